@@ -0,0 +1,222 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// AnnotationNamespace is the NIP-32 namespace of the annotations; a REQ filter
+// with it in "#L" opts into annotations about the pubkeys the filter names
+const AnnotationNamespace = "purplepag.es/annotations"
+
+// Annotation labels, one per analytics table they come from
+const (
+	AnnotationBotCluster    = "bot-cluster"
+	AnnotationSpam          = "spam"
+	AnnotationImpersonation = "impersonation"
+	AnnotationDuplicate     = "duplicate-profile"
+	AnnotationSharedPicture = "shared-picture"
+)
+
+// Annotator turns what the analytics worker found about pubkeys (bot clusters,
+// spam candidates, impersonators, duplicate profiles) into signed NIP-32
+// labels (kind 1985), served alongside REQ responses to clients that opt in
+type Annotator struct {
+	storage *storage.Storage
+	key     string
+
+	mu       sync.RWMutex
+	byPubkey map[string][]*nostr.Event
+	signed   map[string]*nostr.Event // by annotationKey, reused across loads
+}
+
+func NewAnnotator(store *storage.Storage, key string) *Annotator {
+	return &Annotator{
+		storage:  store,
+		key:      key,
+		byPubkey: make(map[string][]*nostr.Event),
+		signed:   make(map[string]*nostr.Event),
+	}
+}
+
+// StartReload keeps the annotations in sync with the latest analytics runs
+func (a *Annotator) StartReload(ctx context.Context, interval time.Duration) {
+	a.Load(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Load(ctx)
+		}
+	}
+}
+
+type annotation struct {
+	pubkey    string
+	label     string
+	content   string
+	createdAt time.Time
+}
+
+// annotationKey identifies an annotation, so an unchanged one keeps its
+// signature and id from one load to the next
+func annotationKey(n annotation) string {
+	return n.pubkey + "\x00" + n.label + "\x00" + n.content + "\x00" + strconv.FormatInt(n.createdAt.Unix(), 10)
+}
+
+// Load reads the analytics tables and signs an annotation for every finding
+func (a *Annotator) Load(ctx context.Context) {
+	start := time.Now()
+	var found []annotation
+
+	clusters, err := a.storage.GetBotClusters(ctx, 1000)
+	if err != nil {
+		log.Printf("annotations: failed to load bot clusters: %v", err)
+	}
+	exempt, err := a.storage.GetBotClusterExemptions(ctx)
+	if err != nil {
+		log.Printf("annotations: failed to load bot cluster exemptions: %v", err)
+	}
+	for _, c := range clusters {
+		content := fmt.Sprintf("Member of a bot cluster of %d pubkeys", c.Size)
+		for _, pk := range c.Members {
+			if !exempt[pk] {
+				found = append(found, annotation{pk, AnnotationBotCluster, content, c.DetectedAt})
+			}
+		}
+	}
+
+	spam, err := a.storage.GetSpamCandidates(ctx, 100000)
+	if err != nil {
+		log.Printf("annotations: failed to load spam candidates: %v", err)
+	}
+	for _, c := range spam {
+		found = append(found, annotation{c.Pubkey, AnnotationSpam, "Spam candidate: " + c.Reason, c.DetectedAt})
+	}
+
+	impersonators, err := a.storage.GetImpersonationCandidates(ctx, 100000)
+	if err != nil {
+		log.Printf("annotations: failed to load impersonation candidates: %v", err)
+	}
+	for _, c := range impersonators {
+		found = append(found, annotation{c.Pubkey, AnnotationImpersonation, "Likely impersonating " + c.TargetPubkey, c.DetectedAt})
+	}
+
+	// Every group of a run shares its detection time
+	if groups, err := a.storage.GetDuplicateProfileGroups(ctx, 1); err != nil {
+		log.Printf("annotations: failed to load duplicate profile groups: %v", err)
+	} else if len(groups) > 0 {
+		detectedAt := groups[0].DetectedAt
+		for _, basis := range []struct{ basis, label, format string }{
+			{storage.DuplicateBasisContent, AnnotationDuplicate, "One of %d identical profiles"},
+			{storage.DuplicateBasisPicture, AnnotationSharedPicture, "One of %d profiles sharing a picture"},
+		} {
+			sizes, err := a.storage.GetDuplicateProfileSizes(ctx, basis.basis)
+			if err != nil {
+				log.Printf("annotations: failed to load %s duplicates: %v", basis.basis, err)
+				continue
+			}
+			for pk, size := range sizes {
+				found = append(found, annotation{pk, basis.label, fmt.Sprintf(basis.format, size), detectedAt})
+			}
+		}
+	}
+
+	a.mu.RLock()
+	previous := a.signed
+	a.mu.RUnlock()
+
+	byPubkey := make(map[string][]*nostr.Event)
+	signed := make(map[string]*nostr.Event, len(found))
+	for _, n := range found {
+		key := annotationKey(n)
+		evt, ok := previous[key]
+		if !ok {
+			evt = &nostr.Event{
+				Kind:      1985,
+				CreatedAt: nostr.Timestamp(n.createdAt.Unix()),
+				Tags: nostr.Tags{
+					{"L", AnnotationNamespace},
+					{"l", n.label, AnnotationNamespace},
+					{"p", n.pubkey},
+				},
+				Content: n.content,
+			}
+			if err := evt.Sign(a.key); err != nil {
+				log.Printf("annotations: failed to sign annotation: %v", err)
+				return
+			}
+		}
+		if _, dup := signed[key]; dup {
+			continue
+		}
+		signed[key] = evt
+		byPubkey[n.pubkey] = append(byPubkey[n.pubkey], evt)
+	}
+
+	a.mu.Lock()
+	a.byPubkey = byPubkey
+	a.signed = signed
+	a.mu.Unlock()
+
+	log.Printf("annotations: loaded %d annotations about %d pubkeys in %v", len(signed), len(byPubkey), time.Since(start))
+}
+
+// OptsIntoAnnotations reports whether filter asks for annotations, and if so
+// takes the namespace out of its "#L" so the rest of the filter is queried as usual
+func OptsIntoAnnotations(filter *nostr.Filter) bool {
+	values, ok := filter.Tags["L"]
+	if !ok || !slices.Contains(values, AnnotationNamespace) {
+		return false
+	}
+
+	values = slices.DeleteFunc(slices.Clone(values), func(v string) bool { return v == AnnotationNamespace })
+	tags := make(nostr.TagMap, len(filter.Tags))
+	for name, v := range filter.Tags {
+		tags[name] = v
+	}
+	if len(values) == 0 {
+		delete(tags, "L")
+	} else {
+		tags["L"] = values
+	}
+	filter.Tags = tags
+	return true
+}
+
+// Annotations returns the annotations about the pubkeys filter names as
+// authors or in "#p", at most limit
+func (a *Annotator) Annotations(filter nostr.Filter, limit int) []*nostr.Event {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var result []*nostr.Event
+	seen := make(map[string]bool)
+	for _, pubkeys := range [][]string{filter.Authors, filter.Tags["p"]} {
+		for _, pk := range pubkeys {
+			if seen[pk] {
+				continue
+			}
+			seen[pk] = true
+			for _, evt := range a.byPubkey[pk] {
+				if len(result) >= limit {
+					return result
+				}
+				result = append(result, evt)
+			}
+		}
+	}
+	return result
+}
@@ -33,13 +33,17 @@ type DetectedCluster struct {
 }
 
 func (d *ClusterDetector) Detect(ctx context.Context) ([]DetectedCluster, error) {
+	return d.DetectInGraph(ctx, d.buildFollowGraph(ctx))
+}
+
+// DetectInGraph runs cluster detection over an already loaded follow graph
+func (d *ClusterDetector) DetectInGraph(ctx context.Context, graph FollowGraph) ([]DetectedCluster, error) {
 	log.Println("analytics: starting bot cluster detection")
 
 	if err := d.storage.DeactivateBotClusters(ctx); err != nil {
 		log.Printf("analytics: failed to deactivate old clusters: %v", err)
 	}
 
-	graph := d.buildFollowGraph(ctx)
 	if len(graph) < d.minClusterSize {
 		log.Printf("analytics: follow graph too small (%d nodes), skipping", len(graph))
 		return nil, nil
@@ -51,6 +55,12 @@ func (d *ClusterDetector) Detect(ctx context.Context) ([]DetectedCluster, error)
 	log.Printf("analytics: found %d strongly connected components (size >= %d)", len(components), d.minClusterSize)
 
 	clusters := d.filterBotClusters(graph, components)
+
+	exempt, err := d.storage.GetBotClusterExemptions(ctx)
+	if err != nil {
+		log.Printf("analytics: failed to load cluster exemptions: %v", err)
+	}
+	clusters = d.dropExempt(clusters, exempt)
 	log.Printf("analytics: identified %d suspicious bot clusters", len(clusters))
 
 	for _, cluster := range clusters {
@@ -64,9 +74,15 @@ func (d *ClusterDetector) Detect(ctx context.Context) ([]DetectedCluster, error)
 }
 
 func (d *ClusterDetector) buildFollowGraph(ctx context.Context) FollowGraph {
+	return LoadFollowGraph(ctx, d.storage)
+}
+
+// LoadFollowGraph scans the latest contact list of every pubkey once. The hourly
+// analysis shares the result between cluster, trust and community detection.
+func LoadFollowGraph(ctx context.Context, store *storage.Storage) FollowGraph {
 	graph := make(FollowGraph)
 
-	contactLists, err := d.storage.QueryEvents(ctx, nostr.Filter{
+	contactLists, err := store.QueryEvents(ctx, nostr.Filter{
 		Kinds: []int{3},
 	})
 	if err != nil {
@@ -199,6 +215,29 @@ func (d *ClusterDetector) filterBotClusters(graph FollowGraph, components [][]st
 	return clusters
 }
 
+// dropExempt removes pubkeys an operator exempted from the clusters, and the
+// clusters left too small to count
+func (d *ClusterDetector) dropExempt(clusters []DetectedCluster, exempt map[string]bool) []DetectedCluster {
+	if len(exempt) == 0 {
+		return clusters
+	}
+
+	kept := clusters[:0]
+	for _, cluster := range clusters {
+		members := cluster.Members[:0]
+		for _, m := range cluster.Members {
+			if !exempt[m] {
+				members = append(members, m)
+			}
+		}
+		if len(members) >= d.minClusterSize {
+			cluster.Members = members
+			kept = append(kept, cluster)
+		}
+	}
+	return kept
+}
+
 func (d *ClusterDetector) GetFollowGraph(ctx context.Context) FollowGraph {
 	return d.buildFollowGraph(ctx)
 }
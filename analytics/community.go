@@ -5,7 +5,6 @@ import (
 	"log"
 	"sort"
 
-	"github.com/nbd-wtf/go-nostr"
 	"github.com/pablof7z/purplepag.es/storage"
 )
 
@@ -55,10 +54,14 @@ type CommunityGraph struct {
 
 // DetectCommunities runs Louvain algorithm on the follow graph
 func (d *CommunityDetector) DetectCommunities(ctx context.Context) (*CommunityGraph, error) {
+	return d.DetectCommunitiesInGraph(ctx, LoadFollowGraph(ctx, d.storage))
+}
+
+// DetectCommunitiesInGraph runs Louvain over an already loaded follow graph
+func (d *CommunityDetector) DetectCommunitiesInGraph(ctx context.Context, followGraph FollowGraph) (*CommunityGraph, error) {
 	log.Println("community: starting community detection")
 
-	// Build the follow graph
-	graph := d.buildGraph(ctx)
+	graph := d.buildGraph(followGraph)
 	if len(graph.nodes) < 100 {
 		log.Printf("community: graph too small (%d nodes), skipping", len(graph.nodes))
 		return nil, nil
@@ -90,38 +93,7 @@ type louvainGraph struct {
 	edgeCount int
 }
 
-func (d *CommunityDetector) buildGraph(ctx context.Context) *louvainGraph {
-	// Get the follow graph from cluster detector
-	followGraph := make(FollowGraph)
-
-	contactLists, err := d.storage.QueryEvents(ctx, nostr.Filter{
-		Kinds: []int{3},
-	})
-	if err != nil {
-		log.Printf("community: failed to query contact lists: %v", err)
-		return &louvainGraph{nodeIndex: make(map[string]int)}
-	}
-
-	// Keep only latest contact list per pubkey
-	latest := make(map[string]*nostr.Event)
-	for _, evt := range contactLists {
-		if existing, ok := latest[evt.PubKey]; !ok || evt.CreatedAt > existing.CreatedAt {
-			latest[evt.PubKey] = evt
-		}
-	}
-
-	// Build follow graph
-	for author, evt := range latest {
-		if followGraph[author] == nil {
-			followGraph[author] = make(map[string]bool)
-		}
-		for _, tag := range evt.Tags {
-			if len(tag) >= 2 && tag[0] == "p" {
-				followGraph[author][tag[1]] = true
-			}
-		}
-	}
-
+func (d *CommunityDetector) buildGraph(followGraph FollowGraph) *louvainGraph {
 	// Convert to Louvain format - treat as undirected for community detection
 	nodeSet := make(map[string]bool)
 	for from := range followGraph {
@@ -0,0 +1,131 @@
+package analytics
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// Compromise signals. Each is ordinary on its own, but a trusted account
+// showing several within compromiseWindow has most likely changed hands.
+const (
+	SignalProfileChange = "profile_change" // name, picture or nip05 rewritten
+	SignalFollowPurge   = "follow_purge"   // most of a large follow list dropped
+	SignalSpamBurst     = "spam_burst"     // daily event quota exhausted
+)
+
+const (
+	compromiseWindow  = 24 * time.Hour
+	compromiseSignals = 2
+	// A follow list shorter than this can be pruned by hand
+	followPurgeMinFollows = 50
+)
+
+// CheckCompromise compares a trusted author's new profile or contact list with
+// the version it replaced, archived by the event history, and records the
+// compromise signal it shows, if any
+func (t *TrustAnalyzer) CheckCompromise(ctx context.Context, evt *nostr.Event) {
+	if (evt.Kind != 0 && evt.Kind != 3) || !t.IsTrusted(evt.PubKey) {
+		return
+	}
+
+	versions, err := t.storage.GetEventHistory(ctx, evt.PubKey, evt.Kind, 1)
+	if err != nil || len(versions) == 0 || versions[0].CreatedAt >= evt.CreatedAt {
+		return
+	}
+	prev := &versions[0]
+	next := &storage.EventVersion{
+		ID:        evt.ID,
+		PubKey:    evt.PubKey,
+		Kind:      evt.Kind,
+		CreatedAt: evt.CreatedAt,
+		Content:   evt.Content,
+		Tags:      evt.Tags,
+	}
+
+	switch evt.Kind {
+	case 0:
+		if storage.IdentityChanged(prev, next) {
+			t.RecordSignal(ctx, evt.PubKey, SignalProfileChange)
+		}
+	case 3:
+		follows := 0
+		for _, tag := range prev.Tags {
+			if len(tag) >= 2 && tag[0] == "p" {
+				follows++
+			}
+		}
+		removed := len(storage.CalculateContactsDelta(prev, next).Removed)
+		if follows >= followPurgeMinFollows && removed*2 > follows {
+			t.RecordSignal(ctx, evt.PubKey, SignalFollowPurge)
+		}
+	}
+}
+
+// RecordSignal notes a compromise signal from pubkey and revokes its trust
+// once compromiseSignals different ones were seen within compromiseWindow
+func (t *TrustAnalyzer) RecordSignal(ctx context.Context, pubkey, signal string) {
+	if !t.IsTrusted(pubkey) {
+		return
+	}
+
+	now := time.Now()
+	t.signalsMu.Lock()
+	seen := t.signals[pubkey]
+	if seen == nil {
+		seen = make(map[string]time.Time)
+		t.signals[pubkey] = seen
+	}
+	seen[signal] = now
+
+	var recent []string
+	for s, at := range seen {
+		if now.Sub(at) < compromiseWindow {
+			recent = append(recent, s)
+		}
+	}
+	if len(recent) < compromiseSignals {
+		t.signalsMu.Unlock()
+		return
+	}
+	delete(t.signals, pubkey)
+	t.signalsMu.Unlock()
+
+	sort.Strings(recent)
+	t.revoke(ctx, pubkey, recent)
+}
+
+// revoke drops pubkey's trust at once rather than at the next analysis, which
+// won't trust it again before storage.TrustRevocationHold passes
+func (t *TrustAnalyzer) revoke(ctx context.Context, pubkey string, signals []string) {
+	t.mu.Lock()
+	delete(t.trustedSet, pubkey)
+	t.mu.Unlock()
+
+	if err := t.storage.RevokeTrustedPubkey(ctx, pubkey, signals); err != nil {
+		log.Printf("analytics: failed to persist trust revocation of %s: %v", pubkey, err)
+	}
+	log.Printf("analytics: revoked trust in %s on compromise signals: %s", pubkey, strings.Join(signals, ", "))
+}
+
+// expireSignals forgets signals older than compromiseWindow
+func (t *TrustAnalyzer) expireSignals() {
+	now := time.Now()
+	t.signalsMu.Lock()
+	defer t.signalsMu.Unlock()
+	for pubkey, seen := range t.signals {
+		for s, at := range seen {
+			if now.Sub(at) >= compromiseWindow {
+				delete(seen, s)
+			}
+		}
+		if len(seen) == 0 {
+			delete(t.signals, pubkey)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package analytics
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	// DuplicateProfileInterval is how often duplicate profiles are grouped
+	DuplicateProfileInterval = 6 * time.Hour
+	// DuplicateProfileMinGroup is the fewest profiles reported as a group
+	DuplicateProfileMinGroup = 3
+	// DuplicateProfileSpamGroup is the fewest identical profiles that make
+	// their untrusted authors spam candidates
+	DuplicateProfileSpamGroup = 10
+
+	// Only the largest groups of each basis are kept, with a bounded number
+	// of untrusted members each; a purged group's remaining members show up
+	// next run
+	maxDuplicateGroups       = 100
+	maxDuplicateGroupMembers = 2000
+)
+
+// DetectDuplicateProfiles groups the latest profiles of all authors by their
+// exact content and by their picture, and replaces the stored groups with the
+// largest ones. Spam waves often publish the same kind 0 from thousands of keys.
+func DetectDuplicateProfiles(ctx context.Context, store *storage.Storage) error {
+	start := time.Now()
+
+	type profile struct {
+		createdAt nostr.Timestamp
+		content   [sha256.Size]byte
+		picture   string
+	}
+	latest := make(map[string]profile)
+	err := store.ScanEvents(ctx, nostr.Filter{Kinds: []int{0}}, func(evt *nostr.Event) {
+		if existing, ok := latest[evt.PubKey]; ok && evt.CreatedAt <= existing.createdAt {
+			return
+		}
+		var meta struct {
+			Picture string `json:"picture"`
+		}
+		json.Unmarshal([]byte(evt.Content), &meta)
+		latest[evt.PubKey] = profile{
+			createdAt: evt.CreatedAt,
+			content:   sha256.Sum256([]byte(evt.Content)),
+			picture:   strings.TrimSpace(meta.Picture),
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	byContent := make(map[string][]string)
+	byPicture := make(map[string][]string)
+	for pubkey, p := range latest {
+		content := hex.EncodeToString(p.content[:])
+		byContent[content] = append(byContent[content], pubkey)
+		if p.picture != "" {
+			sum := sha256.Sum256([]byte(p.picture))
+			picture := hex.EncodeToString(sum[:])
+			byPicture[picture] = append(byPicture[picture], pubkey)
+		}
+	}
+
+	trusted, err := store.GetTrustedPubkeys(ctx)
+	if err != nil {
+		log.Printf("duplicates: failed to load trusted pubkeys: %v", err)
+	}
+	isTrusted := make(map[string]bool, len(trusted))
+	for _, pk := range trusted {
+		isTrusted[pk] = true
+	}
+
+	now := time.Now()
+	var groups []storage.DuplicateProfileGroup
+	sample := make(map[string]string) // group hash -> a member to show the profile of
+	for basis, byHash := range map[string]map[string][]string{
+		storage.DuplicateBasisContent: byContent,
+		storage.DuplicateBasisPicture: byPicture,
+	} {
+		var found []storage.DuplicateProfileGroup
+		for hash, members := range byHash {
+			if len(members) < DuplicateProfileMinGroup {
+				continue
+			}
+			sort.Strings(members)
+			g := storage.DuplicateProfileGroup{Hash: basis + ":" + hash, Basis: basis, Size: len(members), DetectedAt: now}
+			// Trusted members are only counted, as they are never purged
+			for _, pk := range members {
+				if isTrusted[pk] {
+					g.Trusted++
+				} else if len(g.Members) < maxDuplicateGroupMembers {
+					g.Members = append(g.Members, pk)
+				}
+			}
+			sample[g.Hash] = members[0]
+			found = append(found, g)
+		}
+		sort.Slice(found, func(i, j int) bool {
+			if found[i].Size != found[j].Size {
+				return found[i].Size > found[j].Size
+			}
+			return found[i].Hash < found[j].Hash
+		})
+		if len(found) > maxDuplicateGroups {
+			found = found[:maxDuplicateGroups]
+		}
+		groups = append(groups, found...)
+	}
+
+	for i := range groups {
+		groups[i].Name, groups[i].Picture = duplicateGroupSample(ctx, store, sample[groups[i].Hash])
+	}
+
+	// Thousands of member rows can take longer than a single query may
+	saveCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	if err := store.SaveDuplicateProfileGroups(saveCtx, groups); err != nil {
+		return err
+	}
+
+	log.Printf("duplicates: grouped %d profiles into %d duplicate groups in %v", len(latest), len(groups), time.Since(start))
+	return nil
+}
+
+// duplicateGroupSample is the name and picture of one member of a group
+func duplicateGroupSample(ctx context.Context, store *storage.Storage, pubkey string) (string, string) {
+	events, err := store.QueryEvents(ctx, nostr.Filter{Kinds: []int{0}, Authors: []string{pubkey}, Limit: 1})
+	if err != nil || len(events) == 0 {
+		return "", ""
+	}
+	p, _ := parseImpersonationProfile(events[0])
+	return p.name, p.picture
+}
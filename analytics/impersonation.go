@@ -0,0 +1,301 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// ImpersonationLabelNamespace is the NIP-32 namespace of the labels served for flagged profiles
+const ImpersonationLabelNamespace = "purplepag.es/impersonation"
+
+// ImpersonationDetector flags kind 0 profiles whose name and picture match a
+// profile with at least minTargetFollowers followers, published by a pubkey with
+// at most maxFollowers. The analytics worker runs the detection; the relay loads
+// the flagged set to hide or label those profiles.
+type ImpersonationDetector struct {
+	storage            *storage.Storage
+	minTargetFollowers int
+	maxFollowers       int
+
+	mu       sync.RWMutex
+	flagged  map[string]storage.ImpersonationCandidate
+	labelKey string
+	labels   []*nostr.Event
+}
+
+func NewImpersonationDetector(store *storage.Storage, minTargetFollowers, maxFollowers int) *ImpersonationDetector {
+	return &ImpersonationDetector{
+		storage:            store,
+		minTargetFollowers: minTargetFollowers,
+		maxFollowers:       maxFollowers,
+		flagged:            make(map[string]storage.ImpersonationCandidate),
+	}
+}
+
+type impersonationProfile struct {
+	pubkey  string
+	name    string
+	picture string
+}
+
+func parseImpersonationProfile(evt *nostr.Event) (impersonationProfile, []string) {
+	var meta struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+		Picture     string `json:"picture"`
+	}
+	if err := json.Unmarshal([]byte(evt.Content), &meta); err != nil {
+		return impersonationProfile{}, nil
+	}
+
+	p := impersonationProfile{pubkey: evt.PubKey, name: meta.DisplayName, picture: meta.Picture}
+	if p.name == "" {
+		p.name = meta.Name
+	}
+
+	var keys []string
+	for _, name := range []string{meta.DisplayName, meta.Name} {
+		if key := normalizeProfileName(name); len(key) >= 3 {
+			keys = append(keys, key)
+		}
+	}
+	return p, keys
+}
+
+// DetectInGraph flags impersonators, using the follow graph for follower counts
+func (d *ImpersonationDetector) DetectInGraph(ctx context.Context, graph FollowGraph) ([]storage.ImpersonationCandidate, error) {
+	followers := make(map[string]int)
+	for _, follows := range graph {
+		for pk := range follows {
+			followers[pk]++
+		}
+	}
+
+	var targetPubkeys []string
+	for pk, count := range followers {
+		if count >= d.minTargetFollowers {
+			targetPubkeys = append(targetPubkeys, pk)
+		}
+	}
+	if len(targetPubkeys) == 0 {
+		log.Println("impersonation: no profiles with enough followers to impersonate, skipping")
+		return nil, nil
+	}
+
+	// Index the targets' profiles by normalized name
+	targets := make(map[string][]impersonationProfile)
+	for start := 0; start < len(targetPubkeys); start += 500 {
+		end := min(start+500, len(targetPubkeys))
+		events, err := d.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{0}, Authors: targetPubkeys[start:end]})
+		if err != nil {
+			return nil, err
+		}
+		for _, evt := range events {
+			p, keys := parseImpersonationProfile(evt)
+			if p.picture == "" {
+				continue
+			}
+			for _, key := range keys {
+				targets[key] = append(targets[key], p)
+			}
+		}
+	}
+
+	now := time.Now()
+	found := make(map[string]storage.ImpersonationCandidate)
+	err := d.storage.ScanEvents(ctx, nostr.Filter{Kinds: []int{0}}, func(evt *nostr.Event) {
+		if followers[evt.PubKey] > d.maxFollowers {
+			return
+		}
+		p, keys := parseImpersonationProfile(evt)
+		for _, key := range keys {
+			for _, target := range targets[key] {
+				if target.pubkey == evt.PubKey || !samePicture(p.picture, target.picture) {
+					continue
+				}
+				found[evt.PubKey] = storage.ImpersonationCandidate{
+					Pubkey:          evt.PubKey,
+					TargetPubkey:    target.pubkey,
+					Name:            p.name,
+					Picture:         p.picture,
+					Followers:       followers[evt.PubKey],
+					TargetFollowers: followers[target.pubkey],
+					DetectedAt:      now,
+				}
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep when a profile was first flagged, so its label doesn't change every run
+	previous, err := d.storage.GetImpersonationCandidates(ctx, 100000)
+	if err != nil {
+		log.Printf("impersonation: failed to load previous run: %v", err)
+	}
+	firstFlagged := make(map[string]time.Time, len(previous))
+	for _, c := range previous {
+		firstFlagged[c.Pubkey] = c.DetectedAt
+	}
+
+	candidates := make([]storage.ImpersonationCandidate, 0, len(found))
+	for _, c := range found {
+		if at, ok := firstFlagged[c.Pubkey]; ok {
+			c.DetectedAt = at
+		}
+		candidates = append(candidates, c)
+	}
+	log.Printf("impersonation: flagged %d profiles (checked against %d profiles with %d+ followers)", len(candidates), len(targetPubkeys), d.minTargetFollowers)
+
+	if err := d.storage.SaveImpersonationCandidates(ctx, candidates); err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
+
+// nameFolds maps digits and Cyrillic lookalikes used to dodge exact name matches
+var nameFolds = map[rune]rune{
+	'0': 'o', '1': 'l', '3': 'e', '4': 'a', '5': 's', '7': 't', 'i': 'l', '|': 'l',
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x', 'і': 'l', 'ѕ': 's',
+}
+
+// normalizeProfileName lowercases a name, folds lookalike characters and drops
+// everything but letters, so "Jаck ✓" and "jack" compare equal
+func normalizeProfileName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if folded, ok := nameFolds[r]; ok {
+			r = folded
+		}
+		if unicode.IsLetter(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// samePicture reports whether two picture URLs point at the same image: the
+// same URL, or the same hash-like file name re-hosted elsewhere
+func samePicture(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	if a == b {
+		return true
+	}
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	if ua.Host == ub.Host && ua.Path == ub.Path {
+		return true
+	}
+	base := path.Base(ua.Path)
+	return len(base) >= 16 && base == path.Base(ub.Path)
+}
+
+// SetLabelKey makes the detector sign a NIP-32 label (kind 1985) for every
+// flagged profile with key, served to clients by Labels
+func (d *ImpersonationDetector) SetLabelKey(key string) {
+	d.mu.Lock()
+	d.labelKey = key
+	d.mu.Unlock()
+}
+
+// StartReload keeps the flagged set in sync with the latest detection run
+func (d *ImpersonationDetector) StartReload(ctx context.Context, interval time.Duration) {
+	d.Load(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.Load(ctx)
+		}
+	}
+}
+
+// Load reads the flagged profiles stored by the analytics worker
+func (d *ImpersonationDetector) Load(ctx context.Context) {
+	candidates, err := d.storage.GetImpersonationCandidates(ctx, 100000)
+	if err != nil {
+		log.Printf("impersonation: failed to load flagged profiles: %v", err)
+		return
+	}
+
+	flagged := make(map[string]storage.ImpersonationCandidate, len(candidates))
+	for _, c := range candidates {
+		flagged[c.Pubkey] = c
+	}
+
+	d.mu.RLock()
+	key := d.labelKey
+	d.mu.RUnlock()
+
+	var labels []*nostr.Event
+	if key != "" {
+		labels = make([]*nostr.Event, 0, len(candidates))
+		for _, c := range candidates {
+			label := &nostr.Event{
+				Kind:      1985,
+				CreatedAt: nostr.Timestamp(c.DetectedAt.Unix()),
+				Tags: nostr.Tags{
+					{"L", ImpersonationLabelNamespace},
+					{"l", "impersonation", ImpersonationLabelNamespace},
+					{"p", c.Pubkey},
+				},
+				Content: "Likely impersonating " + c.TargetPubkey,
+			}
+			if err := label.Sign(key); err != nil {
+				log.Printf("impersonation: failed to sign label: %v", err)
+				break
+			}
+			labels = append(labels, label)
+		}
+	}
+
+	d.mu.Lock()
+	d.flagged = flagged
+	d.labels = labels
+	d.mu.Unlock()
+}
+
+func (d *ImpersonationDetector) IsFlagged(pubkey string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.flagged[pubkey]
+	return ok
+}
+
+// Labels returns the signed labels matching filter, at most limit
+func (d *ImpersonationDetector) Labels(filter nostr.Filter, limit int) []*nostr.Event {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var result []*nostr.Event
+	for _, label := range d.labels {
+		if len(result) >= limit {
+			break
+		}
+		if filter.Matches(label) {
+			result = append(result, label)
+		}
+	}
+	return result
+}
@@ -0,0 +1,143 @@
+package analytics
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// Prefetcher reads the profile, contact list and relay list of the pubkeys
+// clients usually look up next, so they are in the database's page cache by the
+// time the REQ arrives. A prefetched pubkey that gets requested before its TTL
+// runs out is a hit, one that doesn't is a miss.
+type Prefetcher struct {
+	mu       sync.Mutex
+	storage  *storage.Storage
+	fanout   int
+	ttl      time.Duration
+	warm     map[string]time.Time // prefetched pubkey -> expiry
+	queue    chan string
+	stopChan chan struct{}
+
+	prefetched int64
+	hits       int64
+	misses     int64
+}
+
+// PrefetchStats counts prefetches since startup
+type PrefetchStats struct {
+	Prefetched int64
+	Hits       int64
+	Misses     int64
+}
+
+func NewPrefetcher(store *storage.Storage, fanout, ttlMinutes int) *Prefetcher {
+	return &Prefetcher{
+		storage:  store,
+		fanout:   fanout,
+		ttl:      time.Duration(ttlMinutes) * time.Minute,
+		warm:     make(map[string]time.Time),
+		queue:    make(chan string, 1000),
+		stopChan: make(chan struct{}),
+	}
+}
+
+func (p *Prefetcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stopChan:
+			return
+		case pubkey := <-p.queue:
+			p.prefetch(ctx, pubkey)
+		case <-ticker.C:
+			p.expire()
+		}
+	}
+}
+
+func (p *Prefetcher) Stop() {
+	close(p.stopChan)
+}
+
+// RecordREQ counts hits for the requested authors and queues their likely-next lookups
+func (p *Prefetcher) RecordREQ(filter nostr.Filter) {
+	if len(filter.Authors) == 0 {
+		return
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	for _, pubkey := range filter.Authors {
+		if expiry, ok := p.warm[pubkey]; ok && now.Before(expiry) {
+			p.hits++
+			delete(p.warm, pubkey)
+		}
+	}
+	p.mu.Unlock()
+
+	// Big author lists are feeds, not someone browsing from profile to profile
+	if len(filter.Authors) > 1 {
+		return
+	}
+
+	select {
+	case p.queue <- filter.Authors[0]:
+	default:
+	}
+}
+
+func (p *Prefetcher) prefetch(ctx context.Context, pubkey string) {
+	next, err := p.storage.GetLikelyNext(ctx, pubkey, p.fanout)
+	if err != nil {
+		log.Printf("analytics: failed to load likely-next lookups: %v", err)
+		return
+	}
+
+	expiry := time.Now().Add(p.ttl)
+	authors := make([]string, 0, len(next))
+	p.mu.Lock()
+	for _, pk := range next {
+		if _, ok := p.warm[pk]; ok {
+			continue
+		}
+		p.warm[pk] = expiry
+		authors = append(authors, pk)
+	}
+	p.prefetched += int64(len(authors))
+	p.mu.Unlock()
+
+	if len(authors) == 0 {
+		return
+	}
+
+	if _, err := p.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{0, 3, 10002}, Authors: authors}); err != nil {
+		log.Printf("analytics: prefetch failed: %v", err)
+	}
+}
+
+func (p *Prefetcher) expire() {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pubkey, expiry := range p.warm {
+		if now.After(expiry) {
+			p.misses++
+			delete(p.warm, pubkey)
+		}
+	}
+}
+
+func (p *Prefetcher) GetStats() PrefetchStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PrefetchStats{Prefetched: p.prefetched, Hits: p.hits, Misses: p.misses}
+}
@@ -0,0 +1,192 @@
+package analytics
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// ScraperDetector looks for per-IP REQ patterns typical of scrapers: floods of
+// single-author REQs and paginated until walks over the whole kind 0 set.
+type ScraperDetector struct {
+	mu                    sync.Mutex
+	storage               *storage.Storage
+	singleAuthorThreshold int
+	windowWalkThreshold   int
+	throttle              bool
+	throttleFor           time.Duration
+	windows               map[string]*ipWindow
+	walks                 map[*khatru.WebSocket]*untilWalk
+	throttled             map[string]time.Time
+	stopChan              chan struct{}
+}
+
+type ipWindow struct {
+	singleAuthor int
+	windowWalks  int
+}
+
+// untilWalk is the last until a connection sent in a kind 0 filter, and
+// which way it moved from the one before
+type untilWalk struct {
+	until     nostr.Timestamp
+	direction int
+}
+
+// step moves the walk to until and reports whether that continued it: until
+// moved, and the same way as the previous step if there was one. Repeating
+// an until, as clients refreshing a feed do, or going back and forth doesn't.
+func (w *untilWalk) step(until nostr.Timestamp) bool {
+	direction := 0
+	switch {
+	case until < w.until:
+		direction = -1
+	case until > w.until:
+		direction = 1
+	}
+	continued := direction != 0 && (w.direction == 0 || w.direction == direction)
+	w.until = until
+	w.direction = direction
+	return continued
+}
+
+func NewScraperDetector(store *storage.Storage, singleAuthorThreshold, windowWalkThreshold int, throttle bool, throttleMinutes int) *ScraperDetector {
+	return &ScraperDetector{
+		storage:               store,
+		singleAuthorThreshold: singleAuthorThreshold,
+		windowWalkThreshold:   windowWalkThreshold,
+		throttle:              throttle,
+		throttleFor:           time.Duration(throttleMinutes) * time.Minute,
+		windows:               make(map[string]*ipWindow),
+		walks:                 make(map[*khatru.WebSocket]*untilWalk),
+		throttled:             make(map[string]time.Time),
+		stopChan:              make(chan struct{}),
+	}
+}
+
+func (d *ScraperDetector) Start(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.stopChan:
+			return
+		case <-ticker.C:
+			d.evaluate(ctx)
+		}
+	}
+}
+
+func (d *ScraperDetector) Stop() {
+	close(d.stopChan)
+}
+
+// RecordFilter classifies a single REQ filter sent on conn from the given IP.
+// A kind 0 filter with an until but no authors or ids counts as a window walk
+// once the connection's until keeps moving the same way from one such filter
+// to the next.
+func (d *ScraperDetector) RecordFilter(ip string, conn *khatru.WebSocket, filter nostr.Filter) {
+	if ip == "" {
+		return
+	}
+
+	singleAuthor := len(filter.Authors) == 1
+	untilFilter := conn != nil && len(filter.Authors) == 0 && len(filter.IDs) == 0 && filter.Until != nil && slices.Contains(filter.Kinds, 0)
+	if !singleAuthor && !untilFilter {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	windowWalk := false
+	if untilFilter {
+		walk := d.walks[conn]
+		if walk == nil {
+			d.walks[conn] = &untilWalk{until: *filter.Until}
+		} else {
+			windowWalk = walk.step(*filter.Until)
+		}
+	}
+	if !singleAuthor && !windowWalk {
+		return
+	}
+
+	w := d.windows[ip]
+	if w == nil {
+		w = &ipWindow{}
+		d.windows[ip] = w
+	}
+	if singleAuthor {
+		w.singleAuthor++
+	}
+	if windowWalk {
+		w.windowWalks++
+	}
+}
+
+// Forget drops what was tracked about conn once it closes
+func (d *ScraperDetector) Forget(conn *khatru.WebSocket) {
+	d.mu.Lock()
+	delete(d.walks, conn)
+	d.mu.Unlock()
+}
+
+// IsThrottled reports whether REQs from this IP should currently be rejected
+func (d *ScraperDetector) IsThrottled(ip string) bool {
+	if !d.throttle {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	until, ok := d.throttled[ip]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(d.throttled, ip)
+		return false
+	}
+	return true
+}
+
+func (d *ScraperDetector) evaluate(ctx context.Context) {
+	d.mu.Lock()
+	windows := d.windows
+	d.windows = make(map[string]*ipWindow)
+	d.mu.Unlock()
+
+	for ip, w := range windows {
+		if w.singleAuthor >= d.singleAuthorThreshold {
+			d.flag(ctx, ip, "single_author_flood", w.singleAuthor)
+		}
+		if w.windowWalks >= d.windowWalkThreshold {
+			d.flag(ctx, ip, "window_walk", w.windowWalks)
+		}
+	}
+}
+
+func (d *ScraperDetector) flag(ctx context.Context, ip, reason string, perMinute int) {
+	log.Printf("analytics: scraper candidate %s (%s, %d/min)", ip, reason, perMinute)
+
+	if err := d.storage.SaveScraperCandidate(ctx, ip, reason, int64(perMinute)); err != nil {
+		log.Printf("analytics: failed to save scraper candidate: %v", err)
+	}
+
+	if d.throttle {
+		d.mu.Lock()
+		d.throttled[ip] = time.Now().Add(d.throttleFor)
+		d.mu.Unlock()
+	}
+}
@@ -0,0 +1,62 @@
+package analytics
+
+import (
+	"testing"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRecordFilterWindowWalks(t *testing.T) {
+	until := func(ts nostr.Timestamp, kinds ...int) nostr.Filter {
+		return nostr.Filter{Kinds: kinds, Until: &ts}
+	}
+	walks := func(d *ScraperDetector, ip string) int {
+		if w := d.windows[ip]; w != nil {
+			return w.windowWalks
+		}
+		return 0
+	}
+
+	d := NewScraperDetector(nil, 1000, 60, false, 0)
+
+	// Paging back through kind 0 on one connection
+	walker := &khatru.WebSocket{}
+	for _, ts := range []nostr.Timestamp{1000, 900, 800, 700} {
+		d.RecordFilter("1.1.1.1", walker, until(ts, 0))
+	}
+	if got := walks(d, "1.1.1.1"); got != 3 {
+		t.Fatalf("expected 3 walk steps, got %d", got)
+	}
+
+	// A feed refreshed with the same until, one going back and forth, and
+	// other kinds aren't walks
+	refresher := &khatru.WebSocket{}
+	for _, ts := range []nostr.Timestamp{1000, 1000, 1000} {
+		d.RecordFilter("2.2.2.2", refresher, until(ts, 0))
+	}
+	wobbler := &khatru.WebSocket{}
+	for _, ts := range []nostr.Timestamp{1000, 900, 1000, 900} {
+		d.RecordFilter("2.2.2.2", wobbler, until(ts, 0))
+	}
+	notes := &khatru.WebSocket{}
+	for _, ts := range []nostr.Timestamp{1000, 900, 800} {
+		d.RecordFilter("2.2.2.2", notes, until(ts, 1))
+	}
+	if got := walks(d, "2.2.2.2"); got != 1 {
+		t.Fatalf("expected only the first wobble step to count, got %d", got)
+	}
+
+	// Connections from one IP don't continue each other's walks
+	a, b := &khatru.WebSocket{}, &khatru.WebSocket{}
+	d.RecordFilter("3.3.3.3", a, until(1000, 0))
+	d.RecordFilter("3.3.3.3", b, until(900, 0))
+	if got := walks(d, "3.3.3.3"); got != 0 {
+		t.Fatalf("expected no walk across connections, got %d", got)
+	}
+
+	d.Forget(walker)
+	if _, ok := d.walks[walker]; ok {
+		t.Fatal("expected the closed connection to be forgotten")
+	}
+}
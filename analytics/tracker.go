@@ -3,6 +3,8 @@ package analytics
 import (
 	"context"
 	"log"
+	"math/rand"
+	"strconv"
 	"sync"
 	"time"
 
@@ -11,16 +13,54 @@ import (
 )
 
 type REQEvent struct {
-	Authors []string
+	IP      string
+	Authors *storage.AuthorSet
 	Kinds   []int
 }
 
+// setRequestKey groups REQs for the same interned author set and kinds. Their
+// per-pubkey counts are expanded once per flush instead of once per REQ.
+type setRequestKey struct {
+	authors *storage.AuthorSet
+	kinds   string
+}
+
+type setRequestCount struct {
+	kinds []int
+	count int64
+}
+
+// A pubkey looked up within interestWindow of an earlier lookup from the same IP
+// is recorded as an interest edge from the earlier one
+const (
+	interestWindow     = 5 * time.Minute
+	interestRecentSize = 10
+)
+
+// A filter records at most cooccurrenceMaxPairs co-occurrence pairs. Filters naming
+// more authors than that allows (follow-list fetches of hundreds of authors) pair up
+// a random sample of them, so no fixed subset of a large list dominates the counts.
+const cooccurrenceMaxPairs = 100
+
+var cooccurrenceSampleSize = maxPairAuthors(cooccurrenceMaxPairs)
+
+// CooccurrenceDecayInterval is how often stored co-occurrence counts are halved
+const CooccurrenceDecayInterval = 7 * 24 * time.Hour
+
+type recentLookup struct {
+	pubkey string
+	at     time.Time
+}
+
 type Tracker struct {
 	mu             sync.RWMutex
 	storage        *storage.Storage
 	pubkeyRequests map[string]int64
 	pubkeyByKind   map[string]map[int]int64
+	setRequests    map[setRequestKey]*setRequestCount
 	cooccurrence   map[string]int64
+	interest       map[storage.InterestEdge]int64
+	recentByIP     map[string][]recentLookup
 	reqChan        chan REQEvent
 	stopChan       chan struct{}
 	flushInterval  time.Duration
@@ -31,7 +71,10 @@ func NewTracker(store *storage.Storage) *Tracker {
 		storage:        store,
 		pubkeyRequests: make(map[string]int64),
 		pubkeyByKind:   make(map[string]map[int]int64),
+		setRequests:    make(map[setRequestKey]*setRequestCount),
 		cooccurrence:   make(map[string]int64),
+		interest:       make(map[storage.InterestEdge]int64),
+		recentByIP:     make(map[string][]recentLookup),
 		reqChan:        make(chan REQEvent, 10000),
 		stopChan:       make(chan struct{}),
 		flushInterval:  30 * time.Second,
@@ -47,13 +90,14 @@ func (t *Tracker) Stop() {
 	close(t.stopChan)
 }
 
-func (t *Tracker) RecordREQ(filter nostr.Filter) {
+// RecordREQ counts a filter's authors, interned by the caller as authors
+func (t *Tracker) RecordREQ(ip string, filter nostr.Filter, authors *storage.AuthorSet) {
 	if len(filter.Authors) == 0 {
 		return
 	}
 
 	select {
-	case t.reqChan <- REQEvent{Authors: filter.Authors, Kinds: filter.Kinds}:
+	case t.reqChan <- REQEvent{IP: ip, Authors: authors, Kinds: filter.Kinds}:
 	default:
 	}
 }
@@ -75,25 +119,18 @@ func (t *Tracker) processEvent(evt REQEvent) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	for _, pubkey := range evt.Authors {
-		t.pubkeyRequests[pubkey]++
-
-		if len(evt.Kinds) > 0 {
-			if t.pubkeyByKind[pubkey] == nil {
-				t.pubkeyByKind[pubkey] = make(map[int]int64)
-			}
-			for _, kind := range evt.Kinds {
-				t.pubkeyByKind[pubkey][kind]++
-			}
+	if evt.Authors.Interned() {
+		key := setRequestKey{authors: evt.Authors, kinds: kindsKey(evt.Kinds)}
+		if sr, ok := t.setRequests[key]; ok {
+			sr.count++
+		} else {
+			t.setRequests[key] = &setRequestCount{kinds: evt.Kinds, count: 1}
 		}
+	} else {
+		countRequests(t.pubkeyRequests, t.pubkeyByKind, evt.Authors.Authors, evt.Kinds, 1)
 	}
 
-	// Track co-occurrences, but limit to first 20 authors to avoid O(n²) explosion
-	maxPairAuthors := 20
-	authorsForPairs := evt.Authors
-	if len(authorsForPairs) > maxPairAuthors {
-		authorsForPairs = authorsForPairs[:maxPairAuthors]
-	}
+	authorsForPairs := sampleAuthors(evt.Authors.Authors, cooccurrenceSampleSize)
 	if len(authorsForPairs) >= 2 {
 		for i := 0; i < len(authorsForPairs); i++ {
 			for j := i + 1; j < len(authorsForPairs); j++ {
@@ -102,6 +139,85 @@ func (t *Tracker) processEvent(evt REQEvent) {
 			}
 		}
 	}
+
+	t.recordInterest(evt.IP, authorsForPairs)
+}
+
+func countRequests(pubkeyRequests map[string]int64, pubkeyByKind map[string]map[int]int64, authors []string, kinds []int, n int64) {
+	for _, pubkey := range authors {
+		pubkeyRequests[pubkey] += n
+
+		if len(kinds) > 0 {
+			if pubkeyByKind[pubkey] == nil {
+				pubkeyByKind[pubkey] = make(map[int]int64)
+			}
+			for _, kind := range kinds {
+				pubkeyByKind[pubkey][kind] += n
+			}
+		}
+	}
+}
+
+func kindsKey(kinds []int) string {
+	var b []byte
+	for _, kind := range kinds {
+		b = strconv.AppendInt(b, int64(kind), 10)
+		b = append(b, ',')
+	}
+	return string(b)
+}
+
+// recordInterest links the pubkeys this IP looked up recently to the ones it asks for now
+func (t *Tracker) recordInterest(ip string, authors []string) {
+	if ip == "" {
+		return
+	}
+
+	now := time.Now()
+	current := make(map[string]bool, len(authors))
+	for _, pubkey := range authors {
+		current[pubkey] = true
+	}
+
+	recent := t.recentByIP[ip]
+	for _, prev := range recent {
+		if now.Sub(prev.at) > interestWindow || current[prev.pubkey] {
+			continue
+		}
+		for _, pubkey := range authors {
+			t.interest[storage.InterestEdge{From: prev.pubkey, To: pubkey}]++
+		}
+	}
+
+	for _, pubkey := range authors {
+		recent = append(recent, recentLookup{pubkey: pubkey, at: now})
+	}
+	if len(recent) > interestRecentSize {
+		recent = recent[len(recent)-interestRecentSize:]
+	}
+	t.recentByIP[ip] = recent
+}
+
+// maxPairAuthors is the most authors whose pairs fit in maxPairs
+func maxPairAuthors(maxPairs int) int {
+	n := 2
+	for (n+1)*n/2 <= maxPairs {
+		n++
+	}
+	return n
+}
+
+// sampleAuthors returns authors, or a random sample of n of them if there are more
+func sampleAuthors(authors []string, n int) []string {
+	if len(authors) <= n {
+		return authors
+	}
+	sample := append([]string(nil), authors...)
+	for i := 0; i < n; i++ {
+		j := i + rand.Intn(len(sample)-i)
+		sample[i], sample[j] = sample[j], sample[i]
+	}
+	return sample[:n]
 }
 
 func makePairKey(a, b string) string {
@@ -133,18 +249,34 @@ func (t *Tracker) flush(ctx context.Context) {
 	t.mu.Lock()
 	pubkeyRequests := t.pubkeyRequests
 	pubkeyByKind := t.pubkeyByKind
+	setRequests := t.setRequests
 	cooccurrence := t.cooccurrence
+	interest := t.interest
 
 	t.pubkeyRequests = make(map[string]int64)
 	t.pubkeyByKind = make(map[string]map[int]int64)
+	t.setRequests = make(map[setRequestKey]*setRequestCount)
 	t.cooccurrence = make(map[string]int64)
+	t.interest = make(map[storage.InterestEdge]int64)
+
+	// Forget IPs that have gone quiet
+	now := time.Now()
+	for ip, recent := range t.recentByIP {
+		if len(recent) == 0 || now.Sub(recent[len(recent)-1].at) > interestWindow {
+			delete(t.recentByIP, ip)
+		}
+	}
 	t.mu.Unlock()
 
+	for key, sr := range setRequests {
+		countRequests(pubkeyRequests, pubkeyByKind, key.authors.Authors, sr.kinds, sr.count)
+	}
+
 	if len(pubkeyRequests) == 0 && len(cooccurrence) == 0 {
 		return
 	}
 
-	err := t.storage.FlushREQAnalytics(ctx, pubkeyRequests, pubkeyByKind, cooccurrence)
+	err := t.storage.FlushREQAnalytics(ctx, pubkeyRequests, pubkeyByKind, cooccurrence, interest)
 	if err != nil {
 		log.Printf("analytics: failed to flush REQ stats: %v", err)
 	}
@@ -0,0 +1,51 @@
+package analytics
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// benchmarkAuthors is a follow list's worth of authors, as large REQs send
+func benchmarkAuthors(n int) []string {
+	authors := make([]string, n)
+	for i := range authors {
+		authors[i] = fmt.Sprintf("%064x", i)
+	}
+	return authors
+}
+
+// BenchmarkCountInternedSet is what a repeated 500-author REQ costs the
+// tracker: interning the list and counting the set once
+func BenchmarkCountInternedSet(b *testing.B) {
+	store := &storage.Storage{}
+	authors := benchmarkAuthors(500)
+	kinds := []int{0, 3, 10002}
+	setRequests := make(map[setRequestKey]*setRequestCount)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := setRequestKey{authors: store.InternAuthors(authors), kinds: kindsKey(kinds)}
+		if sr, ok := setRequests[key]; ok {
+			sr.count++
+		} else {
+			setRequests[key] = &setRequestCount{kinds: kinds, count: 1}
+		}
+	}
+}
+
+// BenchmarkCountPerPubkey is the per-pubkey counting interning replaces
+func BenchmarkCountPerPubkey(b *testing.B) {
+	authors := benchmarkAuthors(500)
+	kinds := []int{0, 3, 10002}
+	pubkeyRequests := make(map[string]int64)
+	pubkeyByKind := make(map[string]map[int]int64)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		countRequests(pubkeyRequests, pubkeyByKind, authors, kinds, 1)
+	}
+}
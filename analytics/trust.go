@@ -2,9 +2,12 @@ package analytics
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"sync"
+	"time"
 
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/pablof7z/purplepag.es/storage"
 )
 
@@ -12,8 +15,24 @@ type TrustAnalyzer struct {
 	mu                  sync.RWMutex
 	storage             *storage.Storage
 	clusterDetector     *ClusterDetector
-	trustedSet          map[string]bool
+	trustedSet          map[string]time.Time // when each pubkey's trust was last confirmed
 	minTrustedFollowers int
+	// Accounts changing name/picture/nip05 more often than this per day are
+	// treated as likely impersonation bots and never trusted
+	maxProfileChangesPerDay int
+	// Untrusted pubkeys whose weighted reports reach this are spam candidates
+	minReportScore float64
+
+	// Incremental updates between full analyses
+	candidates   chan string
+	queueMu      sync.Mutex
+	queued       map[string]bool
+	lastChecked  map[string]time.Time
+	recheckAfter time.Duration
+
+	// Compromise signals seen per trusted pubkey, by signal
+	signalsMu sync.Mutex
+	signals   map[string]map[string]time.Time
 }
 
 func NewTrustAnalyzer(store *storage.Storage, clusterDetector *ClusterDetector, minTrustedFollowers int) *TrustAnalyzer {
@@ -21,77 +40,114 @@ func NewTrustAnalyzer(store *storage.Storage, clusterDetector *ClusterDetector,
 		minTrustedFollowers = 10
 	}
 	t := &TrustAnalyzer{
-		storage:             store,
-		clusterDetector:     clusterDetector,
-		trustedSet:          make(map[string]bool),
-		minTrustedFollowers: minTrustedFollowers,
+		storage:                 store,
+		clusterDetector:         clusterDetector,
+		trustedSet:              make(map[string]time.Time),
+		minTrustedFollowers:     minTrustedFollowers,
+		maxProfileChangesPerDay: 5,
+		minReportScore:          3,
+		candidates:              make(chan string, 10000),
+		queued:                  make(map[string]bool),
+		lastChecked:             make(map[string]time.Time),
+		recheckAfter:            10 * time.Minute,
+		signals:                 make(map[string]map[string]time.Time),
 	}
 
 	// Load trusted pubkeys from database on startup
-	ctx := context.Background()
-	pubkeys, err := store.GetTrustedPubkeys(ctx)
-	if err != nil {
+	if n, err := t.Load(context.Background()); err != nil {
 		log.Printf("analytics: failed to load trusted pubkeys from database: %v", err)
-	} else if len(pubkeys) > 0 {
-		for _, pk := range pubkeys {
-			t.trustedSet[pk] = true
-		}
-		log.Printf("analytics: loaded %d trusted pubkeys from database", len(pubkeys))
+	} else if n > 0 {
+		log.Printf("analytics: loaded %d trusted pubkeys from database", n)
 	}
 
 	return t
 }
 
+// Load replaces the trusted set with the pubkeys whose trust, as stored by the
+// analytics worker, hasn't decayed
+func (t *TrustAnalyzer) Load(ctx context.Context) (int, error) {
+	confirmed, err := t.storage.GetTrustedPubkeysConfirmed(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if confirmed == nil {
+		confirmed = make(map[string]time.Time)
+	}
+
+	t.mu.Lock()
+	t.trustedSet = confirmed
+	t.mu.Unlock()
+	return len(confirmed), nil
+}
+
+// StartReload keeps the trusted set in sync with the analytics worker's and
+// prunes decayed trust, so confirmations, decay and revocations made in other
+// processes take effect here
+func (t *TrustAnalyzer) StartReload(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if pruned, err := t.storage.PruneDecayedTrust(ctx); err != nil {
+				log.Printf("analytics: failed to prune decayed trust: %v", err)
+			} else if pruned > 0 {
+				log.Printf("analytics: trust of %d pubkeys decayed without re-confirmation", pruned)
+			}
+			if _, err := t.Load(ctx); err != nil {
+				log.Printf("analytics: failed to reload trusted pubkeys: %v", err)
+			}
+			t.expireSignals()
+		}
+	}
+}
+
+// SetMinReportScore sets the weighted report score that makes an untrusted pubkey a spam candidate
+func (t *TrustAnalyzer) SetMinReportScore(score float64) {
+	if score > 0 {
+		t.minReportScore = score
+	}
+}
+
 func (t *TrustAnalyzer) AnalyzeTrust(ctx context.Context) error {
+	return t.AnalyzeTrustInGraph(ctx, t.clusterDetector.GetFollowGraph(ctx))
+}
+
+// AnalyzeTrustInGraph runs trust propagation over an already loaded follow graph
+func (t *TrustAnalyzer) AnalyzeTrustInGraph(ctx context.Context, graph FollowGraph) error {
 	log.Println("analytics: starting trust analysis")
 
 	if err := t.storage.ClearSpamCandidates(ctx); err != nil {
 		log.Printf("analytics: failed to clear spam candidates: %v", err)
 	}
 
-	graph := t.clusterDetector.GetFollowGraph(ctx)
 	if len(graph) == 0 {
 		log.Println("analytics: no follow graph data available")
 		return nil
 	}
 
-	trusted := t.findLargestConnectedComponent(graph)
-	log.Printf("analytics: seed trusted set from largest component: %d pubkeys", len(trusted))
-
-	// Trust propagation via follow graph:
-	// A pubkey becomes trusted if >= minTrustedFollowers trusted users follow it
-	changed := true
-	iterations := 0
-	for changed && iterations < 100 {
-		changed = false
-		iterations++
-
-		// Count trusted followers for each non-trusted pubkey
-		trustedFollowerCount := make(map[string]int)
-		for follower, following := range graph {
-			if !trusted[follower] {
-				continue
-			}
-			for followed := range following {
-				if !trusted[followed] {
-					trustedFollowerCount[followed]++
-				}
-			}
-		}
-
-		// Promote pubkeys with enough trusted followers
-		for pubkey, count := range trustedFollowerCount {
-			if count >= t.minTrustedFollowers {
-				trusted[pubkey] = true
-				changed = true
-			}
-		}
+	// Profile churn: revoke trust from accounts rapidly rewriting their identity
+	velocity, err := t.storage.ComputeProfileChangeVelocity(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		log.Printf("analytics: failed to compute profile change velocity: %v", err)
+	} else if err := t.storage.SaveProfileChangeVelocity(ctx, velocity); err != nil {
+		log.Printf("analytics: failed to save profile change velocity: %v", err)
+	}
+	if _, err := t.storage.PruneProfileChanges(ctx, time.Now().Add(-48*time.Hour)); err != nil {
+		log.Printf("analytics: failed to prune profile changes: %v", err)
 	}
 
-	log.Printf("analytics: trust propagation complete after %d iterations, %d trusted pubkeys", iterations, len(trusted))
+	trusted, churners := t.computeTrustedSet(ctx, graph, t.minTrustedFollowers, velocity)
 
+	confirmedAt := time.Now()
+	trustedSet := make(map[string]time.Time, len(trusted))
+	for pk := range trusted {
+		trustedSet[pk] = confirmedAt
+	}
 	t.mu.Lock()
-	t.trustedSet = trusted
+	t.trustedSet = trustedSet
 	t.mu.Unlock()
 
 	// Persist trusted pubkeys to database for use by other components (e.g., event archiving)
@@ -125,6 +181,45 @@ func (t *TrustAnalyzer) AnalyzeTrust(ctx context.Context) error {
 		}
 	}
 
+	for _, pubkey := range churners {
+		eventCount, _ := t.storage.CountEventsForPubkey(ctx, pubkey)
+		if err := t.storage.SaveSpamCandidate(ctx, pubkey, "profile_churn", eventCount); err != nil {
+			log.Printf("analytics: failed to save spam candidate: %v", err)
+		}
+		spamCount++
+	}
+
+	reported, err := t.storage.GetReportSummaries(ctx, t.minReportScore, 10000)
+	if err != nil {
+		log.Printf("analytics: failed to get abuse reports: %v", err)
+	}
+	for _, r := range reported {
+		if trusted[r.Pubkey] {
+			continue
+		}
+		eventCount, _ := t.storage.CountEventsForPubkey(ctx, r.Pubkey)
+		if err := t.storage.SaveSpamCandidate(ctx, r.Pubkey, "reported", eventCount); err != nil {
+			log.Printf("analytics: failed to save spam candidate: %v", err)
+		}
+		spamCount++
+	}
+
+	// Many keys publishing the very same profile is a spam wave
+	duplicates, err := t.storage.GetDuplicateProfileSizes(ctx, storage.DuplicateBasisContent)
+	if err != nil {
+		log.Printf("analytics: failed to get duplicate profiles: %v", err)
+	}
+	for pubkey, size := range duplicates {
+		if size < DuplicateProfileSpamGroup || trusted[pubkey] {
+			continue
+		}
+		eventCount, _ := t.storage.CountEventsForPubkey(ctx, pubkey)
+		if err := t.storage.SaveSpamCandidate(ctx, pubkey, fmt.Sprintf("%d identical profiles", size), eventCount); err != nil {
+			log.Printf("analytics: failed to save spam candidate: %v", err)
+		}
+		spamCount++
+	}
+
 	// Check for pubkeys that have events but were never requested
 	reqData, err := t.storage.GetAllRequestedPubkeys(ctx)
 	if err != nil {
@@ -155,6 +250,69 @@ func (t *TrustAnalyzer) AnalyzeTrust(ctx context.Context) error {
 	return nil
 }
 
+// computeTrustedSet seeds trust with the largest connected component of the
+// follow graph and propagates it to every pubkey at least minTrustedFollowers
+// trusted pubkeys follow. Profile churners and pubkeys under a revocation hold
+// are left out; the churners are returned as well.
+func (t *TrustAnalyzer) computeTrustedSet(ctx context.Context, graph FollowGraph, minTrustedFollowers int, velocity map[string]int) (map[string]bool, []string) {
+	trusted := t.findLargestConnectedComponent(graph)
+	log.Printf("analytics: seed trusted set from largest component: %d pubkeys", len(trusted))
+
+	// Trust propagation via follow graph:
+	// A pubkey becomes trusted if >= minTrustedFollowers trusted users follow it
+	changed := true
+	iterations := 0
+	for changed && iterations < 100 {
+		changed = false
+		iterations++
+
+		// Count trusted followers for each non-trusted pubkey
+		trustedFollowerCount := make(map[string]int)
+		for follower, following := range graph {
+			if !trusted[follower] {
+				continue
+			}
+			for followed := range following {
+				if !trusted[followed] {
+					trustedFollowerCount[followed]++
+				}
+			}
+		}
+
+		// Promote pubkeys with enough trusted followers
+		for pubkey, count := range trustedFollowerCount {
+			if count >= minTrustedFollowers {
+				trusted[pubkey] = true
+				changed = true
+			}
+		}
+	}
+
+	log.Printf("analytics: trust propagation complete after %d iterations, %d trusted pubkeys", iterations, len(trusted))
+
+	var churners []string
+	for pubkey, changes := range velocity {
+		if changes > t.maxProfileChangesPerDay {
+			delete(trusted, pubkey)
+			churners = append(churners, pubkey)
+		}
+	}
+	if len(churners) > 0 {
+		log.Printf("analytics: %d pubkeys exceed %d profile changes/day", len(churners), t.maxProfileChangesPerDay)
+	}
+
+	// Revoked accounts stay out until the hold expires, however well followed
+	revoked, err := t.storage.GetRevokedPubkeys(ctx, time.Now().Add(-storage.TrustRevocationHold))
+	if err != nil {
+		log.Printf("analytics: failed to get trust revocations: %v", err)
+	}
+	for pubkey := range revoked {
+		delete(trusted, pubkey)
+	}
+
+	return trusted, churners
+}
+
 func (t *TrustAnalyzer) findLargestConnectedComponent(graph FollowGraph) map[string]bool {
 	allNodes := make(map[string]bool)
 	for node := range graph {
@@ -219,24 +377,32 @@ func (t *TrustAnalyzer) getAllPubkeysWithEvents(graph FollowGraph) map[string]bo
 	return pubkeys
 }
 
+// isTrustedLocked reports whether pubkey's trust was confirmed within
+// storage.TrustDecayAfter; callers hold t.mu
+func (t *TrustAnalyzer) isTrustedLocked(pubkey string, now time.Time) bool {
+	at, ok := t.trustedSet[pubkey]
+	return ok && now.Sub(at) < storage.TrustDecayAfter
+}
+
 func (t *TrustAnalyzer) IsTrusted(pubkey string) bool {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
-	return t.trustedSet[pubkey]
+	return t.isTrustedLocked(pubkey, time.Now())
 }
 
 func (t *TrustAnalyzer) GetTrustedCount() int {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-	return len(t.trustedSet)
+	return len(t.GetTrustedPubkeys())
 }
 
 func (t *TrustAnalyzer) GetTrustedPubkeys() []string {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
+	now := time.Now()
 	pubkeys := make([]string, 0, len(t.trustedSet))
 	for pk := range t.trustedSet {
-		pubkeys = append(pubkeys, pk)
+		if t.isTrustedLocked(pk, now) {
+			pubkeys = append(pubkeys, pk)
+		}
 	}
 	return pubkeys
 }
@@ -255,12 +421,97 @@ func (t *TrustAnalyzer) GetTrustedFollowerCount(ctx context.Context, pubkey stri
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
+	now := time.Now()
 	count := 0
 	for _, follower := range followers {
-		if t.trustedSet[follower] {
+		if t.isTrustedLocked(follower, now) {
 			count++
 		}
 	}
 
 	return count, nil
 }
+
+// OnContactListSaved queues every untrusted pubkey followed by a trusted author
+// for a trust check, so new legit users don't wait for the hourly analysis
+func (t *TrustAnalyzer) OnContactListSaved(evt *nostr.Event) {
+	if evt.Kind != 3 || !t.IsTrusted(evt.PubKey) {
+		return
+	}
+
+	now := time.Now()
+
+	t.queueMu.Lock()
+	defer t.queueMu.Unlock()
+
+	for _, tag := range evt.Tags {
+		if len(tag) < 2 || tag[0] != "p" {
+			continue
+		}
+		pubkey := tag[1]
+		if t.queued[pubkey] || t.IsTrusted(pubkey) || now.Sub(t.lastChecked[pubkey]) < t.recheckAfter {
+			continue
+		}
+
+		select {
+		case t.candidates <- pubkey:
+			t.queued[pubkey] = true
+		default:
+			// Queue full, the hourly analysis will pick it up
+			return
+		}
+	}
+}
+
+// StartIncremental processes pubkeys queued by OnContactListSaved until ctx is done
+func (t *TrustAnalyzer) StartIncremental(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case pubkey := <-t.candidates:
+			t.checkCandidate(ctx, pubkey)
+		}
+	}
+}
+
+func (t *TrustAnalyzer) checkCandidate(ctx context.Context, pubkey string) {
+	t.queueMu.Lock()
+	delete(t.queued, pubkey)
+	t.lastChecked[pubkey] = time.Now()
+	for pk, at := range t.lastChecked {
+		if time.Since(at) > t.recheckAfter {
+			delete(t.lastChecked, pk)
+		}
+	}
+	t.queueMu.Unlock()
+
+	if t.IsTrusted(pubkey) {
+		return
+	}
+
+	count, err := t.GetTrustedFollowerCount(ctx, pubkey)
+	if err != nil {
+		log.Printf("analytics: failed to count trusted followers for %s: %v", pubkey, err)
+		return
+	}
+	if count < t.minTrustedFollowers {
+		return
+	}
+
+	if inCluster, _ := t.storage.IsPubkeyInBotCluster(ctx, pubkey); inCluster {
+		return
+	}
+	if revoked, _ := t.storage.IsTrustRevoked(ctx, pubkey); revoked {
+		return
+	}
+
+	t.mu.Lock()
+	t.trustedSet[pubkey] = time.Now()
+	t.mu.Unlock()
+
+	if err := t.storage.AddTrustedPubkey(ctx, pubkey); err != nil {
+		log.Printf("analytics: failed to persist trusted pubkey: %v", err)
+	}
+	log.Printf("analytics: %s trusted incrementally (%d trusted followers)", pubkey, count)
+}
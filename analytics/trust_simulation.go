@@ -0,0 +1,104 @@
+package analytics
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// TrustSimulation compares the trusted set a different follower threshold
+// would produce with the one currently stored
+type TrustSimulation struct {
+	MinTrustedFollowers int
+	Current             int // trusted now
+	Simulated           int // trusted under the simulated threshold
+	Kept                int // trusted under both
+	Added               []string
+	// Dropped are currently trusted pubkeys the threshold would leave out,
+	// fewest trusted followers first
+	Dropped []DroppedTrust
+}
+
+// DroppedTrust is a currently trusted pubkey with how many simulated trusted
+// pubkeys follow it
+type DroppedTrust struct {
+	Pubkey           string
+	TrustedFollowers int
+}
+
+// Overlap is the share of pubkeys trusted under either set that both trust, in percent
+func (s *TrustSimulation) Overlap() float64 {
+	union := s.Current + s.Simulated - s.Kept
+	if union == 0 {
+		return 100
+	}
+	return float64(s.Kept) / float64(union) * 100
+}
+
+// KeptShare is the share of currently trusted pubkeys that stay trusted, in percent
+func (s *TrustSimulation) KeptShare() float64 {
+	if s.Current == 0 {
+		return 100
+	}
+	return float64(s.Kept) / float64(s.Current) * 100
+}
+
+// SimulateTrust runs trust propagation over graph with minTrustedFollowers
+// instead of the configured threshold, without storing anything
+func (t *TrustAnalyzer) SimulateTrust(ctx context.Context, graph FollowGraph, minTrustedFollowers int) (*TrustSimulation, error) {
+	velocity, err := t.storage.ComputeProfileChangeVelocity(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	simulated, _ := t.computeTrustedSet(ctx, graph, minTrustedFollowers, velocity)
+
+	t.mu.RLock()
+	current := make(map[string]bool, len(t.trustedSet))
+	for pk := range t.trustedSet {
+		current[pk] = true
+	}
+	t.mu.RUnlock()
+
+	result := &TrustSimulation{
+		MinTrustedFollowers: minTrustedFollowers,
+		Current:             len(current),
+		Simulated:           len(simulated),
+	}
+	dropped := make(map[string]int)
+	for pk := range current {
+		if simulated[pk] {
+			result.Kept++
+		} else {
+			dropped[pk] = 0
+		}
+	}
+	for pk := range simulated {
+		if !current[pk] {
+			result.Added = append(result.Added, pk)
+		}
+	}
+	sort.Strings(result.Added)
+
+	for follower, following := range graph {
+		if !simulated[follower] {
+			continue
+		}
+		for followed := range following {
+			if n, ok := dropped[followed]; ok {
+				dropped[followed] = n + 1
+			}
+		}
+	}
+	for pk, n := range dropped {
+		result.Dropped = append(result.Dropped, DroppedTrust{Pubkey: pk, TrustedFollowers: n})
+	}
+	sort.Slice(result.Dropped, func(i, j int) bool {
+		a, b := result.Dropped[i], result.Dropped[j]
+		if a.TrustedFollowers != b.TrustedFollowers {
+			return a.TrustedFollowers < b.TrustedFollowers
+		}
+		return a.Pubkey < b.Pubkey
+	})
+
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// Handler serves the JSON API under /api/v1
+type Handler struct {
+	storage  *storage.Storage
+	rankings *Rankings
+}
+
+func NewHandler(store *storage.Storage, rankings *Rankings) *Handler {
+	return &Handler{storage: store, rankings: rankings}
+}
+
+// ProfileBundle is everything a client needs to render a user: their latest
+// profile, contact list and relay list plus our derived counts
+type ProfileBundle struct {
+	Pubkey                string       `json:"pubkey"`
+	Profile               *nostr.Event `json:"profile"`
+	Contacts              *nostr.Event `json:"contacts"`
+	Relays                *nostr.Event `json:"relays"`
+	FollowerCount         int64        `json:"follower_count"`
+	VerifiedFollowerCount int64        `json:"verified_follower_count"`
+	// At is set when the bundle was reconstructed as of a past time; follower counts stay current
+	At int64 `json:"at,omitempty"`
+	// MigratedFrom is set when the requested pubkey moved to Pubkey through a key migration
+	MigratedFrom string `json:"migrated_from,omitempty"`
+	MigratedAt   int64  `json:"migrated_at,omitempty"`
+}
+
+func (h *Handler) HandleProfile(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.PathValue("pubkey")
+	if !nostr.IsValid32ByteHex(pubkey) {
+		writeError(w, http.StatusBadRequest, "invalid pubkey")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var latest map[int]*nostr.Event
+	var at int64
+	var migration *storage.KeyMigration
+	if atParam := r.URL.Query().Get("at"); atParam != "" {
+		var err error
+		at, err = strconv.ParseInt(atParam, 10, 64)
+		if err != nil || at <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid at timestamp")
+			return
+		}
+		latest, err = h.eventsAt(ctx, pubkey, nostr.Timestamp(at))
+		if err != nil {
+			writeStorageError(w, err, "failed to query events")
+			return
+		}
+	} else {
+		// A key that migrated is served as the key it moved to
+		var err error
+		migration, err = h.storage.ResolveKeyMigration(ctx, pubkey)
+		if err != nil {
+			writeStorageError(w, err, "failed to resolve key migration")
+			return
+		}
+		if migration != nil {
+			pubkey = migration.NewPubkey
+		}
+
+		events, err := h.storage.QueryEvents(ctx, nostr.Filter{
+			Kinds:   []int{0, 3, 10002},
+			Authors: []string{pubkey},
+		})
+		if err != nil {
+			writeStorageError(w, err, "failed to query events")
+			return
+		}
+
+		latest = make(map[int]*nostr.Event)
+		for _, evt := range events {
+			if existing, ok := latest[evt.Kind]; !ok || evt.CreatedAt > existing.CreatedAt {
+				latest[evt.Kind] = evt
+			}
+		}
+	}
+
+	bundle := ProfileBundle{
+		Pubkey:   pubkey,
+		Profile:  latest[0],
+		Contacts: latest[3],
+		Relays:   latest[10002],
+		At:       at,
+	}
+	if migration != nil {
+		bundle.MigratedFrom = migration.OldPubkey
+		bundle.MigratedAt = migration.MigratedAt.Unix()
+	}
+	bundle.FollowerCount, _ = h.storage.GetFollowerCount(ctx, pubkey)
+	bundle.VerifiedFollowerCount, _ = h.storage.GetVerifiedFollowerCount(ctx, pubkey)
+
+	writeJSON(w, http.StatusOK, bundle)
+}
+
+// eventsAt reconstructs the pubkey's kind 0, 3 and 10002 as they stood at the given time
+func (h *Handler) eventsAt(ctx context.Context, pubkey string, at nostr.Timestamp) (map[int]*nostr.Event, error) {
+	latest := make(map[int]*nostr.Event)
+	for _, kind := range []int{0, 3, 10002} {
+		evt, err := h.storage.GetEventAt(ctx, pubkey, kind, at)
+		if err != nil {
+			return nil, err
+		}
+		if evt != nil {
+			latest[kind] = evt
+		}
+	}
+	return latest, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeStorageError answers 503 with Retry-After for transient storage failures
+// and 500 for anything else
+func writeStorageError(w http.ResponseWriter, err error, message string) {
+	serr := storage.ClassifyError(err)
+	if serr == nil {
+		writeError(w, http.StatusInternalServerError, message)
+		return
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(serr.RetryAfter.Seconds())))
+	writeError(w, http.StatusServiceUnavailable, serr.Error())
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/relay"
+)
+
+const (
+	// broadcastDefaultRelays and broadcastMaxRelays bound ?relays=
+	broadcastDefaultRelays = 20
+	broadcastMaxRelays     = 50
+	// broadcastCooldown is how often one pubkey can have its events re-broadcast
+	broadcastCooldown = 10 * time.Minute
+	// broadcastRecentSync is how recently a relay must have synced to count as healthy
+	broadcastRecentSync = 7 * 24 * time.Hour
+	// Relays scoring below broadcastMinIntegrity once they've delivered
+	// broadcastIntegritySample events are not broadcast to
+	broadcastMinIntegrity    = 50
+	broadcastIntegritySample = 100
+)
+
+// broadcastKinds are the events a broadcast re-publishes
+var broadcastKinds = []int{0, 3, 10002}
+
+// BroadcastEventResult is one relay's OK for one event
+type BroadcastEventResult struct {
+	ID      string `json:"id"`
+	Kind    int    `json:"kind"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// BroadcastRelayResult is what one relay made of a broadcast
+type BroadcastRelayResult struct {
+	URL    string                 `json:"url"`
+	Error  string                 `json:"error,omitempty"`
+	Events []BroadcastEventResult `json:"events"`
+}
+
+// HandleBroadcast serves POST /api/v1/vault/broadcast?relays=N: re-publishes the
+// authenticated user's latest profile, contact list and relay list to the N
+// healthiest discovered relays and returns every relay's OK results
+func (v *ContactVault) HandleBroadcast(w http.ResponseWriter, r *http.Request) {
+	pubkey, ok := v.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	n := broadcastDefaultRelays
+	if raw := r.URL.Query().Get("relays"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > broadcastMaxRelays {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("relays must be between 1 and %d", broadcastMaxRelays))
+			return
+		}
+		n = parsed
+	}
+
+	if wait := v.claimBroadcast(pubkey); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		writeError(w, http.StatusTooManyRequests, "events were broadcast recently; try again later")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	events, err := v.storage.QueryEvents(ctx, nostr.Filter{Kinds: broadcastKinds, Authors: []string{pubkey}, Limit: len(broadcastKinds)})
+	if err != nil {
+		v.releaseBroadcast(pubkey)
+		writeStorageError(w, err, "failed to load events")
+		return
+	}
+	if len(events) == 0 {
+		v.releaseBroadcast(pubkey)
+		writeError(w, http.StatusNotFound, "no profile, contact list or relay list stored for this pubkey")
+		return
+	}
+
+	urls, err := v.healthyRelays(ctx, n)
+	if err != nil {
+		v.releaseBroadcast(pubkey)
+		writeStorageError(w, err, "failed to pick relays")
+		return
+	}
+	cancel()
+
+	broadcast := relay.Broadcast(r.Context(), urls, events)
+
+	results := make([]BroadcastRelayResult, len(broadcast))
+	accepted := 0
+	for i, b := range broadcast {
+		results[i] = BroadcastRelayResult{URL: b.URL, Error: b.Error, Events: []BroadcastEventResult{}}
+		all := b.Error == ""
+		for _, p := range b.Results {
+			results[i].Events = append(results[i].Events, BroadcastEventResult{ID: p.EventID, Kind: p.Kind, OK: p.OK, Message: p.Message})
+			all = all && p.OK
+		}
+		if all {
+			accepted++
+		}
+	}
+
+	ids := make([]string, len(events))
+	for i, evt := range events {
+		ids[i] = evt.ID
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"pubkey":   pubkey,
+		"events":   ids,
+		"relays":   results,
+		"accepted": accepted, // relays that accepted every event
+	})
+}
+
+// healthyRelays picks the n best discovered relays, leaving out those whose
+// events have proven unreliable
+func (v *ContactVault) healthyRelays(ctx context.Context, n int) ([]string, error) {
+	candidates, err := v.storage.GetHealthyRelays(ctx, time.Now().Add(-broadcastRecentSync), n*2)
+	if err != nil {
+		return nil, err
+	}
+	integrity, err := v.storage.GetRelayIntegrity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, 0, n)
+	for _, url := range candidates {
+		if r, ok := integrity[url]; ok && r.Delivered+r.InvalidSig >= broadcastIntegritySample && r.Score() < broadcastMinIntegrity {
+			continue
+		}
+		urls = append(urls, url)
+		if len(urls) == n {
+			break
+		}
+	}
+	return urls, nil
+}
+
+// claimBroadcast starts pubkey's cooldown, or returns how long is left of it
+func (v *ContactVault) claimBroadcast(pubkey string) time.Duration {
+	v.broadcastMu.Lock()
+	defer v.broadcastMu.Unlock()
+
+	now := time.Now()
+	if last, ok := v.broadcasts[pubkey]; ok && now.Sub(last) < broadcastCooldown {
+		return broadcastCooldown - now.Sub(last)
+	}
+	for pk, last := range v.broadcasts {
+		if now.Sub(last) >= broadcastCooldown {
+			delete(v.broadcasts, pk)
+		}
+	}
+	v.broadcasts[pubkey] = now
+	return 0
+}
+
+// releaseBroadcast lifts the cooldown of a broadcast that never went out
+func (v *ContactVault) releaseBroadcast(pubkey string) {
+	v.broadcastMu.Lock()
+	defer v.broadcastMu.Unlock()
+	delete(v.broadcasts, pubkey)
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"net/http"
+)
+
+// HandleRelayCensus serves the relay software census as JSON
+func (h *Handler) HandleRelayCensus(w http.ResponseWriter, r *http.Request) {
+	census, err := h.storage.GetRelayCensus(r.Context())
+	if err != nil {
+		writeStorageError(w, err, "failed to load relay census")
+		return
+	}
+	if census == nil {
+		writeError(w, http.StatusServiceUnavailable, "relay census unavailable")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, census)
+}
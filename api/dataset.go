@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/kinds"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// DatasetRefreshInterval is how often the dataset statistics are recomputed
+const DatasetRefreshInterval = time.Hour
+
+// Histogram bucket lower bounds; each bucket runs up to the next bound, the
+// last one is open ended
+var (
+	followCountBuckets   = []int{0, 1, 10, 50, 100, 250, 500, 1000, 5000}
+	relayListSizeBuckets = []int{0, 1, 2, 3, 4, 5, 10, 20}
+)
+
+// KindTotal is how many events of a kind are stored
+type KindTotal struct {
+	Kind   int    `json:"kind"`
+	Name   string `json:"name,omitempty"`
+	Events int64  `json:"events"`
+}
+
+// HistogramBucket counts the authors whose list size falls in [Min, Max];
+// Max is null for the last, open ended bucket
+type HistogramBucket struct {
+	Label   string `json:"label"`
+	Min     int    `json:"min"`
+	Max     *int   `json:"max"`
+	Pubkeys int64  `json:"pubkeys"`
+}
+
+// DatasetStats are aggregate statistics about the stored events, for citing
+// the dataset without access to the events themselves
+type DatasetStats struct {
+	GeneratedAt   int64       `json:"generated_at"`
+	Events        int64       `json:"events"`
+	UniquePubkeys int64       `json:"unique_pubkeys"`
+	Kinds         []KindTotal `json:"kinds"`
+	Profiles      int64       `json:"profiles"`
+	// Nip05Percent is the share of profiles naming a NIP-05 identifier
+	Nip05Percent float64 `json:"nip05_percent"`
+	ContactLists int64   `json:"contact_lists"`
+	// FollowCounts is how many pubkeys each contact list follows
+	FollowCounts []HistogramBucket `json:"follow_counts"`
+	RelayLists   int64             `json:"relay_lists"`
+	// RelayListSizes is how many relays each relay list names
+	RelayListSizes []HistogramBucket `json:"relay_list_sizes"`
+}
+
+// Dataset keeps the dataset statistics, recomputed on a schedule since they
+// take a pass over every profile, contact list and relay list
+type Dataset struct {
+	storage *storage.Storage
+
+	mu    sync.RWMutex
+	stats *DatasetStats
+}
+
+func NewDataset(store *storage.Storage) *Dataset {
+	return &Dataset{storage: store}
+}
+
+// Refresh recomputes the statistics
+func (d *Dataset) Refresh(ctx context.Context) error {
+	start := time.Now()
+
+	counts, err := d.storage.GetEventCountsByKind(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count events: %w", err)
+	}
+	stats := &DatasetStats{Kinds: make([]KindTotal, 0, len(counts))}
+	for kind, count := range counts {
+		stats.Events += count
+		name, _ := kinds.Lookup(kind)
+		stats.Kinds = append(stats.Kinds, KindTotal{Kind: kind, Name: name, Events: count})
+	}
+	sort.Slice(stats.Kinds, func(i, j int) bool { return stats.Kinds[i].Kind < stats.Kinds[j].Kind })
+
+	stats.UniquePubkeys, err = d.storage.CountUniquePubkeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count pubkeys: %w", err)
+	}
+
+	// Only each author's newest list counts, in case older copies linger
+	type latest struct {
+		createdAt nostr.Timestamp
+		size      int // tags in the list; for profiles, 1 if it names a NIP-05
+	}
+	profiles := make(map[string]latest)
+	contacts := make(map[string]latest)
+	relayLists := make(map[string]latest)
+	keep := func(m map[string]latest, evt *nostr.Event, size int) {
+		if existing, ok := m[evt.PubKey]; !ok || evt.CreatedAt > existing.createdAt {
+			m[evt.PubKey] = latest{evt.CreatedAt, size}
+		}
+	}
+	err = d.storage.ScanEvents(ctx, nostr.Filter{Kinds: []int{0, 3, 10002}}, func(evt *nostr.Event) {
+		switch evt.Kind {
+		case 0:
+			var metadata map[string]any
+			json.Unmarshal([]byte(evt.Content), &metadata)
+			nip05, _ := metadata["nip05"].(string)
+			hasNip05 := 0
+			if strings.TrimSpace(nip05) != "" {
+				hasNip05 = 1
+			}
+			keep(profiles, evt, hasNip05)
+		case 3:
+			keep(contacts, evt, countTags(evt.Tags, "p"))
+		case 10002:
+			keep(relayLists, evt, countTags(evt.Tags, "r"))
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan lists: %w", err)
+	}
+
+	var withNip05 int64
+	for _, p := range profiles {
+		withNip05 += int64(p.size)
+	}
+	stats.Profiles = int64(len(profiles))
+	if stats.Profiles > 0 {
+		stats.Nip05Percent = math.Round(float64(withNip05)*10000/float64(stats.Profiles)) / 100
+	}
+
+	followCounts := make([]int, 0, len(contacts))
+	for _, c := range contacts {
+		followCounts = append(followCounts, c.size)
+	}
+	stats.ContactLists = int64(len(contacts))
+	stats.FollowCounts = histogram(followCountBuckets, followCounts)
+
+	relayListSizes := make([]int, 0, len(relayLists))
+	for _, r := range relayLists {
+		relayListSizes = append(relayListSizes, r.size)
+	}
+	stats.RelayLists = int64(len(relayLists))
+	stats.RelayListSizes = histogram(relayListSizeBuckets, relayListSizes)
+
+	stats.GeneratedAt = time.Now().Unix()
+	d.mu.Lock()
+	d.stats = stats
+	d.mu.Unlock()
+
+	log.Printf("Dataset: computed statistics over %d events from %d pubkeys in %v", stats.Events, stats.UniquePubkeys, time.Since(start))
+	return nil
+}
+
+// HandleDataset serves GET /api/v1/dataset
+func (d *Dataset) HandleDataset(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	stats := d.stats
+	d.mu.RUnlock()
+	if stats == nil {
+		w.Header().Set("Retry-After", "60")
+		writeError(w, http.StatusServiceUnavailable, "dataset statistics are still being computed")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// countTags counts the tags of a name with a non-empty value, each value once
+func countTags(tags nostr.Tags, name string) int {
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		if len(tag) >= 2 && tag[0] == name && tag[1] != "" {
+			seen[tag[1]] = true
+		}
+	}
+	return len(seen)
+}
+
+// histogram counts the values falling in each bucket of bounds
+func histogram(bounds []int, values []int) []HistogramBucket {
+	buckets := make([]HistogramBucket, len(bounds))
+	for i, lower := range bounds {
+		buckets[i] = HistogramBucket{Min: lower, Label: strconv.Itoa(lower) + "+"}
+		if i < len(bounds)-1 {
+			upper := bounds[i+1] - 1
+			buckets[i].Max = &upper
+			buckets[i].Label = strconv.Itoa(lower)
+			if upper > lower {
+				buckets[i].Label += "-" + strconv.Itoa(upper)
+			}
+		}
+	}
+	for _, v := range values {
+		// The last bound not above v
+		i := sort.Search(len(bounds), func(i int) bool { return bounds[i] > v }) - 1
+		if i >= 0 {
+			buckets[i].Pubkeys++
+		}
+	}
+	return buckets
+}
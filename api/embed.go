@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
+)
+
+const (
+	// embedCacheTTL bounds how stale an embedded card or badge can be; browsers
+	// and CDNs are told to cache for as long
+	embedCacheTTL  = 10 * time.Minute
+	embedCacheSize = 10000
+)
+
+// EmbedCard is what the embeddable profile card and follower badge show
+type EmbedCard struct {
+	Pubkey        string `json:"pubkey"`
+	Npub          string `json:"npub"`
+	Name          string `json:"name,omitempty"`
+	DisplayName   string `json:"display_name,omitempty"`
+	Picture       string `json:"picture,omitempty"`
+	About         string `json:"about,omitempty"`
+	Nip05         string `json:"nip05,omitempty"`
+	FollowerCount int64  `json:"follower_count"`
+	ProfileURL    string `json:"profile_url"`
+}
+
+type cachedCard struct {
+	card      EmbedCard
+	fetchedAt time.Time
+}
+
+// Embeds serves widgets authors put on their own sites: a profile card as an
+// iframe or JSON, and a follower-count badge. Cards are cached in memory.
+type Embeds struct {
+	storage *storage.Storage
+
+	mu    sync.Mutex
+	cache map[string]cachedCard
+}
+
+func NewEmbeds(store *storage.Storage) *Embeds {
+	return &Embeds{storage: store, cache: make(map[string]cachedCard)}
+}
+
+func (e *Embeds) card(ctx context.Context, pubkey string) (EmbedCard, error) {
+	e.mu.Lock()
+	cached, ok := e.cache[pubkey]
+	e.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < embedCacheTTL {
+		return cached.card, nil
+	}
+
+	// A key that migrated shows the key it moved to
+	resolved := pubkey
+	if migration, err := e.storage.ResolveKeyMigration(ctx, pubkey); err != nil {
+		return EmbedCard{}, err
+	} else if migration != nil {
+		resolved = migration.NewPubkey
+	}
+
+	card := EmbedCard{Pubkey: resolved, ProfileURL: "/profile?pubkey=" + resolved}
+	card.Npub, _ = nip19.EncodePublicKey(resolved)
+
+	events, err := e.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{0}, Authors: []string{resolved}})
+	if err != nil {
+		return EmbedCard{}, err
+	}
+	var latest *nostr.Event
+	for _, evt := range events {
+		if latest == nil || evt.CreatedAt > latest.CreatedAt {
+			latest = evt
+		}
+	}
+	if latest != nil {
+		var meta struct {
+			Name        string `json:"name"`
+			DisplayName string `json:"display_name"`
+			Picture     string `json:"picture"`
+			About       string `json:"about"`
+			Nip05       string `json:"nip05"`
+		}
+		if json.Unmarshal([]byte(latest.Content), &meta) == nil {
+			card.Name = meta.Name
+			card.DisplayName = meta.DisplayName
+			card.Picture = meta.Picture
+			card.About = meta.About
+			card.Nip05 = meta.Nip05
+			if len(card.About) > 200 {
+				card.About = card.About[:200] + "..."
+			}
+		}
+	}
+
+	card.FollowerCount, err = e.storage.GetFollowerCount(ctx, resolved)
+	if err != nil {
+		return EmbedCard{}, err
+	}
+
+	e.mu.Lock()
+	if len(e.cache) >= embedCacheSize {
+		for pk, c := range e.cache {
+			if time.Since(c.fetchedAt) >= embedCacheTTL {
+				delete(e.cache, pk)
+			}
+		}
+		if len(e.cache) >= embedCacheSize {
+			e.cache = make(map[string]cachedCard)
+		}
+	}
+	e.cache[pubkey] = cachedCard{card: card, fetchedAt: time.Now()}
+	e.mu.Unlock()
+
+	return card, nil
+}
+
+func (e *Embeds) lookup(w http.ResponseWriter, r *http.Request, pubkey string) (EmbedCard, bool) {
+	if !nostr.IsValid32ByteHex(pubkey) {
+		writeError(w, http.StatusBadRequest, "invalid pubkey")
+		return EmbedCard{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	card, err := e.card(ctx, pubkey)
+	if err != nil {
+		writeStorageError(w, err, "failed to load profile")
+		return EmbedCard{}, false
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(embedCacheTTL.Seconds())))
+	return card, true
+}
+
+// HandleCardJSON serves GET /api/v1/embed/{pubkey}
+func (e *Embeds) HandleCardJSON(w http.ResponseWriter, r *http.Request) {
+	card, ok := e.lookup(w, r, r.PathValue("pubkey"))
+	if !ok {
+		return
+	}
+	card.ProfileURL = requestOrigin(r) + card.ProfileURL
+	writeJSON(w, http.StatusOK, card)
+}
+
+// HandleCard serves GET /embed/profile/{pubkey}, a profile card meant for an iframe
+func (e *Embeds) HandleCard(w http.ResponseWriter, r *http.Request) {
+	card, ok := e.lookup(w, r, r.PathValue("pubkey"))
+	if !ok {
+		return
+	}
+
+	tmpl, err := templates.Get("embed_profile", nil)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		EmbedCard
+		Followers string
+	}{EmbedCard: card, Followers: compactCount(card.FollowerCount)}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+	tmpl.Execute(w, data)
+}
+
+// HandleFollowerBadge serves GET /badge/followers/{pubkey}.svg, a shields.io-style badge
+func (e *Embeds) HandleFollowerBadge(w http.ResponseWriter, r *http.Request) {
+	pubkey, isSVG := strings.CutSuffix(r.PathValue("file"), ".svg")
+	if !isSVG {
+		http.NotFound(w, r)
+		return
+	}
+	card, ok := e.lookup(w, r, pubkey)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write([]byte(followerBadge("followers", compactCount(card.FollowerCount))))
+}
+
+// followerBadge renders a flat two-part badge. Widths are estimated from an
+// average glyph width, as shields.io does for Verdana 11px.
+func followerBadge(label, value string) string {
+	labelWidth := 10 + 7*len(label)
+	valueWidth := 10 + 7*len(value)
+	width := labelWidth + valueWidth
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[2]s: %[3]s">
+<title>%[2]s: %[3]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[4]d" height="20" fill="#555"/><rect x="%[4]d" width="%[5]d" height="20" fill="#8b5cf6"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[6]d" y="15" fill="#010101" fill-opacity=".3">%[2]s</text><text x="%[6]d" y="14">%[2]s</text>
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[3]s</text><text x="%[7]d" y="14">%[3]s</text>
+</g>
+</svg>`, width, label, value, labelWidth, valueWidth, labelWidth/2, labelWidth+valueWidth/2)
+}
+
+// compactCount formats a count the way badges do: 950, 1.2k, 3.4M
+func compactCount(n int64) string {
+	switch {
+	case n >= 1_000_000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/1_000_000), ".0") + "M"
+	case n >= 1_000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/1_000), ".0") + "k"
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
+
+func requestOrigin(r *http.Request) string {
+	scheme := "https"
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	} else if r.TLS == nil {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host
+}
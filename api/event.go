@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// EventProvenance is how an event first reached the relay
+type EventProvenance struct {
+	Source     string `json:"source"`
+	Relay      string `json:"relay,omitempty"`
+	ReceivedAt int64  `json:"received_at"`
+}
+
+// EventVersion points at another version of a replaceable event
+type EventVersion struct {
+	ID         string           `json:"id"`
+	CreatedAt  int64            `json:"created_at"`
+	Provenance *EventProvenance `json:"provenance"`
+}
+
+// EventInfo answers support questions such as "why is my old contact list
+// still served?": the event, where it came from and whether it was replaced
+type EventInfo struct {
+	Event      *nostr.Event     `json:"event"`
+	Stored     bool             `json:"stored"`
+	ArchivedAt int64            `json:"archived_at,omitempty"`
+	Provenance *EventProvenance `json:"provenance"`
+	// Status is regular, current, superseded (a newer version exists but this one
+	// is still stored and served to filters without a limit) or replaced
+	Status         string        `json:"status"`
+	Newest         *EventVersion `json:"newest,omitempty"`
+	StoredVersions int           `json:"stored_versions,omitempty"`
+}
+
+// EventLookups serves GET /e/{id}
+type EventLookups struct {
+	storage *storage.Storage
+	// public reports whether a kind may be served to anyone
+	public func(kind int) bool
+}
+
+func NewEventLookups(store *storage.Storage, public func(kind int) bool) *EventLookups {
+	return &EventLookups{storage: store, public: public}
+}
+
+func (l *EventLookups) HandleEvent(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !nostr.IsValid32ByteHex(id) {
+		writeError(w, http.StatusBadRequest, "invalid event id")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	lookup, err := l.storage.LookupEvent(ctx, id)
+	if err != nil {
+		writeStorageError(w, err, "failed to look up event")
+		return
+	}
+	// Private kinds look the same as events we don't have
+	if lookup == nil || !l.public(lookup.Event.Kind) {
+		writeError(w, http.StatusNotFound, "event not found")
+		return
+	}
+
+	info := EventInfo{
+		Event:          lookup.Event,
+		Stored:         lookup.Stored,
+		Provenance:     provenanceJSON(lookup.Provenance),
+		Status:         lookup.Status,
+		StoredVersions: lookup.StoredVersions,
+	}
+	if !lookup.Stored {
+		info.ArchivedAt = lookup.ArchivedAt.Unix()
+	}
+	if lookup.Newest != nil {
+		info.Newest = &EventVersion{
+			ID:         lookup.Newest.ID,
+			CreatedAt:  int64(lookup.Newest.CreatedAt),
+			Provenance: provenanceJSON(lookup.NewestProvenance),
+		}
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+func provenanceJSON(p *storage.EventProvenance) *EventProvenance {
+	if p == nil {
+		return nil
+	}
+	return &EventProvenance{Source: p.Source, Relay: p.RelayURL, ReceivedAt: p.ReceivedAt.Unix()}
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	// exportMaxEvents caps the stored and the archived events in an export
+	exportMaxEvents = 5000
+	// exportLikelyNext caps the interest graph neighbours in an export
+	exportLikelyNext = 100
+)
+
+// RequestAnalytics is how often clients asked the relay for a pubkey
+type RequestAnalytics struct {
+	Total       int64         `json:"total"`
+	LastRequest int64         `json:"last_request"`
+	ByKind      map[int]int64 `json:"by_kind"`
+	// Pubkeys clients most often look up right after this one
+	LikelyNext []string `json:"likely_next"`
+}
+
+// ArchivedVersion is a stored event replaced by a newer version
+type ArchivedVersion struct {
+	ID         string     `json:"id"`
+	Kind       int        `json:"kind"`
+	CreatedAt  int64      `json:"created_at"`
+	ArchivedAt int64      `json:"archived_at"`
+	Content    string     `json:"content"`
+	Tags       nostr.Tags `json:"tags"`
+}
+
+// TrustFlags are the conclusions trust and spam analysis hold about a pubkey
+type TrustFlags struct {
+	Trusted          bool               `json:"trusted"`
+	TrustedAt        int64              `json:"trusted_at,omitempty"`
+	TrustRevocations []TrustRevocation  `json:"trust_revocations"`
+	BotClusters      []int64            `json:"bot_clusters"`
+	ClusterExempt    bool               `json:"cluster_exempt"`
+	SpamCandidate    *SpamFlag          `json:"spam_candidate"`
+	Impersonation    *ImpersonationFlag `json:"impersonation"`
+	Reports          map[string]int     `json:"reports"`
+	Hydration        *HydrationFlag     `json:"hydration"`
+	EventsToday      int64              `json:"events_today"`
+}
+
+type TrustRevocation struct {
+	Signals   []string `json:"signals"`
+	RevokedAt int64    `json:"revoked_at"`
+}
+
+type SpamFlag struct {
+	Reason     string `json:"reason"`
+	EventCount int64  `json:"event_count"`
+	DetectedAt int64  `json:"detected_at"`
+	Purged     bool   `json:"purged"`
+}
+
+type ImpersonationFlag struct {
+	TargetPubkey string `json:"target_pubkey"`
+	Name         string `json:"name"`
+	DetectedAt   int64  `json:"detected_at"`
+}
+
+type HydrationFlag struct {
+	Status      string `json:"status"`
+	EmptyRounds int    `json:"empty_rounds"`
+	LastRound   int64  `json:"last_round"`
+}
+
+// DataExport is everything the relay holds about one pubkey
+type DataExport struct {
+	Pubkey      string            `json:"pubkey"`
+	GeneratedAt int64             `json:"generated_at"`
+	Requests    *RequestAnalytics `json:"requests"`
+	Followers   int64             `json:"followers"`
+	Events      []*nostr.Event    `json:"events"`
+	Archived    []ArchivedVersion `json:"archived"`
+	Flags       TrustFlags        `json:"flags"`
+}
+
+// HandleExport serves GET /api/v1/vault/export: a JSON bundle of every
+// analytics record, stored event and archived version the relay holds about
+// the authenticated pubkey, for data access requests
+func (v *ContactVault) HandleExport(w http.ResponseWriter, r *http.Request) {
+	pubkey, ok := v.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	export, err := v.export(ctx, pubkey)
+	if err != nil {
+		writeStorageError(w, err, "failed to gather data")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="purplepages-`+pubkey[:16]+`.json"`)
+	writeJSON(w, http.StatusOK, export)
+}
+
+func (v *ContactVault) export(ctx context.Context, pubkey string) (*DataExport, error) {
+	export := &DataExport{
+		Pubkey:      pubkey,
+		GeneratedAt: time.Now().Unix(),
+		Events:      []*nostr.Event{},
+		Archived:    []ArchivedVersion{},
+	}
+
+	requests, err := v.storage.GetPubkeyAnalytics(ctx, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	if requests != nil {
+		likelyNext, err := v.storage.GetLikelyNext(ctx, pubkey, exportLikelyNext)
+		if err != nil {
+			return nil, err
+		}
+		export.Requests = &RequestAnalytics{
+			Total:       requests.TotalRequests,
+			LastRequest: requests.LastRequest.Unix(),
+			ByKind:      requests.ByKind,
+			LikelyNext:  append([]string{}, likelyNext...),
+		}
+	}
+
+	if export.Followers, err = v.storage.GetFollowerCount(ctx, pubkey); err != nil {
+		return nil, err
+	}
+
+	events, err := v.storage.QueryEvents(ctx, nostr.Filter{Authors: []string{pubkey}, Limit: exportMaxEvents})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Kind < events[j].Kind })
+	export.Events = append(export.Events, events...)
+
+	archived, err := v.storage.GetAllEventHistory(ctx, pubkey, exportMaxEvents)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range archived {
+		export.Archived = append(export.Archived, ArchivedVersion{
+			ID:         a.ID,
+			Kind:       a.Kind,
+			CreatedAt:  int64(a.CreatedAt),
+			ArchivedAt: a.ArchivedAt.Unix(),
+			Content:    a.Content,
+			Tags:       a.Tags,
+		})
+	}
+
+	flags, err := v.storage.GetPubkeyFlags(ctx, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	export.Flags = trustFlags(flags)
+	if export.Flags.EventsToday, err = v.storage.GetPubkeyQuotaUsage(ctx, pubkey); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+func trustFlags(f *storage.PubkeyFlags) TrustFlags {
+	flags := TrustFlags{TrustRevocations: []TrustRevocation{}, BotClusters: []int64{}, Reports: map[string]int{}}
+	if f == nil {
+		return flags
+	}
+
+	flags.Trusted = f.Trusted
+	if f.Trusted {
+		flags.TrustedAt = f.TrustedAt.Unix()
+	}
+	for _, r := range f.TrustRevocations {
+		flags.TrustRevocations = append(flags.TrustRevocations, TrustRevocation{Signals: r.Signals, RevokedAt: r.RevokedAt.Unix()})
+	}
+	flags.BotClusters = append(flags.BotClusters, f.BotClusters...)
+	flags.ClusterExempt = f.ClusterExempt
+	if s := f.SpamCandidate; s != nil {
+		flags.SpamCandidate = &SpamFlag{Reason: s.Reason, EventCount: s.EventCount, DetectedAt: s.DetectedAt.Unix(), Purged: s.Purged}
+	}
+	if i := f.Impersonation; i != nil {
+		flags.Impersonation = &ImpersonationFlag{TargetPubkey: i.TargetPubkey, Name: i.Name, DetectedAt: i.DetectedAt.Unix()}
+	}
+	for reportType, n := range f.Reports {
+		flags.Reports[reportType] = n
+	}
+	if h := f.Hydration; h != nil {
+		flags.Hydration = &HydrationFlag{Status: h.Status, EmptyRounds: h.EmptyRounds, LastRound: h.LastRound.Unix()}
+	}
+	return flags
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	defaultFollowersLimit = 50
+	maxFollowersLimit     = 500
+)
+
+var (
+	// ErrInvalidFollowersCursor is returned by FollowersPage for cursors it didn't issue
+	ErrInvalidFollowersCursor = errors.New("bad cursor")
+	// ErrFollowsIndexNotReady is returned by FollowersPage until the follows table is built
+	ErrFollowsIndexNotReady = errors.New("follows index is still being built")
+)
+
+// RankedFollower is one follower on a followers page
+type RankedFollower struct {
+	Pubkey    string `json:"pubkey"`
+	Trusted   bool   `json:"trusted"`
+	Followers int64  `json:"followers"`
+}
+
+// FollowerPage is one page of a pubkey's followers plus the cursor for the next one
+type FollowerPage struct {
+	Pubkey     string           `json:"pubkey"`
+	Total      int64            `json:"total"`
+	Followers  []RankedFollower `json:"followers"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// FollowersPage returns the followers of pubkey after cursor, trusted followers
+// first, then the most followed. Like ranking cursors, follower cursors encode
+// the position of the last entry rather than an offset.
+func FollowersPage(ctx context.Context, store *storage.Storage, pubkey, cursor string, limit int) (*FollowerPage, error) {
+	var after *storage.Follower
+	if cursor != "" {
+		f, err := decodeFollowerCursor(cursor)
+		if err != nil {
+			return nil, ErrInvalidFollowersCursor
+		}
+		after = &f
+	}
+	if !store.FollowsIndexReady(ctx) {
+		return nil, ErrFollowsIndexNotReady
+	}
+
+	// One more than asked tells whether there is a next page
+	followers, err := store.GetFollowersPage(ctx, pubkey, after, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &FollowerPage{Pubkey: pubkey, Followers: make([]RankedFollower, 0, min(len(followers), limit))}
+	if page.Total, err = store.GetFollowerCount(ctx, pubkey); err != nil {
+		return nil, err
+	}
+	for i, f := range followers {
+		if i == limit {
+			page.NextCursor = encodeFollowerCursor(followers[i-1])
+			break
+		}
+		page.Followers = append(page.Followers, RankedFollower{Pubkey: f.Pubkey, Trusted: f.Trusted, Followers: f.Followers})
+	}
+	return page, nil
+}
+
+func encodeFollowerCursor(f storage.Follower) string {
+	trusted := 0
+	if f.Trusted {
+		trusted = 1
+	}
+	raw := fmt.Sprintf("%d:%d:%s", trusted, f.Followers, f.Pubkey)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeFollowerCursor(cursor string) (storage.Follower, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return storage.Follower{}, err
+	}
+
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 || (parts[0] != "0" && parts[0] != "1") || !nostr.IsValid32ByteHex(parts[2]) {
+		return storage.Follower{}, fmt.Errorf("malformed cursor")
+	}
+
+	f := storage.Follower{Trusted: parts[0] == "1", Pubkey: parts[2]}
+	if f.Followers, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return storage.Follower{}, err
+	}
+	return f, nil
+}
+
+// HandleFollowers serves GET /api/v1/followers/{pubkey}[?cursor=&limit=]
+func (h *Handler) HandleFollowers(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.PathValue("pubkey")
+	if !nostr.IsValid32ByteHex(pubkey) {
+		writeError(w, http.StatusBadRequest, "invalid pubkey")
+		return
+	}
+
+	limit := defaultFollowersLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(l, maxFollowersLimit)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	page, err := FollowersPage(ctx, h.storage, pubkey, r.URL.Query().Get("cursor"), limit)
+	switch {
+	case errors.Is(err, ErrInvalidFollowersCursor):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrFollowsIndexNotReady):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case err != nil:
+		writeStorageError(w, err, "failed to load followers")
+	default:
+		writeJSON(w, http.StatusOK, page)
+	}
+}
@@ -0,0 +1,16 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pablof7z/purplepag.es/kinds"
+)
+
+// HandleKinds serves the display names of kinds, the defaults plus the
+// operator's kind_names
+func (h *Handler) HandleKinds(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kinds": kinds.All(),
+	})
+}
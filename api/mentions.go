@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	mentionsDefaultLimit = 50
+	mentionsMaxLimit     = 200
+	// mentionsMaxOffset keeps deep pages from turning into full index scans
+	mentionsMaxOffset = 1000
+	mentionsMaxQuery  = 200
+)
+
+// ProfileMention is a profile whose name or bio matches a mentions query
+type ProfileMention struct {
+	Pubkey   string   `json:"pubkey"`
+	Name     string   `json:"name,omitempty"`
+	Language string   `json:"language,omitempty"`
+	Hashtags []string `json:"hashtags"`
+	Snippet  string   `json:"snippet,omitempty"`
+	Rank     float64  `json:"rank"`
+}
+
+// HandleMentioning serves GET /api/v1/profiles/mentioning?q=&lang=&limit=&offset=:
+// profiles whose names or bios mention q, best match first. q is a web search
+// style query, so "quoted phrases", or and -exclusions work; a single #hashtag
+// matches the hashtags in bios. lang restricts results to bios in that language.
+func (h *Handler) HandleMentioning(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		writeError(w, http.StatusBadRequest, "missing q")
+		return
+	}
+	if utf8.RuneCountInString(q) > mentionsMaxQuery {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("q is longer than %d characters", mentionsMaxQuery))
+		return
+	}
+
+	language := strings.ToLower(query.Get("lang"))
+	if language != "" && (len(language) != 2 || strings.Trim(language, "abcdefghijklmnopqrstuvwxyz") != "") {
+		writeError(w, http.StatusBadRequest, "lang must be a two-letter language code")
+		return
+	}
+
+	limit := mentionsDefaultLimit
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(parsed, mentionsMaxLimit)
+	}
+	offset := 0
+	if raw := query.Get("offset"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 || parsed > mentionsMaxOffset {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("offset must be between 0 and %d", mentionsMaxOffset))
+			return
+		}
+		offset = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if !h.storage.ProfileSearchReady(ctx) {
+		writeError(w, http.StatusServiceUnavailable, "profile search index is still being built")
+		return
+	}
+
+	mentions, err := h.storage.SearchProfileMentions(ctx, q, language, limit, offset)
+	if err != nil {
+		writeStorageError(w, err, "failed to search profiles")
+		return
+	}
+
+	profiles := make([]ProfileMention, len(mentions))
+	for i, m := range mentions {
+		profiles[i] = ProfileMention{
+			Pubkey:   m.Pubkey,
+			Name:     m.Name,
+			Language: m.Language,
+			Hashtags: m.Hashtags,
+			Snippet:  m.Snippet,
+			Rank:     m.Rank,
+		}
+		if profiles[i].Hashtags == nil {
+			profiles[i].Hashtags = []string{}
+		}
+	}
+
+	result := map[string]interface{}{"query": q, "profiles": profiles}
+	if len(mentions) == limit && offset+limit <= mentionsMaxOffset {
+		result["next_offset"] = offset + limit
+	}
+	writeJSON(w, http.StatusOK, result)
+}
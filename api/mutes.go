@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	// maxListedMuters caps the muters named in one response
+	maxListedMuters = 1000
+	// maxMuteLists caps the mute lists read for one pubkey, newest first
+	maxMuteLists = 5000
+)
+
+// MuteSummary is how a pubkey sits in the mute graph. Who mutes it is only
+// counted unless listing muters is enabled.
+type MuteSummary struct {
+	Pubkey  string `json:"pubkey"`
+	MutedBy int    `json:"muted_by"`
+	// MutedByTruncated is set when more mute lists name the pubkey than are read
+	MutedByTruncated bool     `json:"muted_by_truncated,omitempty"`
+	Muters           []string `json:"muters,omitempty"`
+	Mutes            int      `json:"mutes"`
+	// Mutual counts pubkeys it mutes that mute it back
+	Mutual        int      `json:"mutual"`
+	MutualPubkeys []string `json:"mutual_pubkeys,omitempty"`
+	// MutersFollowing counts muters who still follow it
+	MutersFollowing int `json:"muters_following"`
+	// MutersFollowed counts muters it follows
+	MutersFollowed int `json:"muters_followed"`
+	// MutesFollowed counts pubkeys it both mutes and follows
+	MutesFollowed  int  `json:"mutes_followed"`
+	MutersWithheld bool `json:"muters_withheld"`
+}
+
+// Mutes answers mute graph questions about single pubkeys. Everything it
+// answers comes from mute lists, so it only answers when those are served to
+// everyone; under an author_only or never_serve kind_privacy it would reveal
+// what the policy withholds.
+type Mutes struct {
+	storage    *storage.Storage
+	listMuters bool
+	public     bool
+}
+
+func NewMutes(store *storage.Storage, listMuters, public bool) *Mutes {
+	return &Mutes{storage: store, listMuters: listMuters, public: public}
+}
+
+// HandleMutes serves GET /api/v1/mutes/{pubkey}
+func (m *Mutes) HandleMutes(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.PathValue("pubkey")
+	if !nostr.IsValid32ByteHex(pubkey) {
+		writeError(w, http.StatusBadRequest, "invalid pubkey")
+		return
+	}
+	if !m.public {
+		writeError(w, http.StatusForbidden, "mute lists are not public on this relay")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	summary, err := m.summarize(ctx, pubkey)
+	if err != nil {
+		writeStorageError(w, err, "failed to load mute graph")
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+func (m *Mutes) summarize(ctx context.Context, pubkey string) (*MuteSummary, error) {
+	summary := &MuteSummary{Pubkey: pubkey, MutersWithheld: !m.listMuters}
+
+	lists, err := m.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{10000}, Tags: nostr.TagMap{"p": []string{pubkey}}, Limit: maxMuteLists})
+	if err != nil {
+		return nil, err
+	}
+	summary.MutedByTruncated = len(lists) >= maxMuteLists
+	// Newest mute lists first, so a capped listing keeps the most recent muters
+	sort.Slice(lists, func(i, j int) bool { return lists[i].CreatedAt > lists[j].CreatedAt })
+	mutedBy := make(map[string]bool)
+	var muters []string
+	for _, evt := range lists {
+		if evt.PubKey != pubkey && !mutedBy[evt.PubKey] {
+			mutedBy[evt.PubKey] = true
+			muters = append(muters, evt.PubKey)
+		}
+	}
+	summary.MutedBy = len(muters)
+	if m.listMuters {
+		summary.Muters = muters[:min(len(muters), maxListedMuters)]
+	}
+
+	own, err := m.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{3, 10000}, Authors: []string{pubkey}, Limit: 10})
+	if err != nil {
+		return nil, err
+	}
+	var mutes, follows map[string]bool
+	for _, evt := range latestByKind(own) {
+		switch evt.Kind {
+		case 3:
+			follows = tagPubkeys(evt.Tags)
+		case 10000:
+			mutes = tagPubkeys(evt.Tags)
+		}
+	}
+
+	summary.Mutes = len(mutes)
+	for pk := range mutes {
+		if mutedBy[pk] {
+			summary.Mutual++
+			if m.listMuters {
+				summary.MutualPubkeys = append(summary.MutualPubkeys, pk)
+			}
+		}
+		if follows[pk] {
+			summary.MutesFollowed++
+		}
+	}
+	sort.Strings(summary.MutualPubkeys)
+	for _, pk := range muters {
+		if follows[pk] {
+			summary.MutersFollowed++
+		}
+	}
+
+	if len(muters) > 0 {
+		following, err := m.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{3}, Authors: muters, Tags: nostr.TagMap{"p": []string{pubkey}}, Limit: len(muters)})
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, evt := range following {
+			if !seen[evt.PubKey] {
+				seen[evt.PubKey] = true
+				summary.MutersFollowing++
+			}
+		}
+	}
+
+	return summary, nil
+}
+
+// latestByKind keeps the newest event of each kind
+func latestByKind(events []*nostr.Event) map[int]*nostr.Event {
+	latest := make(map[int]*nostr.Event)
+	for _, evt := range events {
+		if existing, ok := latest[evt.Kind]; !ok || evt.CreatedAt > existing.CreatedAt {
+			latest[evt.Kind] = evt
+		}
+	}
+	return latest
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip05"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// NIP-05 verification states reported for a claim
+const (
+	Nip05Verified   = "verified"
+	Nip05Failed     = "failed"
+	Nip05Unverified = "unverified" // the identifier's domain couldn't be reached
+)
+
+// Nip05Claim is a pubkey whose profile claims a NIP-05 identifier
+type Nip05Claim struct {
+	Pubkey        string       `json:"pubkey"`
+	Status        string       `json:"status"`
+	CheckedAt     int64        `json:"checked_at,omitempty"`
+	FollowerCount int64        `json:"follower_count"`
+	Profile       *nostr.Event `json:"profile"`
+}
+
+// ResolveNip05 returns every stored pubkey claiming the identifier, verified claims first.
+// Claims that haven't been checked recently are verified against the identifier's domain,
+// which answers for all of them with a single request.
+func ResolveNip05(ctx context.Context, store *storage.Storage, identifier string) ([]Nip05Claim, error) {
+	identifier = storage.NormalizeNip05(identifier)
+	events, err := store.GetNip05Claims(ctx, identifier)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+
+	pubkeys := make([]string, len(events))
+	claimed := make(map[string]string, len(events))
+	for i, evt := range events {
+		pubkeys[i] = evt.PubKey
+		var metadata struct {
+			Nip05 string `json:"nip05"`
+		}
+		json.Unmarshal([]byte(evt.Content), &metadata)
+		claimed[evt.PubKey] = metadata.Nip05
+	}
+
+	previous, err := store.GetNip05Verifications(ctx, pubkeys)
+	if err != nil {
+		return nil, err
+	}
+
+	stale := false
+	for _, pk := range pubkeys {
+		prev, ok := previous[pk]
+		if !ok || storage.NormalizeNip05(prev.Identifier) != identifier || time.Since(prev.CheckedAt) >= nip05RecheckAfter {
+			stale = true
+			break
+		}
+	}
+
+	// Whoever the domain names now is the only valid claimant
+	owner, reachable := "", false
+	if stale && nip05.IsValidIdentifier(identifier) {
+		lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		pointer, err := nip05.QueryIdentifier(lookupCtx, identifier)
+		cancel()
+		if err == nil && pointer != nil {
+			owner, reachable = pointer.PublicKey, true
+		}
+	}
+
+	claims := make([]Nip05Claim, 0, len(events))
+	for _, evt := range events {
+		claim := Nip05Claim{Pubkey: evt.PubKey, Status: Nip05Unverified, Profile: evt}
+
+		if reachable {
+			valid := evt.PubKey == owner
+			store.SaveNip05Verification(ctx, evt.PubKey, claimed[evt.PubKey], valid)
+			claim.Status = Nip05Failed
+			if valid {
+				claim.Status = Nip05Verified
+			}
+			claim.CheckedAt = time.Now().Unix()
+		} else if prev, ok := previous[evt.PubKey]; ok && storage.NormalizeNip05(prev.Identifier) == identifier {
+			claim.Status = Nip05Failed
+			if prev.Valid {
+				claim.Status = Nip05Verified
+			}
+			claim.CheckedAt = prev.CheckedAt.Unix()
+		}
+
+		claim.FollowerCount, _ = store.GetFollowerCount(ctx, evt.PubKey)
+		claims = append(claims, claim)
+	}
+
+	sort.SliceStable(claims, func(i, j int) bool {
+		vi, vj := claims[i].Status == Nip05Verified, claims[j].Status == Nip05Verified
+		if vi != vj {
+			return vi
+		}
+		return claims[i].FollowerCount > claims[j].FollowerCount
+	})
+
+	return claims, nil
+}
+
+// HandleNip05 serves GET /api/v1/nip05?name=alice@example.com
+func (h *Handler) HandleNip05(w http.ResponseWriter, r *http.Request) {
+	name := storage.NormalizeNip05(r.URL.Query().Get("name"))
+	if !nip05.IsValidIdentifier(name) {
+		writeError(w, http.StatusBadRequest, "invalid name, expected user@domain")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	claims, err := ResolveNip05(ctx, h.storage, name)
+	if err != nil {
+		writeStorageError(w, err, "failed to look up identifier")
+		return
+	}
+	if claims == nil {
+		claims = []Nip05Claim{}
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Name   string       `json:"name"`
+		Claims []Nip05Claim `json:"claims"`
+	}{Name: name, Claims: claims})
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/relay"
+)
+
+const (
+	defaultOnboardingLimit = 50
+	maxOnboardingLimit     = 200
+	// onboardingMaxFollows caps how many of the pubkey's follows are expanded
+	onboardingMaxFollows = 1000
+)
+
+// SuggestedFollow is a pubkey followed by several of the user's follows
+type SuggestedFollow struct {
+	Pubkey string `json:"pubkey"`
+	// FollowedBy counts the user's follows who follow this pubkey
+	FollowedBy    int   `json:"followed_by"`
+	FollowerCount int64 `json:"follower_count"`
+}
+
+// SuggestedRelay is a relay the user's follows write to
+type SuggestedRelay struct {
+	URL string `json:"url"`
+	// UsedBy counts the user's follows listing it as a write relay
+	UsedBy int `json:"used_by"`
+	// Integrity is our 0-100 score for the relay, omitted if it never delivered to us
+	Integrity *float64 `json:"integrity,omitempty"`
+}
+
+// OnboardingSuggestions is what a client needs to set up a new account from the
+// follows it picked: where those follows publish and whom they follow
+type OnboardingSuggestions struct {
+	Pubkey            string            `json:"pubkey"`
+	FollowsConsidered int               `json:"follows_considered"`
+	Relays            []SuggestedRelay  `json:"relays"`
+	Follows           []SuggestedFollow `json:"follows"`
+}
+
+// HandleOnboarding serves GET /api/v1/onboarding/{pubkey}[?limit=]
+func (h *Handler) HandleOnboarding(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.PathValue("pubkey")
+	if !nostr.IsValid32ByteHex(pubkey) {
+		writeError(w, http.StatusBadRequest, "invalid pubkey")
+		return
+	}
+
+	limit := defaultOnboardingLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(l, maxOnboardingLimit)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	suggestions, err := h.suggestOnboarding(ctx, pubkey, limit)
+	if err != nil {
+		writeStorageError(w, err, "failed to build suggestions")
+		return
+	}
+	if suggestions == nil {
+		writeError(w, http.StatusNotFound, "no contact list for pubkey")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, suggestions)
+}
+
+// suggestOnboarding ranks the relays the pubkey's follows write to and the
+// pubkeys they follow, the same graph profile hydration walks. It returns nil
+// if we hold no contact list for the pubkey.
+func (h *Handler) suggestOnboarding(ctx context.Context, pubkey string, limit int) (*OnboardingSuggestions, error) {
+	lists, err := h.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{3}, Authors: []string{pubkey}, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(lists) == 0 {
+		return nil, nil
+	}
+
+	following := make(map[string]bool)
+	var follows []string
+	for _, tag := range lists[0].Tags {
+		if len(tag) >= 2 && tag[0] == "p" && nostr.IsValid32ByteHex(tag[1]) && !following[tag[1]] {
+			following[tag[1]] = true
+			follows = append(follows, tag[1])
+		}
+	}
+	if len(follows) > onboardingMaxFollows {
+		follows = follows[:onboardingMaxFollows]
+	}
+
+	suggestions := &OnboardingSuggestions{
+		Pubkey:            pubkey,
+		FollowsConsidered: len(follows),
+		Relays:            []SuggestedRelay{},
+		Follows:           []SuggestedFollow{},
+	}
+	if len(follows) == 0 {
+		return suggestions, nil
+	}
+
+	events, err := h.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{3, 10002}, Authors: follows})
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the newest contact and relay list of each follow counts
+	latest := make(map[string]map[int]*nostr.Event)
+	for _, evt := range events {
+		byKind := latest[evt.PubKey]
+		if byKind == nil {
+			byKind = make(map[int]*nostr.Event)
+			latest[evt.PubKey] = byKind
+		}
+		if existing, ok := byKind[evt.Kind]; !ok || evt.CreatedAt > existing.CreatedAt {
+			byKind[evt.Kind] = evt
+		}
+	}
+
+	relayUsers := make(map[string]int)
+	followedBy := make(map[string]int)
+	for _, byKind := range latest {
+		if evt := byKind[10002]; evt != nil {
+			seen := make(map[string]bool)
+			for _, tag := range evt.Tags {
+				// Read-only relays are where they listen, not where they publish
+				if len(tag) < 2 || tag[0] != "r" || (len(tag) >= 3 && tag[2] == "read") {
+					continue
+				}
+				url, err := relay.NormalizeRelayURL(tag[1])
+				if err != nil || seen[url] {
+					continue
+				}
+				seen[url] = true
+				relayUsers[url]++
+			}
+		}
+		if evt := byKind[3]; evt != nil {
+			seen := make(map[string]bool)
+			for _, tag := range evt.Tags {
+				if len(tag) < 2 || tag[0] != "p" || seen[tag[1]] {
+					continue
+				}
+				seen[tag[1]] = true
+				if tag[1] != pubkey && !following[tag[1]] && nostr.IsValid32ByteHex(tag[1]) {
+					followedBy[tag[1]]++
+				}
+			}
+		}
+	}
+
+	// Bot clusters follow each other densely and would crowd out real suggestions
+	bots, err := h.storage.GetBotClusterPubkeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for pk := range bots {
+		delete(followedBy, pk)
+	}
+
+	integrity, err := h.storage.GetRelayIntegrity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for url, users := range relayUsers {
+		s := SuggestedRelay{URL: url, UsedBy: users}
+		if ri, ok := integrity[url]; ok {
+			score := ri.Score()
+			s.Integrity = &score
+		}
+		suggestions.Relays = append(suggestions.Relays, s)
+	}
+	sort.Slice(suggestions.Relays, func(i, j int) bool {
+		a, b := suggestions.Relays[i], suggestions.Relays[j]
+		if a.UsedBy != b.UsedBy {
+			return a.UsedBy > b.UsedBy
+		}
+		return a.URL < b.URL
+	})
+	if len(suggestions.Relays) > limit {
+		suggestions.Relays = suggestions.Relays[:limit]
+	}
+
+	for pk, n := range followedBy {
+		// A pubkey only one follow follows is noise, not a suggestion
+		if n >= 2 {
+			suggestions.Follows = append(suggestions.Follows, SuggestedFollow{Pubkey: pk, FollowedBy: n})
+		}
+	}
+	sort.Slice(suggestions.Follows, func(i, j int) bool {
+		a, b := suggestions.Follows[i], suggestions.Follows[j]
+		if a.FollowedBy != b.FollowedBy {
+			return a.FollowedBy > b.FollowedBy
+		}
+		return a.Pubkey < b.Pubkey
+	})
+	if len(suggestions.Follows) > limit {
+		suggestions.Follows = suggestions.Follows[:limit]
+	}
+	for i := range suggestions.Follows {
+		suggestions.Follows[i].FollowerCount, _ = h.storage.GetFollowerCount(ctx, suggestions.Follows[i].Pubkey)
+	}
+
+	return suggestions, nil
+}
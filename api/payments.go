@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// maxLightningAddressPubkeys caps the pubkeys returned for one lightning address
+const maxLightningAddressPubkeys = 100
+
+// NutzapMint is a cashu mint a user accepts nutzaps from, with the units it
+// accepts there; no units means sats
+type NutzapMint struct {
+	URL   string   `json:"url"`
+	Units []string `json:"units,omitempty"`
+}
+
+// NutzapInfo is a user's NIP-61 nutzap setup, from their kind 10019
+type NutzapInfo struct {
+	Mints  []NutzapMint `json:"mints"`
+	Relays []string     `json:"relays"`
+	// P2PKPubkey is the key nutzaps to the user must be locked to
+	P2PKPubkey string `json:"p2pk_pubkey,omitempty"`
+	UpdatedAt  int64  `json:"updated_at"`
+}
+
+// PaymentEndpoints is every way a user can be paid that they published:
+// lightning addresses from their profile and nutzap mints from their kind 10019
+type PaymentEndpoints struct {
+	Pubkey string `json:"pubkey"`
+	Lud16  string `json:"lud16,omitempty"`
+	Lud06  string `json:"lud06,omitempty"`
+	// ProfileUpdatedAt is when the profile the lightning addresses come from was published
+	ProfileUpdatedAt int64       `json:"profile_updated_at,omitempty"`
+	Nutzap           *NutzapInfo `json:"nutzap"`
+}
+
+// HandlePayments serves GET /api/v1/payments/{pubkey}
+func (h *Handler) HandlePayments(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.PathValue("pubkey")
+	if !nostr.IsValid32ByteHex(pubkey) {
+		writeError(w, http.StatusBadRequest, "invalid pubkey")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	events, err := h.storage.QueryEvents(ctx, nostr.Filter{
+		Kinds:   []int{0, 10019},
+		Authors: []string{pubkey},
+	})
+	if err != nil {
+		writeStorageError(w, err, "failed to query events")
+		return
+	}
+
+	endpoints := PaymentEndpoints{Pubkey: pubkey}
+	latest := latestByKind(events)
+	if profile := latest[0]; profile != nil {
+		var metadata map[string]any
+		if err := json.Unmarshal([]byte(profile.Content), &metadata); err == nil {
+			lud16, _ := metadata["lud16"].(string)
+			lud06, _ := metadata["lud06"].(string)
+			endpoints.Lud16 = storage.NormalizeLightningAddress(lud16)
+			endpoints.Lud06 = strings.TrimSpace(lud06)
+		}
+		if endpoints.Lud16 != "" || endpoints.Lud06 != "" {
+			endpoints.ProfileUpdatedAt = int64(profile.CreatedAt)
+		}
+	}
+	if info := latest[10019]; info != nil {
+		endpoints.Nutzap = parseNutzapInfo(info)
+	}
+
+	writeJSON(w, http.StatusOK, endpoints)
+}
+
+// HandlePaymentsByAddress serves GET /api/v1/payments?lud16=name@domain, the
+// pubkeys whose latest profile advertises the lightning address
+func (h *Handler) HandlePaymentsByAddress(w http.ResponseWriter, r *http.Request) {
+	address := storage.NormalizeLightningAddress(r.URL.Query().Get("lud16"))
+	if address == "" {
+		writeError(w, http.StatusBadRequest, "lud16 must be a name@domain lightning address")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	pubkeys, err := h.storage.GetPubkeysByLightningAddress(ctx, address, maxLightningAddressPubkeys)
+	if err != nil {
+		writeStorageError(w, err, "failed to look up lightning address")
+		return
+	}
+	if pubkeys == nil {
+		pubkeys = []string{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"lud16":   address,
+		"pubkeys": pubkeys,
+	})
+}
+
+// parseNutzapInfo reads the mint, relay and pubkey tags of a kind 10019
+func parseNutzapInfo(evt *nostr.Event) *NutzapInfo {
+	info := &NutzapInfo{Mints: []NutzapMint{}, Relays: []string{}, UpdatedAt: int64(evt.CreatedAt)}
+	for _, tag := range evt.Tags {
+		if len(tag) < 2 || tag[1] == "" {
+			continue
+		}
+		switch tag[0] {
+		case "mint":
+			info.Mints = append(info.Mints, NutzapMint{URL: tag[1], Units: tag[2:]})
+		case "relay":
+			info.Relays = append(info.Relays, tag[1])
+		case "pubkey":
+			if info.P2PKPubkey == "" {
+				info.P2PKPubkey = tag[1]
+			}
+		}
+	}
+	return info
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	// maxProfileNames caps the pubkeys one lookup may resolve
+	maxProfileNames = 500
+	// profileNamesCacheTTL bounds how stale a resolved name can be
+	profileNamesCacheTTL  = 10 * time.Minute
+	profileNamesCacheSize = 100000
+)
+
+type cachedSummary struct {
+	summary   storage.ProfileSummary
+	found     bool
+	fetchedAt time.Time
+}
+
+// ProfileNames resolves lists of pubkeys to what clients show next to them.
+// Lookups, including pubkeys without a profile, are cached in memory.
+type ProfileNames struct {
+	storage *storage.Storage
+
+	mu    sync.Mutex
+	cache map[string]cachedSummary
+}
+
+func NewProfileNames(store *storage.Storage) *ProfileNames {
+	return &ProfileNames{storage: store, cache: make(map[string]cachedSummary)}
+}
+
+// HandleNames serves POST /api/v1/profiles/names with a body of
+// {"pubkeys": ["<hex>", ...]}
+func (n *ProfileNames) HandleNames(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Pubkeys []string `json:"pubkeys"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 128*1024)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if len(body.Pubkeys) == 0 {
+		writeError(w, http.StatusBadRequest, "missing pubkeys")
+		return
+	}
+	if len(body.Pubkeys) > maxProfileNames {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d pubkeys per request", maxProfileNames))
+		return
+	}
+	for _, pk := range body.Pubkeys {
+		if !nostr.IsValid32ByteHex(pk) {
+			writeError(w, http.StatusBadRequest, "invalid pubkey: "+pk)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	profiles, err := n.lookup(ctx, body.Pubkeys)
+	if err != nil {
+		writeStorageError(w, err, "failed to load profiles")
+		return
+	}
+
+	missing := []string{}
+	for _, pk := range body.Pubkeys {
+		if _, ok := profiles[pk]; !ok {
+			missing = append(missing, pk)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"profiles": profiles, "missing": missing})
+}
+
+// lookup answers from the cache and queries the rest in one go
+func (n *ProfileNames) lookup(ctx context.Context, pubkeys []string) (map[string]storage.ProfileSummary, error) {
+	profiles := make(map[string]storage.ProfileSummary, len(pubkeys))
+	var uncached []string
+
+	n.mu.Lock()
+	for _, pk := range pubkeys {
+		cached, ok := n.cache[pk]
+		if !ok || time.Since(cached.fetchedAt) >= profileNamesCacheTTL {
+			uncached = append(uncached, pk)
+			continue
+		}
+		if cached.found {
+			profiles[pk] = cached.summary
+		}
+	}
+	n.mu.Unlock()
+
+	if len(uncached) == 0 {
+		return profiles, nil
+	}
+
+	found, err := n.storage.GetProfileSummaries(ctx, uncached)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	n.mu.Lock()
+	if len(n.cache)+len(uncached) > profileNamesCacheSize {
+		for pk, c := range n.cache {
+			if now.Sub(c.fetchedAt) >= profileNamesCacheTTL {
+				delete(n.cache, pk)
+			}
+		}
+		if len(n.cache)+len(uncached) > profileNamesCacheSize {
+			n.cache = make(map[string]cachedSummary)
+		}
+	}
+	for _, pk := range uncached {
+		summary, ok := found[pk]
+		n.cache[pk] = cachedSummary{summary: summary, found: ok, fetchedAt: now}
+		if ok {
+			profiles[pk] = summary
+		}
+	}
+	n.mu.Unlock()
+
+	return profiles, nil
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the reverse proxies whose X-Forwarded-* headers are
+// believed; from anyone else those headers are whatever the client made up
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the reverse proxies, as IPs or CIDRs, whose
+// forwarding headers are believed. It must be called before serving.
+func SetTrustedProxies(proxies []string) error {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return fmt.Errorf("%q is not an IP or CIDR", proxy)
+			}
+			bits := 8 * len(ip)
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("%q is not an IP or CIDR", proxy)
+		}
+		nets = append(nets, n)
+	}
+	trustedProxies = nets
+	return nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP is the address the connection comes from
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// fromTrustedProxy reports whether the request's X-Forwarded-* headers can be believed
+func fromTrustedProxy(r *http.Request) bool {
+	return isTrustedProxy(net.ParseIP(remoteIP(r)))
+}
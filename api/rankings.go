@@ -0,0 +1,493 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip05"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// RankingsRefreshInterval is how often the rankings snapshot is rebuilt
+const RankingsRefreshInterval = 15 * time.Minute
+
+const (
+	nip05RecheckAfter     = 7 * 24 * time.Hour
+	nip05ChecksPerRefresh = 500
+	defaultRankingsLimit  = 50
+	maxRankingsLimit      = 200
+)
+
+// ErrInvalidRankingQuery is returned by Query for bad sort orders or cursors
+var ErrInvalidRankingQuery = errors.New("invalid rankings query")
+
+// Ranking sort orders
+const (
+	SortFollowers    = "followers"
+	SortTrend        = "trend"
+	SortCompleteness = "completeness"
+)
+
+// RankedPubkey is one pubkey in the rankings snapshot
+type RankedPubkey struct {
+	Pubkey        string `json:"pubkey"`
+	Rank          int    `json:"rank"`
+	Followers     int    `json:"followers"`
+	Trend         int64  `json:"trend"`
+	Completeness  int    `json:"completeness"`
+	Nip05         string `json:"nip05,omitempty"`
+	Nip05Verified bool   `json:"nip05_verified"`
+	HasRelayList  bool   `json:"has_relay_list"`
+	InBotCluster  bool   `json:"in_bot_cluster"`
+}
+
+// RankingQuery selects a page of the rankings
+type RankingQuery struct {
+	Sort          string
+	Cursor        string
+	Limit         int
+	Nip05Verified bool
+	HasRelayList  bool
+	ExcludeBots   bool
+}
+
+// RankingPage is one page of the rankings plus the cursor for the next one
+type RankingPage struct {
+	Sort        string         `json:"sort"`
+	Total       int            `json:"total"`
+	Entries     []RankedPubkey `json:"entries"`
+	NextCursor  string         `json:"next_cursor,omitempty"`
+	GeneratedAt int64          `json:"generated_at"`
+}
+
+type rankingSnapshot struct {
+	generatedAt time.Time
+	sorted      map[string][]*RankedPubkey
+}
+
+// Rankings keeps a periodically rebuilt snapshot of every followed pubkey and
+// serves filtered, cursor-paginated views of it
+type Rankings struct {
+	storage *storage.Storage
+
+	mu       sync.RWMutex
+	snapshot *rankingSnapshot
+
+	refreshMu sync.Mutex
+}
+
+func NewRankings(store *storage.Storage) *Rankings {
+	return &Rankings{storage: store}
+}
+
+// Refresh rebuilds the snapshot, then verifies NIP-05 identifiers of the highest ranked pubkeys
+func (r *Rankings) Refresh(ctx context.Context) error {
+	if err := r.refresh(ctx); err != nil {
+		return err
+	}
+	r.verifyNip05(ctx)
+	return nil
+}
+
+func (r *Rankings) current(ctx context.Context) (*rankingSnapshot, error) {
+	r.mu.RLock()
+	snap := r.snapshot
+	r.mu.RUnlock()
+	if snap != nil {
+		return snap, nil
+	}
+
+	// First request before Start has built anything; build once for everyone waiting
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
+	r.mu.RLock()
+	snap = r.snapshot
+	r.mu.RUnlock()
+	if snap != nil {
+		return snap, nil
+	}
+
+	if err := r.build(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.snapshot, nil
+}
+
+func (r *Rankings) refresh(ctx context.Context) error {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
+	return r.build(ctx)
+}
+
+func (r *Rankings) build(ctx context.Context) error {
+	start := time.Now()
+
+	// Kind 3 is replaceable, but keep only the newest per author in case older copies linger
+	latestContacts := make(map[string]*nostr.Event)
+	err := r.storage.ScanEvents(ctx, nostr.Filter{Kinds: []int{3}}, func(evt *nostr.Event) {
+		if existing, ok := latestContacts[evt.PubKey]; !ok || evt.CreatedAt > existing.CreatedAt {
+			latestContacts[evt.PubKey] = evt
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan contact lists: %w", err)
+	}
+
+	// Following a migrated key counts as following the key it moved to
+	migrations, _ := r.storage.GetKeyMigrations(ctx)
+
+	followers := make(map[string]int)
+	for _, evt := range latestContacts {
+		seen := make(map[string]bool)
+		for _, tag := range evt.Tags {
+			if len(tag) < 2 || tag[0] != "p" {
+				continue
+			}
+			followed := tag[1]
+			if newPubkey, ok := migrations[followed]; ok {
+				followed = newPubkey
+			}
+			if !seen[followed] {
+				seen[followed] = true
+				followers[followed]++
+			}
+		}
+	}
+
+	// Accounts no relay has anything for are followed but not really there
+	dead, _ := r.storage.GetDeadPubkeys(ctx, time.Time{})
+	bots, _ := r.storage.GetBotClusterPubkeys(ctx)
+	verified, _ := r.storage.GetVerifiedNip05Pubkeys(ctx)
+	changes, _ := r.storage.GetFollowerChanges(ctx)
+
+	entries := make(map[string]*RankedPubkey, len(followers))
+	for pubkey, count := range followers {
+		if dead[pubkey] {
+			continue
+		}
+		entries[pubkey] = &RankedPubkey{
+			Pubkey:       pubkey,
+			Followers:    count,
+			Trend:        changes[pubkey].NetChange,
+			InBotCluster: bots[pubkey],
+		}
+	}
+
+	err = r.storage.ScanEvents(ctx, nostr.Filter{Kinds: []int{10002}}, func(evt *nostr.Event) {
+		if e, ok := entries[evt.PubKey]; ok {
+			e.HasRelayList = true
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan relay lists: %w", err)
+	}
+
+	latestProfiles := make(map[string]*nostr.Event)
+	err = r.storage.ScanEvents(ctx, nostr.Filter{Kinds: []int{0}}, func(evt *nostr.Event) {
+		if _, ok := entries[evt.PubKey]; !ok {
+			return
+		}
+		if existing, ok := latestProfiles[evt.PubKey]; !ok || evt.CreatedAt > existing.CreatedAt {
+			latestProfiles[evt.PubKey] = evt
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan profiles: %w", err)
+	}
+
+	for pubkey, e := range entries {
+		var metadata map[string]interface{}
+		if evt := latestProfiles[pubkey]; evt != nil {
+			json.Unmarshal([]byte(evt.Content), &metadata)
+		}
+		e.Nip05, _ = metadata["nip05"].(string)
+		e.Nip05Verified = e.Nip05 != "" && verified[pubkey] == strings.ToLower(e.Nip05)
+		_, hasContacts := latestContacts[pubkey]
+		e.Completeness = profileCompleteness(metadata, hasContacts, e.HasRelayList)
+	}
+
+	all := make([]*RankedPubkey, 0, len(entries))
+	for _, e := range entries {
+		all = append(all, e)
+	}
+
+	snap := &rankingSnapshot{
+		generatedAt: time.Now(),
+		sorted:      make(map[string][]*RankedPubkey, 3),
+	}
+	for _, sortBy := range []string{SortFollowers, SortTrend, SortCompleteness} {
+		sorted := make([]*RankedPubkey, len(all))
+		copy(sorted, all)
+		sort.Slice(sorted, func(i, j int) bool {
+			return rankedBefore(sortBy, sorted[i], sorted[j])
+		})
+		snap.sorted[sortBy] = sorted
+	}
+
+	r.mu.Lock()
+	r.snapshot = snap
+	r.mu.Unlock()
+
+	log.Printf("Rankings: ranked %d pubkeys in %v", len(all), time.Since(start))
+	return nil
+}
+
+// profileCompleteness scores 0-100 how much of a usable profile a pubkey has published
+func profileCompleteness(metadata map[string]interface{}, hasContacts, hasRelays bool) int {
+	present := func(keys ...string) bool {
+		for _, key := range keys {
+			if v, _ := metadata[key].(string); strings.TrimSpace(v) != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	checks := []bool{
+		present("name", "display_name"),
+		present("picture"),
+		present("about"),
+		present("banner"),
+		present("nip05"),
+		present("lud16", "lud06"),
+		hasContacts,
+		hasRelays,
+	}
+
+	score := 0
+	for _, ok := range checks {
+		if ok {
+			score++
+		}
+	}
+	return score * 100 / len(checks)
+}
+
+func sortValue(sortBy string, e *RankedPubkey) int64 {
+	switch sortBy {
+	case SortTrend:
+		return e.Trend
+	case SortCompleteness:
+		return int64(e.Completeness)
+	default:
+		return int64(e.Followers)
+	}
+}
+
+// rankedBefore orders by the sort value, then followers, then pubkey so every
+// position is unique and a cursor always points at the same place
+func rankedBefore(sortBy string, a, b *RankedPubkey) bool {
+	return cursorBefore(sortBy, a, rankingCursor{sortValue(sortBy, b), int64(b.Followers), b.Pubkey})
+}
+
+type rankingCursor struct {
+	value     int64
+	followers int64
+	pubkey    string
+}
+
+func cursorBefore(sortBy string, e *RankedPubkey, c rankingCursor) bool {
+	if v := sortValue(sortBy, e); v != c.value {
+		return v > c.value
+	}
+	if f := int64(e.Followers); f != c.followers {
+		return f > c.followers
+	}
+	return e.Pubkey < c.pubkey
+}
+
+func encodeCursor(sortBy string, e *RankedPubkey) string {
+	raw := fmt.Sprintf("%d:%d:%s", sortValue(sortBy, e), e.Followers, e.Pubkey)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (rankingCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return rankingCursor{}, err
+	}
+
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 {
+		return rankingCursor{}, fmt.Errorf("malformed cursor")
+	}
+
+	var c rankingCursor
+	if c.value, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return rankingCursor{}, err
+	}
+	if c.followers, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return rankingCursor{}, err
+	}
+	c.pubkey = parts[2]
+	return c, nil
+}
+
+// Query returns one page of the rankings. Cursors encode the position of the
+// last entry rather than an offset, so pages stay consistent across snapshot rebuilds.
+func (r *Rankings) Query(ctx context.Context, q RankingQuery) (*RankingPage, error) {
+	if q.Sort == "" {
+		q.Sort = SortFollowers
+	}
+	if q.Sort != SortFollowers && q.Sort != SortTrend && q.Sort != SortCompleteness {
+		return nil, fmt.Errorf("%w: unknown sort %q", ErrInvalidRankingQuery, q.Sort)
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultRankingsLimit
+	}
+	if q.Limit > maxRankingsLimit {
+		q.Limit = maxRankingsLimit
+	}
+
+	var after *rankingCursor
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad cursor", ErrInvalidRankingQuery)
+		}
+		after = &c
+	}
+
+	snap, err := r.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &RankingPage{
+		Sort:        q.Sort,
+		Entries:     make([]RankedPubkey, 0, q.Limit),
+		GeneratedAt: snap.generatedAt.Unix(),
+	}
+
+	for _, e := range snap.sorted[q.Sort] {
+		if q.Nip05Verified && !e.Nip05Verified {
+			continue
+		}
+		if q.HasRelayList && !e.HasRelayList {
+			continue
+		}
+		if q.ExcludeBots && e.InBotCluster {
+			continue
+		}
+		page.Total++
+
+		if after != nil && (cursorBefore(q.Sort, e, *after) || e.Pubkey == after.pubkey) {
+			continue
+		}
+		if len(page.Entries) < q.Limit {
+			entry := *e
+			entry.Rank = page.Total
+			page.Entries = append(page.Entries, entry)
+		} else if page.NextCursor == "" {
+			page.NextCursor = encodeCursor(q.Sort, &page.Entries[len(page.Entries)-1])
+		}
+	}
+
+	return page, nil
+}
+
+// verifyNip05 checks the claimed identifiers of the top ranked pubkeys that
+// haven't been checked recently or changed since their last check
+func (r *Rankings) verifyNip05(ctx context.Context) {
+	snap, err := r.current(ctx)
+	if err != nil {
+		return
+	}
+
+	var top []*RankedPubkey
+	for _, e := range snap.sorted[SortFollowers] {
+		if len(top) >= nip05ChecksPerRefresh {
+			break
+		}
+		if e.Nip05 != "" && nip05.IsValidIdentifier(e.Nip05) {
+			top = append(top, e)
+		}
+	}
+
+	pubkeys := make([]string, len(top))
+	for i, e := range top {
+		pubkeys[i] = e.Pubkey
+	}
+	previous, err := r.storage.GetNip05Verifications(ctx, pubkeys)
+	if err != nil {
+		log.Printf("Rankings: failed to load NIP-05 verifications: %v", err)
+		return
+	}
+
+	checked, valid := 0, 0
+	for _, e := range top {
+		if ctx.Err() != nil {
+			return
+		}
+
+		prev, ok := previous[e.Pubkey]
+		if ok && prev.Identifier == strings.ToLower(e.Nip05) && time.Since(prev.CheckedAt) < nip05RecheckAfter {
+			continue
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		pointer, err := nip05.QueryIdentifier(lookupCtx, e.Nip05)
+		cancel()
+
+		ok = err == nil && pointer != nil && pointer.PublicKey == e.Pubkey
+		if err := r.storage.SaveNip05Verification(ctx, e.Pubkey, e.Nip05, ok); err != nil {
+			log.Printf("Rankings: failed to save NIP-05 verification for %s: %v", e.Pubkey[:16], err)
+		}
+		checked++
+		if ok {
+			valid++
+		}
+	}
+
+	if checked > 0 {
+		log.Printf("Rankings: verified NIP-05 for %d pubkeys (%d valid)", checked, valid)
+	}
+}
+
+func (h *Handler) HandleRankings(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := RankingQuery{
+		Sort:          query.Get("sort"),
+		Cursor:        query.Get("cursor"),
+		Nip05Verified: query.Get("nip05") == "1",
+		HasRelayList:  query.Get("relays") == "1",
+		ExcludeBots:   query.Get("exclude_bots") == "1",
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		q.Limit = limit
+	}
+
+	page, err := h.rankings.Query(r.Context(), q)
+	if errors.Is(err, ErrInvalidRankingQuery) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeStorageError(w, err, "failed to build rankings")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey is a client allowed more than the anonymous per-IP limit
+type APIKey struct {
+	Name              string
+	RequestsPerMinute int
+	Burst             int
+	// Class groups keys in the allowed/limited counts; the key's name when empty
+	Class string
+}
+
+// RateLimiter is a token bucket per client: per API key when the request carries
+// a known one, per IP otherwise. Each bucket holds up to burst requests and
+// refills at the client's requests per minute.
+type RateLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	burst     int
+	keys      map[string]APIKey
+	lookup    func(key string) (APIKey, bool)
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	counts    map[string]*RateLimitStat
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimitStat counts decisions for one endpoint and client class since startup
+type RateLimitStat struct {
+	Endpoint string
+	Client   string // "ip" for anonymous clients, otherwise the API key's name
+	Allowed  int64
+	Limited  int64
+}
+
+func NewRateLimiter(perMinute, burst int, keys map[string]APIKey) *RateLimiter {
+	return &RateLimiter{
+		perMinute: perMinute,
+		burst:     burst,
+		keys:      keys,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		counts:    make(map[string]*RateLimitStat),
+	}
+}
+
+// SetKeyLookup resolves API keys that aren't configured statically, such as
+// keys issued at runtime. It must be set before the limiter serves requests.
+func (l *RateLimiter) SetKeyLookup(lookup func(key string) (APIKey, bool)) {
+	l.lookup = lookup
+}
+
+// Wrap rate-limits an endpoint, answering 429 with Retry-After once the client's
+// bucket is empty and setting RateLimit-* headers on every response
+func (l *RateLimiter) Wrap(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, perMinute, burst := "ip:"+ClientIP(r), l.perMinute, l.burst
+		class := "ip"
+		if key := RequestAPIKey(r); key != "" {
+			k, ok := l.keys[key]
+			if !ok && l.lookup != nil {
+				k, ok = l.lookup(key)
+			}
+			if !ok {
+				writeError(w, http.StatusUnauthorized, "invalid API key")
+				return
+			}
+			client, perMinute, burst, class = "key:"+k.Name, k.RequestsPerMinute, k.Burst, k.Name
+			if k.Class != "" {
+				class = k.Class
+			}
+		}
+
+		allowed, remaining, wait := l.take(endpoint, client, class, perMinute, burst)
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// RequireKey is Wrap for endpoints only API key holders may use: requests
+// without a key are answered 401
+func (l *RateLimiter) RequireKey(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	wrapped := l.Wrap(endpoint, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if RequestAPIKey(r) == "" {
+			writeError(w, http.StatusUnauthorized, "API key required")
+			return
+		}
+		wrapped(w, r)
+	}
+}
+
+// take spends a token from the client's bucket. It returns whether the request
+// may proceed, the tokens left, and how long until the next token.
+func (l *RateLimiter) take(endpoint, client, class string, perMinute, burst int) (bool, int, time.Duration) {
+	now := time.Now()
+	rate := float64(perMinute) / 60 // tokens per second
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b := l.buckets[client]
+	if b == nil {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	statKey := endpoint + "|" + class
+	stat := l.counts[statKey]
+	if stat == nil {
+		stat = &RateLimitStat{Endpoint: endpoint, Client: class}
+		l.counts[statKey] = stat
+	}
+
+	untilNext := time.Duration(0)
+	if rate > 0 {
+		untilNext = time.Duration((1 - math.Mod(b.tokens, 1)) / rate * float64(time.Second))
+	}
+
+	if b.tokens < 1 {
+		stat.Limited++
+		return false, 0, untilNext
+	}
+	b.tokens--
+	stat.Allowed++
+	return true, int(b.tokens), untilNext
+}
+
+// sweep drops buckets idle long enough to have refilled, so one-off IPs don't
+// pile up. Called with mu held.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > 10*time.Minute {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// GetStats returns allowed and limited counts per endpoint and client class
+func (l *RateLimiter) GetStats() []RateLimitStat {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := make([]RateLimitStat, 0, len(l.counts))
+	for _, stat := range l.counts {
+		result = append(result, *stat)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Endpoint != result[j].Endpoint {
+			return result[i].Endpoint < result[j].Endpoint
+		}
+		return result[i].Client < result[j].Client
+	})
+	return result
+}
+
+// RequestAPIKey reads the key from "Authorization: Bearer <key>" or X-API-Key
+func RequestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// ClientIP returns the requesting client address. X-Forwarded-For is only
+// followed back through trusted proxies: the client is the last hop that
+// isn't one, so hops a client prepends itself are never reached.
+func ClientIP(r *http.Request) string {
+	client := remoteIP(r)
+	if !fromTrustedProxy(r) {
+		return client
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/relay"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// Relay check issue severities, most urgent first
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// Relay statuses in a relay list check
+const (
+	RelayStatusOK          = "ok"
+	RelayStatusUnknown     = "unknown" // never discovered, so never probed
+	RelayStatusDead        = "dead"
+	RelayStatusUnreliable  = "unreliable"
+	RelayStatusRestricted  = "restricted"
+	RelayStatusUnreachable = "unreachable"
+	RelayStatusInvalid     = "invalid"
+)
+
+const (
+	// relayCheckDeadAttempts is how many sync attempts without a success in
+	// relayCheckDeadAfter mark a relay as dead
+	relayCheckDeadAttempts = 5
+	relayCheckDeadAfter    = 30 * 24 * time.Hour
+	// relayCheckUnreliableAttempts is how many attempts a success rate needs
+	// before a relay below relayCheckUnreliableRate counts as unreliable
+	relayCheckUnreliableAttempts = 10
+	relayCheckUnreliableRate     = 0.5
+	// NIP-65 asks for small lists: every relay listed is one more connection for
+	// everyone who reads from or writes to the user
+	relayCheckMaxPerMarker = 4
+	relayCheckMaxTotal     = 10
+)
+
+// RelayCheckIssue is one problem found with a relay list and how to fix it
+type RelayCheckIssue struct {
+	Severity   string `json:"severity"`
+	Code       string `json:"code"`
+	Relay      string `json:"relay,omitempty"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion"`
+}
+
+// CheckedRelay is one entry of a relay list with what our probes say about it
+type CheckedRelay struct {
+	URL    string `json:"url"`
+	Read   bool   `json:"read"`
+	Write  bool   `json:"write"`
+	Status string `json:"status"`
+}
+
+// RelayListCheck evaluates a user's kind 10002 relay list
+type RelayListCheck struct {
+	Pubkey    string            `json:"pubkey"`
+	EventID   string            `json:"event_id"`
+	CreatedAt int64             `json:"created_at"`
+	Healthy   bool              `json:"healthy"` // no errors or warnings
+	Read      int               `json:"read"`    // usable read relays
+	Write     int               `json:"write"`   // usable write relays
+	Relays    []CheckedRelay    `json:"relays"`
+	Issues    []RelayCheckIssue `json:"issues"`
+}
+
+// HandleRelayCheck serves GET /api/v1/check/{pubkey}
+func (h *Handler) HandleRelayCheck(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.PathValue("pubkey")
+	if !nostr.IsValid32ByteHex(pubkey) {
+		writeError(w, http.StatusBadRequest, "invalid pubkey")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	check, err := CheckRelayList(ctx, h.storage, pubkey)
+	if err != nil {
+		writeStorageError(w, err, "failed to check relay list")
+		return
+	}
+	if check == nil {
+		writeError(w, http.StatusNotFound, "no relay list for pubkey")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, check)
+}
+
+// CheckRelayList evaluates the pubkey's newest relay list against what syncing
+// and the census found out about each relay. It returns nil if we hold no
+// relay list for the pubkey.
+func CheckRelayList(ctx context.Context, store *storage.Storage, pubkey string) (*RelayListCheck, error) {
+	events, err := store.QueryEvents(ctx, nostr.Filter{Kinds: []int{10002}, Authors: []string{pubkey}})
+	if err != nil {
+		return nil, err
+	}
+	var latest *nostr.Event
+	for _, evt := range events {
+		if latest == nil || evt.CreatedAt > latest.CreatedAt {
+			latest = evt
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+
+	check := &RelayListCheck{
+		Pubkey:    pubkey,
+		EventID:   latest.ID,
+		CreatedAt: int64(latest.CreatedAt),
+		Relays:    []CheckedRelay{},
+		Issues:    []RelayCheckIssue{},
+	}
+	issue := func(severity, code, relayURL, message, suggestion string) {
+		check.Issues = append(check.Issues, RelayCheckIssue{severity, code, relayURL, message, suggestion})
+	}
+
+	seen := make(map[string]bool)
+	var probeURLs []string
+	for _, tag := range latest.Tags {
+		if len(tag) < 2 || tag[0] != "r" {
+			continue
+		}
+
+		entry := CheckedRelay{URL: tag[1], Read: true, Write: true, Status: RelayStatusUnknown}
+		if len(tag) >= 3 && tag[2] != "" {
+			switch tag[2] {
+			case "read":
+				entry.Write = false
+			case "write":
+				entry.Read = false
+			default:
+				issue(SeverityWarning, "unknown_marker", tag[1],
+					fmt.Sprintf("%s is marked %q, which clients ignore", tag[1], tag[2]),
+					`Use "read", "write" or no marker for both.`)
+			}
+		}
+
+		normalized, err := relay.NormalizeRelayURL(tag[1])
+		switch {
+		case err != nil && isRelayURL(tag[1]):
+			entry.Status = RelayStatusUnreachable
+			issue(SeverityError, "unreachable", tag[1],
+				fmt.Sprintf("%s is a local, private or Tor address most clients can't connect to", tag[1]),
+				"Replace it with a relay on the public internet.")
+		case err != nil:
+			entry.Status = RelayStatusInvalid
+			issue(SeverityError, "invalid_url", tag[1],
+				fmt.Sprintf("%q is not a valid relay URL", tag[1]),
+				"Fix or remove it; relay URLs look like wss://relay.example.com.")
+		case seen[normalized]:
+			issue(SeverityWarning, "duplicate", normalized,
+				fmt.Sprintf("%s is listed more than once", normalized),
+				"Keep a single entry, without a marker if you read from and write to it.")
+			continue
+		default:
+			entry.URL = normalized
+			seen[normalized] = true
+			probeURLs = append(probeURLs, normalized)
+			if strings.HasPrefix(normalized, "ws://") {
+				issue(SeverityWarning, "insecure", normalized,
+					fmt.Sprintf("%s is unencrypted, so browsers on https pages refuse to connect", normalized),
+					"Use its wss:// address if it has one.")
+			}
+		}
+		check.Relays = append(check.Relays, entry)
+	}
+
+	probes, err := store.GetRelayProbes(ctx, probeURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	var totalRead, totalWrite int
+	for i := range check.Relays {
+		entry := &check.Relays[i]
+		if entry.Read {
+			totalRead++
+		}
+		if entry.Write {
+			totalWrite++
+		}
+		if entry.Status != RelayStatusUnknown {
+			continue
+		}
+
+		if probe, ok := probes[entry.URL]; ok {
+			entry.Status = probeStatus(probe)
+			switch entry.Status {
+			case RelayStatusDead:
+				message := fmt.Sprintf("%s never answered any of our %d sync attempts", entry.URL, probe.SyncAttempts)
+				if !probe.LastSync.IsZero() {
+					message = fmt.Sprintf("%s hasn't answered our sync attempts since %s", entry.URL, probe.LastSync.UTC().Format("2006-01-02"))
+				}
+				issue(SeverityError, "dead", entry.URL, message,
+					"Remove it; clients waste a connection on it and whatever it held is likely gone.")
+			case RelayStatusUnreliable:
+				issue(SeverityWarning, "unreliable", entry.URL,
+					fmt.Sprintf("%s answered only %d of our %d sync attempts", entry.URL, probe.SyncSuccesses, probe.SyncAttempts),
+					"Keep another relay for the same purpose next to it.")
+			case RelayStatusRestricted:
+				if entry.Write {
+					issue(SeverityWarning, "restricted", entry.URL,
+						fmt.Sprintf("%s refuses to serve events to others, so your notes there can't be read", entry.URL),
+						"Mark it read, or write to a relay that serves the public.")
+				}
+			}
+			if entry.Read && probe.Capability == storage.RelayAuthRequired {
+				issue(SeverityInfo, "auth_required", entry.URL,
+					fmt.Sprintf("%s requires NIP-42 authentication", entry.URL),
+					"People whose clients don't authenticate may fail to send you replies and mentions there.")
+			}
+		}
+
+		// A restricted relay still takes replies and mentions, it just won't
+		// hand out what is written to it
+		usable := entry.Status == RelayStatusOK || entry.Status == RelayStatusUnknown || entry.Status == RelayStatusUnreliable
+		if entry.Read && (usable || entry.Status == RelayStatusRestricted) {
+			check.Read++
+		}
+		if entry.Write && usable {
+			check.Write++
+		}
+	}
+
+	switch {
+	case totalWrite == 0:
+		issue(SeverityError, "no_write_relays", "",
+			"No write relays are listed, so nobody knows where to find your notes",
+			"Add two or three relays you publish to, without a marker or marked write.")
+	case check.Write == 0:
+		issue(SeverityError, "no_usable_write_relays", "",
+			"None of your write relays are usable, so your notes can't be found",
+			"Replace them with two or three working relays.")
+	case check.Write == 1:
+		issue(SeverityWarning, "single_write_relay", "",
+			"Your notes can only be found on a single relay",
+			"Add a second write relay so your notes survive it going down.")
+	}
+	switch {
+	case totalRead == 0:
+		issue(SeverityError, "no_read_relays", "",
+			"No read relays are listed, so others don't know where to send replies and mentions",
+			"Add two or three relays you read from, without a marker or marked read.")
+	case check.Read == 0:
+		issue(SeverityError, "no_usable_read_relays", "",
+			"None of your read relays are usable, so replies and mentions don't reach you",
+			"Replace them with two or three working relays.")
+	}
+
+	if check.Read > 0 && check.Write > 0 {
+		if check.Write >= 3*check.Read && check.Write > relayCheckMaxPerMarker {
+			issue(SeverityWarning, "read_write_imbalance", "",
+				fmt.Sprintf("You write to %d relays but read from only %d", check.Write, check.Read),
+				"Mark some write relays for both, or drop the ones you don't need.")
+		} else if check.Read >= 3*check.Write && check.Read > relayCheckMaxPerMarker {
+			issue(SeverityWarning, "read_write_imbalance", "",
+				fmt.Sprintf("You read from %d relays but write to only %d", check.Read, check.Write),
+				"Mark some read relays for both, or drop the ones you don't need.")
+		}
+	}
+	if totalWrite > relayCheckMaxPerMarker {
+		issue(SeverityWarning, "too_many_write_relays", "",
+			fmt.Sprintf("%d write relays are listed; clients following you connect to each of them", totalWrite),
+			fmt.Sprintf("Keep at most %d write relays.", relayCheckMaxPerMarker))
+	}
+	if totalRead > relayCheckMaxPerMarker {
+		issue(SeverityWarning, "too_many_read_relays", "",
+			fmt.Sprintf("%d read relays are listed; anyone replying to you has to send to each of them", totalRead),
+			fmt.Sprintf("Keep at most %d read relays.", relayCheckMaxPerMarker))
+	}
+	if len(check.Relays) > relayCheckMaxTotal {
+		issue(SeverityWarning, "too_many_relays", "",
+			fmt.Sprintf("%d relays are listed", len(check.Relays)),
+			"Long lists slow down every client that loads them; drop relays you don't rely on.")
+	}
+
+	severityRank := map[string]int{SeverityError: 0, SeverityWarning: 1, SeverityInfo: 2}
+	sort.SliceStable(check.Issues, func(i, j int) bool {
+		return severityRank[check.Issues[i].Severity] < severityRank[check.Issues[j].Severity]
+	})
+	check.Healthy = len(check.Issues) == 0 || check.Issues[0].Severity == SeverityInfo
+
+	return check, nil
+}
+
+// probeStatus judges a relay by its sync history and capability flags
+func probeStatus(probe storage.RelayProbe) string {
+	if probe.SyncAttempts >= relayCheckDeadAttempts && (probe.LastSync.IsZero() || time.Since(probe.LastSync) > relayCheckDeadAfter) {
+		return RelayStatusDead
+	}
+	if probe.Capability == storage.RelayRestricted {
+		return RelayStatusRestricted
+	}
+	if probe.SyncAttempts >= relayCheckUnreliableAttempts && float64(probe.SyncSuccesses) < relayCheckUnreliableRate*float64(probe.SyncAttempts) {
+		return RelayStatusUnreliable
+	}
+	return RelayStatusOK
+}
+
+// isRelayURL reports whether a URL NormalizeRelayURL refused is a well-formed
+// websocket URL, i.e. was refused for its host rather than its syntax
+func isRelayURL(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	return err == nil && (u.Scheme == "ws" || u.Scheme == "wss") && u.Hostname() != ""
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// SnapshotKinds are the kinds served by /api/v1/snapshot for seeding other instances
+var SnapshotKinds = []int{0, 3, 10002}
+
+// HandleSnapshot streams a gzipped JSONL dump of the latest profile, contact and
+// relay list events, optionally only those created at or after ?since=<unix>
+func (h *Handler) HandleSnapshot(w http.ResponseWriter, r *http.Request) {
+	filter := nostr.Filter{Kinds: SnapshotKinds}
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		since, err := strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil || since < 0 {
+			writeError(w, http.StatusBadRequest, "invalid since")
+			return
+		}
+		ts := nostr.Timestamp(since)
+		filter.Since = &ts
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="purplepages-snapshot.jsonl.gz"`)
+
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	enc := json.NewEncoder(gz)
+
+	var sent int64
+	var writeErr error
+	err := h.storage.ScanEvents(r.Context(), filter, func(evt *nostr.Event) {
+		if writeErr != nil {
+			return
+		}
+		writeErr = enc.Encode(evt)
+		sent++
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		// Headers are gone already; the truncated stream tells the client it failed
+		log.Printf("api: snapshot aborted after %d events: %v", sent, err)
+		return
+	}
+
+	log.Printf("api: served snapshot of %d events to %s", sent, r.RemoteAddr)
+}
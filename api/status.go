@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	// maxStatusPubkeys caps the pubkeys one status lookup may check
+	maxStatusPubkeys = 1000
+	// statusCacheTTL bounds how stale a pubkey's status can be; trust and spam
+	// analysis run hourly, so a few minutes costs little accuracy
+	statusCacheTTL  = 5 * time.Minute
+	statusCacheSize = 200000
+)
+
+// PubkeyStatus is how a client should treat a pubkey in a timeline
+type PubkeyStatus struct {
+	Trusted       bool    `json:"trusted"`
+	BotClusters   []int64 `json:"bot_clusters"`
+	SpamCandidate bool    `json:"spam_candidate"`
+	Followers     int64   `json:"followers"`
+	LastSeen      int64   `json:"last_seen"`
+}
+
+type cachedStatus struct {
+	status    PubkeyStatus
+	fetchedAt time.Time
+}
+
+// StatusLookup answers bulk spam checks for lists of pubkeys. Statuses are
+// cached in memory.
+type StatusLookup struct {
+	storage *storage.Storage
+
+	mu    sync.Mutex
+	cache map[string]cachedStatus
+}
+
+func NewStatusLookup(store *storage.Storage) *StatusLookup {
+	return &StatusLookup{storage: store, cache: make(map[string]cachedStatus)}
+}
+
+// HandleStatus serves POST /api/v1/status with a body of
+// {"pubkeys": ["<hex>", ...]}
+func (l *StatusLookup) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Pubkeys []string `json:"pubkeys"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 128*1024)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if len(body.Pubkeys) == 0 {
+		writeError(w, http.StatusBadRequest, "missing pubkeys")
+		return
+	}
+	if len(body.Pubkeys) > maxStatusPubkeys {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d pubkeys per request", maxStatusPubkeys))
+		return
+	}
+	for _, pk := range body.Pubkeys {
+		if !nostr.IsValid32ByteHex(pk) {
+			writeError(w, http.StatusBadRequest, "invalid pubkey: "+pk)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	statuses, err := l.lookup(ctx, body.Pubkeys)
+	if err != nil {
+		writeStorageError(w, err, "failed to load statuses")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"statuses": statuses})
+}
+
+// lookup answers from the cache and queries the rest in one go
+func (l *StatusLookup) lookup(ctx context.Context, pubkeys []string) (map[string]PubkeyStatus, error) {
+	statuses := make(map[string]PubkeyStatus, len(pubkeys))
+	var uncached []string
+
+	l.mu.Lock()
+	for _, pk := range pubkeys {
+		cached, ok := l.cache[pk]
+		if !ok || time.Since(cached.fetchedAt) >= statusCacheTTL {
+			uncached = append(uncached, pk)
+			continue
+		}
+		statuses[pk] = cached.status
+	}
+	l.mu.Unlock()
+
+	if len(uncached) == 0 {
+		return statuses, nil
+	}
+
+	found, err := l.storage.GetPubkeyStatuses(ctx, uncached)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	if len(l.cache)+len(uncached) > statusCacheSize {
+		for pk, c := range l.cache {
+			if now.Sub(c.fetchedAt) >= statusCacheTTL {
+				delete(l.cache, pk)
+			}
+		}
+		if len(l.cache)+len(uncached) > statusCacheSize {
+			l.cache = make(map[string]cachedStatus)
+		}
+	}
+	for _, pk := range uncached {
+		s := found[pk]
+		status := PubkeyStatus{
+			Trusted:       s.Trusted,
+			BotClusters:   append([]int64{}, s.BotClusters...),
+			SpamCandidate: s.SpamCandidate,
+			Followers:     s.Followers,
+			LastSeen:      s.LastSeen,
+		}
+		l.cache[pk] = cachedStatus{status: status, fetchedAt: now}
+		statuses[pk] = status
+	}
+	l.mu.Unlock()
+
+	return statuses, nil
+}
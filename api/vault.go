@@ -0,0 +1,337 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip42"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	// vaultChallengeTTL is how long a challenge from /api/v1/vault/challenge can be signed
+	vaultChallengeTTL = 10 * time.Minute
+	// vaultMaxVersions caps the archived contact lists listed
+	vaultMaxVersions = 500
+)
+
+// ContactListVersion summarizes one version of a contact list and how it
+// differs from the version before it
+type ContactListVersion struct {
+	ID         string `json:"id"`
+	CreatedAt  int64  `json:"created_at"`
+	ArchivedAt int64  `json:"archived_at,omitempty"` // when a newer version replaced it
+	Current    bool   `json:"current"`
+	Follows    int    `json:"follows"`
+	Added      int    `json:"added"`
+	Removed    int    `json:"removed"`
+}
+
+// ContactVault lets a user list every archived version of their own contact
+// list and restore one, export what the relay holds about them, and have
+// their latest profile, contact list and relay list re-broadcast. Requests are authenticated with a NIP-42 auth event
+// signed over a challenge from the vault, sent as
+// "Authorization: Nostr <base64 event>".
+type ContactVault struct {
+	storage *storage.Storage
+	publish func(ctx context.Context, evt *nostr.Event) error
+	secret  []byte
+	// Relay URL auth events must name; derived from the request when empty
+	relayURL string
+
+	// When each pubkey last had its events re-broadcast
+	broadcastMu sync.Mutex
+	broadcasts  map[string]time.Time
+}
+
+func NewContactVault(store *storage.Storage, publish func(ctx context.Context, evt *nostr.Event) error) *ContactVault {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return &ContactVault{storage: store, publish: publish, secret: secret, broadcasts: make(map[string]time.Time)}
+}
+
+// HandleChallenge serves GET /api/v1/vault/challenge: the challenge and relay
+// URL to put in the auth event
+func (v *ContactVault) HandleChallenge(w http.ResponseWriter, r *http.Request) {
+	issued := strconv.FormatInt(time.Now().Unix(), 10)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"challenge":  issued + "." + v.sign(issued),
+		"relay":      v.authRelayURL(r),
+		"expires_in": int(vaultChallengeTTL.Seconds()),
+	})
+}
+
+// HandleList serves GET /api/v1/vault/contacts: the authenticated user's
+// current contact list and every archived version, newest first
+func (v *ContactVault) HandleList(w http.ResponseWriter, r *http.Request) {
+	pubkey, ok := v.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	current, err := v.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{3}, Authors: []string{pubkey}, Limit: 1})
+	if err != nil {
+		writeStorageError(w, err, "failed to load contact list")
+		return
+	}
+	archived, err := v.storage.GetEventHistory(ctx, pubkey, 3, vaultMaxVersions)
+	if err != nil {
+		writeStorageError(w, err, "failed to load archived contact lists")
+		return
+	}
+
+	// Newest first, so each version is diffed against the one after it
+	type version struct {
+		summary ContactListVersion
+		follows map[string]bool
+	}
+	var versions []version
+	if len(current) > 0 {
+		versions = append(versions, version{
+			summary: ContactListVersion{ID: current[0].ID, CreatedAt: int64(current[0].CreatedAt), Current: true},
+			follows: tagPubkeys(current[0].Tags),
+		})
+	}
+	for _, a := range archived {
+		versions = append(versions, version{
+			summary: ContactListVersion{ID: a.ID, CreatedAt: int64(a.CreatedAt), ArchivedAt: a.ArchivedAt.Unix()},
+			follows: tagPubkeys(a.Tags),
+		})
+	}
+
+	result := make([]ContactListVersion, len(versions))
+	for i, ver := range versions {
+		ver.summary.Follows = len(ver.follows)
+		if i+1 < len(versions) {
+			previous := versions[i+1].follows
+			for pk := range ver.follows {
+				if !previous[pk] {
+					ver.summary.Added++
+				}
+			}
+			for pk := range previous {
+				if !ver.follows[pk] {
+					ver.summary.Removed++
+				}
+			}
+		} else {
+			ver.summary.Added = len(ver.follows)
+		}
+		result[i] = ver.summary
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"pubkey": pubkey, "versions": result})
+}
+
+// HandleGet serves GET /api/v1/vault/contacts/{id}: one version as it was signed
+func (v *ContactVault) HandleGet(w http.ResponseWriter, r *http.Request) {
+	pubkey, ok := v.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	evt, ok := v.version(w, r, pubkey)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, evt)
+}
+
+// HandleRestore serves POST /api/v1/vault/contacts/{id}/restore. Without a body
+// it returns the version as a new unsigned event for the client to sign; with
+// that event signed as the body it publishes it as the current contact list.
+func (v *ContactVault) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	pubkey, ok := v.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	old, ok := v.version(w, r, pubkey)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 4*1024*1024))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "body too large")
+		return
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"event": nostr.Event{PubKey: pubkey, CreatedAt: nostr.Now(), Kind: 3, Tags: old.Tags, Content: old.Content},
+		})
+		return
+	}
+
+	var evt nostr.Event
+	if err := json.Unmarshal(body, &evt); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid event")
+		return
+	}
+	if evt.Kind != 3 || evt.PubKey != pubkey {
+		writeError(w, http.StatusBadRequest, "event must be a contact list signed by the authenticated pubkey")
+		return
+	}
+	if !evt.CheckID() {
+		writeError(w, http.StatusBadRequest, "invalid event id")
+		return
+	}
+	if ok, _ := evt.CheckSignature(); !ok {
+		writeError(w, http.StatusBadRequest, "invalid signature")
+		return
+	}
+	oldTags, _ := json.Marshal(old.Tags)
+	newTags, _ := json.Marshal(evt.Tags)
+	if string(oldTags) != string(newTags) {
+		writeError(w, http.StatusBadRequest, "event tags differ from the version being restored")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	current, err := v.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{3}, Authors: []string{pubkey}, Limit: 1})
+	if err != nil {
+		writeStorageError(w, err, "failed to load contact list")
+		return
+	}
+	if len(current) > 0 && evt.CreatedAt <= current[0].CreatedAt {
+		writeError(w, http.StatusConflict, "a newer contact list exists; sign the restored version again")
+		return
+	}
+
+	if err := v.publish(ctx, &evt); err != nil {
+		writeStorageError(w, err, "failed to publish contact list")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": evt.ID, "restored_from": old.ID})
+}
+
+// version loads the {id} version of pubkey's contact list, current or archived
+func (v *ContactVault) version(w http.ResponseWriter, r *http.Request, pubkey string) (*nostr.Event, bool) {
+	id := r.PathValue("id")
+	if !nostr.IsValid32ByteHex(id) {
+		writeError(w, http.StatusBadRequest, "invalid event id")
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	lookup, err := v.storage.LookupEvent(ctx, id)
+	if err != nil {
+		writeStorageError(w, err, "failed to look up contact list")
+		return nil, false
+	}
+	// Other users' lists look the same as lists we never had
+	if lookup == nil || lookup.Event.Kind != 3 || lookup.Event.PubKey != pubkey {
+		writeError(w, http.StatusNotFound, "contact list version not found")
+		return nil, false
+	}
+	return lookup.Event, true
+}
+
+// authenticate returns the pubkey that signed the request's auth event,
+// writing a 401 if there is none or it doesn't verify
+func (v *ContactVault) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	pubkey, err := v.authedPubkey(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Nostr")
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return "", false
+	}
+	return pubkey, true
+}
+
+func (v *ContactVault) authedPubkey(r *http.Request) (string, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Nostr ") {
+		return "", errors.New("missing Nostr authorization")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(auth, "Nostr ")))
+	if err != nil {
+		return "", errors.New("authorization is not base64")
+	}
+	var evt nostr.Event
+	if err := json.Unmarshal(raw, &evt); err != nil {
+		return "", errors.New("authorization is not an event")
+	}
+
+	tag := evt.Tags.Find("challenge")
+	if tag == nil {
+		return "", errors.New("auth event has no challenge")
+	}
+	issued, sig, ok := strings.Cut(tag[1], ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(v.sign(issued))) {
+		return "", errors.New("unknown challenge")
+	}
+	if at, err := strconv.ParseInt(issued, 10, 64); err != nil || time.Since(time.Unix(at, 0)) > vaultChallengeTTL {
+		return "", errors.New("challenge expired")
+	}
+
+	pubkey, ok := nip42.ValidateAuthEvent(&evt, tag[1], v.authRelayURL(r))
+	if !ok {
+		return "", errors.New("invalid auth event")
+	}
+	return pubkey, nil
+}
+
+func (v *ContactVault) sign(issued string) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(issued))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetRelayURL fixes the relay URL auth events must name, so it can't be
+// steered by request headers. It must be set before the vault serves requests.
+func (v *ContactVault) SetRelayURL(url string) {
+	v.relayURL = url
+}
+
+// authRelayURL is the relay URL auth events must name: the configured one, or
+// this host's websocket address, as seen through a trusted reverse proxy
+func (v *ContactVault) authRelayURL(r *http.Request) string {
+	if v.relayURL != "" {
+		return v.relayURL
+	}
+
+	host, proto := r.Host, ""
+	if fromTrustedProxy(r) {
+		if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+		proto = r.Header.Get("X-Forwarded-Proto")
+	}
+	scheme := "wss"
+	if proto == "http" || (proto == "" && r.TLS == nil && strings.Contains(host, ":")) {
+		scheme = "ws"
+	}
+	return scheme + "://" + host
+}
+
+// tagPubkeys is the set of valid pubkeys in a list's p tags
+func tagPubkeys(tags nostr.Tags) map[string]bool {
+	pubkeys := make(map[string]bool)
+	for _, tag := range tags {
+		if len(tag) >= 2 && tag[0] == "p" && nostr.IsValid32ByteHex(tag[1]) {
+			pubkeys[tag[1]] = true
+		}
+	}
+	return pubkeys
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// HostedNames serves /.well-known/nostr.json for the NIP-05 names our domain
+// issues. Names are held in memory and reloaded after every admin change.
+type HostedNames struct {
+	storage *storage.Storage
+
+	mu     sync.RWMutex
+	names  map[string]string   // name -> pubkey
+	relays map[string][]string // pubkey -> relays
+}
+
+func NewHostedNames(store *storage.Storage) *HostedNames {
+	return &HostedNames{storage: store, names: make(map[string]string), relays: make(map[string][]string)}
+}
+
+// StartReload loads the names and keeps them in sync with storage
+func (h *HostedNames) StartReload(ctx context.Context, interval time.Duration) {
+	h.Reload(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.Reload(ctx)
+		}
+	}
+}
+
+func (h *HostedNames) Reload(ctx context.Context) {
+	hosted, err := h.storage.GetHostedNames(ctx)
+	if err != nil {
+		log.Printf("Failed to load hosted NIP-05 names: %v", err)
+		return
+	}
+
+	names := make(map[string]string, len(hosted))
+	relays := make(map[string][]string)
+	for _, n := range hosted {
+		names[n.Name] = n.Pubkey
+		if len(n.Relays) > 0 {
+			relays[n.Pubkey] = n.Relays
+		}
+	}
+
+	h.mu.Lock()
+	h.names = names
+	h.relays = relays
+	h.mu.Unlock()
+}
+
+type nostrJSON struct {
+	Names  map[string]string   `json:"names"`
+	Relays map[string][]string `json:"relays,omitempty"`
+}
+
+// HandleNostrJSON serves GET /.well-known/nostr.json[?name=]. Without a name
+// every issued name is listed.
+func (h *HostedNames) HandleNostrJSON(w http.ResponseWriter, r *http.Request) {
+	resp := nostrJSON{Names: make(map[string]string), Relays: make(map[string][]string)}
+
+	h.mu.RLock()
+	if name := r.URL.Query().Get("name"); name != "" {
+		if pubkey, ok := h.names[strings.ToLower(name)]; ok {
+			resp.Names[strings.ToLower(name)] = pubkey
+			if relays := h.relays[pubkey]; len(relays) > 0 {
+				resp.Relays[pubkey] = relays
+			}
+		}
+	} else {
+		for name, pubkey := range h.names {
+			resp.Names[name] = pubkey
+		}
+		for pubkey, relays := range h.relays {
+			resp.Relays[pubkey] = relays
+		}
+	}
+	h.mu.RUnlock()
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, resp)
+}
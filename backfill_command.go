@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pablof7z/purplepag.es/config"
+	relay2 "github.com/pablof7z/purplepag.es/relay"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+func runBackfillRelaysCommand(args []string) {
+	backfillFlags := flag.NewFlagSet("backfill-relays", flag.ExitOnError)
+	backfillFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages backfill-relays\n\n")
+		fmt.Fprintf(os.Stderr, "Scan every stored relay list and search relay list and add their relays to the discovered relays.\n")
+	}
+
+	if err := backfillFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load("config.json")
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	store, err := storage.New(cfg.Storage.Backend, cfg.Storage.Path, false, cfg.Storage.AnalyticsDBURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer store.Close()
+	store.SetScanChunkSize(cfg.Storage.ScanChunkSize)
+
+	if err := store.InitRelayDiscoverySchema(); err != nil {
+		log.Fatalf("Failed to initialize relay discovery schema: %v", err)
+	}
+
+	discovery := relay2.NewDiscovery(store)
+	result, err := discovery.BackfillDiscoveredRelays(context.Background(), func(p relay2.BackfillProgress) {
+		if !p.Done {
+			fmt.Printf("\rScanned %d events, %d relays found", p.EventsScanned, p.RelaysFound)
+		}
+	})
+	fmt.Println()
+	if err != nil {
+		log.Fatalf("Backfill failed: %v", err)
+	}
+
+	fmt.Printf("Scanned %d relay list events: %d relays found, %d newly discovered\n",
+		result.EventsScanned, result.RelaysFound, result.RelaysAdded)
+}
@@ -0,0 +1,219 @@
+package billing
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// invoiceExpiry is how long an invoice for a premium key can be paid
+const invoiceExpiry = time.Hour
+
+// Billing sells premium API keys: a buyer requests an invoice from the
+// operator's wallet over NWC, pays it with any lightning wallet, then claims a
+// key that lifts their JSON API rate limits until it expires
+type Billing struct {
+	storage   *storage.Storage
+	wallet    *NWCClient
+	priceSats int64
+	duration  time.Duration
+
+	mu   sync.RWMutex
+	keys map[string]storage.BillingKey // key hash -> active key
+}
+
+func New(store *storage.Storage, wallet *NWCClient, priceSats int64, duration time.Duration) *Billing {
+	return &Billing{
+		storage:   store,
+		wallet:    wallet,
+		priceSats: priceSats,
+		duration:  duration,
+		keys:      make(map[string]storage.BillingKey),
+	}
+}
+
+// StartReload keeps the active keys in sync with storage, dropping expired ones
+func (b *Billing) StartReload(ctx context.Context, interval time.Duration) {
+	b.load(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.load(ctx)
+		}
+	}
+}
+
+func (b *Billing) load(ctx context.Context) {
+	active, err := b.storage.GetBillingKeys(ctx, true, 1000000)
+	if err != nil {
+		log.Printf("billing: failed to load keys: %v", err)
+		return
+	}
+
+	keys := make(map[string]storage.BillingKey, len(active))
+	for _, k := range active {
+		keys[k.KeyHash] = k
+	}
+
+	b.mu.Lock()
+	b.keys = keys
+	b.mu.Unlock()
+}
+
+// Lookup returns the ID of the active premium key matching key
+func (b *Billing) Lookup(key string) (string, bool) {
+	b.mu.RLock()
+	k, ok := b.keys[hashSecret(key)]
+	b.mu.RUnlock()
+	if !ok || time.Now().After(k.ExpiresAt) {
+		return "", false
+	}
+	return k.ID, true
+}
+
+// HandleCreateInvoice serves POST /api/v1/billing/invoice. The response carries
+// a claim token the buyer needs to collect the key once the invoice is paid.
+func (b *Billing) HandleCreateInvoice(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.URL.Query().Get("pubkey")
+	if pubkey != "" && !nostr.IsValid32ByteHex(pubkey) {
+		writeError(w, http.StatusBadRequest, "invalid pubkey")
+		return
+	}
+
+	days := int(b.duration.Hours() / 24)
+	inv, err := b.wallet.MakeInvoice(r.Context(), b.priceSats, "purplepag.es premium API key", invoiceExpiry)
+	if err != nil {
+		log.Printf("billing: failed to create invoice: %v", err)
+		writeError(w, http.StatusBadGateway, "wallet unavailable")
+		return
+	}
+
+	token := randomSecret()
+	now := time.Now()
+	err = b.storage.SaveBillingInvoice(r.Context(), storage.BillingInvoice{
+		PaymentHash:    inv.PaymentHash,
+		Invoice:        inv.Invoice,
+		AmountSats:     b.priceSats,
+		Pubkey:         pubkey,
+		ClaimTokenHash: hashSecret(token),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(invoiceExpiry),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to save invoice")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"invoice":       inv.Invoice,
+		"payment_hash":  inv.PaymentHash,
+		"claim_token":   token,
+		"amount_sats":   b.priceSats,
+		"duration_days": days,
+		"expires_at":    now.Add(invoiceExpiry).Unix(),
+	})
+}
+
+// HandleClaim serves GET /api/v1/billing/invoice/{payment_hash}?token=. Until
+// the invoice is paid it answers {"paid": false}; the first call after payment
+// issues the API key, which is shown only once.
+func (b *Billing) HandleClaim(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	inv, err := b.storage.GetBillingInvoice(ctx, r.PathValue("payment_hash"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load invoice")
+		return
+	}
+	token := hashSecret(r.URL.Query().Get("token"))
+	if inv == nil || subtle.ConstantTimeCompare([]byte(token), []byte(inv.ClaimTokenHash)) != 1 {
+		writeError(w, http.StatusNotFound, "unknown invoice or claim token")
+		return
+	}
+	if inv.KeyID != "" {
+		writeError(w, http.StatusConflict, storage.ErrInvoiceClaimed.Error())
+		return
+	}
+
+	status, err := b.wallet.LookupInvoice(ctx, inv.PaymentHash)
+	if err != nil {
+		log.Printf("billing: failed to look up invoice %s: %v", inv.PaymentHash, err)
+		writeError(w, http.StatusBadGateway, "wallet unavailable")
+		return
+	}
+	if status.SettledAt == 0 {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"paid": false, "expires_at": inv.ExpiresAt.Unix()})
+		return
+	}
+
+	secret := randomSecret()
+	keyHash := hashSecret(secret)
+	now := time.Now()
+	key := storage.BillingKey{
+		ID:          "premium-" + keyHash[:8],
+		KeyHash:     keyHash,
+		PaymentHash: inv.PaymentHash,
+		Pubkey:      inv.Pubkey,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(b.duration),
+	}
+	err = b.storage.IssueBillingKey(ctx, key, time.Unix(status.SettledAt, 0))
+	if errors.Is(err, storage.ErrInvoiceClaimed) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to issue key")
+		return
+	}
+
+	b.mu.Lock()
+	b.keys[key.KeyHash] = key
+	b.mu.Unlock()
+	log.Printf("billing: issued %s (expires %s)", key.ID, key.ExpiresAt.UTC().Format(time.RFC3339))
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"paid":       true,
+		"api_key":    secret,
+		"key_id":     key.ID,
+		"expires_at": key.ExpiresAt.Unix(),
+	})
+}
+
+func randomSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,177 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// NIP-47 event kinds
+const (
+	kindNWCRequest  = 23194
+	kindNWCResponse = 23195
+)
+
+const nwcTimeout = 30 * time.Second
+
+// NWCClient talks to the operator's wallet over Nostr Wallet Connect (NIP-47),
+// using NIP-04 encryption which every wallet service supports
+type NWCClient struct {
+	walletPubkey string
+	relayURL     string
+	secret       string
+	sharedKey    []byte
+}
+
+// ParseNWCURI reads a nostr+walletconnect://<wallet pubkey>?relay=...&secret=... connection string
+func ParseNWCURI(uri string) (*NWCClient, error) {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "nostr+walletconnect" && u.Scheme != "nostrwalletconnect") {
+		return nil, fmt.Errorf("not a nostr+walletconnect URI")
+	}
+
+	walletPubkey := u.Host
+	if walletPubkey == "" {
+		walletPubkey = strings.TrimPrefix(u.Opaque, "//")
+	}
+	if !nostr.IsValid32ByteHex(walletPubkey) {
+		return nil, fmt.Errorf("invalid wallet pubkey in NWC URI")
+	}
+
+	q := u.Query()
+	relayURL, secret := q.Get("relay"), q.Get("secret")
+	if relayURL == "" {
+		return nil, fmt.Errorf("NWC URI has no relay")
+	}
+	if !nostr.IsValid32ByteHex(secret) {
+		return nil, fmt.Errorf("NWC URI has no valid secret")
+	}
+
+	sharedKey, err := nip04.ComputeSharedSecret(walletPubkey, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NWCClient{walletPubkey: walletPubkey, relayURL: relayURL, secret: secret, sharedKey: sharedKey}, nil
+}
+
+type nwcResponse struct {
+	ResultType string          `json:"result_type"`
+	Error      *nwcError       `json:"error"`
+	Result     json.RawMessage `json:"result"`
+}
+
+type nwcError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// call sends one request to the wallet and waits for its response
+func (c *NWCClient) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, nwcTimeout)
+	defer cancel()
+
+	payload, err := json.Marshal(map[string]interface{}{"method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	content, err := nip04.Encrypt(string(payload), c.sharedKey)
+	if err != nil {
+		return err
+	}
+
+	req := nostr.Event{
+		Kind:      kindNWCRequest,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", c.walletPubkey}},
+		Content:   content,
+	}
+	if err := req.Sign(c.secret); err != nil {
+		return err
+	}
+
+	relay, err := nostr.RelayConnect(ctx, c.relayURL)
+	if err != nil {
+		return fmt.Errorf("connect to wallet relay: %w", err)
+	}
+	defer relay.Close()
+
+	// Subscribe before publishing so a fast wallet's answer isn't missed
+	sub, err := relay.Subscribe(ctx, nostr.Filters{{
+		Kinds:   []int{kindNWCResponse},
+		Authors: []string{c.walletPubkey},
+		Tags:    nostr.TagMap{"e": []string{req.ID}},
+	}})
+	if err != nil {
+		return err
+	}
+	defer sub.Unsub()
+
+	if err := relay.Publish(ctx, req); err != nil {
+		return fmt.Errorf("publish %s request: %w", method, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: no response from wallet: %w", method, ctx.Err())
+		case evt, ok := <-sub.Events:
+			if !ok {
+				return fmt.Errorf("%s: wallet relay closed the subscription", method)
+			}
+			plain, err := nip04.Decrypt(evt.Content, c.sharedKey)
+			if err != nil {
+				continue
+			}
+			var resp nwcResponse
+			if err := json.Unmarshal([]byte(plain), &resp); err != nil {
+				return fmt.Errorf("%s: invalid wallet response: %w", method, err)
+			}
+			if resp.Error != nil {
+				return fmt.Errorf("%s: wallet error %s: %s", method, resp.Error.Code, resp.Error.Message)
+			}
+			return json.Unmarshal(resp.Result, result)
+		}
+	}
+}
+
+// Invoice is a lightning invoice issued by the wallet
+type Invoice struct {
+	Invoice     string `json:"invoice"`
+	PaymentHash string `json:"payment_hash"`
+	Amount      int64  `json:"amount"` // msats
+	SettledAt   int64  `json:"settled_at"`
+	ExpiresAt   int64  `json:"expires_at"`
+}
+
+// MakeInvoice asks the wallet for an invoice of amountSats, valid for expiry
+func (c *NWCClient) MakeInvoice(ctx context.Context, amountSats int64, description string, expiry time.Duration) (*Invoice, error) {
+	var inv Invoice
+	err := c.call(ctx, "make_invoice", map[string]interface{}{
+		"amount":      amountSats * 1000,
+		"description": description,
+		"expiry":      int64(expiry.Seconds()),
+	}, &inv)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Invoice == "" || inv.PaymentHash == "" {
+		return nil, fmt.Errorf("make_invoice: wallet returned no invoice")
+	}
+	return &inv, nil
+}
+
+// LookupInvoice returns the wallet's view of an invoice; SettledAt is set once paid
+func (c *NWCClient) LookupInvoice(ctx context.Context, paymentHash string) (*Invoice, error) {
+	var inv Invoice
+	if err := c.call(ctx, "lookup_invoice", map[string]interface{}{"payment_hash": paymentHash}, &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
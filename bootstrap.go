@@ -0,0 +1,77 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func runBootstrapCommand(args []string) {
+	bootstrapFlags := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	from := bootstrapFlags.String("from", "https://purplepag.es", "Base URL of the purplepages instance to seed from")
+	since := bootstrapFlags.Int64("since", 0, "Only fetch events created at or after this unix timestamp")
+	token := bootstrapFlags.String("token", "", "Partner sync token, for instances that only serve the snapshot to partners")
+	bootstrapFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages bootstrap [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Seed this instance with the latest kind 0/3/10002 events from another purplepages instance.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		bootstrapFlags.PrintDefaults()
+	}
+
+	if err := bootstrapFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	snapshotURL, err := url.Parse(strings.TrimSuffix(*from, "/") + "/api/v1/snapshot")
+	if err != nil {
+		log.Fatalf("Invalid --from URL: %v", err)
+	}
+	if *since > 0 {
+		snapshotURL.RawQuery = url.Values{"since": {strconv.FormatInt(*since, 10)}}.Encode()
+	}
+
+	cfg, store := openImportStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+	stats := &importStats{}
+
+	log.Printf("Bootstrapping from %s...", snapshotURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, snapshotURL.String(), nil)
+	if err != nil {
+		log.Fatalf("Failed to build request: %v", err)
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("Failed to fetch snapshot: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Snapshot request failed: %s", resp.Status)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		log.Fatalf("Failed to read snapshot: %v", err)
+	}
+	defer gz.Close()
+
+	// Events come from another server, so verify every signature
+	if err := importEventStream(ctx, store, cfg, gz, true, stats); err != nil {
+		log.Fatalf("Bootstrap failed: %v (%s)", err, stats)
+	}
+
+	log.Printf("Bootstrap complete: %s", stats)
+}
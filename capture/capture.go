@@ -0,0 +1,585 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// MaxTokenTTL is the longest a debug token can be used to start captures
+	MaxTokenTTL = 24 * time.Hour
+	// maxCapturesPerToken bounds how many connections one token records
+	maxCapturesPerToken = 5
+	// maxMessageBytes is the largest message followed; a larger one ends the capture
+	maxMessageBytes = 1 << 20
+	// redactedValue replaces the data of other users' events in captured frames
+	redactedValue = "[redacted]"
+)
+
+var (
+	// ErrNotFound is returned for a capture ID that doesn't exist
+	ErrNotFound = errors.New("capture not found")
+
+	idPattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z-[0-9a-f]{8}$`)
+)
+
+// Token lets the connections opening the relay URL with ?debug=<token> be
+// captured. Tokens are kept in memory and don't survive a restart.
+type Token struct {
+	Token     string    `json:"token"`
+	Note      string    `json:"note"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Captures  int       `json:"captures"` // connections captured with it so far
+}
+
+// Header is the first line of a capture file
+type Header struct {
+	ID        string `json:"id"`
+	Note      string `json:"note"`
+	CreatedBy string `json:"created_by"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Origin    string `json:"origin,omitempty"`
+	StartedAt int64  `json:"started_at"`
+}
+
+// Frame is one captured websocket message, a line of a capture file after the header
+type Frame struct {
+	T        int64           `json:"t"`    // milliseconds since the capture started
+	Dir      string          `json:"dir"`  // "in" from the client, "out" to it
+	Type     string          `json:"type"` // the envelope label, "close" for a websocket close, "truncated" when recording stopped
+	Frame    json.RawMessage `json:"frame,omitempty"`
+	Redacted bool            `json:"redacted,omitempty"` // events of other users in it were redacted
+}
+
+// Summary describes a capture for listings
+type Summary struct {
+	Header
+	Frames    int
+	Duration  time.Duration
+	Size      int64
+	Truncated bool
+	Active    bool // the connection is still open
+}
+
+// Captures records the websocket frames of connections that opted in with a
+// debug token, one JSONL file per connection, so client interop bugs can be
+// replayed frame by frame. Events of anyone but the pubkey the client
+// authenticated as are redacted before they are written, and captures are
+// removed once they are older than the retention.
+type Captures struct {
+	dir       string
+	retention time.Duration
+	maxFrames int
+
+	mu     sync.Mutex
+	tokens map[string]*Token
+	active map[string]bool
+}
+
+func New(dir string, retention time.Duration, maxFrames int) (*Captures, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	return &Captures{
+		dir:       dir,
+		retention: retention,
+		maxFrames: maxFrames,
+		tokens:    make(map[string]*Token),
+		active:    make(map[string]bool),
+	}, nil
+}
+
+// Issue creates a debug token valid for ttl, at most MaxTokenTTL
+func (c *Captures) Issue(note, actor string, ttl time.Duration) (*Token, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	t := &Token{
+		Token:     hex.EncodeToString(raw),
+		Note:      note,
+		CreatedBy: actor,
+		CreatedAt: now,
+		ExpiresAt: now.Add(min(ttl, MaxTokenTTL)),
+	}
+
+	c.mu.Lock()
+	c.tokens[t.Token] = t
+	c.mu.Unlock()
+	issued := *t
+	return &issued, nil
+}
+
+// Tokens returns the tokens that haven't expired, newest first
+func (c *Captures) Tokens() []Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	tokens := make([]Token, 0, len(c.tokens))
+	for _, t := range c.tokens {
+		if now.Before(t.ExpiresAt) {
+			tokens = append(tokens, *t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens
+}
+
+// Revoke stops a token from starting captures, reporting whether it existed
+func (c *Captures) Revoke(token string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.tokens[token]
+	delete(c.tokens, token)
+	return ok
+}
+
+// claim returns the token a new capture is started with, or nil when the
+// token is unknown, expired or used up
+func (c *Captures) claim(token string) *Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.tokens[token]
+	if t == nil || time.Now().After(t.ExpiresAt) || t.Captures >= maxCapturesPerToken {
+		return nil
+	}
+	t.Captures++
+	claimed := *t
+	return &claimed
+}
+
+// Wrap captures the websocket connections next upgrades when they are opened
+// with a valid ?debug=<token>. Other requests, and connections with an
+// unknown or expired token, are served as usual.
+func (c *Captures) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("debug")
+		if token == "" || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next(w, r)
+			return
+		}
+		t := c.claim(token)
+		if t == nil {
+			next(w, r)
+			return
+		}
+
+		next(&hijackRecorder{ResponseWriter: w, hijacked: func(conn net.Conn) net.Conn {
+			s, err := c.begin(t, r)
+			if err != nil {
+				log.Printf("Capture: failed to start capture for token %q: %v", t.Note, err)
+				return conn
+			}
+			return &capturedConn{Conn: conn, session: s}
+		}}, r)
+	}
+}
+
+// begin opens the file of a new capture and writes its header
+func (c *Captures) begin(t *Token, r *http.Request) (*session, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	id := start.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+
+	f, err := os.OpenFile(c.path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	header := Header{
+		ID:        id,
+		Note:      t.Note,
+		CreatedBy: t.CreatedBy,
+		UserAgent: r.UserAgent(),
+		Origin:    r.Header.Get("Origin"),
+		StartedAt: start.Unix(),
+	}
+	line, _ := json.Marshal(header)
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.active[id] = true
+	c.mu.Unlock()
+	log.Printf("Capture: recording connection as %s (%s)", id, t.Note)
+
+	return &session{
+		captures: c,
+		id:       id,
+		file:     f,
+		start:    start,
+		in:       newWSFrameParser(true, maxMessageBytes),
+		out:      newWSFrameParser(false, maxMessageBytes),
+	}, nil
+}
+
+func (c *Captures) path(id string) string {
+	return filepath.Join(c.dir, id+".jsonl")
+}
+
+// List returns every capture on disk, newest first
+func (c *Captures) List() ([]Summary, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var summaries []Summary
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if !ok || !idPattern.MatchString(id) {
+			continue
+		}
+		header, frames, err := c.Read(id)
+		if err != nil {
+			continue
+		}
+		s := Summary{Header: *header, Frames: len(frames)}
+		if info, err := entry.Info(); err == nil {
+			s.Size = info.Size()
+		}
+		if len(frames) > 0 {
+			last := frames[len(frames)-1]
+			s.Duration = time.Duration(last.T) * time.Millisecond
+			s.Truncated = last.Type == "truncated"
+			if s.Truncated {
+				s.Frames--
+			}
+		}
+		c.mu.Lock()
+		s.Active = c.active[id]
+		c.mu.Unlock()
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].ID > summaries[j].ID })
+	return summaries, nil
+}
+
+// Read returns the header and frames of a capture
+func (c *Captures) Read(id string) (*Header, []Frame, error) {
+	f, err := c.Open(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 2*maxMessageBytes)
+	if !scanner.Scan() {
+		return nil, nil, fmt.Errorf("capture %s has no header", id)
+	}
+	var header Header
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, nil, err
+	}
+
+	var frames []Frame
+	for scanner.Scan() {
+		var frame Frame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			// The last line of a capture still being written may be partial
+			break
+		}
+		frames = append(frames, frame)
+	}
+	return &header, frames, scanner.Err()
+}
+
+// Open returns the raw capture file, for downloads
+func (c *Captures) Open(id string) (*os.File, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(c.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes a capture. A capture still recording stops being written.
+func (c *Captures) Delete(id string) error {
+	if !idPattern.MatchString(id) {
+		return ErrNotFound
+	}
+	err := os.Remove(c.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Start removes expired captures and tokens every hour
+func (c *Captures) Start(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.sweep()
+		}
+	}
+}
+
+func (c *Captures) sweep() {
+	now := time.Now()
+	c.mu.Lock()
+	for token, t := range c.tokens {
+		if now.After(t.ExpiresAt) {
+			delete(c.tokens, token)
+		}
+	}
+	c.mu.Unlock()
+
+	summaries, err := c.List()
+	if err != nil {
+		log.Printf("Capture: failed to list captures: %v", err)
+		return
+	}
+	removed := 0
+	for _, s := range summaries {
+		if !s.Active && now.Sub(time.Unix(s.StartedAt, 0)) > c.retention && c.Delete(s.ID) == nil {
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("Capture: removed %d expired captures", removed)
+	}
+}
+
+// hijackRecorder hands the connection of a websocket upgrade to hijacked
+type hijackRecorder struct {
+	http.ResponseWriter
+	hijacked func(net.Conn) net.Conn
+}
+
+func (w *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped := w.hijacked(conn)
+	// Upgraders can keep reading through brw, which reads conn directly: read
+	// what it already buffered, then the wrapped connection
+	buffered, _ := brw.Reader.Peek(brw.Reader.Buffered())
+	brw.Reader = bufio.NewReaderSize(io.MultiReader(bytes.NewReader(buffered), wrapped), brw.Reader.Size())
+	return wrapped, brw, nil
+}
+
+func (w *hijackRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// capturedConn passes everything read and written through its session
+type capturedConn struct {
+	net.Conn
+	session   *session
+	closeOnce sync.Once
+}
+
+func (cc *capturedConn) Read(b []byte) (int, error) {
+	n, err := cc.Conn.Read(b)
+	if n > 0 {
+		cc.session.observe(true, b[:n])
+	}
+	return n, err
+}
+
+func (cc *capturedConn) Write(b []byte) (int, error) {
+	n, err := cc.Conn.Write(b)
+	if n > 0 {
+		cc.session.observe(false, b[:n])
+	}
+	return n, err
+}
+
+func (cc *capturedConn) Close() error {
+	err := cc.Conn.Close()
+	cc.closeOnce.Do(cc.session.finish)
+	return err
+}
+
+// session is the capture of one connection
+type session struct {
+	captures *Captures
+	id       string
+	start    time.Time
+
+	mu      sync.Mutex
+	file    *os.File // nil once recording stopped
+	in, out *wsFrameParser
+	frames  int
+	owner   string // pubkey the client authenticated as, whose events aren't redacted
+}
+
+func (s *session) observe(in bool, b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return
+	}
+
+	parser, dir := s.out, "out"
+	if in {
+		parser, dir = s.in, "in"
+	}
+	err := parser.feed(b, func(opcode byte, payload []byte) {
+		if s.file != nil {
+			s.record(dir, opcode, payload)
+		}
+	})
+	if err != nil && s.file != nil {
+		s.stop(err.Error())
+	}
+}
+
+// record writes one message; the caller holds s.mu
+func (s *session) record(dir string, opcode byte, payload []byte) {
+	frame := Frame{T: time.Since(s.start).Milliseconds(), Dir: dir}
+	if opcode == wsOpClose {
+		frame.Type = "close"
+		frame.Frame = closeFrame(payload)
+	} else {
+		frame.Type, frame.Frame, frame.Redacted = s.envelope(payload)
+	}
+	s.write(frame)
+
+	s.frames++
+	if s.frames >= s.captures.maxFrames {
+		s.stop(fmt.Sprintf("reached %d frames", s.captures.maxFrames))
+	}
+}
+
+// envelope labels a message and redacts the events of other users in it
+func (s *session) envelope(payload []byte) (string, json.RawMessage, bool) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(payload, &elements); err != nil || len(elements) == 0 {
+		quoted, _ := json.Marshal(string(payload))
+		return "invalid", quoted, false
+	}
+	var label string
+	if err := json.Unmarshal(elements[0], &label); err != nil {
+		return "invalid", json.RawMessage(payload), false
+	}
+
+	redacted := false
+	for i := 1; i < len(elements); i++ {
+		if len(elements[i]) == 0 || elements[i][0] != '{' {
+			continue
+		}
+		var evt nostr.Event
+		if err := json.Unmarshal(elements[i], &evt); err != nil || evt.PubKey == "" || evt.Sig == "" {
+			continue
+		}
+
+		switch {
+		case label == "AUTH":
+			// The client's own identity; the signature would let it be replayed
+			s.owner = evt.PubKey
+			evt.Sig = redactedValue
+		case evt.PubKey == s.owner:
+			continue
+		default:
+			evt.Content = fmt.Sprintf("[redacted %d bytes]", len(evt.Content))
+			for _, tag := range evt.Tags {
+				for j := 1; j < len(tag); j++ {
+					tag[j] = redactedValue
+				}
+			}
+			evt.Sig = redactedValue
+		}
+		if b, err := json.Marshal(evt); err == nil {
+			elements[i] = b
+			redacted = true
+		}
+	}
+
+	if !redacted {
+		return label, json.RawMessage(payload), false
+	}
+	b, _ := json.Marshal(elements)
+	return label, b, true
+}
+
+// closeFrame describes a websocket close frame's status code and reason
+func closeFrame(payload []byte) json.RawMessage {
+	status := struct {
+		Code   int    `json:"code,omitempty"`
+		Reason string `json:"reason,omitempty"`
+	}{}
+	if len(payload) >= 2 {
+		status.Code = int(binary.BigEndian.Uint16(payload))
+		status.Reason = string(payload[2:])
+	}
+	b, _ := json.Marshal(status)
+	return b
+}
+
+func (s *session) write(frame Frame) {
+	line, err := json.Marshal(frame)
+	if err != nil {
+		return
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Capture: failed to write %s: %v", s.id, err)
+		s.file.Close()
+		s.file = nil
+	}
+}
+
+// stop ends recording early, noting why; the caller holds s.mu
+func (s *session) stop(reason string) {
+	reasonJSON, _ := json.Marshal(reason)
+	s.write(Frame{T: time.Since(s.start).Milliseconds(), Type: "truncated", Frame: reasonJSON})
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	s.in, s.out = nil, nil
+}
+
+func (s *session) finish() {
+	s.mu.Lock()
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	s.mu.Unlock()
+
+	s.captures.mu.Lock()
+	delete(s.captures.active, s.id)
+	s.captures.mu.Unlock()
+	log.Printf("Capture: %s finished with %d frames", s.id, s.frames)
+}
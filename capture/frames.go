@@ -0,0 +1,157 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Websocket opcodes (RFC 6455 section 5.2)
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpClose        = 0x8
+)
+
+// errFrameTooLarge stops parsing a stream whose messages outgrow the parser's buffer
+var errFrameTooLarge = errors.New("websocket message too large to capture")
+
+// wsFrameParser reassembles websocket messages from one direction of a raw
+// connection, fed the bytes as they are read or written. It only observes:
+// the bytes themselves go to the connection untouched.
+type wsFrameParser struct {
+	masked     bool // client to server frames are masked
+	skipHeader bool // server to client bytes start with the HTTP upgrade response
+	maxBytes   int
+
+	buf     []byte
+	message []byte // fragments of the message being reassembled
+	opcode  byte   // opcode of the message being reassembled
+}
+
+func newWSFrameParser(clientToServer bool, maxBytes int) *wsFrameParser {
+	return &wsFrameParser{masked: clientToServer, skipHeader: !clientToServer, maxBytes: maxBytes}
+}
+
+// feed consumes p and calls emit for every complete text message, and for
+// close frames with their payload. Once it returns an error the stream can't
+// be followed anymore and further bytes should not be fed.
+func (p *wsFrameParser) feed(b []byte, emit func(opcode byte, payload []byte)) error {
+	p.buf = append(p.buf, b...)
+
+	if p.skipHeader {
+		end := bytes.Index(p.buf, []byte("\r\n\r\n"))
+		if end < 0 {
+			if len(p.buf) > 8192 {
+				return errors.New("no end to the upgrade response")
+			}
+			return nil
+		}
+		p.buf = p.buf[end+4:]
+		p.skipHeader = false
+	}
+
+	for {
+		n, err := p.frame(emit)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		p.buf = p.buf[n:]
+	}
+
+	if len(p.buf) > p.maxBytes {
+		return errFrameTooLarge
+	}
+	// Don't keep a large backing array alive for a few leftover bytes
+	if len(p.buf) == 0 {
+		p.buf = nil
+	}
+	return nil
+}
+
+// frame parses the frame at the start of the buffer, returning how many bytes
+// it took, or 0 when the buffer doesn't hold a whole frame yet
+func (p *wsFrameParser) frame(emit func(opcode byte, payload []byte)) (int, error) {
+	if len(p.buf) < 2 {
+		return 0, nil
+	}
+	fin := p.buf[0]&0x80 != 0
+	opcode := p.buf[0] & 0x0f
+	masked := p.buf[1]&0x80 != 0
+	length := uint64(p.buf[1] & 0x7f)
+	pos := 2
+
+	switch length {
+	case 126:
+		if len(p.buf) < pos+2 {
+			return 0, nil
+		}
+		length = uint64(binary.BigEndian.Uint16(p.buf[pos:]))
+		pos += 2
+	case 127:
+		if len(p.buf) < pos+8 {
+			return 0, nil
+		}
+		length = binary.BigEndian.Uint64(p.buf[pos:])
+		pos += 8
+	}
+	if length > uint64(p.maxBytes) {
+		return 0, errFrameTooLarge
+	}
+	if masked != p.masked {
+		return 0, errors.New("unexpected frame masking")
+	}
+
+	var mask [4]byte
+	if masked {
+		if len(p.buf) < pos+4 {
+			return 0, nil
+		}
+		copy(mask[:], p.buf[pos:pos+4])
+		pos += 4
+	}
+	if uint64(len(p.buf)-pos) < length {
+		return 0, nil
+	}
+
+	payload := make([]byte, length)
+	copy(payload, p.buf[pos:pos+int(length)])
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	n := pos + int(length)
+
+	switch {
+	case opcode >= wsOpClose:
+		// Control frames are never fragmented and may come between fragments
+		if opcode == wsOpClose {
+			emit(opcode, payload)
+		}
+	case opcode == wsOpContinuation:
+		p.message = append(p.message, payload...)
+		if len(p.message) > p.maxBytes {
+			return 0, errFrameTooLarge
+		}
+		if fin {
+			if p.opcode == wsOpText {
+				emit(p.opcode, p.message)
+			}
+			p.message = nil
+		}
+	default:
+		if fin {
+			if opcode == wsOpText {
+				emit(opcode, payload)
+			}
+		} else {
+			p.opcode = opcode
+			p.message = payload
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,178 @@
+package changelog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// milestoneKinds are the dataset counts milestones are recorded for
+var milestoneKinds = []struct {
+	kind int
+	noun string
+}{
+	{0, "profiles"},
+	{3, "contact lists"},
+	{10002, "relay lists"},
+}
+
+// minMilestone is the smallest count worth a changelog entry
+const minMilestone = 1000
+
+// Changelog records policy changes and dataset milestones, and publishes them
+// as kind 1 notes from the relay key when one is configured
+type Changelog struct {
+	storage *storage.Storage
+	key     string // hex secret key notes are signed with, empty to not publish
+}
+
+func New(store *storage.Storage, key string) *Changelog {
+	return &Changelog{storage: store, key: key}
+}
+
+// CanPublish reports whether entries can be published as notes
+func (c *Changelog) CanPublish() bool {
+	return c.key != ""
+}
+
+// Record appends an entry written by the operator, publishing it as a note if asked
+func (c *Changelog) Record(ctx context.Context, title, body, actor string, publish bool) (*storage.ChangelogEntry, error) {
+	e := storage.ChangelogEntry{
+		Category:  storage.ChangelogPolicy,
+		Title:     title,
+		Body:      body,
+		CreatedBy: actor,
+		CreatedAt: time.Now(),
+	}
+	id, err := c.storage.AddChangelogEntry(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	e.ID = id
+
+	if publish {
+		if err := c.publish(ctx, &e); err != nil {
+			return &e, fmt.Errorf("recorded, but publishing failed: %w", err)
+		}
+	}
+	return &e, nil
+}
+
+// Remove deletes an entry and the note it was published as, reporting whether it existed
+func (c *Changelog) Remove(ctx context.Context, id int64) (bool, error) {
+	e, err := c.storage.DeleteChangelogEntry(ctx, id)
+	if err != nil || e == nil {
+		return false, err
+	}
+	if e.NoteID != "" {
+		notes, err := c.storage.QueryEvents(ctx, nostr.Filter{IDs: []string{e.NoteID}})
+		if err == nil && len(notes) > 0 {
+			err = c.storage.DeleteEvent(ctx, notes[0])
+		}
+		if err != nil {
+			log.Printf("changelog: failed to delete note %s of entry %d: %v", e.NoteID, id, err)
+		}
+	}
+	return true, nil
+}
+
+// CheckMilestones appends an entry for each dataset count that reached a new
+// milestone: 1, 2.5 and 5 times a power of ten. Only the highest milestone
+// reached is recorded, so a relay starting with a large dataset gets one entry
+// per count rather than one per milestone it already passed.
+func (c *Changelog) CheckMilestones(ctx context.Context) error {
+	for _, m := range milestoneKinds {
+		count, err := c.storage.CountEventsByKind(ctx, m.kind)
+		if err != nil {
+			return fmt.Errorf("failed to count kind %d: %w", m.kind, err)
+		}
+		threshold := milestoneBelow(count)
+		if threshold == 0 {
+			continue
+		}
+
+		e := storage.ChangelogEntry{
+			Category:  storage.ChangelogMilestone,
+			Title:     fmt.Sprintf("%s %s stored", formatCount(threshold), m.noun),
+			Body:      fmt.Sprintf("The relay now stores %d %s.", count, m.noun),
+			Milestone: fmt.Sprintf("kind%d:%d", m.kind, threshold),
+			CreatedAt: time.Now(),
+		}
+		id, err := c.storage.AddChangelogEntry(ctx, e)
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			continue // already recorded
+		}
+		e.ID = id
+		log.Printf("changelog: milestone %q reached", e.Title)
+
+		if c.CanPublish() {
+			if err := c.publish(ctx, &e); err != nil {
+				log.Printf("changelog: failed to publish milestone %q: %v", e.Title, err)
+			}
+		}
+	}
+	return nil
+}
+
+// publish signs the entry as a kind 1 note and stores it, so clients following
+// the relay key see it
+func (c *Changelog) publish(ctx context.Context, e *storage.ChangelogEntry) error {
+	if c.key == "" {
+		return fmt.Errorf("no changelog.key configured")
+	}
+
+	content := e.Title
+	if e.Body != "" {
+		content += "\n\n" + e.Body
+	}
+	note := &nostr.Event{
+		Kind:      1,
+		CreatedAt: nostr.Timestamp(e.CreatedAt.Unix()),
+		Tags:      nostr.Tags{{"t", "changelog"}, {"t", e.Category}},
+		Content:   content,
+	}
+	if err := note.Sign(c.key); err != nil {
+		return err
+	}
+	if err := c.storage.SaveEvent(ctx, note); err != nil {
+		return err
+	}
+	e.NoteID = note.ID
+	return c.storage.SetChangelogNote(ctx, e.ID, note.ID)
+}
+
+// milestoneBelow returns the highest milestone count reaches, 0 under minMilestone
+func milestoneBelow(count int64) int64 {
+	var best int64
+	for power := int64(minMilestone); power <= count; power *= 10 {
+		for _, step := range []int64{power, power * 5 / 2, power * 5} {
+			if step <= count {
+				best = step
+			}
+		}
+	}
+	return best
+}
+
+// formatCount shortens a milestone for titles: 2500 -> 2.5k, 10000000 -> 10M
+func formatCount(n int64) string {
+	for _, unit := range []struct {
+		size   int64
+		suffix string
+	}{{1_000_000_000, "B"}, {1_000_000, "M"}, {1_000, "k"}} {
+		if n >= unit.size {
+			s := strconv.FormatFloat(float64(n)/float64(unit.size), 'f', 1, 64)
+			return strings.TrimSuffix(s, ".0") + unit.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/pablof7z/purplepag.es/api"
+)
+
+// clientIP is the address of the client on ctx's connection. Unlike
+// khatru.GetIP, X-Forwarded-For is only followed through trusted proxies, so
+// clients can't pick the IP they are counted and throttled under.
+func clientIP(ctx context.Context) string {
+	conn := khatru.GetConnection(ctx)
+	if conn == nil || conn.Request == nil {
+		return ""
+	}
+	return api.ClientIP(conn.Request)
+}
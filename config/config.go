@@ -3,9 +3,13 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type RelayInfo struct {
@@ -22,6 +26,10 @@ type RelayInfo struct {
 type ServerConfig struct {
 	Host string `json:"host"`
 	Port int    `json:"port"`
+	// IPs or CIDRs of reverse proxies whose X-Forwarded-* headers are believed
+	TrustedProxies []string `json:"trusted_proxies"`
+	// The relay's public websocket URL, which NIP-42 auth events must name
+	PublicURL string `json:"public_url"`
 }
 
 type StorageConfig struct {
@@ -29,38 +37,263 @@ type StorageConfig struct {
 	Path           string `json:"path"`
 	ArchiveEnabled *bool  `json:"archive_enabled"`
 	AnalyticsDBURL string `json:"analytics_db_url"` // Optional: separate PostgreSQL for analytics
+	ScanChunkSize  int    `json:"scan_chunk_size"`  // Events per read transaction during full scans
+	ReadDBURL      string `json:"read_db_url"`      // Optional: database for analytics reads, e.g. a replica
+	MaxReadConns   int    `json:"max_read_conns"`   // Read-only pool size, 0 to share the write pool
+	MaxWriteConns  int    `json:"max_write_conns"`
+	// Optional: directory of daily event log segments for `purplepages replay-log`
+	EventLogDir           string `json:"event_log_dir"`
+	EventLogRetentionDays int    `json:"event_log_retention_days"`
 }
 
 type SyncConfig struct {
 	Enabled bool     `json:"enabled"`
 	Relays  []string `json:"relays"`
 	Kinds   []int    `json:"kinds"`
+	// Hex or nsec secret key answering NIP-42 challenges from upstream relays during
+	// sync, hydration and trusted sync; relay_auth_keys overrides it per relay URL
+	AuthKey       string            `json:"auth_key"`
+	RelayAuthKeys map[string]string `json:"relay_auth_keys"`
+	// Startup is async (serve while the initial sync runs) or gated (/readyz
+	// fails until ready_after_events events are saved or ready_after_relays
+	// relays finish; with neither set, until the whole sync finishes)
+	Startup          string `json:"startup"`
+	ReadyAfterEvents int64  `json:"ready_after_events"`
+	ReadyAfterRelays int    `json:"ready_after_relays"`
 }
 
+// Startup modes for the initial sync
+const (
+	SyncStartupAsync = "async" // ready immediately, sync in the background
+	SyncStartupGated = "gated" // not ready until the initial sync reaches its threshold
+)
+
 type ProfileHydrationConfig struct {
 	Enabled         bool `json:"enabled"`
 	MinFollowers    int  `json:"min_followers"`
 	RetryAfterHours int  `json:"retry_after_hours"`
 	IntervalMinutes int  `json:"interval_minutes"`
-	BatchSize       int  `json:"batch_size"`
+	BatchSize       int  `json:"batch_size"`     // size of the first batch; later ones adapt to upstream latency and errors
+	MinBatchSize    int  `json:"min_batch_size"` // smallest a batch shrinks to while upstreams struggle
+	MaxBatchSize    int  `json:"max_batch_size"`
+	DeadAfterRounds int  `json:"dead_after_rounds"` // consecutive rounds with nothing from any relay
+	DeadRetryDays   int  `json:"dead_retry_days"`
+}
+
+// NegentropyPeerConfig is a relay the running relay reconciles with over NIP-77 on a schedule
+type NegentropyPeerConfig struct {
+	// Relay URL; peers running purplepag.es take a partner token as ?sync_token=
+	URL             string `json:"url"`
+	Direction       string `json:"direction"` // down (pull, default), up (push) or both
+	Kinds           []int  `json:"kinds"`     // defaults to sync_kinds
+	IntervalMinutes int    `json:"interval_minutes"`
 }
 
+// Directions of a negentropy peer
+const (
+	NegentropyDown = "down"
+	NegentropyUp   = "up"
+	NegentropyBoth = "both"
+)
+
 type TrustedSyncConfig struct {
 	Disabled        bool  `json:"disabled"` // disabled instead of enabled, so default (false) means enabled
 	IntervalMinutes int   `json:"interval_minutes"`
 	BatchSize       int   `json:"batch_size"`
 	Kinds           []int `json:"kinds"`
 	TimeoutSeconds  int   `json:"timeout_seconds"`
+	// Deprecated: use sync.auth_key, which this is used as when that is unset
+	AuthKey string `json:"auth_key"`
 }
 
 type LimitsConfig struct {
 	MaxSubscriptions    int `json:"max_subscriptions"`
-	MaxFilters          int `json:"max_filters"`
+	MaxFilters          int `json:"max_filters"` // per REQ
+	// Authors and ids a single filter may list
+	MaxAuthors          int `json:"max_authors"`
+	MaxIDs              int `json:"max_ids"`
 	MaxLimit            int `json:"max_limit"`
 	MaxEventTags        int `json:"max_event_tags"`
 	MaxContentLength    int `json:"max_content_length"`
+	// Largest websocket message accepted, after reassembling fragments; bigger
+	// messages close the connection before anything is parsed
+	MaxMessageBytes     int `json:"max_message_bytes"`
 	EventsPerDayLimit   int `json:"events_per_day_limit"`
 	MinTrustedFollowers int `json:"min_trusted_followers"`
+	// Accepted events per publishing pubkey per UTC day
+	PubkeyEventsPerDay        int `json:"pubkey_events_per_day"`
+	TrustedPubkeyEventsPerDay int `json:"trusted_pubkey_events_per_day"`
+	// How long a REQ's stored-event query may run before EOSE is sent with whatever was found
+	QueryTimeoutMs int `json:"query_timeout_ms"`
+	// Weighted spam/impersonation reports that make an untrusted pubkey a spam
+	// candidate; a report from a trusted pubkey weighs 1
+	MinReportScore float64 `json:"min_report_score"`
+}
+
+type ScraperDetectionConfig struct {
+	Disabled              bool `json:"disabled"`
+	SingleAuthorPerMinute int  `json:"single_author_per_minute"`
+	WindowWalksPerMinute  int  `json:"window_walks_per_minute"`
+	Throttle              bool `json:"throttle"`
+	ThrottleMinutes       int  `json:"throttle_minutes"`
+}
+
+type PrefetchConfig struct {
+	Disabled   bool `json:"disabled"`
+	Fanout     int  `json:"fanout"`      // likely-next pubkeys prefetched per lookup
+	TTLMinutes int  `json:"ttl_minutes"` // how long a prefetch can still count as a hit
+	// Most requested pubkeys whose kind 0/3/10002 events are kept in memory; -1 disables
+	BundlePubkeys int `json:"bundle_pubkeys"`
+	BundleMB      int `json:"bundle_mb"` // memory the bundles may take
+}
+
+// BackgroundLoadConfig sets when the relay counts as busy with interactive
+// traffic, so heavy background jobs hold off
+type BackgroundLoadConfig struct {
+	Disabled          bool `json:"disabled"`
+	MaxConnections    int  `json:"max_connections"`      // open websocket connections
+	MaxQueryLatencyMs int  `json:"max_query_latency_ms"` // mean REQ storage query time
+	MaxDeferMinutes   int  `json:"max_defer_minutes"`    // longest a scheduled run waits for quiet
+}
+
+type ImpersonationConfig struct {
+	Disabled           bool   `json:"disabled"`
+	MinTargetFollowers int    `json:"min_target_followers"` // profiles this followed can be impersonated
+	MaxFollowers       int    `json:"max_followers"`        // impersonators have at most this many
+	Policy             string `json:"policy"`
+	LabelKey           string `json:"label_key"` // hex or nsec key signing labels under the label policy
+}
+
+// AnnotationsConfig serves NIP-32 labels about pubkeys, built from the analytics
+// tables, to clients whose REQ filter opts in with the annotation namespace in "#L"
+type AnnotationsConfig struct {
+	Enabled bool   `json:"enabled"`
+	Key     string `json:"key"` // hex or nsec key signing the annotations
+}
+
+// PrivacyConfig controls what analytics keep about clients
+type PrivacyConfig struct {
+	// HashIPs stores client IPs as HMACs under a daily rotating salt instead of raw
+	HashIPs bool `json:"hash_ips"`
+	// ListMuters names who mutes a pubkey on /api/v1/mutes/{pubkey} instead of only counting them
+	ListMuters bool `json:"list_muters"`
+}
+
+// ImageProxyConfig controls the proxy pages load profile pictures through
+type ImageProxyConfig struct {
+	Disabled bool   `json:"disabled"` // link pictures directly, as profiles give them
+	CacheDir string `json:"cache_dir"`
+	MaxBytes int64  `json:"max_bytes"` // larger pictures are not served
+	TTLHours int    `json:"ttl_hours"` // how long a cached picture is served before it is fetched again
+	// Secret signing proxied URLs; when empty a key is generated once and kept in cache_dir
+	Key string `json:"key"`
+}
+
+// GeoIPConfig points at the country database REQ volume is broken down by on /stats/network
+type GeoIPConfig struct {
+	// CSV of IP ranges and country codes: DB-IP IP to Country Lite or IP2Location LITE DB1
+	Database string `json:"database"`
+}
+
+// ChangelogConfig controls publishing the public changelog as notes
+type ChangelogConfig struct {
+	// Hex or nsec key of relay.pubkey, signing kind 1 notes of changelog entries; off when empty
+	Key string `json:"key"`
+}
+
+// SessionCaptureConfig controls recording the websocket frames of connections
+// opened with a debug token issued at /stats/captures
+type SessionCaptureConfig struct {
+	Disabled       bool   `json:"disabled"`
+	Dir            string `json:"dir"`
+	RetentionHours int    `json:"retention_hours"` // captures older than this are removed
+	MaxFrames      int    `json:"max_frames"`      // frames recorded per connection before the capture stops
+}
+
+// ProfilingConfig exposes pprof and captures heap profiles when memory runs high
+type ProfilingConfig struct {
+	// Admin listener serving /debug/pprof, e.g. 127.0.0.1:6060; off when empty.
+	// It has no authentication, so keep it off public interfaces.
+	Listen string `json:"listen"`
+	// Resident memory, in MB, above which a heap profile is written to dir; 0 disables
+	HeapThresholdMB int    `json:"heap_threshold_mb"`
+	Dir             string `json:"dir"`
+	Keep            int    `json:"keep"` // captured profiles kept per process, oldest removed first
+}
+
+// MirrorConfig replays a sample of client traffic against a staging relay. Off
+// unless url is set.
+type MirrorConfig struct {
+	URL           string  `json:"url"`            // staging relay, e.g. wss://staging.example.com
+	SamplePercent float64 `json:"sample_percent"` // share of REQs and EVENTs mirrored
+	QueueSize     int     `json:"queue_size"`     // frames waiting for staging before new ones are dropped
+}
+
+// OriginPolicyConfig checks the Origin header browsers send when opening a
+// websocket. Native clients send none and are never refused. Patterns match the
+// whole origin, with * standing for any run of characters, e.g. "https://*.example.com".
+type OriginPolicyConfig struct {
+	Allow []string `json:"allow"` // when set, only origins matching one of these may connect
+	Deny  []string `json:"deny"`  // origins matching any of these are refused, even if allowed
+}
+
+// Heavyweight subsystems a deployment can switch off, in config or at runtime
+// through /api/v1/admin/features
+const (
+	FeatureAnalytics   = "analytics"    // REQ analytics tracking
+	FeatureArchive     = "archive"      // archiving replaced versions for /timecapsule
+	FeatureCommunities = "communities"  // community detection in the analytics worker
+	FeatureHydrator    = "hydrator"     // profile hydration
+	FeatureTrustedSync = "trusted_sync" // syncing trusted pubkeys from their write relays
+)
+
+// FeatureNames lists every feature, all on unless configured otherwise
+var FeatureNames = []string{FeatureAnalytics, FeatureArchive, FeatureCommunities, FeatureHydrator, FeatureTrustedSync}
+
+// Impersonation policies, applied to flagged profiles in query responses
+const (
+	ImpersonationFlag  = "flag"  // listed on /stats/impersonation only
+	ImpersonationHide  = "hide"  // kind 0 withheld from REQ responses
+	ImpersonationLabel = "label" // NIP-32 labels served on kind 1985 REQs
+)
+
+// APIConfig limits the profile JSON endpoints per IP, or per API key for clients
+// sending one as "Authorization: Bearer <key>" or X-API-Key
+type APIConfig struct {
+	RequestsPerMinute int                     `json:"requests_per_minute"`
+	Burst             int                     `json:"burst"`
+	Keys              map[string]APIKeyConfig `json:"keys"` // API key -> allowance
+}
+
+type APIKeyConfig struct {
+	Name              string `json:"name"`
+	RequestsPerMinute int    `json:"requests_per_minute"`
+	Burst             int    `json:"burst"`
+}
+
+// BillingConfig sells premium API keys, paid to the operator's wallet through
+// Nostr Wallet Connect. Off unless nwc_uri is set.
+type BillingConfig struct {
+	NWCURI            string `json:"nwc_uri"` // nostr+walletconnect:// URI allowed to make and look up invoices
+	PriceSats         int64  `json:"price_sats"`
+	DurationDays      int    `json:"duration_days"`
+	RequestsPerMinute int    `json:"requests_per_minute"`
+	Burst             int    `json:"burst"`
+}
+
+// PartnersConfig issues sync tokens to partner relays mirroring this one. Tokens
+// are signed with token_secret; partner tokens are off unless it is set.
+type PartnersConfig struct {
+	TokenSecret string `json:"token_secret"`
+	// NIP-77 negentropy sessions one IP may open per hour without a token; -1 keeps negentropy partner-only
+	NegentropyPerHour int `json:"negentropy_per_hour"`
+	// Negentropy sessions a partner may open per hour
+	PartnerNegentropyPerHour int `json:"partner_negentropy_per_hour"`
+	// JSON API allowance for requests sending a partner token as their API key
+	RequestsPerMinute int `json:"requests_per_minute"`
+	Burst             int `json:"burst"`
+	// Refuse /api/v1/snapshot to clients without a partner token
+	SnapshotPartnersOnly bool `json:"snapshot_partners_only"`
 }
 
 // KindRange represents either a single kind or a range of kinds
@@ -159,10 +392,74 @@ type Config struct {
 	Sync             SyncConfig             `json:"sync"`
 	ProfileHydration ProfileHydrationConfig `json:"profile_hydration"`
 	TrustedSync      TrustedSyncConfig      `json:"trusted_sync"`
+	NegentropyPeers  []NegentropyPeerConfig `json:"negentropy_peers"`
 	Limits           LimitsConfig           `json:"limits"`
+	ScraperDetection ScraperDetectionConfig `json:"scraper_detection"`
+	Prefetch         PrefetchConfig         `json:"prefetch"`
+	BackgroundLoad   BackgroundLoadConfig   `json:"background_load"`
+	API              APIConfig              `json:"api"`
+	Impersonation    ImpersonationConfig    `json:"impersonation"`
+	Annotations      AnnotationsConfig      `json:"annotations"`
+	Billing          BillingConfig          `json:"billing"`
+	Partners         PartnersConfig         `json:"partners"`
+	Privacy          PrivacyConfig          `json:"privacy"`
+	Mirror           MirrorConfig           `json:"mirror"`
+	OriginPolicy     OriginPolicyConfig     `json:"origin_policy"`
+	ImageProxy       ImageProxyConfig       `json:"image_proxy"`
+	Profiling        ProfilingConfig        `json:"profiling"`
+	GeoIP            GeoIPConfig            `json:"geoip"`
+	Changelog        ChangelogConfig        `json:"changelog"`
+	SessionCapture   SessionCaptureConfig   `json:"session_capture"`
 	StatsPassword    string                 `json:"stats_password"`
+	// Directory of <page>.html files overriding the built-in templates, re-read when they change
+	TemplatesDir string `json:"templates_dir"`
+	// Load Chart.js and D3 from their public CDNs instead of the copies served at /static
+	AssetsCDN bool `json:"assets_cdn"`
+	// Per-kind serving policy, e.g. {"10000": "author_only"}; unlisted kinds are public
+	KindPrivacy map[string]string `json:"kind_privacy"`
+	// Per-kind handling of filters matching more than limits.max_limit events, e.g. {"3": "trusted_first"}
+	OversizeFilters map[string]string `json:"oversize_filters"`
+	// Display names of kinds, e.g. {"30078": "App Data"}, on top of DefaultKindNames;
+	// an empty name removes a default
+	KindNames map[string]string `json:"kind_names"`
+	// Days after which events of a kind their author hasn't updated are pruned
+	// unless the author is trusted, e.g. {"30000": 180, "10030": 365}
+	KindTTLDays map[string]int `json:"kind_ttl_days"`
+	// Per-kind overrides of limits.max_event_tags and limits.max_content_length,
+	// e.g. {"3": {"max_event_tags": 10000}}; unset fields keep the global limit
+	KindLimits map[string]KindLimit `json:"kind_limits"`
+	// Features switched on or off, e.g. {"communities": false}; unlisted features are on
+	Features map[string]bool `json:"features"`
+
+	kindPrivacy     map[int]string
+	oversizeFilters map[int]string
+	kindNames       map[int]string
+	kindTTLs        map[int]time.Duration
+	kindLimits      map[int]KindLimit
+	originAllow     []originPattern
+	originDeny      []originPattern
 }
 
+// KindLimit caps the events of one kind; zero fields fall back to the global limits
+type KindLimit struct {
+	MaxEventTags     int `json:"max_event_tags,omitempty"`
+	MaxContentLength int `json:"max_content_length,omitempty"`
+}
+
+// Kind privacy policies
+const (
+	PrivacyPublic     = "public"      // served to everyone
+	PrivacyAuthorOnly = "author_only" // served only to the author, authenticated with NIP-42
+	PrivacyNeverServe = "never_serve" // stored but never served
+)
+
+// Oversize filter policies, from most to least permissive
+const (
+	OversizeNewest       = "newest"        // serve the newest max_limit events
+	OversizeTrustedFirst = "trusted_first" // serve trusted authors' events first, then the newest
+	OversizeReject       = "reject"        // close the subscription with a too-many-results error
+)
+
 // DefaultSyncKinds returns the default kinds to sync (NIP-51 lists + profiles)
 func DefaultSyncKinds() []int {
 	return []int{
@@ -178,11 +475,50 @@ func DefaultSyncKinds() []int {
 		10007, // Search relays
 		10009, // Simple groups
 		10015, // Interests
+		10019, // Nutzap info
 		10030, // Emojis
 		10050, // DM relays
 	}
 }
 
+// DefaultKindNames returns the display names of the kinds purplepag.es stores by default
+func DefaultKindNames() map[int]string {
+	return map[int]string{
+		0:     "Profile",
+		3:     "Contacts",
+		10000: "Mute List",
+		10001: "Pinned Notes",
+		10002: "Relay List",
+		10003: "Bookmarks",
+		10004: "Communities",
+		10005: "Public Chats",
+		10006: "Blocked Relays",
+		10007: "Search Relays",
+		10009: "Simple Groups",
+		10012: "Relay Feeds",
+		10015: "Interests",
+		10019: "Nutzap Info",
+		10020: "Media Follows",
+		10030: "Emojis",
+		10050: "DM Relays",
+		10101: "Wiki Authors",
+		10102: "Wiki Relays",
+		30000: "Follow Sets",
+		30002: "Relay Sets",
+		30003: "Bookmark Sets",
+		30004: "Article Sets",
+		30005: "Video Sets",
+		30007: "Mute Sets",
+		30015: "Interest Sets",
+		30030: "Emoji Sets",
+		30063: "Release Sets",
+		30267: "App Sets",
+		31924: "Calendar",
+		39089: "Starter Packs",
+		39092: "Media Packs",
+	}
+}
+
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -194,6 +530,22 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
+	// A proxy on the same host is trusted unless configured otherwise
+	if cfg.Server.TrustedProxies == nil {
+		cfg.Server.TrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+	}
+	for _, proxy := range cfg.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("server.trusted_proxies: %q is not an IP or CIDR", proxy)
+		}
+	}
+
+	if cfg.Server.PublicURL != "" {
+		if err := checkRelayURL(cfg.Server.PublicURL); err != nil {
+			return nil, fmt.Errorf("server.public_url: %v", err)
+		}
+	}
+
 	// Set defaults for sync kinds
 	if len(cfg.SyncKinds) == 0 {
 		cfg.SyncKinds = DefaultSyncKinds()
@@ -204,6 +556,15 @@ func Load(path string) (*Config, error) {
 		defaultTrue := true
 		cfg.Storage.ArchiveEnabled = &defaultTrue
 	}
+	if cfg.Storage.ScanChunkSize <= 0 {
+		cfg.Storage.ScanChunkSize = 1000
+	}
+	if cfg.Storage.MaxWriteConns <= 0 {
+		cfg.Storage.MaxWriteConns = 20
+	}
+	if cfg.Storage.EventLogRetentionDays == 0 {
+		cfg.Storage.EventLogRetentionDays = 30
+	}
 
 	// Set defaults for profile hydration
 	if cfg.ProfileHydration.MinFollowers == 0 {
@@ -218,6 +579,18 @@ func Load(path string) (*Config, error) {
 	if cfg.ProfileHydration.BatchSize == 0 {
 		cfg.ProfileHydration.BatchSize = 50
 	}
+	if cfg.ProfileHydration.MinBatchSize == 0 {
+		cfg.ProfileHydration.MinBatchSize = 10
+	}
+	if cfg.ProfileHydration.MaxBatchSize == 0 {
+		cfg.ProfileHydration.MaxBatchSize = 4 * cfg.ProfileHydration.BatchSize
+	}
+	if cfg.ProfileHydration.DeadAfterRounds == 0 {
+		cfg.ProfileHydration.DeadAfterRounds = 3
+	}
+	if cfg.ProfileHydration.DeadRetryDays == 0 {
+		cfg.ProfileHydration.DeadRetryDays = 30
+	}
 
 	// Set defaults for trusted sync
 	if cfg.TrustedSync.IntervalMinutes == 0 {
@@ -233,6 +606,26 @@ func Load(path string) (*Config, error) {
 		cfg.TrustedSync.TimeoutSeconds = 30
 	}
 
+	for i := range cfg.NegentropyPeers {
+		peer := &cfg.NegentropyPeers[i]
+		if peer.URL == "" {
+			return nil, fmt.Errorf("negentropy_peers[%d]: url is required", i)
+		}
+		switch peer.Direction {
+		case "":
+			peer.Direction = NegentropyDown
+		case NegentropyDown, NegentropyUp, NegentropyBoth:
+		default:
+			return nil, fmt.Errorf("negentropy_peers[%d]: unknown direction %q (use down, up or both)", i, peer.Direction)
+		}
+		if len(peer.Kinds) == 0 {
+			peer.Kinds = cfg.SyncKinds
+		}
+		if peer.IntervalMinutes <= 0 {
+			peer.IntervalMinutes = 360
+		}
+	}
+
 	// Set defaults for limits
 	if cfg.Limits.MaxSubscriptions == 0 {
 		cfg.Limits.MaxSubscriptions = 50
@@ -240,21 +633,279 @@ func Load(path string) (*Config, error) {
 	if cfg.Limits.MaxFilters == 0 {
 		cfg.Limits.MaxFilters = 10
 	}
+	if cfg.Limits.MaxAuthors == 0 {
+		cfg.Limits.MaxAuthors = 5000
+	}
+	if cfg.Limits.MaxIDs == 0 {
+		cfg.Limits.MaxIDs = 1000
+	}
 	if cfg.Limits.MaxLimit == 0 {
 		cfg.Limits.MaxLimit = 2000
 	}
+	if cfg.Limits.QueryTimeoutMs == 0 {
+		cfg.Limits.QueryTimeoutMs = 5000
+	}
 	if cfg.Limits.MaxEventTags == 0 {
 		cfg.Limits.MaxEventTags = 2000
 	}
 	if cfg.Limits.MaxContentLength == 0 {
 		cfg.Limits.MaxContentLength = 131072
 	}
+	if cfg.Limits.MaxMessageBytes == 0 {
+		cfg.Limits.MaxMessageBytes = 262144
+	}
 	if cfg.Limits.EventsPerDayLimit == 0 {
 		cfg.Limits.EventsPerDayLimit = 50000
 	}
 	if cfg.Limits.MinTrustedFollowers == 0 {
 		cfg.Limits.MinTrustedFollowers = 1000
 	}
+	if cfg.Limits.MinReportScore == 0 {
+		cfg.Limits.MinReportScore = 3
+	}
+	if cfg.Limits.PubkeyEventsPerDay == 0 {
+		cfg.Limits.PubkeyEventsPerDay = 200
+	}
+	if cfg.Limits.TrustedPubkeyEventsPerDay == 0 {
+		cfg.Limits.TrustedPubkeyEventsPerDay = 2000
+	}
+
+	// Set defaults for scraper detection
+	if cfg.ScraperDetection.SingleAuthorPerMinute == 0 {
+		cfg.ScraperDetection.SingleAuthorPerMinute = 1000
+	}
+	if cfg.ScraperDetection.WindowWalksPerMinute == 0 {
+		cfg.ScraperDetection.WindowWalksPerMinute = 60
+	}
+	if cfg.ScraperDetection.ThrottleMinutes == 0 {
+		cfg.ScraperDetection.ThrottleMinutes = 60
+	}
+
+	if cfg.Prefetch.Fanout == 0 {
+		cfg.Prefetch.Fanout = 5
+	}
+	if cfg.Prefetch.TTLMinutes == 0 {
+		cfg.Prefetch.TTLMinutes = 10
+	}
+	if cfg.Prefetch.BundlePubkeys == 0 {
+		cfg.Prefetch.BundlePubkeys = 10000
+	}
+	if cfg.Prefetch.BundleMB == 0 {
+		cfg.Prefetch.BundleMB = 256
+	}
+
+	if cfg.BackgroundLoad.MaxConnections == 0 {
+		cfg.BackgroundLoad.MaxConnections = 500
+	}
+	if cfg.BackgroundLoad.MaxQueryLatencyMs == 0 {
+		cfg.BackgroundLoad.MaxQueryLatencyMs = 250
+	}
+	if cfg.BackgroundLoad.MaxDeferMinutes == 0 {
+		cfg.BackgroundLoad.MaxDeferMinutes = 120
+	}
+
+	if cfg.Impersonation.MinTargetFollowers == 0 {
+		cfg.Impersonation.MinTargetFollowers = 1000
+	}
+	if cfg.Impersonation.MaxFollowers == 0 {
+		cfg.Impersonation.MaxFollowers = 5
+	}
+	switch cfg.Impersonation.Policy {
+	case "":
+		cfg.Impersonation.Policy = ImpersonationFlag
+	case ImpersonationFlag, ImpersonationHide:
+	case ImpersonationLabel:
+		if cfg.Impersonation.LabelKey == "" {
+			return nil, fmt.Errorf("impersonation: the label policy needs a label_key")
+		}
+	default:
+		return nil, fmt.Errorf("impersonation: unknown policy %q", cfg.Impersonation.Policy)
+	}
+	if cfg.Annotations.Enabled && cfg.Annotations.Key == "" {
+		return nil, fmt.Errorf("annotations: enabled without a key")
+	}
+
+	if cfg.Sync.AuthKey == "" {
+		cfg.Sync.AuthKey = cfg.TrustedSync.AuthKey
+	}
+	switch cfg.Sync.Startup {
+	case "":
+		cfg.Sync.Startup = SyncStartupAsync
+	case SyncStartupAsync, SyncStartupGated:
+	default:
+		return nil, fmt.Errorf("sync: unknown startup mode %q", cfg.Sync.Startup)
+	}
+
+	if cfg.API.RequestsPerMinute == 0 {
+		cfg.API.RequestsPerMinute = 60
+	}
+	if cfg.API.Burst == 0 {
+		cfg.API.Burst = 20
+	}
+	for key, k := range cfg.API.Keys {
+		if k.Name == "" {
+			k.Name = key[:min(len(key), 8)]
+		}
+		if k.RequestsPerMinute == 0 {
+			k.RequestsPerMinute = cfg.API.RequestsPerMinute * 10
+		}
+		if k.Burst == 0 {
+			k.Burst = cfg.API.Burst * 10
+		}
+		cfg.API.Keys[key] = k
+	}
+
+	if cfg.Billing.PriceSats == 0 {
+		cfg.Billing.PriceSats = 5000
+	}
+	if cfg.Billing.DurationDays == 0 {
+		cfg.Billing.DurationDays = 30
+	}
+	if cfg.Billing.RequestsPerMinute == 0 {
+		cfg.Billing.RequestsPerMinute = cfg.API.RequestsPerMinute * 10
+	}
+	if cfg.Billing.Burst == 0 {
+		cfg.Billing.Burst = cfg.API.Burst * 10
+	}
+
+	if cfg.Partners.NegentropyPerHour == 0 {
+		cfg.Partners.NegentropyPerHour = 6
+	}
+	if cfg.Partners.PartnerNegentropyPerHour == 0 {
+		cfg.Partners.PartnerNegentropyPerHour = 600
+	}
+	if cfg.Partners.RequestsPerMinute == 0 {
+		cfg.Partners.RequestsPerMinute = cfg.API.RequestsPerMinute * 20
+	}
+	if cfg.Partners.Burst == 0 {
+		cfg.Partners.Burst = cfg.API.Burst * 20
+	}
+
+	if cfg.Mirror.URL != "" {
+		if cfg.Mirror.SamplePercent == 0 {
+			cfg.Mirror.SamplePercent = 1
+		}
+		if cfg.Mirror.SamplePercent < 0 || cfg.Mirror.SamplePercent > 100 {
+			return nil, fmt.Errorf("mirror: sample_percent must be between 0 and 100")
+		}
+	}
+	if cfg.ImageProxy.CacheDir == "" {
+		cfg.ImageProxy.CacheDir = "image-cache"
+	}
+	if cfg.ImageProxy.MaxBytes == 0 {
+		cfg.ImageProxy.MaxBytes = 2 * 1024 * 1024
+	}
+	if cfg.ImageProxy.TTLHours == 0 {
+		cfg.ImageProxy.TTLHours = 24 * 7
+	}
+
+	if cfg.Profiling.Dir == "" {
+		cfg.Profiling.Dir = "profiles"
+	}
+
+	if cfg.SessionCapture.Dir == "" {
+		cfg.SessionCapture.Dir = "captures"
+	}
+	if cfg.SessionCapture.RetentionHours <= 0 {
+		cfg.SessionCapture.RetentionHours = 72
+	}
+	if cfg.SessionCapture.MaxFrames <= 0 {
+		cfg.SessionCapture.MaxFrames = 5000
+	}
+	if cfg.Profiling.Keep <= 0 {
+		cfg.Profiling.Keep = 5
+	}
+
+	if cfg.Mirror.QueueSize == 0 {
+		cfg.Mirror.QueueSize = 1000
+	}
+
+	cfg.kindPrivacy = make(map[int]string, len(cfg.KindPrivacy))
+	for kindStr, policy := range cfg.KindPrivacy {
+		kind, err := strconv.Atoi(kindStr)
+		if err != nil {
+			return nil, fmt.Errorf("kind_privacy: invalid kind %q", kindStr)
+		}
+		switch policy {
+		case PrivacyPublic, PrivacyAuthorOnly, PrivacyNeverServe:
+		default:
+			return nil, fmt.Errorf("kind_privacy: unknown policy %q for kind %d", policy, kind)
+		}
+		cfg.kindPrivacy[kind] = policy
+	}
+
+	cfg.oversizeFilters = make(map[int]string, len(cfg.OversizeFilters))
+	for kindStr, policy := range cfg.OversizeFilters {
+		kind, err := strconv.Atoi(kindStr)
+		if err != nil {
+			return nil, fmt.Errorf("oversize_filters: invalid kind %q", kindStr)
+		}
+		switch policy {
+		case OversizeNewest, OversizeTrustedFirst, OversizeReject:
+		default:
+			return nil, fmt.Errorf("oversize_filters: unknown policy %q for kind %d", policy, kind)
+		}
+		cfg.oversizeFilters[kind] = policy
+	}
+
+	cfg.kindNames = DefaultKindNames()
+	for kindStr, name := range cfg.KindNames {
+		kind, err := strconv.Atoi(kindStr)
+		if err != nil || kind < 0 {
+			return nil, fmt.Errorf("kind_names: invalid kind %q", kindStr)
+		}
+		if name = strings.TrimSpace(name); name == "" {
+			delete(cfg.kindNames, kind)
+			continue
+		}
+		cfg.kindNames[kind] = name
+	}
+
+	cfg.kindTTLs = make(map[int]time.Duration, len(cfg.KindTTLDays))
+	for kindStr, days := range cfg.KindTTLDays {
+		kind, err := strconv.Atoi(kindStr)
+		if err != nil {
+			return nil, fmt.Errorf("kind_ttl_days: invalid kind %q", kindStr)
+		}
+		if kind == 0 || kind == 3 || kind == 10002 {
+			return nil, fmt.Errorf("kind_ttl_days: kind %d can't expire", kind)
+		}
+		if days <= 0 {
+			return nil, fmt.Errorf("kind_ttl_days: kind %d needs a positive number of days", kind)
+		}
+		cfg.kindTTLs[kind] = time.Duration(days) * 24 * time.Hour
+	}
+
+	cfg.kindLimits = make(map[int]KindLimit, len(cfg.KindLimits))
+	for kindStr, limit := range cfg.KindLimits {
+		kind, err := strconv.Atoi(kindStr)
+		if err != nil || kind < 0 {
+			return nil, fmt.Errorf("kind_limits: invalid kind %q", kindStr)
+		}
+		if limit.MaxEventTags < 0 || limit.MaxContentLength < 0 {
+			return nil, fmt.Errorf("kind_limits: kind %d has a negative limit", kind)
+		}
+		if limit.MaxEventTags == 0 {
+			limit.MaxEventTags = cfg.Limits.MaxEventTags
+		}
+		if limit.MaxContentLength == 0 {
+			limit.MaxContentLength = cfg.Limits.MaxContentLength
+		}
+		cfg.kindLimits[kind] = limit
+	}
+
+	for name := range cfg.Features {
+		if !slices.Contains(FeatureNames, name) {
+			return nil, fmt.Errorf("features: unknown feature %q", name)
+		}
+	}
+
+	if cfg.originAllow, err = compileOriginPatterns(cfg.OriginPolicy.Allow); err != nil {
+		return nil, fmt.Errorf("origin_policy.allow: %w", err)
+	}
+	if cfg.originDeny, err = compileOriginPatterns(cfg.OriginPolicy.Deny); err != nil {
+		return nil, fmt.Errorf("origin_policy.deny: %w", err)
+	}
 
 	return &cfg, nil
 }
@@ -262,3 +913,124 @@ func Load(path string) (*Config, error) {
 func (c *Config) IsKindAllowed(kind int) bool {
 	return c.AllowedKinds.Contains(kind)
 }
+
+// KindPrivacyPolicy returns how events of the given kind may be served
+func (c *Config) KindPrivacyPolicy(kind int) string {
+	if policy, ok := c.kindPrivacy[kind]; ok {
+		return policy
+	}
+	return PrivacyPublic
+}
+
+// PrivateKinds returns the kinds whose privacy policy isn't public, in order
+func (c *Config) PrivateKinds() []int {
+	kinds := make([]int, 0, len(c.kindPrivacy))
+	for kind, policy := range c.kindPrivacy {
+		if policy != PrivacyPublic {
+			kinds = append(kinds, kind)
+		}
+	}
+	slices.Sort(kinds)
+	return kinds
+}
+
+// KindTTLs returns how long an event of each expiring kind may go without an
+// update before it is pruned
+func (c *Config) KindTTLs() map[int]time.Duration {
+	return c.kindTTLs
+}
+
+// KindLimit returns the tag and content limits events of a kind are held to
+func (c *Config) KindLimit(kind int) KindLimit {
+	if limit, ok := c.kindLimits[kind]; ok {
+		return limit
+	}
+	return KindLimit{MaxEventTags: c.Limits.MaxEventTags, MaxContentLength: c.Limits.MaxContentLength}
+}
+
+// KindLimitOverrides returns the kinds with their own limits, global defaults filled in
+func (c *Config) KindLimitOverrides() map[int]KindLimit {
+	return c.kindLimits
+}
+
+// FeatureDefaults returns whether each feature is on before runtime overrides
+func (c *Config) FeatureDefaults() map[string]bool {
+	defaults := make(map[string]bool, len(FeatureNames))
+	for _, name := range FeatureNames {
+		enabled, ok := c.Features[name]
+		defaults[name] = enabled || !ok
+	}
+	return defaults
+}
+
+// RejectOrigin returns why a websocket upgrade from origin is refused, or ""
+// if it may connect: the deny pattern it matched, or "not allowed" when an
+// allow list is set and it matches none of it. Requests without an Origin
+// header come from native clients and are never refused.
+func (c *Config) RejectOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, p := range c.originDeny {
+		if p.re.MatchString(origin) {
+			return p.pattern
+		}
+	}
+	if len(c.originAllow) == 0 {
+		return ""
+	}
+	for _, p := range c.originAllow {
+		if p.re.MatchString(origin) {
+			return ""
+		}
+	}
+	return "not allowed"
+}
+
+type originPattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// compileOriginPatterns turns origin patterns, where * matches any run of
+// characters, into case-insensitive regexps matching the whole origin
+func compileOriginPatterns(patterns []string) ([]originPattern, error) {
+	var compiled []originPattern
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			return nil, fmt.Errorf("empty pattern")
+		}
+		parts := strings.Split(pattern, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		re, err := regexp.Compile("(?i)^" + strings.Join(parts, ".*") + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, originPattern{pattern: pattern, re: re})
+	}
+	return compiled, nil
+}
+
+// NamedKinds returns the display name of every named kind, defaults included
+func (c *Config) NamedKinds() map[int]string {
+	return c.kindNames
+}
+
+// OversizeFilterPolicy returns how a filter over these kinds is answered when it
+// matches more than limits.max_limit events. With several kinds the strictest
+// configured policy wins.
+func (c *Config) OversizeFilterPolicy(kinds []int) string {
+	result := OversizeNewest
+	for _, kind := range kinds {
+		switch c.oversizeFilters[kind] {
+		case OversizeReject:
+			return OversizeReject
+		case OversizeTrustedFirst:
+			result = OversizeTrustedFirst
+		}
+	}
+	return result
+}
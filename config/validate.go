@@ -0,0 +1,335 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Problem codes reported by Validate
+const (
+	ProblemUnreadable   = "unreadable"    // the file can't be read or isn't JSON
+	ProblemUnknownField = "unknown_field" // a field the relay doesn't know, ignored when loading
+	ProblemInvalidValue = "invalid_value" // a value Load refuses
+	ProblemInvalidURL   = "invalid_url"   // a relay URL that isn't ws:// or wss:// with a host
+	ProblemKindOverlap  = "kind_overlap"  // a kind listed twice, or a synced kind allowed_kinds refuses
+	ProblemPortConflict = "port_conflict" // two listeners on the same port, or a port out of range
+	ProblemMissingPath  = "missing_path"  // a file or directory that doesn't exist
+)
+
+// Problem severities. Warnings don't stop the relay from starting.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Problem is one finding of Validate, located by its dotted JSON path, e.g. "sync.relays[2]"
+type Problem struct {
+	Field    string `json:"field"`
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Field == "" {
+		return fmt.Sprintf("%s: %s", p.Severity, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.Severity, p.Field, p.Message)
+}
+
+// HasErrors reports whether any of problems is an error rather than a warning
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the config file at path without starting anything: unknown
+// fields, values Load refuses, relay URLs, kinds listed twice, listeners
+// sharing a port and paths that don't exist. Problems are sorted by field.
+func Validate(path string) []Problem {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []Problem{{Code: ProblemUnreadable, Severity: SeverityError, Message: err.Error()}}
+	}
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return []Problem{{Code: ProblemUnreadable, Severity: SeverityError, Message: "invalid JSON: " + err.Error()}}
+	}
+
+	var problems []Problem
+	unknownFields(raw, reflect.TypeOf(Config{}), "", &problems)
+
+	cfg, err := Load(path)
+	if err != nil {
+		// Load stops at its first error, so the rest can't be checked
+		problems = append(problems, Problem{Code: ProblemInvalidValue, Severity: SeverityError, Message: err.Error()})
+	} else {
+		problems = append(problems, cfg.validate(filepath.Dir(path))...)
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Field < problems[j].Field })
+	return problems
+}
+
+// unknownFields reports the keys of raw that the type it decodes into doesn't have
+func unknownFields(raw any, t reflect.Type, field string, problems *[]Problem) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// Types decoding themselves, like KindSet, have their own rules
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := raw.(map[string]any)
+		if !ok {
+			return
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fields[name] = f.Type
+		}
+		for key, value := range obj {
+			ft, ok := fields[key]
+			if !ok {
+				*problems = append(*problems, Problem{
+					Field:    joinField(field, key),
+					Code:     ProblemUnknownField,
+					Severity: SeverityWarning,
+					Message:  fmt.Sprintf("unknown field %q is ignored", key),
+				})
+				continue
+			}
+			unknownFields(value, ft, joinField(field, key), problems)
+		}
+	case reflect.Map:
+		obj, ok := raw.(map[string]any)
+		if !ok {
+			return
+		}
+		for key, value := range obj {
+			unknownFields(value, t.Elem(), fmt.Sprintf("%s[%q]", field, key), problems)
+		}
+	case reflect.Slice:
+		items, ok := raw.([]any)
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", field, i), problems)
+		}
+	}
+}
+
+func joinField(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// validate checks a loaded config for problems Load doesn't catch. Relative
+// paths are resolved against dir, the directory of the config file.
+func (c *Config) validate(dir string) []Problem {
+	var problems []Problem
+	add := func(field, code, format string, args ...any) {
+		problems = append(problems, Problem{Field: field, Code: code, Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+	}
+	warn := func(field, code, format string, args ...any) {
+		problems = append(problems, Problem{Field: field, Code: code, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Relay URLs. Ones without a scheme work, connected to as wss://.
+	checkRelay := func(field, u string) {
+		if !strings.Contains(u, "://") {
+			warn(field, ProblemInvalidURL, "%q has no scheme and is connected to as wss://%s", u, u)
+			u = "wss://" + u
+		}
+		if err := checkRelayURL(u); err != nil {
+			add(field, ProblemInvalidURL, "%v", err)
+		}
+	}
+	for i, u := range c.Sync.Relays {
+		checkRelay(fmt.Sprintf("sync.relays[%d]", i), u)
+	}
+	for u := range c.Sync.RelayAuthKeys {
+		checkRelay(fmt.Sprintf("sync.relay_auth_keys[%q]", u), u)
+	}
+	if c.Mirror.URL != "" {
+		checkRelay("mirror.url", c.Mirror.URL)
+	}
+	for i, peer := range c.NegentropyPeers {
+		checkRelay(fmt.Sprintf("negentropy_peers[%d].url", i), peer.URL)
+	}
+
+	// Kind lists. Kinds listed twice work, but usually aren't what was meant.
+	for _, list := range []struct {
+		field string
+		kinds []int
+	}{
+		{"sync_kinds", c.SyncKinds},
+		{"sync.kinds", c.Sync.Kinds},
+		{"trusted_sync.kinds", c.TrustedSync.Kinds},
+	} {
+		seen := make(map[int]bool, len(list.kinds))
+		for _, kind := range list.kinds {
+			if seen[kind] {
+				warn(list.field, ProblemKindOverlap, "kind %d is listed more than once", kind)
+			}
+			seen[kind] = true
+			if !c.AllowedKinds.IsEmpty() && !c.AllowedKinds.Contains(kind) {
+				warn(list.field, ProblemKindOverlap, "kind %d is synced but allowed_kinds refuses it", kind)
+			}
+		}
+	}
+	problems = append(problems, c.AllowedKinds.overlaps("allowed_kinds")...)
+
+	// Listeners
+	// Port 0 is left to the -port flag
+	if c.Server.Port < 0 || c.Server.Port > 65535 {
+		add("server.port", ProblemPortConflict, "port %d is out of range", c.Server.Port)
+	}
+	if c.Profiling.Listen != "" {
+		_, portStr, err := net.SplitHostPort(c.Profiling.Listen)
+		port, perr := strconv.Atoi(portStr)
+		switch {
+		case err != nil || perr != nil:
+			add("profiling.listen", ProblemPortConflict, "%q is not a host:port address", c.Profiling.Listen)
+		case port != 0 && port == c.Server.Port:
+			add("profiling.listen", ProblemPortConflict, "port %d is also server.port", port)
+		}
+	}
+
+	// Paths
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	mustExist := func(field, p string, wantDir bool) {
+		info, err := os.Stat(resolve(p))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			add(field, ProblemMissingPath, "%s does not exist", p)
+		case err != nil:
+			add(field, ProblemMissingPath, "%v", err)
+		case wantDir && !info.IsDir():
+			add(field, ProblemMissingPath, "%s is not a directory", p)
+		case !wantDir && info.IsDir():
+			add(field, ProblemMissingPath, "%s is a directory", p)
+		}
+	}
+	// Directories created on startup only can't already be something else
+	mayCreate := func(field, p string) {
+		if info, err := os.Stat(resolve(p)); err == nil && !info.IsDir() {
+			add(field, ProblemMissingPath, "%s is not a directory", p)
+		}
+	}
+
+	switch c.Storage.Backend {
+	case "lmdb":
+		if c.Storage.Path == "" {
+			add("storage.path", ProblemMissingPath, "the lmdb backend needs a path")
+		} else {
+			mayCreate("storage.path", c.Storage.Path)
+		}
+	case "postgresql":
+		if c.Storage.Path == "" {
+			add("storage.path", ProblemMissingPath, "the postgresql backend needs a database URL")
+		}
+	default:
+		add("storage.backend", ProblemInvalidValue, "unsupported storage backend %q (supported: lmdb, postgresql)", c.Storage.Backend)
+	}
+	if c.Storage.EventLogDir != "" {
+		mayCreate("storage.event_log_dir", c.Storage.EventLogDir)
+	}
+	if c.TemplatesDir != "" {
+		mustExist("templates_dir", c.TemplatesDir, true)
+	}
+	if c.GeoIP.Database != "" {
+		mustExist("geoip.database", c.GeoIP.Database, false)
+	}
+	if !c.ImageProxy.Disabled && c.ImageProxy.CacheDir != "" {
+		mayCreate("image_proxy.cache_dir", c.ImageProxy.CacheDir)
+	}
+	if c.Profiling.HeapThresholdMB > 0 && c.Profiling.Dir != "" {
+		mayCreate("profiling.dir", c.Profiling.Dir)
+	}
+	if !c.SessionCapture.Disabled && c.SessionCapture.Dir != "" {
+		mayCreate("session_capture.dir", c.SessionCapture.Dir)
+	}
+
+	return problems
+}
+
+// checkRelayURL returns why u can't be connected to as a relay, or nil
+func checkRelayURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("%q is not a URL", u)
+	}
+	if parsed.Scheme != "ws" && parsed.Scheme != "wss" {
+		return fmt.Errorf("%q is not a ws:// or wss:// URL", u)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("%q has no host", u)
+	}
+	return nil
+}
+
+// overlaps reports kinds a set lists twice: ranges overlapping each other and
+// single kinds inside a range
+func (ks *KindSet) overlaps(field string) []Problem {
+	var problems []Problem
+	add := func(format string, args ...any) {
+		problems = append(problems, Problem{Field: field, Code: ProblemKindOverlap, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+	}
+
+	ranges := slices.Clone(ks.ranges)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].Start <= ranges[i-1].End {
+			add("ranges %d-%d and %d-%d overlap", ranges[i-1].Start, ranges[i-1].End, ranges[i].Start, ranges[i].End)
+		}
+	}
+
+	kinds := make([]int, 0, len(ks.kinds))
+	for kind := range ks.kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Ints(kinds)
+	for _, kind := range kinds {
+		for _, r := range ranges {
+			if kind >= r.Start && kind <= r.End {
+				add("kind %d is already in range %d-%d", kind, r.Start, r.End)
+				break
+			}
+		}
+	}
+	return problems
+}
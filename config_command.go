@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pablof7z/purplepag.es/config"
+)
+
+// configValidation is the output of `purplepages config validate -json`
+type configValidation struct {
+	Path     string           `json:"path"`
+	Valid    bool             `json:"valid"` // no errors; warnings don't count
+	Problems []config.Problem `json:"problems"`
+}
+
+func runConfigCommand(args []string) {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages config validate [options] [path]\n")
+		os.Exit(1)
+	}
+
+	validateFlags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	asJSON := validateFlags.Bool("json", false, "Print the problems as JSON")
+	validateFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages config validate [options] [path]\n\n")
+		fmt.Fprintf(os.Stderr, "Check a config file (default config.json) without starting the relay: unknown\n")
+		fmt.Fprintf(os.Stderr, "fields, invalid values and relay URLs, kinds listed twice, port conflicts and\n")
+		fmt.Fprintf(os.Stderr, "missing paths. Exits 1 if any problem is an error.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		validateFlags.PrintDefaults()
+	}
+
+	if err := validateFlags.Parse(args[1:]); err != nil {
+		os.Exit(1)
+	}
+
+	path := "config.json"
+	if validateFlags.NArg() > 0 {
+		path = validateFlags.Arg(0)
+	}
+
+	problems := config.Validate(path)
+	out := configValidation{Path: path, Valid: !config.HasErrors(problems), Problems: problems}
+	if out.Problems == nil {
+		out.Problems = []config.Problem{}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			log.Fatalf("Failed to encode problems: %v", err)
+		}
+	} else if len(problems) == 0 {
+		fmt.Printf("%s: ok\n", path)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SEVERITY\tFIELD\tCODE\tMESSAGE")
+		for _, p := range problems {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Severity, p.Field, p.Code, p.Message)
+		}
+		w.Flush()
+	}
+
+	if !out.Valid {
+		os.Exit(1)
+	}
+}
+
+// loadCheckedConfig validates the config before loading it, so the relay
+// refuses to start with every error spelled out rather than only the first
+func loadCheckedConfig(path string) *config.Config {
+	problems := config.Validate(path)
+	for _, p := range problems {
+		log.Printf("Config %s", p)
+	}
+	if config.HasErrors(problems) {
+		log.Fatalf("%s has errors, not starting; check it with `purplepages config validate %s`", path, path)
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	return cfg
+}
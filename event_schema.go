@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// schemaViolation is why an event's structure doesn't fit its kind: a short
+// code rejections are counted under, and the message sent back to the client
+type schemaViolation struct {
+	code    string
+	message string
+}
+
+// validateEventSchema checks the structure the kinds we serve are relied on for:
+// profiles must hold a JSON object, contact lists must name pubkeys, relay
+// lists must name relays and nutzap info must name relays and mints. It
+// returns nil for events that fit, and for kinds it has no rules for.
+func validateEventSchema(evt *nostr.Event) *schemaViolation {
+	switch evt.Kind {
+	case 0:
+		var metadata map[string]any
+		if err := json.Unmarshal([]byte(evt.Content), &metadata); err != nil || metadata == nil {
+			return &schemaViolation{"content_not_object", "invalid: kind 0 content must be a JSON object"}
+		}
+
+	case 3:
+		for _, tag := range evt.Tags {
+			if len(tag) == 0 || tag[0] != "p" {
+				continue
+			}
+			if len(tag) < 2 || !nostr.IsValid32ByteHex(tag[1]) {
+				return &schemaViolation{"p_tag_not_hex", fmt.Sprintf("invalid: kind 3 p tag %q is not a 64-character hex pubkey", tagValue(tag))}
+			}
+		}
+
+	case 10002:
+		for _, tag := range evt.Tags {
+			if len(tag) == 0 || tag[0] != "r" {
+				continue
+			}
+			if len(tag) < 2 || !isRelayURL(tag[1]) {
+				return &schemaViolation{"r_tag_not_url", fmt.Sprintf("invalid: kind 10002 r tag %q is not a ws:// or wss:// URL", tagValue(tag))}
+			}
+			if len(tag) >= 3 && tag[2] != "" && tag[2] != "read" && tag[2] != "write" {
+				return &schemaViolation{"r_tag_bad_marker", fmt.Sprintf("invalid: kind 10002 r tag marker %q must be read or write", tag[2])}
+			}
+		}
+
+	case 10019:
+		for _, tag := range evt.Tags {
+			if len(tag) == 0 {
+				continue
+			}
+			switch tag[0] {
+			case "mint":
+				if len(tag) < 2 || !isMintURL(tag[1]) {
+					return &schemaViolation{"mint_tag_not_url", fmt.Sprintf("invalid: kind 10019 mint tag %q is not an http:// or https:// URL", tagValue(tag))}
+				}
+			case "relay":
+				if len(tag) < 2 || !isRelayURL(tag[1]) {
+					return &schemaViolation{"relay_tag_not_url", fmt.Sprintf("invalid: kind 10019 relay tag %q is not a ws:// or wss:// URL", tagValue(tag))}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func isRelayURL(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	return err == nil && (u.Scheme == "ws" || u.Scheme == "wss") && u.Hostname() != ""
+}
+
+func isMintURL(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Hostname() != ""
+}
+
+// tagValue is a tag's value for an error message, cut short
+func tagValue(tag nostr.Tag) string {
+	if len(tag) < 2 {
+		return ""
+	}
+	if len(tag[1]) > 80 {
+		return tag[1][:80] + "..."
+	}
+	return tag[1]
+}
@@ -0,0 +1,150 @@
+package features
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/config"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// refreshInterval is how often overrides set by another process are picked up
+const refreshInterval = 10 * time.Second
+
+// ErrUnknown is returned for a feature name that isn't in config.FeatureNames
+var ErrUnknown = errors.New("unknown feature")
+
+// Flag is a feature's state and where it comes from
+type Flag struct {
+	Name       string
+	Enabled    bool
+	Default    bool // the configured state
+	Overridden bool // set at runtime, taking precedence over Default
+	UpdatedBy  string
+	UpdatedAt  time.Time
+}
+
+// Flags gates heavyweight subsystems. Each feature starts in its configured
+// state; admins override it at runtime, and overrides are stored so every
+// process sharing the database follows them.
+type Flags struct {
+	storage  *storage.Storage
+	defaults map[string]bool
+
+	mu        sync.RWMutex
+	overrides map[string]storage.FeatureFlag
+}
+
+func New(store *storage.Storage, defaults map[string]bool) *Flags {
+	return &Flags{storage: store, defaults: defaults, overrides: make(map[string]storage.FeatureFlag)}
+}
+
+// Enabled reports whether the feature is on
+func (f *Flags) Enabled(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.enabledLocked(name)
+}
+
+// List returns every feature with its state
+func (f *Flags) List() []Flag {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	flags := make([]Flag, 0, len(config.FeatureNames))
+	for _, name := range config.FeatureNames {
+		flag := Flag{Name: name, Enabled: f.defaults[name], Default: f.defaults[name]}
+		if o, ok := f.overrides[name]; ok {
+			flag.Enabled = o.Enabled
+			flag.Overridden = true
+			flag.UpdatedBy = o.UpdatedBy
+			flag.UpdatedAt = o.UpdatedAt
+		}
+		flags = append(flags, flag)
+	}
+	return flags
+}
+
+// Set overrides the feature's configured state
+func (f *Flags) Set(ctx context.Context, name string, enabled bool, actor string) error {
+	if !slices.Contains(config.FeatureNames, name) {
+		return ErrUnknown
+	}
+	if err := f.storage.SetFeatureFlag(ctx, name, enabled, actor); err != nil {
+		return err
+	}
+	return f.Refresh(ctx)
+}
+
+// Clear returns the feature to its configured state
+func (f *Flags) Clear(ctx context.Context, name string) error {
+	if !slices.Contains(config.FeatureNames, name) {
+		return ErrUnknown
+	}
+	if _, err := f.storage.ClearFeatureFlag(ctx, name); err != nil {
+		return err
+	}
+	return f.Refresh(ctx)
+}
+
+// Refresh reloads the overrides from storage
+func (f *Flags) Refresh(ctx context.Context) error {
+	stored, err := f.storage.GetFeatureFlags(ctx)
+	if err != nil {
+		return err
+	}
+
+	overrides := make(map[string]storage.FeatureFlag, len(stored))
+	for _, o := range stored {
+		overrides[o.Name] = o
+	}
+
+	f.mu.Lock()
+	for _, name := range config.FeatureNames {
+		was := f.enabledLocked(name)
+		o, ok := overrides[name]
+		if ok && o.Enabled != was {
+			log.Printf("Features: %s switched %s by %s", name, onOff(o.Enabled), o.UpdatedBy)
+		} else if !ok && f.defaults[name] != was {
+			log.Printf("Features: %s back to its configured state (%s)", name, onOff(f.defaults[name]))
+		}
+	}
+	f.overrides = overrides
+	f.mu.Unlock()
+	return nil
+}
+
+// Watch refreshes the overrides until ctx is done
+func (f *Flags) Watch(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.Refresh(ctx); err != nil {
+				log.Printf("Features: failed to refresh overrides: %v", err)
+			}
+		}
+	}
+}
+
+func (f *Flags) enabledLocked(name string) bool {
+	if o, ok := f.overrides[name]; ok {
+		return o.Enabled
+	}
+	return f.defaults[name]
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fixtureEpoch anchors generated timestamps, so a seed always yields the same events
+var fixtureEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fixtureSharedRelays is the size of the relay pool every community writes to
+const fixtureSharedRelays = 20
+
+var fixtureSyllables = []string{
+	"ka", "lo", "mi", "ra", "ten", "vo", "shi", "an", "be", "dor", "el", "fu",
+	"gra", "hi", "jo", "ku", "lin", "mar", "no", "pe", "qui", "ro", "sa", "tu",
+}
+
+type fixtureOptions struct {
+	pubkeys     int
+	followsAvg  int
+	communities int
+	bots        int
+	seed        int64
+}
+
+type fixtureUser struct {
+	secret    string
+	pubkey    string
+	name      string
+	community int // -1 for bots
+}
+
+func runGenFixturesCommand(args []string) {
+	fixtureFlags := flag.NewFlagSet("genfixtures", flag.ExitOnError)
+	pubkeys := fixtureFlags.Int("pubkeys", 1000, "Number of synthetic users")
+	followsAvg := fixtureFlags.Int("follows-avg", 150, "Average number of follows per user")
+	communities := fixtureFlags.Int("communities", 5, "Number of communities most follows stay inside")
+	bots := fixtureFlags.Int("bots", 0, "Size of a bot ring following only each other, for cluster and spam detection")
+	seed := fixtureFlags.Int64("seed", 1, "Random seed; the same flags and seed always generate the same events")
+	out := fixtureFlags.String("out", "", "Write the events as JSONL to this file (\"-\" for stdout) instead of storing them")
+	fixtureFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages genfixtures [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Generate a synthetic dataset of profiles, contact lists and relay lists for local\n")
+		fmt.Fprintf(os.Stderr, "development and for benchmarking community detection and trust analysis.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fixtureFlags.PrintDefaults()
+	}
+
+	if err := fixtureFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if *pubkeys < 2 || *followsAvg < 1 || *communities < 1 || *bots < 0 {
+		log.Fatalf("--pubkeys must be at least 2, --follows-avg and --communities at least 1, --bots at least 0")
+	}
+
+	opts := fixtureOptions{pubkeys: *pubkeys, followsAvg: *followsAvg, communities: *communities, bots: *bots, seed: *seed}
+	events, follows := generateFixtures(opts)
+
+	if *out != "" {
+		var w io.Writer = os.Stdout
+		if *out != "-" {
+			f, err := os.Create(*out)
+			if err != nil {
+				log.Fatalf("Failed to create %s: %v", *out, err)
+			}
+			defer f.Close()
+			w = f
+		}
+		enc := json.NewEncoder(w)
+		for _, evt := range events {
+			if err := enc.Encode(evt); err != nil {
+				log.Fatalf("Failed to write fixtures: %v", err)
+			}
+		}
+	} else {
+		cfg, store := openImportStorage()
+		defer store.Close()
+
+		ctx := context.Background()
+		stats := &importStats{}
+		for _, evt := range events {
+			importEvent(ctx, store, cfg, evt, stats)
+		}
+		log.Printf("Stored fixtures: %s", stats)
+	}
+
+	users := *pubkeys + *bots
+	log.Printf("Generated %d events: %d users in %d communities with %d bots, %d follows (%.1f per user)",
+		len(events), users, *communities, *bots, follows, float64(follows)/float64(users))
+}
+
+// generateFixtures builds a kind 0, 3 and 10002 event for every user. Users are
+// spread over communities of falling size; follow counts are long-tailed around
+// followsAvg and most follows go to popular members of the user's own community.
+// It also returns the total number of follows.
+func generateFixtures(opts fixtureOptions) ([]*nostr.Event, int) {
+	rng := rand.New(rand.NewSource(opts.seed))
+
+	// Community c holds a share proportional to 1/(c+1) of the users
+	communityWeights := make([]float64, opts.communities)
+	for c := range communityWeights {
+		communityWeights[c] = 1 / float64(c+1)
+	}
+	communityPicker := newWeightedPicker(communityWeights)
+
+	users := make([]fixtureUser, 0, opts.pubkeys+opts.bots)
+	members := make([][]int, opts.communities)
+	popularity := make([]float64, 0, opts.pubkeys)
+	for i := 0; i < opts.pubkeys+opts.bots; i++ {
+		u := fixtureUser{community: -1}
+		u.secret, u.pubkey = fixtureKeypair(rng)
+		if i < opts.pubkeys {
+			u.community = communityPicker.pick(rng)
+			u.name = fixtureName(rng)
+			members[u.community] = append(members[u.community], i)
+			// Pareto-distributed, so a few accounts attract most follows
+			popularity = append(popularity, math.Min(1/math.Pow(1-rng.Float64(), 1/1.2), 1000))
+		} else {
+			u.name = fmt.Sprintf("%s%d", fixtureName(rng), rng.Intn(10000))
+		}
+		users = append(users, u)
+	}
+
+	globalPicker := newWeightedPicker(popularity)
+	communityPickers := make([]*weightedPicker, opts.communities)
+	for c, idx := range members {
+		weights := make([]float64, len(idx))
+		for j, i := range idx {
+			weights[j] = popularity[i]
+		}
+		communityPickers[c] = newWeightedPicker(weights)
+	}
+
+	var events []*nostr.Event
+	totalFollows := 0
+	for i, u := range users {
+		var follows []int
+		if u.community >= 0 {
+			follows = fixtureFollows(rng, opts, i, u.community, members, communityPickers, globalPicker)
+		} else {
+			// Bots follow every other bot and a handful of popular accounts
+			for j := opts.pubkeys; j < len(users); j++ {
+				if j != i {
+					follows = append(follows, j)
+				}
+			}
+			for k := 0; k < 5; k++ {
+				follows = append(follows, globalPicker.pick(rng))
+			}
+		}
+		totalFollows += len(follows)
+
+		createdAt := fixtureEpoch.Add(time.Duration(rng.Int63n(int64(365 * 24 * time.Hour))))
+		events = append(events,
+			fixtureProfile(rng, u, createdAt),
+			fixtureContactList(u, users, follows, createdAt.Add(time.Duration(rng.Int63n(int64(30*24*time.Hour))))),
+			fixtureRelayList(rng, u, createdAt.Add(time.Duration(rng.Int63n(int64(30*24*time.Hour))))),
+		)
+	}
+
+	return events, totalFollows
+}
+
+// fixtureFollows picks a long-tailed number of distinct accounts for user i to
+// follow, 85% of them from its own community
+func fixtureFollows(rng *rand.Rand, opts fixtureOptions, i, community int, members [][]int, communityPickers []*weightedPicker, globalPicker *weightedPicker) []int {
+	count := int(rng.ExpFloat64()*float64(opts.followsAvg-1)) + 1
+	if count > opts.pubkeys-1 {
+		count = opts.pubkeys - 1
+	}
+
+	seen := map[int]bool{i: true}
+	follows := make([]int, 0, count)
+	for attempts := 0; len(follows) < count && attempts < count*4; attempts++ {
+		var j int
+		intra := rng.Float64() < 0.85 && len(members[community]) > 1
+		if intra {
+			j = members[community][communityPickers[community].pick(rng)]
+		} else {
+			j = globalPicker.pick(rng)
+		}
+		if seen[j] {
+			// Once the popular accounts are followed, fall back to anyone in the same pool
+			if intra {
+				j = members[community][rng.Intn(len(members[community]))]
+			} else {
+				j = rng.Intn(opts.pubkeys)
+			}
+			if seen[j] {
+				continue
+			}
+		}
+		seen[j] = true
+		follows = append(follows, j)
+	}
+	return follows
+}
+
+func fixtureProfile(rng *rand.Rand, u fixtureUser, createdAt time.Time) *nostr.Event {
+	profile := map[string]string{
+		"name":    strings.ToLower(u.name),
+		"picture": fmt.Sprintf("https://fixtures.example/avatars/%s.png", u.pubkey[:16]),
+	}
+	if u.community >= 0 {
+		profile["display_name"] = u.name
+		profile["about"] = fmt.Sprintf("Synthetic member of community %d", u.community)
+		if rng.Intn(3) == 0 {
+			profile["nip05"] = fmt.Sprintf("%s@community%d.example", strings.ToLower(u.name), u.community)
+		}
+	} else {
+		profile["about"] = "Follow back! Best deals every day"
+	}
+	content, _ := json.Marshal(profile)
+
+	return fixtureSign(u, &nostr.Event{Kind: 0, CreatedAt: nostr.Timestamp(createdAt.Unix()), Tags: nostr.Tags{}, Content: string(content)})
+}
+
+func fixtureContactList(u fixtureUser, users []fixtureUser, follows []int, createdAt time.Time) *nostr.Event {
+	tags := make(nostr.Tags, len(follows))
+	for k, j := range follows {
+		tags[k] = nostr.Tag{"p", users[j].pubkey}
+	}
+	return fixtureSign(u, &nostr.Event{Kind: 3, CreatedAt: nostr.Timestamp(createdAt.Unix()), Tags: tags})
+}
+
+// fixtureRelayList points members at their community's relay plus a few from a
+// shared pool, so relay discovery and outbox lookups have overlap to work with
+func fixtureRelayList(rng *rand.Rand, u fixtureUser, createdAt time.Time) *nostr.Event {
+	tags := nostr.Tags{}
+	if u.community >= 0 {
+		tags = append(tags, nostr.Tag{"r", fmt.Sprintf("wss://relay.community%d.example", u.community)})
+	}
+	seen := map[int]bool{}
+	for k := rng.Intn(3) + 1; k > 0; k-- {
+		relay := rng.Intn(fixtureSharedRelays)
+		if seen[relay] {
+			continue
+		}
+		seen[relay] = true
+		tag := nostr.Tag{"r", fmt.Sprintf("wss://relay%d.fixtures.example", relay)}
+		switch rng.Intn(4) {
+		case 0:
+			tag = append(tag, "read")
+		case 1:
+			tag = append(tag, "write")
+		}
+		tags = append(tags, tag)
+	}
+	return fixtureSign(u, &nostr.Event{Kind: 10002, CreatedAt: nostr.Timestamp(createdAt.Unix()), Tags: tags})
+}
+
+func fixtureSign(u fixtureUser, evt *nostr.Event) *nostr.Event {
+	if err := evt.Sign(u.secret); err != nil {
+		log.Fatalf("Failed to sign fixture event: %v", err)
+	}
+	return evt
+}
+
+// fixtureKeypair draws a secret key from rng rather than crypto/rand, so the
+// same seed produces the same pubkeys
+func fixtureKeypair(rng *rand.Rand) (string, string) {
+	for {
+		b := make([]byte, 32)
+		rng.Read(b)
+		secret := hex.EncodeToString(b)
+		if pubkey, err := nostr.GetPublicKey(secret); err == nil {
+			return secret, pubkey
+		}
+	}
+}
+
+func fixtureName(rng *rand.Rand) string {
+	var b strings.Builder
+	for k := rng.Intn(2) + 2; k > 0; k-- {
+		b.WriteString(fixtureSyllables[rng.Intn(len(fixtureSyllables))])
+	}
+	name := b.String()
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// weightedPicker draws indexes with probability proportional to their weight
+type weightedPicker struct {
+	cumulative []float64
+}
+
+func newWeightedPicker(weights []float64) *weightedPicker {
+	cumulative := make([]float64, len(weights))
+	total := 0.0
+	for i, w := range weights {
+		total += w
+		cumulative[i] = total
+	}
+	return &weightedPicker{cumulative: cumulative}
+}
+
+func (p *weightedPicker) pick(rng *rand.Rand) int {
+	target := rng.Float64() * p.cumulative[len(p.cumulative)-1]
+	i := sort.SearchFloat64s(p.cumulative, target)
+	if i >= len(p.cumulative) {
+		i = len(p.cumulative) - 1
+	}
+	return i
+}
@@ -0,0 +1,129 @@
+// Package geoip maps IP addresses to countries from a CSV range database such as
+// DB-IP's "IP to Country Lite" (start_ip,end_ip,country) or IP2Location LITE DB1
+// (ip_from,ip_to,country_code,country_name with decimal addresses).
+package geoip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Unknown is the region of addresses the database has no country for
+const Unknown = "unknown"
+
+type ipRange struct {
+	start, end [16]byte
+	country    string
+}
+
+// DB is an in-memory, sorted copy of a range database
+type DB struct {
+	ranges []ipRange
+}
+
+// Open loads the CSV database at path
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	countries := make(map[string]string) // interned, a few hundred codes across a million rows
+	db := &DB{}
+	line := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d: expected start, end and country", line)
+		}
+
+		start, ok1 := parseAddr(record[0])
+		end, ok2 := parseAddr(record[1])
+		if !ok1 || !ok2 {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: invalid address range %q-%q", line, record[0], record[1])
+		}
+
+		code := strings.ToUpper(strings.TrimSpace(record[2]))
+		if code == "" || code == "-" || code == "ZZ" {
+			continue
+		}
+		country, ok := countries[code]
+		if !ok {
+			country = code
+			countries[code] = code
+		}
+		db.ranges = append(db.ranges, ipRange{start: start, end: end, country: country})
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start[:], db.ranges[j].start[:]) < 0
+	})
+	return db, nil
+}
+
+// Len is the number of ranges loaded
+func (db *DB) Len() int {
+	return len(db.ranges)
+}
+
+// Country returns the ISO country code of ip, or Unknown
+func (db *DB) Country(ip string) string {
+	addr, ok := parseAddr(ip)
+	if !ok {
+		return Unknown
+	}
+
+	// The last range starting at or before addr is the only one that can hold it
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start[:], addr[:]) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(addr[:], db.ranges[i].end[:]) > 0 {
+		return Unknown
+	}
+	return db.ranges[i].country
+}
+
+// parseAddr reads an IPv4 or IPv6 address, textual or decimal, as 16 bytes with
+// IPv4 in its IPv4-mapped form so both families sort in one list
+func parseAddr(s string) ([16]byte, bool) {
+	var addr [16]byte
+	s = strings.TrimSpace(s)
+
+	if ip := net.ParseIP(s); ip != nil {
+		copy(addr[:], ip.To16())
+		return addr, true
+	}
+
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return addr, false
+	}
+	if n.BitLen() <= 32 {
+		// IP2Location's IPv4 files number addresses from 0, not as mapped IPv6
+		n.Or(n, new(big.Int).Lsh(big.NewInt(0xffff), 32))
+	}
+	n.FillBytes(addr[:])
+	return addr, true
+}
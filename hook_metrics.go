@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/stats"
+)
+
+// Wrappers that record how long each khatru hook takes, so slow EOSEs and OKs
+// can be attributed to a specific hook on /metrics
+
+func timedRejectEvent(s *stats.Stats, hook string, fn func(context.Context, *nostr.Event) (bool, string)) func(context.Context, *nostr.Event) (bool, string) {
+	return func(ctx context.Context, event *nostr.Event) (bool, string) {
+		start := time.Now()
+		defer func() { s.ObserveHook(hook, time.Since(start)) }()
+		return fn(ctx, event)
+	}
+}
+
+func timedRejectFilter(s *stats.Stats, hook string, fn func(context.Context, nostr.Filter) (bool, string)) func(context.Context, nostr.Filter) (bool, string) {
+	return func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		start := time.Now()
+		defer func() { s.ObserveHook(hook, time.Since(start)) }()
+		return fn(ctx, filter)
+	}
+}
+
+func timedStoreEvent(s *stats.Stats, hook string, fn func(context.Context, *nostr.Event) error) func(context.Context, *nostr.Event) error {
+	return func(ctx context.Context, event *nostr.Event) error {
+		start := time.Now()
+		defer func() { s.ObserveHook(hook, time.Since(start)) }()
+		return fn(ctx, event)
+	}
+}
+
+func timedOnEventSaved(s *stats.Stats, hook string, fn func(context.Context, *nostr.Event)) func(context.Context, *nostr.Event) {
+	return func(ctx context.Context, event *nostr.Event) {
+		start := time.Now()
+		defer func() { s.ObserveHook(hook, time.Since(start)) }()
+		fn(ctx, event)
+	}
+}
+
+// timedQueryEvents measures until the result channel is handed back to khatru, which
+// covers the storage query; streaming the results to the client is not included
+func timedQueryEvents(s *stats.Stats, hook string, fn func(context.Context, nostr.Filter) (chan *nostr.Event, error)) func(context.Context, nostr.Filter) (chan *nostr.Event, error) {
+	return func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		start := time.Now()
+		defer func() { s.ObserveHook(hook, time.Since(start)) }()
+		return fn(ctx, filter)
+	}
+}
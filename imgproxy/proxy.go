@@ -0,0 +1,335 @@
+// Package imgproxy serves profile pictures from our own origin, so pages don't
+// hotlink them: viewers' IPs stay with us, dead hosts cost one fetch per cache
+// period instead of a broken image on every view, and oversized or non-image
+// responses never reach the browser.
+package imgproxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// failureTTL is how long a URL that could not be fetched is answered with
+	// 404 before it is tried again
+	failureTTL = time.Hour
+	// fetchTimeout bounds one upstream image fetch, headers and body
+	fetchTimeout = 10 * time.Second
+	// keyFile holds the generated signing key in the cache directory
+	keyFile = "signing-key"
+)
+
+// allowedTypes are the content types served, by sniffed type. SVG is left out
+// since it can carry script.
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+var (
+	errTooLarge = errors.New("image too large")
+	errNotImage = errors.New("not an image")
+)
+
+// Proxy fetches images on first request and serves them from a disk cache
+// named by the hash of their URL
+type Proxy struct {
+	dir      string
+	key      []byte // signs proxied URLs, so the proxy only fetches what our pages link
+	maxBytes int64
+	ttl      time.Duration
+	client   *http.Client
+
+	mu       sync.Mutex
+	inflight map[string]*fetch
+}
+
+// fetch is one upstream request, shared by everyone asking for the same URL
+// while it runs
+type fetch struct {
+	done chan struct{}
+	err  error
+}
+
+func New(dir, secret string, maxBytes int64, ttl time.Duration) (*Proxy, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create image cache directory: %w", err)
+	}
+
+	key, err := signingKey(dir, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image proxy signing key: %w", err)
+	}
+
+	return &Proxy{
+		dir:      dir,
+		key:      key,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		client: &http.Client{
+			Timeout:   fetchTimeout,
+			Transport: &http.Transport{DialContext: publicDialer().DialContext},
+		},
+		inflight: make(map[string]*fetch),
+	}, nil
+}
+
+// signingKey is what proxied URLs are signed with: secret when set, so every
+// instance signs alike, or else a key generated on first start and kept in dir,
+// so URLs already handed out keep working across restarts
+func signingKey(dir, secret string) ([]byte, error) {
+	if secret != "" {
+		return []byte(secret), nil
+	}
+
+	path := filepath.Join(dir, keyFile)
+	if key, err := os.ReadFile(path); err == nil && len(key) > 0 {
+		return key, nil
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(tmp, key, 0600); err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	// Linking fails if another process sharing dir got there first; its key wins
+	if err := os.Link(tmp, path); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		return os.ReadFile(path)
+	}
+	return key, nil
+}
+
+// publicDialer refuses connections to loopback, private and link-local
+// addresses, so a profile picture can't point the proxy at our own network
+func publicDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout: fetchTimeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+				return fmt.Errorf("refusing to fetch images from %s", host)
+			}
+			return nil
+		},
+	}
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultProxy *Proxy
+)
+
+// SetDefault makes URL route pictures through p; nil links them directly
+func SetDefault(p *Proxy) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultProxy = p
+}
+
+// URL is where pages should load the picture at src from: the default proxy, or
+// src itself when proxying is off or src isn't an http(s) URL
+func URL(src string) string {
+	defaultMu.RLock()
+	p := defaultProxy
+	defaultMu.RUnlock()
+
+	if p == nil || !(strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://")) {
+		return src
+	}
+	return "/img/" + p.sign(src) + "?url=" + url.QueryEscape(src)
+}
+
+func (p *Proxy) sign(src string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(src))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// cachePath is where the image at src is stored, named by its URL hash so every
+// page linking the same picture shares one copy
+func (p *Proxy) cachePath(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(p.dir, name[:2], name)
+}
+
+// HandleImage serves GET /img/{sig}?url=
+func (p *Proxy) HandleImage(w http.ResponseWriter, r *http.Request) {
+	src := r.URL.Query().Get("url")
+	if src == "" || !hmac.Equal([]byte(r.PathValue("sig")), []byte(p.sign(src))) {
+		http.Error(w, "Invalid image URL", http.StatusForbidden)
+		return
+	}
+
+	path := p.cachePath(src)
+	if err := p.ensureCached(r.Context(), src, path); err != nil {
+		if _, statErr := os.Stat(path); statErr != nil {
+			http.Error(w, "Image unavailable", http.StatusNotFound)
+			return
+		}
+		// A copy past its TTL beats no picture while the host is down
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		http.Error(w, "Image unavailable", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(content))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	w.Write(content)
+}
+
+// ensureCached fetches src into path unless a fresh copy is there already or
+// the last attempt failed less than failureTTL ago. Concurrent requests for the
+// same URL wait for a single fetch.
+func (p *Proxy) ensureCached(ctx context.Context, src, path string) error {
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < p.ttl {
+		return nil
+	}
+	if info, err := os.Stat(path + ".failed"); err == nil && time.Since(info.ModTime()) < failureTTL {
+		return errors.New("recently failed")
+	}
+
+	p.mu.Lock()
+	f, ok := p.inflight[path]
+	if !ok {
+		f = &fetch{done: make(chan struct{})}
+		p.inflight[path] = f
+		go func() {
+			f.err = p.download(src, path)
+			p.mu.Lock()
+			delete(p.inflight, path)
+			p.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// download fetches src and stores it at path if it is an allowed image within
+// maxBytes, or marks it as failed
+func (p *Proxy) download(src, path string) error {
+	err := p.fetchTo(src, path)
+	if err != nil {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if f, createErr := os.Create(path + ".failed"); createErr == nil {
+			f.Close()
+		}
+		return err
+	}
+	os.Remove(path + ".failed")
+	return nil
+}
+
+func (p *Proxy) fetchTo(src, path string) error {
+	resp, err := p.client.Get(src)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upstream answered %d", resp.StatusCode)
+	}
+	if resp.ContentLength > p.maxBytes {
+		return errTooLarge
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, p.maxBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(content)) > p.maxBytes {
+		return errTooLarge
+	}
+	// Trust the bytes, not the header: hosts commonly mislabel images
+	if !allowedTypes[http.DetectContentType(content)] {
+		return errNotImage
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Start deletes cached images and failure markers not refreshed for twice the
+// TTL, once an hour, so pictures nobody views anymore don't pile up
+func (p *Proxy) Start(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.sweep()
+		}
+	}
+}
+
+func (p *Proxy) sweep() {
+	cutoff := time.Now().Add(-2 * p.ttl)
+	removed := 0
+	filepath.WalkDir(p.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path == filepath.Join(p.dir, keyFile) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+			if os.Remove(path) == nil {
+				removed++
+			}
+		}
+		return nil
+	})
+	if removed > 0 {
+		log.Printf("Image proxy: removed %d expired cache files", removed)
+	}
+}
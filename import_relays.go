@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/config"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// importStats tracks progress for the relay database importers
+type importStats struct {
+	imported   int
+	duplicates int
+	disallowed int
+	invalid    int
+	failed     int
+}
+
+func (s *importStats) String() string {
+	return fmt.Sprintf("%d imported, %d duplicates, %d disallowed kinds, %d invalid ids or signatures, %d failed",
+		s.imported, s.duplicates, s.disallowed, s.invalid, s.failed)
+}
+
+// importEvent saves a single event if its kind is allowed by the config
+func importEvent(ctx context.Context, store *storage.Storage, cfg *config.Config, evt *nostr.Event, stats *importStats) {
+	if !cfg.IsKindAllowed(evt.Kind) {
+		stats.disallowed++
+		return
+	}
+
+	if err := store.SaveEvent(ctx, evt); err != nil {
+		if err.Error() == "duplicate: event already exists" {
+			stats.duplicates++
+		} else {
+			log.Printf("Failed to save event %s: %v", evt.ID, err)
+			stats.failed++
+		}
+		return
+	}
+
+	stats.imported++
+	if stats.imported%1000 == 0 {
+		log.Printf("Imported %d events (%s)...", stats.imported, stats)
+	}
+}
+
+// importEventStream reads newline-delimited event JSON (the format produced by `strfry export`).
+// IDs and signatures are only checked when verify is set, local relay databases are trusted.
+func importEventStream(ctx context.Context, store *storage.Storage, cfg *config.Config, r io.Reader, verify bool, stats *importStats) error {
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 0, 1024*1024)
+	scanner.Buffer(buf, 10*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := scanner.Bytes()
+		if len(data) == 0 {
+			continue
+		}
+
+		var evt nostr.Event
+		if err := json.Unmarshal(data, &evt); err != nil {
+			log.Printf("Failed to parse event on line %d: %v", line, err)
+			stats.failed++
+			continue
+		}
+
+		if verify {
+			if !evt.CheckID() {
+				stats.invalid++
+				continue
+			}
+			if ok, _ := evt.CheckSignature(); !ok {
+				stats.invalid++
+				continue
+			}
+		}
+
+		importEvent(ctx, store, cfg, &evt, stats)
+	}
+
+	return scanner.Err()
+}
+
+func openImportStorage() (*config.Config, *storage.Storage) {
+	cfg, err := config.Load("config.json")
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	store, err := storage.New(cfg.Storage.Backend, cfg.Storage.Path, *cfg.Storage.ArchiveEnabled, cfg.Storage.AnalyticsDBURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	initSchemas(store)
+
+	return cfg, store
+}
+
+func runImportStrfryCommand(args []string) {
+	importFlags := flag.NewFlagSet("import-strfry", flag.ExitOnError)
+	strfryBin := importFlags.String("strfry", "strfry", "Path to the strfry executable (used when <path> is a strfry.conf)")
+	importFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages import-strfry [options] <path>\n\n")
+		fmt.Fprintf(os.Stderr, "Import events from a strfry relay, keeping only allowed kinds.\n\n")
+		fmt.Fprintf(os.Stderr, "<path> can be:\n")
+		fmt.Fprintf(os.Stderr, "  - a strfry.conf file: runs `strfry --config=<path> export` and streams its output\n")
+		fmt.Fprintf(os.Stderr, "  - a file produced by `strfry export`\n")
+		fmt.Fprintf(os.Stderr, "  - \"-\" to read an export stream from stdin\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		importFlags.PrintDefaults()
+	}
+
+	if err := importFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if importFlags.NArg() < 1 {
+		importFlags.Usage()
+		os.Exit(1)
+	}
+	path := importFlags.Arg(0)
+
+	cfg, store := openImportStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+	stats := &importStats{}
+
+	log.Printf("Starting strfry import from %s...", path)
+
+	var err error
+	switch {
+	case path == "-":
+		err = importEventStream(ctx, store, cfg, os.Stdin, false, stats)
+	case strings.HasSuffix(path, ".conf"):
+		err = importFromStrfryExport(ctx, store, cfg, *strfryBin, path, stats)
+	default:
+		var file *os.File
+		file, err = os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", path, err)
+		}
+		err = importEventStream(ctx, store, cfg, file, false, stats)
+		file.Close()
+	}
+
+	if err != nil {
+		log.Fatalf("Import failed: %v (%s)", err, stats)
+	}
+
+	log.Printf("Import complete: %s", stats)
+}
+
+// importFromStrfryExport runs `strfry export` against the given config and streams its stdout
+func importFromStrfryExport(ctx context.Context, store *storage.Storage, cfg *config.Config, strfryBin, configPath string, stats *importStats) error {
+	cmd := exec.CommandContext(ctx, strfryBin, "--config="+configPath, "export")
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open strfry stdout: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start strfry: %w", err)
+	}
+
+	if err := importEventStream(ctx, store, cfg, stdout, false, stats); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("strfry export failed: %w", err)
+	}
+	return nil
+}
+
+func runImportNostrRSCommand(args []string) {
+	importFlags := flag.NewFlagSet("import-nostrrs", flag.ExitOnError)
+	includeHidden := importFlags.Bool("include-hidden", false, "Also import events nostr-rs-relay marked as hidden (deleted)")
+	importFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages import-nostrrs [options] <nostr.db>\n\n")
+		fmt.Fprintf(os.Stderr, "Import events directly from a nostr-rs-relay SQLite database, keeping only allowed kinds.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		importFlags.PrintDefaults()
+	}
+
+	if err := importFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if importFlags.NArg() < 1 {
+		importFlags.Usage()
+		os.Exit(1)
+	}
+	dbPath := importFlags.Arg(0)
+
+	if _, err := os.Stat(dbPath); err != nil {
+		log.Fatalf("Cannot read nostr-rs-relay database: %v", err)
+	}
+
+	// Open read-only so a running nostr-rs-relay isn't disturbed
+	src, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		log.Fatalf("Failed to open nostr-rs-relay database: %v", err)
+	}
+	defer src.Close()
+
+	cfg, store := openImportStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+	stats := &importStats{}
+
+	// nostr-rs-relay stores the full serialized event JSON in event.content
+	query := `SELECT content FROM event WHERE hidden != 1 ORDER BY id`
+	if *includeHidden {
+		query = `SELECT content FROM event ORDER BY id`
+	}
+
+	log.Printf("Starting nostr-rs-relay import from %s...", dbPath)
+
+	rows, err := src.QueryContext(ctx, query)
+	if err != nil {
+		log.Fatalf("Failed to query nostr-rs-relay events: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			log.Printf("Failed to read row: %v", err)
+			stats.failed++
+			continue
+		}
+
+		var evt nostr.Event
+		if err := json.Unmarshal([]byte(raw), &evt); err != nil {
+			log.Printf("Failed to parse event: %v", err)
+			stats.failed++
+			continue
+		}
+
+		importEvent(ctx, store, cfg, &evt, stats)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Fatalf("Import failed: %v (%s)", err, stats)
+	}
+
+	log.Printf("Import complete: %s", stats)
+}
@@ -0,0 +1,150 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// triggerPollInterval is how often a job checks for a run requested through the status API
+const triggerPollInterval = 10 * time.Second
+
+// ErrRunning is returned when a run is asked for while the job is already running
+var ErrRunning = errors.New("job already running")
+
+// Job is a background task whose runs are recorded in storage, so its status can
+// be reported, and a run requested, from any process sharing the database
+type Job struct {
+	name    string
+	storage *storage.Storage
+	run     func(ctx context.Context) error
+	mu      sync.Mutex // held while running
+	// Scheduled runs wait for the relay to be quiet; nil runs them on time
+	load *LoadMonitor
+}
+
+// New registers the job as run by process; run is what a scheduled or requested run executes
+func New(ctx context.Context, store *storage.Storage, name, process string, run func(ctx context.Context) error) *Job {
+	if err := store.RegisterJob(ctx, name, process); err != nil {
+		log.Printf("jobs: failed to register %s: %v", name, err)
+	}
+	return &Job{name: name, storage: store, run: run}
+}
+
+// DeferUnderLoad makes scheduled runs wait while load reports the relay busy.
+// Requested runs still start at once.
+func (j *Job) DeferUnderLoad(load *LoadMonitor) *Job {
+	j.load = load
+	return j
+}
+
+func (j *Job) Name() string {
+	return j.name
+}
+
+// Run runs the job and records the outcome
+func (j *Job) Run(ctx context.Context) error {
+	return j.Track(ctx, j.run)
+}
+
+// Track records fn as a run of the job, for callers that run the job's work
+// themselves. It returns ErrRunning without calling fn if a run is in progress.
+func (j *Job) Track(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !j.mu.TryLock() {
+		return ErrRunning
+	}
+	defer j.mu.Unlock()
+
+	start := time.Now()
+	if err := j.storage.MarkJobStarted(ctx, j.name, start); err != nil {
+		log.Printf("jobs: failed to record start of %s: %v", j.name, err)
+	}
+
+	err := fn(ctx)
+
+	if markErr := j.storage.MarkJobFinished(context.Background(), j.name, time.Since(start), err); markErr != nil {
+		log.Printf("jobs: failed to record end of %s: %v", j.name, markErr)
+	}
+	return err
+}
+
+// Every runs the job after delay and then every interval, and whenever a run is
+// requested. Scheduled runs deferred under load catch up as soon as it drops.
+func (j *Job) Every(ctx context.Context, delay, interval time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	poll := time.NewTicker(triggerPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if !j.load.WaitQuiet(ctx, j.name) && ctx.Err() != nil {
+				return
+			}
+		case <-poll.C:
+			if !j.runRequested(ctx) {
+				continue
+			}
+			log.Printf("jobs: running %s on request", j.name)
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		j.runAndLog(ctx)
+		timer.Reset(interval)
+	}
+}
+
+// Due returns how long until interval has passed since the job last succeeded,
+// for jobs whose schedule should survive restarts. A job that never succeeded is
+// due after a full interval.
+func (j *Job) Due(ctx context.Context, interval time.Duration) time.Duration {
+	status, err := j.storage.GetJobStatus(ctx, j.name)
+	if err != nil || status == nil || status.LastSuccessAt.IsZero() {
+		return interval
+	}
+	if wait := time.Until(status.LastSuccessAt.Add(interval)); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// WatchRequests runs the job whenever a run is requested, for jobs scheduled by their caller
+func (j *Job) WatchRequests(ctx context.Context) {
+	poll := time.NewTicker(triggerPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			if j.runRequested(ctx) {
+				log.Printf("jobs: running %s on request", j.name)
+				j.runAndLog(ctx)
+			}
+		}
+	}
+}
+
+func (j *Job) runAndLog(ctx context.Context) {
+	if err := j.Run(ctx); err != nil && !errors.Is(err, ErrRunning) {
+		log.Printf("jobs: %s failed: %v", j.name, err)
+	}
+}
+
+func (j *Job) runRequested(ctx context.Context) bool {
+	status, err := j.storage.GetJobStatus(ctx, j.name)
+	return err == nil && status != nil && status.RunRequested()
+}
@@ -0,0 +1,170 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	// loadSampleInterval is how often the relay records its load and other
+	// processes read it
+	loadSampleInterval = 15 * time.Second
+	// loadStaleAfter is how old a sample can be before it is ignored; a relay
+	// that stopped sampling is not holding anything up
+	loadStaleAfter = 4 * loadSampleInterval
+)
+
+// LoadMonitor tells background jobs whether the relay is busy with
+// interactive traffic: too many open connections, or REQ queries slower on
+// average than a threshold. The relay samples its own load and records it in
+// storage; the analytics worker follows the recorded samples. A nil monitor
+// never reports load.
+type LoadMonitor struct {
+	storage         *storage.Storage
+	maxConnections  int64
+	maxQueryLatency time.Duration
+	maxDefer        time.Duration
+
+	mu        sync.Mutex
+	current   storage.RelayLoad
+	queries   int64
+	queryTime time.Duration
+}
+
+// NewLoadMonitor treats the relay as busy above maxConnections connections or
+// maxQueryLatency mean query time. A scheduled run waits at most maxDefer for
+// the load to drop.
+func NewLoadMonitor(store *storage.Storage, maxConnections int, maxQueryLatency, maxDefer time.Duration) *LoadMonitor {
+	return &LoadMonitor{
+		storage:         store,
+		maxConnections:  int64(maxConnections),
+		maxQueryLatency: maxQueryLatency,
+		maxDefer:        maxDefer,
+	}
+}
+
+// ObserveQuery records how long a REQ's storage query took
+func (m *LoadMonitor) ObserveQuery(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries++
+	m.queryTime += d
+}
+
+// Sample records the load every loadSampleInterval, for the relay process.
+// connections returns the open websocket connections.
+func (m *LoadMonitor) Sample(ctx context.Context, connections func() int64) {
+	ticker := time.NewTicker(loadSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		load := storage.RelayLoad{Connections: connections(), SampledAt: time.Now()}
+		if m.queries > 0 {
+			load.QueryLatency = m.queryTime / time.Duration(m.queries)
+		}
+		m.queries, m.queryTime = 0, 0
+		m.current = load
+		m.mu.Unlock()
+
+		if err := m.storage.RecordRelayLoad(ctx, load); err != nil {
+			log.Printf("jobs: failed to record relay load: %v", err)
+		}
+	}
+}
+
+// Watch follows the load the relay records, for processes other than the relay
+func (m *LoadMonitor) Watch(ctx context.Context) {
+	ticker := time.NewTicker(loadSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		m.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *LoadMonitor) refresh(ctx context.Context) {
+	load, err := m.storage.GetRelayLoad(ctx)
+	if err != nil {
+		log.Printf("jobs: failed to read relay load: %v", err)
+		return
+	}
+	if load == nil {
+		return
+	}
+	m.mu.Lock()
+	m.current = *load
+	m.mu.Unlock()
+}
+
+// Load returns the latest sample
+func (m *LoadMonitor) Load() storage.RelayLoad {
+	if m == nil {
+		return storage.RelayLoad{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// Busy reports whether the latest sample is over either threshold
+func (m *LoadMonitor) Busy() bool {
+	if m == nil {
+		return false
+	}
+	load := m.Load()
+	if time.Since(load.SampledAt) > loadStaleAfter {
+		return false
+	}
+	return load.Connections > m.maxConnections || load.QueryLatency > m.maxQueryLatency
+}
+
+// WaitQuiet blocks while the relay is busy, for at most the monitor's
+// maxDefer, so name's run happens once interactive traffic has died down. It
+// returns false if it gave up waiting with the relay still busy.
+func (m *LoadMonitor) WaitQuiet(ctx context.Context, name string) bool {
+	if !m.Busy() {
+		return true
+	}
+
+	load := m.Load()
+	log.Printf("jobs: deferring %s, relay busy (%d connections, %v mean query)", name, load.Connections, load.QueryLatency)
+	start := time.Now()
+	deadline := time.NewTimer(m.maxDefer)
+	defer deadline.Stop()
+	ticker := time.NewTicker(loadSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-deadline.C:
+			log.Printf("jobs: running %s after deferring %v, relay still busy", name, time.Since(start).Round(time.Second))
+			return false
+		case <-ticker.C:
+			if !m.Busy() {
+				log.Printf("jobs: running %s after deferring %v", name, time.Since(start).Round(time.Second))
+				return true
+			}
+		}
+	}
+}
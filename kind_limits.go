@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pablof7z/purplepag.es/config"
+)
+
+// kindLimitsField is the NIP-11 extension field listing per-kind limits
+const kindLimitsField = "kind_limitation"
+
+// nip11Capture buffers the relay's NIP-11 document so fields can be added to it;
+// headers, CORS included, go straight to the client
+type nip11Capture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *nip11Capture) WriteHeader(status int) {
+	c.status = status
+}
+
+func (c *nip11Capture) Write(p []byte) (int, error) {
+	return c.body.Write(p)
+}
+
+// advertiseKindLimits adds the configured per-kind limits to the NIP-11 document
+// as {"kind_limitation": {"3": {"max_event_tags": 10000, "max_content_length": 131072}}},
+// next to the global "limitation", so clients can size events of those kinds
+func advertiseKindLimits(cfg *config.Config, next http.HandlerFunc) http.HandlerFunc {
+	limits := cfg.KindLimitOverrides()
+	if len(limits) == 0 {
+		return next
+	}
+
+	byKind := make(map[string]config.KindLimit, len(limits))
+	for kind, limit := range limits {
+		byKind[strconv.Itoa(kind)] = limit
+	}
+	field, err := json.Marshal(byKind)
+	if err != nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Accept") != "application/nostr+json" {
+			next(w, r)
+			return
+		}
+
+		capture := &nip11Capture{ResponseWriter: w, status: http.StatusOK}
+		next(capture, r)
+
+		var doc map[string]json.RawMessage
+		if capture.status != http.StatusOK || json.Unmarshal(capture.body.Bytes(), &doc) != nil {
+			w.WriteHeader(capture.status)
+			w.Write(capture.body.Bytes())
+			return
+		}
+		doc[kindLimitsField] = field
+		json.NewEncoder(w).Encode(doc)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/config"
+)
+
+// servable reports whether evt may go to a client authenticated as authed (""
+// when not): its kind is allowed and not never_serve, and an author_only kind
+// goes to its author only. Filters naming kinds are narrowed before querying;
+// events matched by filters without kinds are checked one by one with this.
+func servable(cfg *config.Config, evt *nostr.Event, authed string) bool {
+	if !cfg.IsKindAllowed(evt.Kind) {
+		return false
+	}
+	switch cfg.KindPrivacyPolicy(evt.Kind) {
+	case config.PrivacyNeverServe:
+		return false
+	case config.PrivacyAuthorOnly:
+		return authed != "" && evt.PubKey == authed
+	}
+	return true
+}
+
+// servableEvents drops the events a client authenticated as authed may not receive
+func servableEvents(cfg *config.Config, events []*nostr.Event, authed string) []*nostr.Event {
+	return slices.DeleteFunc(events, func(evt *nostr.Event) bool {
+		return !servable(cfg, evt, authed)
+	})
+}
+
+// countServable counts the events matching filter that a client authenticated
+// as authed may receive, so COUNT reveals no more than REQ would serve
+func countServable(ctx context.Context, count func(context.Context, nostr.Filter) (int64, error), cfg *config.Config, filter nostr.Filter, authed string) (int64, error) {
+	var total int64
+	var authorOnly []int
+
+	if len(filter.Kinds) > 0 {
+		var public []int
+		for _, kind := range filter.Kinds {
+			if !cfg.IsKindAllowed(kind) {
+				continue
+			}
+			switch cfg.KindPrivacyPolicy(kind) {
+			case config.PrivacyNeverServe:
+			case config.PrivacyAuthorOnly:
+				authorOnly = append(authorOnly, kind)
+			default:
+				public = append(public, kind)
+			}
+		}
+		if len(public) > 0 {
+			f := filter
+			f.Kinds = public
+			n, err := count(ctx, f)
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		}
+	} else {
+		// Without kinds, events of private kinds are taken back out of the
+		// total; kinds outside allowed_kinds are never stored to begin with
+		n, err := count(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+		total = n
+		if private := cfg.PrivateKinds(); len(private) > 0 {
+			f := filter
+			f.Kinds = private
+			hidden, err := count(ctx, f)
+			if err != nil {
+				return 0, err
+			}
+			total -= hidden
+			for _, kind := range private {
+				if cfg.KindPrivacyPolicy(kind) == config.PrivacyAuthorOnly {
+					authorOnly = append(authorOnly, kind)
+				}
+			}
+		}
+	}
+
+	// The client's own events of author_only kinds count too
+	if authed == "" || len(authorOnly) == 0 || (len(filter.Authors) > 0 && !slices.Contains(filter.Authors, authed)) {
+		return total, nil
+	}
+	f := filter
+	f.Kinds = authorOnly
+	f.Authors = []string{authed}
+	own, err := count(ctx, f)
+	if err != nil {
+		return 0, err
+	}
+	return total + own, nil
+}
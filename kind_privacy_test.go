@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/config"
+)
+
+// kindPrivacyFixture is a config with an author_only and a never_serve kind,
+// and one event of each kind, public kind 0 included, by the same author
+func kindPrivacyFixture(t *testing.T) (*config.Config, []*nostr.Event, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{
+		"allowed_kinds": [0, 4, 10000],
+		"kind_privacy": {"10000": "author_only", "4": "never_serve"}
+	}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	author, _ := nostr.GetPublicKey(sk)
+	var events []*nostr.Event
+	for _, kind := range []int{0, 4, 10000} {
+		evt := &nostr.Event{Kind: kind, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "{}"}
+		if err := evt.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, evt)
+	}
+	return cfg, events, author
+}
+
+// matching stands in for the store: the events matching filter, and their count
+func matching(events []*nostr.Event, filter nostr.Filter) []*nostr.Event {
+	var matched []*nostr.Event
+	for _, evt := range events {
+		if filter.Matches(evt) {
+			matched = append(matched, evt)
+		}
+	}
+	return matched
+}
+
+func counter(events []*nostr.Event) func(context.Context, nostr.Filter) (int64, error) {
+	return func(_ context.Context, filter nostr.Filter) (int64, error) {
+		return int64(len(matching(events, filter))), nil
+	}
+}
+
+// Filters without kinds skip the per-kind checks of REQs, so every event they
+// match must still be checked against its kind's privacy policy
+func TestKindPrivacyWithoutKinds(t *testing.T) {
+	cfg, events, author := kindPrivacyFixture(t)
+	other, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	filters := map[string]nostr.Filter{
+		"authors only": {Authors: []string{author}},
+		"ids only":     {IDs: []string{events[0].ID, events[1].ID, events[2].ID}},
+	}
+	clients := []struct {
+		name   string
+		authed string
+		want   []int
+	}{
+		{"anonymous", "", []int{0}},
+		{"another pubkey", other, []int{0}},
+		{"the author", author, []int{0, 10000}},
+	}
+
+	for name, filter := range filters {
+		for _, client := range clients {
+			served := servableEvents(cfg, matching(events, filter), client.authed)
+			var kinds []int
+			for _, evt := range served {
+				kinds = append(kinds, evt.Kind)
+			}
+			if len(kinds) != len(client.want) {
+				t.Errorf("%s, %s: served kinds %v, want %v", name, client.name, kinds, client.want)
+			} else {
+				for i := range kinds {
+					if kinds[i] != client.want[i] {
+						t.Errorf("%s, %s: served kinds %v, want %v", name, client.name, kinds, client.want)
+						break
+					}
+				}
+			}
+
+			count, err := countServable(context.Background(), counter(events), cfg, filter, client.authed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != int64(len(client.want)) {
+				t.Errorf("%s, %s: counted %d events, want %d", name, client.name, count, len(client.want))
+			}
+		}
+	}
+}
+
+func TestKindPrivacyCountWithKinds(t *testing.T) {
+	cfg, events, author := kindPrivacyFixture(t)
+
+	filter := nostr.Filter{Kinds: []int{0, 4, 10000}, Authors: []string{author}}
+	for authed, want := range map[string]int64{"": 1, author: 2} {
+		count, err := countServable(context.Background(), counter(events), cfg, filter, authed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Errorf("authed %q: counted %d events, want %d", authed, count, want)
+		}
+	}
+}
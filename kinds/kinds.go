@@ -0,0 +1,60 @@
+// Package kinds is the registry of event kind display names shared by every
+// page, template and API, filled from the kind_names config at startup.
+package kinds
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	mu    sync.RWMutex
+	names = map[int]string{}
+)
+
+// SetNames replaces the registry
+func SetNames(m map[int]string) {
+	copied := make(map[int]string, len(m))
+	for kind, name := range m {
+		copied[kind] = name
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	names = copied
+}
+
+// Lookup returns the display name of kind, if it has one
+func Lookup(kind int) (string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	name, ok := names[kind]
+	return name, ok
+}
+
+// Name is the display name of kind, or "Kind <n>" for kinds without one
+func Name(kind int) string {
+	if name, ok := Lookup(kind); ok {
+		return name
+	}
+	return fmt.Sprintf("Kind %d", kind)
+}
+
+// Entry is one named kind
+type Entry struct {
+	Kind int    `json:"kind"`
+	Name string `json:"name"`
+}
+
+// All returns every named kind, lowest kind first
+func All() []Entry {
+	mu.RLock()
+	defer mu.RUnlock()
+	entries := make([]Entry, 0, len(names))
+	for kind, name := range names {
+		entries = append(entries, Entry{Kind: kind, Name: name})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Kind < entries[j].Kind })
+	return entries
+}
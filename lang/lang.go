@@ -0,0 +1,210 @@
+// Package lang guesses the language of short texts such as profile bios and
+// maps languages to the PostgreSQL text search configuration that stems them.
+package lang
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Simple is the text search configuration used when no language is detected
+// or the language has no stemmer: words are only lowercased
+const Simple = "simple"
+
+// minStopwords is how many stopwords a text needs before its language is trusted
+const minStopwords = 2
+
+// analyzers maps language codes to their PostgreSQL text search configuration
+var analyzers = map[string]string{
+	"ar": "arabic",
+	"da": "danish",
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"id": "indonesian",
+	"it": "italian",
+	"nl": "dutch",
+	"no": "norwegian",
+	"pt": "portuguese",
+	"ru": "russian",
+	"sv": "swedish",
+	"tr": "turkish",
+}
+
+// stopwords are frequent words that tell Latin-script languages apart
+var stopwords = map[string][]string{
+	"da": {"og", "jeg", "det", "ikke", "er", "til", "af", "med", "på", "som", "har", "min", "mit"},
+	"de": {"und", "ich", "die", "der", "das", "nicht", "ist", "mit", "ein", "eine", "auf", "für", "bin", "von", "zu"},
+	"en": {"the", "and", "i", "is", "of", "to", "in", "a", "my", "for", "on", "with", "am", "at", "about", "love"},
+	"es": {"el", "la", "y", "de", "que", "en", "los", "las", "soy", "por", "con", "para", "una", "mi", "del"},
+	"fi": {"ja", "on", "ei", "että", "olen", "se", "mutta", "kun", "minä", "myös"},
+	"fr": {"le", "la", "et", "les", "des", "je", "suis", "est", "une", "pour", "dans", "du", "avec", "pas", "mon"},
+	"id": {"dan", "yang", "saya", "di", "ini", "itu", "dengan", "untuk", "tidak", "aku", "ke", "dari"},
+	"it": {"il", "e", "di", "che", "sono", "la", "per", "non", "una", "con", "del", "mi", "gli", "della"},
+	"nl": {"de", "en", "het", "een", "ik", "van", "is", "niet", "op", "met", "voor", "ben", "zijn"},
+	"no": {"og", "jeg", "det", "ikke", "er", "til", "av", "med", "på", "som", "har", "en", "meg"},
+	"pt": {"o", "e", "de", "que", "em", "os", "as", "sou", "um", "uma", "para", "com", "não", "do", "da"},
+	"sv": {"och", "jag", "det", "inte", "är", "att", "en", "på", "som", "med", "för", "av", "min"},
+	"tr": {"ve", "bir", "bu", "ben", "için", "ile", "de", "da", "çok", "ne", "gibi", "değil"},
+}
+
+var stopwordLanguages = func() map[string][]string {
+	byWord := make(map[string][]string)
+	for code, words := range stopwords {
+		for _, w := range words {
+			byWord[w] = append(byWord[w], code)
+		}
+	}
+	return byWord
+}()
+
+// Detect returns the language code of text, or "" if it can't tell. Texts in
+// a script used by few languages are judged by script, others by stopwords.
+func Detect(text string) string {
+	if code := detectScript(text); code != "" {
+		return code
+	}
+
+	scores := make(map[string]int)
+	words := 0
+	for _, word := range Words(text) {
+		words++
+		for _, code := range stopwordLanguages[word] {
+			scores[code]++
+		}
+	}
+	if words == 0 {
+		return ""
+	}
+
+	best, bestScore, runnerUp := "", 0, 0
+	for code, score := range scores {
+		if score > bestScore {
+			runnerUp = bestScore
+			best, bestScore = code, score
+		} else if score > runnerUp {
+			runnerUp = score
+		}
+	}
+	// Closely related languages share stopwords; a tie means no verdict
+	if bestScore < minStopwords || bestScore == runnerUp {
+		return ""
+	}
+	return best
+}
+
+// detectScript returns the language of text written mostly in a script tied
+// to one language, or "" for Latin and mixed texts
+func detectScript(text string) string {
+	counts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			counts["ja"]++
+		case unicode.Is(unicode.Hangul, r):
+			counts["ko"]++
+		case unicode.Is(unicode.Han, r):
+			counts["zh"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			counts["ru"]++
+		case unicode.Is(unicode.Arabic, r):
+			counts["ar"]++
+		case unicode.Is(unicode.Greek, r):
+			counts["el"]++
+		case unicode.Is(unicode.Thai, r):
+			counts["th"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// Japanese mixes kana with Han characters
+	if counts["ja"] > 0 && counts["ja"]+counts["zh"] > letters/2 {
+		return "ja"
+	}
+	for code, n := range counts {
+		if n > letters/2 {
+			return code
+		}
+	}
+	return ""
+}
+
+// Analyzer returns the text search configuration for a language code,
+// Simple for unknown codes and languages without a stemmer
+func Analyzer(code string) string {
+	if analyzer, ok := analyzers[code]; ok {
+		return analyzer
+	}
+	return Simple
+}
+
+// Analyzers returns every stemming text search configuration, sorted
+func Analyzers() []string {
+	result := make([]string, 0, len(analyzers))
+	for _, analyzer := range analyzers {
+		result = append(result, analyzer)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Words splits text into lowercased words, dropping punctuation, URLs and
+// the # of hashtags
+func Words(text string) []string {
+	var words []string
+	for _, field := range strings.Fields(strings.ToLower(text)) {
+		if strings.Contains(field, "://") {
+			continue
+		}
+		word := strings.TrimFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// Hashtags returns the distinct hashtags in text, lowercased and without the #
+func Hashtags(text string) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, field := range strings.Fields(text) {
+		if strings.Contains(field, "://") {
+			continue
+		}
+		for _, part := range strings.Split(field, "#")[1:] {
+			tag := strings.ToLower(part)
+			if end := strings.IndexFunc(tag, func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+			}); end >= 0 {
+				tag = tag[:end]
+			}
+			if tag == "" || seen[tag] || isNumber(tag) {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func isNumber(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
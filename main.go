@@ -8,9 +8,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
+	gosync "sync"
 	"syscall"
 	"time"
 
@@ -20,12 +23,25 @@ import (
 	"github.com/nbd-wtf/go-nostr/nip11"
 	"github.com/nbd-wtf/go-nostr/nip77"
 	"github.com/pablof7z/purplepag.es/analytics"
+	"github.com/pablof7z/purplepag.es/api"
+	"github.com/pablof7z/purplepag.es/billing"
+	"github.com/pablof7z/purplepag.es/capture"
+	"github.com/pablof7z/purplepag.es/changelog"
 	"github.com/pablof7z/purplepag.es/config"
+	"github.com/pablof7z/purplepag.es/features"
+	"github.com/pablof7z/purplepag.es/geoip"
+	"github.com/pablof7z/purplepag.es/imgproxy"
+	"github.com/pablof7z/purplepag.es/jobs"
+	"github.com/pablof7z/purplepag.es/kinds"
 	"github.com/pablof7z/purplepag.es/pages"
+	"github.com/pablof7z/purplepag.es/partners"
+	"github.com/pablof7z/purplepag.es/profiling"
 	relay2 "github.com/pablof7z/purplepag.es/relay"
+	"github.com/pablof7z/purplepag.es/static"
 	"github.com/pablof7z/purplepag.es/stats"
 	"github.com/pablof7z/purplepag.es/storage"
 	"github.com/pablof7z/purplepag.es/sync"
+	"github.com/pablof7z/purplepag.es/templates"
 )
 
 func main() {
@@ -40,16 +56,69 @@ func main() {
 		return
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "import-strfry" {
+		runImportStrfryCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import-nostrrs" {
+		runImportNostrRSCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "stats" {
+		runStatsCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "bootstrap" {
+		runBootstrapCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "backfill-relays" {
+		runBackfillRelaysCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "sync-plan" {
+		runSyncPlanCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "replay-log" {
+		runReplayLogCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "genfixtures" {
+		runGenFixturesCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "renormalize-cooccurrence" {
+		runRenormalizeCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "trust-simulate" {
+		runTrustSimulateCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		runConfigCommand(os.Args[2:])
+		return
+	}
+
 	port := flag.Int("port", 0, "Override port from config (use 9999 for sync-only test mode)")
 	importFile := flag.String("import", "", "Import events from JSONL file and exit")
 	testHydrator := flag.Bool("test-hydrator", false, "Run profile hydrator once and show results")
 	benchmarkHydrator := flag.Bool("benchmark-hydrator", false, "Benchmark hydrator performance on production DB")
 	flag.Parse()
 
-	cfg, err := config.Load("config.json")
-	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
-	}
+	cfg := loadCheckedConfig("config.json")
+	startProfiling(cfg.Profiling, "relay", true)
 
 	// Handle test-hydrator mode early (doesn't need production DB)
 	if *testHydrator {
@@ -73,39 +142,58 @@ func main() {
 
 	testMode := cfg.Server.Port == 9999
 
+	if err := api.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid server.trusted_proxies: %v", err)
+	}
+
 	store, err := storage.New(cfg.Storage.Backend, cfg.Storage.Path, *cfg.Storage.ArchiveEnabled, cfg.Storage.AnalyticsDBURL)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 	defer store.Close()
-
-	if err := store.InitRelayDiscoverySchema(); err != nil {
-		log.Fatalf("Failed to initialize relay discovery schema: %v", err)
-	}
-
-	if err := store.InitProfileHydrationSchema(); err != nil {
-		log.Fatalf("Failed to initialize profile hydration schema: %v", err)
+	store.SetScanChunkSize(cfg.Storage.ScanChunkSize)
+	if !store.AnalyticsEnabled() {
+		log.Println("WARNING: ================================================================")
+		log.Println("WARNING: analytics disabled: no SQL database configured")
+		log.Println("WARNING: the lmdb backend needs storage.analytics_db_url for REQ analytics,")
+		log.Println("WARNING: rankings enrichment, relay discovery, quotas and every /stats page;")
+		log.Println("WARNING: until then they are empty and analytics APIs answer 503")
+		log.Println("WARNING: ================================================================")
+		templates.SetNotice("Analytics disabled: no SQL database is configured (storage.analytics_db_url), so analytics on this page are empty.")
 	}
-
-	if err := store.InitAnalyticsSchema(); err != nil {
-		log.Fatalf("Failed to initialize analytics schema: %v", err)
+	store.SetQueryTimeout(time.Duration(cfg.Limits.QueryTimeoutMs) * time.Millisecond)
+	if err := store.ConfigurePools(cfg.Storage.ReadDBURL, cfg.Storage.MaxReadConns, cfg.Storage.MaxWriteConns); err != nil {
+		log.Fatalf("Failed to configure storage pools: %v", err)
 	}
-
-	if err := store.InitTrustedSyncSchema(); err != nil {
-		log.Fatalf("Failed to initialize trusted sync schema: %v", err)
+	if cfg.Storage.EventLogDir != "" {
+		eventLog, err := storage.OpenEventLog(cfg.Storage.EventLogDir, cfg.Storage.EventLogRetentionDays)
+		if err != nil {
+			log.Fatalf("Failed to open event log: %v", err)
+		}
+		defer eventLog.Close()
+		store.SetEventLog(eventLog)
+		log.Printf("Logging saved events to %s (retention %d days)", cfg.Storage.EventLogDir, cfg.Storage.EventLogRetentionDays)
 	}
 
-	if err := store.InitDailyStatsSchema(); err != nil {
-		log.Fatalf("Failed to initialize daily stats schema: %v", err)
-	}
+	initSchemas(store)
 
-	if err := store.InitEventHistorySchema(); err != nil {
-		log.Fatalf("Failed to initialize event history schema: %v", err)
+	if cfg.Privacy.HashIPs {
+		store.EnableIPHashing()
+		go func() {
+			scrubbed, err := store.ScrubRawIPs(context.Background())
+			if err != nil {
+				log.Printf("Failed to scrub raw IPs: %v", err)
+			} else if scrubbed > 0 {
+				log.Printf("IP hashing: scrubbed %d raw IPs from analytics", scrubbed)
+			}
+		}()
 	}
 
-	if err := store.InitStorageStatsSchema(); err != nil {
-		log.Fatalf("Failed to initialize storage stats schema: %v", err)
+	flags := features.New(store, cfg.FeatureDefaults())
+	if err := flags.Refresh(context.Background()); err != nil {
+		log.Printf("Failed to load feature overrides: %v", err)
 	}
+	store.SetArchiveGate(func() bool { return flags.Enabled(config.FeatureArchive) })
 
 	if *importFile != "" {
 		if err := importEventsFromJSONL(store, *importFile); err != nil {
@@ -116,16 +204,99 @@ func main() {
 	}
 
 	statsTracker := stats.New(store)
+	loadMonitor := newLoadMonitor(cfg, store)
+	var geoDB *geoip.DB
+	if cfg.GeoIP.Database != "" {
+		start := time.Now()
+		geoDB, err = geoip.Open(cfg.GeoIP.Database)
+		if err != nil {
+			log.Fatalf("Failed to load GeoIP database: %v", err)
+		}
+		statsTracker.SetGeoIP(geoDB)
+		log.Printf("Loaded %d GeoIP ranges from %s in %v", geoDB.Len(), cfg.GeoIP.Database, time.Since(start))
+	}
 	analyticsTracker := analytics.NewTracker(store)
 	clusterDetector := analytics.NewClusterDetector(store)
-	trustAnalyzer := analytics.NewTrustAnalyzer(store, clusterDetector, 10)
-	discovery := relay2.NewDiscovery(store)
-	if err := discovery.BackfillDiscoveredRelays(context.Background()); err != nil {
-		log.Printf("Warning: failed to backfill discovered relays: %v", err)
+	trustAnalyzer := analytics.NewTrustAnalyzer(store, clusterDetector, cfg.Limits.MinTrustedFollowers)
+	trustAnalyzer.SetMinReportScore(cfg.Limits.MinReportScore)
+	// Flagged impersonators are loaded from the analytics worker's results, only
+	// needed here when the policy acts on them in query responses
+	var impersonation *analytics.ImpersonationDetector
+	if !cfg.Impersonation.Disabled && cfg.Impersonation.Policy != config.ImpersonationFlag {
+		impersonation = analytics.NewImpersonationDetector(store, cfg.Impersonation.MinTargetFollowers, cfg.Impersonation.MaxFollowers)
+		if cfg.Impersonation.Policy == config.ImpersonationLabel {
+			labelKey, err := relay2.DecodeSecretKey(cfg.Impersonation.LabelKey)
+			if err != nil {
+				log.Fatalf("Invalid impersonation.label_key: %v", err)
+			}
+			impersonation.SetLabelKey(labelKey)
+		}
+	}
+	// Opt-in annotations expose the analytics worker's findings in-protocol
+	var annotator *analytics.Annotator
+	if cfg.Annotations.Enabled {
+		annotationKey, err := relay2.DecodeSecretKey(cfg.Annotations.Key)
+		if err != nil {
+			log.Fatalf("Invalid annotations.key: %v", err)
+		}
+		annotator = analytics.NewAnnotator(store, annotationKey)
+	}
+	// Changelog entries are published as notes from the relay key, which the
+	// NIP-11 document advertises when relay.pubkey isn't set
+	var changelogKey string
+	if cfg.Changelog.Key != "" {
+		key, err := relay2.DecodeSecretKey(cfg.Changelog.Key)
+		if err != nil {
+			log.Fatalf("Invalid changelog.key: %v", err)
+		}
+		pubkey, err := nostr.GetPublicKey(key)
+		if err != nil {
+			log.Fatalf("Invalid changelog.key: %v", err)
+		}
+		if cfg.Relay.Pubkey == "" {
+			cfg.Relay.Pubkey = pubkey
+		} else if cfg.Relay.Pubkey != pubkey {
+			log.Printf("Warning: changelog.key belongs to %s, not relay.pubkey %s", pubkey, cfg.Relay.Pubkey)
+		}
+		changelogKey = key
+	}
+	relayChangelog := changelog.New(store, changelogKey)
+	var scraperDetector *analytics.ScraperDetector
+	if !cfg.ScraperDetection.Disabled {
+		scraperDetector = analytics.NewScraperDetector(
+			store,
+			cfg.ScraperDetection.SingleAuthorPerMinute,
+			cfg.ScraperDetection.WindowWalksPerMinute,
+			cfg.ScraperDetection.Throttle,
+			cfg.ScraperDetection.ThrottleMinutes,
+		)
+	}
+	// Sync tokens for partner relays; without a token secret none verify, but
+	// anonymous negentropy sessions are still counted against their allowance
+	syncPartners := partners.New(store, cfg.Partners.TokenSecret)
+	var prefetcher *analytics.Prefetcher
+	if !cfg.Prefetch.Disabled {
+		prefetcher = analytics.NewPrefetcher(store, cfg.Prefetch.Fanout, cfg.Prefetch.TTLMinutes)
 	}
+	discovery := relay2.NewDiscovery(store)
+	// Pick up relays from relay lists stored before discovery ran on them, without holding up startup
+	go func() {
+		_, err := discovery.BackfillDiscoveredRelays(context.Background(), func(p relay2.BackfillProgress) {
+			if p.Done {
+				log.Printf("Relay backfill complete: %d events scanned, %d relays found, %d new", p.EventsScanned, p.RelaysFound, p.RelaysAdded)
+				return
+			}
+			log.Printf("Relay backfill: %d events scanned, %d relays found", p.EventsScanned, p.RelaysFound)
+		})
+		if err != nil {
+			log.Printf("Warning: failed to backfill discovered relays: %v", err)
+		}
+	}()
 	syncQueue := relay2.NewSyncQueue(store, cfg.SyncKinds)
 
 	relay := khatru.NewRelay()
+	// NIP-42 auth events must name this URL instead of one built from request headers
+	relay.ServiceURL = cfg.Server.PublicURL
 
 	relay.Info.Name = cfg.Relay.Name
 	relay.Info.Description = cfg.Relay.Description
@@ -140,39 +311,171 @@ func main() {
 		MaxLimit:         cfg.Limits.MaxLimit,
 		MaxEventTags:     cfg.Limits.MaxEventTags,
 		MaxContentLength: cfg.Limits.MaxContentLength,
+		MaxMessageLength: cfg.Limits.MaxMessageBytes,
+	}
+	relay.MaxMessageSize = int64(cfg.Limits.MaxMessageBytes)
+	relay.Negentropy = true
+
+	// Mirror sampled traffic to staging ahead of the reject hooks, so staging sees
+	// what clients sent rather than only what this relay accepted
+	var mirror *relay2.Mirror
+	if cfg.Mirror.URL != "" {
+		mirror = relay2.NewMirror(cfg.Mirror.URL, cfg.Mirror.SamplePercent, cfg.Mirror.QueueSize)
+
+		relay.RejectEvent = append(relay.RejectEvent, timedRejectEvent(statsTracker, "reject_event:mirror", func(ctx context.Context, event *nostr.Event) (bool, string) {
+			if !khatru.IsInternalCall(ctx) && mirror.Sampled(event.ID) {
+				mirror.MirrorEvent(event)
+			}
+			return false, ""
+		}))
+		relay.RejectFilter = append(relay.RejectFilter, timedRejectFilter(statsTracker, "reject_filter:mirror", func(ctx context.Context, filter nostr.Filter) (bool, string) {
+			if khatru.IsInternalCall(ctx) {
+				return false, ""
+			}
+			// Keyed on the subscription so all filters of a REQ are mirrored together
+			if mirror.Sampled(fmt.Sprintf("%p/%s", khatru.GetConnection(ctx), khatru.GetSubscriptionID(ctx))) {
+				mirror.MirrorFilter(filter)
+			}
+			return false, ""
+		}))
+	}
+
+	// rejectOversize refuses an event over a size limit with a NOTICE as well as the
+	// OK message, and counts the attempt against the sending IP
+	rejectOversize := func(ctx context.Context, reason string, size int, message string) (bool, string) {
+		statsTracker.RecordEventRejected()
+		store.RecordOversizeAttempt(ctx, khatru.GetIP(ctx), reason, size)
+		if ws := khatru.GetConnection(ctx); ws != nil {
+			ws.WriteJSON(nostr.NoticeEnvelope(message))
+		}
+		return true, message
 	}
 
-	relay.RejectEvent = append(relay.RejectEvent, func(ctx context.Context, event *nostr.Event) (bool, string) {
+	relay.RejectEvent = append(relay.RejectEvent, timedRejectEvent(statsTracker, "reject_event:kind_and_size", func(ctx context.Context, event *nostr.Event) (bool, string) {
 		if !cfg.IsKindAllowed(event.Kind) {
 			statsTracker.RecordEventRejectedForKind(ctx, event.Kind, event.PubKey)
 			return true, fmt.Sprintf("kind %d is not allowed", event.Kind)
 		}
-		if len(event.Tags) > cfg.Limits.MaxEventTags {
-			statsTracker.RecordEventRejected()
-			return true, fmt.Sprintf("too many tags: %d (max %d)", len(event.Tags), cfg.Limits.MaxEventTags)
+		limit := cfg.KindLimit(event.Kind)
+		if len(event.Tags) > limit.MaxEventTags {
+			return rejectOversize(ctx, "tags", len(event.Tags),
+				fmt.Sprintf("invalid: too many tags for kind %d: %d (max %d)", event.Kind, len(event.Tags), limit.MaxEventTags))
 		}
-		if len(event.Content) > cfg.Limits.MaxContentLength {
-			statsTracker.RecordEventRejected()
-			return true, fmt.Sprintf("content too long: %d (max %d)", len(event.Content), cfg.Limits.MaxContentLength)
+		if len(event.Content) > limit.MaxContentLength {
+			return rejectOversize(ctx, "content", len(event.Content),
+				fmt.Sprintf("invalid: content too long for kind %d: %d (max %d)", event.Kind, len(event.Content), limit.MaxContentLength))
 		}
 		return false, ""
-	})
+	}))
+
+	relay.RejectEvent = append(relay.RejectEvent, timedRejectEvent(statsTracker, "reject_event:schema", func(ctx context.Context, event *nostr.Event) (bool, string) {
+		violation := validateEventSchema(event)
+		if violation == nil {
+			return false, ""
+		}
+		statsTracker.RecordEventRejected()
+		store.RecordInvalidEvent(ctx, event.Kind, violation.code)
+		return true, violation.message
+	}))
+
+	relay.RejectEvent = append(relay.RejectEvent, timedRejectEvent(statsTracker, "reject_event:pubkey_quota", func(ctx context.Context, event *nostr.Event) (bool, string) {
+		quota := cfg.Limits.PubkeyEventsPerDay
+		if trustAnalyzer.IsTrusted(event.PubKey) {
+			quota = cfg.Limits.TrustedPubkeyEventsPerDay
+		}
+		used, err := store.GetPubkeyQuotaUsage(ctx, event.PubKey)
+		if err != nil || used < int64(quota) {
+			return false, ""
+		}
+		statsTracker.RecordEventRejected()
+		store.RecordQuotaRejected(ctx, event.PubKey)
+		trustAnalyzer.RecordSignal(ctx, event.PubKey, analytics.SignalSpamBurst)
+		return true, fmt.Sprintf("rate-limited: daily quota of %d events exhausted", quota)
+	}))
+
+	reqFilters := newReqFilterCounter(statsTracker)
+	reqDiff := newReqDiffs()
+	relay.RejectFilter = append(relay.RejectFilter, timedRejectFilter(statsTracker, "reject_filter:filter_size", func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if khatru.IsInternalCall(ctx) {
+			return false, ""
+		}
+		statsTracker.ObserveFilterSize("authors", len(filter.Authors))
+		statsTracker.ObserveFilterSize("ids", len(filter.IDs))
 
-	relay.RejectFilter = append(relay.RejectFilter, func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if n := reqFilters.add(ctx); n > cfg.Limits.MaxFilters {
+			return true, fmt.Sprintf("blocked: too-many-filters: a REQ may have at most %d filters; send the rest in another REQ", cfg.Limits.MaxFilters)
+		}
+		if len(filter.Authors) > cfg.Limits.MaxAuthors {
+			return true, fmt.Sprintf("blocked: too-many-authors: filter has %d authors, at most %d are allowed; split them across REQs", len(filter.Authors), cfg.Limits.MaxAuthors)
+		}
+		if len(filter.IDs) > cfg.Limits.MaxIDs {
+			return true, fmt.Sprintf("blocked: too-many-ids: filter has %d ids, at most %d are allowed; split them across REQs", len(filter.IDs), cfg.Limits.MaxIDs)
+		}
+		return false, ""
+	}))
+
+	relay.RejectFilter = append(relay.RejectFilter, timedRejectFilter(statsTracker, "reject_filter:max_limit", func(ctx context.Context, filter nostr.Filter) (bool, string) {
 		if filter.Limit > cfg.Limits.MaxLimit {
 			return true, fmt.Sprintf("limit too high: %d (max %d)", filter.Limit, cfg.Limits.MaxLimit)
 		}
 		return false, ""
-	})
+	}))
+
+	relay.RejectFilter = append(relay.RejectFilter, timedRejectFilter(statsTracker, "reject_filter:oversize", func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		// A filter with its own limit already asks for a truncated answer
+		if filter.Limit > 0 || cfg.OversizeFilterPolicy(filter.Kinds) != config.OversizeReject {
+			return false, ""
+		}
+		count, err := store.CountEvents(ctx, filter)
+		if err != nil || count <= int64(cfg.Limits.MaxLimit) {
+			return false, ""
+		}
+		return true, fmt.Sprintf("blocked: too-many-results: filter matches %d events, more than the %d served; narrow it or set a limit", count, cfg.Limits.MaxLimit)
+	}))
 
-	relay.RejectFilter = append(relay.RejectFilter, func(ctx context.Context, filter nostr.Filter) (bool, string) {
+	relay.RejectFilter = append(relay.RejectFilter, timedRejectFilter(statsTracker, "reject_filter:kinds_required", func(ctx context.Context, filter nostr.Filter) (bool, string) {
 		if len(filter.Kinds) == 0 {
 			return true, "filters must specify at least one kind"
 		}
 		return false, ""
-	})
+	}))
+
+	// NIP-77 sessions walk whole kinds, so they get their own hourly allowance,
+	// a larger one for partners holding a sync token
+	relay.RejectFilter = append(relay.RejectFilter, timedRejectFilter(statsTracker, "reject_filter:negentropy", func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if !eventstore.IsNegentropySession(ctx) {
+			return false, ""
+		}
+		if partner, ok := syncPartners.FromConnection(ctx); ok {
+			if !syncPartners.AllowNegentropy("partner:"+partner.ID, cfg.Partners.PartnerNegentropyPerHour) {
+				return true, "rate-limited: partner negentropy allowance used up for this hour"
+			}
+			syncPartners.RecordNegentropySession(partner.ID)
+			return false, ""
+		}
+		if !syncPartners.AllowNegentropy("ip:"+khatru.GetIP(ctx), cfg.Partners.NegentropyPerHour) {
+			if cfg.Partners.NegentropyPerHour < 0 {
+				return true, "restricted: negentropy sync requires a partner sync token"
+			}
+			return true, "rate-limited: negentropy allowance used up for this hour"
+		}
+		return false, ""
+	}))
+
+	relay.RejectFilter = append(relay.RejectFilter, timedRejectFilter(statsTracker, "reject_filter:scraper", func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if _, ok := syncPartners.FromConnection(ctx); ok {
+			return false, ""
+		}
+		if scraperDetector != nil && scraperDetector.IsThrottled(clientIP(ctx)) {
+			return true, "rate-limited: request pattern looks like scraping"
+		}
+		return false, ""
+	}))
 
-	relay.RejectFilter = append(relay.RejectFilter, func(ctx context.Context, filter nostr.Filter) (bool, string) {
+	relay.RejectFilter = append(relay.RejectFilter, timedRejectFilter(statsTracker, "reject_filter:ip_rate_limit", func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if _, ok := syncPartners.FromConnection(ctx); ok {
+			return false, ""
+		}
 		ip := khatru.GetIP(ctx)
 		eventsServed, err := store.GetEventsServedLast24Hours(ctx, ip)
 		if err != nil {
@@ -193,44 +496,133 @@ func main() {
 			return true, fmt.Sprintf("rate limit exceeded")
 		}
 		return false, ""
-	})
+	}))
 
-	relay.StoreEvent = append(relay.StoreEvent, func(ctx context.Context, event *nostr.Event) error {
+	relay.StoreEvent = append(relay.StoreEvent, timedStoreEvent(statsTracker, "store_event", func(ctx context.Context, event *nostr.Event) error {
 		start := time.Now()
 		if err := store.SaveEvent(ctx, event); err != nil {
+			if err == eventstore.ErrDupEvent {
+				return err
+			}
+			// Turn backend failures clients can't act on into a reason with a retry hint
+			if serr := storage.ClassifyError(err); serr != nil {
+				statsTracker.RecordStorageFailure(serr.Class)
+				log.Printf("StoreEvent failed (%s): kind=%d pubkey=%s: %v", serr.Class, event.Kind, event.PubKey[:8], err)
+				return serr
+			}
+			statsTracker.RecordStorageFailure("other")
 			return err
 		}
+		if err := store.RecordProvenance(ctx, event.ID, storage.ProvenanceClient, ""); err != nil {
+			log.Printf("Failed to record provenance of %s: %v", event.ID, err)
+		}
 		elapsed := time.Since(start)
 		if elapsed > 100*time.Millisecond {
 			log.Printf("SLOW StoreEvent: kind=%d tags=%d elapsed=%v pubkey=%s", event.Kind, len(event.Tags), elapsed, event.PubKey[:8])
 		}
 		statsTracker.RecordEventAccepted(event.Kind)
 		return nil
-	})
+	}))
 
-	relay.OnEventSaved = append(relay.OnEventSaved, func(ctx context.Context, event *nostr.Event) {
-		if event.Kind == 10002 {
+	relay.OnEventSaved = append(relay.OnEventSaved, timedOnEventSaved(statsTracker, "on_event_saved", func(ctx context.Context, event *nostr.Event) {
+		if relay2.IsDiscoveryKind(event.Kind) {
+			start := time.Now()
 			discovery.ExtractRelaysFromEvent(ctx, event)
+			statsTracker.ObserveHook("on_event_saved:discovery", time.Since(start))
 		}
-	})
+		if event.Kind == 0 || event.Kind == 3 {
+			start := time.Now()
+			trustAnalyzer.CheckCompromise(ctx, event)
+			trustAnalyzer.OnContactListSaved(event)
+			statsTracker.ObserveHook("on_event_saved:trust", time.Since(start))
+		}
+		if event.Kind == storage.KindMigrationAttestation || event.Kind == storage.KindKeyMigration {
+			if m, err := store.LinkKeyMigration(ctx, event); err != nil {
+				log.Printf("Failed to link key migration from %s: %v", event.ID, err)
+			} else if m != nil {
+				log.Printf("Linked key migration %s -> %s", m.OldPubkey, m.NewPubkey)
+			}
+		}
+		if event.Kind == storage.KindReport {
+			for _, report := range storage.ReportsFromEvent(event) {
+				if err := store.SaveAbuseReport(ctx, report); err != nil {
+					log.Printf("Failed to record report from %s: %v", event.ID, err)
+				}
+			}
+		}
+		start := time.Now()
+		store.RecordQuotaAccepted(ctx, event.PubKey)
+		statsTracker.ObserveHook("on_event_saved:quota", time.Since(start))
+	}))
+
+	relay.QueryEvents = append(relay.QueryEvents, timedQueryEvents(statsTracker, "query_events", func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		analyticsStart := time.Now()
+		// Large REQs repeat the same author lists; intern once and share the set
+		authors := store.InternAuthors(filter.Authors)
+		if flags.Enabled(config.FeatureAnalytics) {
+			analyticsTracker.RecordREQ(khatru.GetIP(ctx), filter, authors)
+		}
+		if scraperDetector != nil {
+			scraperDetector.RecordFilter(clientIP(ctx), khatru.GetConnection(ctx), filter)
+		}
+		if prefetcher != nil {
+			prefetcher.RecordREQ(filter)
+		}
+		if partner, ok := syncPartners.FromConnection(ctx); ok && !eventstore.IsNegentropySession(ctx) {
+			syncPartners.RecordRequest(partner.ID)
+		}
+		statsTracker.ObserveHook("query_events:analytics", time.Since(analyticsStart))
 
-	relay.QueryEvents = append(relay.QueryEvents, func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
-		analyticsTracker.RecordREQ(filter)
+		// A repeated filter from a client opted into diff streaming skips what it already has
+		diffConn, diffHash := reqDiff.key(ctx, filter)
+		var alreadyServed map[[8]byte]struct{}
+		if diffHash != "" {
+			alreadyServed = reqDiff.served(diffConn, diffHash)
+		}
+
+		// Under the label policy, REQs for kind 1985 also get our impersonation labels
+		internal := khatru.IsInternalCall(ctx)
+		var labels []*nostr.Event
+		if impersonation != nil && cfg.Impersonation.Policy == config.ImpersonationLabel && !internal && slices.Contains(filter.Kinds, 1985) {
+			labels = impersonation.Labels(filter, effectiveLimit(filter, cfg.Limits.MaxLimit))
+		}
+		// Filters with our annotation namespace in #L also get annotations about the pubkeys they name
+		if annotator != nil && !internal && analytics.OptsIntoAnnotations(&filter) {
+			labels = append(labels, annotator.Annotations(filter, effectiveLimit(filter, cfg.Limits.MaxLimit))...)
+		}
+		hideImpersonators := impersonation != nil && cfg.Impersonation.Policy == config.ImpersonationHide && !internal
 
-		// Track REQ kinds for stats and filter out disallowed kinds
+		// Track REQ kinds for stats and filter out disallowed and private kinds
+		authed := khatru.GetAuthed(ctx)
+		authorOnly := false
 		allowedKinds := make([]int, 0, len(filter.Kinds))
 		for _, kind := range filter.Kinds {
 			statsTracker.RecordREQKind(ctx, kind)
 			if !cfg.IsKindAllowed(kind) {
 				statsTracker.RecordRejectedREQ(ctx, kind)
-			} else {
-				allowedKinds = append(allowedKinds, kind)
+				continue
+			}
+			switch policy := cfg.KindPrivacyPolicy(kind); policy {
+			case config.PrivacyNeverServe:
+				statsTracker.RecordPrivacyRejectedREQ(ctx, kind, policy)
+				continue
+			case config.PrivacyAuthorOnly:
+				if authed == "" {
+					statsTracker.RecordPrivacyRejectedREQ(ctx, kind, policy)
+					khatru.RequestAuth(ctx)
+					continue
+				}
+				authorOnly = true
 			}
+			allowedKinds = append(allowedKinds, kind)
 		}
 
 		// If no allowed kinds remain after filtering, return empty immediately
 		if len(filter.Kinds) > 0 && len(allowedKinds) == 0 {
-			ch := make(chan *nostr.Event)
+			ch := make(chan *nostr.Event, len(labels))
+			for _, label := range labels {
+				ch <- label
+			}
 			close(ch)
 			return ch, nil
 		}
@@ -238,15 +630,34 @@ func main() {
 		// Update filter with only allowed kinds
 		filter.Kinds = allowedKinds
 
+		// Filters matching more than max_limit are truncated to the newest events, or
+		// for trusted_first kinds to trusted authors' events first
+		limit := effectiveLimit(filter, cfg.Limits.MaxLimit)
+		filter.Limit = limit
+		oversizePolicy := cfg.OversizeFilterPolicy(filter.Kinds)
+		if oversizePolicy == config.OversizeTrustedFirst {
+			filter.Limit = limit * trustedFirstOversample
+		}
+
 		start := time.Now()
-		events, err := store.QueryEvents(ctx, filter)
-		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		events, err := store.QueryEventsWithAuthorSet(ctx, filter, authors)
+		elapsed := time.Since(start)
+		statsTracker.ObserveHook("query_events:storage", elapsed)
+		loadMonitor.ObserveQuery(elapsed)
+		if elapsed > 100*time.Millisecond {
 			log.Printf("SLOW QueryEvents: kinds=%v authors=%d tags=%d limit=%d elapsed=%v results=%d",
 				filter.Kinds, len(filter.Authors), len(filter.Tags), filter.Limit, elapsed, len(events))
 		}
 		if err != nil {
 			return nil, err
 		}
+		if oversizePolicy == config.OversizeTrustedFirst && len(events) > limit {
+			events = trustedFirst(events, trustAnalyzer.IsTrusted, limit)
+		}
+		// Filters without kinds skipped the kind checks above, so their events are checked instead
+		if len(filter.Kinds) == 0 && !internal {
+			events = servableEvents(cfg, events, authed)
+		}
 
 		ip := khatru.GetIP(ctx)
 
@@ -254,7 +665,26 @@ func main() {
 		go func() {
 			defer close(ch)
 			var count int64
+			var matched map[[8]byte]struct{}
+			if diffHash != "" {
+				matched = make(map[[8]byte]struct{}, len(events))
+			}
+			events = append(labels, events...)
 			for _, evt := range events {
+				// Author-only kinds go to their author and nobody else
+				if authorOnly && evt.PubKey != authed && cfg.KindPrivacyPolicy(evt.Kind) == config.PrivacyAuthorOnly {
+					continue
+				}
+				if hideImpersonators && evt.Kind == 0 && impersonation.IsFlagged(evt.PubKey) {
+					continue
+				}
+				if matched != nil {
+					id := eventIDPrefix(evt)
+					matched[id] = struct{}{}
+					if _, ok := alreadyServed[id]; ok {
+						continue
+					}
+				}
 				select {
 				case ch <- evt:
 					count++
@@ -264,27 +694,47 @@ func main() {
 				}
 			}
 			statsTracker.RecordEventsServed(context.Background(), ip, count)
+			if matched != nil {
+				reqDiff.remember(diffConn, diffHash, matched)
+			}
 		}()
 
 		return ch, nil
-	})
+	}))
 
 	relay.DeleteEvent = append(relay.DeleteEvent, func(ctx context.Context, event *nostr.Event) error {
 		return store.DeleteEvent(ctx, event)
 	})
 
 	relay.CountEvents = append(relay.CountEvents, func(ctx context.Context, filter nostr.Filter) (int64, error) {
-		return store.CountEvents(ctx, filter)
+		if khatru.IsInternalCall(ctx) {
+			return store.CountEvents(ctx, filter)
+		}
+		return countServable(ctx, store.CountEvents, cfg, filter, khatru.GetAuthed(ctx))
 	})
 
 	relay.OnConnect = append(relay.OnConnect, func(ctx context.Context) {
 		statsTracker.RecordConnection()
+		attachMessageGuard(ctx)
 	})
 
 	relay.OnDisconnect = append(relay.OnDisconnect, func(ctx context.Context) {
 		statsTracker.RecordDisconnection()
+		if conn := khatru.GetConnection(ctx); conn != nil {
+			reqDiff.forget(conn)
+			if scraperDetector != nil {
+				scraperDetector.Forget(conn)
+			}
+		}
 	})
 
+	syncCredentials, err := relay2.NewCredentials(cfg.Sync.AuthKey, cfg.Sync.RelayAuthKeys)
+	if err != nil {
+		log.Fatalf("Invalid sync auth key: %v", err)
+	}
+
+	// Under the gated startup mode /readyz fails until this is far enough along
+	var initialSync *sync.Syncer
 	if cfg.Sync.Enabled && len(cfg.Sync.Relays) > 0 {
 		syncKinds := cfg.Sync.Kinds
 		if len(syncKinds) == 0 {
@@ -292,6 +742,8 @@ func main() {
 		}
 		log.Printf("Starting initial sync from %d relays for %d kinds...", len(cfg.Sync.Relays), len(syncKinds))
 		syncer := sync.NewSyncer(store, syncKinds, cfg.Sync.Relays)
+		syncer.SetCredentials(syncCredentials)
+		initialSync = syncer
 
 		if testMode {
 			log.Println("Test mode: running sync and exiting...")
@@ -316,10 +768,72 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	go flags.Watch(ctx)
+	if loadMonitor != nil {
+		go loadMonitor.Sample(ctx, statsTracker.GetActiveConnections)
+	}
 	analyticsTracker.Start(ctx)
+	go statsTracker.Start(ctx)
+	if scraperDetector != nil {
+		go scraperDetector.Start(ctx)
+	}
+	if prefetcher != nil {
+		go prefetcher.Start(ctx)
+	}
+	go trustAnalyzer.StartIncremental(ctx)
+	go trustAnalyzer.StartReload(ctx, 10*time.Minute)
+	if impersonation != nil {
+		go impersonation.StartReload(ctx, 10*time.Minute)
+	}
+	if annotator != nil {
+		go annotator.StartReload(ctx, 10*time.Minute)
+	}
+	if mirror != nil {
+		mirror.Start(ctx)
+	}
 
 	log.Println("Relay: heavy analytics disabled in relay process - run './purplepages analytics' separately")
 
+	// Build the follows index from stored contact lists on first start; saves keep it current after that
+	go func() {
+		if err := store.EnsureFollowsIndex(ctx); err != nil {
+			log.Printf("Failed to build follows index: %v", err)
+		}
+	}()
+
+	// Likewise the profile search index, from stored profiles
+	go func() {
+		if err := store.EnsureProfileSearchIndex(ctx); err != nil {
+			log.Printf("Failed to build profile search index: %v", err)
+		}
+	}()
+
+	// And the lightning address index
+	go func() {
+		if err := store.EnsureLightningAddressIndex(ctx); err != nil {
+			log.Printf("Failed to build lightning address index: %v", err)
+		}
+	}()
+
+	go watchWriteStats(ctx, store)
+
+	// Persist upstream relay integrity counts
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := store.FlushRelayIntegrity(ctx); err != nil {
+					log.Printf("Failed to flush relay integrity: %v", err)
+				}
+			}
+		}
+	}()
+
 	// Record daily storage snapshots
 	go func() {
 		// Wait 5 minutes before first snapshot to ensure database is fully initialized
@@ -331,6 +845,9 @@ func main() {
 		} else {
 			log.Println("Recorded initial storage snapshot")
 		}
+		if err := store.RecordDailyKindCounts(ctx); err != nil {
+			log.Printf("Failed to record kind counts: %v", err)
+		}
 
 		ticker := time.NewTicker(24 * time.Hour)
 		defer ticker.Stop()
@@ -345,6 +862,12 @@ func main() {
 				} else {
 					log.Println("Recorded daily storage snapshot")
 				}
+				if err := store.RecordDailyKindCounts(ctx); err != nil {
+					log.Printf("Failed to record kind counts: %v", err)
+				}
+				if err := store.CleanupQuotas(ctx, 30); err != nil {
+					log.Printf("Failed to clean up quota counters: %v", err)
+				}
 			}
 		}
 	}()
@@ -354,6 +877,9 @@ func main() {
 		syncQueue.Start(ctx)
 	}()
 
+	// Hydration and trusted sync coalesce fetches of the same pubkey from the same relay
+	upstreamFetches := relay2.NewInFlight()
+
 	var hydrator *relay2.ProfileHydrator
 	if cfg.ProfileHydration.Enabled && len(cfg.Sync.Relays) > 0 {
 		hydrator = relay2.NewProfileHydrator(
@@ -363,10 +889,20 @@ func main() {
 			cfg.ProfileHydration.RetryAfterHours,
 			cfg.ProfileHydration.BatchSize,
 		)
-		go func() {
-			time.Sleep(3 * time.Minute) // Wait a bit after startup
-			hydrator.Start(ctx, cfg.ProfileHydration.IntervalMinutes)
-		}()
+		hydrator.SetDeadAccountPolicy(cfg.ProfileHydration.DeadAfterRounds, time.Duration(cfg.ProfileHydration.DeadRetryDays)*24*time.Hour)
+		// A run may keep taking batches for one interval, so runs don't pile up
+		hydrator.SetAdaptiveBatching(cfg.ProfileHydration.MinBatchSize, cfg.ProfileHydration.MaxBatchSize, time.Duration(cfg.ProfileHydration.IntervalMinutes)*time.Minute)
+		hydrator.SetCredentials(syncCredentials)
+		hydrator.SetInFlight(upstreamFetches)
+		hydratorJob := jobs.New(ctx, store, "hydrator", "relay", func(ctx context.Context) error {
+			if !flags.Enabled(config.FeatureHydrator) {
+				return nil
+			}
+			hydrator.RunOnce(ctx)
+			return nil
+		})
+		// Wait a bit after startup
+		go hydratorJob.Every(ctx, 3*time.Minute, time.Duration(cfg.ProfileHydration.IntervalMinutes)*time.Minute)
 	}
 
 	var trustedSyncer *relay2.TrustedSyncer
@@ -378,10 +914,17 @@ func main() {
 			cfg.TrustedSync.BatchSize,
 			cfg.TrustedSync.TimeoutSeconds,
 		)
-		go func() {
-			time.Sleep(6 * time.Minute) // Wait for trust analyzer to run first
-			trustedSyncer.Start(ctx, cfg.TrustedSync.IntervalMinutes)
-		}()
+		trustedSyncer.SetCredentials(syncCredentials)
+		trustedSyncer.SetInFlight(upstreamFetches)
+		trustedSyncJob := jobs.New(ctx, store, "trusted_sync", "relay", func(ctx context.Context) error {
+			if !flags.Enabled(config.FeatureTrustedSync) {
+				return nil
+			}
+			trustedSyncer.RunOnce(ctx)
+			return nil
+		})
+		// Wait for trust analyzer to run first
+		go trustedSyncJob.Every(ctx, 6*time.Minute, time.Duration(cfg.TrustedSync.IntervalMinutes)*time.Minute)
 	}
 
 	// Cross-kind sync: runs once after startup to fill gaps between sync kinds
@@ -418,17 +961,172 @@ func main() {
 		go syncSubscriber.Start(ctx)
 	}
 
-	pageHandler := pages.NewHandler(store)
+	rankings := api.NewRankings(store)
+	rankingsJob := jobs.New(ctx, store, "rankings_refresh", "relay", rankings.Refresh).DeferUnderLoad(loadMonitor)
+	go rankingsJob.Every(ctx, 0, api.RankingsRefreshInterval)
+
+	// Aggregate dataset statistics for /api/v1/dataset
+	dataset := api.NewDataset(store)
+	datasetJob := jobs.New(ctx, store, "dataset_refresh", "relay", dataset.Refresh).DeferUnderLoad(loadMonitor)
+	go datasetJob.Every(ctx, 0, api.DatasetRefreshInterval)
+
+	census := relay2.NewCensusHarvester(store)
+	censusJob := jobs.New(ctx, store, "relay_census", "relay", census.Harvest)
+	go censusJob.Every(ctx, 10*time.Minute, 24*time.Hour)
+
+	// Followers are ranked by their own follower counts, refreshed from the follows index
+	followerCountsJob := jobs.New(ctx, store, "follower_counts_refresh", "relay", store.RefreshFollowerCounts).DeferUnderLoad(loadMonitor)
+	go followerCountsJob.Every(ctx, followerCountsJob.Due(ctx, time.Hour), time.Hour)
+
+	// The most muted pubkeys on /stats/social are counted across every mute list
+	mostMutedJob := jobs.New(ctx, store, "most_muted_refresh", "relay", store.RefreshMostMuted).DeferUnderLoad(loadMonitor)
+	go mostMutedJob.Every(ctx, mostMutedJob.Due(ctx, time.Hour), time.Hour)
+
+	// Profile bundles of the most requested pubkeys answer their REQs from memory;
+	// reloading them reads thousands of events, so it waits for quiet periods
+	if cfg.Prefetch.BundlePubkeys > 0 {
+		store.EnableProfileBundles(cfg.Prefetch.BundlePubkeys, int64(cfg.Prefetch.BundleMB)<<20)
+		bundlesJob := jobs.New(ctx, store, "profile_bundles", "relay", store.RefreshProfileBundles).DeferUnderLoad(loadMonitor)
+		go bundlesJob.Every(ctx, time.Minute, 15*time.Minute)
+	}
+
+	milestonesJob := jobs.New(ctx, store, "changelog_milestones", "relay", relayChangelog.CheckMilestones).DeferUnderLoad(loadMonitor)
+	go milestonesJob.Every(ctx, milestonesJob.Due(ctx, 24*time.Hour), 24*time.Hour)
+
+	outboxJob := jobs.New(ctx, store, "outbox_probe", "relay", relay2.NewOutboxProber(store).Probe).DeferUnderLoad(loadMonitor)
+	go outboxJob.Every(ctx, outboxJob.Due(ctx, 24*time.Hour), 24*time.Hour)
+
+	// Each negentropy peer is its own job, so peers keep their own schedule and
+	// can be run from /api/v1/jobs one at a time
+	for _, peer := range cfg.NegentropyPeers {
+		if !strings.HasPrefix(peer.URL, "ws://") && !strings.HasPrefix(peer.URL, "wss://") {
+			peer.URL = "wss://" + peer.URL
+		}
+		name := "negentropy_sync"
+		if u, err := url.Parse(peer.URL); err == nil {
+			name += ":" + u.Host
+		}
+		interval := time.Duration(peer.IntervalMinutes) * time.Minute
+		negentropyJob := jobs.New(ctx, store, name, "relay", relay2.NewNegentropySyncer(store, peer).Sync).DeferUnderLoad(loadMonitor)
+		go negentropyJob.Every(ctx, negentropyJob.Due(ctx, interval), interval)
+	}
+
+	if kindTTLs := cfg.KindTTLs(); len(kindTTLs) > 0 {
+		kindTTLJob := jobs.New(ctx, store, "kind_ttl_prune", "relay", func(ctx context.Context) error {
+			return pruneExpiredKinds(ctx, store, kindTTLs)
+		}).DeferUnderLoad(loadMonitor)
+		go kindTTLJob.Every(ctx, kindTTLJob.Due(ctx, 24*time.Hour), 24*time.Hour)
+	}
+
+	if cfg.TemplatesDir != "" {
+		templates.SetOverrideDir(cfg.TemplatesDir)
+		log.Printf("Serving template overrides from %s", cfg.TemplatesDir)
+	}
+	static.SetUseCDN(cfg.AssetsCDN)
+	kinds.SetNames(cfg.NamedKinds())
+
+	var imageProxy *imgproxy.Proxy
+	if !cfg.ImageProxy.Disabled {
+		imageProxy, err = imgproxy.New(cfg.ImageProxy.CacheDir, cfg.ImageProxy.Key, cfg.ImageProxy.MaxBytes, time.Duration(cfg.ImageProxy.TTLHours)*time.Hour)
+		if err != nil {
+			log.Fatalf("Failed to initialize image proxy: %v", err)
+		}
+		imgproxy.SetDefault(imageProxy)
+		go imageProxy.Start(ctx)
+	}
+
+	var captures *capture.Captures
+	if !cfg.SessionCapture.Disabled {
+		captures, err = capture.New(cfg.SessionCapture.Dir, time.Duration(cfg.SessionCapture.RetentionHours)*time.Hour, cfg.SessionCapture.MaxFrames)
+		if err != nil {
+			log.Fatalf("Failed to initialize session capture: %v", err)
+		}
+		go captures.Start(ctx)
+	}
+
+	pageHandler := pages.NewHandler(store, rankings)
+	reportHandler := pages.NewReportHandler(store, cfg.Relay.Contact)
+	embeds := api.NewEmbeds(store)
+	profileNames := api.NewProfileNames(store)
+	statusLookup := api.NewStatusLookup(store)
+	mutes := api.NewMutes(store, cfg.Privacy.ListMuters, cfg.IsKindAllowed(10000) && cfg.KindPrivacyPolicy(10000) == config.PrivacyPublic)
+	eventLookups := api.NewEventLookups(store, func(kind int) bool {
+		return cfg.IsKindAllowed(kind) && cfg.KindPrivacyPolicy(kind) == config.PrivacyPublic
+	})
+	apiHandler := api.NewHandler(store, rankings)
+	contactVault := api.NewContactVault(store, func(ctx context.Context, evt *nostr.Event) error {
+		skipBroadcast, err := relay.AddEvent(ctx, evt)
+		if err == nil && !skipBroadcast {
+			relay.BroadcastEvent(evt)
+		}
+		return err
+	})
+	contactVault.SetRelayURL(cfg.Server.PublicURL)
+	apiKeys := make(map[string]api.APIKey, len(cfg.API.Keys))
+	for key, k := range cfg.API.Keys {
+		apiKeys[key] = api.APIKey{Name: k.Name, RequestsPerMinute: k.RequestsPerMinute, Burst: k.Burst}
+	}
+	apiLimiter := api.NewRateLimiter(cfg.API.RequestsPerMinute, cfg.API.Burst, apiKeys)
+
+	// Premium API keys sold through the operator's NWC wallet
+	var premium *billing.Billing
+	if cfg.Billing.NWCURI != "" {
+		wallet, err := billing.ParseNWCURI(cfg.Billing.NWCURI)
+		if err != nil {
+			log.Fatalf("Invalid billing.nwc_uri: %v", err)
+		}
+		premium = billing.New(store, wallet, cfg.Billing.PriceSats, time.Duration(cfg.Billing.DurationDays)*24*time.Hour)
+		go premium.StartReload(ctx, time.Minute)
+		log.Printf("Billing enabled: %d sats for %d days of premium API access", cfg.Billing.PriceSats, cfg.Billing.DurationDays)
+	}
+
+	if cfg.Partners.TokenSecret != "" {
+		go syncPartners.StartReload(ctx, time.Minute)
+	}
+
+	// Premium keys and partner sync tokens are both accepted as API keys
+	apiLimiter.SetKeyLookup(func(key string) (api.APIKey, bool) {
+		if premium != nil {
+			if id, ok := premium.Lookup(key); ok {
+				return api.APIKey{Name: id, RequestsPerMinute: cfg.Billing.RequestsPerMinute, Burst: cfg.Billing.Burst, Class: "premium"}, true
+			}
+		}
+		if partner, ok := syncPartners.Verify(key); ok {
+			return api.APIKey{Name: "partner:" + partner.ID, RequestsPerMinute: cfg.Partners.RequestsPerMinute, Burst: cfg.Partners.Burst, Class: "partner"}, true
+		}
+		return api.APIKey{}, false
+	})
+
+	// NIP-05 names issued under our own domain
+	hostedNames := api.NewHostedNames(store)
+	go hostedNames.StartReload(ctx, time.Minute)
 
 	analyticsHandler := stats.NewAnalyticsHandler(analyticsTracker, trustAnalyzer, store)
 	trustedSyncHandler := stats.NewTrustedSyncHandler(store)
-	dashboardHandler := stats.NewDashboardHandler(store)
+	dashboardHandler := stats.NewDashboardHandler(store, apiLimiter)
 	storageHandler := stats.NewStorageHandler(store)
 	rejectionHandler := stats.NewRejectionHandler(store)
 	communitiesHandler := stats.NewCommunitiesHandler(store)
 	socialHandler := stats.NewSocialHandler(store)
-	networkHandler := stats.NewNetworkHandler(store)
+	networkHandler := stats.NewNetworkHandler(store, geoDB != nil)
+	metricsHandler := stats.NewMetricsHandler(store, statsTracker, prefetcher, apiLimiter, upstreamFetches, mirror, hydrator)
 	timecapsuleHandler := pages.NewTimecapsuleHandler(store)
+	auditHandler := stats.NewAuditHandler(store)
+	jobsHandler := stats.NewJobsHandler(store, loadMonitor)
+	billingHandler := stats.NewBillingHandler(store)
+	coverageHandler := stats.NewCoverageHandler(store, cfg.TrustedSync.Kinds)
+	qualityHandler := stats.NewQualityHandler(store)
+	hostedNamesHandler := stats.NewHostedNamesHandler(store, hostedNames)
+	featuresHandler := stats.NewFeaturesHandler(store, flags)
+	impersonationHandler := stats.NewImpersonationHandler(store)
+	// Issuing and revoking need a token secret; the page and list work without one
+	var tokenIssuer *partners.Partners
+	if cfg.Partners.TokenSecret != "" {
+		tokenIssuer = syncPartners
+	}
+	partnersHandler := stats.NewPartnersHandler(store, tokenIssuer)
+	changelogHandler := stats.NewChangelogHandler(store, relayChangelog)
+	capturesHandler := stats.NewCapturesHandler(store, captures)
 
 	// Password protection middleware for stats pages
 	requireStatsAuth := func(next http.HandlerFunc) http.HandlerFunc {
@@ -447,15 +1145,98 @@ func main() {
 		}
 	}
 
+	// Like requireStatsAuth, but refuses outright when no stats password is set
+	requireAdminAuth := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if cfg.StatsPassword == "" {
+				http.Error(w, "Set stats_password to enable admin actions", http.StatusForbidden)
+				return
+			}
+			requireStatsAuth(next)(w, r)
+		}
+	}
+
+	// requireAnalytics answers 503 from endpoints that only read or change the
+	// analytics tables when no database holds them
+	requireAnalytics := func(next http.HandlerFunc) http.HandlerFunc {
+		if store.AnalyticsEnabled() {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Analytics disabled: no SQL database configured", http.StatusServiceUnavailable)
+		}
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", relay.ServeHTTP)
+	serveRelay := relay.ServeHTTP
+	if captures != nil {
+		serveRelay = captures.Wrap(serveRelay)
+	}
+	// Messages over max_message_bytes get a NOTICE and are counted against the IP
+	// before the websocket library closes the connection on them
+	serveRelay = guardMessageSize(relay.MaxMessageSize, func(ws *khatru.WebSocket, r *http.Request, size int64) {
+		if ws != nil {
+			ws.WriteJSON(nostr.NoticeEnvelope(fmt.Sprintf("invalid: message too large: %d bytes (max %d)", size, cfg.Limits.MaxMessageBytes)))
+		}
+		store.RecordOversizeAttempt(context.Background(), khatru.GetIPFromRequest(r), "message", int(size))
+	}, serveRelay)
+
+	// Upgrades refused by the origin policy are counted for /stats/rejections
+	if err := store.InitOriginRejectionSchema(); err != nil {
+		log.Fatalf("Failed to initialize origin rejection schema: %v", err)
+	}
+	mux.HandleFunc("/", originPolicy(cfg, store, advertiseKindLimits(cfg, serveRelay)))
+	// Paths the relay doesn't answer itself fall through to its router
+	relay.Router().HandleFunc("/", pageHandler.HandleNotFound)
+	mux.HandleFunc("GET /robots.txt", pageHandler.HandleRobots)
+	mux.HandleFunc("GET /sitemap.xml", apiLimiter.Wrap("sitemap", pageHandler.HandleSitemap))
 	mux.HandleFunc("/rankings", pageHandler.HandleRankings)
 	mux.HandleFunc("/search", pageHandler.HandleSearch)
 	mux.HandleFunc("/profile", pageHandler.HandleProfile)
+	mux.HandleFunc("GET /report", reportHandler.HandleReport)
+	mux.HandleFunc("POST /report", apiLimiter.Wrap("report", reportHandler.HandleReport))
+	mux.HandleFunc("GET /api/v1/profile/{pubkey}", apiLimiter.Wrap("profile", apiHandler.HandleProfile))
+	mux.HandleFunc("POST /api/v1/profiles/names", apiLimiter.Wrap("profile_names", profileNames.HandleNames))
+	mux.HandleFunc("POST /api/v1/status", apiLimiter.RequireKey("status", statusLookup.HandleStatus))
+	mux.HandleFunc("GET /api/v1/profiles/mentioning", apiLimiter.Wrap("mentioning", apiHandler.HandleMentioning))
+	mux.HandleFunc("GET /e/{id}", apiLimiter.Wrap("event", eventLookups.HandleEvent))
+	mux.HandleFunc("GET /api/v1/embed/{pubkey}", apiLimiter.Wrap("embed", embeds.HandleCardJSON))
+	mux.HandleFunc("GET /embed/profile/{pubkey}", apiLimiter.Wrap("embed", embeds.HandleCard))
+	mux.HandleFunc("GET /badge/followers/{file}", apiLimiter.Wrap("badge", embeds.HandleFollowerBadge))
+	mux.HandleFunc("GET /api/v1/snapshot", apiLimiter.Wrap("snapshot", syncPartners.WrapSnapshot(apiHandler.HandleSnapshot, cfg.Partners.SnapshotPartnersOnly)))
+	mux.HandleFunc("GET /api/v1/rankings", apiLimiter.Wrap("rankings", apiHandler.HandleRankings))
+	mux.HandleFunc("GET /api/v1/nip05", apiLimiter.Wrap("nip05", apiHandler.HandleNip05))
+	mux.HandleFunc("GET /relays/census", apiLimiter.Wrap("census", pageHandler.HandleRelayCensus))
+	mux.HandleFunc("GET /changelog", pageHandler.HandleChangelog)
+	mux.HandleFunc("GET /changelog.json", pageHandler.HandleChangelogFeed)
+	mux.HandleFunc("GET /api/v1/relays/census", apiLimiter.Wrap("census", apiHandler.HandleRelayCensus))
+	mux.HandleFunc("GET /api/v1/kinds", apiLimiter.Wrap("kinds", apiHandler.HandleKinds))
+	mux.HandleFunc("GET /api/v1/dataset", apiLimiter.Wrap("dataset", dataset.HandleDataset))
+	mux.HandleFunc("GET /api/v1/onboarding/{pubkey}", apiLimiter.Wrap("onboarding", apiHandler.HandleOnboarding))
+	mux.HandleFunc("GET /api/v1/check/{pubkey}", apiLimiter.Wrap("check", apiHandler.HandleRelayCheck))
+	mux.HandleFunc("GET /api/v1/mutes/{pubkey}", apiLimiter.Wrap("mutes", mutes.HandleMutes))
+	mux.HandleFunc("GET /api/v1/followers/{pubkey}", apiLimiter.Wrap("followers", apiHandler.HandleFollowers))
+	mux.HandleFunc("GET /api/v1/payments/{pubkey}", apiLimiter.Wrap("payments", apiHandler.HandlePayments))
+	mux.HandleFunc("GET /api/v1/payments", apiLimiter.Wrap("payments", apiHandler.HandlePaymentsByAddress))
+	mux.HandleFunc("GET /api/v1/vault/challenge", apiLimiter.Wrap("vault", contactVault.HandleChallenge))
+	mux.HandleFunc("GET /api/v1/vault/contacts", apiLimiter.Wrap("vault", contactVault.HandleList))
+	mux.HandleFunc("GET /api/v1/vault/contacts/{id}", apiLimiter.Wrap("vault", contactVault.HandleGet))
+	mux.HandleFunc("POST /api/v1/vault/contacts/{id}/restore", apiLimiter.Wrap("vault", contactVault.HandleRestore))
+	mux.HandleFunc("GET /api/v1/vault/export", apiLimiter.Wrap("vault", contactVault.HandleExport))
+	mux.HandleFunc("POST /api/v1/vault/broadcast", apiLimiter.Wrap("vault", contactVault.HandleBroadcast))
+	mux.HandleFunc("GET /.well-known/nostr.json", apiLimiter.Wrap("nostr_json", hostedNames.HandleNostrJSON))
+	if premium != nil {
+		mux.HandleFunc("POST /api/v1/billing/invoice", apiLimiter.Wrap("billing", premium.HandleCreateInvoice))
+		mux.HandleFunc("GET /api/v1/billing/invoice/{payment_hash}", apiLimiter.Wrap("billing", premium.HandleClaim))
+	}
 	mux.HandleFunc("/timecapsule", timecapsuleHandler.HandleTimecapsule())
 	mux.HandleFunc("/stats", requireStatsAuth(statsTracker.HandleStats()))
 	mux.HandleFunc("/stats/analytics", requireStatsAuth(analyticsHandler.HandleAnalytics()))
 	mux.HandleFunc("/stats/analytics/purge", requireStatsAuth(analyticsHandler.HandlePurge()))
+	mux.HandleFunc("GET /stats/analytics/cluster", requireStatsAuth(analyticsHandler.HandleCluster()))
+	mux.HandleFunc("POST /stats/analytics/cluster/spam", requireAdminAuth(requireAnalytics(analyticsHandler.HandleClusterSpam())))
+	mux.HandleFunc("POST /stats/analytics/cluster/exempt", requireAdminAuth(requireAnalytics(analyticsHandler.HandleClusterExempt())))
+	mux.HandleFunc("POST /stats/analytics/duplicates/purge", requireAdminAuth(requireAnalytics(analyticsHandler.HandlePurgeDuplicates())))
 	mux.HandleFunc("/stats/trusted-sync", requireStatsAuth(trustedSyncHandler.HandleTrustedSyncStats()))
 	mux.HandleFunc("/stats/dashboard", requireStatsAuth(dashboardHandler.HandleDashboard()))
 	mux.HandleFunc("/stats/storage", requireStatsAuth(storageHandler.HandleStorage()))
@@ -463,7 +1244,44 @@ func main() {
 	mux.HandleFunc("/stats/communities", requireStatsAuth(communitiesHandler.HandleCommunities()))
 	mux.HandleFunc("/stats/social", requireStatsAuth(socialHandler.HandleSocial()))
 	mux.HandleFunc("/stats/network", requireStatsAuth(networkHandler.HandleNetwork()))
+	mux.HandleFunc("/stats/audit", requireStatsAuth(auditHandler.HandleAudit()))
+	mux.HandleFunc("GET /api/v1/stats/kind-counts", requireStatsAuth(requireAnalytics(statsTracker.HandleKindCountHistory())))
+	mux.HandleFunc("GET /api/v1/stats/req-kinds", requireStatsAuth(requireAnalytics(rejectionHandler.HandleREQKindHistory())))
+	mux.HandleFunc("GET /api/v1/jobs", requireStatsAuth(requireAnalytics(jobsHandler.HandleJobs())))
+	mux.HandleFunc("POST /api/v1/jobs/{name}/run", requireAdminAuth(requireAnalytics(jobsHandler.HandleRunJob())))
+	mux.HandleFunc("GET /api/v1/admin/nip05", requireStatsAuth(requireAnalytics(hostedNamesHandler.HandleList())))
+	mux.HandleFunc("PUT /api/v1/admin/nip05/{name}", requireAdminAuth(requireAnalytics(hostedNamesHandler.HandleSet())))
+	mux.HandleFunc("DELETE /api/v1/admin/nip05/{name}", requireAdminAuth(requireAnalytics(hostedNamesHandler.HandleDelete())))
+	mux.HandleFunc("GET /api/v1/admin/features", requireStatsAuth(featuresHandler.HandleList()))
+	mux.HandleFunc("PUT /api/v1/admin/features/{name}", requireAdminAuth(requireAnalytics(featuresHandler.HandleSet())))
+	mux.HandleFunc("DELETE /api/v1/admin/features/{name}", requireAdminAuth(requireAnalytics(featuresHandler.HandleClear())))
+	mux.HandleFunc("POST /api/v1/admin/changelog", requireAdminAuth(requireAnalytics(changelogHandler.HandleAdd())))
+	mux.HandleFunc("DELETE /api/v1/admin/changelog/{id}", requireAdminAuth(requireAnalytics(changelogHandler.HandleDelete())))
+	if captures != nil {
+		mux.HandleFunc("GET /stats/captures", requireAdminAuth(capturesHandler.HandleCaptures()))
+		mux.HandleFunc("GET /stats/captures/{id}", requireAdminAuth(capturesHandler.HandleCapture()))
+		mux.HandleFunc("POST /api/v1/admin/captures", requireAdminAuth(capturesHandler.HandleIssueToken()))
+		mux.HandleFunc("GET /api/v1/admin/captures/{id}", requireAdminAuth(capturesHandler.HandleDownload()))
+		mux.HandleFunc("DELETE /api/v1/admin/captures/{id}", requireAdminAuth(capturesHandler.HandleDelete()))
+		mux.HandleFunc("DELETE /api/v1/admin/captures/tokens/{token}", requireAdminAuth(capturesHandler.HandleRevokeToken()))
+	}
+	mux.HandleFunc("/stats/impersonation", requireStatsAuth(impersonationHandler.HandleImpersonation()))
+	mux.HandleFunc("/stats/billing", requireStatsAuth(billingHandler.HandleBilling()))
+	mux.HandleFunc("/stats/partners", requireStatsAuth(requireAnalytics(partnersHandler.HandlePartners())))
+	mux.HandleFunc("GET /api/v1/admin/partners", requireStatsAuth(requireAnalytics(partnersHandler.HandleList())))
+	if cfg.Partners.TokenSecret != "" {
+		mux.HandleFunc("POST /api/v1/admin/partners", requireAdminAuth(requireAnalytics(partnersHandler.HandleIssue())))
+		mux.HandleFunc("DELETE /api/v1/admin/partners/{id}", requireAdminAuth(requireAnalytics(partnersHandler.HandleRevoke())))
+	}
+	mux.HandleFunc("/stats/coverage", requireStatsAuth(coverageHandler.HandleCoverage()))
+	mux.HandleFunc("/stats/quality", requireStatsAuth(qualityHandler.HandleQuality()))
 	mux.HandleFunc("/relays", requireStatsAuth(statsTracker.HandleRelays()))
+	mux.HandleFunc("/metrics", requireStatsAuth(metricsHandler.HandleMetrics()))
+	mux.HandleFunc("/static/", static.Handler())
+	if imageProxy != nil {
+		mux.HandleFunc("GET /img/{sig}", imageProxy.HandleImage)
+	}
+	mux.HandleFunc("GET /readyz", readyzHandler(cfg.Sync, initialSync, store))
 	mux.HandleFunc("/icon.png", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "icon.png")
 	})
@@ -490,6 +1308,19 @@ func main() {
 	log.Println("Shutting down relay...")
 	cancel()
 	analyticsTracker.Stop()
+	statsTracker.Stop()
+	if err := store.FlushRelayIntegrity(context.Background()); err != nil {
+		log.Printf("Failed to flush relay integrity: %v", err)
+	}
+	if err := store.FlushWriteStats(context.Background()); err != nil {
+		log.Printf("Failed to flush write stats: %v", err)
+	}
+	if scraperDetector != nil {
+		scraperDetector.Stop()
+	}
+	if prefetcher != nil {
+		prefetcher.Stop()
+	}
 	syncQueue.Stop()
 	if hydrator != nil {
 		hydrator.Stop()
@@ -509,6 +1340,122 @@ func main() {
 	}
 }
 
+// initSchemas creates the SQL tables of every subsystem; importers need them
+// too, since saving an event updates the follow graph and profile indexes
+func initSchemas(store *storage.Storage) {
+	if err := store.InitRelayDiscoverySchema(); err != nil {
+		log.Fatalf("Failed to initialize relay discovery schema: %v", err)
+	}
+
+	if err := store.InitRelayCensusSchema(); err != nil {
+		log.Fatalf("Failed to initialize relay census schema: %v", err)
+	}
+
+	if err := store.InitOutboxProbeSchema(); err != nil {
+		log.Fatalf("Failed to initialize outbox probe schema: %v", err)
+	}
+
+	if err := store.InitNegentropySyncSchema(); err != nil {
+		log.Fatalf("Failed to initialize negentropy sync schema: %v", err)
+	}
+
+	if err := store.InitProfileHydrationSchema(); err != nil {
+		log.Fatalf("Failed to initialize profile hydration schema: %v", err)
+	}
+
+	if err := store.InitAnalyticsSchema(); err != nil {
+		log.Fatalf("Failed to initialize analytics schema: %v", err)
+	}
+
+	if err := store.InitDuplicateProfileSchema(); err != nil {
+		log.Fatalf("Failed to initialize duplicate profile schema: %v", err)
+	}
+
+	if err := store.InitTrustedSyncSchema(); err != nil {
+		log.Fatalf("Failed to initialize trusted sync schema: %v", err)
+	}
+
+	if err := store.InitDailyStatsSchema(); err != nil {
+		log.Fatalf("Failed to initialize daily stats schema: %v", err)
+	}
+
+	if err := store.InitEventHistorySchema(); err != nil {
+		log.Fatalf("Failed to initialize event history schema: %v", err)
+	}
+
+	if err := store.InitEventProvenanceSchema(); err != nil {
+		log.Fatalf("Failed to initialize event provenance schema: %v", err)
+	}
+
+	if err := store.InitStorageStatsSchema(); err != nil {
+		log.Fatalf("Failed to initialize storage stats schema: %v", err)
+	}
+
+	if err := store.InitKindCountsSchema(); err != nil {
+		log.Fatalf("Failed to initialize kind counts schema: %v", err)
+	}
+
+	if err := store.InitScraperSchema(); err != nil {
+		log.Fatalf("Failed to initialize scraper schema: %v", err)
+	}
+
+	if err := store.InitOversizeSchema(); err != nil {
+		log.Fatalf("Failed to initialize oversize schema: %v", err)
+	}
+
+	if err := store.InitInvalidEventSchema(); err != nil {
+		log.Fatalf("Failed to initialize invalid event schema: %v", err)
+	}
+
+	if err := store.InitIPPrivacySchema(); err != nil {
+		log.Fatalf("Failed to initialize IP privacy schema: %v", err)
+	}
+
+	if err := store.InitAuditSchema(); err != nil {
+		log.Fatalf("Failed to initialize audit schema: %v", err)
+	}
+
+	if err := store.InitHostedNamesSchema(); err != nil {
+		log.Fatalf("Failed to initialize hosted names schema: %v", err)
+	}
+
+	if err := store.InitChangelogSchema(); err != nil {
+		log.Fatalf("Failed to initialize changelog schema: %v", err)
+	}
+
+	if err := store.InitKeyMigrationSchema(); err != nil {
+		log.Fatalf("Failed to initialize key migration schema: %v", err)
+	}
+
+	if err := store.InitJobSchema(); err != nil {
+		log.Fatalf("Failed to initialize job schema: %v", err)
+	}
+
+	if err := store.InitBillingSchema(); err != nil {
+		log.Fatalf("Failed to initialize billing schema: %v", err)
+	}
+
+	if err := store.InitSyncPartnersSchema(); err != nil {
+		log.Fatalf("Failed to initialize sync partners schema: %v", err)
+	}
+
+	if err := store.InitRelayIntegritySchema(); err != nil {
+		log.Fatalf("Failed to initialize relay integrity schema: %v", err)
+	}
+
+	if err := store.InitFeatureFlagSchema(); err != nil {
+		log.Fatalf("Failed to initialize feature flag schema: %v", err)
+	}
+
+	if err := store.InitProfileSearchSchema(); err != nil {
+		log.Fatalf("Failed to initialize profile search schema: %v", err)
+	}
+
+	if err := store.InitLightningAddressSchema(); err != nil {
+		log.Fatalf("Failed to initialize lightning address schema: %v", err)
+	}
+}
+
 func runAnalyticsWorker() {
 	log.Println("Starting analytics worker process")
 
@@ -516,20 +1463,45 @@ func runAnalyticsWorker() {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	startProfiling(cfg.Profiling, "analytics", false)
 
 	store, err := storage.New(cfg.Storage.Backend, cfg.Storage.Path, *cfg.Storage.ArchiveEnabled, cfg.Storage.AnalyticsDBURL)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 	defer store.Close()
+	if !store.AnalyticsEnabled() {
+		log.Fatalf("Analytics worker needs a SQL database: set storage.analytics_db_url or use the postgresql backend")
+	}
 
 	if err := store.InitAnalyticsSchema(); err != nil {
 		log.Fatalf("Failed to initialize analytics schema: %v", err)
 	}
 
+	if err := store.InitDuplicateProfileSchema(); err != nil {
+		log.Fatalf("Failed to initialize duplicate profile schema: %v", err)
+	}
+
+	if err := store.InitJobSchema(); err != nil {
+		log.Fatalf("Failed to initialize job schema: %v", err)
+	}
+
+	if err := store.InitFeatureFlagSchema(); err != nil {
+		log.Fatalf("Failed to initialize feature flag schema: %v", err)
+	}
+
+	if err := store.InitStorageStatsSchema(); err != nil {
+		log.Fatalf("Failed to initialize storage stats schema: %v", err)
+	}
+
 	clusterDetector := analytics.NewClusterDetector(store)
 	trustAnalyzer := analytics.NewTrustAnalyzer(store, clusterDetector, cfg.Limits.MinTrustedFollowers)
+	trustAnalyzer.SetMinReportScore(cfg.Limits.MinReportScore)
 	communityDetector := analytics.NewCommunityDetector(store)
+	var impersonationDetector *analytics.ImpersonationDetector
+	if !cfg.Impersonation.Disabled {
+		impersonationDetector = analytics.NewImpersonationDetector(store, cfg.Impersonation.MinTargetFollowers, cfg.Impersonation.MaxFollowers)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -543,36 +1515,69 @@ func runAnalyticsWorker() {
 		cancel()
 	}()
 
+	go watchWriteStats(ctx, store)
+	defer func() {
+		if err := store.FlushWriteStats(context.Background()); err != nil {
+			log.Printf("Failed to flush write stats: %v", err)
+		}
+	}()
+
+	flags := features.New(store, cfg.FeatureDefaults())
+	if err := flags.Refresh(ctx); err != nil {
+		log.Printf("Failed to load feature overrides: %v", err)
+	}
+	go flags.Watch(ctx)
+	loadMonitor := newLoadMonitor(cfg, store)
+	if loadMonitor != nil {
+		go loadMonitor.Watch(ctx)
+	}
+	// Community detection can be switched off between cycles
+	communities := func() *analytics.CommunityDetector {
+		if flags.Enabled(config.FeatureCommunities) {
+			return communityDetector
+		}
+		return nil
+	}
+
+	// The hourly cycle runs both on a shared follow graph; requested runs load their own
+	clusterJob := jobs.New(ctx, store, "cluster_detect", "analytics", func(ctx context.Context) error {
+		_, err := clusterDetector.Detect(ctx)
+		return err
+	})
+	trustJob := jobs.New(ctx, store, "trust_analyze", "analytics", trustAnalyzer.AnalyzeTrust)
+	go clusterJob.WatchRequests(ctx)
+	go trustJob.WatchRequests(ctx)
+
+	// Weekly halving keeps co-occurrence counts about recent REQs
+	decayJob := jobs.New(ctx, store, "cooccurrence_decay", "analytics", func(ctx context.Context) error {
+		pruned, err := store.DecayCooccurrences(ctx)
+		if err == nil {
+			log.Printf("Co-occurrence decay: halved counts, dropped %d pairs", pruned)
+		}
+		return err
+	}).DeferUnderLoad(loadMonitor)
+	go decayJob.Every(ctx, decayJob.Due(ctx, analytics.CooccurrenceDecayInterval), analytics.CooccurrenceDecayInterval)
+
+	// Identical profiles published from many keys feed the next trust analysis
+	duplicatesJob := jobs.New(ctx, store, "duplicate_profiles", "analytics", func(ctx context.Context) error {
+		return analytics.DetectDuplicateProfiles(ctx, store)
+	}).DeferUnderLoad(loadMonitor)
+	go duplicatesJob.Every(ctx, duplicatesJob.Due(ctx, analytics.DuplicateProfileInterval), analytics.DuplicateProfileInterval)
+
 	ticker := time.NewTicker(1 * time.Hour)
 	defer ticker.Stop()
 
 	// Run immediately if no trusted pubkeys, otherwise wait 5 minutes
 	if trustAnalyzer.GetTrustedCount() == 0 {
 		log.Println("No trusted pubkeys found, running trust analysis immediately")
-		start := time.Now()
-		clusterDetector.Detect(ctx)
-		log.Printf("clusterDetector.Detect took %v", time.Since(start))
-		start = time.Now()
-		trustAnalyzer.AnalyzeTrust(ctx)
-		log.Printf("trustAnalyzer.AnalyzeTrust took %v", time.Since(start))
-		start = time.Now()
-		communityDetector.DetectCommunities(ctx)
-		log.Printf("communityDetector.DetectCommunities took %v", time.Since(start))
+		runAnalysisCycle(ctx, store, loadMonitor, clusterJob, trustJob, clusterDetector, trustAnalyzer, communities(), impersonationDetector)
 	} else {
 		time.Sleep(5 * time.Minute)
 	}
 
 	log.Println("Analytics worker: starting hourly analysis loop")
 	for {
-		start := time.Now()
-		clusterDetector.Detect(ctx)
-		log.Printf("clusterDetector.Detect took %v", time.Since(start))
-		start = time.Now()
-		trustAnalyzer.AnalyzeTrust(ctx)
-		log.Printf("trustAnalyzer.AnalyzeTrust took %v", time.Since(start))
-		start = time.Now()
-		communityDetector.DetectCommunities(ctx)
-		log.Printf("communityDetector.DetectCommunities took %v", time.Since(start))
+		runAnalysisCycle(ctx, store, loadMonitor, clusterJob, trustJob, clusterDetector, trustAnalyzer, communities(), impersonationDetector)
 
 		select {
 		case <-ctx.Done():
@@ -583,6 +1588,147 @@ func runAnalyticsWorker() {
 	}
 }
 
+// startProfiling runs the heap watcher, and the pprof admin listener when listen
+// is set, for the lifetime of the process. Only the relay listens, so the
+// analytics worker can run next to it on the same host.
+func startProfiling(cfg config.ProfilingConfig, process string, listen bool) {
+	if listen && cfg.Listen != "" {
+		go profiling.Serve(context.Background(), cfg.Listen)
+	}
+	if cfg.HeapThresholdMB > 0 {
+		watcher, err := profiling.NewHeapWatcher(cfg.Dir, process, cfg.HeapThresholdMB, cfg.Keep)
+		if err != nil {
+			log.Printf("Profiling: heap capture disabled: %v", err)
+			return
+		}
+		go watcher.Start(context.Background())
+		log.Printf("Profiling: capturing heap profiles to %s above %d MB resident", cfg.Dir, cfg.HeapThresholdMB)
+	}
+}
+
+// watchWriteStats persists the rows each subsystem writes every minute, for
+// the write budget on /stats/storage
+func watchWriteStats(ctx context.Context, store *storage.Storage) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.FlushWriteStats(ctx); err != nil {
+				log.Printf("Failed to flush write stats: %v", err)
+			}
+		}
+	}
+}
+
+// newLoadMonitor returns the monitor background jobs defer to, or nil with
+// background_load disabled
+func newLoadMonitor(cfg *config.Config, store *storage.Storage) *jobs.LoadMonitor {
+	if cfg.BackgroundLoad.Disabled {
+		return nil
+	}
+	return jobs.NewLoadMonitor(store, cfg.BackgroundLoad.MaxConnections,
+		time.Duration(cfg.BackgroundLoad.MaxQueryLatencyMs)*time.Millisecond,
+		time.Duration(cfg.BackgroundLoad.MaxDeferMinutes)*time.Minute)
+}
+
+// pruneExpiredKinds deletes events of kinds with a TTL that their authors
+// haven't updated within it, keeping those of trusted authors
+func pruneExpiredKinds(ctx context.Context, store *storage.Storage, kindTTLs map[int]time.Duration) error {
+	trusted, err := store.GetTrustedPubkeys(ctx)
+	if err != nil {
+		return err
+	}
+	// Before trust analysis first runs every author would look untrusted
+	if len(trusted) == 0 {
+		log.Println("Kind TTL: no trusted pubkeys yet, skipping prune")
+		return nil
+	}
+	keep := make(map[string]bool, len(trusted))
+	for _, pubkey := range trusted {
+		keep[pubkey] = true
+	}
+
+	for kind, ttl := range kindTTLs {
+		deleted, err := store.PruneExpiredKind(ctx, kind, time.Now().Add(-ttl), keep)
+		if err != nil {
+			return fmt.Errorf("kind %d: %w", kind, err)
+		}
+		if deleted > 0 {
+			log.Printf("Kind TTL: pruned %d kind %d events untouched for %d days", deleted, kind, int(ttl.Hours()/24))
+		}
+	}
+	return nil
+}
+
+// runAnalysisCycle loads the follow graph in a single pass and feeds it to all
+// detectors. Trust analysis depends on the bot clusters, community and
+// impersonation detection do not, so the chains run concurrently, or one
+// after another when the relay is still busy after waiting for it to quiet
+// down. A nil community or impersonation detector is skipped.
+func runAnalysisCycle(ctx context.Context, store *storage.Storage, loadMonitor *jobs.LoadMonitor, clusterJob, trustJob *jobs.Job, clusterDetector *analytics.ClusterDetector, trustAnalyzer *analytics.TrustAnalyzer, communityDetector *analytics.CommunityDetector, impersonationDetector *analytics.ImpersonationDetector) {
+	cycleStart := time.Now()
+	quiet := loadMonitor.WaitQuiet(ctx, "analysis cycle")
+	if ctx.Err() != nil {
+		return
+	}
+
+	start := time.Now()
+	graph := analytics.LoadFollowGraph(ctx, store)
+	log.Printf("analytics.LoadFollowGraph took %v (%d authors)", time.Since(start), len(graph))
+
+	var wg gosync.WaitGroup
+	run := func(chain func()) {
+		if !quiet {
+			chain()
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chain()
+		}()
+	}
+
+	run(func() {
+		start := time.Now()
+		err := clusterJob.Track(ctx, func(ctx context.Context) error {
+			_, err := clusterDetector.DetectInGraph(ctx, graph)
+			return err
+		})
+		log.Printf("clusterDetector.Detect took %v (err=%v)", time.Since(start), err)
+		start = time.Now()
+		err = trustJob.Track(ctx, func(ctx context.Context) error {
+			return trustAnalyzer.AnalyzeTrustInGraph(ctx, graph)
+		})
+		log.Printf("trustAnalyzer.AnalyzeTrust took %v (err=%v)", time.Since(start), err)
+	})
+
+	if communityDetector != nil {
+		run(func() {
+			start := time.Now()
+			communityDetector.DetectCommunitiesInGraph(ctx, graph)
+			log.Printf("communityDetector.DetectCommunities took %v", time.Since(start))
+		})
+	}
+
+	if impersonationDetector != nil {
+		run(func() {
+			start := time.Now()
+			if _, err := impersonationDetector.DetectInGraph(ctx, graph); err != nil {
+				log.Printf("impersonationDetector.Detect failed: %v", err)
+			}
+			log.Printf("impersonationDetector.Detect took %v", time.Since(start))
+		})
+	}
+
+	wg.Wait()
+	log.Printf("analysis cycle took %v", time.Since(cycleStart))
+}
+
 func runSyncCommand(args []string) {
 	syncFlags := flag.NewFlagSet("sync", flag.ExitOnError)
 	kinds := syncFlags.String("k", "", "Comma-separated list of kinds to sync (e.g., -k 0,3,10002)")
@@ -643,6 +1789,7 @@ func runSyncCommand(args []string) {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	startProfiling(cfg.Profiling, "sync", false)
 
 	// If no kinds specified, use sync kinds from config
 	if len(kindsToSync) == 0 {
@@ -863,6 +2010,7 @@ func runHydratorBenchmark(cfg *config.Config) error {
 		cfg.ProfileHydration.RetryAfterHours,
 		cfg.ProfileHydration.BatchSize,
 	)
+	hydrator.SetDeadAccountPolicy(cfg.ProfileHydration.DeadAfterRounds, time.Duration(cfg.ProfileHydration.DeadRetryDays)*24*time.Hour)
 
 	start = time.Now()
 	pubkeysToFetch := hydrator.FindPubkeysNeedingHydration(ctx)
@@ -926,6 +2074,7 @@ func runHydratorTest(store *storage.Storage, cfg *config.Config, skipFetch bool)
 		cfg.ProfileHydration.RetryAfterHours,
 		cfg.ProfileHydration.BatchSize,
 	)
+	hydrator.SetDeadAccountPolicy(cfg.ProfileHydration.DeadAfterRounds, time.Duration(cfg.ProfileHydration.DeadRetryDays)*24*time.Hour)
 
 	// First, show what would be fetched
 	log.Println("Analyzing which pubkeys need hydration...")
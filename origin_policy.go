@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pablof7z/purplepag.es/config"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// originPolicy refuses websocket upgrades whose Origin header the configured
+// origin_policy rejects, counting each refusal apart from other rejections.
+// Plain HTTP requests and clients sending no Origin pass through untouched.
+func originPolicy(cfg *config.Config, store *storage.Storage, next http.HandlerFunc) http.HandlerFunc {
+	if len(cfg.OriginPolicy.Allow) == 0 && len(cfg.OriginPolicy.Deny) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next(w, r)
+			return
+		}
+		origin := r.Header.Get("Origin")
+		rule := cfg.RejectOrigin(origin)
+		if rule == "" {
+			next(w, r)
+			return
+		}
+
+		store.RecordOriginRejection(r.Context(), origin, rule)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// trustedFirstOversample is how many times the served limit a trusted_first
+// filter reads, so trusted authors beyond the newest max_limit events can still
+// make the cut
+const trustedFirstOversample = 10
+
+// effectiveLimit is how many events a filter is answered with at most
+func effectiveLimit(filter nostr.Filter, maxLimit int) int {
+	if filter.Limit > 0 && filter.Limit < maxLimit {
+		return filter.Limit
+	}
+	return maxLimit
+}
+
+// trustedFirst keeps the limit events whose authors rank highest: trusted authors
+// before everyone else, newest first within each group
+func trustedFirst(events []*nostr.Event, isTrusted func(pubkey string) bool, limit int) []*nostr.Event {
+	trusted := make(map[string]bool)
+	for _, evt := range events {
+		if _, ok := trusted[evt.PubKey]; !ok {
+			trusted[evt.PubKey] = isTrusted(evt.PubKey)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		ti, tj := trusted[events[i].PubKey], trusted[events[j].PubKey]
+		if ti != tj {
+			return ti
+		}
+		return events[i].CreatedAt > events[j].CreatedAt
+	})
+
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events
+}
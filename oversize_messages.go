@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/fiatjaf/khatru"
+)
+
+type messageGuardKey struct{}
+
+// messageGuard follows the websocket frames a client sends so that a message
+// over the size limit can be answered before the websocket library sees it:
+// the library refuses it with a bare close frame, and khatru only gets the read
+// error, so neither could send a NOTICE or tell why the connection ended
+type messageGuard struct {
+	limit    int64
+	r        *http.Request
+	oversize func(ws *khatru.WebSocket, r *http.Request, size int64)
+
+	mu sync.Mutex
+	ws *khatru.WebSocket // set once khatru has accepted the connection
+
+	// Frame parsing state, only touched by the connection's reader
+	header    []byte
+	remaining int64 // payload bytes left in the current frame
+	length    int64 // payload bytes of the current message so far
+	tripped   bool
+}
+
+// guardMessageSize calls oversize when a websocket client sends a message over
+// limit bytes, fragments included, just before the websocket library refuses
+// it and closes the connection. Other requests pass through untouched.
+func guardMessageSize(limit int64, oversize func(ws *khatru.WebSocket, r *http.Request, size int64), next http.HandlerFunc) http.HandlerFunc {
+	if limit <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next(w, r)
+			return
+		}
+
+		g := &messageGuard{limit: limit, oversize: oversize}
+		r = r.WithContext(context.WithValue(r.Context(), messageGuardKey{}, g))
+		g.r = r
+		next(&guardedResponse{ResponseWriter: w, guard: g}, r)
+	}
+}
+
+// attachMessageGuard hands the guard of a new connection its khatru connection,
+// which the NOTICE about an oversized message is written through
+func attachMessageGuard(ctx context.Context) {
+	ws := khatru.GetConnection(ctx)
+	if ws == nil || ws.Request == nil {
+		return
+	}
+	if g, ok := ws.Request.Context().Value(messageGuardKey{}).(*messageGuard); ok {
+		g.mu.Lock()
+		g.ws = ws
+		g.mu.Unlock()
+	}
+}
+
+// scan follows the frame headers in b, the next bytes the client sent
+func (g *messageGuard) scan(b []byte) {
+	for len(b) > 0 && !g.tripped {
+		if g.remaining > 0 {
+			n := min(g.remaining, int64(len(b)))
+			g.remaining -= n
+			b = b[n:]
+			continue
+		}
+
+		g.header = append(g.header, b[0])
+		b = b[1:]
+		if len(g.header) < 2 {
+			continue
+		}
+		need := 2
+		switch g.header[1] & 0x7f {
+		case 126:
+			need += 2
+		case 127:
+			need += 8
+		}
+		if g.header[1]&0x80 != 0 {
+			need += 4 // masking key
+		}
+		if len(g.header) < need {
+			continue
+		}
+
+		var size int64
+		switch g.header[1] & 0x7f {
+		case 126:
+			size = int64(binary.BigEndian.Uint16(g.header[2:4]))
+		case 127:
+			size = int64(min(binary.BigEndian.Uint64(g.header[2:10]), math.MaxInt64))
+		default:
+			size = int64(g.header[1] & 0x7f)
+		}
+		opcode := g.header[0] & 0x0f
+		g.header = g.header[:0]
+		g.remaining = size
+
+		// Control frames (close, ping, pong) don't count towards a message
+		if opcode >= 0x8 {
+			continue
+		}
+		if opcode != 0x0 {
+			g.length = 0 // a text or binary frame starts a new message
+		}
+		g.length = min(g.length, math.MaxInt64-size) + size
+		if g.length > g.limit {
+			g.tripped = true
+			g.mu.Lock()
+			ws := g.ws
+			g.mu.Unlock()
+			g.oversize(ws, g.r, g.length)
+		}
+	}
+}
+
+// guardedResponse hands the connection of a websocket upgrade to its guard
+type guardedResponse struct {
+	http.ResponseWriter
+	guard *messageGuard
+}
+
+func (w *guardedResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Whatever was buffered during the handshake is read before the connection
+	buffered, _ := brw.Reader.Peek(brw.Reader.Buffered())
+	w.guard.scan(buffered)
+	guarded := &guardedConn{Conn: conn, guard: w.guard}
+	brw.Reader = bufio.NewReaderSize(io.MultiReader(bytes.NewReader(buffered), guarded), brw.Reader.Size())
+	return guarded, brw, nil
+}
+
+func (w *guardedResponse) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// guardedConn passes everything read through its guard before the websocket
+// library gets to it
+type guardedConn struct {
+	net.Conn
+	guard *messageGuard
+}
+
+func (c *guardedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.guard.scan(b[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+)
+
+func TestGuardMessageSizeNotices(t *testing.T) {
+	relay := khatru.NewRelay()
+	relay.MaxMessageSize = 1024
+	relay.OnConnect = append(relay.OnConnect, func(ctx context.Context) {
+		attachMessageGuard(ctx)
+	})
+
+	var mu sync.Mutex
+	var sizes []int64
+	handler := guardMessageSize(relay.MaxMessageSize, func(ws *khatru.WebSocket, r *http.Request, size int64) {
+		mu.Lock()
+		sizes = append(sizes, size)
+		mu.Unlock()
+		ws.WriteJSON([]any{"NOTICE", "too large"})
+	}, relay.ServeHTTP)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Messages under the limit are served as usual, pings in between included
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`["REQ","a",{"kinds":[0],"limit":1}]`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	readLabel(t, conn, "EOSE")
+
+	// An oversized message sent in fragments, none of them over the limit alone
+	w, err := conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		t.Fatalf("writer: %v", err)
+	}
+	chunk := []byte(strings.Repeat("x", 600))
+	for range 3 {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	w.Close()
+
+	readLabel(t, conn, "NOTICE")
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("expected a close for the message size, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sizes) != 1 || sizes[0] <= 1024 {
+		t.Fatalf("expected one oversize call over the limit, got %v", sizes)
+	}
+}
+
+func readLabel(t *testing.T, conn *websocket.Conn, label string) {
+	t.Helper()
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", label, err)
+		}
+		var envelope []json.RawMessage
+		if err := json.Unmarshal(msg, &envelope); err != nil || len(envelope) == 0 {
+			t.Fatalf("unexpected message %s", msg)
+		}
+		if string(envelope[0]) == `"`+label+`"` {
+			return
+		}
+	}
+}
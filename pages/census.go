@@ -0,0 +1,87 @@
+package pages
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// censusMatrixSoftware is how many implementations get a column in the NIP matrix
+const censusMatrixSoftware = 8
+
+type censusSoftwareRow struct {
+	Software string
+	Relays   int
+	Percent  float64
+	Versions []storage.CensusSoftware
+}
+
+type censusNIPRow struct {
+	NIP     int
+	Relays  int
+	Percent float64
+	Cells   []float64 // share of each matrix implementation's relays claiming the NIP
+}
+
+type censusPageData struct {
+	Census      *storage.RelayCensus
+	GeneratedAt string
+	Software    []censusSoftwareRow
+	Matrix      []string // implementations with a column in the NIP matrix
+	NIPs        []censusNIPRow
+}
+
+// HandleRelayCensus renders the relay software census: implementations and
+// versions deployed, and which NIPs each claims to support
+func (h *Handler) HandleRelayCensus(w http.ResponseWriter, r *http.Request) {
+	census, err := h.storage.GetRelayCensus(r.Context())
+	if err != nil || census == nil {
+		renderError(w, http.StatusServiceUnavailable, "The relay census isn't available right now")
+		return
+	}
+
+	data := censusPageData{Census: census}
+	if census.GeneratedAt > 0 {
+		data.GeneratedAt = time.Unix(census.GeneratedAt, 0).UTC().Format("2006-01-02 15:04 UTC")
+	}
+
+	rowOf := make(map[string]int)
+	for _, sv := range census.SoftwareVersion {
+		i, ok := rowOf[sv.Software]
+		if !ok {
+			total := census.SoftwareTotals[sv.Software]
+			i = len(data.Software)
+			rowOf[sv.Software] = i
+			data.Software = append(data.Software, censusSoftwareRow{
+				Software: sv.Software,
+				Relays:   total,
+				Percent:  percentOf(total, census.Relays),
+			})
+		}
+		data.Software[i].Versions = append(data.Software[i].Versions, sv)
+	}
+	// SoftwareVersion is sorted by deployment already, so rows are too
+
+	for i := 0; i < len(data.Software) && i < censusMatrixSoftware; i++ {
+		data.Matrix = append(data.Matrix, data.Software[i].Software)
+	}
+
+	for _, nip := range census.NIPs {
+		row := censusNIPRow{NIP: nip.NIP, Relays: nip.Relays, Percent: percentOf(nip.Relays, census.Relays)}
+		for _, software := range data.Matrix {
+			row.Cells = append(row.Cells, percentOf(nip.BySoftware[software], census.SoftwareTotals[software]))
+		}
+		data.NIPs = append(data.NIPs, row)
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	renderPage(w, "census", data)
+}
+
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
@@ -0,0 +1,111 @@
+package pages
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// changelogEntries is how many of the latest entries the page and feed show
+const changelogEntries = 200
+
+type changelogEntryView struct {
+	ID        int64
+	Category  string
+	Title     string
+	Body      string
+	Date      string
+	NoteID    string
+	Milestone bool
+}
+
+type changelogPageData struct {
+	Entries []changelogEntryView
+}
+
+// HandleChangelog renders the public changelog of relay policy changes and
+// dataset milestones, newest first
+func (h *Handler) HandleChangelog(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.storage.GetChangelog(r.Context(), changelogEntries)
+	if err != nil {
+		renderError(w, http.StatusServiceUnavailable, "The changelog isn't available right now")
+		return
+	}
+
+	data := changelogPageData{Entries: make([]changelogEntryView, len(entries))}
+	for i, e := range entries {
+		data.Entries[i] = changelogEntryView{
+			ID:        e.ID,
+			Category:  e.Category,
+			Title:     e.Title,
+			Body:      e.Body,
+			Date:      e.CreatedAt.UTC().Format("2006-01-02"),
+			NoteID:    e.NoteID,
+			Milestone: e.Category == storage.ChangelogMilestone,
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	renderPage(w, "changelog", data)
+}
+
+// jsonFeed is a JSON Feed 1.1 document, https://jsonfeed.org/version/1.1
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string   `json:"id"`
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentText   string   `json:"content_text"`
+	DatePublished string   `json:"date_published"`
+	Tags          []string `json:"tags"`
+	// Extension fields, prefixed as JSON Feed requires
+	NoteID string `json:"_nostr_note_id,omitempty"`
+}
+
+// HandleChangelogFeed serves GET /changelog.json, the changelog as a JSON Feed
+func (h *Handler) HandleChangelogFeed(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.storage.GetChangelog(r.Context(), changelogEntries)
+	if err != nil {
+		http.Error(w, "Failed to load changelog", http.StatusServiceUnavailable)
+		return
+	}
+
+	base := baseURL(r)
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       "purplepag.es changelog",
+		HomePageURL: base + "/changelog",
+		FeedURL:     base + "/changelog.json",
+		Items:       make([]jsonFeedItem, len(entries)),
+	}
+	for i, e := range entries {
+		id := strconv.FormatInt(e.ID, 10)
+		content := e.Body
+		if content == "" {
+			content = e.Title
+		}
+		feed.Items[i] = jsonFeedItem{
+			ID:            id,
+			URL:           base + "/changelog#entry-" + id,
+			Title:         e.Title,
+			ContentText:   content,
+			DatePublished: e.CreatedAt.UTC().Format(time.RFC3339),
+			Tags:          []string{e.Category},
+			NoteID:        e.NoteID,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/feed+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(feed)
+}
@@ -3,23 +3,26 @@ package pages
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
-	"sort"
-	"strconv"
+	"net/url"
 	"strings"
 
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/api"
 	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
 )
 
 type Handler struct {
-	storage *storage.Storage
+	storage  *storage.Storage
+	rankings *api.Rankings
 }
 
-func NewHandler(store *storage.Storage) *Handler {
-	return &Handler{storage: store}
+func NewHandler(store *storage.Storage, rankings *api.Rankings) *Handler {
+	return &Handler{storage: store, rankings: rankings}
 }
 
 type Profile struct {
@@ -29,11 +32,20 @@ type Profile struct {
 	Picture       string
 	About         string
 	Nip05         string
+	Nip05Verified bool
+	Rank          int
 	FollowerCount int
+	Trend         int64
+	Completeness  int
+	VerifiedFollowerCount int
 	FollowingCount int
+	Trusted       bool
 	Npub          string
 }
 
+// profileFollowersPerPage is how many followers a profile page lists at once
+const profileFollowersPerPage = 60
+
 var rankingsFuncs = template.FuncMap{
 	"add": func(a, b int) int { return a + b },
 	"sub": func(a, b int) int { return a - b },
@@ -50,121 +62,127 @@ var rankingsFuncs = template.FuncMap{
 }
 
 func (h *Handler) HandleRankings(w http.ResponseWriter, r *http.Request) {
-	page := 1
-	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
-		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-			page = p
-		}
+	query := r.URL.Query()
+	q := api.RankingQuery{
+		Sort:          query.Get("sort"),
+		Cursor:        query.Get("cursor"),
+		Nip05Verified: query.Get("nip05") == "1",
+		HasRelayList:  query.Get("relays") == "1",
+		ExcludeBots:   query.Get("exclude_bots") == "1",
 	}
 
-	limit := 50
-	offset := (page - 1) * limit
-
-	contactLists, err := h.storage.QueryEvents(context.Background(), nostr.Filter{
-		Kinds: []int{3},
-	})
-	if err != nil {
-		http.Error(w, "Failed to query contact lists", http.StatusInternalServerError)
+	page, err := h.rankings.Query(r.Context(), q)
+	if errors.Is(err, api.ErrInvalidRankingQuery) {
+		renderError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	followerCounts := make(map[string]int)
-	latestContactList := make(map[string]*nostr.Event)
-
-	for _, evt := range contactLists {
-		if existing, ok := latestContactList[evt.PubKey]; !ok || evt.CreatedAt > existing.CreatedAt {
-			latestContactList[evt.PubKey] = evt
-		}
-	}
-
-	for _, evt := range latestContactList {
-		for _, tag := range evt.Tags {
-			if len(tag) >= 2 && tag[0] == "p" {
-				pubkey := tag[1]
-				followerCounts[pubkey]++
-			}
-		}
-	}
-
-	type pubkeyCount struct {
-		pubkey string
-		count  int
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, "Failed to load rankings")
+		return
 	}
 
-	ranked := make([]pubkeyCount, 0, len(followerCounts))
-	for pubkey, count := range followerCounts {
-		ranked = append(ranked, pubkeyCount{pubkey, count})
+	pubkeys := make([]string, len(page.Entries))
+	for i, entry := range page.Entries {
+		pubkeys[i] = entry.Pubkey
 	}
-
-	sort.Slice(ranked, func(i, j int) bool {
-		return ranked[i].count > ranked[j].count
-	})
-
-	total := len(ranked)
-	totalPages := (total + limit - 1) / limit
-
-	if offset >= total {
-		offset = 0
-		page = 1
+	verified, _ := h.storage.GetVerifiedFollowerCounts(r.Context(), pubkeys)
+
+	profiles := make([]Profile, 0, len(page.Entries))
+	for _, entry := range page.Entries {
+		profile := h.getProfile(entry.Pubkey)
+		profile.Rank = entry.Rank
+		profile.FollowerCount = entry.Followers
+		profile.Trend = entry.Trend
+		profile.Completeness = entry.Completeness
+		profile.Nip05Verified = entry.Nip05Verified
+		profile.VerifiedFollowerCount = int(verified[entry.Pubkey])
+		profile.Npub = convertToNpub(entry.Pubkey)
+		profiles = append(profiles, profile)
 	}
 
-	end := offset + limit
-	if end > total {
-		end = total
+	// Filters and sort carry over to the next page link
+	filters := url.Values{}
+	for _, key := range []string{"sort", "nip05", "relays", "exclude_bots"} {
+		if v := query.Get(key); v != "" {
+			filters.Set(key, v)
+		}
 	}
-
-	topPubkeys := ranked[offset:end]
-
-	profiles := make([]Profile, 0, len(topPubkeys))
-	for _, pc := range topPubkeys {
-		profile := h.getProfile(pc.pubkey)
-		profile.FollowerCount = pc.count
-		profile.Npub = convertToNpub(pc.pubkey)
-		profiles = append(profiles, profile)
+	next := ""
+	if page.NextCursor != "" {
+		nextValues := url.Values{}
+		for k, v := range filters {
+			nextValues[k] = v
+		}
+		nextValues.Set("cursor", page.NextCursor)
+		next = "/rankings?" + nextValues.Encode()
 	}
 
 	data := struct {
-		Profiles    []Profile
-		Page        int
-		TotalPages  int
-		HasPrev     bool
-		HasNext     bool
-		Total       int
+		Profiles     []Profile
+		Total        int
+		Sort         string
+		Nip05Only    bool
+		RelaysOnly   bool
+		ExcludeBots  bool
+		IsFirstPage  bool
+		FirstPageURL string
+		NextPageURL  string
 	}{
-		Profiles:   profiles,
-		Page:       page,
-		TotalPages: totalPages,
-		HasPrev:    page > 1,
-		HasNext:    page < totalPages,
-		Total:      total,
+		Profiles:     profiles,
+		Total:        page.Total,
+		Sort:         page.Sort,
+		Nip05Only:    q.Nip05Verified,
+		RelaysOnly:   q.HasRelayList,
+		ExcludeBots:  q.ExcludeBots,
+		IsFirstPage:  q.Cursor == "",
+		FirstPageURL: "/rankings?" + filters.Encode(),
+		NextPageURL:  next,
 	}
 
-	tmpl := template.Must(template.New("rankings").Funcs(rankingsFuncs).Parse(rankingsTemplate))
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	tmpl.Execute(w, data)
+	renderPage(w, "rankings", data)
 }
 
 func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 	query := strings.TrimSpace(r.URL.Query().Get("q"))
 
 	if query == "" {
-		tmpl := template.Must(template.New("search").Funcs(rankingsFuncs).Parse(searchTemplate))
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl.Execute(w, struct {
+		renderPage(w, "search", struct {
 			Query    string
 			Profiles []Profile
 		}{Query: "", Profiles: []Profile{}})
 		return
 	}
 
-	events, err := h.storage.SearchProfiles(context.Background(), query, 100)
+	ctx := context.Background()
+
+	// A nostr address lists its claimants first, verified ones on top
+	var claims []api.Nip05Claim
+	if strings.Contains(query, "@") {
+		claims, _ = api.ResolveNip05(ctx, h.storage, query)
+	}
+
+	events, err := h.storage.SearchProfiles(ctx, query, 100)
 	if err != nil {
-		http.Error(w, "Failed to search", http.StatusInternalServerError)
+		renderError(w, http.StatusInternalServerError, "Failed to search")
 		return
 	}
 
-	matches := make([]Profile, 0, len(events))
+	seen := make(map[string]bool, len(claims))
+	verified := make(map[string]bool, len(claims))
+	ordered := make([]*nostr.Event, 0, len(claims)+len(events))
+	for _, c := range claims {
+		seen[c.Pubkey] = true
+		verified[c.Pubkey] = c.Status == api.Nip05Verified
+		ordered = append(ordered, c.Profile)
+	}
 	for _, evt := range events {
+		if !seen[evt.PubKey] {
+			ordered = append(ordered, evt)
+		}
+	}
+
+	matches := make([]Profile, 0, len(ordered))
+	for _, evt := range ordered {
 		var metadata map[string]interface{}
 		if err := json.Unmarshal([]byte(evt.Content), &metadata); err != nil {
 			continue
@@ -177,13 +195,14 @@ func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 		picture, _ := metadata["picture"].(string)
 
 		matches = append(matches, Profile{
-			Pubkey:      evt.PubKey,
-			Name:        name,
-			DisplayName: displayName,
-			Picture:     picture,
-			About:       truncate(about, 150),
-			Nip05:       nip05,
-			Npub:        convertToNpub(evt.PubKey),
+			Pubkey:        evt.PubKey,
+			Name:          name,
+			DisplayName:   displayName,
+			Picture:       picture,
+			About:         truncate(about, 150),
+			Nip05:         nip05,
+			Nip05Verified: verified[evt.PubKey],
+			Npub:          convertToNpub(evt.PubKey),
 		})
 	}
 
@@ -197,18 +216,27 @@ func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
 		Count:    len(matches),
 	}
 
-	tmpl := template.Must(template.New("search").Funcs(rankingsFuncs).Parse(searchTemplate))
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	tmpl.Execute(w, data)
+	renderPage(w, "search", data)
 }
 
 func (h *Handler) HandleProfile(w http.ResponseWriter, r *http.Request) {
 	pubkey := r.URL.Query().Get("pubkey")
 	if pubkey == "" {
-		http.Error(w, "Missing pubkey parameter", http.StatusBadRequest)
+		renderError(w, http.StatusBadRequest, "Missing pubkey parameter")
+		return
+	}
+	if !nostr.IsValid32ByteHex(pubkey) {
+		renderError(w, http.StatusNotFound, "No profile with that pubkey")
 		return
 	}
 
+	// A key that migrated shows the key it moved to
+	var migratedFrom string
+	if migration, _ := h.storage.ResolveKeyMigration(context.Background(), pubkey); migration != nil {
+		migratedFrom = convertToNpub(pubkey)
+		pubkey = migration.NewPubkey
+	}
+
 	profile := h.getProfile(pubkey)
 	profile.Npub = convertToNpub(pubkey)
 
@@ -240,18 +268,46 @@ func (h *Handler) HandleProfile(w http.ResponseWriter, r *http.Request) {
 	// Get follower count from storage
 	followerCount, _ := h.storage.GetFollowerCount(context.Background(), pubkey)
 	profile.FollowerCount = int(followerCount)
+	verifiedCount, _ := h.storage.GetVerifiedFollowerCount(context.Background(), pubkey)
+	profile.VerifiedFollowerCount = int(verifiedCount)
+
+	relayHealth, _ := api.CheckRelayList(context.Background(), h.storage, pubkey)
+
+	// Followers are paged, trusted and most followed first; without the follows
+	// index the section is left out
+	followersCursor := r.URL.Query().Get("followers")
+	var followers []Profile
+	var nextFollowers string
+	if page, err := api.FollowersPage(context.Background(), h.storage, pubkey, followersCursor, profileFollowersPerPage); err == nil {
+		for _, f := range page.Followers {
+			fp := h.getProfile(f.Pubkey)
+			fp.Npub = convertToNpub(f.Pubkey)
+			fp.Trusted = f.Trusted
+			fp.FollowerCount = int(f.Followers)
+			followers = append(followers, fp)
+		}
+		nextFollowers = page.NextCursor
+	}
 
 	data := struct {
-		Profile   Profile
-		Following []Profile
+		Profile       Profile
+		Following     []Profile
+		Followers     []Profile
+		FollowersPage bool // a later page of followers, not the first
+		NextFollowers string
+		MigratedFrom  string
+		RelayHealth   *api.RelayListCheck
 	}{
-		Profile:   profile,
-		Following: following,
+		Profile:       profile,
+		Following:     following,
+		Followers:     followers,
+		FollowersPage: followersCursor != "",
+		NextFollowers: nextFollowers,
+		MigratedFrom:  migratedFrom,
+		RelayHealth:   relayHealth,
 	}
 
-	tmpl := template.Must(template.New("profile").Funcs(rankingsFuncs).Parse(profileTemplate))
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	tmpl.Execute(w, data)
+	renderPage(w, "profile", data)
 }
 
 func (h *Handler) getProfile(pubkey string) Profile {
@@ -300,6 +356,43 @@ func (h *Handler) getProfile(pubkey string) Profile {
 	return profile
 }
 
+// renderPage executes the named template (an operator override if present) with the page funcs
+func renderPage(w http.ResponseWriter, name string, data interface{}) {
+	tmpl, err := templates.Get(name, rankingsFuncs)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl.Execute(w, data)
+}
+
+// renderError writes a branded error page, falling back to plain text if the
+// error template itself can't be loaded
+func renderError(w http.ResponseWriter, status int, message string) {
+	tmpl, err := templates.Get("error", nil)
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpl.Execute(w, struct {
+		Status  int
+		Title   string
+		Message string
+	}{
+		Status:  status,
+		Title:   http.StatusText(status),
+		Message: message,
+	})
+}
+
+// HandleNotFound serves the branded 404 for paths no page or API route claims
+func (h *Handler) HandleNotFound(w http.ResponseWriter, r *http.Request) {
+	renderError(w, http.StatusNotFound, "There's nothing at "+r.URL.Path)
+}
+
 func truncate(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
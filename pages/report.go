@@ -0,0 +1,94 @@
+package pages
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/pablof7z/purplepag.es/api"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// maxReportReason bounds the free-text part of a submitted report
+const maxReportReason = 1000
+
+// ReportHandler serves /report, where anyone can report a spam or impersonation
+// pubkey and find how to reach the relay operator
+type ReportHandler struct {
+	storage *storage.Storage
+	contact string
+}
+
+func NewReportHandler(store *storage.Storage, contact string) *ReportHandler {
+	return &ReportHandler{storage: store, contact: contact}
+}
+
+func (h *ReportHandler) HandleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		h.submit(w, r)
+		return
+	}
+
+	data := struct {
+		Contact string
+		Pubkey  string
+		Message string
+		Error   string
+	}{
+		Contact: h.contact,
+		Pubkey:  r.URL.Query().Get("pubkey"),
+		Message: r.URL.Query().Get("message"),
+		Error:   r.URL.Query().Get("error"),
+	}
+
+	renderPage(w, "report", data)
+}
+
+func (h *ReportHandler) submit(w http.ResponseWriter, r *http.Request) {
+	pubkey, ok := parsePubkey(strings.TrimSpace(r.FormValue("pubkey")))
+	if !ok {
+		http.Redirect(w, r, "/report?error="+url.QueryEscape("Enter a hex pubkey or npub"), http.StatusSeeOther)
+		return
+	}
+
+	reportType := r.FormValue("type")
+	if !storage.IsReportType(reportType) {
+		http.Redirect(w, r, "/report?error="+url.QueryEscape("Choose spam or impersonation"), http.StatusSeeOther)
+		return
+	}
+
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if len(reason) > maxReportReason {
+		reason = reason[:maxReportReason]
+	}
+
+	err := h.storage.SaveAbuseReport(r.Context(), storage.AbuseReport{
+		Reporter:  "ip:" + api.ClientIP(r),
+		Reported:  pubkey,
+		Type:      reportType,
+		Source:    storage.ReportSourceWeb,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, "Failed to save report")
+		return
+	}
+
+	http.Redirect(w, r, "/report?message="+url.QueryEscape("Thanks, your report was received"), http.StatusSeeOther)
+}
+
+// parsePubkey accepts a pubkey as hex or npub and returns it as hex
+func parsePubkey(value string) (string, bool) {
+	if strings.HasPrefix(value, "npub") {
+		prefix, decoded, err := nip19.Decode(value)
+		if err != nil || prefix != "npub" {
+			return "", false
+		}
+		value = decoded.(string)
+	}
+	return value, nostr.IsValid32ByteHex(value)
+}
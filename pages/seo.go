@@ -0,0 +1,110 @@
+package pages
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/api"
+)
+
+// sitemapProfiles is how many of the most followed profiles the sitemap lists
+const sitemapProfiles = 5000
+
+// robotsDisallow keeps crawlers on rankings, profiles and the relay census, away from admin
+// pages, the API and per-query pages that would only spread them thin
+var robotsDisallow = []string{
+	"/stats",
+	"/api/",
+	"/relays",
+	"/metrics",
+	"/embed/",
+	"/badge/",
+	"/search",
+	"/timecapsule",
+	"/report",
+	"/e/",
+}
+
+// HandleRobots serves GET /robots.txt
+func (h *Handler) HandleRobots(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	b.WriteString("Allow: /rankings\n")
+	b.WriteString("Allow: /profile\n")
+	b.WriteString("Allow: /relays/census\n")
+	for _, path := range robotsDisallow {
+		fmt.Fprintf(&b, "Disallow: %s\n", path)
+	}
+	fmt.Fprintf(&b, "\nSitemap: %s/sitemap.xml\n", baseURL(r))
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write([]byte(b.String()))
+}
+
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// HandleSitemap serves GET /sitemap.xml: the rankings for each sort, then the
+// most followed profiles in rank order
+func (h *Handler) HandleSitemap(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	base := baseURL(r)
+	set := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	set.URLs = append(set.URLs, sitemapURL{Loc: base + "/rankings", ChangeFreq: "hourly", Priority: "1.0"})
+	for _, sort := range []string{api.SortTrend, api.SortCompleteness} {
+		set.URLs = append(set.URLs, sitemapURL{Loc: base + "/rankings?sort=" + sort, ChangeFreq: "hourly", Priority: "0.8"})
+	}
+	set.URLs = append(set.URLs, sitemapURL{Loc: base + "/relays/census", ChangeFreq: "daily", Priority: "0.6"})
+
+	cursor := ""
+	for listed := 0; listed < sitemapProfiles; {
+		page, err := h.rankings.Query(ctx, api.RankingQuery{Sort: api.SortFollowers, Cursor: cursor, Limit: sitemapProfiles - listed})
+		if err != nil {
+			// A partial sitemap is still better than none
+			log.Printf("Sitemap: failed to load rankings: %v", err)
+			break
+		}
+		for _, entry := range page.Entries {
+			set.URLs = append(set.URLs, sitemapURL{Loc: base + "/profile?pubkey=" + entry.Pubkey, ChangeFreq: "daily", Priority: "0.5"})
+		}
+		listed += len(page.Entries)
+		if page.NextCursor == "" || len(page.Entries) == 0 {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(set)
+}
+
+// baseURL is the scheme and host the request reached us on, honouring a
+// TLS-terminating proxy in front
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -2,13 +2,15 @@ package pages
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"html/template"
 	"net/http"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/kinds"
 	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
 )
 
 type TimecapsuleHandler struct {
@@ -58,11 +60,27 @@ type DeltaView struct {
 	RelayChanges   []RelayChangeView
 }
 
+// SnapshotView is a profile as it stood at a past date
+type SnapshotView struct {
+	Date         string
+	Name         string
+	DisplayName  string
+	Picture      string
+	About        string
+	Nip05        string
+	HasProfile   bool
+	HasContacts  bool
+	FollowsCount int
+	Relays       []string
+}
+
 type TimecapsulePageData struct {
 	TotalVersions  int64
 	UniquePubkeys  int64
 	SearchPubkey   string
 	SearchName     string
+	SearchDate     string
+	Snapshot       *SnapshotView
 	RecentDeltas   []DeltaView
 	PubkeyHistory  []DeltaView
 	Error          string
@@ -85,12 +103,25 @@ func (h *TimecapsuleHandler) HandleTimecapsule() http.HandlerFunc {
 			// Get name for display
 			names, _ := h.storage.GetProfileNames(ctx, []string{pubkey})
 			data.SearchName = names[pubkey]
+
+			if date := r.URL.Query().Get("at"); date != "" {
+				data.SearchDate = date
+				day, err := time.Parse("2006-01-02", date)
+				if err != nil {
+					data.Error = "Invalid date, expected YYYY-MM-DD"
+				} else {
+					// Use the end of the picked day so changes made that day are included
+					at := nostr.Timestamp(day.Add(24*time.Hour - time.Second).Unix())
+					data.Snapshot = h.getSnapshot(ctx, pubkey, at)
+					data.Snapshot.Date = date
+				}
+			}
 		} else {
 			// Show recent changes
 			data.RecentDeltas = h.getRecentDeltas(ctx, 50)
 		}
 
-		tmpl, err := template.New("timecapsule").Parse(timecapsuleTemplate)
+		tmpl, err := templates.Get("timecapsule", nil)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Template error: %v", err), http.StatusInternalServerError)
 			return
@@ -182,6 +213,47 @@ func (h *TimecapsuleHandler) getPubkeyDeltas(ctx context.Context, pubkey string)
 	return deltas
 }
 
+// getSnapshot reconstructs the profile, follows and relay list as they were at the given time
+func (h *TimecapsuleHandler) getSnapshot(ctx context.Context, pubkey string, at nostr.Timestamp) *SnapshotView {
+	snapshot := &SnapshotView{}
+
+	if evt, _ := h.storage.GetEventAt(ctx, pubkey, 0, at); evt != nil {
+		var profile struct {
+			Name        string `json:"name"`
+			DisplayName string `json:"display_name"`
+			Picture     string `json:"picture"`
+			About       string `json:"about"`
+			Nip05       string `json:"nip05"`
+		}
+		json.Unmarshal([]byte(evt.Content), &profile)
+		snapshot.HasProfile = true
+		snapshot.Name = profile.Name
+		snapshot.DisplayName = profile.DisplayName
+		snapshot.Picture = profile.Picture
+		snapshot.About = truncate(profile.About, 300)
+		snapshot.Nip05 = profile.Nip05
+	}
+
+	if evt, _ := h.storage.GetEventAt(ctx, pubkey, 3, at); evt != nil {
+		snapshot.HasContacts = true
+		for _, tag := range evt.Tags {
+			if len(tag) >= 2 && tag[0] == "p" {
+				snapshot.FollowsCount++
+			}
+		}
+	}
+
+	if evt, _ := h.storage.GetEventAt(ctx, pubkey, 10002, at); evt != nil {
+		for _, tag := range evt.Tags {
+			if len(tag) >= 2 && tag[0] == "r" {
+				snapshot.Relays = append(snapshot.Relays, tag[1])
+			}
+		}
+	}
+
+	return snapshot
+}
+
 func (h *TimecapsuleHandler) buildDelta(ctx context.Context, newVer *storage.EventVersion, oldVer *storage.EventVersion) *DeltaView {
 	names, _ := h.storage.GetProfileNames(ctx, []string{newVer.PubKey})
 
@@ -190,7 +262,7 @@ func (h *TimecapsuleHandler) buildDelta(ctx context.Context, newVer *storage.Eve
 		PubKeyShort:  shortPubkey(newVer.PubKey),
 		Name:         names[newVer.PubKey],
 		Kind:         newVer.Kind,
-		KindName:     kindName(newVer.Kind),
+		KindName:     kinds.Name(newVer.Kind),
 		Timestamp:    time.Unix(int64(newVer.CreatedAt), 0).Format("2006-01-02 15:04"),
 		TimestampAgo: formatTimeAgo(time.Since(time.Unix(int64(newVer.CreatedAt), 0))),
 	}
@@ -251,21 +323,6 @@ func shortPubkey(pk string) string {
 	return pk[:8] + "..." + pk[len(pk)-8:]
 }
 
-func kindName(kind int) string {
-	names := map[int]string{
-		0:     "Profile",
-		3:     "Contacts",
-		10002: "Relay List",
-		10000: "Mute List",
-		10001: "Pinned Notes",
-		10003: "Bookmarks",
-	}
-	if name, ok := names[kind]; ok {
-		return name
-	}
-	return fmt.Sprintf("Kind %d", kind)
-}
-
 func formatTimeAgo(d time.Duration) string {
 	if d < time.Minute {
 		return "just now"
@@ -297,399 +354,3 @@ func formatTimeAgo(d time.Duration) string {
 	}
 	return fmt.Sprintf("%d months ago", months)
 }
-
-var timecapsuleTemplate = `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>purplepag.es - Time Capsule</title>
-    <style>
-        * { margin: 0; padding: 0; box-sizing: border-box; }
-        body {
-            font-family: 'SF Mono', 'Monaco', 'Inconsolata', 'Fira Code', monospace;
-            background: #0d1117;
-            min-height: 100vh;
-            padding: 2rem;
-            color: #c9d1d9;
-        }
-        .container { max-width: 1000px; margin: 0 auto; }
-        .back-link {
-            display: inline-block;
-            margin-bottom: 1.5rem;
-            color: #58a6ff;
-            text-decoration: none;
-            font-size: 0.875rem;
-        }
-        .back-link:hover {
-            text-decoration: underline;
-        }
-        header {
-            margin-bottom: 2rem;
-            text-align: center;
-            border-bottom: 1px solid #21262d;
-            padding-bottom: 1rem;
-        }
-        h1 {
-            font-size: 2rem;
-            font-weight: 600;
-            margin-bottom: 0.5rem;
-            color: #f0f6fc;
-        }
-        .subtitle {
-            color: #8b949e;
-            font-size: 0.875rem;
-        }
-        .stats-row {
-            display: flex;
-            gap: 1rem;
-            margin-bottom: 2rem;
-            justify-content: center;
-        }
-        .stat-box {
-            background: #161b22;
-            border: 1px solid #21262d;
-            border-radius: 6px;
-            padding: 1rem 2rem;
-            text-align: center;
-        }
-        .stat-box .value {
-            font-size: 2rem;
-            font-weight: 600;
-            color: #58a6ff;
-            font-variant-numeric: tabular-nums;
-        }
-        .stat-box .label {
-            font-size: 0.75rem;
-            color: #8b949e;
-            text-transform: uppercase;
-            letter-spacing: 0.05em;
-        }
-        .search-box {
-            background: #161b22;
-            border: 1px solid #21262d;
-            border-radius: 6px;
-            padding: 1.5rem;
-            margin-bottom: 2rem;
-        }
-        .search-box input {
-            width: 100%;
-            padding: 0.75rem 1rem;
-            font-size: 0.875rem;
-            background: #0d1117;
-            border: 1px solid #30363d;
-            border-radius: 6px;
-            color: #c9d1d9;
-            font-family: 'SF Mono', 'Monaco', 'Inconsolata', 'Fira Code', monospace;
-        }
-        .search-box input:focus {
-            outline: none;
-            border-color: #58a6ff;
-        }
-        .search-box input::placeholder {
-            color: #8b949e;
-        }
-        .search-box button {
-            margin-top: 0.75rem;
-            padding: 0.5rem 1.5rem;
-            background: #238636;
-            border: none;
-            border-radius: 6px;
-            color: #ffffff;
-            font-weight: 600;
-            cursor: pointer;
-            font-size: 0.875rem;
-            font-family: inherit;
-        }
-        .search-box button:hover {
-            background: #2ea043;
-        }
-        .delta-card {
-            background: #161b22;
-            border: 1px solid #21262d;
-            border-radius: 6px;
-            padding: 1.5rem;
-            margin-bottom: 1rem;
-        }
-        .delta-header {
-            display: flex;
-            justify-content: space-between;
-            align-items: center;
-            margin-bottom: 1rem;
-            padding-bottom: 0.75rem;
-            border-bottom: 1px solid #21262d;
-        }
-        .delta-user {
-            display: flex;
-            align-items: center;
-            gap: 0.75rem;
-        }
-        .delta-name {
-            font-weight: 600;
-            color: #f0f6fc;
-        }
-        .delta-pubkey {
-            font-family: 'SF Mono', 'Monaco', 'Inconsolata', 'Fira Code', monospace;
-            font-size: 0.75rem;
-            color: #8b949e;
-        }
-        .delta-meta {
-            text-align: right;
-        }
-        .delta-kind {
-            display: inline-block;
-            padding: 0.25rem 0.75rem;
-            background: #388bfd26;
-            border: 1px solid #388bfd;
-            border-radius: 20px;
-            font-size: 0.75rem;
-            color: #58a6ff;
-            margin-bottom: 0.25rem;
-        }
-        .delta-time {
-            font-size: 0.75rem;
-            color: #8b949e;
-        }
-        .change-list { list-style: none; }
-        .change-item {
-            padding: 0.5rem 0;
-            border-bottom: 1px solid #21262d;
-            display: flex;
-            align-items: flex-start;
-            gap: 0.75rem;
-            font-size: 0.875rem;
-        }
-        .change-item:last-child { border-bottom: none; }
-        .change-field {
-            min-width: 100px;
-            font-weight: 500;
-            color: #8b949e;
-            font-size: 0.875rem;
-        }
-        .change-values { flex: 1; }
-        .old-value {
-            color: #f85149;
-            text-decoration: line-through;
-            font-size: 0.875rem;
-        }
-        .new-value {
-            color: #3fb950;
-            font-size: 0.875rem;
-        }
-        .follow-action {
-            display: inline-flex;
-            align-items: center;
-            gap: 0.5rem;
-            padding: 0.25rem 0.75rem;
-            border-radius: 6px;
-            font-size: 0.8rem;
-            margin: 0.25rem;
-            font-family: 'SF Mono', 'Monaco', 'Inconsolata', 'Fira Code', monospace;
-        }
-        .follow-action.followed {
-            background: rgba(63, 185, 80, 0.15);
-            border: 1px solid #3fb950;
-            color: #3fb950;
-        }
-        .follow-action.unfollowed {
-            background: rgba(248, 81, 73, 0.15);
-            border: 1px solid #f85149;
-            color: #f85149;
-        }
-        .relay-action {
-            display: inline-flex;
-            align-items: center;
-            gap: 0.5rem;
-            padding: 0.25rem 0.75rem;
-            border-radius: 6px;
-            font-size: 0.75rem;
-            font-family: 'SF Mono', 'Monaco', 'Inconsolata', 'Fira Code', monospace;
-            margin: 0.25rem;
-        }
-        .relay-action.added {
-            background: rgba(63, 185, 80, 0.15);
-            border: 1px solid #3fb950;
-            color: #3fb950;
-        }
-        .relay-action.removed {
-            background: rgba(248, 81, 73, 0.15);
-            border: 1px solid #f85149;
-            color: #f85149;
-        }
-        .empty-state {
-            text-align: center;
-            padding: 3rem;
-            color: #8b949e;
-            font-size: 0.875rem;
-        }
-        .section-title {
-            font-size: 1.125rem;
-            font-weight: 600;
-            margin-bottom: 1.5rem;
-            color: #f0f6fc;
-        }
-        @media (max-width: 768px) {
-            body { padding: 1rem; }
-            h1 { font-size: 1.75rem; }
-            .stats-row { flex-direction: column; }
-            .delta-header { flex-direction: column; align-items: flex-start; gap: 0.5rem; }
-            .delta-meta { text-align: left; }
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <a href="/" class="back-link">← Back to Home</a>
-
-        <header>
-            <h1>Time Capsule</h1>
-            <p class="subtitle">Track changes in profiles, follows, and relays over time</p>
-        </header>
-
-        <div class="stats-row">
-            <div class="stat-box">
-                <div class="value">{{.TotalVersions}}</div>
-                <div class="label">Archived Versions</div>
-            </div>
-            <div class="stat-box">
-                <div class="value">{{.UniquePubkeys}}</div>
-                <div class="label">Users Tracked</div>
-            </div>
-        </div>
-
-        <div class="search-box">
-            <form method="GET">
-                <input type="text" name="pubkey" placeholder="Search by pubkey (hex)..." value="{{.SearchPubkey}}">
-                <button type="submit">Search</button>
-            </form>
-        </div>
-
-        {{if .SearchPubkey}}
-        <h2 class="section-title">
-            History for {{if .SearchName}}{{.SearchName}}{{else}}{{.SearchPubkey}}{{end}}
-        </h2>
-        {{if .PubkeyHistory}}
-            {{range .PubkeyHistory}}
-            <div class="delta-card">
-                <div class="delta-header">
-                    <div class="delta-user">
-                        <div>
-                            {{if $.SearchName}}<div class="delta-name">{{$.SearchName}}</div>{{end}}
-                            <div class="delta-pubkey">{{.PubKeyShort}}</div>
-                        </div>
-                    </div>
-                    <div class="delta-meta">
-                        <div class="delta-kind">{{.KindName}}</div>
-                        <div class="delta-time">{{.Timestamp}} ({{.TimestampAgo}})</div>
-                    </div>
-                </div>
-
-                {{if .ProfileChanges}}
-                <ul class="change-list">
-                    {{range .ProfileChanges}}
-                    <li class="change-item">
-                        <span class="change-field">{{.Field}}</span>
-                        <div class="change-values">
-                            {{if .OldValue}}<div class="old-value">{{.OldValue}}</div>{{end}}
-                            <div class="new-value">{{if .NewValue}}{{.NewValue}}{{else}}<em>(cleared)</em>{{end}}</div>
-                        </div>
-                    </li>
-                    {{end}}
-                </ul>
-                {{end}}
-
-                {{if .ContactChanges}}
-                <div style="display: flex; flex-wrap: wrap;">
-                    {{range .ContactChanges}}
-                    <span class="follow-action {{.Action}}">
-                        {{if eq .Action "followed"}}+{{else}}-{{end}}
-                        {{if .Name}}{{.Name}}{{else}}{{.Pubkey}}{{end}}
-                    </span>
-                    {{end}}
-                </div>
-                {{end}}
-
-                {{if .RelayChanges}}
-                <div style="display: flex; flex-wrap: wrap;">
-                    {{range .RelayChanges}}
-                    <span class="relay-action {{.Action}}">
-                        {{if eq .Action "added"}}+{{else}}-{{end}} {{.URL}}
-                    </span>
-                    {{end}}
-                </div>
-                {{end}}
-
-                {{if and (not .ProfileChanges) (not .ContactChanges) (not .RelayChanges)}}
-                <div style="color: #8b949e; font-style: italic;">Initial version</div>
-                {{end}}
-            </div>
-            {{end}}
-        {{else}}
-        <div class="empty-state">No history found for this pubkey</div>
-        {{end}}
-
-        {{else}}
-
-        <h2 class="section-title">Recent Changes</h2>
-        {{if .RecentDeltas}}
-            {{range .RecentDeltas}}
-            <div class="delta-card">
-                <div class="delta-header">
-                    <div class="delta-user">
-                        <div>
-                            {{if .Name}}<div class="delta-name">{{.Name}}</div>{{end}}
-                            <a href="/timecapsule?pubkey={{.PubKey}}" class="delta-pubkey" style="color: #58a6ff; text-decoration: none;">{{.PubKeyShort}}</a>
-                        </div>
-                    </div>
-                    <div class="delta-meta">
-                        <div class="delta-kind">{{.KindName}}</div>
-                        <div class="delta-time">{{.TimestampAgo}}</div>
-                    </div>
-                </div>
-
-                {{if .ProfileChanges}}
-                <ul class="change-list">
-                    {{range .ProfileChanges}}
-                    <li class="change-item">
-                        <span class="change-field">{{.Field}}</span>
-                        <div class="change-values">
-                            {{if .OldValue}}<div class="old-value">{{.OldValue}}</div>{{end}}
-                            <div class="new-value">{{if .NewValue}}{{.NewValue}}{{else}}<em>(cleared)</em>{{end}}</div>
-                        </div>
-                    </li>
-                    {{end}}
-                </ul>
-                {{end}}
-
-                {{if .ContactChanges}}
-                <div style="display: flex; flex-wrap: wrap;">
-                    {{range .ContactChanges}}
-                    <span class="follow-action {{.Action}}">
-                        {{if eq .Action "followed"}}+{{else}}-{{end}}
-                        {{if .Name}}{{.Name}}{{else}}{{.Pubkey}}{{end}}
-                    </span>
-                    {{end}}
-                </div>
-                {{end}}
-
-                {{if .RelayChanges}}
-                <div style="display: flex; flex-wrap: wrap;">
-                    {{range .RelayChanges}}
-                    <span class="relay-action {{.Action}}">
-                        {{if eq .Action "added"}}+{{else}}-{{end}} {{.URL}}
-                    </span>
-                    {{end}}
-                </div>
-                {{end}}
-            </div>
-            {{end}}
-        {{else}}
-        <div class="empty-state">
-            <p>No changes recorded yet.</p>
-            <p style="margin-top: 0.5rem; font-size: 0.875rem;">Changes will appear here as users update their profiles, follows, and relay lists.</p>
-        </div>
-        {{end}}
-        {{end}}
-    </div>
-</body>
-</html>`
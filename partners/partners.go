@@ -0,0 +1,251 @@
+// Package partners issues sync tokens to relays that mirror this one. A token
+// lifts its holder out of the anonymous limits: more NIP-77 negentropy sessions,
+// no scraper throttling, the partner API allowance and the bulk snapshot.
+package partners
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/pablof7z/purplepag.es/api"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// TokenParam is the websocket URL query parameter partners pass their token in,
+// e.g. wss://purplepag.es/?sync_token=<token>
+const TokenParam = "sync_token"
+
+// Partners verifies sync tokens and counts partner usage, flushing the counts
+// to storage on every reload
+type Partners struct {
+	storage *storage.Storage
+	secret  []byte
+
+	mu     sync.Mutex
+	active map[string]storage.SyncPartner       // partner ID -> unrevoked partner
+	usage  map[string]*storage.SyncPartnerUsage // partner ID -> usage since the last flush
+
+	// Negentropy sessions opened per client in the current hour
+	hour     time.Time
+	sessions map[string]int
+}
+
+func New(store *storage.Storage, secret string) *Partners {
+	return &Partners{
+		storage:  store,
+		secret:   []byte(secret),
+		active:   make(map[string]storage.SyncPartner),
+		usage:    make(map[string]*storage.SyncPartnerUsage),
+		sessions: make(map[string]int),
+	}
+}
+
+// StartReload keeps the active partners in sync with storage and writes usage
+// counts out, once more when ctx is done
+func (p *Partners) StartReload(ctx context.Context, interval time.Duration) {
+	p.load(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.flush(context.Background())
+			return
+		case <-ticker.C:
+			p.flush(ctx)
+			p.load(ctx)
+		}
+	}
+}
+
+func (p *Partners) load(ctx context.Context) {
+	partners, err := p.storage.GetSyncPartners(ctx, true)
+	if err != nil {
+		log.Printf("partners: failed to load partners: %v", err)
+		return
+	}
+
+	active := make(map[string]storage.SyncPartner, len(partners))
+	for _, partner := range partners {
+		active[partner.ID] = partner
+	}
+
+	p.mu.Lock()
+	p.active = active
+	p.mu.Unlock()
+}
+
+func (p *Partners) flush(ctx context.Context) {
+	p.mu.Lock()
+	usage := p.usage
+	p.usage = make(map[string]*storage.SyncPartnerUsage)
+	p.mu.Unlock()
+
+	now := time.Now()
+	for id, u := range usage {
+		if err := p.storage.AddSyncPartnerUsage(ctx, id, *u, now); err != nil {
+			log.Printf("partners: failed to save usage of %s: %v", id, err)
+		}
+	}
+}
+
+// Issue registers a partner and returns its token, which is shown only once
+func (p *Partners) Issue(ctx context.Context, name, relayURL string) (storage.SyncPartner, string, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	partner := storage.SyncPartner{
+		ID:       hex.EncodeToString(id),
+		Name:     name,
+		RelayURL: relayURL,
+		IssuedAt: time.Unix(time.Now().Unix(), 0),
+	}
+	if err := p.storage.SaveSyncPartner(ctx, partner); err != nil {
+		return partner, "", err
+	}
+
+	p.mu.Lock()
+	p.active[partner.ID] = partner
+	p.mu.Unlock()
+	log.Printf("partners: issued sync token %s to %s", partner.ID, name)
+
+	return partner, partner.ID + "." + p.sign(partner), nil
+}
+
+// Revoke invalidates a partner's token, reporting whether it was valid
+func (p *Partners) Revoke(ctx context.Context, id string) (bool, error) {
+	revoked, err := p.storage.RevokeSyncPartner(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	p.mu.Lock()
+	delete(p.active, id)
+	p.mu.Unlock()
+	if revoked {
+		log.Printf("partners: revoked sync token %s", id)
+	}
+	return revoked, nil
+}
+
+// Verify returns the active partner token belongs to
+func (p *Partners) Verify(token string) (storage.SyncPartner, bool) {
+	id, sig, ok := strings.Cut(token, ".")
+	if !ok || len(p.secret) == 0 {
+		return storage.SyncPartner{}, false
+	}
+
+	p.mu.Lock()
+	partner, ok := p.active[id]
+	p.mu.Unlock()
+	if !ok || !hmac.Equal([]byte(sig), []byte(p.sign(partner))) {
+		return storage.SyncPartner{}, false
+	}
+	return partner, true
+}
+
+// FromConnection returns the partner whose token the websocket connection was
+// opened with, in the URL or as an API key header
+func (p *Partners) FromConnection(ctx context.Context) (storage.SyncPartner, bool) {
+	conn := khatru.GetConnection(ctx)
+	if conn == nil || conn.Request == nil {
+		return storage.SyncPartner{}, false
+	}
+	token := conn.Request.URL.Query().Get(TokenParam)
+	if token == "" {
+		token = api.RequestAPIKey(conn.Request)
+	}
+	if token == "" {
+		return storage.SyncPartner{}, false
+	}
+	return p.Verify(token)
+}
+
+// AllowNegentropy spends one of client's negentropy sessions for this hour. A
+// negative perHour allows none.
+func (p *Partners) AllowNegentropy(client string, perHour int) bool {
+	if perHour < 0 {
+		return false
+	}
+
+	hour := time.Now().Truncate(time.Hour)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !hour.Equal(p.hour) {
+		p.hour = hour
+		p.sessions = make(map[string]int)
+	}
+	if p.sessions[client] >= perHour {
+		return false
+	}
+	p.sessions[client]++
+	return true
+}
+
+func (p *Partners) RecordNegentropySession(id string) {
+	p.record(id, func(u *storage.SyncPartnerUsage) { u.NegentropySessions++ })
+}
+
+func (p *Partners) RecordRequest(id string) {
+	p.record(id, func(u *storage.SyncPartnerUsage) { u.Requests++ })
+}
+
+func (p *Partners) RecordSnapshot(id string) {
+	p.record(id, func(u *storage.SyncPartnerUsage) { u.Snapshots++ })
+}
+
+func (p *Partners) record(id string, add func(u *storage.SyncPartnerUsage)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u := p.usage[id]
+	if u == nil {
+		u = &storage.SyncPartnerUsage{}
+		p.usage[id] = u
+	}
+	add(u)
+}
+
+// WrapSnapshot counts snapshot downloads by partners and, with partnersOnly,
+// refuses everyone else
+func (p *Partners) WrapSnapshot(next http.HandlerFunc, partnersOnly bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		partner, ok := p.Verify(api.RequestAPIKey(r))
+		if !ok {
+			if partnersOnly {
+				writeError(w, http.StatusForbidden, "the snapshot is only available with a partner sync token")
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		p.RecordSnapshot(partner.ID)
+		next(w, r)
+	}
+}
+
+// sign is the token signature, binding the partner ID to its issue time so a
+// token can't be forged for another partner
+func (p *Partners) sign(partner storage.SyncPartner) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(partner.ID + ":" + strconv.FormatInt(partner.IssuedAt.Unix(), 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,154 @@
+// Package profiling serves pprof on the admin listener and writes heap profiles
+// when resident memory crosses a threshold, so an OOM during a big sync leaves
+// something to diagnose it with.
+package profiling
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// checkInterval is how often resident memory is sampled
+	checkInterval = 10 * time.Second
+	// captureCooldown spaces captures while memory stays above the threshold
+	captureCooldown = 10 * time.Minute
+)
+
+// Handler serves the net/http/pprof endpoints under /debug/pprof/
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Serve runs the admin listener on addr until ctx is done
+func Serve(ctx context.Context, addr string) {
+	server := &http.Server{Addr: addr, Handler: Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Profiling: serving pprof on %s/debug/pprof/", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Profiling: admin listener failed: %v", err)
+	}
+}
+
+// HeapWatcher writes a heap profile whenever resident memory is above its
+// threshold, keeping the newest few
+type HeapWatcher struct {
+	dir       string
+	prefix    string // process name, so the relay and analytics worker rotate separately
+	threshold uint64 // bytes
+	keep      int
+}
+
+func NewHeapWatcher(dir, prefix string, thresholdMB, keep int) (*HeapWatcher, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create profile directory: %w", err)
+	}
+	return &HeapWatcher{
+		dir:       dir,
+		prefix:    prefix,
+		threshold: uint64(thresholdMB) * 1024 * 1024,
+		keep:      keep,
+	}, nil
+}
+
+// Start samples resident memory until ctx is done
+func (h *HeapWatcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	var lastCapture time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rss := residentBytes()
+			if rss < h.threshold || time.Since(lastCapture) < captureCooldown {
+				continue
+			}
+			lastCapture = time.Now()
+			if path, err := h.capture(rss); err != nil {
+				log.Printf("Profiling: failed to capture heap profile: %v", err)
+			} else {
+				log.Printf("Profiling: resident memory at %d MB, heap profile written to %s", rss/1024/1024, path)
+			}
+		}
+	}
+}
+
+func (h *HeapWatcher) capture(rss uint64) (string, error) {
+	name := fmt.Sprintf("%s-heap-%s-%dMB.pprof", h.prefix, time.Now().UTC().Format("20060102T150405Z"), rss/1024/1024)
+	path := filepath.Join(h.dir, name)
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	if err := rpprof.Lookup("heap").WriteTo(f, 0); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+
+	h.rotate()
+	return path, nil
+}
+
+// rotate removes all but the newest keep profiles of this process. Names sort
+// by capture time.
+func (h *HeapWatcher) rotate() {
+	matches, err := filepath.Glob(filepath.Join(h.dir, h.prefix+"-heap-*.pprof"))
+	if err != nil || len(matches) <= h.keep {
+		return
+	}
+	sort.Strings(matches)
+	for _, path := range matches[:len(matches)-h.keep] {
+		os.Remove(path)
+	}
+}
+
+// residentBytes is the process's resident set size from /proc, or the memory
+// the Go runtime holds from the OS where /proc isn't available
+func residentBytes() uint64 {
+	if data, err := os.ReadFile("/proc/self/statm"); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) >= 2 {
+			if pages, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.Sys
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pablof7z/purplepag.es/config"
+	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/sync"
+)
+
+// initialSyncReady reports whether the initial sync is far enough along for the
+// relay to take traffic under the configured startup mode
+func initialSyncReady(cfg config.SyncConfig, progress sync.Progress) bool {
+	if cfg.Startup != config.SyncStartupGated || progress.Finished {
+		return true
+	}
+	if cfg.ReadyAfterEvents > 0 && progress.Events >= cfg.ReadyAfterEvents {
+		return true
+	}
+	return cfg.ReadyAfterRelays > 0 && progress.RelaysDone >= cfg.ReadyAfterRelays
+}
+
+// readyzHandler serves /readyz for load balancers and orchestrators: 200 once
+// ready, 503 with the initial sync progress before. syncer is nil when no
+// initial sync runs. The analytics database is reported but never makes the
+// relay unready, since clients are served without it; a degraded relay answers
+// 200 with a JSON body naming the analytics state.
+func readyzHandler(cfg config.SyncConfig, syncer *sync.Syncer, store *storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		analytics := store.AnalyticsStatus(r.Context())
+		if syncer == nil || initialSyncReady(cfg, syncer.Progress()) {
+			if analytics == storage.AnalyticsOK {
+				w.Write([]byte("ok\n"))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":    "degraded",
+				"analytics": analytics,
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":       "syncing",
+			"initial_sync": syncer.Progress(),
+			"analytics":    analytics,
+		})
+	}
+}
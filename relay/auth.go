@@ -0,0 +1,103 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// Credentials holds the secret keys used to answer NIP-42 challenges from
+// upstream relays: one per relay where configured, the global sync identity otherwise.
+// A nil *Credentials never authenticates.
+type Credentials struct {
+	global   string
+	perRelay map[string]string // normalized relay URL -> secret key
+}
+
+// NewCredentials decodes the global key and per-relay keys (hex or nsec); any may be empty
+func NewCredentials(global string, perRelay map[string]string) (*Credentials, error) {
+	c := &Credentials{perRelay: make(map[string]string, len(perRelay))}
+
+	if global != "" {
+		key, err := DecodeSecretKey(global)
+		if err != nil {
+			return nil, err
+		}
+		c.global = key
+	}
+
+	for url, value := range perRelay {
+		key, err := DecodeSecretKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", url, err)
+		}
+		normalized, err := NormalizeRelayURL(url)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", url, err)
+		}
+		c.perRelay[normalized] = key
+	}
+
+	return c, nil
+}
+
+// DecodeSecretKey accepts a secret key as hex or nsec and returns it as hex
+func DecodeSecretKey(key string) (string, error) {
+	if strings.HasPrefix(key, "nsec") {
+		prefix, value, err := nip19.Decode(key)
+		if err != nil || prefix != "nsec" {
+			return "", fmt.Errorf("invalid nsec: %v", err)
+		}
+		key = value.(string)
+	}
+	if !nostr.IsValid32ByteHex(key) {
+		return "", fmt.Errorf("auth key must be a 32-byte hex secret key or nsec")
+	}
+	return key, nil
+}
+
+// KeyFor returns the secret key to authenticate to relayURL with, or "" for none
+func (c *Credentials) KeyFor(relayURL string) string {
+	if c == nil {
+		return ""
+	}
+	if normalized, err := NormalizeRelayURL(relayURL); err == nil {
+		if key, ok := c.perRelay[normalized]; ok {
+			return key
+		}
+	}
+	return c.global
+}
+
+// Resubscribe handles a CLOSED reason: when the relay wants auth and we hold a
+// key for it, it answers the relay's challenge, records the outcome and
+// subscribes again. It returns nil when the subscription can't be retried.
+func (c *Credentials) Resubscribe(ctx context.Context, store *storage.Storage, relay *nostr.Relay, reason string, filters nostr.Filters) *nostr.Subscription {
+	if !strings.HasPrefix(reason, "auth-required:") {
+		return nil
+	}
+	key := c.KeyFor(relay.URL)
+	if key == "" {
+		return nil
+	}
+
+	err := relay.Auth(ctx, func(evt *nostr.Event) error { return evt.Sign(key) })
+	if recordErr := store.RecordRelayAuth(ctx, relay.URL, err); recordErr != nil {
+		log.Printf("Failed to record auth outcome for %s: %v", relay.URL, recordErr)
+	}
+	if err != nil {
+		log.Printf("NIP-42 auth to %s failed: %v", relay.URL, err)
+		return nil
+	}
+
+	sub, err := relay.Subscribe(ctx, filters)
+	if err != nil {
+		return nil
+	}
+	return sub
+}
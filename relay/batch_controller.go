@@ -0,0 +1,105 @@
+package relay
+
+import (
+	"sync"
+	"time"
+)
+
+// The controller backs off when a batch's requests average more than
+// slowBatchLatency or more than congestedErrorRate of them fail
+const (
+	slowBatchLatency   = 2 * time.Second
+	congestedErrorRate = 0.2
+
+	batchSizeStep  = 5 // pubkeys added after a healthy batch
+	minBatchDelay  = 2 * time.Second
+	maxBatchDelay  = 2 * time.Minute
+	initBatchDelay = 10 * time.Second
+	batchDelayStep = 2 * time.Second // taken off the delay after a healthy batch
+)
+
+// BatchState is the adaptive batching state and what the last batch looked like
+type BatchState struct {
+	Size      int
+	Delay     time.Duration
+	Latency   time.Duration // mean request latency of the last batch
+	ErrorRate float64       // share of the last batch's requests that failed
+	Congested bool          // whether the last batch made the controller back off
+	Increases int64
+	Decreases int64
+}
+
+// BatchController sizes batches of upstream requests AIMD style: each healthy
+// batch grows the next one by a few pubkeys and shortens the pause before it,
+// while a slow or failing batch halves the size and doubles the pause. Upstreams
+// that struggle get asked less, and quiet hours are used to catch up.
+type BatchController struct {
+	mu      sync.Mutex
+	minSize int
+	maxSize int
+	state   BatchState
+}
+
+// NewBatchController starts at size, staying within [minSize, maxSize]. With
+// minSize == maxSize the size is fixed and only the delay adapts.
+func NewBatchController(size, minSize, maxSize int) *BatchController {
+	if minSize < 1 {
+		minSize = 1
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+	size = min(max(size, minSize), maxSize)
+	return &BatchController{
+		minSize: minSize,
+		maxSize: maxSize,
+		state:   BatchState{Size: size, Delay: initBatchDelay},
+	}
+}
+
+// Size is how many pubkeys the next batch should hold
+func (c *BatchController) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.Size
+}
+
+// Delay is how long to wait before the next batch
+func (c *BatchController) Delay() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.Delay
+}
+
+// Observe adjusts the size and delay after a batch that made requests, failures
+// of which failed, taking latency in total, and returns the new state
+func (c *BatchController) Observe(requests, failures int, latency time.Duration) BatchState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if requests == 0 {
+		return c.state
+	}
+
+	c.state.Latency = latency / time.Duration(requests)
+	c.state.ErrorRate = float64(failures) / float64(requests)
+	c.state.Congested = c.state.Latency > slowBatchLatency || c.state.ErrorRate > congestedErrorRate
+
+	if c.state.Congested {
+		c.state.Size = max(c.state.Size/2, c.minSize)
+		c.state.Delay = min(c.state.Delay*2, maxBatchDelay)
+		c.state.Decreases++
+	} else {
+		c.state.Size = min(c.state.Size+batchSizeStep, c.maxSize)
+		c.state.Delay = max(c.state.Delay-batchDelayStep, minBatchDelay)
+		c.state.Increases++
+	}
+	return c.state
+}
+
+// State returns the current state
+func (c *BatchController) State() BatchState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
@@ -0,0 +1,69 @@
+package relay
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// broadcastTimeout bounds connecting to one relay and publishing to it
+const broadcastTimeout = 15 * time.Second
+
+// PublishResult is one relay's answer to one event
+type PublishResult struct {
+	EventID string
+	Kind    int
+	OK      bool
+	Message string // the relay's OK message or why publishing failed
+}
+
+// BroadcastResult is what one relay made of a broadcast
+type BroadcastResult struct {
+	URL     string
+	Error   string // set when we couldn't connect
+	Results []PublishResult
+}
+
+// Broadcast publishes events to every relay in urls concurrently and returns
+// each relay's answers in the order of urls
+func Broadcast(ctx context.Context, urls []string, events []*nostr.Event) []BroadcastResult {
+	results := make([]BroadcastResult, len(urls))
+
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = publishTo(ctx, url, events)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+func publishTo(ctx context.Context, url string, events []*nostr.Event) BroadcastResult {
+	result := BroadcastResult{URL: url}
+
+	ctx, cancel := context.WithTimeout(ctx, broadcastTimeout)
+	defer cancel()
+
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer relay.Close()
+
+	for _, evt := range events {
+		published := PublishResult{EventID: evt.ID, Kind: evt.Kind, OK: true}
+		if err := relay.Publish(ctx, *evt); err != nil {
+			published.OK = false
+			published.Message = err.Error()
+		}
+		result.Results = append(result.Results, published)
+	}
+	return result
+}
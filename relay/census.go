@@ -0,0 +1,254 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	// censusRefreshAge is how old a relay's NIP-11 document gets before it is fetched again
+	censusRefreshAge = 7 * 24 * time.Hour
+	// censusBatchSize caps the relays fetched in one harvest, so a fresh install
+	// with tens of thousands of discovered relays spreads them over several runs
+	censusBatchSize = 5000
+	censusWorkers   = 16
+	censusTimeout   = 10 * time.Second
+	// maxRelayDocumentSize bounds how much of a NIP-11 response is read
+	maxRelayDocumentSize = 1 << 20
+	// maxDocumentRelays caps the relays taken from one NIP-11 document
+	maxDocumentRelays = 20
+)
+
+// CensusHarvester fetches the NIP-11 documents of discovered relays for the
+// relay software census
+type CensusHarvester struct {
+	storage *storage.Storage
+}
+
+func NewCensusHarvester(store *storage.Storage) *CensusHarvester {
+	return &CensusHarvester{storage: store}
+}
+
+// Harvest fetches the NIP-11 documents that are due and records what they say,
+// or why they couldn't be fetched
+func (c *CensusHarvester) Harvest(ctx context.Context) error {
+	urls, err := c.storage.GetRelayCensusTargets(ctx, time.Now().Add(-censusRefreshAge), censusBatchSize)
+	if err != nil {
+		return err
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+
+	log.Printf("Relay census: fetching NIP-11 documents of %d relays", len(urls))
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	answered := 0
+	recommended := make(map[string]bool)
+
+	for i := 0; i < censusWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for url := range queue {
+				info, relays := fetchRelayInfo(ctx, url)
+				if err := c.storage.SaveRelayInfo(ctx, info); err != nil {
+					log.Printf("Relay census: failed to save %s: %v", url, err)
+				}
+				if info.Error == "" {
+					mu.Lock()
+					answered++
+					for _, r := range relays {
+						recommended[r] = true
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, url := range urls {
+		select {
+		case queue <- url:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	log.Printf("Relay census: %d of %d relays answered", answered, len(urls))
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	found := make([]string, 0, len(recommended))
+	for url := range recommended {
+		found = append(found, url)
+	}
+	added, err := c.storage.AddDiscoveredRelays(ctx, found, storage.RelaySourceNIP11)
+	if err != nil {
+		return err
+	}
+	if added > 0 {
+		log.Printf("Relay census: discovered %d relays from NIP-11 documents", added)
+	}
+	return nil
+}
+
+// relayDocument is a NIP-11 document with the non-standard fields some relays
+// use to point at mirrors or alternatives of themselves
+type relayDocument struct {
+	nip11.RelayInformationDocument
+	Relays            relayURLs `json:"relays,omitempty"`
+	AlternativeRelays relayURLs `json:"alternative_relays,omitempty"`
+	RecommendedRelays relayURLs `json:"recommended_relays,omitempty"`
+}
+
+// relayURLs reads a list of URLs, and reads anything else as no URLs rather
+// than failing the whole document
+type relayURLs []string
+
+func (r *relayURLs) UnmarshalJSON(data []byte) error {
+	var urls []string
+	if json.Unmarshal(data, &urls) == nil {
+		*r = urls
+	}
+	return nil
+}
+
+// fetchRelayInfo fetches a relay's NIP-11 document and returns what it says
+// about the relay and the other relays it points to
+func fetchRelayInfo(ctx context.Context, url string) (storage.RelayInfo, []string) {
+	ctx, cancel := context.WithTimeout(ctx, censusTimeout)
+	defer cancel()
+
+	info := storage.RelayInfo{URL: url, FetchedAt: time.Now()}
+	doc, err := fetchRelayDocument(ctx, url)
+	if err != nil {
+		info.Error = err.Error()
+		return info, nil
+	}
+
+	info.Name = doc.Name
+	info.Software = NormalizeSoftware(doc.Software)
+	info.Version = strings.TrimPrefix(strings.TrimSpace(doc.Version), "v")
+	info.SupportedNIPs = supportedNIPs(doc.SupportedNIPs)
+	return info, documentRelays(url, doc)
+}
+
+// fetchRelayDocument is nip11.Fetch, keeping the fields relayDocument adds
+func fetchRelayDocument(ctx context.Context, url string) (*relayDocument, error) {
+	u := nostr.NormalizeURL(url)
+	if len(u) < 8 {
+		return nil, fmt.Errorf("invalid url %s", u)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http"+u[2:], nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var doc relayDocument
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxRelayDocumentSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+	return &doc, nil
+}
+
+// documentRelays returns the normalized relay URLs a NIP-11 document points
+// to other than the relay itself: payments_url and the relays listed in
+// relayDocument's extra fields, when they are websocket URLs
+func documentRelays(url string, doc *relayDocument) []string {
+	candidates := make([]string, 0, len(doc.Relays)+len(doc.AlternativeRelays)+len(doc.RecommendedRelays)+1)
+	candidates = append(candidates, doc.PaymentsURL)
+	candidates = append(candidates, doc.Relays...)
+	candidates = append(candidates, doc.AlternativeRelays...)
+	candidates = append(candidates, doc.RecommendedRelays...)
+
+	self, _ := NormalizeRelayURL(url)
+	var relays []string
+	seen := map[string]bool{self: true}
+	for _, candidate := range candidates {
+		if !strings.HasPrefix(candidate, "wss://") && !strings.HasPrefix(candidate, "ws://") {
+			continue
+		}
+		normalized, err := NormalizeRelayURL(candidate)
+		if err != nil || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		relays = append(relays, normalized)
+		if len(relays) == maxDocumentRelays {
+			break
+		}
+	}
+	return relays
+}
+
+// NormalizeSoftware reduces the software field of a NIP-11 document, often a
+// repository URL, to the implementation's name: "git+https://github.com/hoytech/strfry.git"
+// becomes "strfry"
+func NormalizeSoftware(software string) string {
+	s := strings.ToLower(strings.TrimSpace(software))
+	s = strings.TrimPrefix(s, "git+")
+	s = strings.TrimSuffix(s, "/")
+	s = strings.TrimSuffix(s, ".git")
+	if i := strings.LastIndex(s, "/"); i >= 0 && strings.Contains(s, "://") {
+		s = s[i+1:]
+	}
+	return s
+}
+
+// supportedNIPs reads supported_nips, which relays publish as numbers or, now
+// and then, as strings
+func supportedNIPs(raw []any) []int {
+	nips := make([]int, 0, len(raw))
+	seen := make(map[int]bool, len(raw))
+	for _, v := range raw {
+		var n int
+		switch v := v.(type) {
+		case float64:
+			n = int(v)
+		case int:
+			n = v
+		case string:
+			parsed, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				continue
+			}
+			n = parsed
+		default:
+			continue
+		}
+		if n < 0 || seen[n] {
+			continue
+		}
+		seen[n] = true
+		nips = append(nips, n)
+	}
+	return nips
+}
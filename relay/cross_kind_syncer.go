@@ -184,7 +184,7 @@ func (s *CrossKindSyncer) fetchFromRelay(ctx context.Context, relayURL string, p
 			if evt == nil {
 				continue
 			}
-			if err := s.storage.SaveEvent(ctx, evt); err != nil {
+			if err := s.storage.SaveUpstreamEvent(ctx, relayURL, evt); err != nil {
 				if err.Error() != "duplicate: event already exists" {
 					log.Printf("Cross-kind syncer: failed to save event: %v", err)
 				}
@@ -20,28 +20,58 @@ func NewDiscovery(storage *storage.Storage) *Discovery {
 	}
 }
 
-func (d *Discovery) ExtractRelaysFromEvent(ctx context.Context, evt *nostr.Event) {
-	if evt.Kind != 10002 {
-		return
+// discoveryKinds are the kinds whose relay URLs discovery reads
+var discoveryKinds = []int{10002, 10006, 10007}
+
+// IsDiscoveryKind reports whether discovery reads relay URLs from events of kind
+func IsDiscoveryKind(kind int) bool {
+	return kind == 10002 || kind == 10006 || kind == 10007
+}
+
+// eventRelays returns the normalized relay URLs an event lists and the
+// discovery source they count as: relay lists use "r" tags, NIP-51 search and
+// blocked relay lists "relay" tags. Blocked relays come with an empty source.
+func eventRelays(evt *nostr.Event) ([]string, string) {
+	tagName, source := "relay", storage.RelaySourceSearchList
+	switch evt.Kind {
+	case 10002:
+		tagName, source = "r", storage.RelaySourceRelayList
+	case 10006:
+		source = ""
+	case 10007:
+	default:
+		return nil, ""
 	}
 
+	var urls []string
+	seen := make(map[string]bool)
 	for _, tag := range evt.Tags {
-		if len(tag) < 2 {
+		if len(tag) < 2 || tag[0] != tagName {
 			continue
 		}
-
-		if tag[0] != "r" {
+		normalized, err := NormalizeRelayURL(tag[1])
+		if err != nil || seen[normalized] {
 			continue
 		}
+		seen[normalized] = true
+		urls = append(urls, normalized)
+	}
+	return urls, source
+}
 
-		rawURL := tag[1]
-
-		normalized, err := NormalizeRelayURL(rawURL)
-		if err != nil {
-			continue
+// ExtractRelaysFromEvent adds the relays of a relay list or search relay list
+// to the discovered relays, and records the relays a blocked relay list blocks
+func (d *Discovery) ExtractRelaysFromEvent(ctx context.Context, evt *nostr.Event) {
+	urls, source := eventRelays(evt)
+	if evt.Kind == 10006 {
+		if err := d.storage.SetRelayBlocks(ctx, evt.PubKey, urls, int64(evt.CreatedAt)); err != nil {
+			log.Printf("Failed to record blocked relays of %s: %v", evt.PubKey, err)
 		}
+		return
+	}
 
-		if err := d.storage.AddDiscoveredRelay(ctx, normalized); err != nil {
+	for _, normalized := range urls {
+		if err := d.storage.AddDiscoveredRelay(ctx, normalized, source); err != nil {
 			log.Printf("Failed to add discovered relay %s: %v", normalized, err)
 			continue
 		}
@@ -57,32 +87,67 @@ func (d *Discovery) NewRelaysChan() <-chan string {
 	return d.newRelays
 }
 
-// BackfillDiscoveredRelays extracts relay URLs from all existing kind 10002 events
-// and adds them to the discovered_relays table (normalized)
-func (d *Discovery) BackfillDiscoveredRelays(ctx context.Context) error {
-	log.Println("Backfilling discovered relays from existing events...")
+// BackfillProgress is reported while BackfillDiscoveredRelays scans stored events
+type BackfillProgress struct {
+	EventsScanned int
+	RelaysFound   int
+	RelaysAdded   int64
+	Done          bool
+}
 
-	rawURLs, err := d.storage.GetRawRelayURLsFromEvents(ctx)
+// backfillReportEvery is how many scanned events pass between progress reports
+const backfillReportEvery = 10000
+
+// BackfillDiscoveredRelays extracts relay URLs from every stored relay list and
+// search relay list, including ones imported or synced before discovery
+// existed, adds the normalized URLs to discovered_relays and records stored
+// blocked relay lists. progress may be nil.
+func (d *Discovery) BackfillDiscoveredRelays(ctx context.Context, progress func(BackfillProgress)) (BackfillProgress, error) {
+	var p BackfillProgress
+	found := make(map[string]map[string]bool)
+	total := make(map[string]bool)
+
+	err := d.storage.ScanEvents(ctx, nostr.Filter{Kinds: discoveryKinds}, func(evt *nostr.Event) {
+		p.EventsScanned++
+		urls, source := eventRelays(evt)
+		if evt.Kind == 10006 {
+			if err := d.storage.SetRelayBlocks(ctx, evt.PubKey, urls, int64(evt.CreatedAt)); err != nil {
+				log.Printf("Failed to record blocked relays of %s: %v", evt.PubKey, err)
+			}
+		} else {
+			if found[source] == nil {
+				found[source] = make(map[string]bool)
+			}
+			for _, url := range urls {
+				found[source][url] = true
+				total[url] = true
+			}
+		}
+		p.RelaysFound = len(total)
+		if progress != nil && p.EventsScanned%backfillReportEvery == 0 {
+			progress(p)
+		}
+	})
 	if err != nil {
-		return err
+		return p, err
 	}
 
-	log.Printf("Found %d distinct raw relay URLs to process", len(rawURLs))
-
-	added := 0
-	for _, rawURL := range rawURLs {
-		normalized, err := NormalizeRelayURL(rawURL)
-		if err != nil {
-			continue
+	// Relay lists first, so a relay in both is added under the preferred source
+	for _, source := range []string{storage.RelaySourceRelayList, storage.RelaySourceSearchList} {
+		urls := make([]string, 0, len(found[source]))
+		for url := range found[source] {
+			urls = append(urls, url)
 		}
-
-		if err := d.storage.AddDiscoveredRelay(ctx, normalized); err != nil {
-			log.Printf("Failed to add discovered relay %s: %v", normalized, err)
-			continue
+		added, err := d.storage.AddDiscoveredRelays(ctx, urls, source)
+		if err != nil {
+			return p, err
 		}
-		added++
+		p.RelaysAdded += added
 	}
 
-	log.Printf("Backfill complete: added %d normalized relay URLs", added)
-	return nil
+	p.Done = true
+	if progress != nil {
+		progress(p)
+	}
+	return p, nil
 }
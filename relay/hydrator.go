@@ -3,6 +3,8 @@ package relay
 import (
 	"context"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -16,6 +18,20 @@ type ProfileHydrator struct {
 	retryAfterHours int
 	batchSize       int
 	stopChan        chan struct{}
+
+	// Sizes each batch and the pause before the next one from how upstreams coped
+	// with the last; a run keeps taking batches for up to runBudget
+	batches   *BatchController
+	runBudget time.Duration
+
+	// Pubkeys with deadAfterRounds consecutive empty rounds are only retried after deadRetryAfter
+	deadAfterRounds int
+	deadRetryAfter  time.Duration
+
+	// Keys used to answer NIP-42 challenges from relays that require auth for reads
+	credentials *Credentials
+	// Shared with trusted sync so both don't ask a relay for the same pubkey at once
+	inflight *InFlight
 }
 
 func NewProfileHydrator(
@@ -32,7 +48,44 @@ func NewProfileHydrator(
 		retryAfterHours: retryAfterHours,
 		batchSize:       batchSize,
 		stopChan:        make(chan struct{}),
+		batches:         NewBatchController(batchSize, batchSize, batchSize),
+		deadAfterRounds: 3,
+		deadRetryAfter:  30 * 24 * time.Hour,
+	}
+}
+
+// SetDeadAccountPolicy configures when a pubkey that no relay knows about is
+// considered dead, and how long to wait before trying it again
+func (h *ProfileHydrator) SetDeadAccountPolicy(afterRounds int, retryAfter time.Duration) {
+	if afterRounds > 0 {
+		h.deadAfterRounds = afterRounds
 	}
+	if retryAfter > 0 {
+		h.deadRetryAfter = retryAfter
+	}
+}
+
+// SetAdaptiveBatching lets batches grow and shrink between minSize and maxSize
+// as upstreams respond, and lets a run keep fetching batches for up to
+// runBudget. Without it a run fetches a single batch of the configured size.
+func (h *ProfileHydrator) SetAdaptiveBatching(minSize, maxSize int, runBudget time.Duration) {
+	h.batches = NewBatchController(h.batchSize, minSize, maxSize)
+	h.runBudget = runBudget
+}
+
+// BatchState reports the adaptive batch size and delay
+func (h *ProfileHydrator) BatchState() BatchState {
+	return h.batches.State()
+}
+
+// SetCredentials sets the keys used to authenticate to relays that require NIP-42
+func (h *ProfileHydrator) SetCredentials(credentials *Credentials) {
+	h.credentials = credentials
+}
+
+// SetInFlight shares a registry of in-flight fetches with other fetchers
+func (h *ProfileHydrator) SetInFlight(inflight *InFlight) {
+	h.inflight = inflight
 }
 
 func (h *ProfileHydrator) Start(ctx context.Context, intervalMinutes int) {
@@ -79,12 +132,44 @@ func (h *ProfileHydrator) hydrate(ctx context.Context) {
 
 	log.Printf("Profile hydrator: found %d pubkeys needing hydration", len(pubkeysToFetch))
 
-	// Limit to batch size
-	if len(pubkeysToFetch) > h.batchSize {
-		pubkeysToFetch = pubkeysToFetch[:h.batchSize]
+	start := time.Now()
+	for len(pubkeysToFetch) > 0 {
+		batch := pubkeysToFetch[:min(h.batches.Size(), len(pubkeysToFetch))]
+		pubkeysToFetch = pubkeysToFetch[len(batch):]
+
+		stats := h.fetchProfiles(ctx, batch)
+		state := h.batches.Observe(stats.requests, stats.failures, stats.latency)
+		if stats.requests > 0 {
+			log.Printf("Profile hydrator: batch of %d averaged %v per request with %.0f%% failing; next batch %d after %v",
+				len(batch), state.Latency.Round(time.Millisecond), state.ErrorRate*100, state.Size, state.Delay)
+		}
+
+		if len(pubkeysToFetch) == 0 || ctx.Err() != nil || time.Since(start)+state.Delay > h.runBudget {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.stopChan:
+			return
+		case <-time.After(state.Delay):
+		}
 	}
+}
 
-	h.fetchProfiles(ctx, pubkeysToFetch)
+// batchStats is how upstreams answered one batch's requests
+type batchStats struct {
+	requests int
+	failures int // connections refused, subscriptions that failed, timed out or were closed
+	latency  time.Duration
+}
+
+func (b *batchStats) record(took time.Duration, failed bool) {
+	b.requests++
+	b.latency += took
+	if failed {
+		b.failures++
+	}
 }
 
 type PubkeyNeed struct {
@@ -104,10 +189,16 @@ func (h *ProfileHydrator) findPubkeysNeedingHydration(ctx context.Context) []Pub
 
 	retryThreshold := time.Now().Add(-time.Duration(h.retryAfterHours) * time.Hour).Unix()
 
+	// Dead pubkeys are left alone until their long backoff expires
+	dead, err := h.storage.GetDeadPubkeys(ctx, time.Now().Add(-h.deadRetryAfter))
+	if err != nil {
+		log.Printf("Profile hydrator: failed to get dead pubkeys: %v", err)
+	}
+
 	// Collect all pubkeys that meet follower threshold
 	var candidatePubkeys []string
 	for pubkey, count := range followerCounts {
-		if count < h.minFollowers {
+		if count < h.minFollowers || dead[pubkey] {
 			continue
 		}
 
@@ -127,8 +218,8 @@ func (h *ProfileHydrator) findPubkeysNeedingHydration(ctx context.Context) []Pub
 		return nil
 	}
 
-	// Batch check what events we have for all candidates (single query instead of N*3 queries)
-	eventKinds, err := h.storage.CheckPubkeyEventKinds(ctx, candidatePubkeys)
+	// Batch check what events we have for all candidates (one query per chunk instead of N*3 queries)
+	eventKinds, err := h.storage.CheckPubkeyEventKinds(ctx, candidatePubkeys, []int{0, 3, 10002})
 	if err != nil {
 		log.Printf("Profile hydrator: failed to check event kinds: %v", err)
 		return nil
@@ -140,9 +231,9 @@ func (h *ProfileHydrator) findPubkeysNeedingHydration(ctx context.Context) []Pub
 		kinds := eventKinds[pubkey]
 		need := PubkeyNeed{
 			Pubkey:        pubkey,
-			NeedKind0:     !kinds.HasKind0,
-			NeedKind3:     !kinds.HasKind3,
-			NeedKind10002: !kinds.HasKind10002,
+			NeedKind0:     !kinds.Has(0),
+			NeedKind3:     !kinds.Has(3),
+			NeedKind10002: !kinds.Has(10002),
 		}
 
 		// If we need any of them, add to list
@@ -154,25 +245,97 @@ func (h *ProfileHydrator) findPubkeysNeedingHydration(ctx context.Context) []Pub
 	return needs
 }
 
-func (h *ProfileHydrator) fetchProfiles(ctx context.Context, needs []PubkeyNeed) {
+func (h *ProfileHydrator) fetchProfiles(ctx context.Context, needs []PubkeyNeed) batchStats {
+	var stats batchStats
 	if len(h.relays) == 0 {
 		log.Println("Profile hydrator: no relays configured for fetching")
-		return
+		return stats
 	}
 
-	for _, relayURL := range h.relays {
+	found := make(map[string]bool)
+	connected := 0
+	for _, relayURL := range h.rankRelays(ctx) {
+		connectStart := time.Now()
 		relay, err := nostr.RelayConnect(ctx, relayURL)
 		if err != nil {
+			stats.record(time.Since(connectStart), true)
 			log.Printf("Profile hydrator: failed to connect to %s: %v", relayURL, err)
 			continue
 		}
 
-		h.fetchFromRelay(ctx, relay, needs)
+		connected++
+		h.fetchFromRelay(ctx, relay, needs, found, &stats)
 		relay.Close()
 	}
+
+	// A round where we couldn't reach any relay says nothing about the pubkeys
+	if connected == 0 || ctx.Err() != nil {
+		return stats
+	}
+
+	h.recordRound(ctx, needs, found)
+	return stats
+}
+
+// Relays scoring below minHydrationIntegrity once they've delivered
+// integritySampleSize events are left out of hydration
+const (
+	minHydrationIntegrity = 50
+	integritySampleSize   = 100
+)
+
+// rankRelays orders the hydration relays by integrity score, best first, and
+// drops those that have proven unreliable
+func (h *ProfileHydrator) rankRelays(ctx context.Context) []string {
+	integrity, err := h.storage.GetRelayIntegrity(ctx)
+	if err != nil {
+		log.Printf("Profile hydrator: failed to load relay integrity: %v", err)
+		return h.relays
+	}
+
+	ranked := make([]string, 0, len(h.relays))
+	for _, url := range h.relays {
+		r, ok := integrity[url]
+		if ok && r.Delivered+r.InvalidSig >= integritySampleSize && r.Score() < minHydrationIntegrity {
+			log.Printf("Profile hydrator: skipping %s (integrity %.0f)", url, r.Score())
+			continue
+		}
+		ranked = append(ranked, url)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return integrityScore(integrity, ranked[i]) > integrityScore(integrity, ranked[j])
+	})
+	return ranked
+}
+
+func integrityScore(integrity map[string]storage.RelayIntegrity, url string) float64 {
+	if r, ok := integrity[url]; ok {
+		return r.Score()
+	}
+	return 100
+}
+
+// recordRound classifies each pubkey by whether any relay returned something for it this round
+func (h *ProfileHydrator) recordRound(ctx context.Context, needs []PubkeyNeed, found map[string]bool) {
+	empty := 0
+	for _, need := range needs {
+		if !found[need.Pubkey] {
+			empty++
+		}
+		if err := h.storage.RecordHydrationRound(ctx, need.Pubkey, found[need.Pubkey], h.deadAfterRounds); err != nil {
+			log.Printf("Profile hydrator: failed to record outcome for %s: %v", need.Pubkey[:16], err)
+		}
+	}
+
+	if empty > 0 {
+		log.Printf("Profile hydrator: %d/%d pubkeys returned nothing from any relay", empty, len(needs))
+	}
 }
 
-func (h *ProfileHydrator) fetchFromRelay(ctx context.Context, relay *nostr.Relay, needs []PubkeyNeed) {
+func (h *ProfileHydrator) fetchFromRelay(ctx context.Context, relay *nostr.Relay, needs []PubkeyNeed, found map[string]bool, stats *batchStats) {
+	// Auth is per connection, so one attempt covers every pubkey we ask this relay for
+	authTried := false
 	for _, need := range needs {
 		var kinds []int
 		if need.NeedKind0 {
@@ -189,53 +352,18 @@ func (h *ProfileHydrator) fetchFromRelay(ctx context.Context, relay *nostr.Relay
 			continue
 		}
 
-		filter := nostr.Filter{
-			Kinds:   kinds,
-			Authors: []string{need.Pubkey},
-		}
-
-		sub, err := relay.Subscribe(ctx, []nostr.Filter{filter})
-		if err != nil {
-			log.Printf("Profile hydrator: failed to subscribe for %s: %v", need.Pubkey[:16], err)
-			continue
-		}
-
-		timeout := time.After(5 * time.Second)
-		fetchedK0, fetchedK3, fetchedK10002 := false, false, false
-
-	eventLoop:
-		for {
-			select {
-			case <-ctx.Done():
-				sub.Unsub()
-				return
-			case <-timeout:
-				break eventLoop
-			case evt := <-sub.Events:
-				if evt == nil {
-					continue
-				}
-
-				if err := h.storage.SaveEvent(ctx, evt); err != nil {
-					if err.Error() != "duplicate: event already exists" {
-						log.Printf("Profile hydrator: failed to save event: %v", err)
-					}
-				}
-
-				switch evt.Kind {
-				case 0:
-					fetchedK0 = true
-				case 3:
-					fetchedK3 = true
-				case 10002:
-					fetchedK10002 = true
-				}
-			case <-sub.EndOfStoredEvents:
-				break eventLoop
+		stop := false
+		fetched := h.inflight.Fetch(ctx, relay.URL, need.Pubkey, kinds, func(kinds []int) map[int]bool {
+			got, ok := h.fetchKinds(ctx, relay, need.Pubkey, kinds, &authTried, stats)
+			if !ok {
+				stop = true
 			}
+			return got
+		})
+		if stop || ctx.Err() != nil {
+			return
 		}
-
-		sub.Unsub()
+		fetchedK0, fetchedK3, fetchedK10002 := fetched[0], fetched[3], fetched[10002]
 
 		// Record what we fetched (or that we tried)
 		if err := h.storage.RecordProfileFetchAttempt(ctx, need.Pubkey, fetchedK0, fetchedK3, fetchedK10002); err != nil {
@@ -243,8 +371,67 @@ func (h *ProfileHydrator) fetchFromRelay(ctx context.Context, relay *nostr.Relay
 		}
 
 		if fetchedK0 || fetchedK3 || fetchedK10002 {
+			found[need.Pubkey] = true
 			log.Printf("Profile hydrator: fetched data for %s (k0=%t, k3=%t, k10002=%t)",
 				need.Pubkey[:16], fetchedK0, fetchedK3, fetchedK10002)
 		}
 	}
 }
+
+// fetchKinds asks relay for kinds of pubkey and reports the kinds it returned
+// events for. ok is false when the context ended or the relay wants auth we
+// can't give, in which case it won't serve any of the remaining pubkeys either.
+// The request's latency and whether it failed are recorded in stats.
+func (h *ProfileHydrator) fetchKinds(ctx context.Context, relay *nostr.Relay, pubkey string, kinds []int, authTried *bool, stats *batchStats) (fetched map[int]bool, ok bool) {
+	fetched = make(map[int]bool)
+	filter := nostr.Filter{
+		Kinds:   kinds,
+		Authors: []string{pubkey},
+	}
+
+	start := time.Now()
+	sub, err := relay.Subscribe(ctx, []nostr.Filter{filter})
+	if err != nil {
+		stats.record(time.Since(start), true)
+		log.Printf("Profile hydrator: failed to subscribe for %s: %v", pubkey[:16], err)
+		return fetched, true
+	}
+	defer func() { sub.Unsub() }()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return fetched, false
+		case <-timeout:
+			stats.record(time.Since(start), true)
+			return fetched, true
+		case evt := <-sub.Events:
+			if evt == nil {
+				continue
+			}
+
+			if err := h.storage.SaveUpstreamEvent(ctx, relay.URL, evt); err != nil {
+				if err.Error() != "duplicate: event already exists" {
+					log.Printf("Profile hydrator: failed to save event: %v", err)
+				}
+			}
+			fetched[evt.Kind] = true
+		case reason := <-sub.ClosedReason:
+			if !*authTried && strings.HasPrefix(reason, "auth-required:") {
+				*authTried = true
+				if retry := h.credentials.Resubscribe(ctx, h.storage, relay, reason, nostr.Filters{filter}); retry != nil {
+					sub.Unsub()
+					sub = retry
+					continue
+				}
+			}
+			authRequired := strings.HasPrefix(reason, "auth-required:")
+			stats.record(time.Since(start), !authRequired)
+			return fetched, !authRequired
+		case <-sub.EndOfStoredEvents:
+			stats.record(time.Since(start), false)
+			return fetched, true
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package relay
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// InFlight coalesces upstream fetches shared by the profile hydrator and trusted
+// sync. Both can ask the same relay for the same pubkey at the same time; a
+// kind already being fetched from a relay is not requested again, the second
+// fetcher waits for the first and takes its result instead.
+type InFlight struct {
+	mu    sync.Mutex
+	calls map[inflightKey]*inflightCall
+
+	fetched   atomic.Int64
+	coalesced atomic.Int64
+}
+
+type inflightKey struct {
+	relay  string
+	pubkey string
+	kind   int
+}
+
+type inflightCall struct {
+	done  chan struct{}
+	found bool
+}
+
+// InFlightStats counts pubkey kinds fetched from relays and those answered by
+// another fetcher's request instead
+type InFlightStats struct {
+	Fetched   int64
+	Coalesced int64
+}
+
+func NewInFlight() *InFlight {
+	return &InFlight{calls: make(map[inflightKey]*inflightCall)}
+}
+
+// Fetch gets kinds of pubkey from relayURL through fetch, which requests the
+// kinds it is given and reports those it received events for. Kinds someone
+// else is fetching from the relay are waited for; if their fetch came back
+// empty they are requested again, since the other filter may have been
+// narrower. A nil InFlight calls fetch directly.
+func (f *InFlight) Fetch(ctx context.Context, relayURL, pubkey string, kinds []int, fetch func(kinds []int) map[int]bool) map[int]bool {
+	if f == nil {
+		return fetch(kinds)
+	}
+
+	relayURL = nostr.NormalizeURL(relayURL)
+	var own []int
+	owned := make(map[int]*inflightCall)
+	joined := make(map[int]*inflightCall)
+
+	f.mu.Lock()
+	for _, kind := range kinds {
+		key := inflightKey{relay: relayURL, pubkey: pubkey, kind: kind}
+		if call, ok := f.calls[key]; ok {
+			joined[kind] = call
+			continue
+		}
+		call := &inflightCall{done: make(chan struct{})}
+		f.calls[key] = call
+		owned[kind] = call
+		own = append(own, kind)
+	}
+	f.mu.Unlock()
+
+	found := make(map[int]bool)
+	if len(own) > 0 {
+		f.fetched.Add(int64(len(own)))
+		result := fetch(own)
+
+		f.mu.Lock()
+		for kind, call := range owned {
+			call.found = result[kind]
+			found[kind] = result[kind]
+			delete(f.calls, inflightKey{relay: relayURL, pubkey: pubkey, kind: kind})
+			close(call.done)
+		}
+		f.mu.Unlock()
+	}
+
+	var retry []int
+	for kind, call := range joined {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return found
+		}
+		if call.found {
+			found[kind] = true
+			f.coalesced.Add(1)
+		} else {
+			retry = append(retry, kind)
+		}
+	}
+	if len(retry) > 0 {
+		f.fetched.Add(int64(len(retry)))
+		for kind, ok := range fetch(retry) {
+			found[kind] = found[kind] || ok
+		}
+	}
+
+	return found
+}
+
+func (f *InFlight) GetStats() InFlightStats {
+	return InFlightStats{Fetched: f.fetched.Load(), Coalesced: f.coalesced.Load()}
+}
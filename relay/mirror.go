@@ -0,0 +1,192 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// mirrorWorkers is how many frames can be in flight to the staging relay at
+	// once; beyond that they queue, and beyond the queue they are dropped
+	mirrorWorkers = 32
+	// mirrorEOSETimeout is how long a mirrored REQ stays open waiting for EOSE
+	mirrorEOSETimeout = 15 * time.Second
+	// mirrorReconnectDelay keeps a staging relay that is down from being dialed
+	// for every frame
+	mirrorReconnectDelay = 30 * time.Second
+)
+
+// errMirrorDown fails frames while staging is waiting to be dialed again
+var errMirrorDown = errors.New("staging relay unreachable")
+
+// Mirror replays a sample of client REQs and EVENTs against a staging relay, so
+// a change can be measured under the shape of production traffic. Clients are
+// never held up by it: frames are queued and dropped when staging falls behind
+// or is unreachable, and staging's responses are discarded.
+type Mirror struct {
+	url           string
+	samplePercent float64
+	frames        chan mirrorFrame
+
+	mu         sync.Mutex
+	conn       *nostr.Relay
+	lastDialed time.Time
+
+	reqs    atomic.Int64
+	events  atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+}
+
+// mirrorFrame is a REQ filter or an EVENT to replay
+type mirrorFrame struct {
+	filter *nostr.Filter
+	event  *nostr.Event
+}
+
+// MirrorStats counts frames sent to the staging relay and those that never got there
+type MirrorStats struct {
+	REQs    int64
+	Events  int64
+	Dropped int64 // the queue was full
+	Failed  int64 // staging was unreachable or the write failed
+}
+
+func NewMirror(url string, samplePercent float64, queueSize int) *Mirror {
+	return &Mirror{
+		url:           url,
+		samplePercent: samplePercent,
+		frames:        make(chan mirrorFrame, queueSize),
+	}
+}
+
+func (m *Mirror) Start(ctx context.Context) {
+	log.Printf("Mirror: replaying %g%% of REQs and EVENTs to %s", m.samplePercent, m.url)
+	for i := 0; i < mirrorWorkers; i++ {
+		go m.worker(ctx)
+	}
+}
+
+// Sampled reports whether the REQ or EVENT identified by key is mirrored. A key
+// always gets the same answer, so every filter of a REQ is mirrored or none is.
+func (m *Mirror) Sampled(key string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return float64(h.Sum32()%10000) < m.samplePercent*100
+}
+
+// MirrorFilter queues filter to be sent to staging as a REQ of its own
+func (m *Mirror) MirrorFilter(filter nostr.Filter) {
+	m.enqueue(mirrorFrame{filter: &filter})
+}
+
+// MirrorEvent queues evt to be published to staging
+func (m *Mirror) MirrorEvent(evt *nostr.Event) {
+	m.enqueue(mirrorFrame{event: evt})
+}
+
+func (m *Mirror) enqueue(f mirrorFrame) {
+	select {
+	case m.frames <- f:
+	default:
+		m.dropped.Add(1)
+	}
+}
+
+func (m *Mirror) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case f := <-m.frames:
+			if err := m.send(ctx, f); err != nil {
+				m.failed.Add(1)
+			}
+		}
+	}
+}
+
+func (m *Mirror) send(ctx context.Context, f mirrorFrame) error {
+	conn, err := m.connection(ctx)
+	if err != nil {
+		return err
+	}
+
+	if f.event != nil {
+		// Whether staging accepts the event doesn't matter, only that it handled it
+		msg, err := nostr.EventEnvelope{Event: *f.event}.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		if err := <-conn.Write(msg); err != nil {
+			return err
+		}
+		m.events.Add(1)
+		return nil
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, mirrorEOSETimeout)
+	defer cancel()
+
+	sub, err := conn.Subscribe(reqCtx, nostr.Filters{*f.filter})
+	if err != nil {
+		return err
+	}
+	defer sub.Unsub()
+	m.reqs.Add(1)
+
+	// Stay subscribed until staging has answered, so it does the same work a
+	// client's REQ would cost, then close like most clients do after EOSE
+	for {
+		select {
+		case <-sub.Events:
+		case <-sub.EndOfStoredEvents:
+			return nil
+		case <-sub.ClosedReason:
+			return nil
+		case <-reqCtx.Done():
+			return nil
+		}
+	}
+}
+
+// connection returns the connection to staging, dialing it again if it dropped
+func (m *Mirror) connection(ctx context.Context) (*nostr.Relay, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn != nil && m.conn.IsConnected() {
+		return m.conn, nil
+	}
+	if time.Since(m.lastDialed) < mirrorReconnectDelay {
+		return nil, errMirrorDown
+	}
+	m.lastDialed = time.Now()
+
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	conn, err := nostr.RelayConnect(dialCtx, m.url, nostr.WithNoticeHandler(func(string) {}))
+	if err != nil {
+		log.Printf("Mirror: failed to connect to %s: %v", m.url, err)
+		return nil, err
+	}
+	m.conn = conn
+	return conn, nil
+}
+
+func (m *Mirror) GetStats() MirrorStats {
+	return MirrorStats{
+		REQs:    m.reqs.Load(),
+		Events:  m.events.Load(),
+		Dropped: m.dropped.Load(),
+		Failed:  m.failed.Load(),
+	}
+}
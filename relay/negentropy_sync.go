@@ -0,0 +1,293 @@
+package relay
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip77"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy/storage/vector"
+	"github.com/pablof7z/purplepag.es/config"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	// negentropyFrameLimit caps the size of the negentropy messages we send
+	negentropyFrameLimit = 1024 * 1024
+	// negentropyBatchSize is how many events are fetched or looked up per request
+	negentropyBatchSize = 50
+	// negentropyTimeout bounds one kind's reconciliation and transfer
+	negentropyTimeout = 15 * time.Minute
+)
+
+// NegentropySyncer reconciles our events with a peer relay over NIP-77, one
+// kind at a time, pulling the events only the peer holds, pushing the ones
+// only we hold, or both. Each kind's result is recorded for /stats/trusted-sync.
+type NegentropySyncer struct {
+	storage *storage.Storage
+	peer    config.NegentropyPeerConfig
+}
+
+func NewNegentropySyncer(store *storage.Storage, peer config.NegentropyPeerConfig) *NegentropySyncer {
+	return &NegentropySyncer{storage: store, peer: peer}
+}
+
+// Sync reconciles every kind of the peer in turn. It fails when any kind did.
+func (s *NegentropySyncer) Sync(ctx context.Context) error {
+	failed := 0
+	for _, kind := range s.peer.Kinds {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		run := storage.NegentropySyncRun{RelayURL: s.peer.URL, Kind: kind, Direction: s.peer.Direction, StartedAt: time.Now()}
+		err := s.reconcile(ctx, kind, &run)
+		run.Duration = time.Since(run.StartedAt)
+		if err != nil {
+			run.Error = err.Error()
+			failed++
+			log.Printf("Negentropy sync: %s kind %d failed after %d rounds: %v", s.peer.URL, kind, run.Rounds, err)
+		} else {
+			log.Printf("Negentropy sync: %s kind %d: %d rounds, %d ranges, %d only here, %d only there, sent %d, received %d in %v",
+				s.peer.URL, kind, run.Rounds, run.Ranges, run.Have, run.Need, run.Sent, run.Received, run.Duration.Round(time.Millisecond))
+		}
+
+		if err := s.storage.RecordNegentropySyncRun(ctx, run); err != nil {
+			log.Printf("Negentropy sync: failed to record %s kind %d: %v", s.peer.URL, kind, err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d kinds failed to sync with %s", failed, len(s.peer.Kinds), s.peer.URL)
+	}
+	return nil
+}
+
+func (s *NegentropySyncer) reconcile(ctx context.Context, kind int, run *storage.NegentropySyncRun) error {
+	ctx, cancel := context.WithTimeout(ctx, negentropyTimeout)
+	defer cancel()
+
+	filter := nostr.Filter{Kinds: []int{kind}}
+	vec := vector.New()
+	err := s.storage.ScanEvents(ctx, filter, func(evt *nostr.Event) {
+		vec.Insert(evt.CreatedAt, evt.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list our events: %w", err)
+	}
+	vec.Seal()
+
+	neg := negentropy.New(vec, negentropyFrameLimit)
+	failed := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case failed <- err:
+		default:
+		}
+	}
+
+	// Messages are handled one at a time on the connection's read loop, which
+	// can still be running when a failed reconciliation returns
+	var mu sync.Mutex
+	var rounds, ranges int64
+	defer func() {
+		mu.Lock()
+		run.Rounds, run.Ranges = rounds, ranges
+		mu.Unlock()
+	}()
+
+	var conn *nostr.Relay
+	conn, err = nostr.RelayConnect(ctx, s.peer.URL, nostr.WithCustomHandler(func(data string) {
+		switch env := nip77.ParseNegMessage(data).(type) {
+		case *nip77.ErrorEnvelope:
+			fail(fmt.Errorf("peer refused negentropy: %s", env.Reason))
+		case *nip77.MessageEnvelope:
+			mu.Lock()
+			defer mu.Unlock()
+			rounds++
+			ranges += countNegentropyRanges(env.Message)
+			next, err := neg.Reconcile(env.Message)
+			if err != nil {
+				fail(fmt.Errorf("failed to reconcile: %w", err))
+				return
+			}
+			if next != "" {
+				ranges += countNegentropyRanges(next)
+				msg, _ := nip77.MessageEnvelope{SubscriptionID: "neg", Message: next}.MarshalJSON()
+				conn.Write(msg)
+			}
+		}
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	// The IDs each side lacks come out while reconciling and the channels
+	// close once it's done
+	var have, need []string
+	var wg sync.WaitGroup
+	collect := func(ch chan string, ids *[]string) {
+		defer wg.Done()
+		for {
+			select {
+			case id, ok := <-ch:
+				if !ok {
+					return
+				}
+				*ids = append(*ids, id)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go collect(neg.Haves, &have)
+	go collect(neg.HaveNots, &need)
+	reconciled := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(reconciled)
+	}()
+
+	first := neg.Start()
+	mu.Lock()
+	ranges += countNegentropyRanges(first)
+	mu.Unlock()
+	open, _ := nip77.OpenEnvelope{SubscriptionID: "neg", Filter: filter, Message: first}.MarshalJSON()
+	if err := <-conn.Write(open); err != nil {
+		return fmt.Errorf("failed to open negentropy: %w", err)
+	}
+
+	select {
+	case <-reconciled:
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	case err := <-failed:
+		return err
+	case <-conn.Context().Done():
+		return fmt.Errorf("peer closed the connection while reconciling")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	closeMsg, _ := nip77.CloseEnvelope{SubscriptionID: "neg"}.MarshalJSON()
+	conn.Write(closeMsg)
+
+	run.Have, run.Need = int64(len(have)), int64(len(need))
+	if s.peer.Direction != config.NegentropyUp {
+		if err := s.pull(ctx, conn, kind, need, run); err != nil {
+			return err
+		}
+	}
+	if s.peer.Direction != config.NegentropyDown {
+		if err := s.push(ctx, conn, have, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pull fetches the events only the peer holds and saves the new ones
+func (s *NegentropySyncer) pull(ctx context.Context, conn *nostr.Relay, kind int, ids []string, run *storage.NegentropySyncRun) error {
+	for start := 0; start < len(ids); start += negentropyBatchSize {
+		batch := ids[start:min(start+negentropyBatchSize, len(ids))]
+		events, err := conn.QuerySync(ctx, nostr.Filter{IDs: batch})
+		if err != nil {
+			return fmt.Errorf("failed to fetch events: %w", err)
+		}
+		for _, evt := range events {
+			if evt.Kind != kind {
+				continue
+			}
+			if err := s.storage.SaveUpstreamEvent(ctx, s.peer.URL, evt); err == nil {
+				run.Received++
+			}
+		}
+	}
+	return nil
+}
+
+// push publishes the events only we hold, counting the ones the peer accepts
+func (s *NegentropySyncer) push(ctx context.Context, conn *nostr.Relay, ids []string, run *storage.NegentropySyncRun) error {
+	for start := 0; start < len(ids); start += negentropyBatchSize {
+		batch := ids[start:min(start+negentropyBatchSize, len(ids))]
+		events, err := s.storage.QueryEvents(ctx, nostr.Filter{IDs: batch, Limit: len(batch)})
+		if err != nil {
+			return fmt.Errorf("failed to load our events: %w", err)
+		}
+		for _, evt := range events {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := conn.Publish(ctx, *evt); err == nil {
+				run.Sent++
+			}
+		}
+	}
+	return nil
+}
+
+// countNegentropyRanges counts the ranges of a negentropy message still being
+// reconciled: those carrying a fingerprint or an ID list, but not skips
+func countNegentropyRanges(msg string) int64 {
+	b, err := hex.DecodeString(msg)
+	if err != nil || len(b) == 0 {
+		return 0
+	}
+	b = b[1:] // protocol version
+
+	var ranges int64
+	for len(b) > 0 {
+		var ok bool
+		var length, mode int
+		// Upper bound: timestamp, then a prefix of the ID
+		if _, b, ok = negentropyVarInt(b); !ok {
+			return ranges
+		}
+		if length, b, ok = negentropyVarInt(b); !ok || len(b) < length {
+			return ranges
+		}
+		b = b[length:]
+		if mode, b, ok = negentropyVarInt(b); !ok {
+			return ranges
+		}
+
+		switch negentropy.Mode(mode) {
+		case negentropy.SkipMode:
+		case negentropy.FingerprintMode:
+			if len(b) < negentropy.FingerprintSize {
+				return ranges
+			}
+			b = b[negentropy.FingerprintSize:]
+			ranges++
+		case negentropy.IdListMode:
+			var count int
+			if count, b, ok = negentropyVarInt(b); !ok || len(b) < count*32 {
+				return ranges
+			}
+			b = b[count*32:]
+			ranges++
+		default:
+			return ranges
+		}
+	}
+	return ranges
+}
+
+// negentropyVarInt reads a negentropy varint: base 128, most significant first
+func negentropyVarInt(b []byte) (int, []byte, bool) {
+	n := 0
+	for i, c := range b {
+		n = n<<7 | int(c&127)
+		if c&128 == 0 {
+			return n, b[i+1:], true
+		}
+	}
+	return 0, nil, false
+}
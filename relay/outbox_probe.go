@@ -0,0 +1,211 @@
+package relay
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	// outboxSampleSize is how many trusted pubkeys one probe checks
+	outboxSampleSize = 200
+	// outboxMaxRelays caps the write relays one probe queries, the most used first
+	outboxMaxRelays = 300
+	outboxWorkers   = 16
+	outboxTimeout   = 15 * time.Second
+	// outboxAuthorsPerFilter keeps filters under the author limits relays set
+	outboxAuthorsPerFilter = 50
+)
+
+// outboxKinds are the kinds clients fetch from a pubkey's write relays
+var outboxKinds = []int{0, 3}
+
+// OutboxProber measures how well the outbox model works: for a sample of
+// trusted pubkeys it asks the write relays in their relay lists for their
+// profile and contact list, the way a client following the outbox model would,
+// and compares the answers with what we hold
+type OutboxProber struct {
+	storage *storage.Storage
+}
+
+func NewOutboxProber(store *storage.Storage) *OutboxProber {
+	return &OutboxProber{storage: store}
+}
+
+// Probe samples trusted pubkeys, queries their write relays and records the
+// outbox accuracy per relay and overall
+func (p *OutboxProber) Probe(ctx context.Context) error {
+	trusted, err := p.storage.GetTrustedPubkeys(ctx)
+	if err != nil {
+		return err
+	}
+	if len(trusted) == 0 {
+		return nil
+	}
+	rand.Shuffle(len(trusted), func(i, j int) { trusted[i], trusted[j] = trusted[j], trusted[i] })
+	sample := trusted[:min(len(trusted), outboxSampleSize)]
+
+	relayLists, err := p.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{10002}, Authors: sample, Limit: len(sample)})
+	if err != nil {
+		return err
+	}
+	held, err := p.storage.QueryEvents(ctx, nostr.Filter{Kinds: outboxKinds, Authors: sample, Limit: len(sample) * len(outboxKinds)})
+	if err != nil {
+		return err
+	}
+
+	// Newest created_at we hold per pubkey and kind: what a relay should return
+	ours := make(map[string]map[int]nostr.Timestamp)
+	for _, evt := range held {
+		if ours[evt.PubKey] == nil {
+			ours[evt.PubKey] = make(map[int]nostr.Timestamp)
+		}
+		if evt.CreatedAt > ours[evt.PubKey][evt.Kind] {
+			ours[evt.PubKey][evt.Kind] = evt.CreatedAt
+		}
+	}
+
+	writers := make(map[string][]string) // relay -> pubkeys writing to it
+	probed := make(map[string]bool)
+	for _, evt := range relayLists {
+		if probed[evt.PubKey] || len(ours[evt.PubKey]) == 0 {
+			continue
+		}
+		probed[evt.PubKey] = true
+		for _, url := range writeRelays(evt) {
+			writers[url] = append(writers[url], evt.PubKey)
+		}
+	}
+	if len(writers) == 0 {
+		return nil
+	}
+
+	urls := make([]string, 0, len(writers))
+	for url := range writers {
+		urls = append(urls, url)
+	}
+	sort.Slice(urls, func(i, j int) bool { return len(writers[urls[i]]) > len(writers[urls[j]]) })
+	urls = urls[:min(len(urls), outboxMaxRelays)]
+
+	log.Printf("Outbox probe: querying %d write relays of %d trusted pubkeys", len(urls), len(probed))
+
+	results := make([]storage.OutboxRelayProbe, len(urls))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < outboxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = probeOutboxRelay(ctx, urls[i], writers[urls[i]], ours)
+			}
+		}()
+	}
+	for i := range urls {
+		select {
+		case queue <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	run := storage.OutboxProbeRun{RunAt: time.Now(), Pubkeys: int64(len(probed)), Relays: int64(len(urls))}
+	for _, r := range results {
+		run.Expected += r.Expected
+		run.Found += r.Found
+		run.Current += r.Current
+	}
+	if err := p.storage.RecordOutboxProbe(ctx, run, results); err != nil {
+		return err
+	}
+
+	if run.Expected > 0 {
+		log.Printf("Outbox probe: write relays returned %d of %d expected events (%.1f%%), %d current",
+			run.Found, run.Expected, float64(run.Found)*100/float64(run.Expected), run.Current)
+	}
+	return nil
+}
+
+// writeRelays returns the normalized write relays of a relay list: relays
+// without a marker or marked "write"
+func writeRelays(evt *nostr.Event) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, tag := range evt.Tags {
+		if len(tag) < 2 || tag[0] != "r" || (len(tag) >= 3 && tag[2] == "read") {
+			continue
+		}
+		normalized, err := NormalizeRelayURL(tag[1])
+		if err != nil || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		urls = append(urls, normalized)
+	}
+	return urls
+}
+
+// probeOutboxRelay asks one relay for the outbox kinds of the pubkeys writing
+// to it and counts which of the events we hold it returned
+func probeOutboxRelay(ctx context.Context, url string, pubkeys []string, ours map[string]map[int]nostr.Timestamp) storage.OutboxRelayProbe {
+	result := storage.OutboxRelayProbe{URL: url, ProbedAt: time.Now(), Pubkeys: int64(len(pubkeys))}
+	for _, pk := range pubkeys {
+		result.Expected += int64(len(ours[pk]))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, outboxTimeout)
+	defer cancel()
+
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer relay.Close()
+
+	// Newest created_at the relay returned per pubkey and kind
+	theirs := make(map[string]map[int]nostr.Timestamp)
+	for start := 0; start < len(pubkeys); start += outboxAuthorsPerFilter {
+		authors := pubkeys[start:min(start+outboxAuthorsPerFilter, len(pubkeys))]
+		events, err := relay.QuerySync(ctx, nostr.Filter{Kinds: outboxKinds, Authors: authors})
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		for _, evt := range events {
+			if theirs[evt.PubKey] == nil {
+				theirs[evt.PubKey] = make(map[int]nostr.Timestamp)
+			}
+			if evt.CreatedAt > theirs[evt.PubKey][evt.Kind] {
+				theirs[evt.PubKey][evt.Kind] = evt.CreatedAt
+			}
+		}
+	}
+
+	for _, pk := range pubkeys {
+		for kind, ourCreatedAt := range ours[pk] {
+			theirCreatedAt, ok := theirs[pk][kind]
+			if !ok {
+				continue
+			}
+			result.Found++
+			if theirCreatedAt >= ourCreatedAt {
+				result.Current++
+			}
+		}
+	}
+	return result
+}
@@ -125,7 +125,7 @@ func (sq *SyncQueue) syncKind(ctx context.Context, relay *nostr.Relay, kind int)
 				continue
 			}
 
-			if err := sq.storage.SaveEvent(ctx, evt); err != nil {
+			if err := sq.storage.SaveUpstreamEvent(ctx, relay.URL, evt); err != nil {
 				if err.Error() == "duplicate: event already exists" {
 					continue
 				}
@@ -105,7 +105,7 @@ func (s *SyncSubscriber) connectAndSubscribe(ctx context.Context, relayURL strin
 			if evt == nil {
 				continue
 			}
-			if err := s.storage.SaveEvent(ctx, evt); err != nil {
+			if err := s.storage.SaveUpstreamEvent(ctx, relayURL, evt); err != nil {
 				if err.Error() != "duplicate: event already exists" {
 					log.Printf("Sync subscriber: failed to save event from %s: %v", relayURL, err)
 				}
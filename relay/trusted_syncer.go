@@ -3,6 +3,7 @@ package relay
 import (
 	"context"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -17,6 +18,15 @@ type TrustedSyncer struct {
 	batchSize     int
 	timeout       time.Duration
 	stopChan      chan struct{}
+
+	// Keys used to answer NIP-42 challenges; relays needing auth are skipped without one
+	credentials *Credentials
+	// Relays flagged as auth-required or restricted, reloaded every sync round
+	capabilities map[string]storage.RelayCapability
+	// Shared with the profile hydrator so both don't ask a relay for the same pubkey at once
+	inflight *InFlight
+	// Set once the cycle running at startup has been picked up
+	resumed bool
 }
 
 func NewTrustedSyncer(
@@ -36,6 +46,16 @@ func NewTrustedSyncer(
 	}
 }
 
+// SetCredentials sets the keys used to authenticate to relays that require NIP-42
+func (s *TrustedSyncer) SetCredentials(credentials *Credentials) {
+	s.credentials = credentials
+}
+
+// SetInFlight shares a registry of in-flight fetches with other fetchers
+func (s *TrustedSyncer) SetInFlight(inflight *InFlight) {
+	s.inflight = inflight
+}
+
 func (s *TrustedSyncer) Start(ctx context.Context, intervalMinutes int) {
 	ticker := time.NewTicker(time.Duration(intervalMinutes) * time.Minute)
 	defer ticker.Stop()
@@ -64,6 +84,10 @@ func (s *TrustedSyncer) Stop() {
 	close(s.stopChan)
 }
 
+func (s *TrustedSyncer) RunOnce(ctx context.Context) {
+	s.sync(ctx)
+}
+
 func (s *TrustedSyncer) sync(ctx context.Context) {
 	// Get all trusted pubkeys
 	trustedPubkeys := s.trustAnalyzer.GetTrustedPubkeys()
@@ -72,10 +96,32 @@ func (s *TrustedSyncer) sync(ctx context.Context) {
 		return
 	}
 
+	capabilities, err := s.storage.GetRelayCapabilities(ctx, time.Now().Add(-storage.RelayCapabilityTTL))
+	if err != nil {
+		log.Printf("Trusted syncer: failed to load relay capabilities: %v", err)
+	}
+	s.capabilities = capabilities
+
+	// Pick up the cycle a restart interrupted; pubkeys it already synced wait for the next one
+	cycle, processed := s.resumeCycle(ctx, len(trustedPubkeys))
+	pending := make([]string, 0, len(trustedPubkeys))
+	for _, pubkey := range trustedPubkeys {
+		if !processed[pubkey] {
+			pending = append(pending, pubkey)
+		}
+	}
+	if len(pending) == 0 {
+		// The trusted set shrank to pubkeys this cycle already covered
+		s.completeCycle(ctx, cycle)
+		cycle, processed = s.resumeCycle(ctx, len(trustedPubkeys))
+		pending = trustedPubkeys
+	}
+
 	// First, prioritize pubkeys missing kind:0 or kind:3
-	missingPubkeys := s.findPubkeysMissingEvents(ctx, trustedPubkeys)
+	missingPubkeys := s.findPubkeysMissingEvents(ctx, pending)
 	syncedCount := 0
 	missingCount := len(missingPubkeys)
+	chosen := make(map[string]bool)
 
 	if missingCount > 0 {
 		// Sync pubkeys missing events first (up to batch size)
@@ -89,6 +135,8 @@ func (s *TrustedSyncer) sync(ctx context.Context) {
 
 		for _, pubkey := range toSync {
 			s.syncPubkey(ctx, pubkey, 0) // Use 0 since we want all events
+			s.markProcessed(ctx, cycle, pubkey)
+			chosen[pubkey] = true
 			syncedCount++
 		}
 	}
@@ -96,7 +144,14 @@ func (s *TrustedSyncer) sync(ctx context.Context) {
 	// If we have remaining capacity, fill with time-based queue
 	remaining := s.batchSize - syncedCount
 	if remaining > 0 {
-		queue, err := s.storage.GetTrustedSyncQueue(ctx, trustedPubkeys, remaining)
+		rest := make([]string, 0, len(pending))
+		for _, pubkey := range pending {
+			if !chosen[pubkey] {
+				rest = append(rest, pubkey)
+			}
+		}
+
+		queue, err := s.storage.GetTrustedSyncQueue(ctx, rest, remaining)
 		if err != nil {
 			log.Printf("Trusted syncer: failed to get sync queue: %v", err)
 			return
@@ -106,14 +161,81 @@ func (s *TrustedSyncer) sync(ctx context.Context) {
 			log.Printf("Trusted syncer: syncing %d additional pubkeys by time (of %d trusted)", len(queue), len(trustedPubkeys))
 			for _, state := range queue {
 				s.syncPubkey(ctx, state.Pubkey, state.LastSyncedAt)
+				s.markProcessed(ctx, cycle, state.Pubkey)
+				syncedCount++
 			}
 		}
 	}
+
+	if cycle != nil && syncedCount >= len(pending) && ctx.Err() == nil {
+		s.completeCycle(ctx, cycle)
+	}
+}
+
+// resumeCycle returns the running sync cycle and the pubkeys it already synced,
+// starting a cycle if none is running. The cycle is nil without a database to
+// keep it in, and every round then picks from all trusted pubkeys.
+func (s *TrustedSyncer) resumeCycle(ctx context.Context, total int) (*storage.TrustedSyncCycle, map[string]bool) {
+	cycle, err := s.storage.GetLatestTrustedSyncCycle(ctx)
+	if err != nil {
+		log.Printf("Trusted syncer: failed to load sync cycle: %v", err)
+		return nil, nil
+	}
+
+	if cycle != nil && cycle.CompletedAt.IsZero() {
+		processed, err := s.storage.GetTrustedSyncCycleProcessed(ctx, cycle.ID)
+		if err != nil {
+			log.Printf("Trusted syncer: failed to load progress of cycle %d: %v", cycle.ID, err)
+			return nil, nil
+		}
+		if cycle.Total != total {
+			cycle.Total = total
+			if err := s.storage.SetTrustedSyncCycleTotal(ctx, cycle.ID, total); err != nil {
+				log.Printf("Trusted syncer: failed to update cycle %d: %v", cycle.ID, err)
+			}
+		}
+		if !s.resumed {
+			s.resumed = true
+			log.Printf("Trusted syncer: resuming cycle %d at %.1f%% (%d of %d pubkeys)", cycle.ID, cycle.Percent(), len(processed), total)
+		}
+		return cycle, processed
+	}
+
+	s.resumed = true
+	cycle, err = s.storage.StartTrustedSyncCycle(ctx, total)
+	if err != nil {
+		log.Printf("Trusted syncer: failed to start sync cycle: %v", err)
+		return nil, nil
+	}
+	if cycle != nil {
+		log.Printf("Trusted syncer: starting cycle %d over %d pubkeys", cycle.ID, total)
+	}
+	return cycle, nil
+}
+
+func (s *TrustedSyncer) markProcessed(ctx context.Context, cycle *storage.TrustedSyncCycle, pubkey string) {
+	if cycle == nil || ctx.Err() != nil {
+		return
+	}
+	if err := s.storage.MarkTrustedSyncCycleProcessed(ctx, cycle.ID, pubkey); err != nil {
+		log.Printf("Trusted syncer: failed to record progress for %s: %v", pubkey[:16], err)
+	}
+}
+
+func (s *TrustedSyncer) completeCycle(ctx context.Context, cycle *storage.TrustedSyncCycle) {
+	if cycle == nil {
+		return
+	}
+	if err := s.storage.CompleteTrustedSyncCycle(ctx, cycle.ID); err != nil {
+		log.Printf("Trusted syncer: failed to complete cycle %d: %v", cycle.ID, err)
+		return
+	}
+	log.Printf("Trusted syncer: completed cycle %d in %s", cycle.ID, time.Since(cycle.StartedAt()).Round(time.Second))
 }
 
 // findPubkeysMissingEvents returns pubkeys that don't have kind:0 or kind:3 events
 func (s *TrustedSyncer) findPubkeysMissingEvents(ctx context.Context, pubkeys []string) []string {
-	eventKinds, err := s.storage.CheckPubkeyEventKinds(ctx, pubkeys)
+	eventKinds, err := s.storage.CheckPubkeyEventKinds(ctx, pubkeys, []int{0, 3})
 	if err != nil {
 		log.Printf("Trusted syncer: failed to check event kinds: %v", err)
 		return nil
@@ -122,7 +244,7 @@ func (s *TrustedSyncer) findPubkeysMissingEvents(ctx context.Context, pubkeys []
 	var missing []string
 	for _, pubkey := range pubkeys {
 		kinds := eventKinds[pubkey]
-		if !kinds.HasKind0 || !kinds.HasKind3 {
+		if !kinds.Has(0) || !kinds.Has(3) {
 			missing = append(missing, pubkey)
 		}
 	}
@@ -164,8 +286,20 @@ func (s *TrustedSyncer) syncPubkey(ctx context.Context, pubkey string, lastSynce
 			continue
 		}
 
-		count := s.fetchFromRelay(ctx, normalized, pubkey, filter)
-		eventsFound += count
+		// Don't burn a timeout on relays known to refuse anonymous reads
+		if c, ok := s.capabilities[normalized]; ok {
+			if c.Capability == storage.RelayRestricted || s.credentials.KeyFor(normalized) == "" {
+				continue
+			}
+		}
+
+		s.inflight.Fetch(ctx, normalized, pubkey, filter.Kinds, func(kinds []int) map[int]bool {
+			kindFilter := filter
+			kindFilter.Kinds = kinds
+			count, received := s.fetchFromRelay(ctx, normalized, pubkey, kindFilter)
+			eventsFound += count
+			return received
+		})
 	}
 
 	// Update sync state regardless of success (best effort approach)
@@ -179,46 +313,91 @@ func (s *TrustedSyncer) syncPubkey(ctx context.Context, pubkey string, lastSynce
 	}
 }
 
-func (s *TrustedSyncer) fetchFromRelay(ctx context.Context, relayURL, pubkey string, filter nostr.Filter) int {
+// fetchFromRelay saves what relayURL has for filter, returning how many events
+// were new and which kinds it returned events for
+func (s *TrustedSyncer) fetchFromRelay(ctx context.Context, relayURL, pubkey string, filter nostr.Filter) (int, map[int]bool) {
 	timeoutCtx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
+	received := make(map[int]bool)
 	relay, err := nostr.RelayConnect(timeoutCtx, relayURL)
 	if err != nil {
-		return 0
+		return 0, received
 	}
 	defer relay.Close()
 
 	sub, err := relay.Subscribe(timeoutCtx, []nostr.Filter{filter})
 	if err != nil {
-		return 0
+		return 0, received
 	}
-	defer sub.Unsub()
+	defer func() { sub.Unsub() }()
 
 	count := 0
+	authed := false
 	for {
 		select {
 		case <-timeoutCtx.Done():
 			if count > 0 {
 				s.storage.RecordTrustedSyncRelayStat(ctx, relayURL, pubkey, count)
 			}
-			return count
+			return count, received
 		case evt := <-sub.Events:
 			if evt == nil {
 				continue
 			}
-			if err := s.storage.SaveEvent(ctx, evt); err != nil {
+			received[evt.Kind] = true
+			if err := s.storage.SaveUpstreamEvent(ctx, relayURL, evt); err != nil {
 				if err.Error() != "duplicate: event already exists" {
 					log.Printf("Trusted syncer: failed to save event: %v", err)
 				}
 			} else {
 				count++
 			}
+		case reason := <-sub.ClosedReason:
+			if !authed {
+				authed = true
+				if retry := s.credentials.Resubscribe(timeoutCtx, s.storage, relay, reason, nostr.Filters{filter}); retry != nil {
+					sub.Unsub()
+					sub = retry
+					continue
+				}
+			}
+			s.flagRelay(ctx, relayURL, reason)
+			return count, received
 		case <-sub.EndOfStoredEvents:
 			if count > 0 {
 				s.storage.RecordTrustedSyncRelayStat(ctx, relayURL, pubkey, count)
 			}
-			return count
+			// A flagged relay that now serves us anonymously has changed its policy
+			if _, flagged := s.capabilities[relayURL]; flagged && !authed {
+				s.storage.ClearRelayCapability(ctx, relayURL)
+				delete(s.capabilities, relayURL)
+			}
+			return count, received
 		}
 	}
 }
+
+// flagRelay records that a relay closed our subscription because it wants auth or
+// refuses us, so later rounds skip it instead of waiting for a timeout
+func (s *TrustedSyncer) flagRelay(ctx context.Context, relayURL, reason string) {
+	var capability string
+	switch {
+	case strings.HasPrefix(reason, "auth-required:"):
+		capability = storage.RelayAuthRequired
+	case strings.HasPrefix(reason, "restricted:"), strings.HasPrefix(reason, "blocked:"):
+		capability = storage.RelayRestricted
+	default:
+		return
+	}
+
+	if err := s.storage.MarkRelayCapability(ctx, relayURL, capability, reason); err != nil {
+		log.Printf("Trusted syncer: failed to flag %s: %v", relayURL, err)
+		return
+	}
+	if s.capabilities == nil {
+		s.capabilities = make(map[string]storage.RelayCapability)
+	}
+	s.capabilities[relayURL] = storage.RelayCapability{URL: relayURL, Capability: capability, Reason: reason, DetectedAt: time.Now()}
+	log.Printf("Trusted syncer: flagged %s as %s (%s)", relayURL, capability, reason)
+}
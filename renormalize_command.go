@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func runRenormalizeCommand(args []string) {
+	renormalizeFlags := flag.NewFlagSet("renormalize-cooccurrence", flag.ExitOnError)
+	renormalizeFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages renormalize-cooccurrence\n\n")
+		fmt.Fprintf(os.Stderr, "Repair REQ co-occurrence counts after large spam purges: remove the analytics of every\n")
+		fmt.Fprintf(os.Stderr, "purged pubkey, drop pairs whose pubkeys have no request counts left, and cap each pair\n")
+		fmt.Fprintf(os.Stderr, "at the request count of its less requested pubkey.\n")
+	}
+
+	if err := renormalizeFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	_, store := openImportStorage()
+	defer store.Close()
+
+	if !store.AnalyticsEnabled() {
+		log.Fatalf("Analytics disabled: no SQL database configured")
+	}
+
+	result, err := store.RenormalizeCooccurrences(context.Background())
+	if err != nil {
+		log.Fatalf("Renormalization failed: %v", err)
+	}
+
+	fmt.Printf("Purged pubkeys: %d request rows, %d co-occurrence pairs, %d interest edges removed\n",
+		result.Purged.Requests, result.Purged.Cooccurrences, result.Purged.InterestEdges)
+	fmt.Printf("Co-occurrence pairs: %d orphaned pairs removed, %d counts capped\n", result.Orphaned, result.Capped)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/config"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+func runReplayLogCommand(args []string) {
+	replayFlags := flag.NewFlagSet("replay-log", flag.ExitOnError)
+	dir := replayFlags.String("dir", "", "Event log directory (default: storage.event_log_dir from config.json)")
+	untilFlag := replayFlags.String("until", "", "Replay events saved at or before this time, RFC 3339 or unix seconds (default: now)")
+	replayFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages replay-log [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Rebuild storage from the event log, replaying every event saved up to a point in time.\n")
+		fmt.Fprintf(os.Stderr, "Point storage in config.json at an empty database first; events already stored are skipped.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		replayFlags.PrintDefaults()
+	}
+
+	if err := replayFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load("config.json")
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	logDir := *dir
+	if logDir == "" {
+		logDir = cfg.Storage.EventLogDir
+	}
+	if logDir == "" {
+		log.Fatalf("No event log to replay: configure storage.event_log_dir or pass --dir")
+	}
+
+	until := time.Now()
+	if *untilFlag != "" {
+		until, err = parseReplayTime(*untilFlag)
+		if err != nil {
+			log.Fatalf("Invalid --until: %v", err)
+		}
+	}
+
+	// Replayed events must not be logged again, so the event log stays off here
+	store, err := storage.New(cfg.Storage.Backend, cfg.Storage.Path, false, cfg.Storage.AnalyticsDBURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer store.Close()
+
+	log.Printf("Replaying %s up to %s...", logDir, until.UTC().Format(time.RFC3339))
+
+	saved, duplicates, failed := 0, 0, 0
+	segments, skipped, err := storage.ReplayEventLog(context.Background(), logDir, until, func(evt *nostr.Event) error {
+		if err := store.SaveEvent(context.Background(), evt); err != nil {
+			if errors.Is(err, eventstore.ErrDupEvent) {
+				duplicates++
+			} else {
+				log.Printf("Failed to save event %s: %v", evt.ID, err)
+				failed++
+			}
+			return nil
+		}
+		saved++
+		if saved%10000 == 0 {
+			log.Printf("Replayed %d events (%d duplicates, %d failed)...", saved, duplicates, failed)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+
+	fmt.Printf("Replayed %d segments: %d events saved, %d already stored, %d failed, %d unreadable lines skipped\n",
+		segments, saved, duplicates, failed, skipped)
+}
+
+func parseReplayTime(value string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
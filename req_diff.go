@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// reqDiffParam opts a connection into diff streaming: wss://purplepag.es/?diff=1
+	reqDiffParam = "diff"
+	// reqDiffTTL is how long a filter's served set is kept without being re-requested
+	reqDiffTTL = 15 * time.Minute
+	// reqDiffMaxFilters caps the filters remembered per connection; the least recently
+	// requested one is dropped first
+	reqDiffMaxFilters = 32
+)
+
+// reqDiffs lets authenticated clients that re-REQ the same filter on a timer
+// receive only what changed. At EOSE the relay remembers which events the
+// filter matched on the connection; a repeat of the filter on it skips those
+// and streams only new events, including new versions of replaceable ones.
+// Sets are per connection, not per pubkey, so another device or tab of the
+// same user still gets everything. Served events are remembered rather than a
+// created_at watermark, since events synced late often carry timestamps older
+// than the last EOSE.
+type reqDiffs struct {
+	mu        sync.Mutex
+	filters   map[*khatru.WebSocket]map[string]*servedSet // connection -> filter hash -> served events
+	lastSweep time.Time
+}
+
+type servedSet struct {
+	ids      map[[8]byte]struct{} // event ID prefixes, enough to tell a filter's events apart
+	lastUsed time.Time
+}
+
+func newReqDiffs() *reqDiffs {
+	return &reqDiffs{filters: make(map[*khatru.WebSocket]map[string]*servedSet), lastSweep: time.Now()}
+}
+
+// key identifies filter for diffing, or is empty when the connection didn't opt
+// in or isn't authenticated
+func (d *reqDiffs) key(ctx context.Context, filter nostr.Filter) (conn *khatru.WebSocket, hash string) {
+	if khatru.IsInternalCall(ctx) {
+		return nil, ""
+	}
+	conn = khatru.GetConnection(ctx)
+	if conn == nil || conn.Request == nil || conn.Request.URL.Query().Get(reqDiffParam) != "1" {
+		return nil, ""
+	}
+	if khatru.GetAuthed(ctx) == "" {
+		return nil, ""
+	}
+	sum := sha256.Sum256([]byte(filter.String()))
+	return conn, hex.EncodeToString(sum[:])
+}
+
+// served returns the events the filter matched at its last EOSE, nil if unknown
+func (d *reqDiffs) served(conn *khatru.WebSocket, hash string) map[[8]byte]struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(time.Now())
+	if set := d.filters[conn][hash]; set != nil {
+		set.lastUsed = time.Now()
+		return set.ids
+	}
+	return nil
+}
+
+// remember records the events the filter matched once they were all sent
+func (d *reqDiffs) remember(conn *khatru.WebSocket, hash string, ids map[[8]byte]struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	byHash := d.filters[conn]
+	if byHash == nil {
+		byHash = make(map[string]*servedSet)
+		d.filters[conn] = byHash
+	}
+	if _, ok := byHash[hash]; !ok && len(byHash) >= reqDiffMaxFilters {
+		oldest := ""
+		for h, set := range byHash {
+			if oldest == "" || set.lastUsed.Before(byHash[oldest].lastUsed) {
+				oldest = h
+			}
+		}
+		delete(byHash, oldest)
+	}
+	byHash[hash] = &servedSet{ids: ids, lastUsed: time.Now()}
+}
+
+// forget drops a closed connection's served sets
+func (d *reqDiffs) forget(conn *khatru.WebSocket) {
+	d.mu.Lock()
+	delete(d.filters, conn)
+	d.mu.Unlock()
+}
+
+// sweep drops served sets not requested within reqDiffTTL. Called with mu held.
+func (d *reqDiffs) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < time.Minute {
+		return
+	}
+	d.lastSweep = now
+
+	for conn, byHash := range d.filters {
+		for hash, set := range byHash {
+			if now.Sub(set.lastUsed) > reqDiffTTL {
+				delete(byHash, hash)
+			}
+		}
+		if len(byHash) == 0 {
+			delete(d.filters, conn)
+		}
+	}
+}
+
+func eventIDPrefix(evt *nostr.Event) [8]byte {
+	var prefix [8]byte
+	if len(evt.ID) < 16 {
+		return prefix
+	}
+	hex.Decode(prefix[:], []byte(evt.ID[:16]))
+	return prefix
+}
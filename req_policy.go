@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pablof7z/purplepag.es/stats"
+)
+
+// reqFilterCounter counts the filters of each REQ. khatru runs RejectFilter once
+// per filter with a context shared by all filters of the REQ, so that context
+// identifies it. A REQ's count is recorded once it is closed.
+type reqFilterCounter struct {
+	mu     sync.Mutex
+	counts map[context.Context]int
+	stats  *stats.Stats
+}
+
+func newReqFilterCounter(s *stats.Stats) *reqFilterCounter {
+	return &reqFilterCounter{counts: make(map[context.Context]int), stats: s}
+}
+
+// add counts one more filter for the REQ of ctx and returns how many it has so far
+func (c *reqFilterCounter) add(ctx context.Context) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.counts[ctx]
+	if !ok {
+		context.AfterFunc(ctx, func() { c.done(ctx) })
+	}
+	n++
+	c.counts[ctx] = n
+	return n
+}
+
+func (c *reqFilterCounter) done(ctx context.Context) {
+	c.mu.Lock()
+	n := c.counts[ctx]
+	delete(c.counts, ctx)
+	c.mu.Unlock()
+
+	c.stats.ObserveFilterSize("filters", n)
+}
@@ -0,0 +1,101 @@
+// Package static serves the JavaScript libraries the stats pages use, embedded
+// so that pages keep working without access to the public CDNs.
+package static
+
+//go:generate sh -c "curl -sfL -o assets/chart.umd.min.js https://cdn.jsdelivr.net/npm/chart.js@4.4.1/dist/chart.umd.min.js && curl -sfL -o assets/d3.v7.min.js https://cdn.jsdelivr.net/npm/d3@7.9.0/dist/d3.min.js"
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+//go:embed assets
+var assets embed.FS
+
+type library struct {
+	file string // name under assets/ and /static/
+	cdn  string
+}
+
+// Libraries referenced from templates with {{asset "<name>"}}
+var libraries = map[string]library{
+	"chart.js": {file: "chart.umd.min.js", cdn: "https://cdn.jsdelivr.net/npm/chart.js@4.4.1/dist/chart.umd.min.js"},
+	"d3":       {file: "d3.v7.min.js", cdn: "https://cdn.jsdelivr.net/npm/d3@7.9.0/dist/d3.min.js"},
+}
+
+type embedded struct {
+	content []byte
+	etag    string
+}
+
+var (
+	mu     sync.RWMutex
+	useCDN bool
+	files  = loadEmbedded()
+)
+
+func loadEmbedded() map[string]embedded {
+	result := make(map[string]embedded)
+	for _, lib := range libraries {
+		content, err := assets.ReadFile("assets/" + lib.file)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(content)
+		result[lib.file] = embedded{content: content, etag: hex.EncodeToString(sum[:8])}
+	}
+	return result
+}
+
+// SetUseCDN makes URL point at the public CDNs instead of /static
+func SetUseCDN(enabled bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	useCDN = enabled
+}
+
+// URL returns where pages should load the named library from: the embedded copy,
+// versioned by content hash so it can be cached forever, or the CDN when
+// configured or when the binary was built without the library.
+func URL(name string) string {
+	lib, ok := libraries[name]
+	if !ok {
+		return ""
+	}
+
+	mu.RLock()
+	cdn := useCDN
+	mu.RUnlock()
+
+	f, ok := files[lib.file]
+	if cdn || !ok {
+		return lib.cdn
+	}
+	return "/static/" + lib.file + "?v=" + f.etag
+}
+
+// Handler serves the embedded libraries under /static/
+func Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, ok := files[strings.TrimPrefix(r.URL.Path, "/static/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		etag := `"` + f.etag + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Write(f.content)
+	}
+}
@@ -3,12 +3,14 @@ package stats
 import (
 	"context"
 	"fmt"
-	"html/template"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/pablof7z/purplepag.es/analytics"
 	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
 )
 
 type AnalyticsHandler struct {
@@ -26,13 +28,14 @@ func NewAnalyticsHandler(tracker *analytics.Tracker, trustAnalyzer *analytics.Tr
 }
 
 type PubkeyDisplay struct {
-	Pubkey        string
-	ShortPubkey   string
-	Name          string
-	TotalRequests int64
-	LastRequest   string
-	IsTrusted     bool
-	IsInCluster   bool
+	Pubkey         string
+	ShortPubkey    string
+	Name           string
+	TotalRequests  int64
+	LastRequest    string
+	IsTrusted      bool
+	IsInCluster    bool
+	ProfileChanges int
 }
 
 type CooccurrenceDisplay struct {
@@ -60,16 +63,56 @@ type SpamDisplay struct {
 	DetectedAgo string
 }
 
+type ReportDisplay struct {
+	Pubkey           string
+	ShortPubkey      string
+	Reports          int
+	TrustedReporters int
+	Score            string
+	Types            string
+	LastReportedAgo  string
+}
+
+type RevocationDisplay struct {
+	Pubkey      string
+	ShortPubkey string
+	Signals     string
+	RevokedAgo  string
+}
+
+type DeadAccountDisplay struct {
+	Pubkey       string
+	ShortPubkey  string
+	EmptyRounds  int
+	LastRoundAgo string
+}
+
+type ScraperDisplay struct {
+	IP            string
+	Reason        string
+	PeakPerMinute int64
+	Detections    int64
+	LastSeenAgo   string
+}
+
 type AnalyticsPageData struct {
-	SearchPubkey   string
-	SearchResult   *PubkeyDisplay
-	TopRequested   []PubkeyDisplay
-	TopCooccurring []CooccurrenceDisplay
-	BotClusters    []ClusterDisplay
-	SpamCandidates []SpamDisplay
-	TrustedCount   int
-	Message        string
-	Error          string
+	SearchPubkey       string
+	SearchResult       *PubkeyDisplay
+	TopRequested       []PubkeyDisplay
+	TopCooccurring     []CooccurrenceDisplay
+	BotClusters        []ClusterDisplay
+	SpamCandidates     []SpamDisplay
+	ReportedPubkeys    []ReportDisplay
+	Revocations        []RevocationDisplay
+	ScraperCandidates  []ScraperDisplay
+	DuplicateGroups    []DuplicateGroupDisplay
+	DuplicateSpamGroup int
+	DeadAccounts       []DeadAccountDisplay
+	DeadCount          int64
+	UnreachableCount   int64
+	TrustedCount       int
+	Message            string
+	Error              string
 }
 
 func (h *AnalyticsHandler) HandleAnalytics() http.HandlerFunc {
@@ -77,8 +120,9 @@ func (h *AnalyticsHandler) HandleAnalytics() http.HandlerFunc {
 		ctx := context.Background()
 
 		data := AnalyticsPageData{
-			TrustedCount: h.trustAnalyzer.GetTrustedCount(),
-			Message:      r.URL.Query().Get("message"),
+			TrustedCount:       h.trustAnalyzer.GetTrustedCount(),
+			DuplicateSpamGroup: analytics.DuplicateProfileSpamGroup,
+			Message:            r.URL.Query().Get("message"),
 		}
 
 		if pubkey := r.URL.Query().Get("pubkey"); pubkey != "" {
@@ -86,13 +130,15 @@ func (h *AnalyticsHandler) HandleAnalytics() http.HandlerFunc {
 			stats, err := h.tracker.GetPubkeyStats(ctx, pubkey)
 			if err == nil && stats != nil {
 				inCluster, _ := h.storage.IsPubkeyInBotCluster(ctx, pubkey)
+				velocity, _ := h.storage.GetProfileChangeVelocity(ctx, []string{pubkey})
 				data.SearchResult = &PubkeyDisplay{
-					Pubkey:        stats.Pubkey,
-					ShortPubkey:   shortPubkey(stats.Pubkey),
-					TotalRequests: stats.TotalRequests,
-					LastRequest:   formatTimeAgo(time.Since(stats.LastRequest)),
-					IsTrusted:     h.trustAnalyzer.IsTrusted(pubkey),
-					IsInCluster:   inCluster,
+					Pubkey:         stats.Pubkey,
+					ShortPubkey:    shortPubkey(stats.Pubkey),
+					TotalRequests:  stats.TotalRequests,
+					LastRequest:    formatTimeAgo(time.Since(stats.LastRequest)),
+					IsTrusted:      h.trustAnalyzer.IsTrusted(pubkey),
+					IsInCluster:    inCluster,
+					ProfileChanges: velocity[pubkey],
 				}
 			}
 		}
@@ -105,17 +151,19 @@ func (h *AnalyticsHandler) HandleAnalytics() http.HandlerFunc {
 			topPubkeys[i] = s.Pubkey
 		}
 		profileNames, _ := h.storage.GetProfileNames(ctx, topPubkeys)
+		profileVelocity, _ := h.storage.GetProfileChangeVelocity(ctx, topPubkeys)
 
 		for _, s := range topRequested {
 			inCluster, _ := h.storage.IsPubkeyInBotCluster(ctx, s.Pubkey)
 			data.TopRequested = append(data.TopRequested, PubkeyDisplay{
-				Pubkey:        s.Pubkey,
-				ShortPubkey:   shortPubkey(s.Pubkey),
-				Name:          profileNames[s.Pubkey],
-				TotalRequests: s.TotalRequests,
-				LastRequest:   formatTimeAgo(time.Since(s.LastRequest)),
-				IsTrusted:     h.trustAnalyzer.IsTrusted(s.Pubkey),
-				IsInCluster:   inCluster,
+				Pubkey:         s.Pubkey,
+				ShortPubkey:    shortPubkey(s.Pubkey),
+				Name:           profileNames[s.Pubkey],
+				TotalRequests:  s.TotalRequests,
+				LastRequest:    formatTimeAgo(time.Since(s.LastRequest)),
+				IsTrusted:      h.trustAnalyzer.IsTrusted(s.Pubkey),
+				IsInCluster:    inCluster,
+				ProfileChanges: profileVelocity[s.Pubkey],
 			})
 		}
 
@@ -159,7 +207,63 @@ func (h *AnalyticsHandler) HandleAnalytics() http.HandlerFunc {
 			})
 		}
 
-		tmpl, err := template.New("analytics").Parse(analyticsTemplate)
+		duplicates, _ := h.storage.GetDuplicateProfileGroups(ctx, 50)
+		for _, g := range duplicates {
+			data.DuplicateGroups = append(data.DuplicateGroups, DuplicateGroupDisplay{
+				DuplicateProfileGroup: g,
+				DetectedAgo:           formatTimeAgo(time.Since(g.DetectedAt)),
+			})
+		}
+
+		reported, _ := h.storage.GetReportSummaries(ctx, 0, 50)
+		for _, r := range reported {
+			data.ReportedPubkeys = append(data.ReportedPubkeys, ReportDisplay{
+				Pubkey:           r.Pubkey,
+				ShortPubkey:      shortPubkey(r.Pubkey),
+				Reports:          r.Reports,
+				TrustedReporters: r.TrustedReporters,
+				Score:            fmt.Sprintf("%.1f", r.Score),
+				Types:            strings.Join(r.Types, ", "),
+				LastReportedAgo:  formatTimeAgo(time.Since(r.LastReportedAt)),
+			})
+		}
+
+		revocations, _ := h.storage.GetTrustRevocations(ctx, 50)
+		for _, r := range revocations {
+			data.Revocations = append(data.Revocations, RevocationDisplay{
+				Pubkey:      r.Pubkey,
+				ShortPubkey: shortPubkey(r.Pubkey),
+				Signals:     strings.Join(r.Signals, ", "),
+				RevokedAgo:  formatTimeAgo(time.Since(r.RevokedAt)),
+			})
+		}
+
+		outcomes, _ := h.storage.GetHydrationOutcomeCounts(ctx)
+		data.DeadCount = outcomes[storage.HydrationDead]
+		data.UnreachableCount = outcomes[storage.HydrationUnreachable]
+
+		deadAccounts, _ := h.storage.GetDeadAccounts(ctx, 50)
+		for _, d := range deadAccounts {
+			data.DeadAccounts = append(data.DeadAccounts, DeadAccountDisplay{
+				Pubkey:       d.Pubkey,
+				ShortPubkey:  shortPubkey(d.Pubkey),
+				EmptyRounds:  d.EmptyRounds,
+				LastRoundAgo: formatTimeAgo(time.Since(d.LastRound)),
+			})
+		}
+
+		scrapers, _ := h.storage.GetScraperCandidates(ctx, 50)
+		for _, c := range scrapers {
+			data.ScraperCandidates = append(data.ScraperCandidates, ScraperDisplay{
+				IP:            c.IP,
+				Reason:        c.Reason,
+				PeakPerMinute: c.PeakPerMinute,
+				Detections:    c.Detections,
+				LastSeenAgo:   formatTimeAgo(time.Since(c.LastSeen)),
+			})
+		}
+
+		tmpl, err := templates.Get("analytics", nil)
 		if err != nil {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
@@ -208,6 +312,18 @@ func (h *AnalyticsHandler) HandlePurge() http.HandlerFunc {
 			return
 		}
 
+		// Their REQ analytics would keep skewing co-occurrence and clusters otherwise.
+		// The events are gone already, so a failure here is left to renormalize-cooccurrence.
+		cleaned, err := h.storage.PurgeAnalyticsForPubkeys(ctx, pubkeys)
+		if err != nil {
+			log.Printf("Failed to purge analytics of spam pubkeys: %v", err)
+		}
+
+		details := fmt.Sprintf("deleted %d events and %d analytics rows from %d spam pubkeys", deleted, cleaned.Total(), len(pubkeys))
+		if err := h.storage.RecordAdminAction(ctx, AuditActor(r), storage.AuditPurgeSpam, details, deleted); err != nil {
+			log.Printf("Failed to record purge in audit log: %v", err)
+		}
+
 		http.Redirect(w, r, fmt.Sprintf("/stats/analytics?message=Purged+%d+events+from+%d+spam+pubkeys", deleted, len(pubkeys)), http.StatusSeeOther)
 	}
 }
@@ -218,550 +334,3 @@ func shortPubkey(pubkey string) string {
 	}
 	return pubkey[:8] + "..." + pubkey[len(pubkey)-8:]
 }
-
-var analyticsTemplate = `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>purplepag.es - REQ Analytics</title>
-    <style>
-        * { margin: 0; padding: 0; box-sizing: border-box; }
-
-        body {
-            font-family: -apple-system, BlinkMacSystemFont, 'SF Pro Display', 'Segoe UI', Roboto, 'Helvetica Neue', Arial, sans-serif;
-            background: #0a0a0f;
-            min-height: 100vh;
-            padding: 2rem;
-            color: #e4e4e7;
-            position: relative;
-            overflow-x: hidden;
-        }
-
-        body::before {
-            content: '';
-            position: fixed;
-            top: -50%;
-            left: -50%;
-            width: 200%;
-            height: 200%;
-            background: radial-gradient(circle at 30% 20%, rgba(139, 92, 246, 0.08) 0%, transparent 50%),
-                        radial-gradient(circle at 70% 80%, rgba(217, 70, 239, 0.06) 0%, transparent 50%);
-            animation: drift 30s ease-in-out infinite;
-            pointer-events: none;
-        }
-
-        @keyframes drift {
-            0%, 100% { transform: translate(0, 0) rotate(0deg); }
-            33% { transform: translate(-5%, 5%) rotate(5deg); }
-            66% { transform: translate(5%, -5%) rotate(-5deg); }
-        }
-
-        @keyframes shimmer {
-            0%, 100% { background-position: 0% 50%; }
-            50% { background-position: 100% 50%; }
-        }
-
-        .container {
-            max-width: 1400px;
-            margin: 0 auto;
-            position: relative;
-            z-index: 1;
-        }
-
-        header {
-            margin-bottom: 3rem;
-            text-align: center;
-        }
-
-        h1 {
-            font-size: 3rem;
-            font-weight: 700;
-            margin-bottom: 0.5rem;
-            background: linear-gradient(135deg, #a78bfa 0%, #e879f9 50%, #a78bfa 100%);
-            background-size: 200% 100%;
-            background-clip: text;
-            -webkit-background-clip: text;
-            -webkit-text-fill-color: transparent;
-            animation: shimmer 8s ease-in-out infinite;
-            letter-spacing: -0.02em;
-        }
-
-        .subtitle {
-            font-size: 1rem;
-            font-weight: 500;
-            color: #a1a1aa;
-            text-transform: uppercase;
-            letter-spacing: 0.15em;
-        }
-
-        .back-link {
-            display: inline-block;
-            margin-bottom: 2rem;
-            color: #a78bfa;
-            text-decoration: none;
-            font-weight: 500;
-            transition: color 0.2s;
-        }
-
-        .back-link:hover { color: #c4b5fd; }
-
-        .search-box {
-            background: linear-gradient(135deg, rgba(139, 92, 246, 0.05) 0%, rgba(217, 70, 239, 0.02) 100%);
-            border: 1px solid rgba(167, 139, 250, 0.15);
-            border-radius: 24px;
-            padding: 1.5rem;
-            margin-bottom: 2rem;
-        }
-
-        .search-box input {
-            width: 100%;
-            padding: 0.75rem 1rem;
-            font-size: 0.9rem;
-            background: rgba(10, 10, 15, 0.5);
-            border: 1px solid rgba(167, 139, 250, 0.2);
-            border-radius: 12px;
-            color: #e4e4e7;
-            font-family: 'SF Mono', monospace;
-        }
-
-        .search-box input:focus {
-            outline: none;
-            border-color: #a78bfa;
-        }
-
-        .search-box button {
-            margin-top: 1rem;
-            padding: 0.75rem 1.5rem;
-            background: rgba(167, 139, 250, 0.2);
-            border: 1px solid rgba(167, 139, 250, 0.3);
-            border-radius: 12px;
-            color: #e4e4e7;
-            font-family: inherit;
-            font-size: 0.875rem;
-            cursor: pointer;
-            transition: all 0.2s;
-        }
-
-        .search-box button:hover {
-            background: rgba(167, 139, 250, 0.3);
-            border-color: rgba(167, 139, 250, 0.5);
-        }
-
-        .result-card {
-            background: linear-gradient(135deg, rgba(139, 92, 246, 0.05) 0%, rgba(217, 70, 239, 0.02) 100%);
-            border: 1px solid rgba(167, 139, 250, 0.15);
-            border-radius: 24px;
-            padding: 1.5rem;
-            margin-bottom: 2rem;
-        }
-
-        .result-card h3 { color: #e4e4e7; margin-bottom: 0.75rem; font-size: 1rem; }
-        .result-card .pubkey { font-size: 0.8rem; color: #71717a; word-break: break-all; font-family: 'SF Mono', monospace; }
-        .result-card .stats { display: flex; gap: 2rem; margin-top: 1rem; }
-        .result-card .stat-label { font-size: 0.7rem; color: #a1a1aa; text-transform: uppercase; letter-spacing: 0.05em; }
-        .result-card .stat-value { font-size: 1.5rem; font-weight: 700; color: #e4e4e7; }
-
-        .badge {
-            display: inline-block;
-            padding: 0.2rem 0.6rem;
-            border-radius: 8px;
-            font-size: 0.7rem;
-            font-weight: 600;
-            margin-left: 0.5rem;
-        }
-
-        .badge.trusted { background: rgba(34, 197, 94, 0.2); color: #4ade80; border: 1px solid rgba(34, 197, 94, 0.3); }
-        .badge.cluster { background: rgba(239, 68, 68, 0.2); color: #f87171; border: 1px solid rgba(239, 68, 68, 0.3); }
-
-        .section {
-            background: linear-gradient(135deg, rgba(139, 92, 246, 0.03) 0%, rgba(217, 70, 239, 0.01) 100%);
-            border: 1px solid rgba(167, 139, 250, 0.1);
-            border-radius: 24px;
-            padding: 2rem;
-            margin-bottom: 2rem;
-        }
-
-        .section h2 {
-            font-size: 1.25rem;
-            font-weight: 700;
-            margin-bottom: 1.5rem;
-            color: #e4e4e7;
-        }
-
-        .data-table { width: 100%; border-collapse: collapse; }
-
-        .data-table th, .data-table td {
-            padding: 0.75rem 1rem;
-            text-align: left;
-            border-bottom: 1px solid rgba(167, 139, 250, 0.1);
-        }
-
-        .data-table th {
-            color: #a1a1aa;
-            font-weight: 600;
-            font-size: 0.7rem;
-            text-transform: uppercase;
-            letter-spacing: 0.05em;
-        }
-
-        .data-table td { font-size: 0.85rem; }
-        .data-table tr:hover { background: rgba(167, 139, 250, 0.03); }
-        .data-table .mono { color: #71717a; font-family: 'SF Mono', monospace; font-size: 0.8rem; }
-        .data-table .num { font-variant-numeric: tabular-nums; color: #a78bfa; font-weight: 600; }
-
-        .cluster-card {
-            background: rgba(239, 68, 68, 0.05);
-            border: 1px solid rgba(239, 68, 68, 0.2);
-            border-radius: 16px;
-            padding: 1rem;
-            margin-bottom: 1rem;
-        }
-
-        .cluster-card .header {
-            display: flex;
-            justify-content: space-between;
-            margin-bottom: 0.5rem;
-            font-size: 0.85rem;
-            color: #e4e4e7;
-        }
-
-        .cluster-card .members { font-size: 0.75rem; color: #71717a; font-family: 'SF Mono', monospace; }
-
-        .spam-section {
-            background: linear-gradient(135deg, rgba(239, 68, 68, 0.05) 0%, rgba(239, 68, 68, 0.02) 100%);
-            border-color: rgba(239, 68, 68, 0.2);
-        }
-
-        .purge-btn {
-            padding: 0.75rem 1.5rem;
-            background: rgba(239, 68, 68, 0.2);
-            border: 1px solid rgba(239, 68, 68, 0.3);
-            border-radius: 12px;
-            color: #f87171;
-            font-weight: 600;
-            font-family: inherit;
-            font-size: 0.85rem;
-            cursor: pointer;
-            margin-bottom: 1rem;
-            transition: all 0.2s;
-        }
-
-        .purge-btn:hover {
-            background: rgba(239, 68, 68, 0.3);
-            border-color: rgba(239, 68, 68, 0.5);
-        }
-
-        .message {
-            background: rgba(34, 197, 94, 0.1);
-            border: 1px solid rgba(34, 197, 94, 0.3);
-            color: #4ade80;
-            padding: 1rem;
-            border-radius: 16px;
-            margin-bottom: 2rem;
-            font-size: 0.9rem;
-        }
-
-        .stats-row {
-            display: grid;
-            grid-template-columns: repeat(auto-fit, minmax(150px, 1fr));
-            gap: 1rem;
-            margin-bottom: 2rem;
-        }
-
-        .stat-box {
-            background: linear-gradient(135deg, rgba(139, 92, 246, 0.05) 0%, rgba(217, 70, 239, 0.02) 100%);
-            border: 1px solid rgba(167, 139, 250, 0.15);
-            border-radius: 16px;
-            padding: 1.25rem;
-            text-align: center;
-        }
-
-        .stat-box .label {
-            font-size: 0.7rem;
-            color: #a1a1aa;
-            text-transform: uppercase;
-            letter-spacing: 0.1em;
-            margin-bottom: 0.5rem;
-        }
-
-        .stat-box .value {
-            font-size: 1.75rem;
-            font-weight: 700;
-            background: linear-gradient(135deg, #e4e4e7 0%, #a1a1aa 100%);
-            background-clip: text;
-            -webkit-background-clip: text;
-            -webkit-text-fill-color: transparent;
-        }
-
-        @media (max-width: 768px) {
-            body { padding: 1.5rem; }
-            h1 { font-size: 2rem; }
-            .stats-row { grid-template-columns: repeat(2, 1fr); }
-            .data-table { font-size: 0.75rem; }
-            .data-table th, .data-table td { padding: 0.5rem; }
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <a href="/stats" class="back-link">← Back to Stats</a>
-        <header>
-            <h1>purplepag.es</h1>
-            <div class="subtitle">REQ Analytics & Spam Detection</div>
-        </header>
-
-        {{if .Message}}
-        <div class="message">{{.Message}}</div>
-        {{end}}
-
-        <div class="stats-row">
-            <div class="stat-box">
-                <div class="label">Trusted Pubkeys</div>
-                <div class="value">{{.TrustedCount}}</div>
-            </div>
-            <div class="stat-box">
-                <div class="label">Bot Clusters</div>
-                <div class="value">{{len .BotClusters}}</div>
-            </div>
-            <div class="stat-box">
-                <div class="label">Spam Candidates</div>
-                <div class="value">{{len .SpamCandidates}}</div>
-            </div>
-        </div>
-
-        <div class="search-box">
-            <form method="GET">
-                <input type="text" name="pubkey" placeholder="Search pubkey..." value="{{.SearchPubkey}}">
-                <button type="submit">Search</button>
-            </form>
-        </div>
-
-        {{if .SearchResult}}
-        <div class="result-card">
-            <h3>Search Result
-                {{if .SearchResult.IsTrusted}}<span class="badge trusted">Trusted</span>{{end}}
-                {{if .SearchResult.IsInCluster}}<span class="badge cluster">Bot Cluster</span>{{end}}
-            </h3>
-            <div class="pubkey">{{.SearchResult.Pubkey}}</div>
-            <div class="stats">
-                <div class="stat">
-                    <div class="stat-label">Total Requests</div>
-                    <div class="stat-value">{{.SearchResult.TotalRequests}}</div>
-                </div>
-                <div class="stat">
-                    <div class="stat-label">Last Requested</div>
-                    <div class="stat-value">{{.SearchResult.LastRequest}}</div>
-                </div>
-            </div>
-        </div>
-        {{end}}
-
-        {{if .SpamCandidates}}
-        <div class="section spam-section">
-            <h2>Spam Candidates ({{len .SpamCandidates}})</h2>
-            <form method="POST" action="/stats/analytics/purge" onsubmit="return confirm('Are you sure you want to purge all spam events? This cannot be undone.');">
-                <button type="submit" class="purge-btn">Purge All Spam Events</button>
-            </form>
-            <table class="data-table">
-                <thead>
-                    <tr>
-                        <th>Pubkey</th>
-                        <th>Reason</th>
-                        <th>Events</th>
-                        <th>Detected</th>
-                    </tr>
-                </thead>
-                <tbody>
-                    {{range .SpamCandidates}}
-                    <tr>
-                        <td class="mono">{{.ShortPubkey}}</td>
-                        <td>{{.Reason}}</td>
-                        <td class="num">{{.EventCount}}</td>
-                        <td>{{.DetectedAgo}}</td>
-                    </tr>
-                    {{end}}
-                </tbody>
-            </table>
-        </div>
-        {{end}}
-
-        {{if .BotClusters}}
-        <div class="section">
-            <h2>Detected Bot Clusters</h2>
-            {{range .BotClusters}}
-            <div class="cluster-card">
-                <div class="header">
-                    <strong>Cluster #{{.ID}}</strong>
-                    <span>{{.Size}} members · {{.InternalDensity}} density · {{.ExternalRatio}} external · {{.DetectedAgo}}</span>
-                </div>
-                <div class="members">{{range .MemberPreviews}}{{.}} {{end}}{{if gt .Size 5}}...{{end}}</div>
-            </div>
-            {{end}}
-        </div>
-        {{end}}
-
-        {{if .TopRequested}}
-        <div class="section">
-            <h2>Top Requested Pubkeys</h2>
-            <table class="data-table">
-                <thead>
-                    <tr>
-                        <th>Name / Pubkey</th>
-                        <th>Requests</th>
-                        <th>Last Requested</th>
-                        <th>Status</th>
-                    </tr>
-                </thead>
-                <tbody>
-                    {{range .TopRequested}}
-                    <tr>
-                        <td>
-                            {{if .Name}}<strong style="color:#f0f6fc">{{.Name}}</strong><br>{{end}}<span class="mono" style="font-size:0.65rem">{{.ShortPubkey}}</span>
-                        </td>
-                        <td class="num">{{.TotalRequests}}</td>
-                        <td>{{.LastRequest}}</td>
-                        <td>
-                            {{if .IsTrusted}}<span class="badge trusted">Trusted</span>{{end}}
-                            {{if .IsInCluster}}<span class="badge cluster">Bot Cluster</span>{{end}}
-                        </td>
-                    </tr>
-                    {{end}}
-                </tbody>
-            </table>
-        </div>
-        {{end}}
-
-        {{if .TopCooccurring}}
-        <div class="section">
-            <h2>Pubkey Co-occurrence Graph</h2>
-            <p style="color: #8b949e; margin-bottom: 0.75rem; font-size: 0.75rem;">
-                Nodes represent pubkeys, edges show co-occurrence frequency. Drag to reposition, scroll to zoom.
-            </p>
-            <div id="graph-container" style="width: 100%; height: 600px; background: #0d1117; border-radius: 6px; overflow: hidden;"></div>
-        </div>
-        <script src="https://d3js.org/d3.v7.min.js"></script>
-        <script>
-        (function() {
-            const cooccurrences = [
-                {{range .TopCooccurring}}
-                {a: "{{.ShortPubkeyA}}", aFull: "{{.PubkeyA}}", b: "{{.ShortPubkeyB}}", bFull: "{{.PubkeyB}}", count: {{.Count}}},
-                {{end}}
-            ];
-
-            const nodeMap = new Map();
-            const links = [];
-
-            cooccurrences.forEach(c => {
-                if (!nodeMap.has(c.a)) {
-                    nodeMap.set(c.a, {id: c.a, full: c.aFull, weight: 0});
-                }
-                if (!nodeMap.has(c.b)) {
-                    nodeMap.set(c.b, {id: c.b, full: c.bFull, weight: 0});
-                }
-                nodeMap.get(c.a).weight += c.count;
-                nodeMap.get(c.b).weight += c.count;
-                links.push({source: c.a, target: c.b, value: c.count});
-            });
-
-            const nodes = Array.from(nodeMap.values());
-            const maxWeight = Math.max(...nodes.map(n => n.weight));
-            const maxLink = Math.max(...links.map(l => l.value));
-
-            const container = document.getElementById('graph-container');
-            const width = container.clientWidth;
-            const height = 600;
-
-            const svg = d3.select('#graph-container')
-                .append('svg')
-                .attr('width', width)
-                .attr('height', height);
-
-            const g = svg.append('g');
-
-            const zoom = d3.zoom()
-                .scaleExtent([0.2, 4])
-                .on('zoom', (event) => g.attr('transform', event.transform));
-
-            svg.call(zoom);
-
-            const simulation = d3.forceSimulation(nodes)
-                .force('link', d3.forceLink(links).id(d => d.id).distance(d => 100 - (d.value / maxLink) * 50).strength(d => 0.3 + (d.value / maxLink) * 0.7))
-                .force('charge', d3.forceManyBody().strength(-200))
-                .force('center', d3.forceCenter(width / 2, height / 2))
-                .force('collision', d3.forceCollide().radius(d => 10 + (d.weight / maxWeight) * 20));
-
-            const link = g.append('g')
-                .selectAll('line')
-                .data(links)
-                .join('line')
-                .attr('stroke', '#58a6ff')
-                .attr('stroke-opacity', d => 0.2 + (d.value / maxLink) * 0.6)
-                .attr('stroke-width', d => 1 + (d.value / maxLink) * 4);
-
-            const node = g.append('g')
-                .selectAll('g')
-                .data(nodes)
-                .join('g')
-                .call(d3.drag()
-                    .on('start', (event, d) => {
-                        if (!event.active) simulation.alphaTarget(0.3).restart();
-                        d.fx = d.x; d.fy = d.y;
-                    })
-                    .on('drag', (event, d) => { d.fx = event.x; d.fy = event.y; })
-                    .on('end', (event, d) => {
-                        if (!event.active) simulation.alphaTarget(0);
-                        d.fx = null; d.fy = null;
-                    }));
-
-            node.append('circle')
-                .attr('r', d => 6 + (d.weight / maxWeight) * 14)
-                .attr('fill', d => {
-                    const t = d.weight / maxWeight;
-                    return d3.interpolateRgb('#388bfd', '#58a6ff')(t);
-                })
-                .attr('stroke', '#0d1117')
-                .attr('stroke-width', 2);
-
-            node.append('text')
-                .text(d => d.id.substring(0, 8))
-                .attr('x', 0)
-                .attr('y', d => -(10 + (d.weight / maxWeight) * 14))
-                .attr('text-anchor', 'middle')
-                .attr('fill', '#8b949e')
-                .attr('font-size', '10px')
-                .attr('font-family', 'monospace')
-                .style('pointer-events', 'none');
-
-            const tooltip = d3.select('#graph-container')
-                .append('div')
-                .style('position', 'absolute')
-                .style('background', '#161b22')
-                .style('border', '1px solid #30363d')
-                .style('border-radius', '6px')
-                .style('padding', '8px 12px')
-                .style('font-size', '11px')
-                .style('font-family', 'monospace')
-                .style('color', '#c9d1d9')
-                .style('pointer-events', 'none')
-                .style('opacity', 0)
-                .style('z-index', 100);
-
-            node.on('mouseover', (event, d) => {
-                tooltip.html(d.full + '<br><span style="color:#58a6ff">Weight: ' + d.weight.toLocaleString() + '</span>')
-                    .style('left', (event.offsetX + 10) + 'px')
-                    .style('top', (event.offsetY - 10) + 'px')
-                    .style('opacity', 1);
-            }).on('mouseout', () => tooltip.style('opacity', 0));
-
-            simulation.on('tick', () => {
-                link.attr('x1', d => d.source.x).attr('y1', d => d.source.y)
-                    .attr('x2', d => d.target.x).attr('y2', d => d.target.y);
-                node.attr('transform', d => 'translate(' + d.x + ',' + d.y + ')');
-            });
-        })();
-        </script>
-        {{end}}
-    </div>
-</body>
-</html>`
@@ -0,0 +1,65 @@
+package stats
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/api"
+	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
+)
+
+type AuditHandler struct {
+	storage *storage.Storage
+}
+
+func NewAuditHandler(store *storage.Storage) *AuditHandler {
+	return &AuditHandler{storage: store}
+}
+
+type AuditEntryDisplay struct {
+	storage.AuditEntry
+	CreatedAtAgo string
+}
+
+type AuditPageData struct {
+	Entries []AuditEntryDisplay
+}
+
+func (h *AuditHandler) HandleAudit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.Background()
+
+		entries, err := h.storage.GetAuditLog(ctx, 500)
+		if err != nil {
+			http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+			return
+		}
+
+		data := AuditPageData{Entries: make([]AuditEntryDisplay, len(entries))}
+		for i, e := range entries {
+			data.Entries[i] = AuditEntryDisplay{AuditEntry: e, CreatedAtAgo: formatTimeAgo(time.Since(e.CreatedAt))}
+		}
+
+		tmpl, err := templates.Get("audit", nil)
+		if err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, data); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// AuditActor identifies who made an admin request: the basic auth username when
+// one was given, otherwise the client address
+func AuditActor(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+	return api.ClientIP(r)
+}
@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
+)
+
+type BillingHandler struct {
+	storage *storage.Storage
+}
+
+func NewBillingHandler(store *storage.Storage) *BillingHandler {
+	return &BillingHandler{storage: store}
+}
+
+type BillingKeyDisplay struct {
+	storage.BillingKey
+	ShortPubkey string
+	CreatedAgo  string
+	Active      bool
+	ExpiresIn   string
+}
+
+type BillingPageData struct {
+	Totals storage.BillingTotals
+	Keys   []BillingKeyDisplay
+}
+
+func (h *BillingHandler) HandleBilling() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.Background()
+
+		totals, err := h.storage.GetBillingTotals(ctx)
+		if err != nil {
+			http.Error(w, "Failed to load billing totals", http.StatusInternalServerError)
+			return
+		}
+		keys, err := h.storage.GetBillingKeys(ctx, false, 500)
+		if err != nil {
+			http.Error(w, "Failed to load billing keys", http.StatusInternalServerError)
+			return
+		}
+
+		now := time.Now()
+		data := BillingPageData{Totals: totals, Keys: make([]BillingKeyDisplay, len(keys))}
+		for i, k := range keys {
+			display := BillingKeyDisplay{
+				BillingKey: k,
+				CreatedAgo: formatTimeAgo(now.Sub(k.CreatedAt)),
+				Active:     k.ExpiresAt.After(now),
+			}
+			if k.Pubkey != "" {
+				display.ShortPubkey = shortPubkey(k.Pubkey)
+			}
+			if display.Active {
+				display.ExpiresIn = formatDuration(k.ExpiresAt.Sub(now))
+			} else {
+				display.ExpiresIn = "expired " + formatTimeAgo(now.Sub(k.ExpiresAt))
+			}
+			data.Keys[i] = display
+		}
+
+		tmpl, err := templates.Get("billing", nil)
+		if err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, data); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
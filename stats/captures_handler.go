@@ -0,0 +1,225 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/capture"
+	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
+)
+
+// defaultCaptureTokenTTL is how long a debug token can start captures when
+// ttl_minutes isn't given
+const defaultCaptureTokenTTL = time.Hour
+
+// CapturesHandler serves the session captures of connections opened with a debug token
+type CapturesHandler struct {
+	storage  *storage.Storage
+	captures *capture.Captures
+}
+
+func NewCapturesHandler(store *storage.Storage, captures *capture.Captures) *CapturesHandler {
+	return &CapturesHandler{storage: store, captures: captures}
+}
+
+type CaptureSummaryDisplay struct {
+	capture.Summary
+	StartedAtAgo string
+	SizeDisplay  string
+}
+
+type CapturesPageData struct {
+	Tokens   []capture.Token
+	Captures []CaptureSummaryDisplay
+}
+
+type CaptureFrameDisplay struct {
+	capture.Frame
+	Offset  string
+	Payload string // Frame.Frame, which the embedded Frame hides from templates
+}
+
+type CapturePageData struct {
+	Header    capture.Header
+	StartedAt time.Time
+	Frames    []CaptureFrameDisplay
+}
+
+// HandleCaptures lists the unexpired debug tokens and the captures on disk
+func (h *CapturesHandler) HandleCaptures() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		summaries, err := h.captures.List()
+		if err != nil {
+			http.Error(w, "Failed to list captures", http.StatusInternalServerError)
+			return
+		}
+
+		data := CapturesPageData{Tokens: h.captures.Tokens(), Captures: make([]CaptureSummaryDisplay, len(summaries))}
+		for i, s := range summaries {
+			data.Captures[i] = CaptureSummaryDisplay{
+				Summary:      s,
+				StartedAtAgo: formatTimeAgo(time.Since(time.Unix(s.StartedAt, 0))),
+				SizeDisplay:  FormatBytes(s.Size),
+			}
+		}
+
+		tmpl, err := templates.Get("captures", nil)
+		if err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, data); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// HandleCapture shows the frames of one capture in order
+func (h *CapturesHandler) HandleCapture() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header, frames, err := h.captures.Read(r.PathValue("id"))
+		if errors.Is(err, capture.ErrNotFound) {
+			http.Error(w, "Unknown capture", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to read capture", http.StatusInternalServerError)
+			return
+		}
+
+		data := CapturePageData{Header: *header, StartedAt: time.Unix(header.StartedAt, 0).UTC(), Frames: make([]CaptureFrameDisplay, len(frames))}
+		for i, f := range frames {
+			data.Frames[i] = CaptureFrameDisplay{Frame: f, Offset: fmt.Sprintf("+%.3fs", float64(f.T)/1000), Payload: string(f.Frame)}
+		}
+
+		tmpl, err := templates.Get("capture", nil)
+		if err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, data); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// HandleIssueToken serves POST /api/v1/admin/captures with a body of
+// {"note": "...", "ttl_minutes": 60}. Connections opening the relay with
+// ?debug=<token> before it expires are captured, up to a few per token.
+func (h *CapturesHandler) HandleIssueToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Note       string `json:"note"`
+			TTLMinutes int    `json:"ttl_minutes"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		body.Note = strings.TrimSpace(body.Note)
+		if body.Note == "" {
+			http.Error(w, "A note saying what is being debugged is required", http.StatusBadRequest)
+			return
+		}
+		ttl := defaultCaptureTokenTTL
+		if body.TTLMinutes > 0 {
+			ttl = time.Duration(body.TTLMinutes) * time.Minute
+		}
+
+		actor := AuditActor(r)
+		token, err := h.captures.Issue(body.Note, actor, ttl)
+		if err != nil {
+			http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+			return
+		}
+
+		if err := h.storage.RecordAdminAction(r.Context(), actor, storage.AuditIssueCaptureToken, token.Note, 1); err != nil {
+			log.Printf("Failed to record capture token in audit log: %v", err)
+		}
+
+		scheme := "ws"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "wss"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      token.Token,
+			"note":       token.Note,
+			"expires_at": token.ExpiresAt.Unix(),
+			"relay_url":  scheme + "://" + r.Host + "/?debug=" + token.Token,
+		})
+	}
+}
+
+// HandleRevokeToken serves DELETE /api/v1/admin/captures/tokens/{token}.
+// Captures already started with it keep recording.
+func (h *CapturesHandler) HandleRevokeToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.captures.Revoke(r.PathValue("token")) {
+			http.Error(w, "Unknown token", http.StatusNotFound)
+			return
+		}
+
+		if err := h.storage.RecordAdminAction(r.Context(), AuditActor(r), storage.AuditRevokeCaptureToken, "", 1); err != nil {
+			log.Printf("Failed to record capture token revocation in audit log: %v", err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleDownload serves GET /api/v1/admin/captures/{id}, the capture as JSONL:
+// a header line, then a line per frame
+func (h *CapturesHandler) HandleDownload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		f, err := h.captures.Open(id)
+		if errors.Is(err, capture.ErrNotFound) {
+			http.Error(w, "Unknown capture", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to read capture", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", "application/jsonl")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="capture-%s.jsonl"`, id))
+		io.Copy(w, f)
+	}
+}
+
+// HandleDelete serves DELETE /api/v1/admin/captures/{id}
+func (h *CapturesHandler) HandleDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		err := h.captures.Delete(id)
+		if errors.Is(err, capture.ErrNotFound) {
+			http.Error(w, "Unknown capture", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete capture", http.StatusInternalServerError)
+			return
+		}
+
+		if err := h.storage.RecordAdminAction(r.Context(), AuditActor(r), storage.AuditDeleteCapture, id, 1); err != nil {
+			log.Printf("Failed to record capture removal in audit log: %v", err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,105 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pablof7z/purplepag.es/changelog"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// ChangelogHandler is the admin API for recording policy changes on the public changelog
+type ChangelogHandler struct {
+	storage   *storage.Storage
+	changelog *changelog.Changelog
+}
+
+func NewChangelogHandler(store *storage.Storage, cl *changelog.Changelog) *ChangelogHandler {
+	return &ChangelogHandler{storage: store, changelog: cl}
+}
+
+// HandleAdd serves POST /api/v1/admin/changelog with a body of
+// {"title": "...", "body": "...", "publish": bool}. publish signs the entry
+// as a kind 1 note from the relay key, and needs changelog.key.
+func (h *ChangelogHandler) HandleAdd() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Title   string `json:"title"`
+			Body    string `json:"body"`
+			Publish bool   `json:"publish"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		body.Title = strings.TrimSpace(body.Title)
+		if body.Title == "" {
+			http.Error(w, "A title is required", http.StatusBadRequest)
+			return
+		}
+		if body.Publish && !h.changelog.CanPublish() {
+			http.Error(w, "Set changelog.key to publish entries as notes", http.StatusBadRequest)
+			return
+		}
+
+		actor := AuditActor(r)
+		entry, err := h.changelog.Record(r.Context(), body.Title, strings.TrimSpace(body.Body), actor, body.Publish)
+		if entry == nil {
+			http.Error(w, "Failed to record entry", http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			log.Printf("changelog: entry %d: %v", entry.ID, err)
+		}
+
+		if err := h.storage.RecordAdminAction(r.Context(), actor, storage.AuditAddChangelog, fmt.Sprintf("%d %s", entry.ID, entry.Title), 1); err != nil {
+			log.Printf("Failed to record changelog entry in audit log: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			// Recorded, but not published
+			w.WriteHeader(http.StatusBadGateway)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":         entry.ID,
+			"title":      entry.Title,
+			"created_at": entry.CreatedAt.Unix(),
+			"note_id":    entry.NoteID,
+		})
+	}
+}
+
+// HandleDelete serves DELETE /api/v1/admin/changelog/{id}, removing the
+// entry and the note it was published as
+func (h *ChangelogHandler) HandleDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid entry ID", http.StatusBadRequest)
+			return
+		}
+
+		found, err := h.changelog.Remove(r.Context(), id)
+		if err != nil {
+			http.Error(w, "Failed to delete entry", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Unknown entry", http.StatusNotFound)
+			return
+		}
+
+		if err := h.storage.RecordAdminAction(r.Context(), AuditActor(r), storage.AuditDeleteChangelog, strconv.FormatInt(id, 10), 1); err != nil {
+			log.Printf("Failed to record changelog entry removal in audit log: %v", err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
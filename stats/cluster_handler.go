@@ -0,0 +1,361 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/relay"
+	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
+)
+
+// clusterMatrixMembers caps the overlap matrix, which grows with the square of
+// the members shown
+const clusterMatrixMembers = 25
+
+type ClusterMemberDisplay struct {
+	Pubkey          string
+	ShortPubkey     string
+	Name            string
+	Requests        int64
+	Followers       int64
+	FollowsInside   int // members of the cluster this one follows
+	Relays          int
+	IsTrusted       bool
+	IsSpamCandidate bool
+}
+
+type SharedRelayDisplay struct {
+	URL     string
+	Members int
+	Share   string
+}
+
+// OverlapCell compares the follow lists of a matrix row and column member
+type OverlapCell struct {
+	Self    bool
+	Follows bool   // the row member follows the column member
+	Overlap int    // percentage of follows the two share
+	Alpha   string // cell shade, from Overlap
+}
+
+type OverlapRow struct {
+	ShortPubkey string
+	Cells       []OverlapCell
+}
+
+type ClusterPageData struct {
+	Cluster       ClusterDisplay
+	IsActive      bool
+	Members       []ClusterMemberDisplay
+	TotalRequests int64
+	SharedRelays  []SharedRelayDisplay
+	MatrixHeaders []string
+	Matrix        []OverlapRow
+	MatrixCapped  bool
+	Message       string
+}
+
+// HandleCluster serves GET /stats/analytics/cluster?id=N: every member of a bot
+// cluster with what ties them together
+func (h *AnalyticsHandler) HandleCluster() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid cluster id", http.StatusBadRequest)
+			return
+		}
+
+		cluster, err := h.storage.GetBotCluster(ctx, id)
+		if err != nil {
+			http.Error(w, "Failed to load cluster", http.StatusInternalServerError)
+			return
+		}
+		if cluster == nil {
+			http.Error(w, "Cluster not found", http.StatusNotFound)
+			return
+		}
+
+		data := ClusterPageData{
+			Cluster: ClusterDisplay{
+				ID:              cluster.ID,
+				Size:            cluster.Size,
+				InternalDensity: fmt.Sprintf("%.1f%%", cluster.InternalDensity*100),
+				ExternalRatio:   fmt.Sprintf("%.1f%%", cluster.ExternalRatio*100),
+				DetectedAgo:     formatTimeAgo(time.Since(cluster.DetectedAt)),
+			},
+			IsActive: cluster.IsActive,
+			Message:  r.URL.Query().Get("message"),
+		}
+
+		members := cluster.Members
+		names, _ := h.storage.GetProfileNames(ctx, members)
+		requests, _ := h.storage.GetRequestCounts(ctx, members)
+		spam, _ := h.trustAnalyzer.GetSpamCandidates(ctx, 10000)
+		isSpam := make(map[string]bool, len(spam))
+		for _, c := range spam {
+			isSpam[c.Pubkey] = true
+		}
+
+		follows, relays := h.clusterLists(ctx, members)
+
+		inCluster := make(map[string]bool, len(members))
+		for _, m := range members {
+			inCluster[m] = true
+		}
+
+		relayMembers := make(map[string]int)
+		for _, m := range members {
+			followers, _ := h.storage.GetFollowerCount(ctx, m)
+			inside := 0
+			for f := range follows[m] {
+				if inCluster[f] {
+					inside++
+				}
+			}
+			for _, u := range relays[m] {
+				relayMembers[u]++
+			}
+
+			data.TotalRequests += requests[m]
+			data.Members = append(data.Members, ClusterMemberDisplay{
+				Pubkey:          m,
+				ShortPubkey:     shortPubkey(m),
+				Name:            names[m],
+				Requests:        requests[m],
+				Followers:       followers,
+				FollowsInside:   inside,
+				Relays:          len(relays[m]),
+				IsTrusted:       h.trustAnalyzer.IsTrusted(m),
+				IsSpamCandidate: isSpam[m],
+			})
+		}
+		// Members clients actually ask for are the ones worth a closer look
+		sort.SliceStable(data.Members, func(i, j int) bool {
+			return data.Members[i].Requests > data.Members[j].Requests
+		})
+
+		for u, n := range relayMembers {
+			// A relay one member uses says nothing about the cluster
+			if n < 2 {
+				continue
+			}
+			data.SharedRelays = append(data.SharedRelays, SharedRelayDisplay{
+				URL:     u,
+				Members: n,
+				Share:   fmt.Sprintf("%.0f%%", float64(n)*100/float64(len(members))),
+			})
+		}
+		sort.Slice(data.SharedRelays, func(i, j int) bool {
+			a, b := data.SharedRelays[i], data.SharedRelays[j]
+			if a.Members != b.Members {
+				return a.Members > b.Members
+			}
+			return a.URL < b.URL
+		})
+
+		shown := data.Members
+		if len(shown) > clusterMatrixMembers {
+			shown = shown[:clusterMatrixMembers]
+			data.MatrixCapped = true
+		}
+		for _, col := range shown {
+			data.MatrixHeaders = append(data.MatrixHeaders, col.ShortPubkey)
+		}
+		for _, row := range shown {
+			matrixRow := OverlapRow{ShortPubkey: row.ShortPubkey}
+			for _, col := range shown {
+				if row.Pubkey == col.Pubkey {
+					matrixRow.Cells = append(matrixRow.Cells, OverlapCell{Self: true})
+					continue
+				}
+				overlap := followOverlap(follows[row.Pubkey], follows[col.Pubkey])
+				matrixRow.Cells = append(matrixRow.Cells, OverlapCell{
+					Follows: follows[row.Pubkey][col.Pubkey],
+					Overlap: overlap,
+					Alpha:   fmt.Sprintf("%.2f", float64(overlap)/100*0.6),
+				})
+			}
+			data.Matrix = append(data.Matrix, matrixRow)
+		}
+
+		tmpl, err := templates.Get("cluster", nil)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, data); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// clusterLists returns each member's latest follow list and the relays it
+// writes to
+func (h *AnalyticsHandler) clusterLists(ctx context.Context, members []string) (map[string]map[string]bool, map[string][]string) {
+	follows := make(map[string]map[string]bool)
+	relays := make(map[string][]string)
+	if len(members) == 0 {
+		return follows, relays
+	}
+
+	events, err := h.storage.QueryEvents(ctx, nostr.Filter{Kinds: []int{3, 10002}, Authors: members})
+	if err != nil {
+		log.Printf("Failed to load cluster member lists: %v", err)
+		return follows, relays
+	}
+
+	latest := make(map[string]map[int]*nostr.Event)
+	for _, evt := range events {
+		byKind := latest[evt.PubKey]
+		if byKind == nil {
+			byKind = make(map[int]*nostr.Event)
+			latest[evt.PubKey] = byKind
+		}
+		if existing, ok := byKind[evt.Kind]; !ok || evt.CreatedAt > existing.CreatedAt {
+			byKind[evt.Kind] = evt
+		}
+	}
+
+	for pubkey, byKind := range latest {
+		if evt := byKind[3]; evt != nil {
+			set := make(map[string]bool)
+			for _, tag := range evt.Tags {
+				if len(tag) >= 2 && tag[0] == "p" {
+					set[tag[1]] = true
+				}
+			}
+			follows[pubkey] = set
+		}
+		if evt := byKind[10002]; evt != nil {
+			seen := make(map[string]bool)
+			for _, tag := range evt.Tags {
+				if len(tag) < 2 || tag[0] != "r" || (len(tag) >= 3 && tag[2] == "read") {
+					continue
+				}
+				u, err := relay.NormalizeRelayURL(tag[1])
+				if err != nil || seen[u] {
+					continue
+				}
+				seen[u] = true
+				relays[pubkey] = append(relays[pubkey], u)
+			}
+		}
+	}
+
+	return follows, relays
+}
+
+// followOverlap is the Jaccard similarity of two follow lists, as a percentage
+func followOverlap(a, b map[string]bool) int {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for f := range a {
+		if b[f] {
+			shared++
+		}
+	}
+	return shared * 100 / (len(a) + len(b) - shared)
+}
+
+// HandleClusterSpam marks a cluster's members, or one member if a pubkey is
+// given, as spam candidates for the next purge
+func (h *AnalyticsHandler) HandleClusterSpam() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid cluster id", http.StatusBadRequest)
+			return
+		}
+		cluster, err := h.storage.GetBotCluster(ctx, id)
+		if err != nil {
+			http.Error(w, "Failed to load cluster", http.StatusInternalServerError)
+			return
+		}
+		if cluster == nil {
+			http.Error(w, "Cluster not found", http.StatusNotFound)
+			return
+		}
+
+		pubkeys := cluster.Members
+		if pubkey := r.FormValue("pubkey"); pubkey != "" {
+			if !slices.Contains(cluster.Members, pubkey) {
+				http.Error(w, "Pubkey is not a member of this cluster", http.StatusBadRequest)
+				return
+			}
+			pubkeys = []string{pubkey}
+		}
+
+		marked := 0
+		for _, pubkey := range pubkeys {
+			// The trust analyzer never flags trusted pubkeys, and neither do we
+			if h.trustAnalyzer.IsTrusted(pubkey) {
+				continue
+			}
+			eventCount, _ := h.storage.CountEventsForPubkey(ctx, pubkey)
+			if err := h.storage.SaveSpamCandidate(ctx, pubkey, "bot_cluster", eventCount); err != nil {
+				http.Error(w, "Failed to mark spam", http.StatusInternalServerError)
+				return
+			}
+			marked++
+		}
+
+		details := fmt.Sprintf("marked %d members of cluster #%d as spam", marked, id)
+		if err := h.storage.RecordAdminAction(ctx, AuditActor(r), storage.AuditMarkClusterSpam, details, int64(marked)); err != nil {
+			log.Printf("Failed to record cluster spam marking in audit log: %v", err)
+		}
+
+		redirectToCluster(w, r, id, fmt.Sprintf("Marked %d members as spam candidates; purge them from the analytics page", marked))
+	}
+}
+
+// HandleClusterExempt takes a member wrongly caught in a cluster out of it for
+// good
+func (h *AnalyticsHandler) HandleClusterExempt() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid cluster id", http.StatusBadRequest)
+			return
+		}
+		pubkey := r.FormValue("pubkey")
+		if !nostr.IsValid32ByteHex(pubkey) {
+			http.Error(w, "Invalid pubkey", http.StatusBadRequest)
+			return
+		}
+
+		if err := h.storage.ExemptFromBotClusters(ctx, pubkey); err != nil {
+			http.Error(w, "Failed to exempt member", http.StatusInternalServerError)
+			return
+		}
+
+		details := fmt.Sprintf("exempted %s from cluster #%d", pubkey, id)
+		if err := h.storage.RecordAdminAction(ctx, AuditActor(r), storage.AuditExemptClusterMember, details, 1); err != nil {
+			log.Printf("Failed to record cluster exemption in audit log: %v", err)
+		}
+
+		redirectToCluster(w, r, id, "Exempted "+shortPubkey(pubkey)+" from bot clusters")
+	}
+}
+
+func redirectToCluster(w http.ResponseWriter, r *http.Request, id int64, message string) {
+	http.Redirect(w, r, fmt.Sprintf("/stats/analytics/cluster?id=%d&message=%s", id, url.QueryEscape(message)), http.StatusSeeOther)
+}
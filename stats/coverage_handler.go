@@ -0,0 +1,231 @@
+package stats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
+)
+
+const (
+	// Newest events younger than coverageFreshAge count as fresh, older than
+	// coverageStaleAge as stale
+	coverageFreshAge = 30 * 24 * time.Hour
+	coverageStaleAge = 365 * 24 * time.Hour
+
+	// coverageMaxRows caps the matrix rows rendered; the CSV export has every pubkey
+	coverageMaxRows = 200
+)
+
+type CoverageKindSummary struct {
+	Kind      int
+	Have      int
+	Percent   string
+	Fresh     int
+	Aging     int
+	Stale     int
+	MedianAge string
+}
+
+type CoverageCell struct {
+	Age   string
+	Class string // fresh, aging, stale or missing
+}
+
+type CoverageRow struct {
+	Pubkey      string
+	ShortPubkey string
+	LastSyncAgo string
+	Have        int
+	Cells       []CoverageCell
+}
+
+type CoveragePageData struct {
+	Kinds          []int
+	TrustedPubkeys int
+	NeverSynced    int
+	SyncedToday    int
+	CoveredPercent string
+	Summaries      []CoverageKindSummary
+	Rows           []CoverageRow
+}
+
+// CoverageHandler shows which of the trusted sync kinds we hold for each trusted
+// pubkey, to tell whether trusted sync and hydration keep up
+type CoverageHandler struct {
+	storage *storage.Storage
+	kinds   []int
+}
+
+func NewCoverageHandler(store *storage.Storage, kinds []int) *CoverageHandler {
+	sorted := append([]int(nil), kinds...)
+	sort.Ints(sorted)
+	return &CoverageHandler{storage: store, kinds: sorted}
+}
+
+func (h *CoverageHandler) HandleCoverage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		coverage, err := h.storage.GetTrustedKindCoverage(r.Context(), h.kinds)
+		if err != nil {
+			http.Error(w, "Failed to load coverage", http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "csv" {
+			h.writeCSV(w, coverage)
+			return
+		}
+
+		tmpl, err := templates.Get("coverage", nil)
+		if err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, h.pageData(coverage, time.Now())); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+func (h *CoverageHandler) pageData(coverage []storage.KindCoverage, now time.Time) CoveragePageData {
+	data := CoveragePageData{Kinds: h.kinds, TrustedPubkeys: len(coverage)}
+
+	ages := make(map[int][]time.Duration, len(h.kinds))
+	covered := 0
+	for _, c := range coverage {
+		if c.LastSyncedAt == 0 {
+			data.NeverSynced++
+		} else if now.Sub(time.Unix(c.LastSyncedAt, 0)) < 24*time.Hour {
+			data.SyncedToday++
+		}
+		for kind, latest := range c.Latest {
+			ages[kind] = append(ages[kind], now.Sub(time.Unix(latest, 0)))
+		}
+		covered += len(c.Latest)
+	}
+	data.CoveredPercent = percent(covered, len(coverage)*len(h.kinds))
+
+	for _, kind := range h.kinds {
+		kindAges := ages[kind]
+		summary := CoverageKindSummary{Kind: kind, Have: len(kindAges), Percent: percent(len(kindAges), len(coverage)), MedianAge: "-"}
+		for _, age := range kindAges {
+			switch coverageClass(age) {
+			case "fresh":
+				summary.Fresh++
+			case "aging":
+				summary.Aging++
+			default:
+				summary.Stale++
+			}
+		}
+		if len(kindAges) > 0 {
+			sort.Slice(kindAges, func(i, j int) bool { return kindAges[i] < kindAges[j] })
+			summary.MedianAge = compactAge(kindAges[len(kindAges)/2])
+		}
+		data.Summaries = append(data.Summaries, summary)
+	}
+
+	// Pubkeys with the fewest kinds first, then those trusted sync visited longest ago
+	worst := append([]storage.KindCoverage(nil), coverage...)
+	sort.SliceStable(worst, func(i, j int) bool {
+		if len(worst[i].Latest) != len(worst[j].Latest) {
+			return len(worst[i].Latest) < len(worst[j].Latest)
+		}
+		return worst[i].LastSyncedAt < worst[j].LastSyncedAt
+	})
+	if len(worst) > coverageMaxRows {
+		worst = worst[:coverageMaxRows]
+	}
+
+	for _, c := range worst {
+		row := CoverageRow{
+			Pubkey:      c.Pubkey,
+			ShortPubkey: shortPubkey(c.Pubkey),
+			LastSyncAgo: timeAgo(now, time.Unix(c.LastSyncedAt, 0)),
+			Have:        len(c.Latest),
+			Cells:       make([]CoverageCell, len(h.kinds)),
+		}
+		for i, kind := range h.kinds {
+			latest, ok := c.Latest[kind]
+			if !ok {
+				row.Cells[i] = CoverageCell{Age: "-", Class: "missing"}
+				continue
+			}
+			age := now.Sub(time.Unix(latest, 0))
+			row.Cells[i] = CoverageCell{Age: compactAge(age), Class: coverageClass(age)}
+		}
+		data.Rows = append(data.Rows, row)
+	}
+
+	return data
+}
+
+// writeCSV exports the full matrix: one row per trusted pubkey, one column per
+// kind holding the created_at of the newest stored event, empty when missing
+func (h *CoverageHandler) writeCSV(w http.ResponseWriter, coverage []storage.KindCoverage) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="coverage-%s.csv"`, time.Now().UTC().Format("2006-01-02")))
+
+	cw := csv.NewWriter(w)
+	header := []string{"pubkey", "last_synced_at"}
+	for _, kind := range h.kinds {
+		header = append(header, "kind_"+strconv.Itoa(kind))
+	}
+	cw.Write(header)
+
+	record := make([]string, len(header))
+	for _, c := range coverage {
+		record[0] = c.Pubkey
+		record[1] = ""
+		if c.LastSyncedAt > 0 {
+			record[1] = strconv.FormatInt(c.LastSyncedAt, 10)
+		}
+		for i, kind := range h.kinds {
+			record[i+2] = ""
+			if latest, ok := c.Latest[kind]; ok {
+				record[i+2] = strconv.FormatInt(latest, 10)
+			}
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+}
+
+func coverageClass(age time.Duration) string {
+	switch {
+	case age < coverageFreshAge:
+		return "fresh"
+	case age < coverageStaleAge:
+		return "aging"
+	default:
+		return "stale"
+	}
+}
+
+// compactAge formats an event age for a matrix cell: 5h, 3d, 4mo, 2y
+func compactAge(d time.Duration) string {
+	switch {
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	case d < 60*24*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d < 2*365*24*time.Hour:
+		return fmt.Sprintf("%dmo", int(d.Hours()/24/30))
+	default:
+		return fmt.Sprintf("%dy", int(d.Hours()/24/365))
+	}
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(n)*100/float64(total))
+}
@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+type DuplicateGroupDisplay struct {
+	storage.DuplicateProfileGroup
+	DetectedAgo string
+}
+
+// HandlePurgeDuplicates deletes the events of the untrusted members of a
+// duplicate profile group and marks them purged spam
+func (h *AnalyticsHandler) HandlePurgeDuplicates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		group, err := h.storage.GetDuplicateProfileGroup(ctx, r.FormValue("hash"))
+		if err != nil {
+			http.Error(w, "Failed to load duplicate group", http.StatusInternalServerError)
+			return
+		}
+		if group == nil {
+			http.Error(w, "Duplicate group not found", http.StatusNotFound)
+			return
+		}
+
+		// Members were untrusted when detected; one trusted since is spared
+		var pubkeys []string
+		for _, pubkey := range group.Members {
+			if !h.trustAnalyzer.IsTrusted(pubkey) {
+				pubkeys = append(pubkeys, pubkey)
+			}
+		}
+
+		reason := fmt.Sprintf("%d identical profiles", group.Size)
+		if group.Basis == storage.DuplicateBasisPicture {
+			reason = fmt.Sprintf("%d profiles sharing a picture", group.Size)
+		}
+		for _, pubkey := range pubkeys {
+			eventCount, _ := h.storage.CountEventsForPubkey(ctx, pubkey)
+			if err := h.storage.SaveSpamCandidate(ctx, pubkey, reason, eventCount); err != nil {
+				http.Error(w, "Failed to mark spam", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		deleted, err := h.storage.DeleteEventsForPubkeys(ctx, pubkeys)
+		if err != nil {
+			http.Error(w, "Failed to delete events", http.StatusInternalServerError)
+			return
+		}
+		if err := h.storage.MarkSpamPurged(ctx, pubkeys); err != nil {
+			http.Error(w, "Failed to mark as purged", http.StatusInternalServerError)
+			return
+		}
+		cleaned, err := h.storage.PurgeAnalyticsForPubkeys(ctx, pubkeys)
+		if err != nil {
+			log.Printf("Failed to purge analytics of duplicate profiles: %v", err)
+		}
+		if err := h.storage.DeleteDuplicateProfileGroup(ctx, group.Hash); err != nil {
+			log.Printf("Failed to drop purged duplicate group: %v", err)
+		}
+
+		details := fmt.Sprintf("deleted %d events and %d analytics rows from %d pubkeys of a %s group of %d (%q)",
+			deleted, cleaned.Total(), len(pubkeys), group.Basis, group.Size, group.Name)
+		if err := h.storage.RecordAdminAction(ctx, AuditActor(r), storage.AuditPurgeDuplicates, details, deleted); err != nil {
+			log.Printf("Failed to record duplicate purge in audit log: %v", err)
+		}
+
+		message := fmt.Sprintf("Purged %d events from %d duplicate profiles", deleted, len(pubkeys))
+		http.Redirect(w, r, "/stats/analytics?message="+url.QueryEscape(message), http.StatusSeeOther)
+	}
+}
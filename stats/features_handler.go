@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/pablof7z/purplepag.es/features"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// FeaturesHandler is the admin API for switching heavyweight subsystems on and off
+type FeaturesHandler struct {
+	storage *storage.Storage
+	flags   *features.Flags
+}
+
+func NewFeaturesHandler(store *storage.Storage, flags *features.Flags) *FeaturesHandler {
+	return &FeaturesHandler{storage: store, flags: flags}
+}
+
+type featureJSON struct {
+	Name       string `json:"name"`
+	Enabled    bool   `json:"enabled"`
+	Default    bool   `json:"default"`
+	Overridden bool   `json:"overridden"`
+	UpdatedBy  string `json:"updated_by,omitempty"`
+	UpdatedAt  int64  `json:"updated_at,omitempty"`
+}
+
+// HandleList serves GET /api/v1/admin/features
+func (h *FeaturesHandler) HandleList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flags := h.flags.List()
+		result := make([]featureJSON, len(flags))
+		for i, f := range flags {
+			result[i] = featureJSON{
+				Name:       f.Name,
+				Enabled:    f.Enabled,
+				Default:    f.Default,
+				Overridden: f.Overridden,
+				UpdatedBy:  f.UpdatedBy,
+				UpdatedAt:  unixOrOmit(f.UpdatedAt),
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"features": result})
+	}
+}
+
+// HandleSet serves PUT /api/v1/admin/features/{name} with a body of {"enabled": bool}
+func (h *FeaturesHandler) HandleSet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4*1024)).Decode(&body); err != nil || body.Enabled == nil {
+			http.Error(w, `Invalid JSON body: expected {"enabled": true|false}`, http.StatusBadRequest)
+			return
+		}
+
+		actor := AuditActor(r)
+		if err := h.flags.Set(r.Context(), name, *body.Enabled, actor); err != nil {
+			if errors.Is(err, features.ErrUnknown) {
+				http.Error(w, "Unknown feature", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to save feature", http.StatusInternalServerError)
+			return
+		}
+
+		details := name + " off"
+		if *body.Enabled {
+			details = name + " on"
+		}
+		if err := h.storage.RecordAdminAction(r.Context(), actor, storage.AuditSetFeature, details, 0); err != nil {
+			log.Printf("Failed to record feature toggle in audit log: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "enabled": *body.Enabled, "overridden": true})
+	}
+}
+
+// HandleClear serves DELETE /api/v1/admin/features/{name}, returning the
+// feature to its configured state
+func (h *FeaturesHandler) HandleClear() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if err := h.flags.Clear(r.Context(), name); err != nil {
+			if errors.Is(err, features.ErrUnknown) {
+				http.Error(w, "Unknown feature", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to clear feature", http.StatusInternalServerError)
+			return
+		}
+
+		if err := h.storage.RecordAdminAction(r.Context(), AuditActor(r), storage.AuditClearFeature, name, 0); err != nil {
+			log.Printf("Failed to record feature reset in audit log: %v", err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,59 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+)
+
+// FilterSizeBuckets are the histogram upper bounds for REQ shapes: filters per
+// REQ, and authors and ids per filter
+var FilterSizeBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// FilterSize is a cumulative histogram of one REQ dimension, e.g. "authors"
+type FilterSize struct {
+	Dimension string
+	Buckets   []uint64 // cumulative counts, one per FilterSizeBuckets entry
+	Count     uint64
+	Sum       float64
+}
+
+type filterSizeHistograms struct {
+	mu         sync.Mutex
+	dimensions map[string]*FilterSize
+}
+
+// ObserveFilterSize records the size of one REQ dimension, so the caps in
+// limits can be set from what clients actually send
+func (s *Stats) ObserveFilterSize(dimension string, n int) {
+	s.filterSizes.mu.Lock()
+	defer s.filterSizes.mu.Unlock()
+
+	h, ok := s.filterSizes.dimensions[dimension]
+	if !ok {
+		h = &FilterSize{Dimension: dimension, Buckets: make([]uint64, len(FilterSizeBuckets))}
+		s.filterSizes.dimensions[dimension] = h
+	}
+
+	for i, le := range FilterSizeBuckets {
+		if float64(n) <= le {
+			h.Buckets[i]++
+		}
+	}
+	h.Count++
+	h.Sum += float64(n)
+}
+
+// GetFilterSizes returns a copy of every dimension's histogram, sorted by name
+func (s *Stats) GetFilterSizes() []FilterSize {
+	s.filterSizes.mu.Lock()
+	defer s.filterSizes.mu.Unlock()
+
+	result := make([]FilterSize, 0, len(s.filterSizes.dimensions))
+	for _, h := range s.filterSizes.dimensions {
+		c := *h
+		c.Buckets = append([]uint64(nil), h.Buckets...)
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Dimension < result[j].Dimension })
+	return result
+}
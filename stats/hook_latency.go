@@ -0,0 +1,59 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// HookLatencyBuckets are the histogram upper bounds, in seconds, for relay hook durations
+var HookLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// HookLatency is a cumulative histogram of one hook's durations
+type HookLatency struct {
+	Hook    string
+	Buckets []uint64 // cumulative counts, one per HookLatencyBuckets entry
+	Count   uint64
+	Sum     float64
+}
+
+type hookHistograms struct {
+	mu    sync.Mutex
+	hooks map[string]*HookLatency
+}
+
+// ObserveHook records how long a khatru hook (or a named step inside one) took
+func (s *Stats) ObserveHook(hook string, d time.Duration) {
+	s.hookLatency.mu.Lock()
+	defer s.hookLatency.mu.Unlock()
+
+	h, ok := s.hookLatency.hooks[hook]
+	if !ok {
+		h = &HookLatency{Hook: hook, Buckets: make([]uint64, len(HookLatencyBuckets))}
+		s.hookLatency.hooks[hook] = h
+	}
+
+	seconds := d.Seconds()
+	for i, le := range HookLatencyBuckets {
+		if seconds <= le {
+			h.Buckets[i]++
+		}
+	}
+	h.Count++
+	h.Sum += seconds
+}
+
+// GetHookLatencies returns a copy of every hook histogram, sorted by hook name
+func (s *Stats) GetHookLatencies() []HookLatency {
+	s.hookLatency.mu.Lock()
+	defer s.hookLatency.mu.Unlock()
+
+	result := make([]HookLatency, 0, len(s.hookLatency.hooks))
+	for _, h := range s.hookLatency.hooks {
+		c := *h
+		c.Buckets = append([]uint64(nil), h.Buckets...)
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Hook < result[j].Hook })
+	return result
+}
@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/api"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// HostedNamesHandler is the admin API for the NIP-05 names served from
+// /.well-known/nostr.json
+type HostedNamesHandler struct {
+	storage *storage.Storage
+	names   *api.HostedNames
+}
+
+func NewHostedNamesHandler(store *storage.Storage, names *api.HostedNames) *HostedNamesHandler {
+	return &HostedNamesHandler{storage: store, names: names}
+}
+
+type hostedNameJSON struct {
+	Name      string   `json:"name"`
+	Pubkey    string   `json:"pubkey"`
+	Relays    []string `json:"relays,omitempty"`
+	CreatedBy string   `json:"created_by,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+// HandleList serves GET /api/v1/admin/nip05
+func (h *HostedNamesHandler) HandleList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hosted, err := h.storage.GetHostedNames(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to load names", http.StatusInternalServerError)
+			return
+		}
+
+		result := make([]hostedNameJSON, len(hosted))
+		for i, n := range hosted {
+			result[i] = hostedNameJSON{Name: n.Name, Pubkey: n.Pubkey, Relays: n.Relays, CreatedBy: n.CreatedBy, CreatedAt: n.CreatedAt.Unix()}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"names": result})
+	}
+}
+
+// HandleSet serves PUT /api/v1/admin/nip05/{name} with a body of
+// {"pubkey": "<hex>", "relays": ["wss://..."]}
+func (h *HostedNamesHandler) HandleSet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !storage.ValidHostedName(name) {
+			http.Error(w, "Invalid name: use lowercase a-z, 0-9, '.', '-' or '_'", http.StatusBadRequest)
+			return
+		}
+
+		var body struct {
+			Pubkey string   `json:"pubkey"`
+			Relays []string `json:"relays"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if !nostr.IsValid32ByteHex(body.Pubkey) {
+			http.Error(w, "Invalid pubkey", http.StatusBadRequest)
+			return
+		}
+		for _, relay := range body.Relays {
+			if !nostr.IsValidRelayURL(relay) {
+				http.Error(w, "Invalid relay URL: "+relay, http.StatusBadRequest)
+				return
+			}
+		}
+
+		actor := AuditActor(r)
+		n := storage.HostedName{Name: name, Pubkey: body.Pubkey, Relays: body.Relays, CreatedBy: actor, CreatedAt: time.Now()}
+		if err := h.storage.SetHostedName(r.Context(), n); err != nil {
+			http.Error(w, "Failed to save name", http.StatusInternalServerError)
+			return
+		}
+		h.names.Reload(r.Context())
+
+		if err := h.storage.RecordAdminAction(r.Context(), actor, storage.AuditSetNip05Name, name+" -> "+body.Pubkey, 1); err != nil {
+			log.Printf("Failed to record NIP-05 name in audit log: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hostedNameJSON{Name: n.Name, Pubkey: n.Pubkey, Relays: n.Relays, CreatedBy: n.CreatedBy, CreatedAt: n.CreatedAt.Unix()})
+	}
+}
+
+// HandleDelete serves DELETE /api/v1/admin/nip05/{name}
+func (h *HostedNamesHandler) HandleDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		found, err := h.storage.DeleteHostedName(r.Context(), name)
+		if err != nil {
+			http.Error(w, "Failed to delete name", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Unknown name", http.StatusNotFound)
+			return
+		}
+		h.names.Reload(r.Context())
+
+		if err := h.storage.RecordAdminAction(r.Context(), AuditActor(r), storage.AuditDeleteNip05Name, name, 1); err != nil {
+			log.Printf("Failed to record NIP-05 name removal in audit log: %v", err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
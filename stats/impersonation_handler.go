@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
+)
+
+type ImpersonationHandler struct {
+	storage *storage.Storage
+}
+
+func NewImpersonationHandler(store *storage.Storage) *ImpersonationHandler {
+	return &ImpersonationHandler{storage: store}
+}
+
+type ImpersonationDisplay struct {
+	storage.ImpersonationCandidate
+	TargetName    string
+	TargetPicture string
+	DetectedAgo   string
+}
+
+type ImpersonationPageData struct {
+	Candidates []ImpersonationDisplay
+}
+
+func (h *ImpersonationHandler) HandleImpersonation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.Background()
+
+		candidates, err := h.storage.GetImpersonationCandidates(ctx, 500)
+		if err != nil {
+			http.Error(w, "Failed to load impersonation candidates", http.StatusInternalServerError)
+			return
+		}
+
+		targetPubkeys := make([]string, 0, len(candidates))
+		seen := make(map[string]bool)
+		for _, c := range candidates {
+			if !seen[c.TargetPubkey] {
+				seen[c.TargetPubkey] = true
+				targetPubkeys = append(targetPubkeys, c.TargetPubkey)
+			}
+		}
+		targets, err := h.storage.GetProfileInfo(ctx, targetPubkeys)
+		if err != nil {
+			targets = nil
+		}
+
+		data := ImpersonationPageData{Candidates: make([]ImpersonationDisplay, len(candidates))}
+		for i, c := range candidates {
+			data.Candidates[i] = ImpersonationDisplay{
+				ImpersonationCandidate: c,
+				TargetName:             targets[c.TargetPubkey].Name,
+				TargetPicture:          targets[c.TargetPubkey].Picture,
+				DetectedAgo:            formatTimeAgo(time.Since(c.DetectedAt)),
+			}
+		}
+
+		tmpl, err := templates.Get("impersonation", nil)
+		if err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, data); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
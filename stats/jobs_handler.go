@@ -0,0 +1,106 @@
+package stats
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/jobs"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// JobsHandler reports the status of background jobs in every process sharing
+// the database and lets admins request a run, along with the relay load
+// scheduled runs defer to
+type JobsHandler struct {
+	storage *storage.Storage
+	load    *jobs.LoadMonitor // nil with background_load disabled
+}
+
+func NewJobsHandler(store *storage.Storage, load *jobs.LoadMonitor) *JobsHandler {
+	return &JobsHandler{storage: store, load: load}
+}
+
+type jobStatusJSON struct {
+	Name           string `json:"name"`
+	Process        string `json:"process"`
+	Running        bool   `json:"running"`
+	LastStartedAt  int64  `json:"last_started_at,omitempty"`
+	LastSuccessAt  int64  `json:"last_success_at,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+	LastErrorAt    int64  `json:"last_error_at,omitempty"`
+	LastDurationMs int64  `json:"last_duration_ms"`
+	RunRequested   bool   `json:"run_requested"`
+}
+
+func unixOrOmit(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func (h *JobsHandler) HandleJobs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses, err := h.storage.GetJobStatuses(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to load job status", http.StatusInternalServerError)
+			return
+		}
+
+		result := make([]jobStatusJSON, len(statuses))
+		for i, j := range statuses {
+			result[i] = jobStatusJSON{
+				Name:           j.Name,
+				Process:        j.Process,
+				Running:        j.Running,
+				LastStartedAt:  unixOrOmit(j.LastStartedAt),
+				LastSuccessAt:  unixOrOmit(j.LastSuccessAt),
+				LastError:      j.LastError,
+				LastErrorAt:    unixOrOmit(j.LastErrorAt),
+				LastDurationMs: j.LastDuration.Milliseconds(),
+				RunRequested:   j.RunRequested(),
+			}
+		}
+
+		response := map[string]interface{}{"jobs": result}
+		if h.load != nil {
+			load := h.load.Load()
+			response["load"] = map[string]interface{}{
+				"connections":      load.Connections,
+				"query_latency_ms": float64(load.QueryLatency.Microseconds()) / 1000,
+				"sampled_at":       unixOrOmit(load.SampledAt),
+				"busy":             h.load.Busy(),
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// HandleRunJob requests an immediate run of the job named in the path. The
+// process running the job picks the request up within seconds.
+func (h *JobsHandler) HandleRunJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		found, err := h.storage.RequestJobRun(r.Context(), name)
+		if err != nil {
+			http.Error(w, "Failed to request run", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Unknown job", http.StatusNotFound)
+			return
+		}
+
+		if err := h.storage.RecordAdminAction(r.Context(), AuditActor(r), storage.AuditRunJob, name, 0); err != nil {
+			log.Printf("Failed to record job run in audit log: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"job": name, "run_requested": true})
+	}
+}
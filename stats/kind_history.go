@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/kinds"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// chartedKinds are the kinds whose growth is charted on /stats
+var chartedKinds = []int{0, 3, 10002}
+
+// kindHistorySpan is how far back each granularity looks by default
+var kindHistorySpan = map[string]time.Duration{
+	"day":   90 * 24 * time.Hour,
+	"week":  365 * 24 * time.Hour,
+	"month": 3 * 365 * 24 * time.Hour,
+}
+
+// kindHistoryGranularity reads ?granularity=, defaulting to week
+func kindHistoryGranularity(r *http.Request) string {
+	granularity := r.URL.Query().Get("granularity")
+	if _, ok := kindHistorySpan[granularity]; !ok {
+		return "week"
+	}
+	return granularity
+}
+
+// kindsParam reads ?kinds=, a comma-separated list of kinds; nil when unset
+func kindsParam(r *http.Request) ([]int, error) {
+	raw := r.URL.Query().Get("kinds")
+	if raw == "" {
+		return nil, nil
+	}
+	var wanted []int
+	for _, k := range strings.Split(raw, ",") {
+		kind, err := strconv.Atoi(strings.TrimSpace(k))
+		if err != nil {
+			return nil, fmt.Errorf("kinds must be a comma-separated list of integers")
+		}
+		wanted = append(wanted, kind)
+	}
+	return wanted, nil
+}
+
+// kindHistoryChart is the /stats growth chart: one dataset per charted kind,
+// aligned on the periods any of them was sampled in
+func (s *Stats) kindHistoryChart(ctx context.Context, granularity string) (template.JS, bool) {
+	series, err := s.storage.GetKindCountSeries(ctx, granularity, time.Now().Add(-kindHistorySpan[granularity]), chartedKinds)
+	if err != nil || len(series) == 0 {
+		return "", false
+	}
+
+	periods := make(map[string]bool)
+	for _, ks := range series {
+		for _, p := range ks.Points {
+			periods[p.Date] = true
+		}
+	}
+	labels := make([]string, 0, len(periods))
+	for period := range periods {
+		labels = append(labels, period)
+	}
+	sort.Strings(labels)
+
+	type dataset struct {
+		Label string   `json:"label"`
+		Data  []*int64 `json:"data"` // nil where the kind has no sample
+	}
+	datasets := make([]dataset, 0, len(series))
+	for _, ks := range series {
+		byPeriod := make(map[string]int64, len(ks.Points))
+		for _, p := range ks.Points {
+			byPeriod[p.Date] = p.Count
+		}
+		d := dataset{Label: kinds.Name(ks.Kind), Data: make([]*int64, len(labels))}
+		for i, period := range labels {
+			if count, ok := byPeriod[period]; ok {
+				d.Data[i] = &count
+			}
+		}
+		datasets = append(datasets, d)
+	}
+
+	chartJSON, _ := json.Marshal(map[string]interface{}{
+		"labels":   labels,
+		"datasets": datasets,
+	})
+	return template.JS(chartJSON), true
+}
+
+// HandleKindCountHistory serves the raw per-kind count samples behind the /stats
+// growth chart. ?granularity= is day, week (default) or month, ?days= how far
+// back to look and ?kinds= a comma-separated list of kinds (default: all).
+func (s *Stats) HandleKindCountHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		granularity := kindHistoryGranularity(r)
+		span := kindHistorySpan[granularity]
+		if days, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && days > 0 {
+			span = time.Duration(days) * 24 * time.Hour
+		}
+
+		wanted, err := kindsParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		series, err := s.storage.GetKindCountSeries(r.Context(), granularity, time.Now().Add(-span), wanted)
+		if err != nil {
+			http.Error(w, "Failed to load kind count history", http.StatusInternalServerError)
+			return
+		}
+		if series == nil {
+			series = []storage.KindCountSeries{}
+		}
+		for i := range series {
+			series[i].Name = kinds.Name(series[i].Kind)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"granularity": granularity,
+			"series":      series,
+		})
+	}
+}
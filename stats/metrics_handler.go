@@ -0,0 +1,221 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/pablof7z/purplepag.es/analytics"
+	"github.com/pablof7z/purplepag.es/api"
+	"github.com/pablof7z/purplepag.es/relay"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// MetricsHandler serves Prometheus text-format metrics
+type MetricsHandler struct {
+	storage     *storage.Storage
+	stats       *Stats
+	prefetcher  *analytics.Prefetcher // nil when prefetching is disabled
+	rateLimiter *api.RateLimiter
+	inflight    *relay.InFlight
+	mirror      *relay.Mirror          // nil when mirroring is disabled
+	hydrator    *relay.ProfileHydrator // nil when hydration is disabled
+}
+
+func NewMetricsHandler(store *storage.Storage, stats *Stats, prefetcher *analytics.Prefetcher, rateLimiter *api.RateLimiter, inflight *relay.InFlight, mirror *relay.Mirror, hydrator *relay.ProfileHydrator) *MetricsHandler {
+	return &MetricsHandler{storage: store, stats: stats, prefetcher: prefetcher, rateLimiter: rateLimiter, inflight: inflight, mirror: mirror, hydrator: hydrator}
+}
+
+func (h *MetricsHandler) HandleMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.Background()
+
+		refreshes, err := h.storage.GetDerivedTableRefreshes(ctx)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load metrics: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		fmt.Fprintln(w, "# HELP purplepages_derived_table_refresh_seconds Duration of the last rebuild of a derived table.")
+		fmt.Fprintln(w, "# TYPE purplepages_derived_table_refresh_seconds gauge")
+		for _, r := range refreshes {
+			fmt.Fprintf(w, "purplepages_derived_table_refresh_seconds{table=%q} %g\n", r.TableName, r.Duration.Seconds())
+		}
+
+		fmt.Fprintln(w, "# HELP purplepages_derived_table_rows Row count of a derived table after its last rebuild.")
+		fmt.Fprintln(w, "# TYPE purplepages_derived_table_rows gauge")
+		for _, r := range refreshes {
+			fmt.Fprintf(w, "purplepages_derived_table_rows{table=%q} %d\n", r.TableName, r.Rows)
+		}
+
+		fmt.Fprintln(w, "# HELP purplepages_derived_table_last_refresh_timestamp_seconds Unix time of the last rebuild of a derived table.")
+		fmt.Fprintln(w, "# TYPE purplepages_derived_table_last_refresh_timestamp_seconds gauge")
+		for _, r := range refreshes {
+			fmt.Fprintf(w, "purplepages_derived_table_last_refresh_timestamp_seconds{table=%q} %d\n", r.TableName, r.RefreshedAt.Unix())
+		}
+
+		scan := h.storage.LastScanStats()
+		fmt.Fprintln(w, "# HELP purplepages_event_scan_seconds Duration of the last chunked full event scan.")
+		fmt.Fprintln(w, "# TYPE purplepages_event_scan_seconds gauge")
+		fmt.Fprintf(w, "purplepages_event_scan_seconds %g\n", scan.Duration.Seconds())
+		fmt.Fprintln(w, "# HELP purplepages_event_scan_events Events visited by the last chunked full event scan.")
+		fmt.Fprintln(w, "# TYPE purplepages_event_scan_events gauge")
+		fmt.Fprintf(w, "purplepages_event_scan_events %d\n", scan.Events)
+		fmt.Fprintln(w, "# HELP purplepages_event_scan_chunks Read transactions used by the last chunked full event scan.")
+		fmt.Fprintln(w, "# TYPE purplepages_event_scan_chunks gauge")
+		fmt.Fprintf(w, "purplepages_event_scan_chunks %d\n", scan.Chunks)
+
+		sets := h.storage.GetAuthorSetStats()
+		fmt.Fprintln(w, "# HELP purplepages_author_sets Distinct large REQ author lists currently interned.")
+		fmt.Fprintln(w, "# TYPE purplepages_author_sets gauge")
+		fmt.Fprintf(w, "purplepages_author_sets %d\n", sets.Sets)
+		fmt.Fprintln(w, "# HELP purplepages_author_set_hits_total Large REQ author lists that matched an interned set.")
+		fmt.Fprintln(w, "# TYPE purplepages_author_set_hits_total counter")
+		fmt.Fprintf(w, "purplepages_author_set_hits_total %d\n", sets.Hits)
+		fmt.Fprintln(w, "# HELP purplepages_author_set_misses_total Large REQ author lists interned as a new set.")
+		fmt.Fprintln(w, "# TYPE purplepages_author_set_misses_total counter")
+		fmt.Fprintf(w, "purplepages_author_set_misses_total %d\n", sets.Misses)
+
+		fmt.Fprintln(w, "# HELP purplepages_storage_failures_total Event saves that failed, by failure class.")
+		fmt.Fprintln(w, "# TYPE purplepages_storage_failures_total counter")
+		for class, count := range h.stats.GetStorageFailures() {
+			fmt.Fprintf(w, "purplepages_storage_failures_total{class=%q} %d\n", class, count)
+		}
+
+		pools := h.storage.GetPoolStats()
+		fmt.Fprintln(w, "# HELP purplepages_db_pool_max_connections Connection limit of a database pool (0 is unlimited).")
+		fmt.Fprintln(w, "# TYPE purplepages_db_pool_max_connections gauge")
+		for _, p := range pools {
+			fmt.Fprintf(w, "purplepages_db_pool_max_connections{pool=%q} %d\n", p.Pool, p.MaxOpenConnections)
+		}
+		fmt.Fprintln(w, "# HELP purplepages_db_pool_in_use_connections Connections of a database pool currently running a query.")
+		fmt.Fprintln(w, "# TYPE purplepages_db_pool_in_use_connections gauge")
+		for _, p := range pools {
+			fmt.Fprintf(w, "purplepages_db_pool_in_use_connections{pool=%q} %d\n", p.Pool, p.InUse)
+		}
+		fmt.Fprintln(w, "# HELP purplepages_db_pool_idle_connections Open but idle connections of a database pool.")
+		fmt.Fprintln(w, "# TYPE purplepages_db_pool_idle_connections gauge")
+		for _, p := range pools {
+			fmt.Fprintf(w, "purplepages_db_pool_idle_connections{pool=%q} %d\n", p.Pool, p.Idle)
+		}
+		fmt.Fprintln(w, "# HELP purplepages_db_pool_waits_total Queries that had to wait for a free connection.")
+		fmt.Fprintln(w, "# TYPE purplepages_db_pool_waits_total counter")
+		for _, p := range pools {
+			fmt.Fprintf(w, "purplepages_db_pool_waits_total{pool=%q} %d\n", p.Pool, p.WaitCount)
+		}
+		fmt.Fprintln(w, "# HELP purplepages_db_pool_wait_seconds_total Time spent waiting for a free connection.")
+		fmt.Fprintln(w, "# TYPE purplepages_db_pool_wait_seconds_total counter")
+		for _, p := range pools {
+			fmt.Fprintf(w, "purplepages_db_pool_wait_seconds_total{pool=%q} %g\n", p.Pool, p.WaitDuration.Seconds())
+		}
+
+		if h.prefetcher != nil {
+			prefetch := h.prefetcher.GetStats()
+			fmt.Fprintln(w, "# HELP purplepages_prefetch_total Pubkeys prefetched because clients usually look them up next.")
+			fmt.Fprintln(w, "# TYPE purplepages_prefetch_total counter")
+			fmt.Fprintf(w, "purplepages_prefetch_total %d\n", prefetch.Prefetched)
+			fmt.Fprintln(w, "# HELP purplepages_prefetch_hits_total Prefetched pubkeys that were requested before their prefetch expired.")
+			fmt.Fprintln(w, "# TYPE purplepages_prefetch_hits_total counter")
+			fmt.Fprintf(w, "purplepages_prefetch_hits_total %d\n", prefetch.Hits)
+			fmt.Fprintln(w, "# HELP purplepages_prefetch_misses_total Prefetched pubkeys that expired without being requested.")
+			fmt.Fprintln(w, "# TYPE purplepages_prefetch_misses_total counter")
+			fmt.Fprintf(w, "purplepages_prefetch_misses_total %d\n", prefetch.Misses)
+		}
+
+		bundles := h.storage.GetProfileBundleStats()
+		fmt.Fprintln(w, "# HELP purplepages_profile_bundles Pubkeys whose kind 0/3/10002 events are cached in memory.")
+		fmt.Fprintln(w, "# TYPE purplepages_profile_bundles gauge")
+		fmt.Fprintf(w, "purplepages_profile_bundles %d\n", bundles.Pubkeys)
+		fmt.Fprintln(w, "# HELP purplepages_profile_bundle_bytes Estimated memory taken by cached profile bundles.")
+		fmt.Fprintln(w, "# TYPE purplepages_profile_bundle_bytes gauge")
+		fmt.Fprintf(w, "purplepages_profile_bundle_bytes %d\n", bundles.Bytes)
+		fmt.Fprintln(w, "# HELP purplepages_profile_bundle_hits_total Filters answered from cached profile bundles.")
+		fmt.Fprintln(w, "# TYPE purplepages_profile_bundle_hits_total counter")
+		fmt.Fprintf(w, "purplepages_profile_bundle_hits_total %d\n", bundles.Hits)
+		fmt.Fprintln(w, "# HELP purplepages_profile_bundle_misses_total Filters for bundle kinds only that went to storage because an author wasn't cached.")
+		fmt.Fprintln(w, "# TYPE purplepages_profile_bundle_misses_total counter")
+		fmt.Fprintf(w, "purplepages_profile_bundle_misses_total %d\n", bundles.Misses)
+
+		if h.inflight != nil {
+			fetches := h.inflight.GetStats()
+			fmt.Fprintln(w, "# HELP purplepages_upstream_fetches_total Pubkey kinds requested from upstream relays by hydration and trusted sync.")
+			fmt.Fprintln(w, "# TYPE purplepages_upstream_fetches_total counter")
+			fmt.Fprintf(w, "purplepages_upstream_fetches_total %d\n", fetches.Fetched)
+			fmt.Fprintln(w, "# HELP purplepages_upstream_fetches_coalesced_total Pubkey kinds answered by a concurrent fetch of the same relay instead of a request of their own.")
+			fmt.Fprintln(w, "# TYPE purplepages_upstream_fetches_coalesced_total counter")
+			fmt.Fprintf(w, "purplepages_upstream_fetches_coalesced_total %d\n", fetches.Coalesced)
+		}
+
+		if h.hydrator != nil {
+			batch := h.hydrator.BatchState()
+			fmt.Fprintln(w, "# HELP purplepages_hydrator_batch_size Pubkeys the next hydration batch will hold.")
+			fmt.Fprintln(w, "# TYPE purplepages_hydrator_batch_size gauge")
+			fmt.Fprintf(w, "purplepages_hydrator_batch_size %d\n", batch.Size)
+			fmt.Fprintln(w, "# HELP purplepages_hydrator_batch_delay_seconds Pause before the next hydration batch.")
+			fmt.Fprintln(w, "# TYPE purplepages_hydrator_batch_delay_seconds gauge")
+			fmt.Fprintf(w, "purplepages_hydrator_batch_delay_seconds %g\n", batch.Delay.Seconds())
+			fmt.Fprintln(w, "# HELP purplepages_hydrator_request_latency_seconds Mean upstream request latency of the last hydration batch.")
+			fmt.Fprintln(w, "# TYPE purplepages_hydrator_request_latency_seconds gauge")
+			fmt.Fprintf(w, "purplepages_hydrator_request_latency_seconds %g\n", batch.Latency.Seconds())
+			fmt.Fprintln(w, "# HELP purplepages_hydrator_request_error_ratio Share of the last hydration batch's upstream requests that failed.")
+			fmt.Fprintln(w, "# TYPE purplepages_hydrator_request_error_ratio gauge")
+			fmt.Fprintf(w, "purplepages_hydrator_request_error_ratio %g\n", batch.ErrorRate)
+			fmt.Fprintln(w, "# HELP purplepages_hydrator_batch_adjustments_total Hydration batches after which the batch size grew or shrank.")
+			fmt.Fprintln(w, "# TYPE purplepages_hydrator_batch_adjustments_total counter")
+			fmt.Fprintf(w, "purplepages_hydrator_batch_adjustments_total{direction=\"increase\"} %d\n", batch.Increases)
+			fmt.Fprintf(w, "purplepages_hydrator_batch_adjustments_total{direction=\"decrease\"} %d\n", batch.Decreases)
+		}
+
+		if h.mirror != nil {
+			mirrored := h.mirror.GetStats()
+			fmt.Fprintln(w, "# HELP purplepages_mirror_frames_total REQs and EVENTs replayed against the staging relay.")
+			fmt.Fprintln(w, "# TYPE purplepages_mirror_frames_total counter")
+			fmt.Fprintf(w, "purplepages_mirror_frames_total{type=\"req\"} %d\n", mirrored.REQs)
+			fmt.Fprintf(w, "purplepages_mirror_frames_total{type=\"event\"} %d\n", mirrored.Events)
+			fmt.Fprintln(w, "# HELP purplepages_mirror_dropped_total Sampled frames dropped because the mirror queue was full.")
+			fmt.Fprintln(w, "# TYPE purplepages_mirror_dropped_total counter")
+			fmt.Fprintf(w, "purplepages_mirror_dropped_total %d\n", mirrored.Dropped)
+			fmt.Fprintln(w, "# HELP purplepages_mirror_failed_total Sampled frames that could not be sent to the staging relay.")
+			fmt.Fprintln(w, "# TYPE purplepages_mirror_failed_total counter")
+			fmt.Fprintf(w, "purplepages_mirror_failed_total %d\n", mirrored.Failed)
+		}
+
+		if h.rateLimiter != nil {
+			limits := h.rateLimiter.GetStats()
+			fmt.Fprintln(w, "# HELP purplepages_api_requests_allowed_total API requests let through by the rate limiter, by endpoint and client.")
+			fmt.Fprintln(w, "# TYPE purplepages_api_requests_allowed_total counter")
+			for _, l := range limits {
+				fmt.Fprintf(w, "purplepages_api_requests_allowed_total{endpoint=%q,client=%q} %d\n", l.Endpoint, l.Client, l.Allowed)
+			}
+			fmt.Fprintln(w, "# HELP purplepages_api_requests_limited_total API requests answered with 429, by endpoint and client.")
+			fmt.Fprintln(w, "# TYPE purplepages_api_requests_limited_total counter")
+			for _, l := range limits {
+				fmt.Fprintf(w, "purplepages_api_requests_limited_total{endpoint=%q,client=%q} %d\n", l.Endpoint, l.Client, l.Limited)
+			}
+		}
+
+		fmt.Fprintln(w, "# HELP purplepages_hook_duration_seconds Time spent in relay hooks and their named steps.")
+		fmt.Fprintln(w, "# TYPE purplepages_hook_duration_seconds histogram")
+		for _, hl := range h.stats.GetHookLatencies() {
+			for i, le := range HookLatencyBuckets {
+				fmt.Fprintf(w, "purplepages_hook_duration_seconds_bucket{hook=%q,le=\"%g\"} %d\n", hl.Hook, le, hl.Buckets[i])
+			}
+			fmt.Fprintf(w, "purplepages_hook_duration_seconds_bucket{hook=%q,le=\"+Inf\"} %d\n", hl.Hook, hl.Count)
+			fmt.Fprintf(w, "purplepages_hook_duration_seconds_sum{hook=%q} %g\n", hl.Hook, hl.Sum)
+			fmt.Fprintf(w, "purplepages_hook_duration_seconds_count{hook=%q} %d\n", hl.Hook, hl.Count)
+		}
+
+		fmt.Fprintln(w, "# HELP purplepages_req_size Filters per REQ, and authors and ids per filter, as sent by clients.")
+		fmt.Fprintln(w, "# TYPE purplepages_req_size histogram")
+		for _, fs := range h.stats.GetFilterSizes() {
+			for i, le := range FilterSizeBuckets {
+				fmt.Fprintf(w, "purplepages_req_size_bucket{dimension=%q,le=\"%g\"} %d\n", fs.Dimension, le, fs.Buckets[i])
+			}
+			fmt.Fprintf(w, "purplepages_req_size_bucket{dimension=%q,le=\"+Inf\"} %d\n", fs.Dimension, fs.Count)
+			fmt.Fprintf(w, "purplepages_req_size_sum{dimension=%q} %g\n", fs.Dimension, fs.Sum)
+			fmt.Fprintf(w, "purplepages_req_size_count{dimension=%q} %d\n", fs.Dimension, fs.Count)
+		}
+	}
+}
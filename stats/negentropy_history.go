@@ -0,0 +1,116 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"sort"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// negentropyChartDays is how far back the negentropy peer chart looks
+const negentropyChartDays = 30
+
+// NegentropyRunDisplay is the latest reconciliation of a kind with a peer
+type NegentropyRunDisplay struct {
+	storage.NegentropySyncRun
+	StartedAgo      string
+	DurationDisplay string
+}
+
+// negentropySyncs returns the latest reconciliation of each peer and kind, and
+// a chart of the events received and sent and ranges reconciled per day
+func (h *TrustedSyncHandler) negentropySyncs(ctx context.Context, now time.Time) ([]NegentropyRunDisplay, template.JS, error) {
+	since := now.AddDate(0, 0, -negentropyChartDays)
+	runs, err := h.storage.GetNegentropySyncRuns(ctx, since)
+	if err != nil {
+		return nil, "", err
+	}
+
+	type peerKind struct {
+		url  string
+		kind int
+	}
+	type dayTotals struct {
+		received, sent, ranges int64
+	}
+	latest := make(map[peerKind]bool)
+	var display []NegentropyRunDisplay
+	daily := make(map[string]map[string]*dayTotals) // peer -> date -> totals
+	for _, run := range runs {
+		// Runs come newest first
+		if key := (peerKind{run.RelayURL, run.Kind}); !latest[key] {
+			latest[key] = true
+			display = append(display, NegentropyRunDisplay{
+				NegentropySyncRun: run,
+				StartedAgo:        timeAgo(now, run.StartedAt),
+				DurationDisplay:   run.Duration.Round(time.Second).String(),
+			})
+		}
+
+		if daily[run.RelayURL] == nil {
+			daily[run.RelayURL] = make(map[string]*dayTotals)
+		}
+		date := run.StartedAt.Format("2006-01-02")
+		t := daily[run.RelayURL][date]
+		if t == nil {
+			t = &dayTotals{}
+			daily[run.RelayURL][date] = t
+		}
+		t.received += run.Received
+		t.sent += run.Sent
+		t.ranges += run.Ranges
+	}
+	sort.Slice(display, func(i, j int) bool {
+		if display[i].RelayURL != display[j].RelayURL {
+			return display[i].RelayURL < display[j].RelayURL
+		}
+		return display[i].Kind < display[j].Kind
+	})
+	if len(runs) == 0 {
+		return display, "", nil
+	}
+
+	// Dates are local, like the run times they group
+	labels := make([]string, 0, negentropyChartDays+1)
+	for d := negentropyChartDays; d >= 0; d-- {
+		labels = append(labels, now.AddDate(0, 0, -d).Format("2006-01-02"))
+	}
+	peers := make([]string, 0, len(daily))
+	for url := range daily {
+		peers = append(peers, url)
+	}
+	sort.Strings(peers)
+
+	type dataset struct {
+		Label string  `json:"label"`
+		Data  []int64 `json:"data"`
+		Axis  string  `json:"axis"` // events or ranges
+	}
+	var datasets []dataset
+	for _, url := range peers {
+		received := dataset{Label: url + " received", Data: make([]int64, len(labels)), Axis: "events"}
+		sent := dataset{Label: url + " sent", Data: make([]int64, len(labels)), Axis: "events"}
+		ranges := dataset{Label: url + " ranges", Data: make([]int64, len(labels)), Axis: "ranges"}
+		var anySent int64
+		for i, date := range labels {
+			if t := daily[url][date]; t != nil {
+				received.Data[i], sent.Data[i], ranges.Data[i] = t.received, t.sent, t.ranges
+				anySent += t.sent
+			}
+		}
+		datasets = append(datasets, received)
+		if anySent > 0 {
+			datasets = append(datasets, sent)
+		}
+		datasets = append(datasets, ranges)
+	}
+
+	chartJSON, _ := json.Marshal(map[string]interface{}{
+		"labels":   labels,
+		"datasets": datasets,
+	})
+	return display, template.JS(chartJSON), nil
+}
@@ -2,23 +2,52 @@ package stats
 
 import (
 	"context"
-	"html/template"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 
 	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
+)
+
+const (
+	// heatmapRegions is how many of the busiest regions get their own heatmap
+	// row; the rest are summed into "Other"
+	heatmapRegions = 15
+	// heatmapDays is how far back the heatmap looks by default, whole weeks so
+	// every weekday weighs the same
+	heatmapDays = 28
 )
 
 type NetworkHandler struct {
-	storage *storage.Storage
+	storage  *storage.Storage
+	hasGeoIP bool
 }
 
-func NewNetworkHandler(store *storage.Storage) *NetworkHandler {
-	return &NetworkHandler{storage: store}
+func NewNetworkHandler(store *storage.Storage, hasGeoIP bool) *NetworkHandler {
+	return &NetworkHandler{storage: store, hasGeoIP: hasGeoIP}
+}
+
+// HeatmapRow is one region's REQs per UTC hour of the day
+type HeatmapRow struct {
+	Region string
+	Total  int64
+	Cells  [24]HeatmapCell
+}
+
+type HeatmapCell struct {
+	REQs      int64
+	Intensity string // cell opacity, relative to the busiest cell of the heatmap
 }
 
 type NetworkPageData struct {
 	HourlyStats []storage.HourlyStats
 	DailyStats  []storage.DailyStats
+	// REQ volume by region and hour of the day, with an "All regions" row first
+	HasGeoIP    bool
+	HeatmapDays int
+	Heatmap     []HeatmapRow
 }
 
 func (h *NetworkHandler) HandleNetwork() http.HandlerFunc {
@@ -31,9 +60,17 @@ func (h *NetworkHandler) HandleNetwork() http.HandlerFunc {
 		data := NetworkPageData{
 			HourlyStats: hourlyStats,
 			DailyStats:  dailyStats,
+			HasGeoIP:    h.hasGeoIP,
+			HeatmapDays: heatmapDays,
+		}
+		if days, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && days > 0 && days <= 90 {
+			data.HeatmapDays = days
+		}
+		if regionStats, err := h.storage.GetRegionHourStats(ctx, data.HeatmapDays); err == nil {
+			data.Heatmap = buildHeatmap(regionStats)
 		}
 
-		tmpl, err := template.New("network").Parse(networkTemplate)
+		tmpl, err := templates.Get("network", nil)
 		if err != nil {
 			http.Error(w, "Template error", http.StatusInternalServerError)
 			return
@@ -46,393 +83,81 @@ func (h *NetworkHandler) HandleNetwork() http.HandlerFunc {
 	}
 }
 
-var networkTemplate = `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>purplepag.es - Network Connections</title>
-    <style>
-        * { margin: 0; padding: 0; box-sizing: border-box; }
-
-        body {
-            font-family: -apple-system, BlinkMacSystemFont, 'SF Pro Display', 'Segoe UI', Roboto, 'Helvetica Neue', Arial, sans-serif;
-            background: #0a0a0f;
-            min-height: 100vh;
-            padding: 2rem;
-            color: #e4e4e7;
-            position: relative;
-            overflow-x: hidden;
-        }
-
-        body::before {
-            content: '';
-            position: fixed;
-            top: -50%;
-            left: -50%;
-            width: 200%;
-            height: 200%;
-            background: radial-gradient(circle at 30% 20%, rgba(139, 92, 246, 0.08) 0%, transparent 50%),
-                        radial-gradient(circle at 70% 80%, rgba(217, 70, 239, 0.06) 0%, transparent 50%);
-            animation: drift 30s ease-in-out infinite;
-            pointer-events: none;
-        }
-
-        @keyframes drift {
-            0%, 100% { transform: translate(0, 0) rotate(0deg); }
-            33% { transform: translate(-5%, 5%) rotate(5deg); }
-            66% { transform: translate(5%, -5%) rotate(-5deg); }
-        }
-
-        @keyframes shimmer {
-            0%, 100% { background-position: 0% 50%; }
-            50% { background-position: 100% 50%; }
-        }
-
-        .container {
-            max-width: 1400px;
-            margin: 0 auto;
-            position: relative;
-            z-index: 1;
-        }
-
-        header {
-            margin-bottom: 3rem;
-            text-align: center;
-        }
-
-        h1 {
-            font-size: 3rem;
-            font-weight: 700;
-            margin-bottom: 0.5rem;
-            background: linear-gradient(135deg, #a78bfa 0%, #e879f9 50%, #a78bfa 100%);
-            background-size: 200% 100%;
-            background-clip: text;
-            -webkit-background-clip: text;
-            -webkit-text-fill-color: transparent;
-            animation: shimmer 8s ease-in-out infinite;
-            letter-spacing: -0.02em;
-        }
-
-        .subtitle {
-            font-size: 1rem;
-            font-weight: 500;
-            color: #a1a1aa;
-            text-transform: uppercase;
-            letter-spacing: 0.15em;
-        }
-
-        .back-link {
-            display: inline-block;
-            margin-bottom: 2rem;
-            color: #a78bfa;
-            text-decoration: none;
-            font-weight: 500;
-            transition: color 0.2s;
-        }
-
-        .back-link:hover { color: #c4b5fd; }
-
-        .section {
-            background: linear-gradient(135deg, rgba(139, 92, 246, 0.03) 0%, rgba(217, 70, 239, 0.01) 100%);
-            border: 1px solid rgba(167, 139, 250, 0.1);
-            border-radius: 24px;
-            padding: 2rem;
-            margin-bottom: 2rem;
-        }
-
-        .section h2 {
-            font-size: 1.25rem;
-            font-weight: 700;
-            margin-bottom: 0.5rem;
-            color: #e4e4e7;
-        }
-
-        .section .description {
-            font-size: 0.875rem;
-            color: #a1a1aa;
-            margin-bottom: 1.5rem;
-        }
-
-        .chart-container {
-            width: 100%;
-            height: 400px;
-            background: rgba(10, 10, 15, 0.5);
-            border-radius: 16px;
-            overflow: hidden;
-        }
-
-        .legend {
-            display: flex;
-            justify-content: center;
-            gap: 2rem;
-            margin-top: 1rem;
-            flex-wrap: wrap;
-        }
-
-        .legend-item {
-            display: flex;
-            align-items: center;
-            gap: 0.5rem;
-            font-size: 0.875rem;
-            color: #a1a1aa;
-        }
-
-        .legend-color {
-            width: 20px;
-            height: 3px;
-            border-radius: 2px;
-        }
-
-        .tooltip {
-            position: absolute;
-            background: rgba(10, 10, 15, 0.95);
-            border: 1px solid rgba(167, 139, 250, 0.3);
-            border-radius: 8px;
-            padding: 0.75rem;
-            font-size: 0.75rem;
-            pointer-events: none;
-            opacity: 0;
-            transition: opacity 0.2s;
-            z-index: 100;
-        }
-
-        .tooltip-date {
-            font-weight: 700;
-            color: #e4e4e7;
-            margin-bottom: 0.5rem;
-        }
-
-        .tooltip-row {
-            display: flex;
-            justify-content: space-between;
-            gap: 1rem;
-            margin-bottom: 0.25rem;
-        }
-
-        .tooltip-label {
-            color: #a1a1aa;
-        }
-
-        .tooltip-value {
-            font-weight: 600;
-            font-variant-numeric: tabular-nums;
-        }
-
-        @media (max-width: 768px) {
-            body { padding: 1.5rem; }
-            h1 { font-size: 2rem; }
-            .chart-container { height: 300px; }
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <a href="/stats" class="back-link">← Back to Stats</a>
-        <header>
-            <h1>purplepag.es</h1>
-            <div class="subtitle">Network Connections Dashboard</div>
-        </header>
-
-        <div class="section">
-            <h2>Hourly Metrics (Last 48 Hours)</h2>
-            <div class="description">Recent network activity with hourly granularity</div>
-            <div id="hourly-chart" class="chart-container"></div>
-            <div class="legend">
-                <div class="legend-item">
-                    <div class="legend-color" style="background: #a78bfa;"></div>
-                    <span>Unique IPs</span>
-                </div>
-                <div class="legend-item">
-                    <div class="legend-color" style="background: #e879f9;"></div>
-                    <span>Total Requests</span>
-                </div>
-                <div class="legend-item">
-                    <div class="legend-color" style="background: #60a5fa;"></div>
-                    <span>Events Served</span>
-                </div>
-            </div>
-        </div>
-
-        <div class="section">
-            <h2>Daily Metrics (Last 30 Days)</h2>
-            <div class="description">Historical trends with daily aggregation</div>
-            <div id="daily-chart" class="chart-container"></div>
-            <div class="legend">
-                <div class="legend-item">
-                    <div class="legend-color" style="background: #a78bfa;"></div>
-                    <span>Unique IPs</span>
-                </div>
-                <div class="legend-item">
-                    <div class="legend-color" style="background: #e879f9;"></div>
-                    <span>Total Requests</span>
-                </div>
-                <div class="legend-item">
-                    <div class="legend-color" style="background: #60a5fa;"></div>
-                    <span>Events Served</span>
-                </div>
-            </div>
-        </div>
-    </div>
-
-    <script src="https://d3js.org/d3.v7.min.js"></script>
-    <script>
-        const hourlyData = [
-            {{range .HourlyStats}}
-            {time: "{{.Hour}}", uniqueIPs: {{.UniqueIPs}}, totalREQs: {{.TotalREQs}}, eventsServed: {{.EventsServed}}},
-            {{end}}
-        ];
-
-        const dailyData = [
-            {{range .DailyStats}}
-            {time: "{{.Date}}", uniqueIPs: {{.UniqueIPs}}, totalREQs: {{.TotalREQs}}, eventsServed: {{.EventsServed}}},
-            {{end}}
-        ];
-
-        function createChart(containerId, data, isHourly) {
-            const container = document.getElementById(containerId);
-            const margin = {top: 20, right: 20, bottom: 40, left: 60};
-            const width = container.clientWidth - margin.left - margin.right;
-            const height = container.clientHeight - margin.top - margin.bottom;
-
-            const svg = d3.select('#' + containerId)
-                .append('svg')
-                .attr('width', container.clientWidth)
-                .attr('height', container.clientHeight)
-                .append('g')
-                .attr('transform', 'translate(' + margin.left + ',' + margin.top + ')');
-
-            data.forEach(d => {
-                d.parsedTime = isHourly ? new Date(d.time.replace(' ', 'T') + ':00:00') : new Date(d.time);
-            });
-
-            const x = d3.scaleTime()
-                .domain(d3.extent(data, d => d.parsedTime))
-                .range([0, width]);
-
-            const maxUniqueIPs = d3.max(data, d => d.uniqueIPs);
-            const maxTotalREQs = d3.max(data, d => d.totalREQs);
-            const maxEventsServed = d3.max(data, d => d.eventsServed);
-            const globalMax = Math.max(maxUniqueIPs, maxTotalREQs, maxEventsServed);
-
-            const y = d3.scaleLinear()
-                .domain([0, globalMax * 1.1])
-                .range([height, 0]);
-
-            svg.append('g')
-                .attr('transform', 'translate(0,' + height + ')')
-                .call(d3.axisBottom(x).ticks(isHourly ? 8 : 10))
-                .selectAll('text')
-                .style('fill', '#a1a1aa')
-                .style('font-size', '11px');
-
-            svg.append('g')
-                .call(d3.axisLeft(y).ticks(6))
-                .selectAll('text')
-                .style('fill', '#a1a1aa')
-                .style('font-size', '11px');
-
-            svg.selectAll('.domain, .tick line')
-                .style('stroke', 'rgba(167, 139, 250, 0.2)');
-
-            const lineUniqueIPs = d3.line()
-                .x(d => x(d.parsedTime))
-                .y(d => y(d.uniqueIPs))
-                .curve(d3.curveMonotoneX);
-
-            const lineTotalREQs = d3.line()
-                .x(d => x(d.parsedTime))
-                .y(d => y(d.totalREQs))
-                .curve(d3.curveMonotoneX);
-
-            const lineEventsServed = d3.line()
-                .x(d => x(d.parsedTime))
-                .y(d => y(d.eventsServed))
-                .curve(d3.curveMonotoneX);
-
-            svg.append('path')
-                .datum(data)
-                .attr('fill', 'none')
-                .attr('stroke', '#a78bfa')
-                .attr('stroke-width', 2)
-                .attr('d', lineUniqueIPs);
-
-            svg.append('path')
-                .datum(data)
-                .attr('fill', 'none')
-                .attr('stroke', '#e879f9')
-                .attr('stroke-width', 2)
-                .attr('d', lineTotalREQs);
-
-            svg.append('path')
-                .datum(data)
-                .attr('fill', 'none')
-                .attr('stroke', '#60a5fa')
-                .attr('stroke-width', 2)
-                .attr('d', lineEventsServed);
-
-            const tooltip = d3.select('body')
-                .append('div')
-                .attr('class', 'tooltip');
-
-            const focus = svg.append('g')
-                .style('display', 'none');
-
-            focus.append('circle')
-                .attr('r', 4)
-                .attr('fill', '#a78bfa')
-                .attr('stroke', '#e4e4e7')
-                .attr('stroke-width', 2);
-
-            const bisect = d3.bisector(d => d.parsedTime).left;
-
-            svg.append('rect')
-                .attr('width', width)
-                .attr('height', height)
-                .style('fill', 'none')
-                .style('pointer-events', 'all')
-                .on('mouseover', () => {
-                    focus.style('display', null);
-                    tooltip.style('opacity', 1);
-                })
-                .on('mouseout', () => {
-                    focus.style('display', 'none');
-                    tooltip.style('opacity', 0);
-                })
-                .on('mousemove', function(event) {
-                    const x0 = x.invert(d3.pointer(event)[0]);
-                    const i = bisect(data, x0, 1);
-                    const d0 = data[i - 1];
-                    const d1 = data[i];
-                    const d = d1 && (x0 - d0.parsedTime > d1.parsedTime - x0) ? d1 : d0;
+// buildHeatmap lays region × hour counts out as rows, busiest region first,
+// preceded by the total of all regions. The total row is shaded on its own
+// scale, since it would otherwise wash out every region.
+func buildHeatmap(stats []storage.RegionHourStats) []HeatmapRow {
+	if len(stats) == 0 {
+		return nil
+	}
 
-                    if (d) {
-                        focus.attr('transform', 'translate(' + x(d.parsedTime) + ',' + y(d.uniqueIPs) + ')');
+	byRegion := make(map[string]*HeatmapRow)
+	all := HeatmapRow{Region: "All regions"}
+	for _, st := range stats {
+		if st.Hour < 0 || st.Hour > 23 {
+			continue
+		}
+		row, ok := byRegion[st.Region]
+		if !ok {
+			row = &HeatmapRow{Region: st.Region}
+			byRegion[st.Region] = row
+		}
+		row.Cells[st.Hour].REQs += st.TotalREQs
+		row.Total += st.TotalREQs
+		all.Cells[st.Hour].REQs += st.TotalREQs
+		all.Total += st.TotalREQs
+	}
 
-                        const formatTime = isHourly ?
-                            d3.timeFormat('%Y-%m-%d %H:00') :
-                            d3.timeFormat('%Y-%m-%d');
+	regions := make([]*HeatmapRow, 0, len(byRegion))
+	for _, row := range byRegion {
+		regions = append(regions, row)
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		if regions[i].Total != regions[j].Total {
+			return regions[i].Total > regions[j].Total
+		}
+		return regions[i].Region < regions[j].Region
+	})
+
+	if len(regions) > heatmapRegions {
+		other := &HeatmapRow{Region: "Other"}
+		for _, row := range regions[heatmapRegions:] {
+			for hour := range row.Cells {
+				other.Cells[hour].REQs += row.Cells[hour].REQs
+			}
+			other.Total += row.Total
+		}
+		regions = append(regions[:heatmapRegions], other)
+	}
 
-                        tooltip
-                            .html(
-                                '<div class="tooltip-date">' + formatTime(d.parsedTime) + '</div>' +
-                                '<div class="tooltip-row"><span class="tooltip-label">Unique IPs:</span><span class="tooltip-value" style="color:#a78bfa">' + d.uniqueIPs.toLocaleString() + '</span></div>' +
-                                '<div class="tooltip-row"><span class="tooltip-label">Total Requests:</span><span class="tooltip-value" style="color:#e879f9">' + d.totalREQs.toLocaleString() + '</span></div>' +
-                                '<div class="tooltip-row"><span class="tooltip-label">Events Served:</span><span class="tooltip-value" style="color:#60a5fa">' + d.eventsServed.toLocaleString() + '</span></div>'
-                            )
-                            .style('left', (event.pageX + 15) + 'px')
-                            .style('top', (event.pageY - 15) + 'px');
-                    }
-                });
-        }
+	rows := make([]HeatmapRow, 0, len(regions)+1)
+	rows = append(rows, all)
+	for _, row := range regions {
+		rows = append(rows, *row)
+	}
 
-        if (hourlyData.length > 0) {
-            createChart('hourly-chart', hourlyData, true);
-        }
+	shade(rows[:1])
+	shade(rows[1:])
+	return rows
+}
 
-        if (dailyData.length > 0) {
-            createChart('daily-chart', dailyData, false);
-        }
-    </script>
-</body>
-</html>`
+// shade sets each cell's intensity relative to the busiest cell in rows
+func shade(rows []HeatmapRow) {
+	var max int64
+	for _, row := range rows {
+		for _, cell := range row.Cells {
+			if cell.REQs > max {
+				max = cell.REQs
+			}
+		}
+	}
+	for i := range rows {
+		for hour := range rows[i].Cells {
+			intensity := 0.0
+			if max > 0 {
+				intensity = float64(rows[i].Cells[hour].REQs) / float64(max)
+			}
+			rows[i].Cells[hour].Intensity = fmt.Sprintf("%.2f", intensity)
+		}
+	}
+}
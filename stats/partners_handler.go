@@ -0,0 +1,216 @@
+package stats
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/partners"
+	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
+)
+
+// partnerUsageDays is the window usage is summed over on the page and admin API
+const partnerUsageDays = 30
+
+// PartnersHandler shows sync partners with their usage and is the admin API for
+// issuing and revoking their tokens. partners is nil when no token secret is set.
+type PartnersHandler struct {
+	storage  *storage.Storage
+	partners *partners.Partners
+}
+
+func NewPartnersHandler(store *storage.Storage, p *partners.Partners) *PartnersHandler {
+	return &PartnersHandler{storage: store, partners: p}
+}
+
+type PartnerDisplay struct {
+	storage.SyncPartner
+	Usage      storage.SyncPartnerUsage
+	Active     bool
+	IssuedAgo  string
+	LastSeen   string
+	RevokedAgo string
+}
+
+type PartnersPageData struct {
+	Enabled bool
+	Days    int
+	Active  int
+	Totals  storage.SyncPartnerUsage
+	Items   []PartnerDisplay
+}
+
+type partnerJSON struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	RelayURL           string `json:"relay_url,omitempty"`
+	IssuedAt           int64  `json:"issued_at"`
+	RevokedAt          int64  `json:"revoked_at,omitempty"`
+	LastSeenAt         int64  `json:"last_seen_at,omitempty"`
+	NegentropySessions int64  `json:"negentropy_sessions"`
+	Requests           int64  `json:"requests"`
+	Snapshots          int64  `json:"snapshots"`
+}
+
+// HandlePartners serves the /stats/partners page
+func (h *PartnersHandler) HandlePartners() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		issued, usage, err := h.load(r)
+		if err != nil {
+			http.Error(w, "Failed to load partners", http.StatusInternalServerError)
+			return
+		}
+
+		now := time.Now()
+		data := PartnersPageData{Enabled: h.partners != nil, Days: partnerUsageDays, Items: make([]PartnerDisplay, len(issued))}
+		for i, p := range issued {
+			display := PartnerDisplay{
+				SyncPartner: p,
+				Usage:       usage[p.ID],
+				Active:      p.RevokedAt.IsZero(),
+				IssuedAgo:   formatTimeAgo(now.Sub(p.IssuedAt)),
+				LastSeen:    "never",
+			}
+			if !p.LastSeenAt.IsZero() {
+				display.LastSeen = formatTimeAgo(now.Sub(p.LastSeenAt))
+			}
+			if !display.Active {
+				display.RevokedAgo = formatTimeAgo(now.Sub(p.RevokedAt))
+			} else {
+				data.Active++
+			}
+			data.Totals.NegentropySessions += display.Usage.NegentropySessions
+			data.Totals.Requests += display.Usage.Requests
+			data.Totals.Snapshots += display.Usage.Snapshots
+			data.Items[i] = display
+		}
+
+		tmpl, err := templates.Get("partners", nil)
+		if err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, data); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// HandleList serves GET /api/v1/admin/partners
+func (h *PartnersHandler) HandleList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		issued, usage, err := h.load(r)
+		if err != nil {
+			http.Error(w, "Failed to load partners", http.StatusInternalServerError)
+			return
+		}
+
+		result := make([]partnerJSON, len(issued))
+		for i, p := range issued {
+			result[i] = toPartnerJSON(p, usage[p.ID])
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"days": partnerUsageDays, "partners": result})
+	}
+}
+
+// HandleIssue serves POST /api/v1/admin/partners with a body of
+// {"name": "...", "relay_url": "wss://..."}. The token is only in this response.
+func (h *PartnersHandler) HandleIssue() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Name     string `json:"name"`
+			RelayURL string `json:"relay_url"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		if body.Name == "" {
+			http.Error(w, "Missing name", http.StatusBadRequest)
+			return
+		}
+		if body.RelayURL != "" && !nostr.IsValidRelayURL(body.RelayURL) {
+			http.Error(w, "Invalid relay URL", http.StatusBadRequest)
+			return
+		}
+
+		partner, token, err := h.partners.Issue(r.Context(), body.Name, body.RelayURL)
+		if err != nil {
+			http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+			return
+		}
+
+		if err := h.storage.RecordAdminAction(r.Context(), AuditActor(r), storage.AuditIssueSyncToken, partner.ID+" "+partner.Name, 1); err != nil {
+			log.Printf("Failed to record sync token in audit log: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"partner": toPartnerJSON(partner, storage.SyncPartnerUsage{}),
+			"token":   token,
+		})
+	}
+}
+
+// HandleRevoke serves DELETE /api/v1/admin/partners/{id}
+func (h *PartnersHandler) HandleRevoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		revoked, err := h.partners.Revoke(r.Context(), id)
+		if err != nil {
+			http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+			return
+		}
+		if !revoked {
+			http.Error(w, "Unknown or already revoked partner", http.StatusNotFound)
+			return
+		}
+
+		if err := h.storage.RecordAdminAction(r.Context(), AuditActor(r), storage.AuditRevokeSyncToken, id, 1); err != nil {
+			log.Printf("Failed to record sync token revocation in audit log: %v", err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *PartnersHandler) load(r *http.Request) ([]storage.SyncPartner, map[string]storage.SyncPartnerUsage, error) {
+	issued, err := h.storage.GetSyncPartners(r.Context(), false)
+	if err != nil {
+		return nil, nil, err
+	}
+	usage, err := h.storage.GetSyncPartnerUsage(r.Context(), partnerUsageDays)
+	if err != nil {
+		return nil, nil, err
+	}
+	return issued, usage, nil
+}
+
+func toPartnerJSON(p storage.SyncPartner, u storage.SyncPartnerUsage) partnerJSON {
+	result := partnerJSON{
+		ID:                 p.ID,
+		Name:               p.Name,
+		RelayURL:           p.RelayURL,
+		IssuedAt:           p.IssuedAt.Unix(),
+		NegentropySessions: u.NegentropySessions,
+		Requests:           u.Requests,
+		Snapshots:          u.Snapshots,
+	}
+	if !p.RevokedAt.IsZero() {
+		result.RevokedAt = p.RevokedAt.Unix()
+	}
+	if !p.LastSeenAt.IsZero() {
+		result.LastSeenAt = p.LastSeenAt.Unix()
+	}
+	return result
+}
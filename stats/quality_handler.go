@@ -0,0 +1,126 @@
+package stats
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
+)
+
+const (
+	// qualityRuns is how many past outbox probes the history shows
+	qualityRuns = 30
+	// qualityRelayAge drops relays no probe has queried for this long
+	qualityRelayAge = 7 * 24 * time.Hour
+)
+
+type QualityRun struct {
+	RunAgo   string
+	Pubkeys  int64
+	Relays   int64
+	Expected int64
+	Accuracy string
+	Current  string
+}
+
+type QualityRelay struct {
+	URL           string
+	Pubkeys       int64
+	Expected      int64
+	Found         int64
+	Accuracy      string
+	AccuracyClass string
+	Current       string
+	ProbedAgo     string
+	Error         string
+}
+
+type QualityPageData struct {
+	HasData  bool
+	Latest   QualityRun
+	Runs     []QualityRun
+	Relays   []QualityRelay
+	Failing  int // relays that couldn't be queried in their latest probe
+	Accurate int // relays returning at least 90% of what was asked
+}
+
+// QualityHandler shows how accurate the outbox model is: whether the write
+// relays of trusted pubkeys return their profiles and contact lists
+type QualityHandler struct {
+	storage *storage.Storage
+}
+
+func NewQualityHandler(store *storage.Storage) *QualityHandler {
+	return &QualityHandler{storage: store}
+}
+
+func (h *QualityHandler) HandleQuality() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		runs, err := h.storage.GetOutboxProbeRuns(ctx, qualityRuns)
+		if err != nil {
+			http.Error(w, "Failed to load outbox probes", http.StatusInternalServerError)
+			return
+		}
+		relays, err := h.storage.GetOutboxRelayProbes(ctx, time.Now().Add(-qualityRelayAge))
+		if err != nil {
+			http.Error(w, "Failed to load outbox probes", http.StatusInternalServerError)
+			return
+		}
+
+		now := time.Now()
+		data := QualityPageData{HasData: len(runs) > 0}
+		for _, run := range runs {
+			data.Runs = append(data.Runs, QualityRun{
+				RunAgo:   formatTimeAgo(now.Sub(run.RunAt)),
+				Pubkeys:  run.Pubkeys,
+				Relays:   run.Relays,
+				Expected: run.Expected,
+				Accuracy: percent(int(run.Found), int(run.Expected)),
+				Current:  percent(int(run.Current), int(run.Expected)),
+			})
+		}
+		if len(data.Runs) > 0 {
+			data.Latest = data.Runs[0]
+		}
+
+		for _, relay := range relays {
+			q := QualityRelay{
+				URL:       relay.URL,
+				Pubkeys:   relay.Pubkeys,
+				Expected:  relay.Expected,
+				Found:     relay.Found,
+				Accuracy:  percent(int(relay.Found), int(relay.Expected)),
+				Current:   percent(int(relay.Current), int(relay.Expected)),
+				ProbedAgo: formatTimeAgo(now.Sub(relay.ProbedAt)),
+				Error:     relay.Error,
+			}
+			switch {
+			case relay.Error != "":
+				q.AccuracyClass = "low"
+				data.Failing++
+			case relay.Expected > 0 && relay.Found*10 >= relay.Expected*9:
+				q.AccuracyClass = "high"
+				data.Accurate++
+			case relay.Expected > 0 && relay.Found*2 >= relay.Expected:
+				q.AccuracyClass = "medium"
+			default:
+				q.AccuracyClass = "low"
+			}
+			data.Relays = append(data.Relays, q)
+		}
+
+		tmpl, err := templates.Get("quality", nil)
+		if err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, data); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
@@ -8,477 +8,9 @@ import (
 	"time"
 
 	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
 )
 
-var rejectionTemplate = `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>purplepag.es - Rejection & REQ Analytics</title>
-    <style>
-        * {
-            margin: 0;
-            padding: 0;
-            box-sizing: border-box;
-        }
-
-        body {
-            font-family: -apple-system, BlinkMacSystemFont, 'SF Pro Display', 'Segoe UI', Roboto, 'Helvetica Neue', Arial, sans-serif;
-            background: #0a0a0f;
-            min-height: 100vh;
-            padding: 2rem;
-            color: #e4e4e7;
-            position: relative;
-            overflow-x: hidden;
-        }
-
-        body::before {
-            content: '';
-            position: fixed;
-            top: -50%;
-            left: -50%;
-            width: 200%;
-            height: 200%;
-            background: radial-gradient(circle at 30% 20%, rgba(139, 92, 246, 0.08) 0%, transparent 50%),
-                        radial-gradient(circle at 70% 80%, rgba(217, 70, 239, 0.06) 0%, transparent 50%);
-            animation: drift 30s ease-in-out infinite;
-            pointer-events: none;
-        }
-
-        @keyframes drift {
-            0%, 100% { transform: translate(0, 0) rotate(0deg); }
-            33% { transform: translate(-5%, 5%) rotate(5deg); }
-            66% { transform: translate(5%, -5%) rotate(-5deg); }
-        }
-
-        @keyframes shimmer {
-            0%, 100% { background-position: 0% 50%; }
-            50% { background-position: 100% 50%; }
-        }
-
-        .container {
-            max-width: 1400px;
-            margin: 0 auto;
-            position: relative;
-            z-index: 1;
-        }
-
-        .back-link {
-            display: inline-block;
-            margin-bottom: 2rem;
-            color: #a78bfa;
-            text-decoration: none;
-            font-weight: 500;
-            transition: color 0.2s;
-        }
-
-        .back-link:hover {
-            color: #c4b5fd;
-        }
-
-        header {
-            margin-bottom: 3rem;
-            text-align: center;
-        }
-
-        h1 {
-            font-size: 3rem;
-            font-weight: 700;
-            margin-bottom: 0.5rem;
-            background: linear-gradient(135deg, #a78bfa 0%, #e879f9 50%, #a78bfa 100%);
-            background-size: 200% 100%;
-            background-clip: text;
-            -webkit-background-clip: text;
-            -webkit-text-fill-color: transparent;
-            animation: shimmer 8s ease-in-out infinite;
-            letter-spacing: -0.02em;
-        }
-
-        .subtitle {
-            font-size: 1rem;
-            font-weight: 500;
-            color: #a1a1aa;
-            text-transform: uppercase;
-            letter-spacing: 0.15em;
-        }
-
-        .stats-grid {
-            display: grid;
-            grid-template-columns: repeat(auto-fit, minmax(200px, 1fr));
-            gap: 1.5rem;
-            margin-bottom: 3rem;
-        }
-
-        .stat-card {
-            background: linear-gradient(135deg, rgba(139, 92, 246, 0.05) 0%, rgba(217, 70, 239, 0.02) 100%);
-            border: 1px solid rgba(167, 139, 250, 0.15);
-            border-radius: 24px;
-            padding: 2rem;
-            text-align: center;
-            position: relative;
-            overflow: hidden;
-            transition: all 0.3s cubic-bezier(0.4, 0, 0.2, 1);
-        }
-
-        .stat-card::before {
-            content: '';
-            position: absolute;
-            top: 0;
-            left: 0;
-            right: 0;
-            height: 1px;
-            background: linear-gradient(90deg, transparent, rgba(167, 139, 250, 0.4), transparent);
-            opacity: 0;
-            transition: opacity 0.3s;
-        }
-
-        .stat-card:hover {
-            border-color: rgba(167, 139, 250, 0.3);
-            transform: translateY(-2px);
-            box-shadow: 0 20px 40px rgba(139, 92, 246, 0.1);
-        }
-
-        .stat-card:hover::before {
-            opacity: 1;
-        }
-
-        .stat-label {
-            font-size: 0.75rem;
-            font-weight: 600;
-            color: #a1a1aa;
-            text-transform: uppercase;
-            letter-spacing: 0.1em;
-            margin-bottom: 1rem;
-        }
-
-        .stat-value {
-            font-size: 2.5rem;
-            font-weight: 700;
-            line-height: 1;
-            background: linear-gradient(135deg, #e4e4e7 0%, #a1a1aa 100%);
-            background-clip: text;
-            -webkit-background-clip: text;
-            -webkit-text-fill-color: transparent;
-        }
-
-        .section {
-            background: linear-gradient(135deg, rgba(139, 92, 246, 0.03) 0%, rgba(217, 70, 239, 0.01) 100%);
-            border: 1px solid rgba(167, 139, 250, 0.1);
-            border-radius: 24px;
-            padding: 2.5rem;
-            margin-bottom: 2rem;
-        }
-
-        h2 {
-            font-size: 1.5rem;
-            font-weight: 700;
-            margin-bottom: 2rem;
-            color: #e4e4e7;
-            letter-spacing: -0.01em;
-        }
-
-        table {
-            width: 100%;
-            border-collapse: collapse;
-        }
-
-        th {
-            text-align: left;
-            padding: 0.75rem 1rem;
-            font-size: 0.75rem;
-            font-weight: 600;
-            color: #a1a1aa;
-            text-transform: uppercase;
-            letter-spacing: 0.05em;
-            border-bottom: 1px solid rgba(167, 139, 250, 0.1);
-        }
-
-        td {
-            padding: 0.75rem 1rem;
-            font-size: 0.9rem;
-            border-bottom: 1px solid rgba(167, 139, 250, 0.05);
-        }
-
-        tr:hover {
-            background: rgba(167, 139, 250, 0.03);
-        }
-
-        .kind-badge {
-            display: inline-block;
-            padding: 0.25rem 0.75rem;
-            background: rgba(167, 139, 250, 0.15);
-            border-radius: 12px;
-            font-family: 'SF Mono', monospace;
-            font-size: 0.85rem;
-            color: #a78bfa;
-        }
-
-        .pubkey {
-            font-family: 'SF Mono', monospace;
-            font-size: 0.8rem;
-            color: #71717a;
-        }
-
-        .count {
-            font-weight: 600;
-            font-variant-numeric: tabular-nums;
-            color: #e4e4e7;
-        }
-
-        .time-ago {
-            color: #71717a;
-            font-size: 0.85rem;
-        }
-
-        .empty-state {
-            text-align: center;
-            padding: 3rem;
-            color: #71717a;
-        }
-
-        .daily-stats {
-            display: grid;
-            gap: 0.5rem;
-        }
-
-        .daily-row {
-            display: flex;
-            align-items: center;
-            gap: 1rem;
-            padding: 0.5rem 0;
-            border-bottom: 1px solid rgba(167, 139, 250, 0.05);
-        }
-
-        .daily-date {
-            font-size: 0.85rem;
-            color: #a1a1aa;
-            min-width: 100px;
-        }
-
-        .daily-kinds {
-            display: flex;
-            flex-wrap: wrap;
-            gap: 0.5rem;
-        }
-
-        .daily-kind {
-            display: inline-flex;
-            align-items: center;
-            gap: 0.25rem;
-            padding: 0.2rem 0.5rem;
-            background: rgba(167, 139, 250, 0.1);
-            border-radius: 8px;
-            font-size: 0.8rem;
-        }
-
-        .daily-kind .kind-num {
-            color: #a78bfa;
-        }
-
-        .daily-kind .kind-count {
-            color: #71717a;
-        }
-
-        @media (max-width: 768px) {
-            body {
-                padding: 1.5rem;
-            }
-
-            h1 {
-                font-size: 2rem;
-            }
-
-            .stats-grid {
-                grid-template-columns: repeat(2, 1fr);
-            }
-
-            .section {
-                padding: 1.5rem;
-            }
-
-            .stat-value {
-                font-size: 1.75rem;
-            }
-
-            table {
-                font-size: 0.8rem;
-            }
-
-            th, td {
-                padding: 0.5rem;
-            }
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <a href="/stats" class="back-link">← Back to Stats</a>
-
-        <header>
-            <h1>Rejection & REQ Analytics</h1>
-            <p class="subtitle">Tracking rejected events and REQ patterns</p>
-        </header>
-
-        <div class="stats-grid">
-            <div class="stat-card">
-                <div class="stat-label">Rejected Events</div>
-                <div class="stat-value">{{.RejectedEventTotal}}</div>
-            </div>
-            <div class="stat-card">
-                <div class="stat-label">Rejected Kinds</div>
-                <div class="stat-value">{{.RejectedEventKinds}}</div>
-            </div>
-            <div class="stat-card">
-                <div class="stat-label">Unique Pubkeys</div>
-                <div class="stat-value">{{.RejectedEventPubkeys}}</div>
-            </div>
-            <div class="stat-card">
-                <div class="stat-label">Rejected REQs</div>
-                <div class="stat-value">{{.RejectedREQTotal}}</div>
-            </div>
-            <div class="stat-card">
-                <div class="stat-label">Unsupported REQ Kinds</div>
-                <div class="stat-value">{{.RejectedREQKinds}}</div>
-            </div>
-        </div>
-
-        <div class="section">
-            <h2>🚫 Rejected Events by Kind</h2>
-            {{if .RejectedEventsByKind}}
-            <table>
-                <thead>
-                    <tr>
-                        <th>Kind</th>
-                        <th>Total Rejected</th>
-                        <th>Unique Pubkeys</th>
-                        <th>Last Seen</th>
-                    </tr>
-                </thead>
-                <tbody>
-                    {{range .RejectedEventsByKind}}
-                    <tr>
-                        <td><span class="kind-badge">{{.Kind}}</span></td>
-                        <td class="count">{{.TotalCount}}</td>
-                        <td>{{.UniquePubkeys}}</td>
-                        <td class="time-ago">{{.LastSeenAgo}}</td>
-                    </tr>
-                    {{end}}
-                </tbody>
-            </table>
-            {{else}}
-            <div class="empty-state">No rejected events recorded yet</div>
-            {{end}}
-        </div>
-
-        <div class="section">
-            <h2>👤 Top Rejected Event Senders</h2>
-            {{if .RejectedEventStats}}
-            <table>
-                <thead>
-                    <tr>
-                        <th>Pubkey</th>
-                        <th>Name</th>
-                        <th>Kind</th>
-                        <th>Count</th>
-                        <th>Last Seen</th>
-                    </tr>
-                </thead>
-                <tbody>
-                    {{range .RejectedEventStats}}
-                    <tr>
-                        <td class="pubkey">{{.PubkeyShort}}</td>
-                        <td>{{if .Name}}{{.Name}}{{else}}<span style="color:#52525b">—</span>{{end}}</td>
-                        <td><span class="kind-badge">{{.Kind}}</span></td>
-                        <td class="count">{{.Count}}</td>
-                        <td class="time-ago">{{.LastSeenAgo}}</td>
-                    </tr>
-                    {{end}}
-                </tbody>
-            </table>
-            {{else}}
-            <div class="empty-state">No rejected events recorded yet</div>
-            {{end}}
-        </div>
-
-        <div class="section">
-            <h2>🔍 Rejected REQs (Unsupported Kinds)</h2>
-            {{if .RejectedREQStats}}
-            <table>
-                <thead>
-                    <tr>
-                        <th>Kind</th>
-                        <th>REQ Count</th>
-                        <th>Last Seen</th>
-                    </tr>
-                </thead>
-                <tbody>
-                    {{range .RejectedREQStats}}
-                    <tr>
-                        <td><span class="kind-badge">{{.Kind}}</span></td>
-                        <td class="count">{{.Count}}</td>
-                        <td class="time-ago">{{.LastSeenAgo}}</td>
-                    </tr>
-                    {{end}}
-                </tbody>
-            </table>
-            {{else}}
-            <div class="empty-state">No rejected REQs recorded yet</div>
-            {{end}}
-        </div>
-
-        <div class="section">
-            <h2>📊 All REQ Kinds (Top 50)</h2>
-            {{if .REQKindStats}}
-            <table>
-                <thead>
-                    <tr>
-                        <th>Kind</th>
-                        <th>Total REQs</th>
-                        <th>Last Request</th>
-                    </tr>
-                </thead>
-                <tbody>
-                    {{range .REQKindStats}}
-                    <tr>
-                        <td><span class="kind-badge">{{.Kind}}</span></td>
-                        <td class="count">{{.TotalRequests}}</td>
-                        <td class="time-ago">{{.LastRequestAgo}}</td>
-                    </tr>
-                    {{end}}
-                </tbody>
-            </table>
-            {{else}}
-            <div class="empty-state">No REQ stats recorded yet</div>
-            {{end}}
-        </div>
-
-        <div class="section">
-            <h2>📅 REQ Kinds by Day (Last 7 Days)</h2>
-            {{if .REQKindDaily}}
-            <div class="daily-stats">
-                {{range .REQKindDaily}}
-                <div class="daily-row">
-                    <span class="daily-date">{{.Date}}</span>
-                    <div class="daily-kinds">
-                        {{range .Kinds}}
-                        <span class="daily-kind">
-                            <span class="kind-num">k{{.Kind}}</span>
-                            <span class="kind-count">×{{.Count}}</span>
-                        </span>
-                        {{end}}
-                    </div>
-                </div>
-                {{end}}
-            </div>
-            {{else}}
-            <div class="empty-state">No daily REQ stats recorded yet</div>
-            {{end}}
-        </div>
-    </div>
-</body>
-</html>`
-
 type RejectionHandler struct {
 	storage *storage.Storage
 }
@@ -496,6 +28,44 @@ type RejectedEventStatView struct {
 	LastSeenAgo string
 }
 
+type QuotaRejectionView struct {
+	PubkeyShort string
+	Name        string
+	Date        string
+	Accepted    int64
+	Rejected    int64
+	LastSeenAgo string
+}
+
+type OversizeAttemptView struct {
+	IP          string
+	Reason      string
+	Attempts    int64
+	Largest     int64
+	LastSeenAgo string
+}
+
+type InvalidEventView struct {
+	Kind        int
+	Reason      string
+	Attempts    int64
+	LastSeenAgo string
+}
+
+type OriginRejectionView struct {
+	Origin      string
+	Rule        string
+	Attempts    int64
+	LastSeenAgo string
+}
+
+type PrivacyRejectedREQView struct {
+	Kind        int
+	Policy      string
+	Count       int64
+	LastSeenAgo string
+}
+
 type RejectedKindSummaryView struct {
 	Kind          int
 	TotalCount    int64
@@ -537,6 +107,23 @@ type RejectionPageData struct {
 	RejectedREQStats     []RejectedREQStatView
 	REQKindStats         []REQKindStatView
 	REQKindDaily         []DailyStatsView
+
+	// REQs per day of the charted kinds, see reqKindChart
+	REQKindChart     template.JS
+	HasREQKindChart  bool
+	REQKindChartDays int
+	REQKindChartKind string // ?kinds= the chart is drawn for, empty for the most requested
+
+	QuotaRejectedTotal int64
+	QuotaRejections    []QuotaRejectionView
+
+	OversizeAttempts []OversizeAttemptView
+
+	OriginRejections []OriginRejectionView
+
+	InvalidEvents []InvalidEventView
+
+	PrivacyRejectedREQs []PrivacyRejectedREQView
 }
 
 func (h *RejectionHandler) HandleRejectionStats() http.HandlerFunc {
@@ -586,6 +173,63 @@ func (h *RejectionHandler) HandleRejectionStats() http.HandlerFunc {
 			})
 		}
 
+		// Get pubkeys that exhausted their daily publishing quota
+		quotaRejectedTotal, _ := h.storage.GetQuotaRejectedTotal(ctx, 7)
+		quotaRejections, _ := h.storage.GetQuotaRejections(ctx, 7, 50)
+		quotaPubkeys := make([]string, len(quotaRejections))
+		for i, q := range quotaRejections {
+			quotaPubkeys[i] = q.Pubkey
+		}
+		quotaNames, _ := h.storage.GetProfileNames(ctx, quotaPubkeys)
+		quotaViews := make([]QuotaRejectionView, 0, len(quotaRejections))
+		for _, q := range quotaRejections {
+			quotaViews = append(quotaViews, QuotaRejectionView{
+				PubkeyShort: shortPubkey(q.Pubkey),
+				Name:        quotaNames[q.Pubkey],
+				Date:        q.Date,
+				Accepted:    q.Accepted,
+				Rejected:    q.Rejected,
+				LastSeenAgo: formatTimeAgo(now.Sub(q.LastSeen)),
+			})
+		}
+
+		// Get IPs sending events over the tag/content limits or messages over the size limit
+		oversize, _ := h.storage.GetOversizeAttempts(ctx, 50)
+		oversizeViews := make([]OversizeAttemptView, 0, len(oversize))
+		for _, o := range oversize {
+			oversizeViews = append(oversizeViews, OversizeAttemptView{
+				IP:          o.IP,
+				Reason:      o.Reason,
+				Attempts:    o.Attempts,
+				Largest:     o.Largest,
+				LastSeenAgo: formatTimeAgo(now.Sub(o.LastSeen)),
+			})
+		}
+
+		// Get events refused for a structure that doesn't fit their kind
+		invalid, _ := h.storage.GetInvalidEventCounts(ctx, 50)
+		invalidViews := make([]InvalidEventView, 0, len(invalid))
+		for _, c := range invalid {
+			invalidViews = append(invalidViews, InvalidEventView{
+				Kind:        c.Kind,
+				Reason:      c.Reason,
+				Attempts:    c.Attempts,
+				LastSeenAgo: formatTimeAgo(now.Sub(c.LastSeen)),
+			})
+		}
+
+		// Get browser origins refused by the origin policy
+		origins, _ := h.storage.GetOriginRejections(ctx, 50)
+		originViews := make([]OriginRejectionView, 0, len(origins))
+		for _, o := range origins {
+			originViews = append(originViews, OriginRejectionView{
+				Origin:      o.Origin,
+				Rule:        o.Rule,
+				Attempts:    o.Attempts,
+				LastSeenAgo: formatTimeAgo(now.Sub(o.LastSeen)),
+			})
+		}
+
 		// Get rejected REQ stats
 		rejectedREQStats, _ := h.storage.GetRejectedREQStats(ctx, 50)
 		rejectedREQViews := make([]RejectedREQStatView, 0, len(rejectedREQStats))
@@ -597,6 +241,18 @@ func (h *RejectionHandler) HandleRejectionStats() http.HandlerFunc {
 			})
 		}
 
+		// Get REQs withheld by kind privacy policies
+		privacyStats, _ := h.storage.GetPrivacyRejectedREQStats(ctx)
+		privacyViews := make([]PrivacyRejectedREQView, 0, len(privacyStats))
+		for _, p := range privacyStats {
+			privacyViews = append(privacyViews, PrivacyRejectedREQView{
+				Kind:        p.Kind,
+				Policy:      p.Policy,
+				Count:       p.Count,
+				LastSeenAgo: formatTimeAgo(now.Sub(p.LastSeen)),
+			})
+		}
+
 		// Get all REQ kind stats
 		reqKindStats, _ := h.storage.GetREQKindStats(ctx, 50)
 		reqKindViews := make([]REQKindStatView, 0, len(reqKindStats))
@@ -631,6 +287,14 @@ func (h *RejectionHandler) HandleRejectionStats() http.HandlerFunc {
 			}
 		}
 
+		// Chart REQs per day by kind; a malformed ?kinds= charts the most requested
+		chartKinds, err := kindsParam(r)
+		if err != nil {
+			chartKinds = nil
+		}
+		chartDays := reqKindDays(r)
+		chartSeries, _ := h.reqKindSeries(ctx, chartDays, chartKinds)
+
 		data := RejectionPageData{
 			RejectedEventTotal:   rejectedEventTotal,
 			RejectedEventKinds:   rejectedEventKinds,
@@ -642,9 +306,21 @@ func (h *RejectionHandler) HandleRejectionStats() http.HandlerFunc {
 			RejectedREQStats:     rejectedREQViews,
 			REQKindStats:         reqKindViews,
 			REQKindDaily:         dailyViews,
+			QuotaRejectedTotal:   quotaRejectedTotal,
+			QuotaRejections:      quotaViews,
+			OversizeAttempts:     oversizeViews,
+			OriginRejections:     originViews,
+			InvalidEvents:        invalidViews,
+			PrivacyRejectedREQs:  privacyViews,
+			REQKindChart:         reqKindChart(chartSeries),
+			HasREQKindChart:      len(chartSeries) > 0,
+			REQKindChartDays:     chartDays,
+		}
+		if chartKinds != nil {
+			data.REQKindChartKind = r.URL.Query().Get("kinds")
 		}
 
-		tmpl, err := template.New("rejections").Parse(rejectionTemplate)
+		tmpl, err := templates.Get("rejections", nil)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Template error: %v", err), http.StatusInternalServerError)
 			return
@@ -3,123 +3,14 @@ package stats
 import (
 	"context"
 	"fmt"
-	"html/template"
 	"net/http"
+	"sort"
 	"time"
-)
 
-var relaysTemplate = `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>purplepag.es - Discovered Relays</title>
-    <style>
-        * { margin: 0; padding: 0; box-sizing: border-box; }
-        body {
-            font-family: 'SF Mono', 'Monaco', 'Inconsolata', 'Fira Code', monospace;
-            background: #0d1117;
-            min-height: 100vh;
-            padding: 2rem;
-            color: #c9d1d9;
-        }
-        .container { max-width: 1400px; margin: 0 auto; }
-        header { margin-bottom: 2rem; border-bottom: 1px solid #21262d; padding-bottom: 1rem; }
-        h1 { font-size: 1.5rem; font-weight: 600; color: #f0f6fc; margin-bottom: 0.25rem; }
-        .subtitle { font-size: 0.875rem; color: #8b949e; }
-        .back-link { display: inline-block; margin-bottom: 1rem; color: #58a6ff; text-decoration: none; font-size: 0.875rem; }
-        .back-link:hover { text-decoration: underline; }
-        .table-container {
-            background: #161b22;
-            border: 1px solid #21262d;
-            border-radius: 6px;
-            padding: 1rem;
-            overflow-x: auto;
-        }
-        table { width: 100%; border-collapse: collapse; }
-        thead th {
-            padding: 0.5rem;
-            text-align: left;
-            font-weight: 600;
-            text-transform: uppercase;
-            font-size: 0.625rem;
-            color: #8b949e;
-            border-bottom: 1px solid #21262d;
-        }
-        tbody tr:hover { background: #1c2128; }
-        tbody td { padding: 0.5rem; border-bottom: 1px solid #21262d; font-size: 0.75rem; }
-        .relay-url a { color: #58a6ff; text-decoration: none; }
-        .relay-url a:hover { text-decoration: underline; }
-        .time-ago { color: #8b949e; }
-        .success-rate { font-weight: 600; }
-        .success-rate.high { color: #3fb950; }
-        .success-rate.medium { color: #d29922; }
-        .success-rate.low { color: #f85149; }
-        .status {
-            display: inline-block;
-            padding: 0.125rem 0.5rem;
-            border-radius: 4px;
-            font-size: 0.625rem;
-            font-weight: 600;
-        }
-        .status.active { background: #238636; color: #fff; }
-        .status.inactive { background: #21262d; color: #8b949e; }
-        .events-count { font-weight: 600; font-variant-numeric: tabular-nums; color: #f0f6fc; }
-        .no-relays { text-align: center; padding: 2rem; color: #8b949e; }
-        @media (max-width: 768px) {
-            body { padding: 1rem; }
-            thead th, tbody td { padding: 0.375rem; }
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <a href="/stats" class="back-link">← Back to Stats</a>
-
-        <header>
-            <h1>Discovered Relays</h1>
-            <div class="subtitle">{{.TotalCount}} relays discovered from kind:10002 events</div>
-        </header>
-
-        {{if .Relays}}
-        <div class="table-container">
-            <table>
-                <thead>
-                    <tr>
-                        <th>Relay URL</th>
-                        <th>Pubkeys</th>
-                        <th>First Seen</th>
-                        <th>Last Sync</th>
-                        <th>Success Rate</th>
-                        <th>Events</th>
-                        <th>Status</th>
-                    </tr>
-                </thead>
-                <tbody>
-                    {{range .Relays}}
-                    <tr>
-                        <td class="relay-url"><a href="{{.URL}}" target="_blank" rel="noopener">{{.URL}}</a></td>
-                        <td class="events-count">{{.PubkeyCount}}</td>
-                        <td class="time-ago">{{.FirstSeenAgo}}</td>
-                        <td class="time-ago">{{.LastSyncAgo}}</td>
-                        <td class="success-rate {{.SuccessRateClass}}">{{.SuccessRate}}</td>
-                        <td class="events-count">{{.EventsContributed}}</td>
-                        <td><span class="status {{.StatusClass}}">{{.StatusText}}</span></td>
-                    </tr>
-                    {{end}}
-                </tbody>
-            </table>
-        </div>
-        {{else}}
-        <div class="table-container">
-            <div class="no-relays">
-                <p>No relays discovered yet. Kind:10002 events will be scanned for relay URLs.</p>
-            </div>
-        </div>
-        {{end}}
-    </div>
-</body>
-</html>`
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
+)
 
 type RelayInfo struct {
 	URL               string
@@ -128,14 +19,31 @@ type RelayInfo struct {
 	SuccessRate       string
 	SuccessRateClass  string
 	EventsContributed int64
-	PubkeyCount       int64
-	StatusClass       string
-	StatusText        string
+	// NewEvents counts events this relay delivered before any other source did
+	NewEvents      int64
+	NewEventsTitle string
+	PubkeyCount    int64
+	Source         string
+	SourceTitle    string
+	// BlockedBy counts users listing the relay in their kind 10006 blocked relays
+	BlockedBy    int64
+	BlockedClass string
+	StatusClass  string
+	StatusText   string
+	// Integrity of the events this relay delivered; empty until it delivered any
+	Integrity      string
+	IntegrityClass string
+	IntegrityTitle string
+	// Outcome of our NIP-42 auth attempts; empty until we authenticated to it
+	Auth      string
+	AuthClass string
+	AuthTitle string
 }
 
 type RelaysPageData struct {
 	TotalCount int
 	Relays     []RelayInfo
+	Sort       string // pubkeys, or new to rank by new events contributed
 }
 
 func (s *Stats) HandleRelays() http.HandlerFunc {
@@ -148,6 +56,16 @@ func (s *Stats) HandleRelays() http.HandlerFunc {
 			return
 		}
 
+		integrity, err := s.storage.GetRelayIntegrity(ctx)
+		if err != nil {
+			integrity = nil
+		}
+
+		auth, err := s.storage.GetRelayAuth(ctx)
+		if err != nil {
+			auth = nil
+		}
+
 		relayInfos := make([]RelayInfo, 0, len(relays))
 		now := time.Now()
 
@@ -181,6 +99,50 @@ func (s *Stats) HandleRelays() http.HandlerFunc {
 				lastSyncAgo = formatTimeAgo(now.Sub(relay.LastSync))
 			}
 
+			integrityStr, integrityClass, integrityTitle := "—", "", ""
+			ri, ok := integrity[relay.URL]
+			if !ok {
+				ri, ok = integrity[nostr.NormalizeURL(relay.URL)]
+			}
+			newEventsTitle := ""
+			if ok && ri.Delivered > 0 {
+				newEventsTitle = fmt.Sprintf("%.1f%% of %d delivered", float64(ri.FirstSeen)*100/float64(ri.Delivered), ri.Delivered)
+			}
+			if ok && ri.Delivered+ri.InvalidSig > 0 {
+				score := ri.Score()
+				integrityStr = fmt.Sprintf("%.0f", score)
+				integrityClass = "low"
+				if score >= 90 {
+					integrityClass = "high"
+				} else if score >= 50 {
+					integrityClass = "medium"
+				}
+				integrityTitle = fmt.Sprintf("%d delivered, %d stale, %d bad signatures, %d duplicates",
+					ri.Delivered, ri.Stale, ri.InvalidSig, ri.Duplicates)
+			}
+
+			authStr, authClass, authTitle := "—", "", ""
+			ra, ok := auth[relay.URL]
+			if !ok {
+				ra, ok = auth[nostr.NormalizeURL(relay.URL)]
+			}
+			if ok {
+				authStr, authClass = "OK", "high"
+				if ra.LastError != "" {
+					authStr, authClass = "Failed", "low"
+				}
+				authTitle = fmt.Sprintf("%d succeeded, %d failed, last %s", ra.Successes, ra.Failures, formatTimeAgo(now.Sub(ra.LastAttemptAt)))
+				if ra.LastError != "" {
+					authTitle += ": " + ra.LastError
+				}
+			}
+
+			source, sourceTitle := relaySourceLabel(relay.Source)
+			blockedClass := ""
+			if relay.BlockedBy >= storage.RelayBlockThreshold {
+				blockedClass = "low"
+			}
+
 			relayInfos = append(relayInfos, RelayInfo{
 				URL:               relay.URL,
 				FirstSeenAgo:      formatTimeAgo(now.Sub(relay.FirstSeen)),
@@ -188,18 +150,39 @@ func (s *Stats) HandleRelays() http.HandlerFunc {
 				SuccessRate:       successRateStr,
 				SuccessRateClass:  successRateClass,
 				EventsContributed: relay.EventsContributed,
+				NewEvents:         ri.FirstSeen,
+				NewEventsTitle:    newEventsTitle,
 				PubkeyCount:       relay.PubkeyCount,
+				Source:            source,
+				SourceTitle:       sourceTitle,
+				BlockedBy:         relay.BlockedBy,
+				BlockedClass:      blockedClass,
 				StatusClass:       statusClass,
 				StatusText:        statusText,
+				Integrity:         integrityStr,
+				IntegrityClass:    integrityClass,
+				IntegrityTitle:    integrityTitle,
+				Auth:              authStr,
+				AuthClass:         authClass,
+				AuthTitle:         authTitle,
+			})
+		}
+
+		sortBy := "pubkeys"
+		if r.URL.Query().Get("sort") == "new" {
+			sortBy = "new"
+			sort.SliceStable(relayInfos, func(i, j int) bool {
+				return relayInfos[i].NewEvents > relayInfos[j].NewEvents
 			})
 		}
 
 		data := RelaysPageData{
 			TotalCount: len(relayInfos),
 			Relays:     relayInfos,
+			Sort:       sortBy,
 		}
 
-		tmpl, err := template.New("relays").Parse(relaysTemplate)
+		tmpl, err := templates.Get("relays", nil)
 		if err != nil {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
@@ -212,6 +195,18 @@ func (s *Stats) HandleRelays() http.HandlerFunc {
 	}
 }
 
+// relaySourceLabel names where a relay was discovered, for the relays table
+func relaySourceLabel(source string) (string, string) {
+	switch source {
+	case storage.RelaySourceSearchList:
+		return "Search list", "Listed in a kind:10007 search relay list"
+	case storage.RelaySourceNIP11:
+		return "NIP-11", "Pointed to by another relay's NIP-11 document"
+	default:
+		return "Relay list", "Listed in a kind:10002 relay list"
+	}
+}
+
 func formatTimeAgo(d time.Duration) string {
 	if d < time.Minute {
 		return "just now"
@@ -0,0 +1,140 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/kinds"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+const (
+	// reqKindChartDays is how far back the REQ kind chart and API look by default
+	reqKindChartDays = 30
+	// reqKindMaxDays is how far back they can be asked to look
+	reqKindMaxDays = 365
+	// reqKindChartKinds is how many of the most requested kinds are charted
+	// when no kinds are picked
+	reqKindChartKinds = 6
+)
+
+// reqKindSeries returns the REQs per day of each kind over the last days days,
+// oldest first, with a zero for every day a kind wasn't requested. Without
+// wanted kinds, the reqKindChartKinds most requested over the span are returned.
+// Series are ordered by their total, most requested first.
+func (h *RejectionHandler) reqKindSeries(ctx context.Context, days int, wanted []int) ([]storage.KindCountSeries, error) {
+	stats, err := h.storage.GetREQKindDailyStats(ctx, days, wanted)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[int]int64)
+	byKind := make(map[int]map[string]int64)
+	for _, s := range stats {
+		totals[s.Kind] += s.RequestCount
+		if byKind[s.Kind] == nil {
+			byKind[s.Kind] = make(map[string]int64)
+		}
+		byKind[s.Kind][s.Date] = s.RequestCount
+	}
+
+	charted := make([]int, 0, len(totals))
+	for kind := range totals {
+		charted = append(charted, kind)
+	}
+	sort.Slice(charted, func(i, j int) bool {
+		if totals[charted[i]] != totals[charted[j]] {
+			return totals[charted[i]] > totals[charted[j]]
+		}
+		return charted[i] < charted[j]
+	})
+	if len(wanted) == 0 && len(charted) > reqKindChartKinds {
+		charted = charted[:reqKindChartKinds]
+	}
+
+	// Dates are recorded in local time, like GetREQKindDailyStats' cutoff
+	today := time.Now()
+	dates := make([]string, 0, days+1)
+	for d := days; d >= 0; d-- {
+		dates = append(dates, today.AddDate(0, 0, -d).Format("2006-01-02"))
+	}
+
+	series := make([]storage.KindCountSeries, 0, len(charted))
+	for _, kind := range charted {
+		ks := storage.KindCountSeries{Kind: kind, Name: kinds.Name(kind), Points: make([]storage.KindCountPoint, len(dates))}
+		for i, date := range dates {
+			ks.Points[i] = storage.KindCountPoint{Date: date, Count: byKind[kind][date]}
+		}
+		series = append(series, ks)
+	}
+	return series, nil
+}
+
+// reqKindChart is the /stats/rejections chart of REQs per day by kind
+func reqKindChart(series []storage.KindCountSeries) template.JS {
+	type dataset struct {
+		Label string  `json:"label"`
+		Data  []int64 `json:"data"`
+	}
+	var labels []string
+	datasets := make([]dataset, 0, len(series))
+	for _, ks := range series {
+		if labels == nil {
+			labels = make([]string, len(ks.Points))
+			for i, p := range ks.Points {
+				labels[i] = p.Date
+			}
+		}
+		d := dataset{Label: ks.Name + " (" + strconv.Itoa(ks.Kind) + ")", Data: make([]int64, len(ks.Points))}
+		for i, p := range ks.Points {
+			d.Data[i] = p.Count
+		}
+		datasets = append(datasets, d)
+	}
+
+	chartJSON, _ := json.Marshal(map[string]interface{}{
+		"labels":   labels,
+		"datasets": datasets,
+	})
+	return template.JS(chartJSON)
+}
+
+// reqKindDays reads ?days=, defaulting to reqKindChartDays
+func reqKindDays(r *http.Request) int {
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days <= 0 {
+		return reqKindChartDays
+	}
+	return min(days, reqKindMaxDays)
+}
+
+// HandleREQKindHistory serves the REQs per day by kind behind the
+// /stats/rejections chart. ?days= is how far back to look (default 30, at most
+// 365) and ?kinds= a comma-separated list of kinds (default: the most requested).
+func (h *RejectionHandler) HandleREQKindHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wanted, err := kindsParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		days := reqKindDays(r)
+
+		series, err := h.reqKindSeries(r.Context(), days, wanted)
+		if err != nil {
+			http.Error(w, "Failed to load REQ kind history", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"days":   days,
+			"series": series,
+		})
+	}
+}
@@ -3,9 +3,11 @@ package stats
 import (
 	"context"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 
+	"github.com/pablof7z/purplepag.es/geoip"
 	"github.com/pablof7z/purplepag.es/storage"
 )
 
@@ -18,14 +20,28 @@ type Stats struct {
 	rejectedEvents int64
 	activeConns    int64
 	totalConns     int64
-	storage        *storage.Storage
+	// Save failures by storage.StorageError class, plus "other" for unclassified ones
+	storageFailures map[string]int64
+	hookLatency     hookHistograms
+	filterSizes     filterSizeHistograms
+	// Per-kind REQ and rejection counters, written to storage every kindStatsFlushInterval
+	kindStats *storage.KindStatsBatch
+	storage   *storage.Storage
+	// Resolves REQ IPs to regions for the /stats/network heatmap; nil without a GeoIP database
+	geo *geoip.DB
 }
 
-func New(storage *storage.Storage) *Stats {
+const kindStatsFlushInterval = 30 * time.Second
+
+func New(store *storage.Storage) *Stats {
 	return &Stats{
-		startTime:    time.Now(),
-		eventsByKind: make(map[int]int64),
-		storage:      storage,
+		startTime:       time.Now(),
+		eventsByKind:    make(map[int]int64),
+		storageFailures: make(map[string]int64),
+		hookLatency:     hookHistograms{hooks: make(map[string]*HookLatency)},
+		filterSizes:     filterSizeHistograms{dimensions: make(map[string]*FilterSize)},
+		kindStats:       storage.NewKindStatsBatch(),
+		storage:         store,
 	}
 }
 
@@ -45,18 +61,81 @@ func (s *Stats) RecordEventRejected() {
 
 func (s *Stats) RecordEventRejectedForKind(ctx context.Context, kind int, pubkey string) {
 	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.rejectedEvents++
-	s.mu.Unlock()
+	s.kindStats.RejectedEvents[storage.RejectedEventKey{Kind: kind, Pubkey: pubkey}]++
+}
 
-	s.storage.RecordRejectedEvent(ctx, kind, pubkey)
+func (s *Stats) RecordStorageFailure(class string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.storageFailures[class]++
+}
+
+func (s *Stats) GetStorageFailures() map[string]int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make(map[string]int64, len(s.storageFailures))
+	for class, count := range s.storageFailures {
+		result[class] = count
+	}
+	return result
 }
 
 func (s *Stats) RecordRejectedREQ(ctx context.Context, kind int) {
-	s.storage.RecordRejectedREQ(ctx, kind)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kindStats.RejectedREQs[kind]++
+}
+
+func (s *Stats) RecordPrivacyRejectedREQ(ctx context.Context, kind int, policy string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kindStats.PrivacyRejected[storage.PrivacyRejectKey{Kind: kind, Policy: policy}]++
 }
 
 func (s *Stats) RecordREQKind(ctx context.Context, kind int) {
-	s.storage.RecordREQKind(ctx, kind)
+	date := time.Now().Format("2006-01-02")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	daily := s.kindStats.REQKindsDaily[date]
+	if daily == nil {
+		daily = make(map[int]int64)
+		s.kindStats.REQKindsDaily[date] = daily
+	}
+	daily[kind]++
+}
+
+// Start flushes buffered per-kind counters periodically until ctx is done
+func (s *Stats) Start(ctx context.Context) {
+	ticker := time.NewTicker(kindStatsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.flushKindStats(ctx)
+		}
+	}
+}
+
+// Stop writes whatever is still buffered
+func (s *Stats) Stop() {
+	s.flushKindStats(context.Background())
+}
+
+func (s *Stats) flushKindStats(ctx context.Context) {
+	s.mu.Lock()
+	batch := s.kindStats
+	s.kindStats = storage.NewKindStatsBatch()
+	s.mu.Unlock()
+
+	if err := s.storage.FlushKindStats(ctx, batch); err != nil {
+		log.Printf("stats: failed to flush kind stats: %v", err)
+	}
 }
 
 func (s *Stats) RecordConnection() {
@@ -135,10 +214,19 @@ func (s *Stats) GetStorageStats(ctx context.Context) map[int]int64 {
 	return result
 }
 
+// SetGeoIP makes served REQs also count towards their region. Call it before
+// the relay starts serving.
+func (s *Stats) SetGeoIP(db *geoip.DB) {
+	s.geo = db
+}
+
 func (s *Stats) RecordEventsServed(ctx context.Context, ip string, eventsCount int64) {
 	if err := s.storage.RecordDailyStats(ctx, ip, eventsCount); err != nil {
 		// Silently ignore errors for now
 	}
+	if s.geo != nil {
+		s.storage.RecordRegionRequest(ctx, s.geo.Country(ip), eventsCount)
+	}
 }
 
 // FormatBytes converts a byte count to a human-readable string.
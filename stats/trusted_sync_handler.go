@@ -5,280 +5,13 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/pablof7z/purplepag.es/storage"
+	"github.com/pablof7z/purplepag.es/templates"
 )
 
-var trustedSyncTemplate = `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>purplepag.es - Trusted Sync Stats</title>
-    <style>
-        * {
-            margin: 0;
-            padding: 0;
-            box-sizing: border-box;
-        }
-
-        body {
-            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, 'Helvetica Neue', Arial, sans-serif;
-            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
-            min-height: 100vh;
-            padding: 2rem;
-            color: #fff;
-        }
-
-        .container {
-            max-width: 1400px;
-            margin: 0 auto;
-        }
-
-        header {
-            text-align: center;
-            margin-bottom: 3rem;
-        }
-
-        h1 {
-            font-size: 3rem;
-            margin-bottom: 0.5rem;
-            text-shadow: 2px 2px 4px rgba(0,0,0,0.3);
-        }
-
-        .subtitle {
-            font-size: 1.2rem;
-            opacity: 0.9;
-        }
-
-        .back-link {
-            display: inline-block;
-            margin-bottom: 2rem;
-            color: #fff;
-            text-decoration: none;
-            opacity: 0.8;
-            transition: opacity 0.2s;
-        }
-
-        .back-link:hover {
-            opacity: 1;
-        }
-
-        .summary-cards {
-            display: grid;
-            grid-template-columns: repeat(auto-fit, minmax(200px, 1fr));
-            gap: 1.5rem;
-            margin-bottom: 2rem;
-        }
-
-        .card {
-            background: rgba(255, 255, 255, 0.15);
-            backdrop-filter: blur(10px);
-            border-radius: 16px;
-            padding: 1.5rem;
-            text-align: center;
-            border: 1px solid rgba(255, 255, 255, 0.2);
-        }
-
-        .card-value {
-            font-size: 2.5rem;
-            font-weight: 700;
-            margin-bottom: 0.5rem;
-        }
-
-        .card-label {
-            font-size: 0.9rem;
-            opacity: 0.8;
-            text-transform: uppercase;
-            letter-spacing: 1px;
-        }
-
-        h2 {
-            font-size: 1.5rem;
-            margin-bottom: 1rem;
-            margin-top: 2rem;
-        }
-
-        .table-container {
-            background: rgba(255, 255, 255, 0.15);
-            backdrop-filter: blur(10px);
-            border-radius: 16px;
-            padding: 2rem;
-            border: 1px solid rgba(255, 255, 255, 0.2);
-            overflow-x: auto;
-            margin-bottom: 2rem;
-        }
-
-        table {
-            width: 100%;
-            border-collapse: separate;
-            border-spacing: 0;
-        }
-
-        thead th {
-            background: rgba(255, 255, 255, 0.1);
-            padding: 1rem;
-            text-align: left;
-            font-weight: 600;
-            text-transform: uppercase;
-            font-size: 0.85rem;
-            letter-spacing: 0.5px;
-            border-bottom: 2px solid rgba(255, 255, 255, 0.2);
-        }
-
-        thead th:first-child {
-            border-top-left-radius: 8px;
-        }
-
-        thead th:last-child {
-            border-top-right-radius: 8px;
-        }
-
-        tbody tr {
-            transition: background 0.2s;
-        }
-
-        tbody tr:hover {
-            background: rgba(255, 255, 255, 0.1);
-        }
-
-        tbody td {
-            padding: 1rem;
-            border-bottom: 1px solid rgba(255, 255, 255, 0.1);
-        }
-
-        .relay-url {
-            font-family: 'Monaco', 'Menlo', monospace;
-            font-size: 0.9rem;
-            max-width: 400px;
-            overflow: hidden;
-            text-overflow: ellipsis;
-            white-space: nowrap;
-        }
-
-        .relay-url a {
-            color: #fff;
-            text-decoration: none;
-        }
-
-        .relay-url a:hover {
-            text-decoration: underline;
-        }
-
-        .pubkey {
-            font-family: 'Monaco', 'Menlo', monospace;
-            font-size: 0.85rem;
-        }
-
-        .events-count {
-            font-weight: 600;
-            color: #90EE90;
-        }
-
-        .time-ago {
-            opacity: 0.8;
-            font-size: 0.9rem;
-        }
-
-        .no-data {
-            text-align: center;
-            padding: 3rem;
-            opacity: 0.8;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <a href="/stats" class="back-link">← Back to Stats</a>
-
-        <header>
-            <h1>Trusted Sync Stats</h1>
-            <div class="subtitle">Events fetched from trusted users' write relays</div>
-        </header>
-
-        <div class="summary-cards">
-            <div class="card">
-                <div class="card-value">{{.TotalEvents}}</div>
-                <div class="card-label">Total Events</div>
-            </div>
-            <div class="card">
-                <div class="card-value">{{.TotalPubkeys}}</div>
-                <div class="card-label">Unique Pubkeys</div>
-            </div>
-            <div class="card">
-                <div class="card-value">{{.TotalRelays}}</div>
-                <div class="card-label">Relays Used</div>
-            </div>
-        </div>
-
-        <h2>By Relay</h2>
-        {{if .RelayStats}}
-        <div class="table-container">
-            <table>
-                <thead>
-                    <tr>
-                        <th>Relay URL</th>
-                        <th>Events Fetched</th>
-                        <th>Unique Pubkeys</th>
-                        <th>Last Sync</th>
-                    </tr>
-                </thead>
-                <tbody>
-                    {{range .RelayStats}}
-                    <tr>
-                        <td class="relay-url"><a href="{{.RelayURL}}" target="_blank" rel="noopener">{{.RelayURL}}</a></td>
-                        <td class="events-count">{{.TotalEvents}}</td>
-                        <td>{{.UniquePubkeys}}</td>
-                        <td class="time-ago">{{.LastSyncAgo}}</td>
-                    </tr>
-                    {{end}}
-                </tbody>
-            </table>
-        </div>
-        {{else}}
-        <div class="table-container">
-            <div class="no-data">
-                <p>No sync data yet. The trusted syncer will start collecting data after the trust analyzer runs.</p>
-            </div>
-        </div>
-        {{end}}
-
-        <h2>Top Pubkeys</h2>
-        {{if .PubkeyStats}}
-        <div class="table-container">
-            <table>
-                <thead>
-                    <tr>
-                        <th>Pubkey</th>
-                        <th>Events Fetched</th>
-                        <th>Relays</th>
-                        <th>Last Sync</th>
-                    </tr>
-                </thead>
-                <tbody>
-                    {{range .PubkeyStats}}
-                    <tr>
-                        <td class="pubkey">{{.PubkeyShort}}</td>
-                        <td class="events-count">{{.TotalEvents}}</td>
-                        <td>{{.RelayCount}}</td>
-                        <td class="time-ago">{{.LastSyncAgo}}</td>
-                    </tr>
-                    {{end}}
-                </tbody>
-            </table>
-        </div>
-        {{else}}
-        <div class="table-container">
-            <div class="no-data">
-                <p>No pubkey data yet.</p>
-            </div>
-        </div>
-        {{end}}
-    </div>
-</body>
-</html>`
-
 type TrustedSyncRelayInfo struct {
 	RelayURL      string
 	TotalEvents   int64
@@ -293,12 +26,33 @@ type TrustedSyncPubkeyInfo struct {
 	LastSyncAgo string
 }
 
+type TrustedSyncFlaggedRelay struct {
+	RelayURL    string
+	Capability  string
+	Reason      string
+	DetectedAgo string
+}
+
+// TrustedSyncCycleInfo is the progress of the current pass over all trusted pubkeys
+type TrustedSyncCycleInfo struct {
+	Percent    string
+	Processed  int
+	Total      int
+	StartedAgo string
+	Completed  bool
+}
+
 type TrustedSyncPageData struct {
-	TotalEvents  int64
-	TotalPubkeys int64
-	TotalRelays  int64
-	RelayStats   []TrustedSyncRelayInfo
-	PubkeyStats  []TrustedSyncPubkeyInfo
+	TotalEvents   int64
+	TotalPubkeys  int64
+	TotalRelays   int64
+	Cycle         *TrustedSyncCycleInfo // nil before the first cycle
+	RelayStats    []TrustedSyncRelayInfo
+	PubkeyStats   []TrustedSyncPubkeyInfo
+	FlaggedRelays []TrustedSyncFlaggedRelay
+	// Scheduled NIP-77 reconciliations with negentropy_peers
+	NegentropyRuns  []NegentropyRunDisplay
+	NegentropyChart template.JS
 }
 
 type TrustedSyncHandler struct {
@@ -357,15 +111,47 @@ func (h *TrustedSyncHandler) HandleTrustedSyncStats() http.HandlerFunc {
 			})
 		}
 
+		var cycleInfo *TrustedSyncCycleInfo
+		if cycle, _ := h.storage.GetLatestTrustedSyncCycle(ctx); cycle != nil {
+			cycleInfo = &TrustedSyncCycleInfo{
+				Percent:    fmt.Sprintf("%.1f%%", cycle.Percent()),
+				Processed:  cycle.Processed,
+				Total:      cycle.Total,
+				StartedAgo: timeAgo(now, cycle.StartedAt()),
+				Completed:  !cycle.CompletedAt.IsZero(),
+			}
+		}
+
+		// Relays skipped for anonymous fetches
+		capabilities, _ := h.storage.GetRelayCapabilities(ctx, now.Add(-storage.RelayCapabilityTTL))
+		flagged := make([]TrustedSyncFlaggedRelay, 0, len(capabilities))
+		for _, c := range capabilities {
+			flagged = append(flagged, TrustedSyncFlaggedRelay{
+				RelayURL:    c.URL,
+				Capability:  c.Capability,
+				Reason:      c.Reason,
+				DetectedAgo: timeAgo(now, c.DetectedAt),
+			})
+		}
+		sort.Slice(flagged, func(i, j int) bool {
+			return flagged[i].RelayURL < flagged[j].RelayURL
+		})
+
+		negentropyRuns, negentropyChart, _ := h.negentropySyncs(ctx, now)
+
 		data := TrustedSyncPageData{
-			TotalEvents:  totalEvents,
-			TotalPubkeys: totalPubkeys,
-			TotalRelays:  totalRelays,
-			RelayStats:   relayInfos,
-			PubkeyStats:  pubkeyInfos,
+			TotalEvents:     totalEvents,
+			TotalPubkeys:    totalPubkeys,
+			TotalRelays:     totalRelays,
+			Cycle:           cycleInfo,
+			RelayStats:      relayInfos,
+			PubkeyStats:     pubkeyInfos,
+			FlaggedRelays:   flagged,
+			NegentropyRuns:  negentropyRuns,
+			NegentropyChart: negentropyChart,
 		}
 
-		tmpl, err := template.New("trusted_sync").Parse(trustedSyncTemplate)
+		tmpl, err := templates.Get("trusted_sync", nil)
 		if err != nil {
 			http.Error(w, "Template error", http.StatusInternalServerError)
 			return
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/config"
+	"github.com/pablof7z/purplepag.es/kinds"
+	relay2 "github.com/pablof7z/purplepag.es/relay"
+	"github.com/pablof7z/purplepag.es/stats"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// operatorStats is the output of `purplepages stats`
+type operatorStats struct {
+	EventsByKind      map[int]int64   `json:"events_by_kind"`
+	TotalEvents       int64           `json:"total_events"`
+	EventStoreBytes   int64           `json:"event_store_bytes"`
+	AnalyticsDBBytes  int64           `json:"analytics_db_bytes"`
+	REQsToday         int64           `json:"reqs_today"`
+	UniqueIPsToday    int64           `json:"unique_ips_today"`
+	EventsServedToday int64           `json:"events_served_today"`
+	TopRequested      []requestedStat `json:"top_requested"`
+	PendingHydration  int             `json:"pending_hydration"`
+	TrustedPubkeys    int             `json:"trusted_pubkeys"`
+}
+
+type requestedStat struct {
+	Pubkey   string `json:"pubkey"`
+	Name     string `json:"name,omitempty"`
+	Requests int64  `json:"requests"`
+}
+
+func runStatsCommand(args []string) {
+	statsFlags := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := statsFlags.Bool("json", false, "Print stats as JSON")
+	top := statsFlags.Int("top", 10, "Number of top requested pubkeys to show")
+	statsFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages stats [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Print key relay numbers without the web dashboard.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		statsFlags.PrintDefaults()
+	}
+
+	if err := statsFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load("config.json")
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	kinds.SetNames(cfg.NamedKinds())
+
+	store, err := storage.New(cfg.Storage.Backend, cfg.Storage.Path, false, cfg.Storage.AnalyticsDBURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer store.Close()
+	store.SetScanChunkSize(cfg.Storage.ScanChunkSize)
+
+	ctx := context.Background()
+	out := collectOperatorStats(ctx, cfg, store, *top)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			log.Fatalf("Failed to encode stats: %v", err)
+		}
+		return
+	}
+
+	printOperatorStats(out)
+}
+
+// collectOperatorStats gathers each number independently; a failing source is
+// reported as zero rather than aborting, so cron reports still go out
+func collectOperatorStats(ctx context.Context, cfg *config.Config, store *storage.Storage, top int) *operatorStats {
+	out := &operatorStats{EventsByKind: make(map[int]int64)}
+
+	if counts, err := store.GetEventCountsByKind(ctx); err != nil {
+		log.Printf("Failed to count events by kind: %v", err)
+	} else {
+		out.EventsByKind = counts
+		for _, c := range counts {
+			out.TotalEvents += c
+		}
+	}
+
+	if size, err := store.GetEventTableSize(ctx); err == nil {
+		out.EventStoreBytes = size
+	}
+	if size, err := store.GetAnalyticsDBSize(ctx); err == nil {
+		out.AnalyticsDBBytes = size
+	}
+
+	if today, err := store.GetTodayStats(ctx); err == nil && today != nil {
+		out.REQsToday = today.TotalREQs
+		out.UniqueIPsToday = today.UniqueIPs
+		out.EventsServedToday = today.EventsServed
+	}
+
+	if topRequested, err := store.GetTopRequestedPubkeys(ctx, top); err == nil {
+		pubkeys := make([]string, len(topRequested))
+		for i, p := range topRequested {
+			pubkeys[i] = p.Pubkey
+		}
+		names, _ := store.GetProfileNames(ctx, pubkeys)
+		for _, p := range topRequested {
+			out.TopRequested = append(out.TopRequested, requestedStat{
+				Pubkey:   p.Pubkey,
+				Name:     names[p.Pubkey],
+				Requests: p.TotalRequests,
+			})
+		}
+	}
+
+	hydrator := relay2.NewProfileHydrator(store, nil,
+		cfg.ProfileHydration.MinFollowers,
+		cfg.ProfileHydration.RetryAfterHours,
+		cfg.ProfileHydration.BatchSize,
+	)
+	hydrator.SetDeadAccountPolicy(cfg.ProfileHydration.DeadAfterRounds, time.Duration(cfg.ProfileHydration.DeadRetryDays)*24*time.Hour)
+	out.PendingHydration = len(hydrator.FindPubkeysNeedingHydration(ctx))
+
+	if trusted, err := store.GetTrustedPubkeys(ctx); err == nil {
+		out.TrustedPubkeys = len(trusted)
+	}
+
+	return out
+}
+
+func printOperatorStats(out *operatorStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Total events\t%d\n", out.TotalEvents)
+	fmt.Fprintf(w, "Event store size\t%s\n", stats.FormatBytes(out.EventStoreBytes))
+	fmt.Fprintf(w, "Analytics DB size\t%s\n", stats.FormatBytes(out.AnalyticsDBBytes))
+	fmt.Fprintf(w, "REQs today\t%d\n", out.REQsToday)
+	fmt.Fprintf(w, "Unique IPs today\t%d\n", out.UniqueIPsToday)
+	fmt.Fprintf(w, "Events served today\t%d\n", out.EventsServedToday)
+	fmt.Fprintf(w, "Pending hydration\t%d\n", out.PendingHydration)
+	fmt.Fprintf(w, "Trusted pubkeys\t%d\n", out.TrustedPubkeys)
+
+	fmt.Fprintf(w, "\nKind\tName\tEvents\n")
+	stored := make([]int, 0, len(out.EventsByKind))
+	for kind := range out.EventsByKind {
+		stored = append(stored, kind)
+	}
+	sort.Ints(stored)
+	for _, kind := range stored {
+		fmt.Fprintf(w, "%d\t%s\t%d\n", kind, kinds.Name(kind), out.EventsByKind[kind])
+	}
+
+	if len(out.TopRequested) > 0 {
+		fmt.Fprintf(w, "\nTop requested\tRequests\n")
+		for _, p := range out.TopRequested {
+			label := p.Pubkey
+			if p.Name != "" {
+				label = fmt.Sprintf("%s (%s)", p.Name, p.Pubkey[:16])
+			}
+			fmt.Fprintf(w, "%s\t%d\n", label, p.Requests)
+		}
+	}
+
+	w.Flush()
+}
@@ -2,9 +2,15 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"strings"
 	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
 )
 
 type PubkeyStats struct {
@@ -90,6 +96,15 @@ func (s *Storage) InitAnalyticsSchema() error {
 	);
 	CREATE INDEX IF NOT EXISTS idx_cooccur_count ON req_cooccurrence(count DESC);
 
+	CREATE TABLE IF NOT EXISTS req_interest_edges (
+		from_pubkey TEXT NOT NULL,
+		to_pubkey TEXT NOT NULL,
+		weight INTEGER NOT NULL DEFAULT 0,
+		last_seen INTEGER NOT NULL,
+		PRIMARY KEY (from_pubkey, to_pubkey)
+	);
+	CREATE INDEX IF NOT EXISTS idx_interest_from_weight ON req_interest_edges(from_pubkey, weight DESC);
+
 	` + botClustersTable + `
 
 	CREATE TABLE IF NOT EXISTS bot_cluster_members (
@@ -100,6 +115,11 @@ func (s *Storage) InitAnalyticsSchema() error {
 	);
 	CREATE INDEX IF NOT EXISTS idx_cluster_member_pubkey ON bot_cluster_members(pubkey);
 
+	CREATE TABLE IF NOT EXISTS bot_cluster_exemptions (
+		pubkey TEXT PRIMARY KEY,
+		exempted_at INTEGER NOT NULL
+	);
+
 	CREATE TABLE IF NOT EXISTS spam_candidates (
 		pubkey TEXT PRIMARY KEY,
 		detected_at INTEGER NOT NULL,
@@ -109,6 +129,56 @@ func (s *Storage) InitAnalyticsSchema() error {
 	);
 	CREATE INDEX IF NOT EXISTS idx_spam_purged ON spam_candidates(purged);
 
+	CREATE TABLE IF NOT EXISTS impersonation_candidates (
+		pubkey TEXT PRIMARY KEY,
+		target_pubkey TEXT NOT NULL,
+		name TEXT NOT NULL,
+		picture TEXT NOT NULL,
+		followers INTEGER NOT NULL,
+		target_followers INTEGER NOT NULL,
+		detected_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_impersonation_target ON impersonation_candidates(target_pubkey);
+
+	-- Spam and impersonation reports, one per reporter and reported pubkey
+	CREATE TABLE IF NOT EXISTS abuse_reports (
+		reporter TEXT NOT NULL,
+		reported TEXT NOT NULL,
+		report_type TEXT NOT NULL,
+		source TEXT NOT NULL,
+		event_id TEXT NOT NULL DEFAULT '',
+		reason TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (reporter, reported)
+	);
+	CREATE INDEX IF NOT EXISTS idx_abuse_reports_reported ON abuse_reports(reported);
+
+	-- Follow edges of each author's latest contact list, maintained on save
+	CREATE TABLE IF NOT EXISTS follows (
+		follower TEXT NOT NULL,
+		followed TEXT NOT NULL,
+		PRIMARY KEY (follower, followed)
+	);
+	CREATE INDEX IF NOT EXISTS idx_follows_followed ON follows(followed);
+
+	CREATE TABLE IF NOT EXISTS follows_state (
+		follower TEXT PRIMARY KEY,
+		created_at INTEGER NOT NULL
+	);
+
+	-- Follower count per followed pubkey, rebuilt from follows to rank followers
+	CREATE TABLE IF NOT EXISTS follower_counts (
+		pubkey TEXT PRIMARY KEY,
+		followers INTEGER NOT NULL
+	);
+
+	-- Most muted pubkeys across kind 10000 lists, rebuilt by the most muted refresh
+	CREATE TABLE IF NOT EXISTS cached_most_muted (
+		pubkey TEXT PRIMARY KEY,
+		mute_count INTEGER NOT NULL,
+		follower_count INTEGER NOT NULL
+	);
+
 	-- Rejected events by unsupported kind
 	CREATE TABLE IF NOT EXISTS rejected_events_by_kind (
 		kind INTEGER NOT NULL,
@@ -145,12 +215,61 @@ func (s *Storage) InitAnalyticsSchema() error {
 	);
 	CREATE INDEX IF NOT EXISTS idx_rejected_req_count ON rejected_req_kinds(count DESC);
 
-	-- Trusted pubkeys (persisted from trust analysis)
+	-- REQs for kinds withheld by a privacy policy
+	CREATE TABLE IF NOT EXISTS privacy_rejected_reqs (
+		kind INTEGER NOT NULL,
+		policy TEXT NOT NULL,
+		count INTEGER NOT NULL DEFAULT 0,
+		last_seen INTEGER NOT NULL,
+		PRIMARY KEY (kind, policy)
+	);
+
+	-- Trusted pubkeys (persisted from trust analysis), trusted_at being when
+	-- trust was last confirmed
 	CREATE TABLE IF NOT EXISTS trusted_pubkeys (
 		pubkey TEXT PRIMARY KEY,
 		trusted_at INTEGER NOT NULL
 	);
 
+	-- Trust revoked on compromise signals
+	CREATE TABLE IF NOT EXISTS trust_revocations (
+		pubkey TEXT NOT NULL,
+		signals TEXT NOT NULL,
+		revoked_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_trust_revocations_pubkey ON trust_revocations(pubkey, revoked_at);
+	CREATE INDEX IF NOT EXISTS idx_trust_revocations_revoked ON trust_revocations(revoked_at);
+
+	-- Identity fields of each pubkey's latest kind 0, and the changes to them per hour
+	CREATE TABLE IF NOT EXISTS profile_identities (
+		pubkey TEXT PRIMARY KEY,
+		identity TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS profile_changes (
+		pubkey TEXT NOT NULL,
+		hour INTEGER NOT NULL,
+		changes INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (pubkey, hour)
+	);
+	CREATE INDEX IF NOT EXISTS idx_profile_changes_hour ON profile_changes(hour);
+
+	-- Kind 0 identity change velocity (name/picture/nip05 changes in the last 24h)
+	CREATE TABLE IF NOT EXISTS profile_change_velocity (
+		pubkey TEXT PRIMARY KEY,
+		changes_24h INTEGER NOT NULL,
+		computed_at INTEGER NOT NULL
+	);
+
+	-- NIP-05 identifiers checked against their domain's nostr.json
+	CREATE TABLE IF NOT EXISTS nip05_verifications (
+		pubkey TEXT PRIMARY KEY,
+		identifier TEXT NOT NULL,
+		valid INTEGER NOT NULL,
+		checked_at INTEGER NOT NULL
+	);
+
 	-- Social graph communities
 	CREATE TABLE IF NOT EXISTS communities (
 		id INTEGER PRIMARY KEY,
@@ -183,6 +302,25 @@ func (s *Storage) InitAnalyticsSchema() error {
 		num_communities INTEGER NOT NULL,
 		detected_at INTEGER NOT NULL
 	);
+
+	-- Per-pubkey daily publishing quota counters
+	CREATE TABLE IF NOT EXISTS pubkey_daily_quota (
+		date TEXT NOT NULL,
+		pubkey TEXT NOT NULL,
+		accepted INTEGER NOT NULL DEFAULT 0,
+		rejected INTEGER NOT NULL DEFAULT 0,
+		last_seen INTEGER NOT NULL,
+		PRIMARY KEY (date, pubkey)
+	);
+	CREATE INDEX IF NOT EXISTS idx_pubkey_daily_quota_rejected ON pubkey_daily_quota(date, rejected);
+
+	-- Last shadow-table rebuild of each derived table
+	CREATE TABLE IF NOT EXISTS derived_table_refreshes (
+		table_name TEXT PRIMARY KEY,
+		duration_ms INTEGER NOT NULL,
+		row_count INTEGER NOT NULL,
+		refreshed_at INTEGER NOT NULL
+	);
 	`
 
 	_, err := dbConn.Exec(schema)
@@ -194,6 +332,7 @@ func (s *Storage) FlushREQAnalytics(
 	pubkeyRequests map[string]int64,
 	pubkeyByKind map[string]map[int]int64,
 	cooccurrence map[string]int64,
+	interest map[InterestEdge]int64,
 ) error {
 	dbConn := s.getDBConn()
 	if dbConn == nil {
@@ -202,57 +341,101 @@ func (s *Storage) FlushREQAnalytics(
 
 	now := time.Now().Unix()
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	for pubkey, count := range pubkeyRequests {
-		_, err := tx.ExecContext(ctx, s.rebind(`
-			INSERT INTO req_analytics (pubkey, total_requests, last_request)
-			VALUES (?, ?, ?)
-			ON CONFLICT(pubkey) DO UPDATE SET
-				total_requests = req_analytics.total_requests + excluded.total_requests,
-				last_request = excluded.last_request
-		`), pubkey, count, now)
-		if err != nil {
-			return err
+	return s.inTx(ctx, dbConn, "FlushREQAnalytics", func(ctx context.Context, tx *sqlx.Tx) error {
+		for pubkey, count := range pubkeyRequests {
+			_, err := s.query(ctx, tx, "FlushREQAnalytics: requests", `
+				INSERT INTO req_analytics (pubkey, total_requests, last_request)
+				VALUES (?, ?, ?)
+				ON CONFLICT(pubkey) DO UPDATE SET
+					total_requests = req_analytics.total_requests + excluded.total_requests,
+					last_request = excluded.last_request
+			`, pubkey, count, now).exec()
+			if err != nil {
+				return err
+			}
+		}
+
+		for pubkey, kindCounts := range pubkeyByKind {
+			for kind, count := range kindCounts {
+				_, err := s.query(ctx, tx, "FlushREQAnalytics: kinds", `
+					INSERT INTO req_analytics_by_kind (pubkey, kind, request_count)
+					VALUES (?, ?, ?)
+					ON CONFLICT(pubkey, kind) DO UPDATE SET
+						request_count = req_analytics_by_kind.request_count + excluded.request_count
+				`, pubkey, kind, count).exec()
+				if err != nil {
+					return err
+				}
+			}
 		}
-	}
 
-	for pubkey, kindCounts := range pubkeyByKind {
-		for kind, count := range kindCounts {
-			_, err := tx.ExecContext(ctx, s.rebind(`
-				INSERT INTO req_analytics_by_kind (pubkey, kind, request_count)
+		for pairKey, count := range cooccurrence {
+			_, err := s.query(ctx, tx, "FlushREQAnalytics: cooccurrence", `
+				INSERT INTO req_cooccurrence (pair_key, count, last_seen)
 				VALUES (?, ?, ?)
-				ON CONFLICT(pubkey, kind) DO UPDATE SET
-					request_count = req_analytics_by_kind.request_count + excluded.request_count
-			`), pubkey, kind, count)
+				ON CONFLICT(pair_key) DO UPDATE SET
+					count = req_cooccurrence.count + excluded.count,
+					last_seen = excluded.last_seen
+			`, pairKey, count, now).exec()
+			if err != nil {
+				return err
+			}
+		}
+
+		for edge, count := range interest {
+			_, err := s.query(ctx, tx, "FlushREQAnalytics: interest", `
+				INSERT INTO req_interest_edges (from_pubkey, to_pubkey, weight, last_seen)
+				VALUES (?, ?, ?, ?)
+				ON CONFLICT(from_pubkey, to_pubkey) DO UPDATE SET
+					weight = req_interest_edges.weight + excluded.weight,
+					last_seen = excluded.last_seen
+			`, edge.From, edge.To, count, now).exec()
 			if err != nil {
 				return err
 			}
 		}
+
+		return nil
+	})
+}
+
+// InterestEdge is one client looking up To shortly after looking up From
+type InterestEdge struct {
+	From string
+	To   string
+}
+
+// interestEdgeMaxAge is how long an interest edge counts without being seen again
+const interestEdgeMaxAge = 30 * 24 * time.Hour
+
+// GetLikelyNext returns the pubkeys clients most often look up after this one
+func (s *Storage) GetLikelyNext(ctx context.Context, pubkey string, limit int) ([]string, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
 	}
 
-	for pairKey, count := range cooccurrence {
-		_, err := tx.ExecContext(ctx, s.rebind(`
-			INSERT INTO req_cooccurrence (pair_key, count, last_seen)
-			VALUES (?, ?, ?)
-			ON CONFLICT(pair_key) DO UPDATE SET
-				count = req_cooccurrence.count + excluded.count,
-				last_seen = excluded.last_seen
-		`), pairKey, count, now)
-		if err != nil {
+	var results []string
+	err := s.query(ctx, dbConn, "GetLikelyNext", `
+		SELECT to_pubkey
+		FROM req_interest_edges
+		WHERE from_pubkey = ? AND last_seen > ?
+		ORDER BY weight DESC
+		LIMIT ?
+	`, pubkey, time.Now().Add(-interestEdgeMaxAge).Unix(), limit).each(func(rows *sql.Rows) error {
+		var to string
+		if err := rows.Scan(&to); err != nil {
 			return err
 		}
-	}
+		results = append(results, to)
+		return nil
+	})
 
-	return tx.Commit()
+	return results, err
 }
 
 func (s *Storage) GetPubkeyAnalytics(ctx context.Context, pubkey string) (*PubkeyStats, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
@@ -260,89 +443,82 @@ func (s *Storage) GetPubkeyAnalytics(ctx context.Context, pubkey string) (*Pubke
 	var stats PubkeyStats
 	var lastRequest int64
 
-	err := dbConn.QueryRowContext(ctx, s.rebind(`
+	err := s.query(ctx, dbConn, "GetPubkeyAnalytics", `
 		SELECT pubkey, total_requests, last_request
 		FROM req_analytics
 		WHERE pubkey = ?
-	`), pubkey).Scan(&stats.Pubkey, &stats.TotalRequests, &lastRequest)
-	if err != nil {
+	`, pubkey).scan(&stats.Pubkey, &stats.TotalRequests, &lastRequest)
+	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	stats.LastRequest = time.Unix(lastRequest, 0)
 	stats.ByKind = make(map[int]int64)
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	err = s.query(ctx, dbConn, "GetPubkeyAnalytics: kinds", `
 		SELECT kind, request_count
 		FROM req_analytics_by_kind
 		WHERE pubkey = ?
-	`), pubkey)
-	if err != nil {
-		return &stats, nil
-	}
-	defer rows.Close()
-
-	for rows.Next() {
+	`, pubkey).each(func(rows *sql.Rows) error {
 		var kind int
 		var count int64
-		rows.Scan(&kind, &count)
+		if err := rows.Scan(&kind, &count); err != nil {
+			return err
+		}
 		stats.ByKind[kind] = count
-	}
+		return nil
+	})
 
-	return &stats, nil
+	return &stats, err
 }
 
 func (s *Storage) GetTopRequestedPubkeys(ctx context.Context, limit int) ([]PubkeyStats, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var results []PubkeyStats
+	err := s.query(ctx, dbConn, "GetTopRequestedPubkeys", `
 		SELECT pubkey, total_requests, last_request
 		FROM req_analytics
 		ORDER BY total_requests DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var results []PubkeyStats
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var stats PubkeyStats
 		var lastRequest int64
-		rows.Scan(&stats.Pubkey, &stats.TotalRequests, &lastRequest)
+		if err := rows.Scan(&stats.Pubkey, &stats.TotalRequests, &lastRequest); err != nil {
+			return err
+		}
 		stats.LastRequest = time.Unix(lastRequest, 0)
 		results = append(results, stats)
-	}
+		return nil
+	})
 
-	return results, rows.Err()
+	return results, err
 }
 
 func (s *Storage) GetTopCooccurrences(ctx context.Context, limit int) ([]CooccurrencePair, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var results []CooccurrencePair
+	err := s.query(ctx, dbConn, "GetTopCooccurrences", `
 		SELECT pair_key, count
 		FROM req_cooccurrence
 		ORDER BY count DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var results []CooccurrencePair
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var pairKey string
 		var count int64
-		rows.Scan(&pairKey, &count)
+		if err := rows.Scan(&pairKey, &count); err != nil {
+			return err
+		}
 
 		parts := strings.SplitN(pairKey, ":", 2)
 		if len(parts) == 2 {
@@ -352,46 +528,71 @@ func (s *Storage) GetTopCooccurrences(ctx context.Context, limit int) ([]Cooccur
 				Count:   count,
 			})
 		}
-	}
+		return nil
+	})
 
-	return results, rows.Err()
+	return results, err
 }
 
-func (s *Storage) SaveBotCluster(ctx context.Context, members []string, internalDensity, externalRatio float64) (int64, error) {
+// DecayCooccurrences halves every co-occurrence count and drops pairs that reach
+// zero, so the counts weigh recent REQs over all-time totals. It returns the
+// number of pairs dropped.
+func (s *Storage) DecayCooccurrences(ctx context.Context) (int64, error) {
 	dbConn := s.getDBConn()
 	if dbConn == nil {
 		return 0, nil
 	}
 
-	now := time.Now().Unix()
-
-	tx, err := dbConn.BeginTxx(ctx, nil)
+	var pruned int64
+	err := s.inTx(ctx, dbConn, "DecayCooccurrences", func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := s.query(ctx, tx, "DecayCooccurrences: halve", `UPDATE req_cooccurrence SET count = count / 2`).exec(); err != nil {
+			return err
+		}
+		result, err := s.query(ctx, tx, "DecayCooccurrences: prune", `DELETE FROM req_cooccurrence WHERE count = 0`).exec()
+		if err != nil {
+			return err
+		}
+		pruned, err = result.RowsAffected()
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
-	defer tx.Rollback()
 
-	var clusterID int64
-	err = tx.QueryRowContext(ctx, s.rebind(`
-		INSERT INTO bot_clusters (detected_at, cluster_size, internal_density, external_ratio, is_active)
-		VALUES (?, ?, ?, ?, 1)
-		RETURNING id
-	`), now, len(members), internalDensity, externalRatio).Scan(&clusterID)
-	if err != nil {
-		return 0, err
+	return pruned, nil
+}
+
+func (s *Storage) SaveBotCluster(ctx context.Context, members []string, internalDensity, externalRatio float64) (int64, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return 0, nil
 	}
 
-	for _, pubkey := range members {
-		_, err := tx.ExecContext(ctx, s.rebind(`
-			INSERT INTO bot_cluster_members (cluster_id, pubkey)
-			VALUES (?, ?)
-		`), clusterID, pubkey)
+	now := time.Now().Unix()
+
+	var clusterID int64
+	err := s.inTx(ctx, dbConn, "SaveBotCluster", func(ctx context.Context, tx *sqlx.Tx) error {
+		err := s.query(ctx, tx, "SaveBotCluster", `
+			INSERT INTO bot_clusters (detected_at, cluster_size, internal_density, external_ratio, is_active)
+			VALUES (?, ?, ?, ?, 1)
+			RETURNING cluster_id
+		`, now, len(members), internalDensity, externalRatio).scan(&clusterID)
 		if err != nil {
-			return 0, err
+			return err
 		}
-	}
 
-	if err := tx.Commit(); err != nil {
+		for _, pubkey := range members {
+			_, err := s.query(ctx, tx, "SaveBotCluster: members", `
+				INSERT INTO bot_cluster_members (cluster_id, pubkey)
+				VALUES (?, ?)
+			`, clusterID, pubkey).exec()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		return 0, err
 	}
 
@@ -399,50 +600,51 @@ func (s *Storage) SaveBotCluster(ctx context.Context, members []string, internal
 }
 
 func (s *Storage) GetBotClusters(ctx context.Context, limit int) ([]BotCluster, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var clusters []BotCluster
+	err := s.query(ctx, dbConn, "GetBotClusters", `
 		SELECT cluster_id, detected_at, cluster_size, internal_density, external_ratio, is_active
 		FROM bot_clusters
 		WHERE is_active = 1
 		ORDER BY detected_at DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var clusters []BotCluster
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var c BotCluster
 		var detectedAt int64
 		var isActive int
-		rows.Scan(&c.ID, &detectedAt, &c.Size, &c.InternalDensity, &c.ExternalRatio, &isActive)
+		if err := rows.Scan(&c.ID, &detectedAt, &c.Size, &c.InternalDensity, &c.ExternalRatio, &isActive); err != nil {
+			return err
+		}
 		c.DetectedAt = time.Unix(detectedAt, 0)
 		c.IsActive = isActive == 1
 		clusters = append(clusters, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	for i := range clusters {
-		memberRows, err := dbConn.QueryContext(ctx, s.rebind(`
+		err := s.query(ctx, dbConn, "GetBotClusters: members", `
 			SELECT pubkey FROM bot_cluster_members WHERE cluster_id = ?
-		`), clusters[i].ID)
-		if err != nil {
-			continue
-		}
-		for memberRows.Next() {
+		`, clusters[i].ID).each(func(rows *sql.Rows) error {
 			var pubkey string
-			memberRows.Scan(&pubkey)
+			if err := rows.Scan(&pubkey); err != nil {
+				return err
+			}
 			clusters[i].Members = append(clusters[i].Members, pubkey)
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
-		memberRows.Close()
 	}
 
-	return clusters, rows.Err()
+	return clusters, nil
 }
 
 func (s *Storage) DeactivateBotClusters(ctx context.Context) error {
@@ -451,7 +653,7 @@ func (s *Storage) DeactivateBotClusters(ctx context.Context) error {
 		return nil
 	}
 
-	_, err := dbConn.ExecContext(ctx, `UPDATE bot_clusters SET is_active = 0`)
+	_, err := s.query(ctx, dbConn, "DeactivateBotClusters", `UPDATE bot_clusters SET is_active = 0`).exec()
 	return err
 }
 
@@ -462,15 +664,40 @@ func (s *Storage) IsPubkeyInBotCluster(ctx context.Context, pubkey string) (bool
 	}
 
 	var count int
-	err := dbConn.QueryRowContext(ctx, s.rebind(`
+	err := s.query(ctx, dbConn, "IsPubkeyInBotCluster", `
 		SELECT COUNT(*) FROM bot_cluster_members bcm
 		JOIN bot_clusters bc ON bcm.cluster_id = bc.cluster_id
 		WHERE bcm.pubkey = ? AND bc.is_active = 1
-	`), pubkey).Scan(&count)
+	`, pubkey).scan(&count)
 
 	return count > 0, err
 }
 
+// GetBotClusterPubkeys returns every member of an active bot cluster
+func (s *Storage) GetBotClusterPubkeys(ctx context.Context) (map[string]bool, error) {
+	result := make(map[string]bool)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return result, nil
+	}
+
+	err := s.query(ctx, dbConn, "GetBotClusterPubkeys", `
+		SELECT DISTINCT bcm.pubkey FROM bot_cluster_members bcm
+		JOIN bot_clusters bc ON bcm.cluster_id = bc.cluster_id
+		WHERE bc.is_active = 1
+	`).each(func(rows *sql.Rows) error {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return err
+		}
+		result[pubkey] = true
+		return nil
+	})
+
+	return result, err
+}
+
 func (s *Storage) SaveSpamCandidate(ctx context.Context, pubkey, reason string, eventCount int64) error {
 	dbConn := s.getDBConn()
 	if dbConn == nil {
@@ -478,48 +705,45 @@ func (s *Storage) SaveSpamCandidate(ctx context.Context, pubkey, reason string,
 	}
 
 	now := time.Now().Unix()
-	_, err := dbConn.ExecContext(ctx, s.rebind(`
+	_, err := s.query(ctx, dbConn, "SaveSpamCandidate", `
 		INSERT INTO spam_candidates (pubkey, detected_at, reason, event_count, purged)
 		VALUES (?, ?, ?, ?, 0)
 		ON CONFLICT(pubkey) DO UPDATE SET
 			detected_at = excluded.detected_at,
 			reason = excluded.reason,
 			event_count = excluded.event_count
-	`), pubkey, now, reason, eventCount)
+	`, pubkey, now, reason, eventCount).exec()
 
 	return err
 }
 
 func (s *Storage) GetSpamCandidates(ctx context.Context, limit int) ([]SpamCandidate, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var candidates []SpamCandidate
+	err := s.query(ctx, dbConn, "GetSpamCandidates", `
 		SELECT pubkey, detected_at, reason, event_count, purged
 		FROM spam_candidates
 		WHERE purged = 0
 		ORDER BY event_count DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var candidates []SpamCandidate
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var c SpamCandidate
 		var detectedAt int64
 		var purged int
-		rows.Scan(&c.Pubkey, &detectedAt, &c.Reason, &c.EventCount, &purged)
+		if err := rows.Scan(&c.Pubkey, &detectedAt, &c.Reason, &c.EventCount, &purged); err != nil {
+			return err
+		}
 		c.DetectedAt = time.Unix(detectedAt, 0)
 		c.Purged = purged == 1
 		candidates = append(candidates, c)
-	}
+		return nil
+	})
 
-	return candidates, rows.Err()
+	return candidates, err
 }
 
 func (s *Storage) MarkSpamPurged(ctx context.Context, pubkeys []string) error {
@@ -529,9 +753,9 @@ func (s *Storage) MarkSpamPurged(ctx context.Context, pubkeys []string) error {
 	}
 
 	for _, pubkey := range pubkeys {
-		_, err := dbConn.ExecContext(ctx, s.rebind(`
+		_, err := s.query(ctx, dbConn, "MarkSpamPurged", `
 			UPDATE spam_candidates SET purged = 1 WHERE pubkey = ?
-		`), pubkey)
+		`, pubkey).exec()
 		if err != nil {
 			return err
 		}
@@ -546,87 +770,54 @@ func (s *Storage) ClearSpamCandidates(ctx context.Context) error {
 		return nil
 	}
 
-	_, err := dbConn.ExecContext(ctx, `DELETE FROM spam_candidates WHERE purged = 0`)
+	_, err := s.query(ctx, dbConn, "ClearSpamCandidates", `DELETE FROM spam_candidates WHERE purged = 0`).exec()
 	return err
 }
 
 func (s *Storage) GetAllRequestedPubkeys(ctx context.Context) (map[string]int64, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, `
-		SELECT pubkey, total_requests FROM req_analytics
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	result := make(map[string]int64)
-	for rows.Next() {
+	err := s.query(ctx, dbConn, "GetAllRequestedPubkeys", `
+		SELECT pubkey, total_requests FROM req_analytics
+	`).each(func(rows *sql.Rows) error {
 		var pubkey string
 		var count int64
-		rows.Scan(&pubkey, &count)
+		if err := rows.Scan(&pubkey, &count); err != nil {
+			return err
+		}
 		result[pubkey] = count
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return result, rows.Err()
+	return result, nil
 }
 
 func (s *Storage) CountEventsForPubkey(ctx context.Context, pubkey string) (int64, error) {
-	dbConn := s.getDBConn()
-	if dbConn == nil {
-		return 0, nil
-	}
-
-	var count int64
-	err := dbConn.QueryRowContext(ctx, s.rebind(`
-		SELECT COUNT(*) FROM event WHERE pubkey = ?
-	`), pubkey).Scan(&count)
-
-	return count, err
+	return s.db.CountEvents(ctx, nostr.Filter{Authors: []string{pubkey}})
 }
 
 func (s *Storage) DeleteEventsForPubkeys(ctx context.Context, pubkeys []string) (int64, error) {
-	dbConn := s.getDBConn()
-	if dbConn == nil {
-		return 0, nil
-	}
+	s.bundles.forget(pubkeys)
 
 	var totalDeleted int64
 	for _, pubkey := range pubkeys {
-		result, err := dbConn.ExecContext(ctx, s.rebind(`DELETE FROM event WHERE pubkey = ?`), pubkey)
+		deleted, err := s.db.DeleteByPubkey(ctx, pubkey)
+		totalDeleted += deleted
 		if err != nil {
 			return totalDeleted, err
 		}
-		deleted, _ := result.RowsAffected()
-		totalDeleted += deleted
 	}
 
 	return totalDeleted, nil
 }
 
-// RecordRejectedEvent records an event that was rejected due to unsupported kind
-func (s *Storage) RecordRejectedEvent(ctx context.Context, kind int, pubkey string) error {
-	dbConn := s.getDBConn()
-	if dbConn == nil {
-		return nil
-	}
-
-	now := time.Now().Unix()
-	_, err := dbConn.ExecContext(ctx, s.rebind(`
-		INSERT INTO rejected_events_by_kind (kind, pubkey, count, last_seen)
-		VALUES (?, ?, 1, ?)
-		ON CONFLICT(kind, pubkey) DO UPDATE SET
-			count = rejected_events_by_kind.count + 1,
-			last_seen = excluded.last_seen
-	`), kind, pubkey, now)
-
-	return err
-}
-
 type RejectedEventStat struct {
 	Kind     int
 	Pubkey   string
@@ -636,34 +827,29 @@ type RejectedEventStat struct {
 
 // GetRejectedEventStats returns stats on rejected events, optionally filtered
 func (s *Storage) GetRejectedEventStats(ctx context.Context, limit int) ([]RejectedEventStat, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var stats []RejectedEventStat
+	err := s.query(ctx, dbConn, "GetRejectedEventStats", `
 		SELECT kind, pubkey, count, last_seen
 		FROM rejected_events_by_kind
 		ORDER BY count DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var stats []RejectedEventStat
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var stat RejectedEventStat
 		var lastSeen int64
 		if err := rows.Scan(&stat.Kind, &stat.Pubkey, &stat.Count, &lastSeen); err != nil {
-			return nil, err
+			return err
 		}
 		stat.LastSeen = time.Unix(lastSeen, 0)
 		stats = append(stats, stat)
-	}
+		return nil
+	})
 
-	return stats, rows.Err()
+	return stats, err
 }
 
 type RejectedKindSummary struct {
@@ -675,54 +861,30 @@ type RejectedKindSummary struct {
 
 // GetRejectedEventsByKind returns aggregated stats per kind
 func (s *Storage) GetRejectedEventsByKind(ctx context.Context, limit int) ([]RejectedKindSummary, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var stats []RejectedKindSummary
+	err := s.query(ctx, dbConn, "GetRejectedEventsByKind", `
 		SELECT kind, SUM(count) as total_count, COUNT(DISTINCT pubkey) as unique_pubkeys, MAX(last_seen) as last_seen
 		FROM rejected_events_by_kind
 		GROUP BY kind
 		ORDER BY total_count DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var stats []RejectedKindSummary
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var stat RejectedKindSummary
 		var lastSeen int64
 		if err := rows.Scan(&stat.Kind, &stat.TotalCount, &stat.UniquePubkeys, &lastSeen); err != nil {
-			return nil, err
+			return err
 		}
 		stat.LastSeen = time.Unix(lastSeen, 0)
 		stats = append(stats, stat)
-	}
-
-	return stats, rows.Err()
-}
-
-// RecordRejectedREQ records a REQ for an unsupported kind
-func (s *Storage) RecordRejectedREQ(ctx context.Context, kind int) error {
-	dbConn := s.getDBConn()
-	if dbConn == nil {
 		return nil
-	}
-
-	now := time.Now().Unix()
-	_, err := dbConn.ExecContext(ctx, s.rebind(`
-		INSERT INTO rejected_req_kinds (kind, count, last_seen)
-		VALUES (?, 1, ?)
-		ON CONFLICT(kind) DO UPDATE SET
-			count = rejected_req_kinds.count + 1,
-			last_seen = excluded.last_seen
-	`), kind, now)
+	})
 
-	return err
+	return stats, err
 }
 
 type RejectedREQStat struct {
@@ -733,67 +895,178 @@ type RejectedREQStat struct {
 
 // GetRejectedREQStats returns stats on rejected REQs by kind
 func (s *Storage) GetRejectedREQStats(ctx context.Context, limit int) ([]RejectedREQStat, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var stats []RejectedREQStat
+	err := s.query(ctx, dbConn, "GetRejectedREQStats", `
 		SELECT kind, count, last_seen
 		FROM rejected_req_kinds
 		ORDER BY count DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var stats []RejectedREQStat
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var stat RejectedREQStat
 		var lastSeen int64
 		if err := rows.Scan(&stat.Kind, &stat.Count, &lastSeen); err != nil {
-			return nil, err
+			return err
 		}
 		stat.LastSeen = time.Unix(lastSeen, 0)
 		stats = append(stats, stat)
+		return nil
+	})
+
+	return stats, err
+}
+
+type PrivacyRejectedREQStat struct {
+	Kind     int
+	Policy   string
+	Count    int64
+	LastSeen time.Time
+}
+
+// GetPrivacyRejectedREQStats returns how often each private kind was withheld
+func (s *Storage) GetPrivacyRejectedREQStats(ctx context.Context) ([]PrivacyRejectedREQStat, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
 	}
 
-	return stats, rows.Err()
+	var stats []PrivacyRejectedREQStat
+	err := s.query(ctx, dbConn, "GetPrivacyRejectedREQStats", `
+		SELECT kind, policy, count, last_seen
+		FROM privacy_rejected_reqs
+		ORDER BY count DESC
+	`).each(func(rows *sql.Rows) error {
+		var stat PrivacyRejectedREQStat
+		var lastSeen int64
+		if err := rows.Scan(&stat.Kind, &stat.Policy, &stat.Count, &lastSeen); err != nil {
+			return err
+		}
+		stat.LastSeen = time.Unix(lastSeen, 0)
+		stats = append(stats, stat)
+		return nil
+	})
+
+	return stats, err
+}
+
+// RejectedEventKey identifies a rejected event counter
+type RejectedEventKey struct {
+	Kind   int
+	Pubkey string
 }
 
-// RecordREQKind records a REQ for any kind (for overall stats)
-func (s *Storage) RecordREQKind(ctx context.Context, kind int) error {
+// PrivacyRejectKey identifies a privacy-withheld REQ counter
+type PrivacyRejectKey struct {
+	Kind   int
+	Policy string
+}
+
+// KindStatsBatch is per-kind REQ and rejection counters buffered in memory between flushes
+type KindStatsBatch struct {
+	REQKindsDaily   map[string]map[int]int64 // date -> kind -> requests
+	RejectedREQs    map[int]int64
+	PrivacyRejected map[PrivacyRejectKey]int64
+	RejectedEvents  map[RejectedEventKey]int64
+}
+
+func NewKindStatsBatch() *KindStatsBatch {
+	return &KindStatsBatch{
+		REQKindsDaily:   make(map[string]map[int]int64),
+		RejectedREQs:    make(map[int]int64),
+		PrivacyRejected: make(map[PrivacyRejectKey]int64),
+		RejectedEvents:  make(map[RejectedEventKey]int64),
+	}
+}
+
+func (b *KindStatsBatch) Empty() bool {
+	return len(b.REQKindsDaily) == 0 && len(b.RejectedREQs) == 0 &&
+		len(b.PrivacyRejected) == 0 && len(b.RejectedEvents) == 0
+}
+
+// FlushKindStats adds a batch of buffered counters in a single transaction
+func (s *Storage) FlushKindStats(ctx context.Context, batch *KindStatsBatch) error {
 	dbConn := s.getDBConn()
-	if dbConn == nil {
+	if dbConn == nil || batch.Empty() {
 		return nil
 	}
 
 	now := time.Now().Unix()
-	date := time.Now().Format("2006-01-02")
-
-	// Update total stats
-	_, err := dbConn.ExecContext(ctx, s.rebind(`
-		INSERT INTO req_kind_stats (kind, total_requests, last_request)
-		VALUES (?, 1, ?)
-		ON CONFLICT(kind) DO UPDATE SET
-			total_requests = total_requests + 1,
-			last_request = excluded.last_request
-	`), kind, now)
-	if err != nil {
-		return err
-	}
 
-	// Update daily stats
-	_, err = dbConn.ExecContext(ctx, s.rebind(`
-		INSERT INTO req_kind_stats_daily (date, kind, request_count)
-		VALUES (?, ?, 1)
-		ON CONFLICT(date, kind) DO UPDATE SET
-			request_count = request_count + 1
-	`), date, kind)
+	return s.inTx(ctx, dbConn, "FlushKindStats", func(ctx context.Context, tx *sqlx.Tx) error {
+		totals := make(map[int]int64)
+		for date, kinds := range batch.REQKindsDaily {
+			for kind, count := range kinds {
+				totals[kind] += count
+				_, err := s.query(ctx, tx, "FlushKindStats: daily", `
+					INSERT INTO req_kind_stats_daily (date, kind, request_count)
+					VALUES (?, ?, ?)
+					ON CONFLICT(date, kind) DO UPDATE SET
+						request_count = req_kind_stats_daily.request_count + excluded.request_count
+				`, date, kind, count).exec()
+				if err != nil {
+					return err
+				}
+			}
+		}
 
-	return err
+		for kind, count := range totals {
+			_, err := s.query(ctx, tx, "FlushKindStats: totals", `
+				INSERT INTO req_kind_stats (kind, total_requests, last_request)
+				VALUES (?, ?, ?)
+				ON CONFLICT(kind) DO UPDATE SET
+					total_requests = req_kind_stats.total_requests + excluded.total_requests,
+					last_request = excluded.last_request
+			`, kind, count, now).exec()
+			if err != nil {
+				return err
+			}
+		}
+
+		for kind, count := range batch.RejectedREQs {
+			_, err := s.query(ctx, tx, "FlushKindStats: rejected REQs", `
+				INSERT INTO rejected_req_kinds (kind, count, last_seen)
+				VALUES (?, ?, ?)
+				ON CONFLICT(kind) DO UPDATE SET
+					count = rejected_req_kinds.count + excluded.count,
+					last_seen = excluded.last_seen
+			`, kind, count, now).exec()
+			if err != nil {
+				return err
+			}
+		}
+
+		for key, count := range batch.PrivacyRejected {
+			_, err := s.query(ctx, tx, "FlushKindStats: privacy rejections", `
+				INSERT INTO privacy_rejected_reqs (kind, policy, count, last_seen)
+				VALUES (?, ?, ?, ?)
+				ON CONFLICT(kind, policy) DO UPDATE SET
+					count = privacy_rejected_reqs.count + excluded.count,
+					last_seen = excluded.last_seen
+			`, key.Kind, key.Policy, count, now).exec()
+			if err != nil {
+				return err
+			}
+		}
+
+		for key, count := range batch.RejectedEvents {
+			_, err := s.query(ctx, tx, "FlushKindStats: rejected events", `
+				INSERT INTO rejected_events_by_kind (kind, pubkey, count, last_seen)
+				VALUES (?, ?, ?, ?)
+				ON CONFLICT(kind, pubkey) DO UPDATE SET
+					count = rejected_events_by_kind.count + excluded.count,
+					last_seen = excluded.last_seen
+			`, key.Kind, key.Pubkey, count, now).exec()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
 
 type REQKindStat struct {
@@ -804,34 +1077,29 @@ type REQKindStat struct {
 
 // GetREQKindStats returns overall REQ stats by kind
 func (s *Storage) GetREQKindStats(ctx context.Context, limit int) ([]REQKindStat, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var stats []REQKindStat
+	err := s.query(ctx, dbConn, "GetREQKindStats", `
 		SELECT kind, total_requests, last_request
 		FROM req_kind_stats
 		ORDER BY total_requests DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var stats []REQKindStat
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var stat REQKindStat
 		var lastRequest int64
 		if err := rows.Scan(&stat.Kind, &stat.TotalRequests, &lastRequest); err != nil {
-			return nil, err
+			return err
 		}
 		stat.LastRequest = time.Unix(lastRequest, 0)
 		stats = append(stats, stat)
-	}
+		return nil
+	})
 
-	return stats, rows.Err()
+	return stats, err
 }
 
 type REQKindDailyStat struct {
@@ -840,119 +1108,102 @@ type REQKindDailyStat struct {
 	RequestCount int64
 }
 
-// GetREQKindDailyStats returns REQ stats by kind per day
+// GetREQKindDailyStats returns REQ stats by kind per day over the last days
+// days, newest first. kinds limits the kinds returned; empty means every kind.
 func (s *Storage) GetREQKindDailyStats(ctx context.Context, days int, kinds []int) ([]REQKindDailyStat, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
 	startDate := time.Now().AddDate(0, 0, -days).Format("2006-01-02")
 
-	var rows interface {
-		Next() bool
-		Scan(...interface{}) error
-		Close() error
-		Err() error
-	}
-	var err error
-
+	kindFilter := ""
+	args := []any{startDate}
 	if len(kinds) > 0 {
-		query := `
-			SELECT date, kind, request_count
-			FROM req_kind_stats_daily
-			WHERE date >= ?
-			ORDER BY date DESC, request_count DESC
-		`
-		rows, err = dbConn.QueryContext(ctx, query, startDate)
-	} else {
-		rows, err = dbConn.QueryContext(ctx, s.rebind(`
-			SELECT date, kind, request_count
-			FROM req_kind_stats_daily
-			WHERE date >= ?
-			ORDER BY date DESC, request_count DESC
-		`), startDate)
+		kindFilter = "AND kind = ANY(?)"
+		args = append(args, pq.Array(kinds))
 	}
 
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	var stats []REQKindDailyStat
-	for rows.Next() {
+	err := s.query(ctx, dbConn, "GetREQKindDailyStats", `
+		SELECT date, kind, request_count
+		FROM req_kind_stats_daily
+		WHERE date >= ? `+kindFilter+`
+		ORDER BY date DESC, request_count DESC
+	`, args...).each(func(rows *sql.Rows) error {
 		var stat REQKindDailyStat
 		if err := rows.Scan(&stat.Date, &stat.Kind, &stat.RequestCount); err != nil {
-			return nil, err
+			return err
 		}
 		stats = append(stats, stat)
-	}
+		return nil
+	})
 
-	return stats, rows.Err()
+	return stats, err
 }
 
 // GetRejectedEventTotals returns total counts for rejected events
 func (s *Storage) GetRejectedEventTotals(ctx context.Context) (totalCount int64, uniqueKinds int64, uniquePubkeys int64, err error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return 0, 0, 0, nil
 	}
 
-	err = dbConn.QueryRowContext(ctx, `
+	err = s.query(ctx, dbConn, "GetRejectedEventTotals", `
 		SELECT COALESCE(SUM(count), 0), COUNT(DISTINCT kind), COUNT(DISTINCT pubkey)
 		FROM rejected_events_by_kind
-	`).Scan(&totalCount, &uniqueKinds, &uniquePubkeys)
+	`).scan(&totalCount, &uniqueKinds, &uniquePubkeys)
 
 	return
 }
 
 // GetRejectedREQTotals returns total counts for rejected REQs
 func (s *Storage) GetRejectedREQTotals(ctx context.Context) (totalCount int64, uniqueKinds int64, err error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return 0, 0, nil
 	}
 
-	err = dbConn.QueryRowContext(ctx, `
+	err = s.query(ctx, dbConn, "GetRejectedREQTotals", `
 		SELECT COALESCE(SUM(count), 0), COUNT(DISTINCT kind)
 		FROM rejected_req_kinds
-	`).Scan(&totalCount, &uniqueKinds)
+	`).scan(&totalCount, &uniqueKinds)
 
 	return
 }
 
 // SetTrustedPubkeys replaces the trusted pubkeys set
 func (s *Storage) SetTrustedPubkeys(ctx context.Context, pubkeys []string) error {
+	now := time.Now().Unix()
+	return s.rebuildDerivedTables(ctx, []derivedTable{trustedPubkeysTable}, func(ctx context.Context, tx *sqlx.Tx) error {
+		for _, pubkey := range pubkeys {
+			if _, err := s.query(ctx, tx, "SetTrustedPubkeys", `
+				INSERT INTO trusted_pubkeys_next (pubkey, trusted_at) VALUES (?, ?)
+			`, pubkey, now).exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddTrustedPubkey adds a single pubkey to the trusted set between full
+// rebuilds, or re-confirms it
+func (s *Storage) AddTrustedPubkey(ctx context.Context, pubkey string) error {
 	dbConn := s.getDBConn()
 	if dbConn == nil {
 		return nil
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	// Clear existing trusted pubkeys
-	if _, err := tx.ExecContext(ctx, `DELETE FROM trusted_pubkeys`); err != nil {
-		return err
-	}
-
-	// Insert new trusted pubkeys
-	now := time.Now().Unix()
-	for _, pubkey := range pubkeys {
-		if _, err := tx.ExecContext(ctx, s.rebind(`
-			INSERT INTO trusted_pubkeys (pubkey, trusted_at) VALUES (?, ?)
-		`), pubkey, now); err != nil {
-			return err
-		}
-	}
-
-	return tx.Commit()
+	_, err := s.query(ctx, dbConn, "AddTrustedPubkey", `
+		INSERT INTO trusted_pubkeys (pubkey, trusted_at) VALUES (?, ?)
+		ON CONFLICT(pubkey) DO UPDATE SET trusted_at = excluded.trusted_at
+	`, pubkey, time.Now().Unix()).exec()
+	return err
 }
 
-// IsPubkeyTrusted checks if a pubkey is in the trusted set
+// IsPubkeyTrusted checks if a pubkey is in the trusted set and its trust hasn't decayed
 func (s *Storage) IsPubkeyTrusted(ctx context.Context, pubkey string) bool {
 	dbConn := s.getDBConn()
 	if dbConn == nil {
@@ -960,48 +1211,45 @@ func (s *Storage) IsPubkeyTrusted(ctx context.Context, pubkey string) bool {
 	}
 
 	var count int
-	err := dbConn.QueryRowContext(ctx, s.rebind(`
-		SELECT COUNT(*) FROM trusted_pubkeys WHERE pubkey = ?
-	`), pubkey).Scan(&count)
+	err := s.query(ctx, dbConn, "IsPubkeyTrusted", `
+		SELECT COUNT(*) FROM trusted_pubkeys WHERE pubkey = ? AND trusted_at >= ?
+	`, pubkey, time.Now().Add(-TrustDecayAfter).Unix()).scan(&count)
 
 	return err == nil && count > 0
 }
 
-// GetTrustedPubkeys returns all trusted pubkeys from the database
+// GetTrustedPubkeys returns all trusted pubkeys from the database whose trust hasn't decayed
 func (s *Storage) GetTrustedPubkeys(ctx context.Context) ([]string, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, `SELECT pubkey FROM trusted_pubkeys`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	var pubkeys []string
-	for rows.Next() {
+	err := s.query(ctx, dbConn, "GetTrustedPubkeys", `
+		SELECT pubkey FROM trusted_pubkeys WHERE trusted_at >= ?
+	`, time.Now().Add(-TrustDecayAfter).Unix()).each(func(rows *sql.Rows) error {
 		var pubkey string
 		if err := rows.Scan(&pubkey); err != nil {
-			return nil, err
+			return err
 		}
 		pubkeys = append(pubkeys, pubkey)
-	}
+		return nil
+	})
 
-	return pubkeys, rows.Err()
+	return pubkeys, err
 }
 
 // GetFollowersOfPubkey returns all pubkeys that follow the given pubkey (from their kind:3 events)
 func (s *Storage) GetFollowersOfPubkey(ctx context.Context, pubkey string) ([]string, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	// Find the latest kind:3 event per author that has a "p" tag for this pubkey
-	var query string
-	if s.isPostgres() {
+	query := `SELECT follower FROM follows WHERE followed = ?`
+	if !s.followsIndexReady(ctx) {
+		// Find the latest kind:3 event per author that has a "p" tag for this pubkey
 		query = `
 		WITH latest_contact_lists AS (
 			SELECT e1.pubkey as follower, e1.tags
@@ -1018,41 +1266,19 @@ func (s *Storage) GetFollowersOfPubkey(ctx context.Context, pubkey string) ([]st
 		FROM latest_contact_lists, jsonb_array_elements(latest_contact_lists.tags) as tag
 		WHERE tag->>0 = 'p'
 		  AND tag->>1 = $1`
-	} else {
-		query = `
-		WITH latest_contact_lists AS (
-			SELECT e1.pubkey as follower, e1.tags
-			FROM event e1
-			INNER JOIN (
-				SELECT pubkey, MAX(created_at) as max_created_at
-				FROM event
-				WHERE kind = 3
-				GROUP BY pubkey
-			) e2 ON e1.pubkey = e2.pubkey AND e1.created_at = e2.max_created_at
-			WHERE e1.kind = 3
-		)
-		SELECT DISTINCT follower
-		FROM latest_contact_lists, json_each(latest_contact_lists.tags) as tag
-		WHERE json_extract(tag.value, '$[0]') = 'p'
-		  AND json_extract(tag.value, '$[1]') = ?`
 	}
 
-	rows, err := dbConn.QueryContext(ctx, query, pubkey)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	var followers []string
-	for rows.Next() {
+	err := s.query(ctx, dbConn, "GetFollowersOfPubkey", query, pubkey).each(func(rows *sql.Rows) error {
 		var follower string
 		if err := rows.Scan(&follower); err != nil {
-			return nil, err
+			return err
 		}
 		followers = append(followers, follower)
-	}
+		return nil
+	})
 
-	return followers, rows.Err()
+	return followers, err
 }
 
 // Community types for storage
@@ -1161,54 +1387,46 @@ func (s *Storage) SaveCommunities(ctx context.Context, graph interface{}) error
 		return err
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
 	now := time.Now().Unix()
 
-	// Clear old data
-	tx.ExecContext(ctx, `DELETE FROM community_members`)
-	tx.ExecContext(ctx, `DELETE FROM community_edges`)
-	tx.ExecContext(ctx, `DELETE FROM communities`)
+	err = s.rebuildDerivedTables(ctx, []derivedTable{communitiesTable, communityMembersTable, communityEdgesTable}, func(ctx context.Context, tx *sqlx.Tx) error {
+		for _, com := range cg.Communities {
+			topMembersJSON, _ := json.Marshal(com.TopMembers)
 
-	// Insert communities
-	for _, com := range cg.Communities {
-		topMembersJSON, _ := json.Marshal(com.TopMembers)
+			_, err := s.query(ctx, tx, "SaveCommunities", `
+				INSERT INTO communities_next (id, size, internal_edges, external_edges, modularity, top_members, detected_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, com.ID, com.Size, com.InternalEdges, com.ExternalEdges, com.Modularity, string(topMembersJSON), now).exec()
+			if err != nil {
+				return err
+			}
 
-		_, err := tx.ExecContext(ctx, s.rebind(`
-			INSERT INTO communities (id, size, internal_edges, external_edges, modularity, top_members, detected_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?)
-		`), com.ID, com.Size, com.InternalEdges, com.ExternalEdges, com.Modularity, string(topMembersJSON), now)
-		if err != nil {
-			return err
+			for _, member := range com.Members {
+				_, err := s.query(ctx, tx, "SaveCommunities: members", `
+					INSERT INTO community_members_next (community_id, pubkey) VALUES (?, ?)
+				`, com.ID, member).exec()
+				if err != nil {
+					return err
+				}
+			}
 		}
 
-		// Insert members
-		for _, member := range com.Members {
-			_, err := tx.ExecContext(ctx, s.rebind(`
-				INSERT INTO community_members (community_id, pubkey) VALUES (?, ?)
-			`), com.ID, member)
+		for _, edge := range cg.Edges {
+			_, err := s.query(ctx, tx, "SaveCommunities: edges", `
+				INSERT INTO community_edges_next (from_id, to_id, weight) VALUES (?, ?, ?)
+			`, edge.FromID, edge.ToID, edge.Weight).exec()
 			if err != nil {
 				return err
 			}
 		}
-	}
-
-	// Insert edges
-	for _, edge := range cg.Edges {
-		_, err := tx.ExecContext(ctx, s.rebind(`
-			INSERT INTO community_edges (from_id, to_id, weight) VALUES (?, ?, ?)
-		`), edge.FromID, edge.ToID, edge.Weight)
-		if err != nil {
-			return err
-		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Update stats
-	_, err = tx.ExecContext(ctx, s.rebind(`
+	_, err = s.query(ctx, dbConn, "SaveCommunities: stats", `
 		INSERT INTO community_stats (id, total_nodes, total_edges, num_communities, detected_at)
 		VALUES (1, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
@@ -1216,17 +1434,13 @@ func (s *Storage) SaveCommunities(ctx context.Context, graph interface{}) error
 			total_edges = excluded.total_edges,
 			num_communities = excluded.num_communities,
 			detected_at = excluded.detected_at
-	`), cg.TotalNodes, cg.TotalEdges, len(cg.Communities), now)
-	if err != nil {
-		return err
-	}
-
-	return tx.Commit()
+	`, cg.TotalNodes, cg.TotalEdges, len(cg.Communities), now).exec()
+	return err
 }
 
 // GetCommunityGraph returns the stored community graph
 func (s *Storage) GetCommunityGraph(ctx context.Context) (*StoredCommunityGraph, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
@@ -1235,52 +1449,49 @@ func (s *Storage) GetCommunityGraph(ctx context.Context) (*StoredCommunityGraph,
 
 	// Get stats
 	var detectedAt int64
-	err := dbConn.QueryRowContext(ctx, `
+	err := s.query(ctx, dbConn, "GetCommunityGraph", `
 		SELECT total_nodes, total_edges, num_communities, detected_at
 		FROM community_stats WHERE id = 1
-	`).Scan(&result.TotalNodes, &result.TotalEdges, &result.NumCommunities, &detectedAt)
-	if err != nil {
+	`).scan(&result.TotalNodes, &result.TotalEdges, &result.NumCommunities, &detectedAt)
+	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil // No data yet
 	}
-	result.DetectedAt = time.Unix(detectedAt, 0)
-
-	// Get communities
-	rows, err := dbConn.QueryContext(ctx, `
-		SELECT id, size, internal_edges, external_edges, modularity, top_members, detected_at
-		FROM communities ORDER BY size DESC
-	`)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	result.DetectedAt = time.Unix(detectedAt, 0)
 
-	for rows.Next() {
+	err = s.query(ctx, dbConn, "GetCommunityGraph: communities", `
+		SELECT id, size, internal_edges, external_edges, modularity, top_members, detected_at
+		FROM communities ORDER BY size DESC
+	`).each(func(rows *sql.Rows) error {
 		var com StoredCommunity
 		var topMembersJSON string
 		var detAt int64
 		if err := rows.Scan(&com.ID, &com.Size, &com.InternalEdges, &com.ExternalEdges, &com.Modularity, &topMembersJSON, &detAt); err != nil {
-			return nil, err
+			return err
 		}
 		json.Unmarshal([]byte(topMembersJSON), &com.TopMembers)
 		com.DetectedAt = time.Unix(detAt, 0)
 		result.Communities = append(result.Communities, com)
-	}
-
-	// Get edges
-	edgeRows, err := dbConn.QueryContext(ctx, `
-		SELECT from_id, to_id, weight FROM community_edges ORDER BY weight DESC
-	`)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer edgeRows.Close()
 
-	for edgeRows.Next() {
+	err = s.query(ctx, dbConn, "GetCommunityGraph: edges", `
+		SELECT from_id, to_id, weight FROM community_edges ORDER BY weight DESC
+	`).each(func(rows *sql.Rows) error {
 		var edge StoredCommunityEdge
-		if err := edgeRows.Scan(&edge.FromID, &edge.ToID, &edge.Weight); err != nil {
-			return nil, err
+		if err := rows.Scan(&edge.FromID, &edge.ToID, &edge.Weight); err != nil {
+			return err
 		}
 		result.Edges = append(result.Edges, edge)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return result, nil
@@ -1288,27 +1499,22 @@ func (s *Storage) GetCommunityGraph(ctx context.Context) (*StoredCommunityGraph,
 
 // GetCommunityMembers returns all members of a community
 func (s *Storage) GetCommunityMembers(ctx context.Context, communityID int, limit int) ([]string, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
-		SELECT pubkey FROM community_members WHERE community_id = ? LIMIT ?
-	`), communityID, limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	var members []string
-	for rows.Next() {
+	err := s.query(ctx, dbConn, "GetCommunityMembers", `
+		SELECT pubkey FROM community_members WHERE community_id = ? LIMIT ?
+	`, communityID, limit).each(func(rows *sql.Rows) error {
 		var pubkey string
 		if err := rows.Scan(&pubkey); err != nil {
-			return nil, err
+			return err
 		}
 		members = append(members, pubkey)
-	}
+		return nil
+	})
 
-	return members, rows.Err()
+	return members, err
 }
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// analyticsPurgeBatch is how many pubkeys one cleanup statement names
+const analyticsPurgeBatch = 500
+
+// AnalyticsPurge counts the analytics rows removed for purged pubkeys
+type AnalyticsPurge struct {
+	Requests      int64 // req_analytics and req_analytics_by_kind rows
+	Cooccurrences int64
+	InterestEdges int64
+}
+
+func (p AnalyticsPurge) Total() int64 {
+	return p.Requests + p.Cooccurrences + p.InterestEdges
+}
+
+// CooccurrenceRenormalization counts what RenormalizeCooccurrences changed
+type CooccurrenceRenormalization struct {
+	Purged   AnalyticsPurge
+	Orphaned int64 // pairs with a side that has no request count left
+	Capped   int64 // pairs counted more often than one of their sides was requested
+}
+
+// PurgeAnalyticsForPubkeys removes the REQ analytics of purged pubkeys: their
+// request counts, every co-occurrence pair and interest edge they are part of.
+// Left in place, a purged bot ring's pairs keep dominating the co-occurrence
+// rankings and clusters long after its events are gone.
+func (s *Storage) PurgeAnalyticsForPubkeys(ctx context.Context, pubkeys []string) (AnalyticsPurge, error) {
+	var purged AnalyticsPurge
+	dbConn := s.getDBConn()
+	if dbConn == nil || len(pubkeys) == 0 {
+		return purged, nil
+	}
+
+	err := s.inTx(ctx, dbConn, "PurgeAnalyticsForPubkeys", func(ctx context.Context, tx *sqlx.Tx) error {
+		for start := 0; start < len(pubkeys); start += analyticsPurgeBatch {
+			end := min(start+analyticsPurgeBatch, len(pubkeys))
+			batch := pubkeys[start:end]
+			in := "(?" + strings.Repeat(",?", len(batch)-1) + ")"
+			args := make([]interface{}, len(batch))
+			for i, pk := range batch {
+				args[i] = pk
+			}
+			twice := append(append([]interface{}{}, args...), args...)
+
+			statements := []struct {
+				name    string
+				query   string
+				args    []interface{}
+				counter *int64
+			}{
+				{"requests", `DELETE FROM req_analytics WHERE pubkey IN ` + in, args, &purged.Requests},
+				{"kinds", `DELETE FROM req_analytics_by_kind WHERE pubkey IN ` + in, args, &purged.Requests},
+				// Pair keys are "<pubkey>:<pubkey>" with 64-character hex pubkeys
+				{"cooccurrence", `DELETE FROM req_cooccurrence WHERE substr(pair_key, 1, 64) IN ` + in + ` OR substr(pair_key, 66) IN ` + in, twice, &purged.Cooccurrences},
+				{"interest", `DELETE FROM req_interest_edges WHERE from_pubkey IN ` + in + ` OR to_pubkey IN ` + in, twice, &purged.InterestEdges},
+			}
+			for _, stmt := range statements {
+				result, err := s.query(ctx, tx, "PurgeAnalyticsForPubkeys: "+stmt.name, stmt.query, stmt.args...).exec()
+				if err != nil {
+					return err
+				}
+				n, err := result.RowsAffected()
+				if err != nil {
+					return err
+				}
+				*stmt.counter += n
+			}
+		}
+		return nil
+	})
+
+	return purged, err
+}
+
+// GetPurgedSpamPubkeys returns every pubkey whose events were purged as spam
+func (s *Storage) GetPurgedSpamPubkeys(ctx context.Context) ([]string, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var pubkeys []string
+	err := s.query(ctx, dbConn, "GetPurgedSpamPubkeys", `
+		SELECT pubkey FROM spam_candidates WHERE purged = 1
+	`).each(func(rows *sql.Rows) error {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return err
+		}
+		pubkeys = append(pubkeys, pubkey)
+		return nil
+	})
+
+	return pubkeys, err
+}
+
+// RenormalizeCooccurrences repairs co-occurrence counts after large purges: it
+// removes the analytics of every purged pubkey, including those purged before
+// cleanup cascaded, drops pairs with a side that no longer has a request count,
+// and caps each pair at the request count of its less requested side, which is
+// as often the two can have been requested together.
+func (s *Storage) RenormalizeCooccurrences(ctx context.Context) (CooccurrenceRenormalization, error) {
+	var result CooccurrenceRenormalization
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return result, nil
+	}
+
+	purged, err := s.GetPurgedSpamPubkeys(ctx)
+	if err != nil {
+		return result, err
+	}
+	if result.Purged, err = s.PurgeAnalyticsForPubkeys(ctx, purged); err != nil {
+		return result, err
+	}
+
+	err = s.inTx(ctx, dbConn, "RenormalizeCooccurrences", func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := s.query(ctx, tx, "RenormalizeCooccurrences: orphans", `
+			DELETE FROM req_cooccurrence
+			WHERE NOT EXISTS (SELECT 1 FROM req_analytics WHERE pubkey = substr(pair_key, 1, 64))
+			   OR NOT EXISTS (SELECT 1 FROM req_analytics WHERE pubkey = substr(pair_key, 66))
+		`).exec()
+		if err != nil {
+			return err
+		}
+		if result.Orphaned, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		res, err = s.query(ctx, tx, "RenormalizeCooccurrences: cap", `
+			UPDATE req_cooccurrence SET count = (
+				SELECT MIN(total_requests) FROM req_analytics
+				WHERE pubkey IN (substr(req_cooccurrence.pair_key, 1, 64), substr(req_cooccurrence.pair_key, 66))
+			)
+			WHERE count > (
+				SELECT MIN(total_requests) FROM req_analytics
+				WHERE pubkey IN (substr(req_cooccurrence.pair_key, 1, 64), substr(req_cooccurrence.pair_key, 66))
+			)
+		`).exec()
+		if err != nil {
+			return err
+		}
+		result.Capped, err = res.RowsAffected()
+		return err
+	})
+
+	return result, err
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Analytics database states, as reported by /readyz
+const (
+	AnalyticsOK          = "ok"
+	AnalyticsDisabled    = "disabled"    // no SQL database configured
+	AnalyticsUnreachable = "unreachable" // configured, but not answering
+)
+
+// AnalyticsEnabled reports whether a SQL database holds the analytics tables.
+// Without one, e.g. on LMDB with no analytics_db_url, analytics reads come back
+// empty and writes are dropped.
+func (s *Storage) AnalyticsEnabled() bool {
+	return s.getDBConn() != nil
+}
+
+// AnalyticsStatus checks the analytics database, pinging it when configured
+func (s *Storage) AnalyticsStatus(ctx context.Context) string {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return AnalyticsDisabled
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := dbConn.PingContext(ctx); err != nil {
+		return AnalyticsUnreachable
+	}
+	return AnalyticsOK
+}
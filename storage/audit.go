@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Admin actions recorded in the audit log
+const (
+	AuditPurgeSpam           = "purge_spam"
+	AuditRunJob              = "run_job"
+	AuditSetNip05Name        = "set_nip05_name"
+	AuditDeleteNip05Name     = "delete_nip05_name"
+	AuditMarkClusterSpam     = "mark_cluster_spam"
+	AuditExemptClusterMember = "exempt_cluster_member"
+	AuditIssueSyncToken      = "issue_sync_token"
+	AuditRevokeSyncToken     = "revoke_sync_token"
+	AuditSetFeature          = "set_feature"
+	AuditClearFeature        = "clear_feature"
+	AuditAddChangelog        = "add_changelog_entry"
+	AuditDeleteChangelog     = "delete_changelog_entry"
+	AuditIssueCaptureToken   = "issue_capture_token"
+	AuditRevokeCaptureToken  = "revoke_capture_token"
+	AuditDeleteCapture       = "delete_capture"
+	AuditPurgeDuplicates     = "purge_duplicate_profiles"
+)
+
+type AuditEntry struct {
+	ID        int64
+	CreatedAt time.Time
+	Actor     string
+	Action    string
+	Details   string
+	Affected  int64
+}
+
+func (s *Storage) InitAuditSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	// Use SERIAL for PostgreSQL, AUTOINCREMENT for SQLite
+	schema := `
+	CREATE TABLE IF NOT EXISTS admin_audit (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at INTEGER NOT NULL,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		details TEXT NOT NULL DEFAULT '',
+		affected INTEGER NOT NULL DEFAULT 0
+	);`
+	if s.isPostgres() {
+		schema = `
+	CREATE TABLE IF NOT EXISTS admin_audit (
+		id SERIAL PRIMARY KEY,
+		created_at INTEGER NOT NULL,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		details TEXT NOT NULL DEFAULT '',
+		affected INTEGER NOT NULL DEFAULT 0
+	);`
+	}
+	schema += `
+	CREATE INDEX IF NOT EXISTS idx_admin_audit_created ON admin_audit(created_at DESC);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// RecordAdminAction appends an entry to the audit log. Entries are never updated or removed.
+func (s *Storage) RecordAdminAction(ctx context.Context, actor, action, details string, affected int64) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "RecordAdminAction", `
+		INSERT INTO admin_audit (created_at, actor, action, details, affected)
+		VALUES (?, ?, ?, ?, ?)
+	`, time.Now().Unix(), actor, action, details, affected).exec()
+
+	return err
+}
+
+// GetAuditLog returns the most recent admin actions, newest first
+func (s *Storage) GetAuditLog(ctx context.Context, limit int) ([]AuditEntry, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var entries []AuditEntry
+	err := s.query(ctx, dbConn, "GetAuditLog", `
+		SELECT id, created_at, actor, action, details, affected
+		FROM admin_audit
+		ORDER BY id DESC
+		LIMIT ?
+	`, limit).each(func(rows *sql.Rows) error {
+		var e AuditEntry
+		var createdAt int64
+		if err := rows.Scan(&e.ID, &createdAt, &e.Actor, &e.Action, &e.Details, &e.Affected); err != nil {
+			return err
+		}
+		e.CreatedAt = time.Unix(createdAt, 0)
+		entries = append(entries, e)
+		return nil
+	})
+
+	return entries, err
+}
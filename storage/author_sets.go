@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"hash/maphash"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Filters naming at least authorSetMinSize authors are interned. Smaller lists
+// are rarely repeated verbatim and cost little to handle directly.
+const (
+	authorSetMinSize = 20
+	authorSetMaxSets = 4096
+)
+
+// AuthorSet is an interned filter author list. Clients send the same follow
+// list's worth of authors on every REQ; interning hashes a list once per REQ,
+// and whatever is derived from it (analytics counts, the encoded query
+// parameter) is computed once per distinct list instead.
+type AuthorSet struct {
+	Authors []string
+
+	interned  bool
+	paramOnce sync.Once
+	param     string // Authors as a Postgres array literal
+}
+
+// Interned reports whether the set is shared by every REQ naming the same list
+func (a *AuthorSet) Interned() bool {
+	return a.interned
+}
+
+// pgArray returns the authors encoded as a query parameter, built on first use
+func (a *AuthorSet) pgArray() string {
+	a.paramOnce.Do(func() {
+		v, _ := pq.Array(a.Authors).Value()
+		a.param, _ = v.(string)
+	})
+	return a.param
+}
+
+// AuthorSetStats describes the author set interner
+type AuthorSetStats struct {
+	Sets   int
+	Hits   int64
+	Misses int64
+}
+
+type authorSets struct {
+	seed   maphash.Seed
+	mu     sync.Mutex
+	sets   map[uint64]*AuthorSet
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// InternAuthors returns the shared set for an author list. Lists too small to
+// be worth sharing get a set of their own.
+func (s *Storage) InternAuthors(authors []string) *AuthorSet {
+	if len(authors) < authorSetMinSize {
+		return &AuthorSet{Authors: authors}
+	}
+
+	c := &s.authorSets
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sets == nil {
+		c.seed = maphash.MakeSeed()
+		c.sets = make(map[uint64]*AuthorSet)
+	}
+
+	var h maphash.Hash
+	h.SetSeed(c.seed)
+	for _, pubkey := range authors {
+		h.WriteString(pubkey)
+		h.WriteByte(',')
+	}
+	key := h.Sum64()
+
+	if set, ok := c.sets[key]; ok && slices.Equal(set.Authors, authors) {
+		c.hits.Add(1)
+		return set
+	}
+	c.misses.Add(1)
+
+	if len(c.sets) >= authorSetMaxSets {
+		// Map iteration order is random, so this evicts an arbitrary set
+		for k := range c.sets {
+			delete(c.sets, k)
+			break
+		}
+	}
+	set := &AuthorSet{Authors: slices.Clone(authors), interned: true}
+	c.sets[key] = set
+	return set
+}
+
+func (s *Storage) GetAuthorSetStats() AuthorSetStats {
+	c := &s.authorSets
+	c.mu.Lock()
+	sets := len(c.sets)
+	c.mu.Unlock()
+	return AuthorSetStats{Sets: sets, Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+// isAuthorSetQuery reports whether a filter selects a large author list,
+// optionally narrowed by kind or time, which queryAuthorSet answers with a
+// statement prepared once per filter shape
+func isAuthorSetQuery(filter nostr.Filter) bool {
+	return len(filter.Authors) >= authorSetMinSize && len(filter.IDs) == 0 && len(filter.Tags) == 0 && filter.Search == ""
+}
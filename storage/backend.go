@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/fiatjaf/eventstore/lmdb"
+	"github.com/fiatjaf/eventstore/postgresql"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// eventBackend is everything Storage needs from an event store. Each supported
+// store gets an adapter here, so adding a backend never requires touching the
+// analytics or stats code, which only talks to Storage.
+type eventBackend interface {
+	eventstore.Store
+	eventstore.Counter
+
+	// DeleteByPubkey removes every event authored by pubkey
+	DeleteByPubkey(ctx context.Context, pubkey string) (int64, error)
+	// MaxQueryLimit is the largest filter limit QueryEvents honours, 0 if unbounded
+	MaxQueryLimit() int
+	// DiskSize reports how many bytes the stored events take
+	DiskSize(ctx context.Context) (int64, error)
+	// SQL returns the connection holding the event table, nil for non-SQL stores
+	SQL() *sqlx.DB
+	// GetByIDs fetches events by exact ID, skipping generic filter planning
+	GetByIDs(ctx context.Context, ids []string) ([]*nostr.Event, error)
+	// QueryAuthorSet answers a filter whose authors were interned as set
+	QueryAuthorSet(ctx context.Context, set *AuthorSet, filter nostr.Filter) ([]*nostr.Event, error)
+}
+
+func newEventBackend(backend, path string) (eventBackend, error) {
+	switch backend {
+	case "lmdb":
+		return &lmdbBackend{&lmdb.LMDBBackend{
+			Path:    path,
+			MapSize: 1 << 34, // 16GB
+		}}, nil
+	case "postgresql":
+		return &postgresBackend{PostgresBackend: &postgresql.PostgresBackend{
+			DatabaseURL: path,
+			QueryLimit:  1000000,
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %s (supported: lmdb, postgresql)", backend)
+	}
+}
+
+type lmdbBackend struct {
+	*lmdb.LMDBBackend
+}
+
+func (b *lmdbBackend) DeleteByPubkey(ctx context.Context, pubkey string) (int64, error) {
+	var deleted int64
+	for {
+		ch, err := b.QueryEvents(ctx, nostr.Filter{Authors: []string{pubkey}, Limit: b.MaxQueryLimit()})
+		if err != nil {
+			return deleted, err
+		}
+
+		// Drain the read transaction before opening write transactions
+		var batch []*nostr.Event
+		for evt := range ch {
+			batch = append(batch, evt)
+		}
+		if len(batch) == 0 {
+			return deleted, nil
+		}
+
+		for _, evt := range batch {
+			if err := b.DeleteEvent(ctx, evt); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+}
+
+func (b *lmdbBackend) MaxQueryLimit() int {
+	return b.MaxLimit
+}
+
+func (b *lmdbBackend) DiskSize(ctx context.Context) (int64, error) {
+	stat, err := os.Stat(b.Path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat LMDB file: %w", err)
+	}
+	return stat.Size(), nil
+}
+
+func (b *lmdbBackend) SQL() *sqlx.DB {
+	return nil
+}
+
+// GetByIDs goes through QueryEvents, whose planner already reads the ID index directly
+func (b *lmdbBackend) GetByIDs(ctx context.Context, ids []string) ([]*nostr.Event, error) {
+	ch, err := b.QueryEvents(ctx, nostr.Filter{IDs: ids})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*nostr.Event, 0, len(ids))
+	for evt := range ch {
+		events = append(events, evt)
+	}
+	return events, nil
+}
+
+// QueryAuthorSet goes through QueryEvents, which already walks one pubkey index
+// range per author and has no statement to reuse
+func (b *lmdbBackend) QueryAuthorSet(ctx context.Context, set *AuthorSet, filter nostr.Filter) ([]*nostr.Event, error) {
+	ch, err := b.QueryEvents(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*nostr.Event, 0)
+	for evt := range ch {
+		events = append(events, evt)
+	}
+	return events, nil
+}
+
+type postgresBackend struct {
+	*postgresql.PostgresBackend
+
+	stmtsMu sync.Mutex
+	stmts   map[authorSetShape]*sql.Stmt
+}
+
+func (b *postgresBackend) DeleteByPubkey(ctx context.Context, pubkey string) (int64, error) {
+	result, err := b.DB.ExecContext(ctx, `DELETE FROM event WHERE pubkey = $1`, pubkey)
+	if err != nil {
+		return 0, queryErr("DeleteByPubkey", err)
+	}
+	return result.RowsAffected()
+}
+
+func (b *postgresBackend) MaxQueryLimit() int {
+	return b.QueryLimit
+}
+
+func (b *postgresBackend) DiskSize(ctx context.Context) (int64, error) {
+	var size int64
+	err := b.DB.QueryRowContext(ctx, `SELECT pg_total_relation_size('event')`).Scan(&size)
+	return size, queryErr("DiskSize", err)
+}
+
+func (b *postgresBackend) SQL() *sqlx.DB {
+	return b.DB
+}
+
+// GetByIDs is a single unique-index lookup with one array parameter, so the plan
+// is the same however many IDs are asked for and there is no sort or ID limit
+func (b *postgresBackend) GetByIDs(ctx context.Context, ids []string) ([]*nostr.Event, error) {
+	rows, err := b.DB.QueryContext(ctx, `
+		SELECT id, pubkey, created_at, kind, tags, content, sig
+		FROM event WHERE id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, queryErr("GetByIDs", err)
+	}
+	defer rows.Close()
+
+	events := make([]*nostr.Event, 0, len(ids))
+	for rows.Next() {
+		var evt nostr.Event
+		var createdAt int64
+		if err := rows.Scan(&evt.ID, &evt.PubKey, &createdAt, &evt.Kind, &evt.Tags, &evt.Content, &evt.Sig); err != nil {
+			return nil, queryErr("GetByIDs", err)
+		}
+		evt.CreatedAt = nostr.Timestamp(createdAt)
+		events = append(events, &evt)
+	}
+	return events, queryErr("GetByIDs", rows.Err())
+}
+
+// authorSetShape is which optional conditions an author set query has. The SQL
+// depends on nothing else, so each shape is prepared once.
+type authorSetShape struct {
+	kinds, since, until bool
+}
+
+// QueryAuthorSet runs the same query as QueryEvents, but binds the authors and
+// kinds as single array parameters. The statement text no longer changes with
+// every list, so Postgres reuses its plan, the set's encoded authors are reused
+// across REQs, and lists over the 500 author limit are accepted.
+func (b *postgresBackend) QueryAuthorSet(ctx context.Context, set *AuthorSet, filter nostr.Filter) ([]*nostr.Event, error) {
+	shape := authorSetShape{kinds: len(filter.Kinds) > 0, since: filter.Since != nil, until: filter.Until != nil}
+	stmt, err := b.authorSetStmt(ctx, shape)
+	if err != nil {
+		return nil, err
+	}
+
+	params := []any{set.pgArray()}
+	if shape.kinds {
+		kinds := make([]int64, len(filter.Kinds))
+		for i, k := range filter.Kinds {
+			kinds[i] = int64(k)
+		}
+		params = append(params, pq.Array(kinds))
+	}
+	if shape.since {
+		params = append(params, int64(*filter.Since))
+	}
+	if shape.until {
+		params = append(params, int64(*filter.Until))
+	}
+	limit := filter.Limit
+	if limit < 1 || limit > b.QueryLimit {
+		limit = b.QueryLimit
+	}
+	params = append(params, limit)
+
+	rows, err := stmt.QueryContext(ctx, params...)
+	if err != nil {
+		return nil, queryErr("QueryAuthorSet", err)
+	}
+	defer rows.Close()
+
+	events := make([]*nostr.Event, 0)
+	for rows.Next() {
+		var evt nostr.Event
+		var createdAt int64
+		if err := rows.Scan(&evt.ID, &evt.PubKey, &createdAt, &evt.Kind, &evt.Tags, &evt.Content, &evt.Sig); err != nil {
+			return nil, queryErr("QueryAuthorSet", err)
+		}
+		evt.CreatedAt = nostr.Timestamp(createdAt)
+		events = append(events, &evt)
+	}
+	return events, queryErr("QueryAuthorSet", rows.Err())
+}
+
+func (b *postgresBackend) authorSetStmt(ctx context.Context, shape authorSetShape) (*sql.Stmt, error) {
+	b.stmtsMu.Lock()
+	defer b.stmtsMu.Unlock()
+
+	if stmt, ok := b.stmts[shape]; ok {
+		return stmt, nil
+	}
+
+	conditions := []string{"pubkey = ANY($1)"}
+	n := 1
+	if shape.kinds {
+		n++
+		conditions = append(conditions, fmt.Sprintf("kind = ANY($%d)", n))
+	}
+	if shape.since {
+		n++
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", n))
+	}
+	if shape.until {
+		n++
+		conditions = append(conditions, fmt.Sprintf("created_at <= $%d", n))
+	}
+	query := fmt.Sprintf(`
+		SELECT id, pubkey, created_at, kind, tags, content, sig
+		FROM event WHERE %s
+		ORDER BY created_at DESC, id LIMIT $%d
+	`, strings.Join(conditions, " AND "), n+1)
+
+	stmt, err := b.DB.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if b.stmts == nil {
+		b.stmts = make(map[authorSetShape]*sql.Stmt)
+	}
+	b.stmts[shape] = stmt
+	return stmt, nil
+}
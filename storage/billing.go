@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrInvoiceClaimed is returned when a key was already issued for a paid invoice
+var ErrInvoiceClaimed = errors.New("a key was already issued for this invoice")
+
+// BillingInvoice is an invoice for a premium API key. Only hashes of the claim
+// token and of issued keys are stored.
+type BillingInvoice struct {
+	PaymentHash    string
+	Invoice        string
+	AmountSats     int64
+	Pubkey         string // optional, the buyer's nostr pubkey
+	ClaimTokenHash string
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+	SettledAt      time.Time // zero until paid
+	KeyID          string    // set once a key was issued
+}
+
+// BillingKey is a premium API key issued for a paid invoice
+type BillingKey struct {
+	ID          string
+	KeyHash     string
+	PaymentHash string
+	Pubkey      string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// BillingTotals summarizes premium sales for the admin page
+type BillingTotals struct {
+	RevenueSats     int64
+	PaidInvoices    int64
+	PendingInvoices int64
+	ActiveKeys      int64
+}
+
+func (s *Storage) InitBillingSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS billing_invoices (
+		payment_hash TEXT PRIMARY KEY,
+		invoice TEXT NOT NULL,
+		amount_sats INTEGER NOT NULL,
+		pubkey TEXT NOT NULL DEFAULT '',
+		claim_token_hash TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		settled_at INTEGER NOT NULL DEFAULT 0,
+		key_id TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_billing_invoices_created ON billing_invoices(created_at DESC);
+
+	CREATE TABLE IF NOT EXISTS billing_keys (
+		id TEXT PRIMARY KEY,
+		key_hash TEXT NOT NULL UNIQUE,
+		payment_hash TEXT NOT NULL,
+		pubkey TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_billing_keys_expires ON billing_keys(expires_at);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+func (s *Storage) SaveBillingInvoice(ctx context.Context, inv BillingInvoice) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "SaveBillingInvoice", `
+		INSERT INTO billing_invoices (payment_hash, invoice, amount_sats, pubkey, claim_token_hash, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, inv.PaymentHash, inv.Invoice, inv.AmountSats, inv.Pubkey, inv.ClaimTokenHash, inv.CreatedAt.Unix(), inv.ExpiresAt.Unix()).exec()
+	return err
+}
+
+// GetBillingInvoice returns the invoice, or nil if there is none with that payment hash
+func (s *Storage) GetBillingInvoice(ctx context.Context, paymentHash string) (*BillingInvoice, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var inv BillingInvoice
+	var createdAt, expiresAt, settledAt int64
+	err := s.query(ctx, dbConn, "GetBillingInvoice", `
+		SELECT payment_hash, invoice, amount_sats, pubkey, claim_token_hash, created_at, expires_at, settled_at, key_id
+		FROM billing_invoices WHERE payment_hash = ?
+	`, paymentHash).scan(&inv.PaymentHash, &inv.Invoice, &inv.AmountSats, &inv.Pubkey, &inv.ClaimTokenHash, &createdAt, &expiresAt, &settledAt, &inv.KeyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	inv.CreatedAt = time.Unix(createdAt, 0)
+	inv.ExpiresAt = time.Unix(expiresAt, 0)
+	if settledAt > 0 {
+		inv.SettledAt = time.Unix(settledAt, 0)
+	}
+	return &inv, nil
+}
+
+// IssueBillingKey marks the invoice paid and stores the key issued for it, unless
+// a key was issued for it already
+func (s *Storage) IssueBillingKey(ctx context.Context, key BillingKey, settledAt time.Time) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	return s.inTx(ctx, dbConn, "IssueBillingKey", func(ctx context.Context, tx *sqlx.Tx) error {
+		result, err := s.query(ctx, tx, "IssueBillingKey: settle", `
+			UPDATE billing_invoices SET settled_at = ?, key_id = ?
+			WHERE payment_hash = ? AND key_id = ''
+		`, settledAt.Unix(), key.ID, key.PaymentHash).exec()
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrInvoiceClaimed
+		}
+
+		_, err = s.query(ctx, tx, "IssueBillingKey: key", `
+			INSERT INTO billing_keys (id, key_hash, payment_hash, pubkey, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, key.ID, key.KeyHash, key.PaymentHash, key.Pubkey, key.CreatedAt.Unix(), key.ExpiresAt.Unix()).exec()
+		return err
+	})
+}
+
+// GetBillingKeys returns issued keys, newest first; with activeOnly, only unexpired ones
+func (s *Storage) GetBillingKeys(ctx context.Context, activeOnly bool, limit int) ([]BillingKey, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	minExpiry := int64(0)
+	if activeOnly {
+		minExpiry = time.Now().Unix()
+	}
+
+	var keys []BillingKey
+	err := s.query(ctx, dbConn, "GetBillingKeys", `
+		SELECT id, key_hash, payment_hash, pubkey, created_at, expires_at
+		FROM billing_keys
+		WHERE expires_at > ?
+		ORDER BY created_at DESC
+		LIMIT ?
+	`, minExpiry, limit).each(func(rows *sql.Rows) error {
+		var k BillingKey
+		var createdAt, expiresAt int64
+		if err := rows.Scan(&k.ID, &k.KeyHash, &k.PaymentHash, &k.Pubkey, &createdAt, &expiresAt); err != nil {
+			return err
+		}
+		k.CreatedAt = time.Unix(createdAt, 0)
+		k.ExpiresAt = time.Unix(expiresAt, 0)
+		keys = append(keys, k)
+		return nil
+	})
+
+	return keys, err
+}
+
+func (s *Storage) GetBillingTotals(ctx context.Context) (BillingTotals, error) {
+	var totals BillingTotals
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return totals, nil
+	}
+
+	now := time.Now().Unix()
+	err := s.query(ctx, dbConn, "GetBillingTotals: invoices", `
+		SELECT
+			COALESCE(SUM(CASE WHEN settled_at > 0 THEN amount_sats ELSE 0 END), 0),
+			COUNT(CASE WHEN settled_at > 0 THEN 1 END),
+			COUNT(CASE WHEN settled_at = 0 AND expires_at > ? THEN 1 END)
+		FROM billing_invoices
+	`, now).scan(&totals.RevenueSats, &totals.PaidInvoices, &totals.PendingInvoices)
+	if err != nil {
+		return totals, err
+	}
+
+	err = s.query(ctx, dbConn, "GetBillingTotals: keys", `
+		SELECT COUNT(*) FROM billing_keys WHERE expires_at > ?
+	`, now).scan(&totals.ActiveKeys)
+	return totals, err
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Changelog entry categories
+const (
+	ChangelogPolicy    = "policy"    // recorded by the operator
+	ChangelogMilestone = "milestone" // appended when the dataset crosses a threshold
+)
+
+// ChangelogEntry is one entry of the public changelog
+type ChangelogEntry struct {
+	ID        int64
+	Category  string
+	Title     string
+	Body      string
+	Milestone string // key of the milestone reached, empty for policy entries
+	CreatedBy string
+	CreatedAt time.Time
+	NoteID    string // kind 1 note the entry was published as, if any
+}
+
+func (s *Storage) InitChangelogSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	// Use SERIAL for PostgreSQL, AUTOINCREMENT for SQLite
+	id := "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	if s.isPostgres() {
+		id = "id SERIAL PRIMARY KEY"
+	}
+	schema := `
+	CREATE TABLE IF NOT EXISTS changelog_entries (
+		` + id + `,
+		category TEXT NOT NULL,
+		title TEXT NOT NULL,
+		body TEXT NOT NULL DEFAULT '',
+		milestone TEXT UNIQUE,
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		note_id TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_changelog_entries_created ON changelog_entries(created_at DESC);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// AddChangelogEntry appends e to the changelog and returns its ID. A milestone
+// is only ever recorded once: adding it again returns 0 without an error.
+func (s *Storage) AddChangelogEntry(ctx context.Context, e ChangelogEntry) (int64, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return 0, nil
+	}
+
+	var milestone any
+	if e.Milestone != "" {
+		milestone = e.Milestone
+	}
+
+	var id int64
+	err := s.query(ctx, dbConn, "AddChangelogEntry", `
+		INSERT INTO changelog_entries (category, title, body, milestone, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (milestone) DO NOTHING
+		RETURNING id
+	`, e.Category, e.Title, e.Body, milestone, e.CreatedBy, e.CreatedAt.Unix()).scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// SetChangelogNote records the kind 1 note an entry was published as
+func (s *Storage) SetChangelogNote(ctx context.Context, id int64, noteID string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "SetChangelogNote", `UPDATE changelog_entries SET note_id = ? WHERE id = ?`, noteID, id).exec()
+	return err
+}
+
+// DeleteChangelogEntry removes an entry, returning it, or nil if there was none
+func (s *Storage) DeleteChangelogEntry(ctx context.Context, id int64) (*ChangelogEntry, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var e *ChangelogEntry
+	err := s.query(ctx, dbConn, "DeleteChangelogEntry", `
+		DELETE FROM changelog_entries WHERE id = ?
+		RETURNING id, category, title, body, COALESCE(milestone, ''), created_by, created_at, note_id
+	`, id).each(func(rows *sql.Rows) error {
+		entry, err := scanChangelogEntry(rows)
+		e = &entry
+		return err
+	})
+	return e, err
+}
+
+// GetChangelog returns the most recent changelog entries, newest first
+func (s *Storage) GetChangelog(ctx context.Context, limit int) ([]ChangelogEntry, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var entries []ChangelogEntry
+	err := s.query(ctx, dbConn, "GetChangelog", `
+		SELECT id, category, title, body, COALESCE(milestone, ''), created_by, created_at, note_id
+		FROM changelog_entries
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, limit).each(func(rows *sql.Rows) error {
+		e, err := scanChangelogEntry(rows)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+func scanChangelogEntry(rows *sql.Rows) (ChangelogEntry, error) {
+	var e ChangelogEntry
+	var createdAt int64
+	if err := rows.Scan(&e.ID, &e.Category, &e.Title, &e.Body, &e.Milestone, &e.CreatedBy, &createdAt, &e.NoteID); err != nil {
+		return e, err
+	}
+	e.CreatedAt = time.Unix(createdAt, 0)
+	return e, nil
+}
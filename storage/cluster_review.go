@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// GetBotCluster returns a cluster with all its members, or nil if there is no
+// cluster with that id. Clusters replaced by a later detection run are
+// returned too, with IsActive false.
+func (s *Storage) GetBotCluster(ctx context.Context, id int64) (*BotCluster, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	c := BotCluster{ID: id}
+	var detectedAt int64
+	var isActive int
+	err := s.query(ctx, dbConn, "GetBotCluster: cluster", `
+		SELECT detected_at, cluster_size, internal_density, external_ratio, is_active
+		FROM bot_clusters
+		WHERE cluster_id = ?
+	`, id).scan(&detectedAt, &c.Size, &c.InternalDensity, &c.ExternalRatio, &isActive)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.DetectedAt = time.Unix(detectedAt, 0)
+	c.IsActive = isActive == 1
+
+	err = s.query(ctx, dbConn, "GetBotCluster: members", `
+		SELECT pubkey FROM bot_cluster_members WHERE cluster_id = ? ORDER BY pubkey
+	`, id).each(func(rows *sql.Rows) error {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return err
+		}
+		c.Members = append(c.Members, pubkey)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// GetRequestCounts returns how often each pubkey was requested, leaving out
+// pubkeys never requested
+func (s *Storage) GetRequestCounts(ctx context.Context, pubkeys []string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil || len(pubkeys) == 0 {
+		return counts, nil
+	}
+
+	err := s.query(ctx, dbConn, "GetRequestCounts", `
+		SELECT pubkey, total_requests FROM req_analytics WHERE pubkey = ANY($1)
+	`, pq.Array(pubkeys)).each(func(rows *sql.Rows) error {
+		var pubkey string
+		var count int64
+		if err := rows.Scan(&pubkey, &count); err != nil {
+			return err
+		}
+		counts[pubkey] = count
+		return nil
+	})
+
+	return counts, err
+}
+
+// ExemptFromBotClusters clears a pubkey wrongly caught in a bot cluster. It
+// leaves the active clusters and the unpurged spam candidates, and later
+// detection runs leave it out of the clusters they find.
+func (s *Storage) ExemptFromBotClusters(ctx context.Context, pubkey string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	return s.inTx(ctx, dbConn, "ExemptFromBotClusters", func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := s.query(ctx, tx, "ExemptFromBotClusters: exemption", `
+			INSERT INTO bot_cluster_exemptions (pubkey, exempted_at)
+			VALUES (?, ?)
+			ON CONFLICT(pubkey) DO NOTHING
+		`, pubkey, time.Now().Unix()).exec(); err != nil {
+			return err
+		}
+
+		if _, err := s.query(ctx, tx, "ExemptFromBotClusters: members", `
+			DELETE FROM bot_cluster_members
+			WHERE pubkey = ? AND cluster_id IN (SELECT cluster_id FROM bot_clusters WHERE is_active = 1)
+		`, pubkey).exec(); err != nil {
+			return err
+		}
+
+		if _, err := s.query(ctx, tx, "ExemptFromBotClusters: sizes", `
+			UPDATE bot_clusters SET cluster_size = (
+				SELECT COUNT(*) FROM bot_cluster_members m WHERE m.cluster_id = bot_clusters.cluster_id
+			)
+			WHERE is_active = 1
+		`).exec(); err != nil {
+			return err
+		}
+
+		_, err := s.query(ctx, tx, "ExemptFromBotClusters: spam candidates", `
+			DELETE FROM spam_candidates WHERE pubkey = ? AND purged = 0
+		`, pubkey).exec()
+		return err
+	})
+}
+
+// GetBotClusterExemptions returns the pubkeys exempted from bot clusters
+func (s *Storage) GetBotClusterExemptions(ctx context.Context) (map[string]bool, error) {
+	result := make(map[string]bool)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return result, nil
+	}
+
+	err := s.query(ctx, dbConn, "GetBotClusterExemptions", `SELECT pubkey FROM bot_cluster_exemptions`).each(func(rows *sql.Rows) error {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return err
+		}
+		result[pubkey] = true
+		return nil
+	})
+
+	return result, err
+}
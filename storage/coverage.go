@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// KindCoverage is which of a set of kinds we hold for one trusted pubkey, and how
+// recent the newest stored event of each is
+type KindCoverage struct {
+	Pubkey       string
+	LastSyncedAt int64         // trusted sync's last pass over the pubkey, 0 if never
+	Latest       map[int]int64 // kind -> created_at of the newest stored event
+}
+
+// GetTrustedKindCoverage returns the coverage of kinds for every trusted pubkey
+func (s *Storage) GetTrustedKindCoverage(ctx context.Context, kinds []int) ([]KindCoverage, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	kinds64 := make([]int64, len(kinds))
+	for i, k := range kinds {
+		kinds64[i] = int64(k)
+	}
+
+	var coverage []KindCoverage
+	err := s.query(ctx, dbConn, "GetTrustedKindCoverage", `
+		SELECT t.pubkey, COALESCE(ts.last_synced_at, 0), e.kind, MAX(e.created_at)
+		FROM trusted_pubkeys t
+		LEFT JOIN trusted_sync_state ts ON ts.pubkey = t.pubkey
+		LEFT JOIN event e ON e.pubkey = t.pubkey AND e.kind = ANY($1)
+		GROUP BY t.pubkey, ts.last_synced_at, e.kind
+		ORDER BY t.pubkey
+	`, pq.Array(kinds64)).each(func(rows *sql.Rows) error {
+		var pubkey string
+		var lastSynced int64
+		var kind, latest *int64
+		if err := rows.Scan(&pubkey, &lastSynced, &kind, &latest); err != nil {
+			return err
+		}
+
+		if len(coverage) == 0 || coverage[len(coverage)-1].Pubkey != pubkey {
+			coverage = append(coverage, KindCoverage{
+				Pubkey:       pubkey,
+				LastSyncedAt: lastSynced,
+				Latest:       make(map[int]int64),
+			})
+		}
+		// A pubkey with none of the kinds comes back as a single row without a kind
+		if kind != nil && latest != nil {
+			coverage[len(coverage)-1].Latest[int(*kind)] = *latest
+		}
+		return nil
+	})
+
+	return coverage, err
+}
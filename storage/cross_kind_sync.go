@@ -2,19 +2,21 @@ package storage
 
 import (
 	"context"
-	"fmt"
-	"strings"
+	"database/sql"
+
+	"github.com/lib/pq"
 )
 
 // GetPubkeysMissingKind returns pubkeys that have sourceKind but NOT targetKind
 // Limited to `limit` results for batched processing
 func (s *Storage) GetPubkeysMissingKind(ctx context.Context, sourceKind, targetKind int, limit int) ([]string, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	query := `
+	var pubkeys []string
+	err := s.query(ctx, dbConn, "GetPubkeysMissingKind", `
 		SELECT DISTINCT e1.pubkey
 		FROM event e1
 		WHERE e1.kind = $1
@@ -24,23 +26,16 @@ func (s *Storage) GetPubkeysMissingKind(ctx context.Context, sourceKind, targetK
 			AND e2.kind = $2
 		)
 		LIMIT $3
-	`
-
-	rows, err := dbConn.QueryContext(ctx, query, sourceKind, targetKind, limit)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query missing kinds: %w", err)
-	}
-	defer rows.Close()
-
-	var pubkeys []string
-	for rows.Next() {
+	`, sourceKind, targetKind, limit).each(func(rows *sql.Rows) error {
 		var pk string
 		if err := rows.Scan(&pk); err != nil {
-			continue
+			return err
 		}
 		pubkeys = append(pubkeys, pk)
-	}
-	return pubkeys, rows.Err()
+		return nil
+	})
+
+	return pubkeys, err
 }
 
 // CheckPubkeyHasKinds checks which of the specified kinds each pubkey has
@@ -55,49 +50,27 @@ func (s *Storage) CheckPubkeyHasKinds(ctx context.Context, pubkeys []string, kin
 		return result, nil
 	}
 
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return result, nil
 	}
 
-	// Build pubkey placeholders
-	pubkeyPlaceholders := make([]string, len(pubkeys))
-	args := make([]interface{}, 0, len(pubkeys)+len(kinds))
-	for i, pk := range pubkeys {
-		pubkeyPlaceholders[i] = fmt.Sprintf("$%d", i+1)
-		args = append(args, pk)
-	}
-
-	// Build kind placeholders
-	kindPlaceholders := make([]string, len(kinds))
-	for i, k := range kinds {
-		kindPlaceholders[i] = fmt.Sprintf("$%d", len(pubkeys)+i+1)
-		args = append(args, k)
-	}
-
-	query := fmt.Sprintf(`
+	err := s.query(ctx, dbConn, "CheckPubkeyHasKinds", `
 		SELECT DISTINCT pubkey, kind
 		FROM event
-		WHERE pubkey IN (%s) AND kind IN (%s)
-	`, strings.Join(pubkeyPlaceholders, ","), strings.Join(kindPlaceholders, ","))
-
-	rows, err := dbConn.QueryContext(ctx, query, args...)
-	if err != nil {
-		return result, fmt.Errorf("failed to check pubkey kinds: %w", err)
-	}
-	defer rows.Close()
-
-	for rows.Next() {
+		WHERE pubkey = ANY($1) AND kind = ANY($2)
+	`, pq.Array(pubkeys), pq.Array(kinds)).each(func(rows *sql.Rows) error {
 		var pubkey string
 		var kind int
 		if err := rows.Scan(&pubkey, &kind); err != nil {
-			continue
+			return err
 		}
 		if result[pubkey] == nil {
 			result[pubkey] = make(map[int]bool)
 		}
 		result[pubkey][kind] = true
-	}
+		return nil
+	})
 
-	return result, rows.Err()
+	return result, err
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"time"
 )
 
@@ -19,6 +20,15 @@ type HourlyStats struct {
 	EventsServed int64
 }
 
+// RegionHourStats is the request volume from one region in one hour of the day
+// (UTC), summed over the days queried
+type RegionHourStats struct {
+	Region       string
+	Hour         int
+	TotalREQs    int64
+	EventsServed int64
+}
+
 func (s *Storage) InitDailyStatsSchema() error {
 	dbConn := s.getDBConn()
 	if dbConn == nil {
@@ -43,6 +53,16 @@ func (s *Storage) InitDailyStatsSchema() error {
 		PRIMARY KEY (hour, ip)
 	);
 	CREATE INDEX IF NOT EXISTS idx_hourly_requests_hour ON hourly_requests(hour);
+
+	-- Requests per GeoIP region, by UTC hour; the region is resolved before the
+	-- IP is stored or hashed, and the IP isn't kept here
+	CREATE TABLE IF NOT EXISTS hourly_region_requests (
+		hour TEXT NOT NULL,
+		region TEXT NOT NULL,
+		request_count INTEGER NOT NULL DEFAULT 0,
+		events_served INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (hour, region)
+	);
 	`
 
 	_, err := dbConn.Exec(schema)
@@ -59,32 +79,85 @@ func (s *Storage) RecordDailyStats(ctx context.Context, ip string, eventsServed
 	date := now.Format("2006-01-02")
 	hour := now.Format("2006-01-02 15")
 
+	ip, err := s.anonymizeIP(ctx, ip, now)
+	if err != nil {
+		return err
+	}
+
 	// Record daily stats
-	_, err := dbConn.ExecContext(ctx, s.rebind(`
+	_, err = s.query(ctx, dbConn, "RecordDailyStats: daily", `
 		INSERT INTO daily_requests (date, ip, request_count, events_served)
 		VALUES (?, ?, 1, ?)
 		ON CONFLICT(date, ip) DO UPDATE SET
 			request_count = daily_requests.request_count + 1,
 			events_served = daily_requests.events_served + excluded.events_served
-	`), date, ip, eventsServed)
+	`, date, ip, eventsServed).exec()
 	if err != nil {
 		return err
 	}
 
 	// Record hourly stats
-	_, err = dbConn.ExecContext(ctx, s.rebind(`
+	_, err = s.query(ctx, dbConn, "RecordDailyStats: hourly", `
 		INSERT INTO hourly_requests (hour, ip, request_count, events_served)
 		VALUES (?, ?, 1, ?)
 		ON CONFLICT(hour, ip) DO UPDATE SET
 			request_count = hourly_requests.request_count + 1,
 			events_served = hourly_requests.events_served + excluded.events_served
-	`), hour, ip, eventsServed)
+	`, hour, ip, eventsServed).exec()
 
 	return err
 }
 
-func (s *Storage) GetDailyStats(ctx context.Context, days int) ([]DailyStats, error) {
+// RecordRegionRequest counts a REQ from region in the current UTC hour
+func (s *Storage) RecordRegionRequest(ctx context.Context, region string, eventsServed int64) error {
 	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "RecordRegionRequest", `
+		INSERT INTO hourly_region_requests (hour, region, request_count, events_served)
+		VALUES (?, ?, 1, ?)
+		ON CONFLICT(hour, region) DO UPDATE SET
+			request_count = hourly_region_requests.request_count + 1,
+			events_served = hourly_region_requests.events_served + excluded.events_served
+	`, time.Now().UTC().Format("2006-01-02 15"), region, eventsServed).exec()
+	return err
+}
+
+// GetRegionHourStats sums the last days of region requests by region and hour of the day
+func (s *Storage) GetRegionHourStats(ctx context.Context, days int) ([]RegionHourStats, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	cutoffHour := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02 15")
+
+	var results []RegionHourStats
+	err := s.query(ctx, dbConn, "GetRegionHourStats", `
+		SELECT
+			region,
+			CAST(SUBSTR(hour, 12, 2) AS INTEGER) as hour_of_day,
+			SUM(request_count) as total_reqs,
+			SUM(events_served) as events_served
+		FROM hourly_region_requests
+		WHERE hour >= ?
+		GROUP BY region, hour_of_day
+	`, cutoffHour).each(func(rows *sql.Rows) error {
+		var stat RegionHourStats
+		if err := rows.Scan(&stat.Region, &stat.Hour, &stat.TotalREQs, &stat.EventsServed); err != nil {
+			return err
+		}
+		results = append(results, stat)
+		return nil
+	})
+
+	return results, err
+}
+
+func (s *Storage) GetDailyStats(ctx context.Context, days int) ([]DailyStats, error) {
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
@@ -92,7 +165,8 @@ func (s *Storage) GetDailyStats(ctx context.Context, days int) ([]DailyStats, er
 	// Calculate the cutoff date in Go (works for both SQLite and PostgreSQL)
 	cutoffDate := time.Now().AddDate(0, 0, -days).Format("2006-01-02")
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var results []DailyStats
+	err := s.query(ctx, dbConn, "GetDailyStats", `
 		SELECT
 			date,
 			SUM(request_count) as total_reqs,
@@ -102,26 +176,20 @@ func (s *Storage) GetDailyStats(ctx context.Context, days int) ([]DailyStats, er
 		WHERE date >= ?
 		GROUP BY date
 		ORDER BY date ASC
-	`), cutoffDate)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var results []DailyStats
-	for rows.Next() {
+	`, cutoffDate).each(func(rows *sql.Rows) error {
 		var stat DailyStats
 		if err := rows.Scan(&stat.Date, &stat.TotalREQs, &stat.UniqueIPs, &stat.EventsServed); err != nil {
-			return nil, err
+			return err
 		}
 		results = append(results, stat)
-	}
+		return nil
+	})
 
-	return results, rows.Err()
+	return results, err
 }
 
 func (s *Storage) GetHourlyStats(ctx context.Context, hours int) ([]HourlyStats, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
@@ -129,7 +197,8 @@ func (s *Storage) GetHourlyStats(ctx context.Context, hours int) ([]HourlyStats,
 	// Calculate the cutoff hour in Go (works for both SQLite and PostgreSQL)
 	cutoffHour := time.Now().Add(-time.Duration(hours) * time.Hour).Format("2006-01-02 15")
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var results []HourlyStats
+	err := s.query(ctx, dbConn, "GetHourlyStats", `
 		SELECT
 			hour,
 			SUM(request_count) as total_reqs,
@@ -139,22 +208,16 @@ func (s *Storage) GetHourlyStats(ctx context.Context, hours int) ([]HourlyStats,
 		WHERE hour >= ?
 		GROUP BY hour
 		ORDER BY hour ASC
-	`), cutoffHour)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var results []HourlyStats
-	for rows.Next() {
+	`, cutoffHour).each(func(rows *sql.Rows) error {
 		var stat HourlyStats
 		if err := rows.Scan(&stat.Hour, &stat.TotalREQs, &stat.UniqueIPs, &stat.EventsServed); err != nil {
-			return nil, err
+			return err
 		}
 		results = append(results, stat)
-	}
+		return nil
+	})
 
-	return results, rows.Err()
+	return results, err
 }
 
 type TopIP struct {
@@ -164,12 +227,13 @@ type TopIP struct {
 }
 
 func (s *Storage) GetTopIPs(ctx context.Context, limit int) ([]TopIP, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var results []TopIP
+	err := s.query(ctx, dbConn, "GetTopIPs", `
 		SELECT
 			ip,
 			SUM(request_count) as total_reqs,
@@ -178,46 +242,51 @@ func (s *Storage) GetTopIPs(ctx context.Context, limit int) ([]TopIP, error) {
 		GROUP BY ip
 		ORDER BY events_served DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var results []TopIP
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var ip TopIP
 		if err := rows.Scan(&ip.IP, &ip.TotalREQs, &ip.EventsServed); err != nil {
-			return nil, err
+			return err
 		}
 		results = append(results, ip)
-	}
+		return nil
+	})
 
-	return results, rows.Err()
+	return results, err
 }
 
 func (s *Storage) GetEventsServedLast24Hours(ctx context.Context, ip string) (int64, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return 0, nil
 	}
 
 	// Calculate the cutoff hour in Go (works for both SQLite and PostgreSQL)
-	cutoffHour := time.Now().Add(-24 * time.Hour).Format("2006-01-02 15")
+	now := time.Now()
+	cutoffHour := now.Add(-24 * time.Hour).Format("2006-01-02 15")
+
+	// With IP hashing the last 24 hours span two daily salts
+	today, err := s.anonymizeIP(ctx, ip, now)
+	if err != nil {
+		return 0, err
+	}
+	yesterday, err := s.anonymizeIP(ctx, ip, now.Add(-24*time.Hour))
+	if err != nil {
+		return 0, err
+	}
 
 	var total int64
-	err := dbConn.QueryRowContext(ctx, s.rebind(`
+	err = s.query(ctx, dbConn, "GetEventsServedLast24Hours", `
 		SELECT COALESCE(SUM(events_served), 0)
 		FROM hourly_requests
-		WHERE ip = ?
+		WHERE ip IN (?, ?)
 		  AND hour >= ?
-	`), ip, cutoffHour).Scan(&total)
+	`, today, yesterday, cutoffHour).scan(&total)
 
 	return total, err
 }
 
 func (s *Storage) GetTodayStats(ctx context.Context) (*DailyStats, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
@@ -226,14 +295,14 @@ func (s *Storage) GetTodayStats(ctx context.Context) (*DailyStats, error) {
 	var stat DailyStats
 	stat.Date = today
 
-	err := dbConn.QueryRowContext(ctx, s.rebind(`
+	err := s.query(ctx, dbConn, "GetTodayStats", `
 		SELECT
 			COALESCE(SUM(request_count), 0),
 			COALESCE(COUNT(DISTINCT ip), 0),
 			COALESCE(SUM(events_served), 0)
 		FROM daily_requests
 		WHERE date = ?
-	`), today).Scan(&stat.TotalREQs, &stat.UniqueIPs, &stat.EventsServed)
+	`, today).scan(&stat.TotalREQs, &stat.UniqueIPs, &stat.EventsServed)
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Derived tables are rebuilt into "<name>_next" shadow tables and swapped in
+// with RENAME, so readers never see a half-populated table and a failed
+// rebuild leaves the previous contents in place.
+const shadowSuffix = "_next"
+
+type derivedTable struct {
+	name       string
+	primaryKey string
+	// index name -> indexed columns
+	indexes map[string]string
+	// replay brings writes made to the live table while the shadow was being
+	// filled over to the shadow. Each statement gets the rebuild's start time
+	// and runs at the swap, with the live table locked against writers.
+	replay []string
+}
+
+var (
+	trustedPubkeysTable = derivedTable{
+		name:       "trusted_pubkeys",
+		primaryKey: "pubkey",
+		// The relay trusts pubkeys between rebuilds and compromise checks revoke
+		// them; whatever the live table says about a pubkey touched since the
+		// rebuild started wins over the rebuilt set
+		replay: []string{
+			`DELETE FROM trusted_pubkeys_next WHERE pubkey IN (
+				SELECT pubkey FROM trust_revocations WHERE revoked_at >= ?
+			)`,
+			`INSERT INTO trusted_pubkeys_next (pubkey, trusted_at)
+			SELECT pubkey, trusted_at FROM trusted_pubkeys WHERE trusted_at >= ?
+			ON CONFLICT(pubkey) DO UPDATE SET trusted_at = excluded.trusted_at`,
+		},
+	}
+	profileVelocityTable  = derivedTable{name: "profile_change_velocity", primaryKey: "pubkey"}
+	communitiesTable      = derivedTable{name: "communities", primaryKey: "id"}
+	communityEdgesTable   = derivedTable{name: "community_edges", primaryKey: "from_id, to_id"}
+	communityMembersTable = derivedTable{
+		name:       "community_members",
+		primaryKey: "community_id, pubkey",
+		indexes:    map[string]string{"idx_community_member_pubkey": "pubkey"},
+	}
+	followsTable = derivedTable{
+		name:       "follows",
+		primaryKey: "follower, followed",
+		indexes:    map[string]string{"idx_follows_followed": "followed"},
+	}
+	followsStateTable   = derivedTable{name: "follows_state", primaryKey: "follower"}
+	followerCountsTable = derivedTable{name: "follower_counts", primaryKey: "pubkey"}
+	mostMutedTable      = derivedTable{name: "cached_most_muted", primaryKey: "pubkey"}
+)
+
+type DerivedTableRefresh struct {
+	TableName   string
+	Duration    time.Duration
+	Rows        int64
+	RefreshedAt time.Time
+}
+
+// derivedTableRebuildTimeout bounds a whole rebuild, which loads every row of
+// the shadow tables in one transaction and outlasts the default query timeout
+const derivedTableRebuildTimeout = 30 * time.Minute
+
+// rebuildDerivedTables creates empty shadow copies of the given tables, lets fill
+// populate them, then swaps all of them in within a single short transaction.
+// The swap locks the live tables against writers and replays what they wrote
+// during the fill, so rows written by the relay meanwhile aren't lost.
+func (s *Storage) rebuildDerivedTables(ctx context.Context, tables []derivedTable, fill func(ctx context.Context, tx *sqlx.Tx) error) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, derivedTableRebuildTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+
+	for _, t := range tables {
+		shadow := t.name + shadowSuffix
+		if _, err := s.query(ctx, dbConn, "rebuildDerivedTables: drop "+shadow, fmt.Sprintf(`DROP TABLE IF EXISTS %s`, shadow)).exec(); err != nil {
+			return err
+		}
+		if _, err := s.query(ctx, dbConn, "rebuildDerivedTables: create "+shadow, fmt.Sprintf(`CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS)`, shadow, t.name)).exec(); err != nil {
+			return err
+		}
+	}
+
+	err := s.inTx(ctx, dbConn, "rebuildDerivedTables: fill", func(ctx context.Context, tx *sqlx.Tx) error {
+		if err := fill(ctx, tx); err != nil {
+			return err
+		}
+
+		// Build keys after the bulk load, it is cheaper than maintaining them per insert
+		for _, t := range tables {
+			shadow := t.name + shadowSuffix
+			if _, err := s.query(ctx, tx, "rebuildDerivedTables: key "+shadow, fmt.Sprintf(`ALTER TABLE %s ADD PRIMARY KEY (%s)`, shadow, t.primaryKey)).exec(); err != nil {
+				return err
+			}
+			for idx, cols := range t.indexes {
+				if _, err := s.query(ctx, tx, "rebuildDerivedTables: index "+shadow, fmt.Sprintf(`CREATE INDEX %s ON %s(%s)`, idx+shadowSuffix, shadow, cols)).exec(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	buildTime := time.Since(start)
+
+	rowCounts := make(map[string]int64, len(tables))
+	for _, t := range tables {
+		var count int64
+		if err := s.query(ctx, dbConn, "rebuildDerivedTables: count", fmt.Sprintf(`SELECT COUNT(*) FROM %s`, t.name+shadowSuffix)).scan(&count); err != nil {
+			return err
+		}
+		rowCounts[t.name] = count
+	}
+
+	err = s.inTx(ctx, dbConn, "rebuildDerivedTables: swap", func(ctx context.Context, tx *sqlx.Tx) error {
+		// Readers go on until the renames; writers wait and then land in the new table
+		for _, t := range tables {
+			if _, err := s.query(ctx, tx, "rebuildDerivedTables: lock "+t.name, fmt.Sprintf(`LOCK TABLE %s IN EXCLUSIVE MODE`, t.name)).exec(); err != nil {
+				return err
+			}
+			for _, stmt := range t.replay {
+				if _, err := s.query(ctx, tx, "rebuildDerivedTables: replay "+t.name, stmt, start.Unix()).exec(); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, t := range tables {
+			stmts := []string{
+				fmt.Sprintf(`ALTER TABLE %s RENAME TO %s_old`, t.name, t.name),
+				fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, t.name+shadowSuffix, t.name),
+				fmt.Sprintf(`DROP TABLE %s_old`, t.name),
+			}
+			for idx := range t.indexes {
+				stmts = append(stmts, fmt.Sprintf(`ALTER INDEX %s RENAME TO %s`, idx+shadowSuffix, idx))
+			}
+			for _, stmt := range stmts {
+				if _, err := s.query(ctx, tx, "rebuildDerivedTables: swap "+t.name, stmt).exec(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, t := range tables {
+		if err := s.recordDerivedTableRefresh(ctx, t.name, buildTime, rowCounts[t.name], now); err != nil {
+			log.Printf("Failed to record %s refresh: %v", t.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) recordDerivedTableRefresh(ctx context.Context, table string, duration time.Duration, rows, refreshedAt int64) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "recordDerivedTableRefresh", `
+		INSERT INTO derived_table_refreshes (table_name, duration_ms, row_count, refreshed_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(table_name) DO UPDATE SET
+			duration_ms = excluded.duration_ms,
+			row_count = excluded.row_count,
+			refreshed_at = excluded.refreshed_at
+	`, table, duration.Milliseconds(), rows, refreshedAt).exec()
+	return err
+}
+
+// GetDerivedTableRefreshes returns the last rebuild of each derived table
+func (s *Storage) GetDerivedTableRefreshes(ctx context.Context) ([]DerivedTableRefresh, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var refreshes []DerivedTableRefresh
+	err := s.query(ctx, dbConn, "GetDerivedTableRefreshes", `
+		SELECT table_name, duration_ms, row_count, refreshed_at
+		FROM derived_table_refreshes
+		ORDER BY table_name
+	`).each(func(rows *sql.Rows) error {
+		var r DerivedTableRefresh
+		var durationMs, refreshedAt int64
+		if err := rows.Scan(&r.TableName, &durationMs, &r.Rows, &refreshedAt); err != nil {
+			return err
+		}
+		r.Duration = time.Duration(durationMs) * time.Millisecond
+		r.RefreshedAt = time.Unix(refreshedAt, 0)
+		refreshes = append(refreshes, r)
+		return nil
+	})
+
+	return refreshes, err
+}
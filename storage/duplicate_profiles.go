@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Duplicate profile group bases
+const (
+	DuplicateBasisContent = "content" // byte-identical kind 0 content
+	DuplicateBasisPicture = "picture" // the same picture URL
+)
+
+// DuplicateProfileGroup is a set of pubkeys whose latest profiles are
+// identical, or share a picture
+type DuplicateProfileGroup struct {
+	Hash       string // content hash or picture URL hash, unique per group
+	Basis      string
+	Size       int
+	Trusted    int    // members in the trusted set when detected
+	Name       string // of one member, to recognize the group by
+	Picture    string
+	DetectedAt time.Time
+	Members    []string // only filled by GetDuplicateProfileGroup
+}
+
+func (s *Storage) InitDuplicateProfileSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS duplicate_profile_groups (
+		hash TEXT PRIMARY KEY,
+		basis TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		trusted INTEGER NOT NULL DEFAULT 0,
+		name TEXT NOT NULL DEFAULT '',
+		picture TEXT NOT NULL DEFAULT '',
+		detected_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_duplicate_profile_groups_size ON duplicate_profile_groups(size DESC);
+
+	CREATE TABLE IF NOT EXISTS duplicate_profile_members (
+		hash TEXT NOT NULL,
+		pubkey TEXT NOT NULL,
+		PRIMARY KEY (hash, pubkey)
+	);
+	CREATE INDEX IF NOT EXISTS idx_duplicate_profile_members_pubkey ON duplicate_profile_members(pubkey);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// SaveDuplicateProfileGroups replaces the stored groups with those of the latest detection run
+func (s *Storage) SaveDuplicateProfileGroups(ctx context.Context, groups []DuplicateProfileGroup) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	return s.inTx(ctx, dbConn, "SaveDuplicateProfileGroups", func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := s.query(ctx, tx, "SaveDuplicateProfileGroups: clear members", `DELETE FROM duplicate_profile_members`).exec(); err != nil {
+			return err
+		}
+		if _, err := s.query(ctx, tx, "SaveDuplicateProfileGroups: clear groups", `DELETE FROM duplicate_profile_groups`).exec(); err != nil {
+			return err
+		}
+
+		for _, g := range groups {
+			_, err := s.query(ctx, tx, "SaveDuplicateProfileGroups: group", `
+				INSERT INTO duplicate_profile_groups (hash, basis, size, trusted, name, picture, detected_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, g.Hash, g.Basis, g.Size, g.Trusted, g.Name, g.Picture, g.DetectedAt.Unix()).exec()
+			if err != nil {
+				return err
+			}
+			for _, pubkey := range g.Members {
+				_, err := s.query(ctx, tx, "SaveDuplicateProfileGroups: member", `
+					INSERT INTO duplicate_profile_members (hash, pubkey) VALUES (?, ?)
+					ON CONFLICT DO NOTHING
+				`, g.Hash, pubkey).exec()
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// GetDuplicateProfileGroups returns the largest groups first, without their members
+func (s *Storage) GetDuplicateProfileGroups(ctx context.Context, limit int) ([]DuplicateProfileGroup, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var groups []DuplicateProfileGroup
+	err := s.query(ctx, dbConn, "GetDuplicateProfileGroups", `
+		SELECT hash, basis, size, trusted, name, picture, detected_at
+		FROM duplicate_profile_groups
+		ORDER BY size DESC, hash
+		LIMIT ?
+	`, limit).each(func(rows *sql.Rows) error {
+		var g DuplicateProfileGroup
+		var detectedAt int64
+		if err := rows.Scan(&g.Hash, &g.Basis, &g.Size, &g.Trusted, &g.Name, &g.Picture, &detectedAt); err != nil {
+			return err
+		}
+		g.DetectedAt = time.Unix(detectedAt, 0)
+		groups = append(groups, g)
+		return nil
+	})
+	return groups, err
+}
+
+// GetDuplicateProfileGroup returns a group with its members, or nil if there is none with that hash
+func (s *Storage) GetDuplicateProfileGroup(ctx context.Context, hash string) (*DuplicateProfileGroup, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var g DuplicateProfileGroup
+	var detectedAt int64
+	err := s.query(ctx, dbConn, "GetDuplicateProfileGroup", `
+		SELECT hash, basis, size, trusted, name, picture, detected_at
+		FROM duplicate_profile_groups WHERE hash = ?
+	`, hash).scan(&g.Hash, &g.Basis, &g.Size, &g.Trusted, &g.Name, &g.Picture, &detectedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	g.DetectedAt = time.Unix(detectedAt, 0)
+
+	err = s.query(ctx, dbConn, "GetDuplicateProfileGroup: members", `
+		SELECT pubkey FROM duplicate_profile_members WHERE hash = ? ORDER BY pubkey
+	`, hash).each(func(rows *sql.Rows) error {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return err
+		}
+		g.Members = append(g.Members, pubkey)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// GetDuplicateProfileSizes returns, for every pubkey in a group of the given
+// basis, the size of its group
+func (s *Storage) GetDuplicateProfileSizes(ctx context.Context, basis string) (map[string]int, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	sizes := make(map[string]int)
+	err := s.query(ctx, dbConn, "GetDuplicateProfileSizes", `
+		SELECT m.pubkey, g.size
+		FROM duplicate_profile_members m
+		JOIN duplicate_profile_groups g ON g.hash = m.hash
+		WHERE g.basis = ?
+	`, basis).each(func(rows *sql.Rows) error {
+		var pubkey string
+		var size int
+		if err := rows.Scan(&pubkey, &size); err != nil {
+			return err
+		}
+		sizes[pubkey] = max(sizes[pubkey], size)
+		return nil
+	})
+	return sizes, err
+}
+
+// DeleteDuplicateProfileGroup drops a group once it has been dealt with
+func (s *Storage) DeleteDuplicateProfileGroup(ctx context.Context, hash string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	return s.inTx(ctx, dbConn, "DeleteDuplicateProfileGroup", func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := s.query(ctx, tx, "DeleteDuplicateProfileGroup: members", `DELETE FROM duplicate_profile_members WHERE hash = ?`, hash).exec(); err != nil {
+			return err
+		}
+		_, err := s.query(ctx, tx, "DeleteDuplicateProfileGroup: group", `DELETE FROM duplicate_profile_groups WHERE hash = ?`, hash).exec()
+		return err
+	})
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Storage failure classes reported to clients and counted in stats
+const (
+	FailureDiskFull    = "disk_full"
+	FailureLockTimeout = "lock_timeout"
+	FailureUnavailable = "unavailable"
+)
+
+// StorageError is a storage failure the client can do nothing about but retry later.
+// Its message is a NIP-01 OK reason with a retry-after hint.
+type StorageError struct {
+	Class      string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("error: storage unavailable (%s), retry after %ds",
+		strings.ReplaceAll(e.Class, "_", " "), int(e.RetryAfter.Seconds()))
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyError maps a backend error to a StorageError, or returns nil if the
+// error isn't a known transient storage failure
+func ClassifyError(err error) *StorageError {
+	if err == nil {
+		return nil
+	}
+
+	var serr *StorageError
+	if errors.As(err, &serr) {
+		return serr
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case errors.Is(err, syscall.ENOSPC),
+		strings.Contains(msg, "mdb_map_full"),
+		strings.Contains(msg, "no space left on device"),
+		strings.Contains(msg, "could not extend file"),
+		strings.Contains(msg, "53100"): // postgres disk_full
+		return &StorageError{Class: FailureDiskFull, RetryAfter: 5 * time.Minute, Err: err}
+
+	case errors.Is(err, context.DeadlineExceeded),
+		strings.Contains(msg, "lock timeout"),
+		strings.Contains(msg, "database is locked"),
+		strings.Contains(msg, "mdb_readers_full"),
+		strings.Contains(msg, "55p03"), // postgres lock_not_available
+		strings.Contains(msg, "40p01"): // postgres deadlock_detected
+		return &StorageError{Class: FailureLockTimeout, RetryAfter: 5 * time.Second, Err: err}
+
+	case errors.Is(err, sql.ErrConnDone),
+		strings.Contains(msg, "connection refused"),
+		strings.Contains(msg, "too many clients"),
+		strings.Contains(msg, "database system is shutting down"),
+		strings.Contains(msg, "database system is starting up"):
+		return &StorageError{Class: FailureUnavailable, RetryAfter: 30 * time.Second, Err: err}
+	}
+
+	return nil
+}
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -54,6 +56,12 @@ type RelaysDelta struct {
 	Removed    []string // relays removed
 }
 
+// SetArchiveGate makes SaveEvent archive replaced versions only while gate
+// returns true, so archiving can be paused at runtime
+func (s *Storage) SetArchiveGate(gate func() bool) {
+	s.archiveGate = gate
+}
+
 func (s *Storage) InitEventHistorySchema() error {
 	dbConn := s.getDBConn()
 	if dbConn == nil {
@@ -95,148 +103,172 @@ func (s *Storage) ArchiveEvent(ctx context.Context, evt *nostr.Event) error {
 	}
 
 	now := time.Now().Unix()
-	_, err = dbConn.ExecContext(ctx, s.rebind(`
+	_, err = s.query(ctx, dbConn, "ArchiveEvent", `
 		INSERT INTO event_history (id, pubkey, kind, created_at, content, tags, sig, archived_at)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO NOTHING
-	`), evt.ID, evt.PubKey, evt.Kind, evt.CreatedAt, evt.Content, string(tagsJSON), evt.Sig, now)
+	`, evt.ID, evt.PubKey, evt.Kind, evt.CreatedAt, evt.Content, string(tagsJSON), evt.Sig, now).exec()
 
 	return err
 }
 
 // GetEventHistory returns all historical versions of events for a pubkey and kind
 func (s *Storage) GetEventHistory(ctx context.Context, pubkey string, kind int, limit int) ([]EventVersion, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var versions []EventVersion
+	err := s.query(ctx, dbConn, "GetEventHistory", `
 		SELECT id, pubkey, kind, created_at, content, tags, archived_at
 		FROM event_history
 		WHERE pubkey = ? AND kind = ?
 		ORDER BY created_at DESC
 		LIMIT ?
-	`), pubkey, kind, limit)
+	`, pubkey, kind, limit).each(scanEventVersions(&versions))
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var versions []EventVersion
-	for rows.Next() {
-		var v EventVersion
-		var tagsJSON string
-		var archivedAt int64
-		if err := rows.Scan(&v.ID, &v.PubKey, &v.Kind, &v.CreatedAt, &v.Content, &tagsJSON, &archivedAt); err != nil {
-			return nil, err
-		}
-		json.Unmarshal([]byte(tagsJSON), &v.Tags)
-		v.ArchivedAt = time.Unix(archivedAt, 0)
-		versions = append(versions, v)
-	}
 
-	return versions, rows.Err()
+	return versions, nil
 }
 
 // GetAllEventHistory returns all historical events for a pubkey (all kinds)
 func (s *Storage) GetAllEventHistory(ctx context.Context, pubkey string, limit int) ([]EventVersion, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var versions []EventVersion
+	err := s.query(ctx, dbConn, "GetAllEventHistory", `
 		SELECT id, pubkey, kind, created_at, content, tags, archived_at
 		FROM event_history
 		WHERE pubkey = ?
 		ORDER BY created_at DESC
 		LIMIT ?
-	`), pubkey, limit)
+	`, pubkey, limit).each(scanEventVersions(&versions))
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var versions []EventVersion
-	for rows.Next() {
-		var v EventVersion
-		var tagsJSON string
-		var archivedAt int64
-		if err := rows.Scan(&v.ID, &v.PubKey, &v.Kind, &v.CreatedAt, &v.Content, &tagsJSON, &archivedAt); err != nil {
-			return nil, err
-		}
-		json.Unmarshal([]byte(tagsJSON), &v.Tags)
-		v.ArchivedAt = time.Unix(archivedAt, 0)
-		versions = append(versions, v)
+	return versions, nil
+}
+
+// GetEventAt returns the pubkey's event of the given kind as it stood at the given
+// time, taken from the live store or, if that's newer, from archived versions.
+// Returns nil if the pubkey had no such event yet.
+func (s *Storage) GetEventAt(ctx context.Context, pubkey string, kind int, at nostr.Timestamp) (*nostr.Event, error) {
+	events, err := s.QueryEvents(ctx, nostr.Filter{
+		Kinds:   []int{kind},
+		Authors: []string{pubkey},
+		Until:   &at,
+		Limit:   1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(events) > 0 {
+		// The live store only holds the latest version, so if it predates at nothing archived can be newer
+		return events[0], nil
+	}
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
 	}
 
-	return versions, rows.Err()
+	var evt nostr.Event
+	var tagsJSON string
+	err = s.query(ctx, dbConn, "GetEventAt", `
+		SELECT id, pubkey, kind, created_at, content, tags, sig
+		FROM event_history
+		WHERE pubkey = ? AND kind = ? AND created_at <= ?
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, pubkey, kind, at).scan(&evt.ID, &evt.PubKey, &evt.Kind, &evt.CreatedAt, &evt.Content, &tagsJSON, &evt.Sig)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(tagsJSON), &evt.Tags)
+
+	return &evt, nil
+}
+
+// GetArchivedEvent returns an archived version by ID and when it was archived, or nil if there is none
+func (s *Storage) GetArchivedEvent(ctx context.Context, id string) (*nostr.Event, time.Time, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, time.Time{}, nil
+	}
+
+	var evt nostr.Event
+	var tagsJSON string
+	var archivedAt int64
+	err := s.query(ctx, dbConn, "GetArchivedEvent", `
+		SELECT id, pubkey, kind, created_at, content, tags, sig, archived_at
+		FROM event_history
+		WHERE id = ?
+	`, id).scan(&evt.ID, &evt.PubKey, &evt.Kind, &evt.CreatedAt, &evt.Content, &tagsJSON, &evt.Sig, &archivedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	json.Unmarshal([]byte(tagsJSON), &evt.Tags)
+
+	return &evt, time.Unix(archivedAt, 0), nil
 }
 
 // GetRecentChanges returns recent archived events across all pubkeys
 func (s *Storage) GetRecentChanges(ctx context.Context, kind int, limit int) ([]EventVersion, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	var rows interface {
-		Next() bool
-		Scan(...interface{}) error
-		Close() error
-		Err() error
-	}
-	var err error
-
+	var q *sqlQuery
 	if kind > 0 {
-		rows, err = dbConn.QueryContext(ctx, s.rebind(`
+		q = s.query(ctx, dbConn, "GetRecentChanges", `
 			SELECT id, pubkey, kind, created_at, content, tags, archived_at
 			FROM event_history
 			WHERE kind = ?
 			ORDER BY archived_at DESC
 			LIMIT ?
-		`), kind, limit)
+		`, kind, limit)
 	} else {
-		rows, err = dbConn.QueryContext(ctx, s.rebind(`
+		q = s.query(ctx, dbConn, "GetRecentChanges", `
 			SELECT id, pubkey, kind, created_at, content, tags, archived_at
 			FROM event_history
 			ORDER BY archived_at DESC
 			LIMIT ?
-		`), limit)
-	}
-	if err != nil {
-		return nil, err
+		`, limit)
 	}
-	defer rows.Close()
 
 	var versions []EventVersion
-	for rows.Next() {
-		var v EventVersion
-		var tagsJSON string
-		var archivedAt int64
-		if err := rows.Scan(&v.ID, &v.PubKey, &v.Kind, &v.CreatedAt, &v.Content, &tagsJSON, &archivedAt); err != nil {
-			return nil, err
-		}
-		json.Unmarshal([]byte(tagsJSON), &v.Tags)
-		v.ArchivedAt = time.Unix(archivedAt, 0)
-		versions = append(versions, v)
+	if err := q.each(scanEventVersions(&versions)); err != nil {
+		return nil, err
 	}
 
-	return versions, rows.Err()
+	return versions, nil
 }
 
 // GetEventHistoryStats returns stats about archived events
 func (s *Storage) GetEventHistoryStats(ctx context.Context) (totalVersions int64, uniquePubkeys int64, err error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return 0, 0, nil
 	}
 
-	err = dbConn.QueryRowContext(ctx, `
+	err = s.query(ctx, dbConn, "GetEventHistoryStats", `
 		SELECT COUNT(*), COUNT(DISTINCT pubkey)
 		FROM event_history
-	`).Scan(&totalVersions, &uniquePubkeys)
+	`).scan(&totalVersions, &uniquePubkeys)
 
 	return
 }
@@ -382,30 +414,45 @@ func CalculateRelaysDelta(oldVer, newVer *EventVersion) *RelaysDelta {
 
 // GetPubkeysWithHistory returns pubkeys that have archived versions
 func (s *Storage) GetPubkeysWithHistory(ctx context.Context, limit int) ([]string, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var pubkeys []string
+	err := s.query(ctx, dbConn, "GetPubkeysWithHistory", `
 		SELECT DISTINCT pubkey
 		FROM event_history
 		ORDER BY (SELECT MAX(archived_at) FROM event_history eh WHERE eh.pubkey = event_history.pubkey) DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var pubkeys []string
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var pk string
 		if err := rows.Scan(&pk); err != nil {
-			return nil, err
+			return err
 		}
 		pubkeys = append(pubkeys, pk)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return pubkeys, rows.Err()
+	return pubkeys, nil
+}
+
+// scanEventVersions appends each row of an event_history query selecting id,
+// pubkey, kind, created_at, content, tags and archived_at to versions
+func scanEventVersions(versions *[]EventVersion) func(rows *sql.Rows) error {
+	return func(rows *sql.Rows) error {
+		var v EventVersion
+		var tagsJSON string
+		var archivedAt int64
+		if err := rows.Scan(&v.ID, &v.PubKey, &v.Kind, &v.CreatedAt, &v.Content, &tagsJSON, &archivedAt); err != nil {
+			return err
+		}
+		json.Unmarshal([]byte(tagsJSON), &v.Tags)
+		v.ArchivedAt = time.Unix(archivedAt, 0)
+		*versions = append(*versions, v)
+		return nil
+	}
 }
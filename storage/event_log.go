@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// EventLog appends every saved event to a daily JSONL segment
+// (events-YYYY-MM-DD.jsonl, UTC) so storage can be rebuilt up to a point in time
+// with `purplepages replay-log`. Finished segments are gzipped and segments
+// older than the retention are deleted.
+type EventLog struct {
+	mu            sync.Mutex
+	dir           string
+	retentionDays int
+	day           string
+	file          *os.File
+}
+
+// EventLogRecord is one line of a segment: the event and when we saved it
+type EventLogRecord struct {
+	At    int64        `json:"at"`
+	Event *nostr.Event `json:"event"`
+}
+
+const eventLogDayFormat = "2006-01-02"
+
+func OpenEventLog(dir string, retentionDays int) (*EventLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create event log directory: %w", err)
+	}
+
+	l := &EventLog{dir: dir, retentionDays: retentionDays}
+	if err := l.rotate(time.Now().UTC().Format(eventLogDayFormat)); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetEventLog makes SaveEvent append saved events to l
+func (s *Storage) SetEventLog(l *EventLog) {
+	s.eventLog = l
+}
+
+// Append writes evt to today's segment, rotating at midnight UTC
+func (l *EventLog) Append(evt *nostr.Event) error {
+	now := time.Now().UTC()
+	line, err := json.Marshal(EventLogRecord{At: now.Unix(), Event: evt})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if day := now.Format(eventLogDayFormat); day != l.day {
+		if err := l.rotate(day); err != nil {
+			return err
+		}
+	}
+
+	_, err = l.file.Write(line)
+	return err
+}
+
+// rotate closes the current segment and opens day's. Called with mu held.
+func (l *EventLog) rotate(day string) error {
+	if l.file != nil {
+		l.file.Sync()
+		l.file.Close()
+	}
+
+	file, err := os.OpenFile(filepath.Join(l.dir, "events-"+day+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open event log segment: %w", err)
+	}
+	l.file = file
+	l.day = day
+
+	go l.maintain(day)
+	return nil
+}
+
+// maintain gzips finished segments and deletes those past retention
+func (l *EventLog) maintain(today string) {
+	segments, err := listEventLogSegments(l.dir)
+	if err != nil {
+		log.Printf("Event log: failed to list segments: %v", err)
+		return
+	}
+
+	cutoff := ""
+	if l.retentionDays > 0 {
+		cutoff = time.Now().UTC().AddDate(0, 0, -l.retentionDays).Format(eventLogDayFormat)
+	}
+
+	for _, seg := range segments {
+		switch {
+		case cutoff != "" && seg.day < cutoff:
+			if err := os.Remove(seg.path); err != nil {
+				log.Printf("Event log: failed to delete %s: %v", seg.path, err)
+			}
+		case seg.day < today && !seg.compressed:
+			if err := compressSegment(seg.path); err != nil {
+				log.Printf("Event log: failed to compress %s: %v", seg.path, err)
+			}
+		}
+	}
+}
+
+// compressSegment replaces path with path.gz, writing to a temporary file first
+// so a .gz segment is always complete
+func compressSegment(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (l *EventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	l.file.Sync()
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+type eventLogSegment struct {
+	day        string
+	path       string
+	compressed bool
+}
+
+// listEventLogSegments returns one segment per day, oldest first. When a crash
+// left both forms of a day behind, the gzipped one is complete and wins.
+func listEventLogSegments(dir string) ([]eventLogSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byDay := make(map[string]eventLogSegment)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "events-") {
+			continue
+		}
+		var seg eventLogSegment
+		switch {
+		case strings.HasSuffix(name, ".jsonl.gz"):
+			seg = eventLogSegment{day: strings.TrimSuffix(strings.TrimPrefix(name, "events-"), ".jsonl.gz"), compressed: true}
+		case strings.HasSuffix(name, ".jsonl"):
+			seg = eventLogSegment{day: strings.TrimSuffix(strings.TrimPrefix(name, "events-"), ".jsonl")}
+		default:
+			continue
+		}
+		if _, err := time.Parse(eventLogDayFormat, seg.day); err != nil {
+			continue
+		}
+		seg.path = filepath.Join(dir, name)
+		if existing, ok := byDay[seg.day]; ok && existing.compressed {
+			continue
+		}
+		byDay[seg.day] = seg
+	}
+
+	segments := make([]eventLogSegment, 0, len(byDay))
+	for _, seg := range byDay {
+		segments = append(segments, seg)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].day < segments[j].day })
+	return segments, nil
+}
+
+// ReplayEventLog calls fn, oldest first, for every event in dir's segments saved
+// at or before until. Lines that don't parse, e.g. one cut off by a crash, are skipped.
+func ReplayEventLog(ctx context.Context, dir string, until time.Time, fn func(*nostr.Event) error) (segments int, skipped int, err error) {
+	all, err := listEventLogSegments(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	lastDay := until.UTC().Format(eventLogDayFormat)
+	for _, seg := range all {
+		if seg.day > lastDay {
+			break
+		}
+		n, err := replaySegment(ctx, seg, until.Unix(), fn)
+		skipped += n
+		if err != nil {
+			return segments, skipped, fmt.Errorf("%s: %w", seg.path, err)
+		}
+		segments++
+	}
+	return segments, skipped, nil
+}
+
+func replaySegment(ctx context.Context, seg eventLogSegment, until int64, fn func(*nostr.Event) error) (int, error) {
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if seg.compressed {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 10*1024*1024)
+
+	skipped := 0
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return skipped, err
+		}
+
+		var rec EventLogRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Event == nil {
+			skipped++
+			continue
+		}
+		if rec.At > until {
+			break
+		}
+		if err := fn(rec.Event); err != nil {
+			return skipped, err
+		}
+	}
+
+	return skipped, scanner.Err()
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Where a stored event came from
+const (
+	ProvenanceClient   = "client"   // published to us by a client
+	ProvenanceUpstream = "upstream" // fetched from an upstream relay
+)
+
+// Replacement status of a looked-up event
+const (
+	EventRegular    = "regular"    // not replaceable
+	EventCurrent    = "current"    // the newest version we hold
+	EventSuperseded = "superseded" // a newer version exists but this one is still stored
+	EventReplaced   = "replaced"   // only in the archive of replaced versions
+)
+
+// EventProvenance is how an event first reached us
+type EventProvenance struct {
+	Source     string
+	RelayURL   string // set for upstream events
+	ReceivedAt time.Time
+}
+
+// EventLookup is an event with where it came from and whether it is still the
+// version served for its pubkey and kind
+type EventLookup struct {
+	Event      *nostr.Event
+	Stored     bool      // false when only the archived version is left
+	ArchivedAt time.Time // set when Stored is false
+	Provenance *EventProvenance
+	Status     string
+	// Newest is the current version when Status is superseded or replaced
+	Newest           *nostr.Event
+	NewestProvenance *EventProvenance
+	// StoredVersions counts the versions of a replaceable event currently stored
+	StoredVersions int
+}
+
+func (s *Storage) InitEventProvenanceSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS event_provenance (
+		id TEXT PRIMARY KEY,
+		source TEXT NOT NULL,
+		relay_url TEXT NOT NULL DEFAULT '',
+		received_at INTEGER NOT NULL
+	);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// RecordProvenance notes where an event came from; only the first source is kept
+func (s *Storage) RecordProvenance(ctx context.Context, id, source, relayURL string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "RecordProvenance", `
+		INSERT INTO event_provenance (id, source, relay_url, received_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(id) DO NOTHING
+	`, id, source, relayURL, time.Now().Unix()).exec()
+	return err
+}
+
+// GetProvenance returns how the event reached us, or nil if it wasn't recorded
+func (s *Storage) GetProvenance(ctx context.Context, id string) (*EventProvenance, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var p EventProvenance
+	var receivedAt int64
+	err := s.query(ctx, dbConn, "GetProvenance", `
+		SELECT source, relay_url, received_at FROM event_provenance WHERE id = ?
+	`, id).scan(&p.Source, &p.RelayURL, &receivedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.ReceivedAt = time.Unix(receivedAt, 0)
+	return &p, nil
+}
+
+// isIDLookup reports whether a filter selects events by full ID, optionally
+// narrowed by kind or time, which queryByIDs answers from the ID index alone
+func isIDLookup(filter nostr.Filter) bool {
+	if len(filter.IDs) == 0 || len(filter.Authors) > 0 || len(filter.Tags) > 0 || filter.Search != "" {
+		return false
+	}
+	for _, id := range filter.IDs {
+		if !nostr.IsValid32ByteHex(id) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Storage) queryByIDs(ctx context.Context, filter nostr.Filter) ([]*nostr.Event, error) {
+	found, err := s.db.GetByIDs(ctx, filter.IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*nostr.Event, 0, len(found))
+	for _, evt := range found {
+		if len(filter.Kinds) > 0 && !slices.Contains(filter.Kinds, evt.Kind) {
+			continue
+		}
+		if (filter.Since != nil && evt.CreatedAt < *filter.Since) || (filter.Until != nil && evt.CreatedAt > *filter.Until) {
+			continue
+		}
+		events = append(events, evt)
+	}
+
+	// Same order and limit as the generic path
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].CreatedAt != events[j].CreatedAt {
+			return events[i].CreatedAt > events[j].CreatedAt
+		}
+		return events[i].ID < events[j].ID
+	})
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+// LookupEvent finds an event by ID in the store or the archive of replaced
+// versions, or returns nil if we never had it or it was deleted
+func (s *Storage) LookupEvent(ctx context.Context, id string) (*EventLookup, error) {
+	found, err := s.db.GetByIDs(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := &EventLookup{Status: EventRegular}
+	if len(found) > 0 {
+		lookup.Event = found[0]
+		lookup.Stored = true
+	} else {
+		evt, archivedAt, err := s.GetArchivedEvent(ctx, id)
+		if err != nil || evt == nil {
+			return nil, err
+		}
+		lookup.Event = evt
+		lookup.ArchivedAt = archivedAt
+	}
+
+	if lookup.Provenance, err = s.GetProvenance(ctx, id); err != nil {
+		return nil, err
+	}
+
+	evt := lookup.Event
+	versionsFilter := nostr.Filter{Kinds: []int{evt.Kind}, Authors: []string{evt.PubKey}}
+	switch {
+	case isReplaceableKind(evt.Kind):
+	case evt.Kind >= 30000 && evt.Kind < 40000:
+		versionsFilter.Tags = nostr.TagMap{"d": []string{evt.Tags.GetD()}}
+	default:
+		return lookup, nil
+	}
+
+	versions, err := s.QueryEvents(ctx, versionsFilter)
+	if err != nil {
+		return nil, err
+	}
+	lookup.StoredVersions = len(versions)
+	// Some backends only return the newest version of a replaceable event
+	if lookup.Stored && !slices.ContainsFunc(versions, func(v *nostr.Event) bool { return v.ID == evt.ID }) {
+		lookup.StoredVersions++
+	}
+
+	var newest *nostr.Event
+	for _, v := range versions {
+		if newest == nil || v.CreatedAt > newest.CreatedAt || (v.CreatedAt == newest.CreatedAt && v.ID < newest.ID) {
+			newest = v
+		}
+	}
+
+	switch {
+	case lookup.Stored && (newest == nil || newest.ID == evt.ID):
+		lookup.Status = EventCurrent
+	case lookup.Stored:
+		lookup.Status = EventSuperseded
+	default:
+		lookup.Status = EventReplaced
+	}
+	if newest != nil && newest.ID != evt.ID {
+		lookup.Newest = newest
+		if lookup.NewestProvenance, err = s.GetProvenance(ctx, newest.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return lookup, nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// FeatureFlag is a runtime override of a feature's configured state
+type FeatureFlag struct {
+	Name      string
+	Enabled   bool
+	UpdatedBy string
+	UpdatedAt time.Time
+}
+
+func (s *Storage) InitFeatureFlagSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS feature_flags (
+		name TEXT PRIMARY KEY,
+		enabled INTEGER NOT NULL,
+		updated_by TEXT NOT NULL DEFAULT '',
+		updated_at INTEGER NOT NULL
+	);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// GetFeatureFlags returns every runtime override
+func (s *Storage) GetFeatureFlags(ctx context.Context) ([]FeatureFlag, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var flags []FeatureFlag
+	err := s.query(ctx, dbConn, "GetFeatureFlags", `
+		SELECT name, enabled, updated_by, updated_at FROM feature_flags ORDER BY name
+	`).each(func(rows *sql.Rows) error {
+		var f FeatureFlag
+		var enabled int
+		var updatedAt int64
+		if err := rows.Scan(&f.Name, &enabled, &f.UpdatedBy, &updatedAt); err != nil {
+			return err
+		}
+		f.Enabled = enabled != 0
+		f.UpdatedAt = time.Unix(updatedAt, 0)
+		flags = append(flags, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// SetFeatureFlag overrides a feature's configured state in every process
+func (s *Storage) SetFeatureFlag(ctx context.Context, name string, enabled bool, actor string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	value := 0
+	if enabled {
+		value = 1
+	}
+	_, err := s.query(ctx, dbConn, "SetFeatureFlag", `
+		INSERT INTO feature_flags (name, enabled, updated_by, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			enabled = excluded.enabled,
+			updated_by = excluded.updated_by,
+			updated_at = excluded.updated_at
+	`, name, value, actor, time.Now().Unix()).exec()
+	return err
+}
+
+// ClearFeatureFlag drops a feature's override, returning it to its configured
+// state. It reports whether there was one.
+func (s *Storage) ClearFeatureFlag(ctx context.Context, name string) (bool, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return false, nil
+	}
+
+	result, err := s.query(ctx, dbConn, "ClearFeatureFlag", `DELETE FROM feature_flags WHERE name = ?`, name).exec()
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
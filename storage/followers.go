@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Follower is one follower of a pubkey, with what followers are ranked by
+type Follower struct {
+	Pubkey    string
+	Trusted   bool
+	Followers int64 // the follower's own follower count, as of the last refresh
+}
+
+// FollowsIndexReady reports whether follower pages can be served from the follows table
+func (s *Storage) FollowsIndexReady(ctx context.Context) bool {
+	return s.followsIndexReady(ctx)
+}
+
+// RefreshFollowerCounts rebuilds the follower count of every followed pubkey
+// from the follows table. Followers are ranked by these counts, so they only
+// need to be roughly current.
+func (s *Storage) RefreshFollowerCounts(ctx context.Context) error {
+	if s.getDBConn() == nil || !s.followsIndexReady(ctx) {
+		return nil
+	}
+
+	start := time.Now()
+	err := s.rebuildDerivedTables(ctx, []derivedTable{followerCountsTable}, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := s.query(ctx, tx, "RefreshFollowerCounts", `
+			INSERT INTO follower_counts_next (pubkey, followers)
+			SELECT followed, COUNT(*) FROM follows GROUP BY followed
+		`).exec()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Refreshed follower counts in %v", time.Since(start))
+	return nil
+}
+
+// GetFollowersPage returns up to limit followers of pubkey from the follows
+// table: trusted followers first, then the most followed, then by pubkey.
+// after is the last follower of the previous page, nil for the first page.
+func (s *Storage) GetFollowersPage(ctx context.Context, pubkey string, after *Follower, limit int) ([]Follower, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	args := []any{pubkey, limit}
+	position := ""
+	if after != nil {
+		trusted := 0
+		if after.Trusted {
+			trusted = 1
+		}
+		args = append(args, trusted, after.Followers, after.Pubkey)
+		position = `
+			WHERE trusted < $3
+				OR (trusted = $3 AND followers < $4)
+				OR (trusted = $3 AND followers = $4 AND follower > $5)`
+	}
+
+	var followers []Follower
+	err := s.query(ctx, dbConn, "GetFollowersPage", `
+		WITH ranked AS (
+			SELECT f.follower,
+				CASE WHEN t.pubkey IS NULL THEN 0 ELSE 1 END AS trusted,
+				COALESCE(c.followers, 0) AS followers
+			FROM follows f
+			LEFT JOIN trusted_pubkeys t ON t.pubkey = f.follower
+			LEFT JOIN follower_counts c ON c.pubkey = f.follower
+			WHERE f.followed = $1
+		)
+		SELECT follower, trusted, followers FROM ranked`+position+`
+		ORDER BY trusted DESC, followers DESC, follower
+		LIMIT $2
+	`, args...).each(func(rows *sql.Rows) error {
+		var f Follower
+		var trusted int
+		if err := rows.Scan(&f.Pubkey, &trusted, &f.Followers); err != nil {
+			return err
+		}
+		f.Trusted = trusted == 1
+		followers = append(followers, f)
+		return nil
+	})
+	return followers, err
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The follows table holds one row per p tag of each author's latest contact list,
+// so follower queries hit an index instead of unpacking every kind 3 tag blob.
+// SaveEvent keeps it current by applying the difference between an author's
+// previous and new contact list; follows_state records which version is applied.
+
+// followsReplayChunk is how many authors' contact lists are read at once when
+// replaying those saved during a rebuild
+const followsReplayChunk = 500
+
+// rebuildSet collects the authors whose contact lists were applied to the
+// live follows table while RebuildFollows filled its replacement, so they can
+// be applied again once the replacement is swapped in. Contact lists synced
+// late carry old created_at values, so a time window would miss them.
+type rebuildSet struct {
+	mu      sync.Mutex
+	changed map[string]bool // nil while no rebuild runs
+}
+
+func (r *rebuildSet) start() {
+	r.mu.Lock()
+	r.changed = make(map[string]bool)
+	r.mu.Unlock()
+}
+
+func (r *rebuildSet) record(pubkey string) {
+	r.mu.Lock()
+	if r.changed != nil {
+		r.changed[pubkey] = true
+	}
+	r.mu.Unlock()
+}
+
+// finish stops recording and returns the authors recorded
+func (r *rebuildSet) finish() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pubkeys := make([]string, 0, len(r.changed))
+	for pk := range r.changed {
+		pubkeys = append(pubkeys, pk)
+	}
+	r.changed = nil
+	return pubkeys
+}
+
+func contactListFollows(evt *nostr.Event) []string {
+	seen := make(map[string]bool)
+	follows := make([]string, 0, len(evt.Tags))
+	for _, tag := range evt.Tags {
+		if len(tag) >= 2 && tag[0] == "p" && tag[1] != "" && !seen[tag[1]] {
+			seen[tag[1]] = true
+			follows = append(follows, tag[1])
+		}
+	}
+	return follows
+}
+
+// updateFollows applies a contact list to the follows table unless a newer one
+// from the same author is already applied
+func (s *Storage) updateFollows(ctx context.Context, evt *nostr.Event) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+	s.followsRebuild.record(evt.PubKey)
+
+	return s.inTx(ctx, dbConn, "updateFollows", func(ctx context.Context, tx *sqlx.Tx) error {
+		var appliedAt int64
+		err := s.query(ctx, tx, "updateFollows: state", `SELECT created_at FROM follows_state WHERE follower = ? FOR UPDATE`, evt.PubKey).scan(&appliedAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil && appliedAt >= int64(evt.CreatedAt) {
+			return nil
+		}
+
+		var previous []string
+		err = s.query(ctx, tx, "updateFollows: previous", `SELECT followed FROM follows WHERE follower = ?`, evt.PubKey).each(func(rows *sql.Rows) error {
+			var pk string
+			if err := rows.Scan(&pk); err != nil {
+				return err
+			}
+			previous = append(previous, pk)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		current := contactListFollows(evt)
+		keep := make(map[string]bool, len(current))
+		for _, pk := range current {
+			keep[pk] = true
+		}
+
+		var removed []string
+		for _, pk := range previous {
+			if !keep[pk] {
+				removed = append(removed, pk)
+			}
+			delete(keep, pk)
+		}
+		added := make([]string, 0, len(keep))
+		for _, pk := range current {
+			if keep[pk] {
+				added = append(added, pk)
+			}
+		}
+
+		if len(removed) > 0 {
+			if _, err := s.query(ctx, tx, "updateFollows: remove", `DELETE FROM follows WHERE follower = $1 AND followed = ANY($2)`, evt.PubKey, pq.Array(removed)).exec(); err != nil {
+				return err
+			}
+		}
+		if len(added) > 0 {
+			if _, err := s.query(ctx, tx, "updateFollows: add", `
+				INSERT INTO follows (follower, followed) SELECT $1, unnest($2::text[])
+				ON CONFLICT DO NOTHING
+			`, evt.PubKey, pq.Array(added)).exec(); err != nil {
+				return err
+			}
+		}
+
+		_, err = s.query(ctx, tx, "updateFollows: applied", `
+			INSERT INTO follows_state (follower, created_at) VALUES (?, ?)
+			ON CONFLICT(follower) DO UPDATE SET created_at = excluded.created_at
+		`, evt.PubKey, int64(evt.CreatedAt)).exec()
+		return err
+	})
+}
+
+// followsIndexReady reports whether the follows table has been built; until then
+// follower queries fall back to unpacking contact list tags. Other processes
+// sharing the database see the build through derived_table_refreshes.
+func (s *Storage) followsIndexReady(ctx context.Context) bool {
+	if s.followsReady.Load() {
+		return true
+	}
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return false
+	}
+	var refreshedAt int64
+	err := s.query(ctx, dbConn, "followsIndexReady", `SELECT refreshed_at FROM derived_table_refreshes WHERE table_name = ?`, followsTable.name).scan(&refreshedAt)
+	if err != nil {
+		return false
+	}
+	s.followsReady.Store(true)
+	return true
+}
+
+// EnsureFollowsIndex builds the follows table from stored contact lists unless
+// it has been built before. Follower queries use it once it is ready.
+func (s *Storage) EnsureFollowsIndex(ctx context.Context) error {
+	if s.getDBConn() == nil || s.followsIndexReady(ctx) {
+		return nil
+	}
+	return s.RebuildFollows(ctx)
+}
+
+// RebuildFollows rebuilds the follows table from every author's latest contact
+// list, inserting each as it is read
+func (s *Storage) RebuildFollows(ctx context.Context) error {
+	start := time.Now()
+	s.followsRebuild.start()
+	defer s.followsRebuild.finish()
+
+	var lists int
+	err := s.rebuildDerivedTables(ctx, []derivedTable{followsTable, followsStateTable}, func(ctx context.Context, tx *sqlx.Tx) error {
+		// Lists come newest first, so an author's older copies, if any linger,
+		// are skipped; only their timestamps are kept in memory
+		applied := make(map[string]nostr.Timestamp)
+		var fillErr error
+		err := s.ScanEvents(ctx, nostr.Filter{Kinds: []int{3}}, func(evt *nostr.Event) {
+			if fillErr != nil {
+				return
+			}
+			appliedAt, ok := applied[evt.PubKey]
+			if ok && evt.CreatedAt <= appliedAt {
+				return
+			}
+			if ok {
+				fillErr = s.clearRebuiltFollows(ctx, tx, evt.PubKey)
+				if fillErr != nil {
+					return
+				}
+			}
+			if follows := contactListFollows(evt); len(follows) > 0 {
+				if _, fillErr = s.query(ctx, tx, "RebuildFollows", `
+					INSERT INTO follows_next (follower, followed) SELECT $1, unnest($2::text[])
+				`, evt.PubKey, pq.Array(follows)).exec(); fillErr != nil {
+					return
+				}
+			}
+			if _, fillErr = s.query(ctx, tx, "RebuildFollows: state", `
+				INSERT INTO follows_state_next (follower, created_at) VALUES (?, ?)
+			`, evt.PubKey, int64(evt.CreatedAt)).exec(); fillErr != nil {
+				return
+			}
+			applied[evt.PubKey] = evt.CreatedAt
+		})
+		lists = len(applied)
+		if fillErr != nil {
+			return fillErr
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Lists saved while the table was filled went to the one just replaced
+	changed := s.followsRebuild.finish()
+	for i := 0; i < len(changed); i += followsReplayChunk {
+		authors := changed[i:min(i+followsReplayChunk, len(changed))]
+		events, err := s.QueryEvents(ctx, nostr.Filter{Kinds: []int{3}, Authors: authors})
+		if err != nil {
+			return err
+		}
+		for _, evt := range events {
+			if err := s.updateFollows(ctx, evt); err != nil {
+				log.Printf("Failed to apply contact list %s to follows: %v", evt.ID, err)
+			}
+		}
+	}
+
+	s.followsReady.Store(true)
+	log.Printf("Built follows index from %d contact lists in %v, replaying %d saved meanwhile", lists, time.Since(start), len(changed))
+	return nil
+}
+
+// clearRebuiltFollows drops an author's rows from the follows tables being rebuilt
+func (s *Storage) clearRebuiltFollows(ctx context.Context, tx *sqlx.Tx, follower string) error {
+	if _, err := s.query(ctx, tx, "RebuildFollows: clear", `DELETE FROM follows_next WHERE follower = ?`, follower).exec(); err != nil {
+		return err
+	}
+	_, err := s.query(ctx, tx, "RebuildFollows: clear state", `DELETE FROM follows_state_next WHERE follower = ?`, follower).exec()
+	return err
+}
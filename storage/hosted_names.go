@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"time"
+)
+
+// hostedNamePattern is the NIP-05 local-part charset; "_" is the domain's root identifier
+var hostedNamePattern = regexp.MustCompile(`^[a-z0-9._-]{1,64}$`)
+
+// HostedName is a NIP-05 name issued under our own domain through /.well-known/nostr.json
+type HostedName struct {
+	Name      string
+	Pubkey    string
+	Relays    []string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// ValidHostedName reports whether name can be issued as a NIP-05 name
+func ValidHostedName(name string) bool {
+	return hostedNamePattern.MatchString(name)
+}
+
+func (s *Storage) InitHostedNamesSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS hosted_nip05_names (
+		name TEXT PRIMARY KEY,
+		pubkey TEXT NOT NULL,
+		relays TEXT NOT NULL DEFAULT '[]',
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL
+	);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// SetHostedName issues a name, or points an issued one at another pubkey
+func (s *Storage) SetHostedName(ctx context.Context, n HostedName) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	relays := n.Relays
+	if relays == nil {
+		relays = []string{}
+	}
+	relaysJSON, err := json.Marshal(relays)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.query(ctx, dbConn, "SetHostedName", `
+		INSERT INTO hosted_nip05_names (name, pubkey, relays, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			pubkey = excluded.pubkey,
+			relays = excluded.relays,
+			created_by = excluded.created_by,
+			created_at = excluded.created_at
+	`, n.Name, n.Pubkey, string(relaysJSON), n.CreatedBy, n.CreatedAt.Unix()).exec()
+	return err
+}
+
+// DeleteHostedName revokes a name, reporting whether it was issued
+func (s *Storage) DeleteHostedName(ctx context.Context, name string) (bool, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return false, nil
+	}
+
+	result, err := s.query(ctx, dbConn, "DeleteHostedName", `DELETE FROM hosted_nip05_names WHERE name = ?`, name).exec()
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetHostedNames returns every issued name, alphabetically
+func (s *Storage) GetHostedNames(ctx context.Context) ([]HostedName, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var names []HostedName
+	err := s.query(ctx, dbConn, "GetHostedNames", `
+		SELECT name, pubkey, relays, created_by, created_at
+		FROM hosted_nip05_names
+		ORDER BY name
+	`).each(func(rows *sql.Rows) error {
+		var n HostedName
+		var relaysJSON string
+		var createdAt int64
+		if err := rows.Scan(&n.Name, &n.Pubkey, &relaysJSON, &n.CreatedBy, &createdAt); err != nil {
+			return err
+		}
+		json.Unmarshal([]byte(relaysJSON), &n.Relays)
+		n.CreatedAt = time.Unix(createdAt, 0)
+		names = append(names, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Hydration outcome statuses, classified per hydration round across all relays
+const (
+	HydrationFound       = "found"
+	HydrationUnreachable = "unreachable"
+	HydrationDead        = "dead"
+)
+
+type HydrationOutcome struct {
+	Pubkey      string
+	Status      string
+	EmptyRounds int
+	LastRound   time.Time
+}
+
+// RecordHydrationRound classifies a pubkey after a hydration round. Pubkeys for
+// which no relay returned anything for deadAfter consecutive rounds become dead.
+func (s *Storage) RecordHydrationRound(ctx context.Context, pubkey string, found bool, deadAfter int) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	now := time.Now().Unix()
+
+	if found {
+		_, err := s.query(ctx, dbConn, "RecordHydrationRound", `
+			INSERT INTO hydration_outcomes (pubkey, status, empty_rounds, last_round)
+			VALUES (?, ?, 0, ?)
+			ON CONFLICT(pubkey) DO UPDATE SET
+				status = excluded.status,
+				empty_rounds = 0,
+				last_round = excluded.last_round
+		`, pubkey, HydrationFound, now).exec()
+		return err
+	}
+
+	_, err := s.query(ctx, dbConn, "RecordHydrationRound", `
+		INSERT INTO hydration_outcomes (pubkey, status, empty_rounds, last_round)
+		VALUES (?, CASE WHEN 1 >= ? THEN ? ELSE ? END, 1, ?)
+		ON CONFLICT(pubkey) DO UPDATE SET
+			status = CASE WHEN hydration_outcomes.empty_rounds + 1 >= ? THEN ? ELSE ? END,
+			empty_rounds = hydration_outcomes.empty_rounds + 1,
+			last_round = excluded.last_round
+	`, pubkey, deadAfter, HydrationDead, HydrationUnreachable, now, deadAfter, HydrationDead, HydrationUnreachable).exec()
+	return err
+}
+
+// GetDeadPubkeys returns pubkeys classified as dead whose last round was at or after since.
+// A zero since returns every dead pubkey.
+func (s *Storage) GetDeadPubkeys(ctx context.Context, since time.Time) (map[string]bool, error) {
+	result := make(map[string]bool)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return result, nil
+	}
+
+	var sinceUnix int64
+	if !since.IsZero() {
+		sinceUnix = since.Unix()
+	}
+
+	err := s.query(ctx, dbConn, "GetDeadPubkeys", `
+		SELECT pubkey FROM hydration_outcomes WHERE status = ? AND last_round >= ?
+	`, HydrationDead, sinceUnix).each(func(rows *sql.Rows) error {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return err
+		}
+		result[pubkey] = true
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// GetHydrationOutcomeCounts returns how many pubkeys are in each hydration status
+func (s *Storage) GetHydrationOutcomeCounts(ctx context.Context) (map[string]int64, error) {
+	result := make(map[string]int64)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return result, nil
+	}
+
+	err := s.query(ctx, dbConn, "GetHydrationOutcomeCounts", `SELECT status, COUNT(*) FROM hydration_outcomes GROUP BY status`).each(func(rows *sql.Rows) error {
+		var status string
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			return err
+		}
+		result[status] = count
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// GetDeadAccounts returns a sample of dead pubkeys, most failed rounds first
+func (s *Storage) GetDeadAccounts(ctx context.Context, limit int) ([]HydrationOutcome, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var outcomes []HydrationOutcome
+	err := s.query(ctx, dbConn, "GetDeadAccounts", `
+		SELECT pubkey, status, empty_rounds, last_round
+		FROM hydration_outcomes
+		WHERE status = ?
+		ORDER BY empty_rounds DESC, last_round DESC
+		LIMIT ?
+	`, HydrationDead, limit).each(func(rows *sql.Rows) error {
+		var o HydrationOutcome
+		var lastRound int64
+		if err := rows.Scan(&o.Pubkey, &o.Status, &o.EmptyRounds, &lastRound); err != nil {
+			return err
+		}
+		o.LastRound = time.Unix(lastRound, 0)
+		outcomes = append(outcomes, o)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return outcomes, nil
+}
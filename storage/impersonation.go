@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ImpersonationCandidate is a profile copying the name and picture of a much
+// more followed one
+type ImpersonationCandidate struct {
+	Pubkey          string
+	TargetPubkey    string // the profile being impersonated
+	Name            string
+	Picture         string
+	Followers       int
+	TargetFollowers int
+	DetectedAt      time.Time
+}
+
+// SaveImpersonationCandidates replaces the stored candidates with those of the latest detection run
+func (s *Storage) SaveImpersonationCandidates(ctx context.Context, candidates []ImpersonationCandidate) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	return s.inTx(ctx, dbConn, "SaveImpersonationCandidates", func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := s.query(ctx, tx, "SaveImpersonationCandidates: clear", `DELETE FROM impersonation_candidates`).exec(); err != nil {
+			return err
+		}
+
+		for _, c := range candidates {
+			_, err := s.query(ctx, tx, "SaveImpersonationCandidates: insert", `
+				INSERT INTO impersonation_candidates (pubkey, target_pubkey, name, picture, followers, target_followers, detected_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(pubkey) DO NOTHING
+			`, c.Pubkey, c.TargetPubkey, c.Name, c.Picture, c.Followers, c.TargetFollowers, c.DetectedAt.Unix()).exec()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetImpersonationCandidates returns candidates, those impersonating the most followed profiles first
+func (s *Storage) GetImpersonationCandidates(ctx context.Context, limit int) ([]ImpersonationCandidate, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var candidates []ImpersonationCandidate
+	err := s.query(ctx, dbConn, "GetImpersonationCandidates", `
+		SELECT pubkey, target_pubkey, name, picture, followers, target_followers, detected_at
+		FROM impersonation_candidates
+		ORDER BY target_followers DESC, pubkey
+		LIMIT ?
+	`, limit).each(func(rows *sql.Rows) error {
+		var c ImpersonationCandidate
+		var detectedAt int64
+		if err := rows.Scan(&c.Pubkey, &c.TargetPubkey, &c.Name, &c.Picture, &c.Followers, &c.TargetFollowers, &detectedAt); err != nil {
+			return err
+		}
+		c.DetectedAt = time.Unix(detectedAt, 0)
+		candidates = append(candidates, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
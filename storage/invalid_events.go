@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// InvalidEventCount counts events refused because their structure doesn't fit their kind
+type InvalidEventCount struct {
+	Kind     int
+	Reason   string
+	Attempts int64
+	LastSeen time.Time
+}
+
+func (s *Storage) InitInvalidEventSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS invalid_events (
+		kind INTEGER NOT NULL,
+		reason TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_seen INTEGER NOT NULL,
+		PRIMARY KEY (kind, reason)
+	);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// RecordInvalidEvent counts an event of kind refused for reason
+func (s *Storage) RecordInvalidEvent(ctx context.Context, kind int, reason string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "RecordInvalidEvent", `
+		INSERT INTO invalid_events (kind, reason, attempts, last_seen)
+		VALUES (?, ?, 1, ?)
+		ON CONFLICT(kind, reason) DO UPDATE SET
+			attempts = invalid_events.attempts + 1,
+			last_seen = excluded.last_seen
+	`, kind, reason, time.Now().Unix()).exec()
+
+	return err
+}
+
+// GetInvalidEventCounts returns refusals per kind and reason, most frequent first
+func (s *Storage) GetInvalidEventCounts(ctx context.Context, limit int) ([]InvalidEventCount, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var results []InvalidEventCount
+	err := s.query(ctx, dbConn, "GetInvalidEventCounts", `
+		SELECT kind, reason, attempts, last_seen
+		FROM invalid_events
+		ORDER BY attempts DESC
+		LIMIT ?
+	`, limit).each(func(rows *sql.Rows) error {
+		var c InvalidEventCount
+		var lastSeen int64
+		if err := rows.Scan(&c.Kind, &c.Reason, &c.Attempts, &lastSeen); err != nil {
+			return err
+		}
+		c.LastSeen = time.Unix(lastSeen, 0)
+		results = append(results, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
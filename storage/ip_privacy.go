@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// hashedIPPrefix marks an IP that was replaced by its salted hash
+const hashedIPPrefix = "anon-"
+
+// ipPrivacy replaces IPs with an HMAC under a salt that changes every UTC day.
+// Salts live in the database so the hash of an IP is the same across processes
+// and restarts within a day, and are deleted after two days, after which the
+// hashes can no longer be linked back to addresses.
+type ipPrivacy struct {
+	enabled bool
+	mu      sync.Mutex
+	salts   map[string][]byte // day -> salt
+}
+
+func (s *Storage) InitIPPrivacySchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS ip_salts (
+		day TEXT PRIMARY KEY,
+		salt TEXT NOT NULL
+	);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// EnableIPHashing makes every IP recorded from now on be stored as a daily salted
+// hash. Call it before the storage is used.
+func (s *Storage) EnableIPHashing() {
+	s.ipPrivacy.mu.Lock()
+	s.ipPrivacy.enabled = true
+	s.ipPrivacy.salts = make(map[string][]byte)
+	s.ipPrivacy.mu.Unlock()
+}
+
+// anonymizeIP returns the value to store for ip at t: ip itself unless hashing is enabled
+func (s *Storage) anonymizeIP(ctx context.Context, ip string, t time.Time) (string, error) {
+	if !s.ipPrivacy.enabled || ip == "" || strings.HasPrefix(ip, hashedIPPrefix) {
+		return ip, nil
+	}
+	salt, err := s.ipSalt(ctx, t.UTC().Format("2006-01-02"))
+	if err != nil {
+		return "", err
+	}
+	return hashIP(salt, ip), nil
+}
+
+func hashIP(salt []byte, ip string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(ip))
+	return hashedIPPrefix + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+func (s *Storage) ipSalt(ctx context.Context, day string) ([]byte, error) {
+	s.ipPrivacy.mu.Lock()
+	defer s.ipPrivacy.mu.Unlock()
+
+	if salt, ok := s.ipPrivacy.salts[day]; ok {
+		return salt, nil
+	}
+
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		salt := randomSalt()
+		s.ipPrivacy.salts[day] = salt
+		return salt, nil
+	}
+
+	// Whichever process gets here first for a day picks its salt
+	if _, err := s.query(ctx, dbConn, "ipSalt: insert", `
+		INSERT INTO ip_salts (day, salt) VALUES (?, ?)
+		ON CONFLICT(day) DO NOTHING
+	`, day, hex.EncodeToString(randomSalt())).exec(); err != nil {
+		return nil, err
+	}
+	var saltHex string
+	if err := s.query(ctx, dbConn, "ipSalt: select", `SELECT salt FROM ip_salts WHERE day = ?`, day).scan(&saltHex); err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, err
+	}
+
+	// Yesterday's salt is still needed for 24 hour lookups; older ones are destroyed
+	cutoff := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	if _, err := s.query(ctx, dbConn, "ipSalt: expire", `DELETE FROM ip_salts WHERE day < ?`, cutoff).exec(); err != nil {
+		return nil, err
+	}
+	for d := range s.ipPrivacy.salts {
+		if d < cutoff {
+			delete(s.ipPrivacy.salts, d)
+		}
+	}
+
+	s.ipPrivacy.salts[day] = salt
+	return salt, nil
+}
+
+func randomSalt() []byte {
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	return salt
+}
+
+// ScrubRawIPs replaces the raw IPs stored before hashing was enabled with hashes
+// under a one-off salt that is never stored. Hashes stay consistent across
+// tables, so historical unique counts and top-N lists are unchanged. It returns
+// the number of distinct addresses scrubbed.
+func (s *Storage) ScrubRawIPs(ctx context.Context) (int, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return 0, nil
+	}
+
+	// Scrubbing rewrites every table holding IPs, so it gets the deadline of a
+	// derived table rebuild rather than that of a single query
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, derivedTableRebuildTimeout)
+		defer cancel()
+	}
+
+	var scrubbed int
+	err := s.inTx(ctx, dbConn, "ScrubRawIPs", func(ctx context.Context, tx *sqlx.Tx) error {
+		var raw []string
+		err := s.query(ctx, tx, "ScrubRawIPs: select", `
+			SELECT ip FROM daily_requests WHERE ip NOT LIKE 'anon-%'
+			UNION SELECT ip FROM hourly_requests WHERE ip NOT LIKE 'anon-%'
+			UNION SELECT ip FROM scraper_candidates WHERE ip NOT LIKE 'anon-%'
+			UNION SELECT ip FROM oversize_attempts WHERE ip NOT LIKE 'anon-%'
+			UNION SELECT substr(reporter, 4) FROM abuse_reports WHERE reporter LIKE 'ip:%' AND reporter NOT LIKE 'ip:anon-%'
+		`).each(func(rows *sql.Rows) error {
+			var ip string
+			if err := rows.Scan(&ip); err != nil {
+				return err
+			}
+			raw = append(raw, ip)
+			return nil
+		})
+		if err != nil || len(raw) == 0 {
+			return err
+		}
+
+		salt := randomSalt()
+		hashed := make([]string, len(raw))
+		for i, ip := range raw {
+			hashed[i] = hashIP(salt, ip)
+		}
+
+		if _, err := s.query(ctx, tx, "ScrubRawIPs: mapping", `CREATE TEMP TABLE ip_scrub (raw TEXT PRIMARY KEY, hashed TEXT NOT NULL) ON COMMIT DROP`).exec(); err != nil {
+			return err
+		}
+		if _, err := s.query(ctx, tx, "ScrubRawIPs: mapping", `
+			INSERT INTO ip_scrub (raw, hashed) SELECT * FROM unnest($1::text[], $2::text[])
+		`, pq.Array(raw), pq.Array(hashed)).exec(); err != nil {
+			return err
+		}
+
+		for _, stmt := range []string{
+			`UPDATE daily_requests t SET ip = m.hashed FROM ip_scrub m WHERE t.ip = m.raw`,
+			`UPDATE hourly_requests t SET ip = m.hashed FROM ip_scrub m WHERE t.ip = m.raw`,
+			`UPDATE scraper_candidates t SET ip = m.hashed FROM ip_scrub m WHERE t.ip = m.raw`,
+			`UPDATE oversize_attempts t SET ip = m.hashed FROM ip_scrub m WHERE t.ip = m.raw`,
+			`UPDATE abuse_reports t SET reporter = 'ip:' || m.hashed FROM ip_scrub m WHERE t.reporter = 'ip:' || m.raw`,
+		} {
+			if _, err := s.query(ctx, tx, "ScrubRawIPs: update", stmt).exec(); err != nil {
+				return err
+			}
+		}
+
+		scrubbed = len(raw)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return scrubbed, nil
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// JobStatus is the last recorded state of a background job. Jobs record their
+// runs here so any process sharing the database can report on and trigger them.
+type JobStatus struct {
+	Name           string
+	Process        string // the process running the job: relay or analytics
+	Running        bool
+	LastStartedAt  time.Time
+	LastSuccessAt  time.Time
+	LastError      string
+	LastErrorAt    time.Time
+	LastDuration   time.Duration
+	RunRequestedAt time.Time
+}
+
+// RunRequested reports whether a run was requested after the last one started
+func (j JobStatus) RunRequested() bool {
+	return j.RunRequestedAt.After(j.LastStartedAt)
+}
+
+func (s *Storage) InitJobSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS job_status (
+		name TEXT PRIMARY KEY,
+		process TEXT NOT NULL,
+		running INTEGER NOT NULL DEFAULT 0,
+		started_at INTEGER NOT NULL DEFAULT 0,
+		last_success_at INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		last_error_at INTEGER NOT NULL DEFAULT 0,
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		run_requested_at INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS relay_load (
+		id INTEGER PRIMARY KEY,
+		connections INTEGER NOT NULL DEFAULT 0,
+		query_latency_us INTEGER NOT NULL DEFAULT 0,
+		sampled_at INTEGER NOT NULL DEFAULT 0
+	);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// RegisterJob records that process runs the job. A run left marked as running
+// by a previous process is cleared.
+func (s *Storage) RegisterJob(ctx context.Context, name, process string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "RegisterJob", `
+		INSERT INTO job_status (name, process) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET process = excluded.process, running = 0
+	`, name, process).exec()
+	return err
+}
+
+func (s *Storage) MarkJobStarted(ctx context.Context, name string, startedAt time.Time) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "MarkJobStarted", `
+		UPDATE job_status SET running = 1, started_at = ? WHERE name = ?
+	`, startedAt.Unix(), name).exec()
+	return err
+}
+
+// MarkJobFinished records the outcome of a run; runErr is nil on success
+func (s *Storage) MarkJobFinished(ctx context.Context, name string, duration time.Duration, runErr error) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	var err error
+	if runErr == nil {
+		_, err = s.query(ctx, dbConn, "MarkJobFinished", `
+			UPDATE job_status SET running = 0, duration_ms = ?, last_success_at = ? WHERE name = ?
+		`, duration.Milliseconds(), now, name).exec()
+	} else {
+		_, err = s.query(ctx, dbConn, "MarkJobFinished", `
+			UPDATE job_status SET running = 0, duration_ms = ?, last_error = ?, last_error_at = ? WHERE name = ?
+		`, duration.Milliseconds(), runErr.Error(), now, name).exec()
+	}
+	return err
+}
+
+// RequestJobRun asks the process running the job to run it as soon as it can.
+// It returns false when no such job is registered.
+func (s *Storage) RequestJobRun(ctx context.Context, name string) (bool, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return false, nil
+	}
+
+	result, err := s.query(ctx, dbConn, "RequestJobRun", `
+		UPDATE job_status SET run_requested_at = ? WHERE name = ?
+	`, time.Now().Unix(), name).exec()
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Storage) GetJobStatus(ctx context.Context, name string) (*JobStatus, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	row := s.query(ctx, dbConn, "GetJobStatus", `
+		SELECT name, process, running, started_at, last_success_at, last_error, last_error_at, duration_ms, run_requested_at
+		FROM job_status WHERE name = ?
+	`, name)
+	status, err := scanJobStatus(rowFunc(row.scan))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return status, err
+}
+
+func (s *Storage) GetJobStatuses(ctx context.Context) ([]JobStatus, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var statuses []JobStatus
+	err := s.query(ctx, dbConn, "GetJobStatuses", `
+		SELECT name, process, running, started_at, last_success_at, last_error, last_error_at, duration_ms, run_requested_at
+		FROM job_status ORDER BY process, name
+	`).each(func(rows *sql.Rows) error {
+		status, err := scanJobStatus(rows)
+		if err != nil {
+			return err
+		}
+		statuses = append(statuses, *status)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+func scanJobStatus(row interface{ Scan(...any) error }) (*JobStatus, error) {
+	var j JobStatus
+	var running int
+	var startedAt, successAt, errorAt, durationMs, requestedAt int64
+	if err := row.Scan(&j.Name, &j.Process, &running, &startedAt, &successAt, &j.LastError, &errorAt, &durationMs, &requestedAt); err != nil {
+		return nil, err
+	}
+	j.Running = running == 1
+	j.LastStartedAt = unixOrZero(startedAt)
+	j.LastSuccessAt = unixOrZero(successAt)
+	j.LastErrorAt = unixOrZero(errorAt)
+	j.LastDuration = time.Duration(durationMs) * time.Millisecond
+	j.RunRequestedAt = unixOrZero(requestedAt)
+	return &j, nil
+}
+
+func unixOrZero(ts int64) time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+	return time.Unix(ts, 0)
+}
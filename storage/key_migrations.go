@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Key migrations are linked once both sides have spoken: the old key names its
+// successor and the new key claims the old one
+const (
+	KindMigrationAttestation = 1776 // signed by the old key, p-tags the new key
+	KindKeyMigration         = 1777 // signed by the new key, p-tags the old key
+)
+
+// maxMigrationHops bounds how far a chain of migrations is followed
+const maxMigrationHops = 8
+
+type KeyMigration struct {
+	OldPubkey  string
+	NewPubkey  string
+	MigratedAt time.Time
+}
+
+func (s *Storage) InitKeyMigrationSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS key_migrations (
+		old_pubkey TEXT PRIMARY KEY,
+		new_pubkey TEXT NOT NULL,
+		attestation_id TEXT NOT NULL,
+		migration_id TEXT NOT NULL,
+		migrated_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_key_migrations_new ON key_migrations(new_pubkey);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// LinkKeyMigration checks a freshly saved attestation or migration event for its
+// counterpart and records the link when both exist. It returns the linked
+// migration, or nil when the event doesn't complete one.
+func (s *Storage) LinkKeyMigration(ctx context.Context, evt *nostr.Event) (*KeyMigration, error) {
+	if evt.Kind != KindMigrationAttestation && evt.Kind != KindKeyMigration {
+		return nil, nil
+	}
+
+	for _, tag := range evt.Tags {
+		if len(tag) < 2 || tag[0] != "p" || !nostr.IsValid32ByteHex(tag[1]) || tag[1] == evt.PubKey {
+			continue
+		}
+
+		oldPubkey, newPubkey := evt.PubKey, tag[1]
+		counterpart := nostr.Filter{Kinds: []int{KindKeyMigration}, Authors: []string{newPubkey}, Tags: nostr.TagMap{"p": []string{oldPubkey}}, Limit: 1}
+		if evt.Kind == KindKeyMigration {
+			oldPubkey, newPubkey = tag[1], evt.PubKey
+			counterpart = nostr.Filter{Kinds: []int{KindMigrationAttestation}, Authors: []string{oldPubkey}, Tags: nostr.TagMap{"p": []string{newPubkey}}, Limit: 1}
+		}
+
+		events, err := s.QueryEvents(ctx, counterpart)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			continue
+		}
+
+		attestation, migration := evt, events[0]
+		if evt.Kind == KindKeyMigration {
+			attestation, migration = events[0], evt
+		}
+
+		m := &KeyMigration{OldPubkey: oldPubkey, NewPubkey: newPubkey, MigratedAt: migration.CreatedAt.Time()}
+		if err := s.saveKeyMigration(ctx, m, attestation.ID, migration.ID); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	return nil, nil
+}
+
+func (s *Storage) saveKeyMigration(ctx context.Context, m *KeyMigration, attestationID, migrationID string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	// A later migration of the same old key wins
+	_, err := s.query(ctx, dbConn, "saveKeyMigration", `
+		INSERT INTO key_migrations (old_pubkey, new_pubkey, attestation_id, migration_id, migrated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(old_pubkey) DO UPDATE SET
+			new_pubkey = excluded.new_pubkey,
+			attestation_id = excluded.attestation_id,
+			migration_id = excluded.migration_id,
+			migrated_at = excluded.migrated_at
+		WHERE excluded.migrated_at >= key_migrations.migrated_at
+	`, m.OldPubkey, m.NewPubkey, attestationID, migrationID, m.MigratedAt.Unix()).exec()
+
+	return err
+}
+
+// ResolveKeyMigration follows a pubkey's migrations to its current key. It
+// returns nil when the pubkey never migrated.
+func (s *Storage) ResolveKeyMigration(ctx context.Context, pubkey string) (*KeyMigration, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var result *KeyMigration
+	seen := map[string]bool{pubkey: true}
+	current := pubkey
+	for i := 0; i < maxMigrationHops; i++ {
+		var next string
+		var migratedAt int64
+		err := s.query(ctx, dbConn, "ResolveKeyMigration", `
+			SELECT new_pubkey, migrated_at FROM key_migrations WHERE old_pubkey = ?
+		`, current).scan(&next, &migratedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if seen[next] {
+			break
+		}
+		seen[next] = true
+		current = next
+		result = &KeyMigration{OldPubkey: pubkey, NewPubkey: next, MigratedAt: time.Unix(migratedAt, 0)}
+	}
+
+	return result, nil
+}
+
+// GetKeyMigrations maps every migrated pubkey to the key it ended up at
+func (s *Storage) GetKeyMigrations(ctx context.Context) (map[string]string, error) {
+	result := make(map[string]string)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return result, nil
+	}
+
+	direct := make(map[string]string)
+	err := s.query(ctx, dbConn, "GetKeyMigrations", `SELECT old_pubkey, new_pubkey FROM key_migrations`).each(func(rows *sql.Rows) error {
+		var oldPubkey, newPubkey string
+		if err := rows.Scan(&oldPubkey, &newPubkey); err != nil {
+			return err
+		}
+		direct[oldPubkey] = newPubkey
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for oldPubkey, next := range direct {
+		seen := map[string]bool{oldPubkey: true}
+		for i := 0; i < maxMigrationHops; i++ {
+			further, ok := direct[next]
+			if !ok || seen[further] {
+				break
+			}
+			seen[next] = true
+			next = further
+		}
+		result[oldPubkey] = next
+	}
+
+	return result, nil
+}
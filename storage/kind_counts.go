@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// KindCountPoint is how many events of a kind were stored at the end of a
+// day, week or month
+type KindCountPoint struct {
+	Date  string `json:"date"` // first day of the period, YYYY-MM-DD
+	Count int64  `json:"count"`
+}
+
+// KindCountSeries is one kind's stored event count over time, oldest first
+type KindCountSeries struct {
+	Kind   int              `json:"kind"`
+	Name   string           `json:"name"` // filled in by handlers from the kinds registry
+	Points []KindCountPoint `json:"points"`
+}
+
+// KindCountGranularities are the periods GetKindCountSeries can group samples by
+var KindCountGranularities = []string{"day", "week", "month"}
+
+func (s *Storage) InitKindCountsSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS daily_kind_counts (
+		date TEXT NOT NULL,
+		kind INTEGER NOT NULL,
+		event_count BIGINT NOT NULL,
+		PRIMARY KEY (date, kind)
+	);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// RecordDailyKindCounts samples the stored event count of every kind for today.
+// Samples are a few rows a day and are kept for good, so growth can be charted
+// over the relay's lifetime.
+func (s *Storage) RecordDailyKindCounts(ctx context.Context) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return fmt.Errorf("database connection not available")
+	}
+
+	counts, err := s.GetEventCountsByKind(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count events by kind: %w", err)
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	return s.inTx(ctx, dbConn, "RecordDailyKindCounts", func(ctx context.Context, tx *sqlx.Tx) error {
+		for kind, count := range counts {
+			_, err := s.query(ctx, tx, "RecordDailyKindCounts", `
+				INSERT INTO daily_kind_counts (date, kind, event_count)
+				VALUES (?, ?, ?)
+				ON CONFLICT(date, kind) DO UPDATE SET event_count = excluded.event_count
+			`, today, kind, count).exec()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetKindCountSeries returns the stored event count of each kind per day, week
+// or month since since, taking the last sample of each period. kinds limits the
+// series returned; empty means every kind sampled.
+func (s *Storage) GetKindCountSeries(ctx context.Context, granularity string, since time.Time, kinds []int) ([]KindCountSeries, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	valid := false
+	for _, g := range KindCountGranularities {
+		valid = valid || g == granularity
+	}
+	if !valid {
+		return nil, fmt.Errorf("unknown granularity %q", granularity)
+	}
+
+	kindFilter := ""
+	args := []any{granularity, since.UTC().Format("2006-01-02")}
+	if len(kinds) > 0 {
+		kindFilter = "AND kind = ANY(?)"
+		args = append(args, pq.Array(kinds))
+	}
+
+	byKind := make(map[int]*KindCountSeries)
+	err := s.query(ctx, dbConn, "GetKindCountSeries", `
+		SELECT DISTINCT ON (kind, period)
+			kind, to_char(date_trunc(?, date::date), 'YYYY-MM-DD') AS period, event_count
+		FROM daily_kind_counts
+		WHERE date >= ? `+kindFilter+`
+		ORDER BY kind, period, date DESC
+	`, args...).each(func(rows *sql.Rows) error {
+		var kind int
+		var point KindCountPoint
+		if err := rows.Scan(&kind, &point.Date, &point.Count); err != nil {
+			return err
+		}
+		series, ok := byKind[kind]
+		if !ok {
+			series = &KindCountSeries{Kind: kind}
+			byKind[kind] = series
+		}
+		series.Points = append(series.Points, point)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]KindCountSeries, 0, len(byKind))
+	for _, series := range byKind {
+		result = append(result, *series)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Kind < result[j].Kind })
+	return result, nil
+}
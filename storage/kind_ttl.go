@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// kindTTLBatch is how many expired events are read per query while pruning
+const kindTTLBatch = 1000
+
+// PruneExpiredKind deletes the events of kind created before olderThan, i.e. not
+// updated by their author since, except those by authors in keep. It returns
+// how many events were deleted.
+func (s *Storage) PruneExpiredKind(ctx context.Context, kind int, olderThan time.Time, keep map[string]bool) (int64, error) {
+	var deleted int64
+	until := nostr.Timestamp(olderThan.Unix())
+	for {
+		events, err := s.QueryEvents(ctx, nostr.Filter{Kinds: []int{kind}, Until: &until, Limit: kindTTLBatch})
+		if err != nil {
+			return deleted, err
+		}
+
+		oldest := until
+		for _, evt := range events {
+			if evt.CreatedAt < oldest {
+				oldest = evt.CreatedAt
+			}
+			if keep[evt.PubKey] {
+				continue
+			}
+			if err := s.DeleteEvent(ctx, evt); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+
+		if len(events) < kindTTLBatch || ctx.Err() != nil {
+			return deleted, ctx.Err()
+		}
+		// Kept events stay in place, so page past them; step back a second when a
+		// whole batch shares one timestamp
+		if oldest == until {
+			oldest--
+		}
+		until = oldest
+	}
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The lightning_addresses table indexes the lud16 and lud06 fields of every
+// author's latest profile, so an address can be traced back to the pubkeys
+// advertising it. SaveEvent keeps it current; EnsureLightningAddressIndex
+// fills it once.
+
+// lightningAddressTable names the index in derived_table_refreshes once it is built
+const lightningAddressTable = "lightning_addresses"
+
+func (s *Storage) InitLightningAddressSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS lightning_addresses (
+		pubkey TEXT PRIMARY KEY,
+		created_at INTEGER NOT NULL,
+		lud16 TEXT NOT NULL DEFAULT '',
+		lud06 TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_lightning_addresses_lud16 ON lightning_addresses(lud16);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// updateLightningAddress indexes the payment fields of a profile unless a
+// newer one from the same author is already indexed. Profiles without them
+// are indexed too, so removing an address from a profile drops it.
+func (s *Storage) updateLightningAddress(ctx context.Context, evt *nostr.Event) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	// Profiles that aren't JSON objects are refused before they get here, but
+	// the fields themselves may be of any type
+	var metadata map[string]any
+	if err := json.Unmarshal([]byte(evt.Content), &metadata); err != nil {
+		return nil
+	}
+	lud16, _ := metadata["lud16"].(string)
+	lud06, _ := metadata["lud06"].(string)
+
+	_, err := s.query(ctx, dbConn, "updateLightningAddress", `
+		INSERT INTO lightning_addresses (pubkey, created_at, lud16, lud06)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(pubkey) DO UPDATE SET
+			created_at = excluded.created_at,
+			lud16 = excluded.lud16,
+			lud06 = excluded.lud06
+		WHERE excluded.created_at > lightning_addresses.created_at
+	`, evt.PubKey, int64(evt.CreatedAt), NormalizeLightningAddress(lud16), strings.TrimSpace(lud06)).exec()
+	return err
+}
+
+// NormalizeLightningAddress lowercases a name@domain lightning address, as
+// both halves are matched case-insensitively, and returns "" for anything else
+func NormalizeLightningAddress(address string) string {
+	address = strings.ToLower(strings.TrimSpace(address))
+	name, domain, ok := strings.Cut(address, "@")
+	if !ok || name == "" || domain == "" || strings.ContainsAny(domain, "@/ ") {
+		return ""
+	}
+	return address
+}
+
+// EnsureLightningAddressIndex indexes every stored profile unless the index
+// has been built before
+func (s *Storage) EnsureLightningAddressIndex(ctx context.Context) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+	var refreshedAt int64
+	err := s.query(ctx, dbConn, "EnsureLightningAddressIndex: check", `SELECT refreshed_at FROM derived_table_refreshes WHERE table_name = ?`, lightningAddressTable).scan(&refreshedAt)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	start := time.Now()
+	var indexed int64
+	err = s.ScanEvents(ctx, nostr.Filter{Kinds: []int{0}}, func(evt *nostr.Event) {
+		if err := s.updateLightningAddress(ctx, evt); err != nil {
+			log.Printf("Failed to index lightning address of profile %s: %v", evt.ID, err)
+			return
+		}
+		indexed++
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.recordDerivedTableRefresh(ctx, lightningAddressTable, time.Since(start), indexed, time.Now().Unix()); err != nil {
+		return err
+	}
+	log.Printf("Built lightning address index from %d profiles in %v", indexed, time.Since(start))
+	return nil
+}
+
+// GetPubkeysByLightningAddress returns the pubkeys whose latest profile
+// advertises the given lud16 address, up to limit
+func (s *Storage) GetPubkeysByLightningAddress(ctx context.Context, address string, limit int) ([]string, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+	address = NormalizeLightningAddress(address)
+	if address == "" {
+		return nil, nil
+	}
+
+	var pubkeys []string
+	err := s.query(ctx, dbConn, "GetPubkeysByLightningAddress", `
+		SELECT pubkey FROM lightning_addresses WHERE lud16 = ? ORDER BY created_at DESC LIMIT ?
+	`, address, limit).each(func(rows *sql.Rows) error {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return err
+		}
+		pubkeys = append(pubkeys, pubkey)
+		return nil
+	})
+	return pubkeys, err
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// MuteGraphOverview summarizes how kind 10000 mute lists relate to each other
+// and to contact lists
+type MuteGraphOverview struct {
+	Mutes          int64 // pubkeys named across all mute lists
+	MutualPairs    int64 // pairs of pubkeys muting each other
+	FollowedMutes  int64 // mutes of a pubkey the muter also follows
+	MutedFollowers int64 // mutes of a pubkey that follows the muter
+}
+
+// GetMuteGraphOverview walks every mute list, then every contact list, to
+// relate mutes to follows
+func (s *Storage) GetMuteGraphOverview(ctx context.Context) (*MuteGraphOverview, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	overview := &MuteGraphOverview{}
+	mutes := make(map[string]map[string]bool)
+	err := s.eachAuthorTagList(ctx, dbConn, "GetMuteGraphOverview: mute lists", `SELECT pubkey, tags FROM event WHERE kind = 10000`, func(pubkey string, tags [][]string) {
+		muted := mutes[pubkey]
+		if muted == nil {
+			muted = make(map[string]bool)
+			mutes[pubkey] = muted
+		}
+		for _, tag := range tags {
+			if len(tag) >= 2 && tag[0] == "p" && tag[1] != pubkey && !muted[tag[1]] {
+				muted[tag[1]] = true
+				overview.Mutes++
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for muter, muted := range mutes {
+		for pk := range muted {
+			// Each pair is seen from both sides
+			if muter < pk && mutes[pk][muter] {
+				overview.MutualPairs++
+			}
+		}
+	}
+
+	err = s.eachAuthorTagList(ctx, dbConn, "GetMuteGraphOverview: contact lists", `SELECT pubkey, tags FROM event WHERE kind = 3`, func(pubkey string, tags [][]string) {
+		muted := mutes[pubkey]
+		seen := make(map[string]bool)
+		for _, tag := range tags {
+			if len(tag) < 2 || tag[0] != "p" || seen[tag[1]] {
+				continue
+			}
+			seen[tag[1]] = true
+			if muted[tag[1]] {
+				overview.FollowedMutes++
+			}
+			if mutes[tag[1]][pubkey] {
+				overview.MutedFollowers++
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return overview, nil
+}
+
+// eachAuthorTagList is eachTagList for queries selecting a pubkey and a tags column
+func (s *Storage) eachAuthorTagList(ctx context.Context, db execer, name, query string, fn func(pubkey string, tags [][]string)) error {
+	return s.query(ctx, db, name, query).each(func(rows *sql.Rows) error {
+		var pubkey, tagsJSON string
+		if err := rows.Scan(&pubkey, &tagsJSON); err != nil {
+			return err
+		}
+		var tags [][]string
+		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
+			return nil
+		}
+		fn(pubkey, tags)
+		return nil
+	})
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// negentropySyncRetention is how long reconciliation results are kept
+const negentropySyncRetention = 90 * 24 * time.Hour
+
+// NegentropySyncRun is one scheduled NIP-77 reconciliation of a kind with a
+// peer relay: how much work finding the difference took and what was moved
+type NegentropySyncRun struct {
+	RelayURL  string
+	Kind      int
+	Direction string // down, up or both
+	StartedAt time.Time
+	Duration  time.Duration
+	Rounds    int64 // NEG-MSG round trips
+	Ranges    int64 // fingerprint and ID list ranges exchanged
+	Have      int64 // IDs only we hold
+	Need      int64 // IDs only the peer holds
+	Sent      int64 // events the peer accepted from us
+	Received  int64 // new events saved from the peer
+	Error     string
+}
+
+func (s *Storage) InitNegentropySyncSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	// Use SERIAL for PostgreSQL, AUTOINCREMENT for SQLite
+	id := "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	if s.isPostgres() {
+		id = "id SERIAL PRIMARY KEY"
+	}
+	schema := `
+	CREATE TABLE IF NOT EXISTS negentropy_sync_runs (
+		` + id + `,
+		relay_url TEXT NOT NULL,
+		kind INTEGER NOT NULL,
+		direction TEXT NOT NULL,
+		started_at INTEGER NOT NULL,
+		duration_ms INTEGER NOT NULL,
+		rounds INTEGER NOT NULL DEFAULT 0,
+		ranges INTEGER NOT NULL DEFAULT 0,
+		have_ids INTEGER NOT NULL DEFAULT 0,
+		need_ids INTEGER NOT NULL DEFAULT 0,
+		sent INTEGER NOT NULL DEFAULT 0,
+		received INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_negentropy_sync_runs_started ON negentropy_sync_runs(started_at DESC);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// RecordNegentropySyncRun stores the result of a reconciliation and drops
+// results older than 90 days
+func (s *Storage) RecordNegentropySyncRun(ctx context.Context, run NegentropySyncRun) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "RecordNegentropySyncRun", `
+		INSERT INTO negentropy_sync_runs
+			(relay_url, kind, direction, started_at, duration_ms, rounds, ranges, have_ids, need_ids, sent, received, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, run.RelayURL, run.Kind, run.Direction, run.StartedAt.Unix(), run.Duration.Milliseconds(),
+		run.Rounds, run.Ranges, run.Have, run.Need, run.Sent, run.Received, run.Error).exec()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.query(ctx, dbConn, "RecordNegentropySyncRun: prune", `
+		DELETE FROM negentropy_sync_runs WHERE started_at < ?
+	`, time.Now().Add(-negentropySyncRetention).Unix()).exec()
+	return err
+}
+
+// GetNegentropySyncRuns returns the reconciliations started since since, newest first
+func (s *Storage) GetNegentropySyncRuns(ctx context.Context, since time.Time) ([]NegentropySyncRun, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var runs []NegentropySyncRun
+	err := s.query(ctx, dbConn, "GetNegentropySyncRuns", `
+		SELECT relay_url, kind, direction, started_at, duration_ms, rounds, ranges, have_ids, need_ids, sent, received, error
+		FROM negentropy_sync_runs
+		WHERE started_at >= ?
+		ORDER BY started_at DESC, id DESC
+	`, since.Unix()).each(func(rows *sql.Rows) error {
+		var r NegentropySyncRun
+		var startedAt, durationMs int64
+		if err := rows.Scan(&r.RelayURL, &r.Kind, &r.Direction, &startedAt, &durationMs,
+			&r.Rounds, &r.Ranges, &r.Have, &r.Need, &r.Sent, &r.Received, &r.Error); err != nil {
+			return err
+		}
+		r.StartedAt = time.Unix(startedAt, 0)
+		r.Duration = time.Duration(durationMs) * time.Millisecond
+		runs = append(runs, r)
+		return nil
+	})
+	return runs, err
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+type Nip05Verification struct {
+	Pubkey     string
+	Identifier string
+	Valid      bool
+	CheckedAt  time.Time
+}
+
+// SaveNip05Verification records the result of checking a pubkey's NIP-05 identifier
+func (s *Storage) SaveNip05Verification(ctx context.Context, pubkey, identifier string, valid bool) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	validInt := 0
+	if valid {
+		validInt = 1
+	}
+
+	_, err := s.query(ctx, dbConn, "SaveNip05Verification", `
+		INSERT INTO nip05_verifications (pubkey, identifier, valid, checked_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(pubkey) DO UPDATE SET
+			identifier = excluded.identifier,
+			valid = excluded.valid,
+			checked_at = excluded.checked_at
+	`, pubkey, strings.ToLower(identifier), validInt, time.Now().Unix()).exec()
+
+	return err
+}
+
+// GetNip05Verifications returns the last verification for each of the given pubkeys
+func (s *Storage) GetNip05Verifications(ctx context.Context, pubkeys []string) (map[string]Nip05Verification, error) {
+	result := make(map[string]Nip05Verification)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil || len(pubkeys) == 0 {
+		return result, nil
+	}
+
+	args := make([]interface{}, len(pubkeys))
+	for i, pk := range pubkeys {
+		args[i] = pk
+	}
+
+	query := "SELECT pubkey, identifier, valid, checked_at FROM nip05_verifications WHERE pubkey IN (?" +
+		strings.Repeat(",?", len(pubkeys)-1) + ")"
+
+	err := s.query(ctx, dbConn, "GetNip05Verifications", query, args...).each(func(rows *sql.Rows) error {
+		var v Nip05Verification
+		var valid int
+		var checkedAt int64
+		if err := rows.Scan(&v.Pubkey, &v.Identifier, &valid, &checkedAt); err != nil {
+			return err
+		}
+		v.Valid = valid == 1
+		v.CheckedAt = time.Unix(checkedAt, 0)
+		result[v.Pubkey] = v
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// GetVerifiedNip05Pubkeys returns the identifier of every pubkey whose NIP-05 last verified successfully
+func (s *Storage) GetVerifiedNip05Pubkeys(ctx context.Context) (map[string]string, error) {
+	result := make(map[string]string)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return result, nil
+	}
+
+	err := s.query(ctx, dbConn, "GetVerifiedNip05Pubkeys", `SELECT pubkey, identifier FROM nip05_verifications WHERE valid = 1`).each(func(rows *sql.Rows) error {
+		var pubkey, identifier string
+		if err := rows.Scan(&pubkey, &identifier); err != nil {
+			return err
+		}
+		result[pubkey] = identifier
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// GetNip05Claims returns the latest kind 0 of every pubkey whose profile claims the given
+// identifier. "_@domain" and "domain" are treated as the same identifier.
+func (s *Storage) GetNip05Claims(ctx context.Context, identifier string) ([]*nostr.Event, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	identifier = NormalizeNip05(identifier)
+	// The root identifier is usually written as the bare domain, which still contains the domain
+	needle := identifier
+	if strings.HasPrefix(needle, "_@") {
+		needle = needle[2:]
+	}
+
+	seen := make(map[string]bool)
+	var claims []*nostr.Event
+	err := s.query(ctx, dbConn, "GetNip05Claims", `
+		SELECT id, pubkey, created_at, kind, tags, content, sig
+		FROM event
+		WHERE kind = 0 AND content ILIKE '%' || $1 || '%'
+		ORDER BY created_at DESC
+		LIMIT 500`, needle).each(func(rows *sql.Rows) error {
+		var evt nostr.Event
+		var tagsJSON string
+		if err := rows.Scan(&evt.ID, &evt.PubKey, &evt.CreatedAt, &evt.Kind, &tagsJSON, &evt.Content, &evt.Sig); err != nil {
+			return err
+		}
+		// Rows are newest first, so an older profile of the same pubkey no longer counts as a claim
+		if seen[evt.PubKey] {
+			return nil
+		}
+		seen[evt.PubKey] = true
+
+		var metadata struct {
+			Nip05 string `json:"nip05"`
+		}
+		if err := json.Unmarshal([]byte(evt.Content), &metadata); err != nil {
+			return nil
+		}
+		if NormalizeNip05(metadata.Nip05) != identifier {
+			return nil
+		}
+		json.Unmarshal([]byte(tagsJSON), &evt.Tags)
+		claims = append(claims, &evt)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// NormalizeNip05 lowercases an identifier and expands a bare domain to "_@domain"
+func NormalizeNip05(identifier string) string {
+	identifier = strings.ToLower(strings.TrimSpace(identifier))
+	if identifier != "" && !strings.Contains(identifier, "@") {
+		identifier = "_@" + identifier
+	}
+	return identifier
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// OriginRejection counts websocket upgrades refused for their Origin header
+type OriginRejection struct {
+	Origin   string
+	Rule     string // the deny pattern matched, or "not allowed" when no allow pattern matched
+	Attempts int64
+	LastSeen time.Time
+}
+
+func (s *Storage) InitOriginRejectionSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS origin_rejections (
+		origin TEXT NOT NULL,
+		rule TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_seen INTEGER NOT NULL,
+		PRIMARY KEY (origin, rule)
+	);
+	CREATE INDEX IF NOT EXISTS idx_origin_rejections_last_seen ON origin_rejections(last_seen DESC);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// RecordOriginRejection counts a websocket upgrade refused by the origin policy
+func (s *Storage) RecordOriginRejection(ctx context.Context, origin, rule string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "RecordOriginRejection", `
+		INSERT INTO origin_rejections (origin, rule, attempts, last_seen)
+		VALUES (?, ?, 1, ?)
+		ON CONFLICT(origin, rule) DO UPDATE SET
+			attempts = origin_rejections.attempts + 1,
+			last_seen = excluded.last_seen
+	`, origin, rule, time.Now().Unix()).exec()
+
+	return err
+}
+
+// GetOriginRejections returns the origins refused most often
+func (s *Storage) GetOriginRejections(ctx context.Context, limit int) ([]OriginRejection, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var results []OriginRejection
+	err := s.query(ctx, dbConn, "GetOriginRejections", `
+		SELECT origin, rule, attempts, last_seen
+		FROM origin_rejections
+		ORDER BY attempts DESC
+		LIMIT ?
+	`, limit).each(func(rows *sql.Rows) error {
+		var o OriginRejection
+		var lastSeen int64
+		if err := rows.Scan(&o.Origin, &o.Rule, &o.Attempts, &lastSeen); err != nil {
+			return err
+		}
+		o.LastSeen = time.Unix(lastSeen, 0)
+		results = append(results, o)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// OutboxProbeRun totals one outbox probe: for a sample of trusted pubkeys,
+// how many of the profiles and contact lists we hold their own write relays
+// returned, and how many of those were as new as ours
+type OutboxProbeRun struct {
+	RunAt    time.Time
+	Pubkeys  int64
+	Relays   int64
+	Expected int64 // pubkey, kind and write relay combinations asked for
+	Found    int64 // answered with an event of that kind
+	Current  int64 // answered with an event at least as new as ours
+}
+
+// OutboxRelayProbe is how one relay answered for the pubkeys listing it as
+// a write relay in the latest probe
+type OutboxRelayProbe struct {
+	URL      string
+	ProbedAt time.Time
+	Pubkeys  int64
+	Expected int64
+	Found    int64
+	Current  int64
+	Error    string // why the relay couldn't be queried, empty if it answered
+}
+
+func (s *Storage) InitOutboxProbeSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS outbox_probe_runs (
+		run_at INTEGER PRIMARY KEY,
+		pubkeys INTEGER NOT NULL,
+		relays INTEGER NOT NULL,
+		expected INTEGER NOT NULL,
+		found INTEGER NOT NULL,
+		current INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS outbox_probe_relays (
+		url TEXT PRIMARY KEY,
+		probed_at INTEGER NOT NULL,
+		pubkeys INTEGER NOT NULL,
+		expected INTEGER NOT NULL,
+		found INTEGER NOT NULL,
+		current INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// RecordOutboxProbe stores a probe's totals and replaces the latest result of
+// each relay it queried
+func (s *Storage) RecordOutboxProbe(ctx context.Context, run OutboxProbeRun, relays []OutboxRelayProbe) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	return s.inTx(ctx, dbConn, "RecordOutboxProbe", func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := s.query(ctx, tx, "RecordOutboxProbe: run", `
+			INSERT INTO outbox_probe_runs (run_at, pubkeys, relays, expected, found, current)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(run_at) DO NOTHING
+		`, run.RunAt.Unix(), run.Pubkeys, run.Relays, run.Expected, run.Found, run.Current).exec()
+		if err != nil {
+			return err
+		}
+
+		for _, r := range relays {
+			_, err := s.query(ctx, tx, "RecordOutboxProbe: relay", `
+				INSERT INTO outbox_probe_relays (url, probed_at, pubkeys, expected, found, current, error)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(url) DO UPDATE SET
+					probed_at = excluded.probed_at,
+					pubkeys = excluded.pubkeys,
+					expected = excluded.expected,
+					found = excluded.found,
+					current = excluded.current,
+					error = excluded.error
+			`, r.URL, r.ProbedAt.Unix(), r.Pubkeys, r.Expected, r.Found, r.Current, r.Error).exec()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetOutboxProbeRuns returns the latest probes, newest first
+func (s *Storage) GetOutboxProbeRuns(ctx context.Context, limit int) ([]OutboxProbeRun, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var runs []OutboxProbeRun
+	err := s.query(ctx, dbConn, "GetOutboxProbeRuns", `
+		SELECT run_at, pubkeys, relays, expected, found, current
+		FROM outbox_probe_runs
+		ORDER BY run_at DESC
+		LIMIT ?
+	`, limit).each(func(rows *sql.Rows) error {
+		var r OutboxProbeRun
+		var runAt int64
+		if err := rows.Scan(&runAt, &r.Pubkeys, &r.Relays, &r.Expected, &r.Found, &r.Current); err != nil {
+			return err
+		}
+		r.RunAt = time.Unix(runAt, 0)
+		runs = append(runs, r)
+		return nil
+	})
+	return runs, err
+}
+
+// GetOutboxRelayProbes returns the latest result of every probed relay probed
+// since since, the relays most pubkeys write to first
+func (s *Storage) GetOutboxRelayProbes(ctx context.Context, since time.Time) ([]OutboxRelayProbe, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var relays []OutboxRelayProbe
+	err := s.query(ctx, dbConn, "GetOutboxRelayProbes", `
+		SELECT url, probed_at, pubkeys, expected, found, current, error
+		FROM outbox_probe_relays
+		WHERE probed_at >= ?
+		ORDER BY pubkeys DESC, url
+	`, since.Unix()).each(func(rows *sql.Rows) error {
+		var r OutboxRelayProbe
+		var probedAt int64
+		if err := rows.Scan(&r.URL, &probedAt, &r.Pubkeys, &r.Expected, &r.Found, &r.Current, &r.Error); err != nil {
+			return err
+		}
+		r.ProbedAt = time.Unix(probedAt, 0)
+		relays = append(relays, r)
+		return nil
+	})
+	return relays, err
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type OversizeAttempt struct {
+	IP       string
+	Reason   string
+	Attempts int64
+	Largest  int64
+	LastSeen time.Time
+}
+
+func (s *Storage) InitOversizeSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS oversize_attempts (
+		ip TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		largest INTEGER NOT NULL DEFAULT 0,
+		last_seen INTEGER NOT NULL,
+		PRIMARY KEY (ip, reason)
+	);
+	CREATE INDEX IF NOT EXISTS idx_oversize_attempts_last_seen ON oversize_attempts(last_seen DESC);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// RecordOversizeAttempt counts an event or message refused for exceeding a size limit against the sending IP
+func (s *Storage) RecordOversizeAttempt(ctx context.Context, ip, reason string, size int) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	now := time.Now()
+	ip, err := s.anonymizeIP(ctx, ip, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.query(ctx, dbConn, "RecordOversizeAttempt", `
+		INSERT INTO oversize_attempts (ip, reason, attempts, largest, last_seen)
+		VALUES (?, ?, 1, ?, ?)
+		ON CONFLICT(ip, reason) DO UPDATE SET
+			attempts = oversize_attempts.attempts + 1,
+			largest = GREATEST(oversize_attempts.largest, excluded.largest),
+			last_seen = excluded.last_seen
+	`, ip, reason, size, now.Unix()).exec()
+
+	return err
+}
+
+// GetOversizeAttempts returns the IPs that sent the most oversized events
+func (s *Storage) GetOversizeAttempts(ctx context.Context, limit int) ([]OversizeAttempt, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var results []OversizeAttempt
+	err := s.query(ctx, dbConn, "GetOversizeAttempts", `
+		SELECT ip, reason, attempts, largest, last_seen
+		FROM oversize_attempts
+		ORDER BY attempts DESC
+		LIMIT ?
+	`, limit).each(func(rows *sql.Rows) error {
+		var a OversizeAttempt
+		var lastSeen int64
+		if err := rows.Scan(&a.IP, &a.Reason, &a.Attempts, &a.Largest, &lastSeen); err != nil {
+			return err
+		}
+		a.LastSeen = time.Unix(lastSeen, 0)
+		results = append(results, a)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// PoolStats is a snapshot of one connection pool, as reported by database/sql
+type PoolStats struct {
+	Pool string
+	sql.DBStats
+}
+
+// ConfigurePools caps the write pool at maxWrite connections and opens a separate
+// read-only pool of maxRead connections, so long analytics reads queue behind
+// each other instead of taking connections from writes. readURL defaults to the
+// database the analytics tables live in; point it at a replica to move reads off
+// the primary entirely. A maxRead of 0 keeps every query on the write pool.
+func (s *Storage) ConfigurePools(readURL string, maxRead, maxWrite int) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	if maxWrite > 0 {
+		dbConn.SetMaxOpenConns(maxWrite)
+		dbConn.SetMaxIdleConns(maxWrite)
+	}
+
+	if maxRead <= 0 {
+		return nil
+	}
+
+	if readURL == "" {
+		readURL = s.sqlURL
+	}
+	if readURL == "" {
+		return fmt.Errorf("no read database URL configured")
+	}
+
+	readDB, err := sqlx.Connect("postgres", readOnlyDSN(readURL))
+	if err != nil {
+		return fmt.Errorf("failed to connect read pool: %w", err)
+	}
+	readDB.SetMaxOpenConns(maxRead)
+	readDB.SetMaxIdleConns(maxRead)
+	s.readDB = readDB
+
+	log.Printf("Storage: %d read-only and %d read-write connections", maxRead, maxWrite)
+	return nil
+}
+
+// readOnlyDSN asks the server to refuse writes on every connection of the read pool
+func readOnlyDSN(dsn string) string {
+	const param = "default_transaction_read_only=on"
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if strings.Contains(dsn, "?") {
+			return dsn + "&" + param
+		}
+		return dsn + "?" + param
+	}
+	return dsn + " " + param
+}
+
+// getReadDBConn returns the pool for queries that only read, falling back to
+// the write pool when no read pool is configured
+func (s *Storage) getReadDBConn() *sqlx.DB {
+	if s.readDB != nil {
+		return s.readDB
+	}
+	return s.getDBConn()
+}
+
+// GetPoolStats reports the write pool and, when configured, the read pool
+func (s *Storage) GetPoolStats() []PoolStats {
+	var pools []PoolStats
+	if dbConn := s.getDBConn(); dbConn != nil {
+		pools = append(pools, PoolStats{Pool: "write", DBStats: dbConn.Stats()})
+	}
+	if s.readDB != nil {
+		pools = append(pools, PoolStats{Pool: "read", DBStats: s.readDB.Stats()})
+	}
+	return pools
+}
@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Profile bundles are the profile, contact list and relay list of the most
+// requested pubkeys, held in memory so the REQs clients send most often are
+// answered without touching the database. The cache is refreshed from storage
+// in quiet periods and kept current by SaveEvent and DeleteEvent in between;
+// writes by other processes show up with the next refresh.
+
+// bundleKinds are the kinds of a profile bundle, in slot order
+var bundleKinds = [...]int{0, 3, 10002}
+
+// bundleQueryChunk is how many authors one refresh query asks for
+const bundleQueryChunk = 500
+
+func bundleSlot(kind int) int {
+	for i, k := range bundleKinds {
+		if k == kind {
+			return i
+		}
+	}
+	return -1
+}
+
+// profileBundle holds the newest event of each bundle kind. A known slot
+// without an event means we hold none of that kind; an unknown slot, after its
+// event was deleted, is answered from storage until the next save or refresh.
+type profileBundle struct {
+	events [len(bundleKinds)]*nostr.Event
+	known  [len(bundleKinds)]bool
+}
+
+// ProfileBundleStats describes the bundle cache
+type ProfileBundleStats struct {
+	Pubkeys     int
+	Bytes       int64
+	Hits        int64 // filters answered from memory
+	Misses      int64 // bundle-shaped filters that went to storage
+	RefreshedAt time.Time
+}
+
+type bundleCache struct {
+	mu          sync.RWMutex
+	maxPubkeys  int // 0 while disabled
+	maxBytes    int64
+	bundles     map[string]*profileBundle
+	bytes       int64
+	refreshedAt time.Time
+	// pubkeys written while a refresh runs, left out of its result
+	refreshing bool
+	dirty      map[string]bool
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// EnableProfileBundles keeps the bundles of up to maxPubkeys of the most
+// requested pubkeys in memory, using at most maxBytes of event data
+func (s *Storage) EnableProfileBundles(maxPubkeys int, maxBytes int64) {
+	s.bundles.mu.Lock()
+	defer s.bundles.mu.Unlock()
+	s.bundles.maxPubkeys = maxPubkeys
+	s.bundles.maxBytes = maxBytes
+}
+
+// RefreshProfileBundles reloads the bundles of the currently most requested pubkeys
+func (s *Storage) RefreshProfileBundles(ctx context.Context) error {
+	s.bundles.mu.Lock()
+	maxPubkeys, maxBytes := s.bundles.maxPubkeys, s.bundles.maxBytes
+	if maxPubkeys > 0 {
+		s.bundles.refreshing = true
+		s.bundles.dirty = make(map[string]bool)
+	}
+	s.bundles.mu.Unlock()
+	if maxPubkeys <= 0 {
+		return nil
+	}
+	defer func() {
+		s.bundles.mu.Lock()
+		s.bundles.refreshing = false
+		s.bundles.dirty = nil
+		s.bundles.mu.Unlock()
+	}()
+
+	start := time.Now()
+	top, err := s.GetTopRequestedPubkeys(ctx, maxPubkeys)
+	if err != nil {
+		return err
+	}
+
+	bundles := make(map[string]*profileBundle, len(top))
+	var bytes int64
+	for start := 0; start < len(top) && bytes < maxBytes; start += bundleQueryChunk {
+		chunk := top[start:min(start+bundleQueryChunk, len(top))]
+		authors := make([]string, len(chunk))
+		for i, p := range chunk {
+			authors[i] = p.Pubkey
+		}
+
+		loaded := make(map[string]*profileBundle, len(authors))
+		ch, err := s.db.QueryEvents(ctx, nostr.Filter{Kinds: bundleKinds[:], Authors: authors})
+		if err != nil {
+			return err
+		}
+		for evt := range ch {
+			b := loaded[evt.PubKey]
+			if b == nil {
+				b = &profileBundle{}
+				loaded[evt.PubKey] = b
+			}
+			slot := bundleSlot(evt.Kind)
+			if existing := b.events[slot]; existing == nil || evt.CreatedAt > existing.CreatedAt {
+				b.events[slot] = evt
+			}
+		}
+
+		// Most requested first, until the memory budget runs out
+		for _, pubkey := range authors {
+			b := loaded[pubkey]
+			if b == nil {
+				b = &profileBundle{}
+			}
+			b.known = [len(bundleKinds)]bool{true, true, true}
+			size := b.size()
+			if bytes+size > maxBytes {
+				break
+			}
+			bundles[pubkey] = b
+			bytes += size
+		}
+	}
+
+	s.bundles.mu.Lock()
+	for pubkey := range s.bundles.dirty {
+		if b, ok := bundles[pubkey]; ok {
+			bytes -= b.size()
+			delete(bundles, pubkey)
+		}
+	}
+	s.bundles.bundles = bundles
+	s.bundles.bytes = bytes
+	s.bundles.refreshedAt = time.Now()
+	s.bundles.mu.Unlock()
+
+	log.Printf("Cached profile bundles of %d pubkeys (%d MB) in %v", len(bundles), bytes>>20, time.Since(start))
+	return nil
+}
+
+// size estimates the memory the bundle's events take
+func (b *profileBundle) size() int64 {
+	var size int64
+	for _, evt := range b.events {
+		if evt == nil {
+			continue
+		}
+		size += int64(len(evt.Content) + len(evt.ID) + len(evt.PubKey) + len(evt.Sig))
+		for _, tag := range evt.Tags {
+			for _, v := range tag {
+				size += int64(len(v)) + 16
+			}
+		}
+	}
+	return size
+}
+
+// answer returns the events of a filter asking only for bundle kinds of cached
+// pubkeys, newest first. The events are shared and must not be modified.
+func (c *bundleCache) answer(filter nostr.Filter) ([]*nostr.Event, bool) {
+	if len(filter.Authors) == 0 || len(filter.Kinds) == 0 || len(filter.IDs) > 0 || len(filter.Tags) > 0 ||
+		filter.Since != nil || filter.Until != nil || filter.Search != "" {
+		return nil, false
+	}
+	slots := make([]int, len(filter.Kinds))
+	for i, kind := range filter.Kinds {
+		if slots[i] = bundleSlot(kind); slots[i] < 0 {
+			return nil, false
+		}
+	}
+
+	c.mu.RLock()
+	if c.bundles == nil {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	events := make([]*nostr.Event, 0, len(filter.Authors)*len(slots))
+	for _, pubkey := range filter.Authors {
+		b := c.bundles[pubkey]
+		if b == nil {
+			c.mu.RUnlock()
+			c.misses.Add(1)
+			return nil, false
+		}
+		for _, slot := range slots {
+			if !b.known[slot] {
+				c.mu.RUnlock()
+				c.misses.Add(1)
+				return nil, false
+			}
+			if b.events[slot] != nil {
+				events = append(events, b.events[slot])
+			}
+		}
+	}
+	c.mu.RUnlock()
+	c.hits.Add(1)
+
+	sort.Slice(events, func(i, j int) bool { return events[i].CreatedAt > events[j].CreatedAt })
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, true
+}
+
+// saved puts a newly stored bundle event into its cached bundle
+func (c *bundleCache) saved(evt *nostr.Event) {
+	slot := bundleSlot(evt.Kind)
+	if slot < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing {
+		c.dirty[evt.PubKey] = true
+	}
+	b := c.bundles[evt.PubKey]
+	if b == nil {
+		return
+	}
+	if existing := b.events[slot]; b.known[slot] && existing != nil && existing.CreatedAt > evt.CreatedAt {
+		return
+	}
+	before := b.size()
+	b.events[slot] = evt
+	b.known[slot] = true
+	c.bytes += b.size() - before
+}
+
+// deleted forgets a deleted bundle event; storage answers for that kind of the
+// pubkey until a newer one is saved or the cache is refreshed
+func (c *bundleCache) deleted(evt *nostr.Event) {
+	slot := bundleSlot(evt.Kind)
+	if slot < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing {
+		c.dirty[evt.PubKey] = true
+	}
+	b := c.bundles[evt.PubKey]
+	if b == nil || b.events[slot] == nil || b.events[slot].ID != evt.ID {
+		return
+	}
+	before := b.size()
+	b.events[slot] = nil
+	b.known[slot] = false
+	c.bytes += b.size() - before
+}
+
+// forget drops the bundles of pubkeys whose events were removed wholesale
+func (c *bundleCache) forget(pubkeys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pubkey := range pubkeys {
+		if c.refreshing {
+			c.dirty[pubkey] = true
+		}
+		if b, ok := c.bundles[pubkey]; ok {
+			c.bytes -= b.size()
+			delete(c.bundles, pubkey)
+		}
+	}
+}
+
+// GetProfileBundleStats describes the bundle cache
+func (s *Storage) GetProfileBundleStats() ProfileBundleStats {
+	s.bundles.mu.RLock()
+	defer s.bundles.mu.RUnlock()
+	return ProfileBundleStats{
+		Pubkeys:     len(s.bundles.bundles),
+		Bytes:       s.bundles.bytes,
+		Hits:        s.bundles.hits.Load(),
+		Misses:      s.bundles.misses.Load(),
+		RefreshedAt: s.bundles.refreshedAt,
+	}
+}
@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/lang"
+)
+
+// The profile_search table indexes every author's latest profile for full text
+// search: names unstemmed, the about field with the analyzer of its detected
+// language and again unstemmed, so a query matches both inflected forms and
+// exact words in any language. profile_hashtags lists the hashtags in each bio.
+// SaveEvent keeps both current; EnsureProfileSearchIndex fills them once.
+
+// profileSearchTable names the index in derived_table_refreshes once it is built
+const profileSearchTable = "profile_search"
+
+// maxProfileHashtags caps the hashtags indexed per bio
+const maxProfileHashtags = 20
+
+// ProfileMention is a profile matching a search, with where it matched
+type ProfileMention struct {
+	Pubkey   string
+	Name     string
+	Language string // detected language code of the about field, "" if unknown
+	Hashtags []string
+	Snippet  string // about field excerpt with matches wrapped in <b></b>
+	Rank     float64
+}
+
+func (s *Storage) InitProfileSearchSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS profile_search (
+		pubkey TEXT PRIMARY KEY,
+		created_at INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		about TEXT NOT NULL,
+		language TEXT NOT NULL,
+		document TSVECTOR NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_profile_search_document ON profile_search USING GIN (document);
+	CREATE INDEX IF NOT EXISTS idx_profile_search_language ON profile_search(language);
+
+	CREATE TABLE IF NOT EXISTS profile_hashtags (
+		hashtag TEXT NOT NULL,
+		pubkey TEXT NOT NULL,
+		PRIMARY KEY (hashtag, pubkey)
+	);
+	CREATE INDEX IF NOT EXISTS idx_profile_hashtags_pubkey ON profile_hashtags(pubkey);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// updateProfileSearch indexes a profile unless a newer one from the same
+// author is already indexed
+func (s *Storage) updateProfileSearch(ctx context.Context, evt *nostr.Event) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	var metadata struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+		About       string `json:"about"`
+	}
+	// Profiles that aren't JSON objects are refused before they get here
+	if err := json.Unmarshal([]byte(evt.Content), &metadata); err != nil {
+		return nil
+	}
+	name := strings.TrimSpace(metadata.DisplayName + " " + metadata.Name)
+	language := lang.Detect(metadata.About)
+	hashtags := lang.Hashtags(metadata.About)
+	if len(hashtags) > maxProfileHashtags {
+		hashtags = hashtags[:maxProfileHashtags]
+	}
+
+	return s.inTx(ctx, dbConn, "updateProfileSearch", func(ctx context.Context, tx *sqlx.Tx) error {
+		result, err := s.query(ctx, tx, "updateProfileSearch", `
+			INSERT INTO profile_search (pubkey, created_at, name, about, language, document)
+			VALUES ($1, $2, $3, $4, $5,
+				setweight(to_tsvector('simple', $3), 'A') ||
+				setweight(to_tsvector($6::regconfig, $4), 'B') ||
+				setweight(to_tsvector('simple', $4), 'C'))
+			ON CONFLICT(pubkey) DO UPDATE SET
+				created_at = excluded.created_at,
+				name = excluded.name,
+				about = excluded.about,
+				language = excluded.language,
+				document = excluded.document
+			WHERE excluded.created_at > profile_search.created_at
+		`, evt.PubKey, int64(evt.CreatedAt), name, metadata.About, language, lang.Analyzer(language)).exec()
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		if _, err := s.query(ctx, tx, "updateProfileSearch: clear hashtags", `DELETE FROM profile_hashtags WHERE pubkey = ?`, evt.PubKey).exec(); err != nil {
+			return err
+		}
+		if len(hashtags) > 0 {
+			if _, err := s.query(ctx, tx, "updateProfileSearch: hashtags", `
+				INSERT INTO profile_hashtags (hashtag, pubkey) SELECT unnest($1::text[]), $2
+			`, pq.Array(hashtags), evt.PubKey).exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// profileSearchReady reports whether the search index has been built; until
+// then SearchProfiles scans profile content
+func (s *Storage) profileSearchReady(ctx context.Context) bool {
+	if s.searchReady.Load() {
+		return true
+	}
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return false
+	}
+	var refreshedAt int64
+	err := s.query(ctx, dbConn, "profileSearchReady", `SELECT refreshed_at FROM derived_table_refreshes WHERE table_name = ?`, profileSearchTable).scan(&refreshedAt)
+	if err != nil {
+		return false
+	}
+	s.searchReady.Store(true)
+	return true
+}
+
+// ProfileSearchReady reports whether profile search and mentions use the index
+func (s *Storage) ProfileSearchReady(ctx context.Context) bool {
+	return s.profileSearchReady(ctx)
+}
+
+// EnsureProfileSearchIndex indexes every stored profile unless the index has
+// been built before. Saves keep it current after that.
+func (s *Storage) EnsureProfileSearchIndex(ctx context.Context) error {
+	if s.getDBConn() == nil || s.profileSearchReady(ctx) {
+		return nil
+	}
+
+	start := time.Now()
+	var indexed int64
+	err := s.ScanEvents(ctx, nostr.Filter{Kinds: []int{0}}, func(evt *nostr.Event) {
+		if err := s.updateProfileSearch(ctx, evt); err != nil {
+			log.Printf("Failed to index profile %s for search: %v", evt.ID, err)
+			return
+		}
+		indexed++
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.recordDerivedTableRefresh(ctx, profileSearchTable, time.Since(start), indexed, time.Now().Unix()); err != nil {
+		return err
+	}
+	s.searchReady.Store(true)
+	log.Printf("Built profile search index from %d profiles in %v", indexed, time.Since(start))
+	return nil
+}
+
+// profileSearchQuery is the tsquery matching query unstemmed and as stemmed by
+// analyzer, or by every stemmer when analyzer is empty. Quoted phrases match
+// consecutive words, "or" alternatives and -word exclusions.
+func profileSearchQuery(analyzer string) string {
+	analyzers := []string{analyzer}
+	if analyzer == "" {
+		analyzers = lang.Analyzers()
+	}
+	parts := []string{`websearch_to_tsquery('simple', $1)`}
+	for _, a := range analyzers {
+		if a != lang.Simple {
+			parts = append(parts, `websearch_to_tsquery('`+a+`', $1)`)
+		}
+	}
+	return strings.Join(parts, " || ")
+}
+
+// SearchProfileMentions returns the profiles whose names or bios match query,
+// best match first. A query of a single #hashtag matches the hashtags in bios.
+// language, if set, restricts results to bios detected in that language and
+// stems the query with its analyzer only.
+func (s *Storage) SearchProfileMentions(ctx context.Context, query, language string, limit, offset int) ([]ProfileMention, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	args := []any{query, limit, offset}
+	var q string
+	if hashtag, ok := strings.CutPrefix(query, "#"); ok && !strings.ContainsAny(hashtag, " \t#") {
+		args[0] = strings.ToLower(hashtag)
+		q = `
+			SELECT p.pubkey, p.name, p.language, 1.0::float8, ''
+			FROM profile_hashtags h
+			JOIN profile_search p ON p.pubkey = h.pubkey
+			WHERE h.hashtag = $1`
+		if language != "" {
+			q += ` AND p.language = $4`
+			args = append(args, language)
+		}
+		q += `
+			ORDER BY p.created_at DESC
+			LIMIT $2 OFFSET $3`
+	} else {
+		analyzer := ""
+		if language != "" {
+			analyzer = lang.Analyzer(language)
+		}
+		q = `
+			SELECT p.pubkey, p.name, p.language, ts_rank(p.document, q)::float8,
+				ts_headline('simple', p.about, q, 'MaxWords=30, MinWords=10, MaxFragments=1')
+			FROM profile_search p, (SELECT ` + profileSearchQuery(analyzer) + ` AS q) query
+			WHERE p.document @@ q`
+		if language != "" {
+			q += ` AND p.language = $4`
+			args = append(args, language)
+		}
+		q += `
+			ORDER BY 4 DESC, p.pubkey
+			LIMIT $2 OFFSET $3`
+	}
+
+	var mentions []ProfileMention
+	err := s.query(ctx, dbConn, "SearchProfileMentions", q, args...).each(func(rows *sql.Rows) error {
+		var m ProfileMention
+		if err := rows.Scan(&m.Pubkey, &m.Name, &m.Language, &m.Rank, &m.Snippet); err != nil {
+			return err
+		}
+		mentions = append(mentions, m)
+		return nil
+	})
+	if err != nil || len(mentions) == 0 {
+		return mentions, err
+	}
+
+	pubkeys := make([]string, len(mentions))
+	index := make(map[string]int, len(mentions))
+	for i, m := range mentions {
+		pubkeys[i] = m.Pubkey
+		index[m.Pubkey] = i
+	}
+	err = s.query(ctx, dbConn, "SearchProfileMentions: hashtags", `
+		SELECT pubkey, hashtag FROM profile_hashtags WHERE pubkey = ANY($1) ORDER BY hashtag
+	`, pq.Array(pubkeys)).each(func(rows *sql.Rows) error {
+		var pubkey, hashtag string
+		if err := rows.Scan(&pubkey, &hashtag); err != nil {
+			return err
+		}
+		mentions[index[pubkey]].Hashtags = append(mentions[index[pubkey]].Hashtags, hashtag)
+		return nil
+	})
+	return mentions, err
+}
+
+// searchProfileIndex returns the latest profiles of the best index matches for
+// query, and of pubkeys starting with it
+func (s *Storage) searchProfileIndex(ctx context.Context, query string, limit int) ([]*nostr.Event, error) {
+	mentions, err := s.SearchProfileMentions(ctx, query, "", limit, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	authors := make([]string, 0, len(mentions)+1)
+	if nostr.IsValid32ByteHex(query) {
+		authors = append(authors, query)
+	}
+	for _, m := range mentions {
+		authors = append(authors, m.Pubkey)
+	}
+	if len(authors) == 0 {
+		return nil, nil
+	}
+
+	events, err := s.QueryEvents(ctx, nostr.Filter{Kinds: []int{0}, Authors: authors, Limit: len(authors)})
+	if err != nil {
+		return nil, err
+	}
+	byAuthor := make(map[string]*nostr.Event, len(events))
+	for _, evt := range events {
+		if existing, ok := byAuthor[evt.PubKey]; !ok || evt.CreatedAt > existing.CreatedAt {
+			byAuthor[evt.PubKey] = evt
+		}
+	}
+
+	results := make([]*nostr.Event, 0, len(authors))
+	for _, pk := range authors {
+		if evt, ok := byAuthor[pk]; ok {
+			results = append(results, evt)
+			delete(byAuthor, pk)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// identityFields are the kind 0 fields whose churn indicates impersonation bots
+var identityFields = map[string]bool{
+	"name":         true,
+	"display_name": true,
+	"picture":      true,
+	"nip05":        true,
+}
+
+// IdentityChanged reports whether newVer rewrote an identity field of oldVer
+func IdentityChanged(oldVer, newVer *EventVersion) bool {
+	for _, change := range CalculateProfileDelta(oldVer, newVer).Changes {
+		if identityFields[change.Field] {
+			return true
+		}
+	}
+	return false
+}
+
+// profileIdentity fingerprints the identity fields of a kind 0 content, so two
+// versions differ exactly when IdentityChanged would report them
+func profileIdentity(content string) string {
+	var profile struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+		Picture     string `json:"picture"`
+		Nip05       string `json:"nip05"`
+	}
+	json.Unmarshal([]byte(content), &profile)
+
+	sum := sha256.Sum256([]byte(profile.Name + "\x00" + profile.DisplayName + "\x00" + profile.Picture + "\x00" + profile.Nip05))
+	return hex.EncodeToString(sum[:16])
+}
+
+// recordProfileChange counts a saved kind 0 against its author's change
+// velocity when it replaces an older profile with different identity fields.
+// Every pubkey is counted here, where event_history only keeps the versions
+// of trusted ones.
+func (s *Storage) recordProfileChange(ctx context.Context, evt *nostr.Event) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	identity := profileIdentity(evt.Content)
+	var previous string
+	var previousAt int64
+	err := s.query(ctx, dbConn, "recordProfileChange", `
+		SELECT identity, created_at FROM profile_identities WHERE pubkey = ?
+	`, evt.PubKey).scan(&previous, &previousAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = s.query(ctx, dbConn, "recordProfileChange", `
+			INSERT INTO profile_identities (pubkey, identity, created_at) VALUES (?, ?, ?)
+			ON CONFLICT(pubkey) DO NOTHING
+		`, evt.PubKey, identity, evt.CreatedAt).exec()
+		return err
+	}
+	if err != nil || int64(evt.CreatedAt) <= previousAt {
+		return err
+	}
+
+	// Only the save that moves the row on counts, should two race
+	result, err := s.query(ctx, dbConn, "recordProfileChange", `
+		UPDATE profile_identities SET identity = ?, created_at = ?
+		WHERE pubkey = ? AND created_at = ?
+	`, identity, evt.CreatedAt, evt.PubKey, previousAt).exec()
+	if err != nil || identity == previous {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	_, err = s.query(ctx, dbConn, "recordProfileChange", `
+		INSERT INTO profile_changes (pubkey, hour, changes) VALUES (?, ?, 1)
+		ON CONFLICT(pubkey, hour) DO UPDATE SET changes = profile_changes.changes + 1
+	`, evt.PubKey, int64(evt.CreatedAt)/3600).exec()
+	return err
+}
+
+// ComputeProfileChangeVelocity counts, per pubkey, how many kind 0 versions
+// created since the given time (to the hour) changed an identity field (name,
+// picture, nip05)
+func (s *Storage) ComputeProfileChangeVelocity(ctx context.Context, since time.Time) (map[string]int, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	velocity := make(map[string]int)
+	err := s.query(ctx, dbConn, "ComputeProfileChangeVelocity", `
+		SELECT pubkey, SUM(changes)
+		FROM profile_changes
+		WHERE hour >= ?
+		GROUP BY pubkey
+	`, since.Unix()/3600).each(func(rows *sql.Rows) error {
+		var pubkey string
+		var changes int
+		if err := rows.Scan(&pubkey, &changes); err != nil {
+			return err
+		}
+		velocity[pubkey] = changes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return velocity, nil
+}
+
+// PruneProfileChanges drops the identity change counts of hours before the given time
+func (s *Storage) PruneProfileChanges(ctx context.Context, before time.Time) (int64, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return 0, nil
+	}
+
+	result, err := s.query(ctx, dbConn, "PruneProfileChanges", `
+		DELETE FROM profile_changes WHERE hour < ?
+	`, before.Unix()/3600).exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SaveProfileChangeVelocity replaces the stored velocity snapshot
+func (s *Storage) SaveProfileChangeVelocity(ctx context.Context, velocity map[string]int) error {
+	now := time.Now().Unix()
+	return s.rebuildDerivedTables(ctx, []derivedTable{profileVelocityTable}, func(ctx context.Context, tx *sqlx.Tx) error {
+		for pubkey, changes := range velocity {
+			if _, err := s.query(ctx, tx, "SaveProfileChangeVelocity", `
+				INSERT INTO profile_change_velocity_next (pubkey, changes_24h, computed_at) VALUES (?, ?, ?)
+			`, pubkey, changes, now).exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetProfileChangeVelocity returns the last computed identity changes per day for the given pubkeys
+func (s *Storage) GetProfileChangeVelocity(ctx context.Context, pubkeys []string) (map[string]int, error) {
+	result := make(map[string]int)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil || len(pubkeys) == 0 {
+		return result, nil
+	}
+
+	err := s.query(ctx, dbConn, "GetProfileChangeVelocity", `
+		SELECT pubkey, changes_24h FROM profile_change_velocity WHERE pubkey = ANY($1)
+	`, pq.Array(pubkeys)).each(func(rows *sql.Rows) error {
+		var pubkey string
+		var changes int
+		if err := rows.Scan(&pubkey, &changes); err != nil {
+			return err
+		}
+		result[pubkey] = changes
+		return nil
+	})
+
+	return result, err
+}
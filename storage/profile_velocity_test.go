@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Velocity used to be computed from event_history, which only archives
+// trusted pubkeys, so churning bots were never counted. Every saved profile
+// now counts, whoever wrote it.
+func TestProfileChangeVelocityCountsEveryPubkey(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Every connection to :memory: is its own database
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`
+		CREATE TABLE profile_identities (pubkey TEXT PRIMARY KEY, identity TEXT NOT NULL, created_at INTEGER NOT NULL);
+		CREATE TABLE profile_changes (pubkey TEXT NOT NULL, hour INTEGER NOT NULL, changes INTEGER NOT NULL DEFAULT 0, PRIMARY KEY (pubkey, hour));
+	`); err != nil {
+		t.Fatal(err)
+	}
+	store := &Storage{analyticsDB: db}
+
+	now := time.Now()
+	at := func(ago time.Duration) nostr.Timestamp {
+		return nostr.Timestamp(now.Add(-ago).Unix())
+	}
+	bot := "b0"
+	user := "a0"
+	for _, evt := range []*nostr.Event{
+		{PubKey: bot, CreatedAt: at(3 * time.Hour), Content: `{"name":"alice"}`},
+		{PubKey: bot, CreatedAt: at(170 * time.Minute), Content: `{"name":"alice","about":"hi"}`}, // not an identity field
+		{PubKey: bot, CreatedAt: at(2 * time.Hour), Content: `{"name":"bob"}`},
+		{PubKey: bot, CreatedAt: at(150 * time.Minute), Content: `{"name":"carol"}`}, // older than the stored profile
+		{PubKey: bot, CreatedAt: at(time.Hour), Content: `{"name":"bob","picture":"https://x/p.png"}`},
+		{PubKey: bot, CreatedAt: at(time.Hour), Content: `{"name":"dave"}`}, // same second as the stored profile
+		{PubKey: user, CreatedAt: at(30 * time.Hour), Content: `{"name":"erin"}`},
+		{PubKey: user, CreatedAt: at(28 * time.Hour), Content: `{"name":"erin","nip05":"erin@x"}`}, // outside the window
+		{PubKey: user, CreatedAt: at(time.Hour), Content: `{"name":"frank","nip05":"erin@x"}`},
+	} {
+		if err := store.recordProfileChange(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	velocity, err := store.ComputeProfileChangeVelocity(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if velocity[bot] != 2 || velocity[user] != 1 || len(velocity) != 2 {
+		t.Fatalf("unexpected velocity: %v", velocity)
+	}
+
+	pruned, err := store.PruneProfileChanges(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Fatalf("expected the change outside the window to be pruned, got %d", pruned)
+	}
+}
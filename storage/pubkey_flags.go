@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// PubkeyFlags is everything trust and spam analysis has concluded about one
+// pubkey, for answering that pubkey's data access requests
+type PubkeyFlags struct {
+	Trusted          bool
+	TrustedAt        time.Time // when trust was last confirmed
+	TrustRevocations []TrustRevocation
+	BotClusters      []int64 // active bot clusters the pubkey is a member of
+	ClusterExempt    bool
+	SpamCandidate    *SpamCandidate
+	Impersonation    *ImpersonationCandidate
+	Reports          map[string]int // reports against the pubkey by type
+	Hydration        *HydrationOutcome
+}
+
+// GetPubkeyFlags gathers the trust, bot cluster, spam, impersonation, report
+// and hydration records held about pubkey
+func (s *Storage) GetPubkeyFlags(ctx context.Context, pubkey string) (*PubkeyFlags, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	flags := &PubkeyFlags{Reports: make(map[string]int)}
+
+	var trustedAt int64
+	err := s.query(ctx, dbConn, "GetPubkeyFlags: trusted", `
+		SELECT trusted_at FROM trusted_pubkeys WHERE pubkey = ?
+	`, pubkey).scan(&trustedAt)
+	switch {
+	case err == nil:
+		flags.Trusted = true
+		flags.TrustedAt = time.Unix(trustedAt, 0)
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	err = s.query(ctx, dbConn, "GetPubkeyFlags: revocations", `
+		SELECT signals, revoked_at FROM trust_revocations WHERE pubkey = ? ORDER BY revoked_at DESC
+	`, pubkey).each(func(rows *sql.Rows) error {
+		r := TrustRevocation{Pubkey: pubkey}
+		var signals string
+		var at int64
+		if err := rows.Scan(&signals, &at); err != nil {
+			return err
+		}
+		r.Signals = strings.Split(signals, ",")
+		r.RevokedAt = time.Unix(at, 0)
+		flags.TrustRevocations = append(flags.TrustRevocations, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.query(ctx, dbConn, "GetPubkeyFlags: clusters", `
+		SELECT bcm.cluster_id FROM bot_cluster_members bcm
+		JOIN bot_clusters bc ON bcm.cluster_id = bc.cluster_id
+		WHERE bcm.pubkey = ? AND bc.is_active = 1
+		ORDER BY bcm.cluster_id
+	`, pubkey).each(func(rows *sql.Rows) error {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		flags.BotClusters = append(flags.BotClusters, id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var exempt int
+	if err := s.query(ctx, dbConn, "GetPubkeyFlags: exemption", `
+		SELECT COUNT(*) FROM bot_cluster_exemptions WHERE pubkey = ?
+	`, pubkey).scan(&exempt); err != nil {
+		return nil, err
+	}
+	flags.ClusterExempt = exempt > 0
+
+	spam := SpamCandidate{Pubkey: pubkey}
+	var detectedAt int64
+	var purged int
+	err = s.query(ctx, dbConn, "GetPubkeyFlags: spam", `
+		SELECT detected_at, reason, event_count, purged FROM spam_candidates WHERE pubkey = ?
+	`, pubkey).scan(&detectedAt, &spam.Reason, &spam.EventCount, &purged)
+	switch {
+	case err == nil:
+		spam.DetectedAt = time.Unix(detectedAt, 0)
+		spam.Purged = purged == 1
+		flags.SpamCandidate = &spam
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	imp := ImpersonationCandidate{Pubkey: pubkey}
+	err = s.query(ctx, dbConn, "GetPubkeyFlags: impersonation", `
+		SELECT target_pubkey, name, picture, followers, target_followers, detected_at
+		FROM impersonation_candidates WHERE pubkey = ?
+	`, pubkey).scan(&imp.TargetPubkey, &imp.Name, &imp.Picture, &imp.Followers, &imp.TargetFollowers, &detectedAt)
+	switch {
+	case err == nil:
+		imp.DetectedAt = time.Unix(detectedAt, 0)
+		flags.Impersonation = &imp
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	// Only counts: who reported a pubkey is the reporters' data, not theirs
+	err = s.query(ctx, dbConn, "GetPubkeyFlags: reports", `
+		SELECT report_type, COUNT(*) FROM abuse_reports WHERE reported = ? GROUP BY report_type
+	`, pubkey).each(func(rows *sql.Rows) error {
+		var reportType string
+		var count int
+		if err := rows.Scan(&reportType, &count); err != nil {
+			return err
+		}
+		flags.Reports[reportType] = count
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	outcome := HydrationOutcome{Pubkey: pubkey}
+	var lastRound int64
+	err = s.query(ctx, dbConn, "GetPubkeyFlags: hydration", `
+		SELECT status, empty_rounds, last_round FROM hydration_outcomes WHERE pubkey = ?
+	`, pubkey).scan(&outcome.Status, &outcome.EmptyRounds, &lastRound)
+	switch {
+	case err == nil:
+		outcome.LastRound = time.Unix(lastRound, 0)
+		flags.Hydration = &outcome
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	return flags, nil
+}
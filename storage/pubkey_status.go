@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// PubkeyStatus is what a client needs to judge a pubkey at a glance
+type PubkeyStatus struct {
+	Trusted       bool
+	BotClusters   []int64 // active bot clusters the pubkey is a member of
+	SpamCandidate bool
+	Followers     int64
+	LastSeen      int64 // created_at of the pubkey's newest stored event, 0 if none
+}
+
+// GetPubkeyStatuses returns the trust, bot cluster, spam, follower and
+// activity status of each of pubkeys, including pubkeys we know nothing about
+func (s *Storage) GetPubkeyStatuses(ctx context.Context, pubkeys []string) (map[string]*PubkeyStatus, error) {
+	statuses := make(map[string]*PubkeyStatus, len(pubkeys))
+	for _, pk := range pubkeys {
+		statuses[pk] = &PubkeyStatus{}
+	}
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil || len(pubkeys) == 0 {
+		return statuses, nil
+	}
+	keys := pq.Array(pubkeys)
+
+	err := s.query(ctx, dbConn, "GetPubkeyStatuses: trusted", `
+		SELECT pubkey FROM trusted_pubkeys WHERE pubkey = ANY($1)
+	`, keys).each(func(rows *sql.Rows) error {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return err
+		}
+		statuses[pubkey].Trusted = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.query(ctx, dbConn, "GetPubkeyStatuses: clusters", `
+		SELECT bcm.pubkey, bcm.cluster_id FROM bot_cluster_members bcm
+		JOIN bot_clusters bc ON bcm.cluster_id = bc.cluster_id
+		WHERE bcm.pubkey = ANY($1) AND bc.is_active = 1
+		ORDER BY bcm.cluster_id
+	`, keys).each(func(rows *sql.Rows) error {
+		var pubkey string
+		var id int64
+		if err := rows.Scan(&pubkey, &id); err != nil {
+			return err
+		}
+		statuses[pubkey].BotClusters = append(statuses[pubkey].BotClusters, id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.query(ctx, dbConn, "GetPubkeyStatuses: spam", `
+		SELECT pubkey FROM spam_candidates WHERE pubkey = ANY($1)
+	`, keys).each(func(rows *sql.Rows) error {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return err
+		}
+		statuses[pubkey].SpamCandidate = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	followersQuery := `
+		SELECT followed, COUNT(*) FROM follows WHERE followed = ANY($1) GROUP BY followed`
+	if !s.followsIndexReady(ctx) {
+		followersQuery = `
+		SELECT pk, (
+			SELECT COUNT(*) FROM event
+			WHERE kind = 3 AND tags @> jsonb_build_array(jsonb_build_array('p', pk))
+		)
+		FROM unnest($1::text[]) AS pk`
+	}
+	err = s.query(ctx, dbConn, "GetPubkeyStatuses: followers", followersQuery, keys).each(func(rows *sql.Rows) error {
+		var pubkey string
+		var count int64
+		if err := rows.Scan(&pubkey, &count); err != nil {
+			return err
+		}
+		statuses[pubkey].Followers = count
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.query(ctx, dbConn, "GetPubkeyStatuses: last seen", `
+		SELECT pubkey, MAX(created_at) FROM event WHERE pubkey = ANY($1) GROUP BY pubkey
+	`, keys).each(func(rows *sql.Rows) error {
+		var pubkey string
+		var lastSeen int64
+		if err := rows.Scan(&pubkey, &lastSeen); err != nil {
+			return err
+		}
+		statuses[pubkey].LastSeen = lastSeen
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
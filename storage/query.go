@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// defaultQueryTimeout bounds a query whose context carries no deadline, so a
+// stuck connection can't hold up a background job or handler indefinitely.
+// Statements known to run longer, like table rebuilds, get a deadline of
+// their own from the caller.
+const defaultQueryTimeout = 30 * time.Second
+
+// QueryError is a failed storage query, named after the method that ran it
+type QueryError struct {
+	Query string
+	Err   error
+}
+
+func (e *QueryError) Error() string {
+	return e.Query + ": " + e.Err.Error()
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// queryErr names err after the query that failed, unless it already is
+func queryErr(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var qerr *QueryError
+	if errors.As(err, &qerr) {
+		return err
+	}
+	return &QueryError{Query: name, Err: err}
+}
+
+// withQueryTimeout bounds ctx by defaultQueryTimeout unless the caller set a
+// deadline of its own
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, defaultQueryTimeout)
+}
+
+// execer runs statements: a connection pool or a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqlQuery is a statement ready to run through exec, scan or each. Every run
+// is bounded by a timeout and its errors carry the query's name. Rows written
+// are counted towards the subsystem owning the table, see writeStats.
+type sqlQuery struct {
+	ctx    context.Context
+	db     execer
+	name   string
+	query  string
+	args   []any
+	writes *writeStats
+}
+
+// query prepares a statement on db, with ? placeholders rebound. name, usually
+// the calling method, prefixes any error the statement returns.
+func (s *Storage) query(ctx context.Context, db execer, name, query string, args ...any) *sqlQuery {
+	return &sqlQuery{ctx: ctx, db: db, name: name, query: s.rebind(query), args: args, writes: &s.writes}
+}
+
+// exec runs a statement returning no rows
+func (q *sqlQuery) exec() (sql.Result, error) {
+	ctx, cancel := withQueryTimeout(q.ctx)
+	defer cancel()
+
+	res, err := q.db.ExecContext(ctx, q.query, q.args...)
+	if err == nil {
+		if subsystem := q.writes.subsystem(q.query); subsystem != "" {
+			n, _ := res.RowsAffected()
+			q.writes.add(subsystem, n)
+		}
+	}
+	return res, queryErr(q.name, err)
+}
+
+// scan runs a query returning one row and scans it into dest. A missing row
+// is reported as a wrapped sql.ErrNoRows.
+func (q *sqlQuery) scan(dest ...any) error {
+	ctx, cancel := withQueryTimeout(q.ctx)
+	defer cancel()
+
+	err := q.db.QueryRowContext(ctx, q.query, q.args...).Scan(dest...)
+	if err == nil {
+		// Writes that return a row, like INSERT ... RETURNING
+		q.writes.add(q.writes.subsystem(q.query), 1)
+	}
+	return queryErr(q.name, err)
+}
+
+// rowFunc lends a scan method, like sqlQuery.scan, to helpers that take a row
+type rowFunc func(dest ...any) error
+
+func (f rowFunc) Scan(dest ...any) error {
+	return f(dest...)
+}
+
+// each runs a query and calls fn for every row, stopping at the first error
+// fn, the scan or the result set returns
+func (q *sqlQuery) each(fn func(rows *sql.Rows) error) error {
+	ctx, cancel := withQueryTimeout(q.ctx)
+	defer cancel()
+
+	rows, err := q.db.QueryContext(ctx, q.query, q.args...)
+	if err != nil {
+		return queryErr(q.name, err)
+	}
+	defer rows.Close()
+
+	var n int64
+	defer func() {
+		if n > 0 {
+			q.writes.add(q.writes.subsystem(q.query), n)
+		}
+	}()
+	for rows.Next() {
+		n++
+		if err := fn(rows); err != nil {
+			return queryErr(q.name, err)
+		}
+	}
+	return queryErr(q.name, rows.Err())
+}
+
+// inTx runs fn in a transaction on db, committing if it returns nil. The
+// transaction as a whole is bounded like a single query. Errors from fn are
+// returned as they are, so its statements name themselves and sentinel errors
+// still compare equal.
+func (s *Storage) inTx(ctx context.Context, db *sqlx.DB, name string, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return queryErr(name, err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	return queryErr(name, tx.Commit())
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type QuotaRejection struct {
+	Pubkey   string
+	Date     string
+	Accepted int64
+	Rejected int64
+	LastSeen time.Time
+}
+
+// GetPubkeyQuotaUsage returns how many events from pubkey were accepted today (UTC)
+func (s *Storage) GetPubkeyQuotaUsage(ctx context.Context, pubkey string) (int64, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return 0, nil
+	}
+
+	var accepted int64
+	err := s.query(ctx, dbConn, "GetPubkeyQuotaUsage", `
+		SELECT COALESCE(MAX(accepted), 0) FROM pubkey_daily_quota WHERE date = ? AND pubkey = ?
+	`, quotaDate(), pubkey).scan(&accepted)
+
+	return accepted, err
+}
+
+// RecordQuotaAccepted counts an accepted event against the pubkey's daily quota
+func (s *Storage) RecordQuotaAccepted(ctx context.Context, pubkey string) error {
+	return s.bumpQuota(ctx, pubkey, 1, 0)
+}
+
+// RecordQuotaRejected records an event refused because the daily quota was exhausted
+func (s *Storage) RecordQuotaRejected(ctx context.Context, pubkey string) error {
+	return s.bumpQuota(ctx, pubkey, 0, 1)
+}
+
+func (s *Storage) bumpQuota(ctx context.Context, pubkey string, accepted, rejected int64) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "bumpQuota", `
+		INSERT INTO pubkey_daily_quota (date, pubkey, accepted, rejected, last_seen)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(date, pubkey) DO UPDATE SET
+			accepted = pubkey_daily_quota.accepted + excluded.accepted,
+			rejected = pubkey_daily_quota.rejected + excluded.rejected,
+			last_seen = excluded.last_seen
+	`, quotaDate(), pubkey, accepted, rejected, time.Now().Unix()).exec()
+
+	return err
+}
+
+// GetQuotaRejections returns the pubkeys that hit their quota most over the last N days
+func (s *Storage) GetQuotaRejections(ctx context.Context, days, limit int) ([]QuotaRejection, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02")
+	var results []QuotaRejection
+	err := s.query(ctx, dbConn, "GetQuotaRejections", `
+		SELECT pubkey, date, accepted, rejected, last_seen
+		FROM pubkey_daily_quota
+		WHERE rejected > 0 AND date > ?
+		ORDER BY rejected DESC
+		LIMIT ?
+	`, since, limit).each(func(rows *sql.Rows) error {
+		var r QuotaRejection
+		var lastSeen int64
+		if err := rows.Scan(&r.Pubkey, &r.Date, &r.Accepted, &r.Rejected, &lastSeen); err != nil {
+			return err
+		}
+		r.LastSeen = time.Unix(lastSeen, 0)
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// GetQuotaRejectedTotal returns the number of quota rejections over the last N days
+func (s *Storage) GetQuotaRejectedTotal(ctx context.Context, days int) (int64, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return 0, nil
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02")
+	var total int64
+	err := s.query(ctx, dbConn, "GetQuotaRejectedTotal", `
+		SELECT COALESCE(SUM(rejected), 0) FROM pubkey_daily_quota WHERE date > ?
+	`, since).scan(&total)
+
+	return total, err
+}
+
+// CleanupQuotas removes quota counters older than the given number of days
+func (s *Storage) CleanupQuotas(ctx context.Context, days int) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02")
+	_, err := s.query(ctx, dbConn, "CleanupQuotas", `DELETE FROM pubkey_daily_quota WHERE date < ?`, cutoff).exec()
+	return err
+}
+
+func quotaDate() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Relay capability flags detected from CLOSED reasons during syncing
+const (
+	RelayAuthRequired = "auth_required"
+	RelayRestricted   = "restricted"
+)
+
+// RelayCapabilityTTL is how long a flag holds before the relay is tried anonymously again
+const RelayCapabilityTTL = 7 * 24 * time.Hour
+
+type RelayCapability struct {
+	URL        string
+	Capability string
+	Reason     string
+	DetectedAt time.Time
+}
+
+// MarkRelayCapability flags a relay as needing NIP-42 auth or refusing us outright
+func (s *Storage) MarkRelayCapability(ctx context.Context, url, capability, reason string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "MarkRelayCapability", `
+		INSERT INTO relay_capabilities (url, capability, reason, detected_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			capability = excluded.capability,
+			reason = excluded.reason,
+			detected_at = excluded.detected_at
+	`, url, capability, reason, time.Now().Unix()).exec()
+
+	return err
+}
+
+// ClearRelayCapability removes a relay's flag once it served us normally
+func (s *Storage) ClearRelayCapability(ctx context.Context, url string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "ClearRelayCapability", `DELETE FROM relay_capabilities WHERE url = ?`, url).exec()
+	return err
+}
+
+// GetRelayCapabilities returns relay flags detected at or after since, keyed by URL
+func (s *Storage) GetRelayCapabilities(ctx context.Context, since time.Time) (map[string]RelayCapability, error) {
+	result := make(map[string]RelayCapability)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return result, nil
+	}
+
+	err := s.query(ctx, dbConn, "GetRelayCapabilities", `
+		SELECT url, capability, reason, detected_at
+		FROM relay_capabilities
+		WHERE detected_at >= ?
+	`, since.Unix()).each(func(rows *sql.Rows) error {
+		var c RelayCapability
+		var detectedAt int64
+		if err := rows.Scan(&c.URL, &c.Capability, &c.Reason, &detectedAt); err != nil {
+			return err
+		}
+		c.DetectedAt = time.Unix(detectedAt, 0)
+		result[c.URL] = c
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// RelayAuth counts our NIP-42 authentication attempts against an upstream relay
+type RelayAuth struct {
+	URL           string
+	Successes     int64
+	Failures      int64
+	LastError     string // empty when the last attempt succeeded
+	LastAttemptAt time.Time
+}
+
+// RecordRelayAuth records the outcome of authenticating to a relay; authErr is nil on success
+func (s *Storage) RecordRelayAuth(ctx context.Context, url string, authErr error) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	successes, failures, lastError := 1, 0, ""
+	if authErr != nil {
+		successes, failures, lastError = 0, 1, authErr.Error()
+	}
+
+	_, err := s.query(ctx, dbConn, "RecordRelayAuth", `
+		INSERT INTO relay_auth (url, successes, failures, last_error, last_attempt_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			successes = relay_auth.successes + excluded.successes,
+			failures = relay_auth.failures + excluded.failures,
+			last_error = excluded.last_error,
+			last_attempt_at = excluded.last_attempt_at
+	`, url, successes, failures, lastError, time.Now().Unix()).exec()
+
+	return err
+}
+
+// GetRelayAuth returns authentication outcomes keyed by relay URL
+func (s *Storage) GetRelayAuth(ctx context.Context) (map[string]RelayAuth, error) {
+	result := make(map[string]RelayAuth)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return result, nil
+	}
+
+	err := s.query(ctx, dbConn, "GetRelayAuth", `
+		SELECT url, successes, failures, last_error, last_attempt_at FROM relay_auth
+	`).each(func(rows *sql.Rows) error {
+		var a RelayAuth
+		var lastAttemptAt int64
+		if err := rows.Scan(&a.URL, &a.Successes, &a.Failures, &a.LastError, &lastAttemptAt); err != nil {
+			return err
+		}
+		a.LastAttemptAt = time.Unix(lastAttemptAt, 0)
+		result[a.URL] = a
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RelayCensusWindow is how recently a relay's NIP-11 document must have been
+// fetched for the relay to be counted in the census
+const RelayCensusWindow = 30 * 24 * time.Hour
+
+// RelayInfo is what a relay's NIP-11 document said about it at FetchedAt, or
+// why it couldn't be fetched
+type RelayInfo struct {
+	URL           string
+	Name          string
+	Software      string // normalized, e.g. "strfry" rather than its repository URL
+	Version       string
+	SupportedNIPs []int
+	FetchedAt     time.Time
+	Error         string
+}
+
+// CensusSoftware counts relays running one version of a relay implementation
+type CensusSoftware struct {
+	Software string `json:"software"`
+	Version  string `json:"version"`
+	Relays   int    `json:"relays"`
+}
+
+// CensusNIP counts relays claiming support for a NIP, overall and per implementation
+type CensusNIP struct {
+	NIP        int            `json:"nip"`
+	Relays     int            `json:"relays"`
+	BySoftware map[string]int `json:"by_software"`
+}
+
+// RelayCensus is the software and NIP support of every discovered relay that
+// answered NIP-11 within RelayCensusWindow
+type RelayCensus struct {
+	GeneratedAt     int64            `json:"generated_at"` // newest fetch counted
+	Relays          int              `json:"relays"`
+	Unreachable     int              `json:"unreachable"`
+	SoftwareTotals  map[string]int   `json:"software_totals"`
+	SoftwareVersion []CensusSoftware `json:"software_versions"`
+	NIPs            []CensusNIP      `json:"nips"`
+}
+
+func (s *Storage) InitRelayCensusSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS relay_info (
+		url TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		software TEXT NOT NULL DEFAULT '',
+		version TEXT NOT NULL DEFAULT '',
+		supported_nips INTEGER[] NOT NULL DEFAULT '{}',
+		fetched_at INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_relay_info_fetched ON relay_info(fetched_at);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// SaveRelayInfo records the outcome of fetching a relay's NIP-11 document
+func (s *Storage) SaveRelayInfo(ctx context.Context, info RelayInfo) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	nips := info.SupportedNIPs
+	if nips == nil {
+		nips = []int{}
+	}
+	_, err := s.query(ctx, dbConn, "SaveRelayInfo", `
+		INSERT INTO relay_info (url, name, software, version, supported_nips, fetched_at, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			name = excluded.name,
+			software = excluded.software,
+			version = excluded.version,
+			supported_nips = excluded.supported_nips,
+			fetched_at = excluded.fetched_at,
+			error = excluded.error
+	`, info.URL, info.Name, info.Software, info.Version, pq.Array(nips), info.FetchedAt.Unix(), info.Error).exec()
+	return err
+}
+
+// GetRelayCensusTargets returns up to limit active discovered relays whose
+// NIP-11 document is due, never fetched first and then the longest ago
+func (s *Storage) GetRelayCensusTargets(ctx context.Context, olderThan time.Time, limit int) ([]string, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var urls []string
+	err := s.query(ctx, dbConn, "GetRelayCensusTargets", `
+		SELECT d.url
+		FROM discovered_relays d
+		LEFT JOIN relay_info i ON i.url = d.url
+		WHERE d.is_active = 1 AND (i.fetched_at IS NULL OR i.fetched_at < ?)
+		ORDER BY i.fetched_at ASC NULLS FIRST
+		LIMIT ?
+	`, olderThan.Unix(), limit).each(func(rows *sql.Rows) error {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return err
+		}
+		urls = append(urls, url)
+		return nil
+	})
+	return urls, err
+}
+
+// GetRelayCensus tallies the NIP-11 documents fetched within RelayCensusWindow
+func (s *Storage) GetRelayCensus(ctx context.Context) (*RelayCensus, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	census := &RelayCensus{SoftwareTotals: make(map[string]int)}
+	versions := make(map[CensusSoftware]int)
+	nips := make(map[int]*CensusNIP)
+
+	err := s.query(ctx, dbConn, "GetRelayCensus", `
+		SELECT software, version, supported_nips, fetched_at, error
+		FROM relay_info
+		WHERE fetched_at >= ?
+	`, time.Now().Add(-RelayCensusWindow).Unix()).each(func(rows *sql.Rows) error {
+		var software, version, fetchErr string
+		var supported pq.Int64Array
+		var fetchedAt int64
+		if err := rows.Scan(&software, &version, &supported, &fetchedAt, &fetchErr); err != nil {
+			return err
+		}
+		if fetchedAt > census.GeneratedAt {
+			census.GeneratedAt = fetchedAt
+		}
+		if fetchErr != "" {
+			census.Unreachable++
+			return nil
+		}
+
+		census.Relays++
+		if software == "" {
+			software = "unknown"
+		}
+		census.SoftwareTotals[software]++
+		versions[CensusSoftware{Software: software, Version: version}]++
+		for _, n := range supported {
+			nip, ok := nips[int(n)]
+			if !ok {
+				nip = &CensusNIP{NIP: int(n), BySoftware: make(map[string]int)}
+				nips[int(n)] = nip
+			}
+			nip.Relays++
+			nip.BySoftware[software]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for sv, count := range versions {
+		sv.Relays = count
+		census.SoftwareVersion = append(census.SoftwareVersion, sv)
+	}
+	// Most deployed implementations first, then their most deployed versions
+	sort.Slice(census.SoftwareVersion, func(i, j int) bool {
+		a, b := census.SoftwareVersion[i], census.SoftwareVersion[j]
+		if a.Software != b.Software {
+			ta, tb := census.SoftwareTotals[a.Software], census.SoftwareTotals[b.Software]
+			if ta != tb {
+				return ta > tb
+			}
+			return a.Software < b.Software
+		}
+		if a.Relays != b.Relays {
+			return a.Relays > b.Relays
+		}
+		return a.Version < b.Version
+	})
+
+	for _, nip := range nips {
+		census.NIPs = append(census.NIPs, *nip)
+	}
+	sort.Slice(census.NIPs, func(i, j int) bool { return census.NIPs[i].NIP < census.NIPs[j].NIP })
+
+	return census, nil
+}
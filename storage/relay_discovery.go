@@ -2,16 +2,37 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/fiatjaf/eventstore/postgresql"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/nbd-wtf/go-nostr"
 )
 
+// Where a discovered relay was first found, or since then somewhere more
+// telling. The sync queue prefers relays from relay lists over search relays,
+// and those over relays only another relay's NIP-11 document points to.
+const (
+	RelaySourceRelayList  = "relay_list"  // kind 10002 relay lists
+	RelaySourceSearchList = "search_list" // kind 10007 search relay lists
+	RelaySourceNIP11      = "nip11"       // NIP-11 documents of known relays
+)
+
+// relaySourceRank orders a source column by RelaySource* preference
+const relaySourceRank = `CASE %s WHEN 'relay_list' THEN 0 WHEN 'search_list' THEN 1 ELSE 2 END`
+
+// RelayBlockThreshold is how many users must list a relay in their kind 10006
+// blocked relays before the sync queue leaves it for last
+const RelayBlockThreshold = 3
+
 type DiscoveredRelay struct {
 	URL               string
+	Source            string
+	BlockedBy         int64 // users listing the relay in their blocked relays
 	FirstSeen         time.Time
 	LastSync          time.Time
 	SyncAttempts      int64
@@ -27,13 +48,8 @@ func (s *Storage) getDBConn() *sqlx.DB {
 		return s.analyticsDB
 	}
 
-	// Fallback: try to extract from eventstore
-	switch db := s.db.(type) {
-	case *postgresql.PostgresBackend:
-		return db.DB
-	default:
-		return nil
-	}
+	// Fallback: share the event store's connection when it is SQL-backed
+	return s.db.SQL()
 }
 
 // isPostgres always returns true since we only support PostgreSQL/LMDB
@@ -70,26 +86,75 @@ func (s *Storage) InitRelayDiscoverySchema() error {
 
 	CREATE INDEX IF NOT EXISTS idx_last_sync ON discovered_relays(last_sync);
 	CREATE INDEX IF NOT EXISTS idx_is_active ON discovered_relays(is_active);
+	ALTER TABLE discovered_relays ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'relay_list';
+
+	CREATE TABLE IF NOT EXISTS relay_blocks (
+		pubkey TEXT NOT NULL,
+		url TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (pubkey, url)
+	);
+	CREATE INDEX IF NOT EXISTS idx_relay_blocks_url ON relay_blocks(url);
 	`
 
 	_, err := dbConn.Exec(schema)
 	return err
 }
 
-func (s *Storage) AddDiscoveredRelay(ctx context.Context, url string) error {
+// addDiscoveredRelayQuery inserts a relay, or moves a known relay to a
+// preferred source; it returns whether the relay is new
+var addDiscoveredRelayQuery = `
+	INSERT INTO discovered_relays (url, first_seen, is_active, source)
+	VALUES ($1, $2, 1, $3)
+	ON CONFLICT(url) DO UPDATE SET source = excluded.source
+	WHERE ` + fmt.Sprintf(relaySourceRank, "excluded.source") + ` < ` + fmt.Sprintf(relaySourceRank, "discovered_relays.source") + `
+	RETURNING xmax = 0`
+
+func (s *Storage) AddDiscoveredRelay(ctx context.Context, url, source string) error {
 	dbConn := s.getDBConn()
 	if dbConn == nil {
 		return nil
 	}
 
 	now := time.Now().Unix()
-	_, err := dbConn.ExecContext(ctx, s.rebind(`
-		INSERT INTO discovered_relays (url, first_seen, is_active)
-		VALUES (?, ?, 1)
-		ON CONFLICT(url) DO NOTHING
-	`), url, now)
+	return s.query(ctx, dbConn, "AddDiscoveredRelay", addDiscoveredRelayQuery, url, now, source).each(func(rows *sql.Rows) error {
+		return nil
+	})
+}
 
-	return err
+// AddDiscoveredRelays inserts many relay URLs found in source in one
+// transaction and returns how many were new
+func (s *Storage) AddDiscoveredRelays(ctx context.Context, urls []string, source string) (int64, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil || len(urls) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now().Unix()
+	var added int64
+	err := s.inTx(ctx, dbConn, "AddDiscoveredRelays", func(ctx context.Context, tx *sqlx.Tx) error {
+		for _, url := range urls {
+			err := s.query(ctx, tx, "AddDiscoveredRelays", addDiscoveredRelayQuery, url, now, source).each(func(rows *sql.Rows) error {
+				var inserted bool
+				if err := rows.Scan(&inserted); err != nil {
+					return err
+				}
+				if inserted {
+					added++
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return added, nil
 }
 
 func (s *Storage) GetRelayQueue(ctx context.Context) ([]DiscoveredRelay, error) {
@@ -98,26 +163,23 @@ func (s *Storage) GetRelayQueue(ctx context.Context) ([]DiscoveredRelay, error)
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, `
-		SELECT url, first_seen, last_sync, sync_attempts, sync_successes, events_contributed, is_active
-		FROM discovered_relays
-		WHERE is_active = 1
-		ORDER BY last_sync ASC
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
+	// Relays enough users block go last, then by source, least recently synced first
 	var relays []DiscoveredRelay
-	for rows.Next() {
+	err := s.query(ctx, dbConn, "GetRelayQueue", `
+		SELECT dr.url, dr.first_seen, dr.last_sync, dr.sync_attempts, dr.sync_successes, dr.events_contributed, dr.is_active,
+			dr.source, COALESCE(rb.blocked_by, 0)
+		FROM discovered_relays dr
+		LEFT JOIN (SELECT url, COUNT(*) AS blocked_by FROM relay_blocks GROUP BY url) rb ON rb.url = dr.url
+		WHERE dr.is_active = 1
+		ORDER BY COALESCE(rb.blocked_by, 0) >= $1, `+fmt.Sprintf(relaySourceRank, "dr.source")+`, dr.last_sync ASC
+	`, RelayBlockThreshold).each(func(rows *sql.Rows) error {
 		var r DiscoveredRelay
 		var firstSeen, lastSync int64
 		var isActive int
 
-		err := rows.Scan(&r.URL, &firstSeen, &lastSync, &r.SyncAttempts, &r.SyncSuccesses, &r.EventsContributed, &isActive)
+		err := rows.Scan(&r.URL, &firstSeen, &lastSync, &r.SyncAttempts, &r.SyncSuccesses, &r.EventsContributed, &isActive, &r.Source, &r.BlockedBy)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		r.FirstSeen = time.Unix(firstSeen, 0)
@@ -125,9 +187,13 @@ func (s *Storage) GetRelayQueue(ctx context.Context) ([]DiscoveredRelay, error)
 		r.IsActive = isActive == 1
 
 		relays = append(relays, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return relays, rows.Err()
+	return relays, nil
 }
 
 func (s *Storage) UpdateSyncStats(ctx context.Context, url string, success bool, eventsContributed int64) error {
@@ -139,27 +205,27 @@ func (s *Storage) UpdateSyncStats(ctx context.Context, url string, success bool,
 	now := time.Now().Unix()
 
 	if success {
-		_, err := dbConn.ExecContext(ctx, s.rebind(`
+		_, err := s.query(ctx, dbConn, "UpdateSyncStats", `
 			UPDATE discovered_relays
 			SET last_sync = ?,
 			    sync_attempts = sync_attempts + 1,
 			    sync_successes = sync_successes + 1,
 			    events_contributed = events_contributed + ?
 			WHERE url = ?
-		`), now, eventsContributed, url)
+		`, now, eventsContributed, url).exec()
 		return err
 	}
 
-	_, err := dbConn.ExecContext(ctx, s.rebind(`
+	_, err := s.query(ctx, dbConn, "UpdateSyncStats", `
 		UPDATE discovered_relays
 		SET sync_attempts = sync_attempts + 1
 		WHERE url = ?
-	`), url)
+	`, url).exec()
 	return err
 }
 
 func (s *Storage) GetRelayStats(ctx context.Context) ([]DiscoveredRelay, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
@@ -180,9 +246,11 @@ func (s *Storage) GetRelayStats(ctx context.Context) ([]DiscoveredRelay, error)
 		)
 		SELECT
 			dr.url, dr.first_seen, dr.last_sync, dr.sync_attempts, dr.sync_successes,
-			dr.events_contributed, dr.is_active, COALESCE(rpc.pubkey_count, 0)
+			dr.events_contributed, dr.is_active, COALESCE(rpc.pubkey_count, 0),
+			dr.source, COALESCE(rb.blocked_by, 0)
 		FROM discovered_relays dr
 		LEFT JOIN relay_pubkey_counts rpc ON dr.url = rpc.relay_url
+		LEFT JOIN (SELECT url, COUNT(*) AS blocked_by FROM relay_blocks GROUP BY url) rb ON rb.url = dr.url
 		ORDER BY COALESCE(rpc.pubkey_count, 0) DESC, dr.events_contributed DESC`
 	} else {
 		query = `
@@ -198,26 +266,22 @@ func (s *Storage) GetRelayStats(ctx context.Context) ([]DiscoveredRelay, error)
 		)
 		SELECT
 			dr.url, dr.first_seen, dr.last_sync, dr.sync_attempts, dr.sync_successes,
-			dr.events_contributed, dr.is_active, COALESCE(rpc.pubkey_count, 0)
+			dr.events_contributed, dr.is_active, COALESCE(rpc.pubkey_count, 0),
+			dr.source, COALESCE(rb.blocked_by, 0)
 		FROM discovered_relays dr
 		LEFT JOIN relay_pubkey_counts rpc ON dr.url = rpc.relay_url
+		LEFT JOIN (SELECT url, COUNT(*) AS blocked_by FROM relay_blocks GROUP BY url) rb ON rb.url = dr.url
 		ORDER BY COALESCE(rpc.pubkey_count, 0) DESC, dr.events_contributed DESC`
 	}
-	rows, err := dbConn.QueryContext(ctx, query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	var relays []DiscoveredRelay
-	for rows.Next() {
+	err := s.query(ctx, dbConn, "GetRelayStats", query).each(func(rows *sql.Rows) error {
 		var r DiscoveredRelay
 		var firstSeen, lastSync int64
 		var isActive int
 
-		err := rows.Scan(&r.URL, &firstSeen, &lastSync, &r.SyncAttempts, &r.SyncSuccesses, &r.EventsContributed, &isActive, &r.PubkeyCount)
+		err := rows.Scan(&r.URL, &firstSeen, &lastSync, &r.SyncAttempts, &r.SyncSuccesses, &r.EventsContributed, &isActive, &r.PubkeyCount, &r.Source, &r.BlockedBy)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		r.FirstSeen = time.Unix(firstSeen, 0)
@@ -225,61 +289,59 @@ func (s *Storage) GetRelayStats(ctx context.Context) ([]DiscoveredRelay, error)
 		r.IsActive = isActive == 1
 
 		relays = append(relays, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return relays, rows.Err()
+	return relays, nil
 }
 
-func (s *Storage) GetDiscoveredRelayCount(ctx context.Context) (int64, error) {
+// SetRelayBlocks replaces the relays pubkey blocks with urls, from its kind
+// 10006 event created at createdAt, unless a newer list is already recorded
+func (s *Storage) SetRelayBlocks(ctx context.Context, pubkey string, urls []string, createdAt int64) error {
 	dbConn := s.getDBConn()
 	if dbConn == nil {
-		return 0, nil
+		return nil
 	}
 
-	var count int64
-	err := dbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM discovered_relays`).Scan(&count)
-	return count, err
+	return s.inTx(ctx, dbConn, "SetRelayBlocks", func(ctx context.Context, tx *sqlx.Tx) error {
+		var newest int64
+		err := s.query(ctx, tx, "SetRelayBlocks: newest", `
+			SELECT COALESCE(MAX(created_at), 0) FROM relay_blocks WHERE pubkey = ?
+		`, pubkey).scan(&newest)
+		if err != nil {
+			return err
+		}
+		if newest > createdAt {
+			return nil
+		}
+
+		if _, err := s.query(ctx, tx, "SetRelayBlocks: clear", `DELETE FROM relay_blocks WHERE pubkey = ?`, pubkey).exec(); err != nil {
+			return err
+		}
+		if len(urls) == 0 {
+			return nil
+		}
+		_, err = s.query(ctx, tx, "SetRelayBlocks: insert", `
+			INSERT INTO relay_blocks (pubkey, url, created_at)
+			SELECT $1, unnest($2::text[]), $3
+			ON CONFLICT DO NOTHING
+		`, pubkey, pq.Array(urls), createdAt).exec()
+		return err
+	})
 }
 
-// GetRawRelayURLsFromEvents returns all relay URLs from kind 10002 events (not normalized)
-func (s *Storage) GetRawRelayURLsFromEvents(ctx context.Context) ([]string, error) {
-	dbConn := s.getDBConn()
+func (s *Storage) GetDiscoveredRelayCount(ctx context.Context) (int64, error) {
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
-		return nil, nil
-	}
-
-	var query string
-	if s.isPostgres() {
-		query = `
-			SELECT DISTINCT tag->>1
-			FROM event, jsonb_array_elements(event.tags) as tag
-			WHERE event.kind = 10002
-			  AND tag->>0 = 'r'
-			  AND tag->>1 IS NOT NULL`
-	} else {
-		query = `
-			SELECT DISTINCT json_extract(tag.value, '$[1]')
-			FROM event, json_each(event.tags) as tag
-			WHERE event.kind = 10002
-			  AND json_extract(tag.value, '$[0]') = 'r'
-			  AND json_extract(tag.value, '$[1]') IS NOT NULL`
-	}
-	rows, err := dbConn.QueryContext(ctx, query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var urls []string
-	for rows.Next() {
-		var url string
-		if err := rows.Scan(&url); err != nil {
-			return nil, err
-		}
-		urls = append(urls, url)
+		return 0, nil
 	}
 
-	return urls, rows.Err()
+	var count int64
+	err := s.query(ctx, dbConn, "GetDiscoveredRelayCount", `SELECT COUNT(*) FROM discovered_relays`).scan(&count)
+	return count, err
 }
 
 func (s *Storage) InitProfileHydrationSchema() error {
@@ -298,6 +360,15 @@ func (s *Storage) InitProfileHydrationSchema() error {
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_last_attempt ON profile_fetch_attempts(last_attempt);
+
+	CREATE TABLE IF NOT EXISTS hydration_outcomes (
+		pubkey TEXT PRIMARY KEY,
+		status TEXT NOT NULL,
+		empty_rounds INTEGER NOT NULL DEFAULT 0,
+		last_round INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_hydration_outcomes_status ON hydration_outcomes(status, last_round);
 	`
 
 	_, err := dbConn.Exec(schema)
@@ -321,11 +392,11 @@ func (s *Storage) GetProfileFetchAttempt(ctx context.Context, pubkey string) (*P
 	var attempt ProfileFetchAttempt
 	var k0, k3, k10002 int
 
-	err := dbConn.QueryRowContext(ctx, s.rebind(`
+	err := s.query(ctx, dbConn, "GetProfileFetchAttempt", `
 		SELECT pubkey, last_attempt, fetched_kind_0, fetched_kind_3, fetched_kind_10002
 		FROM profile_fetch_attempts
 		WHERE pubkey = ?
-	`), pubkey).Scan(&attempt.Pubkey, &attempt.LastAttempt, &k0, &k3, &k10002)
+	`, pubkey).scan(&attempt.Pubkey, &attempt.LastAttempt, &k0, &k3, &k10002)
 
 	if err != nil {
 		return nil, nil
@@ -356,7 +427,7 @@ func (s *Storage) RecordProfileFetchAttempt(ctx context.Context, pubkey string,
 		k10002 = 1
 	}
 
-	_, err := dbConn.ExecContext(ctx, s.rebind(`
+	_, err := s.query(ctx, dbConn, "RecordProfileFetchAttempt", `
 		INSERT INTO profile_fetch_attempts (pubkey, last_attempt, fetched_kind_0, fetched_kind_3, fetched_kind_10002)
 		VALUES (?, ?, ?, ?, ?)
 		ON CONFLICT(pubkey) DO UPDATE SET
@@ -364,47 +435,41 @@ func (s *Storage) RecordProfileFetchAttempt(ctx context.Context, pubkey string,
 			fetched_kind_0 = CASE WHEN excluded.fetched_kind_0 = 1 THEN 1 ELSE profile_fetch_attempts.fetched_kind_0 END,
 			fetched_kind_3 = CASE WHEN excluded.fetched_kind_3 = 1 THEN 1 ELSE profile_fetch_attempts.fetched_kind_3 END,
 			fetched_kind_10002 = CASE WHEN excluded.fetched_kind_10002 = 1 THEN 1 ELSE profile_fetch_attempts.fetched_kind_10002 END
-	`), pubkey, now, k0, k3, k10002)
+	`, pubkey, now, k0, k3, k10002).exec()
 
 	return err
 }
 
 func (s *Storage) GetProfileFetchAttemptCount(ctx context.Context) (int64, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return 0, nil
 	}
 
 	var count int64
-	err := dbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM profile_fetch_attempts`).Scan(&count)
+	err := s.query(ctx, dbConn, "GetProfileFetchAttemptCount", `SELECT COUNT(*) FROM profile_fetch_attempts`).scan(&count)
 	return count, err
 }
 
 func (s *Storage) GetRecentProfileFetchAttempts(ctx context.Context, limit int) ([]ProfileFetchAttempt, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var attempts []ProfileFetchAttempt
+	err := s.query(ctx, dbConn, "GetRecentProfileFetchAttempts", `
 		SELECT pubkey, last_attempt, fetched_kind_0, fetched_kind_3, fetched_kind_10002
 		FROM profile_fetch_attempts
 		ORDER BY last_attempt DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var attempts []ProfileFetchAttempt
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var attempt ProfileFetchAttempt
 		var k0, k3, k10002 int
 
 		err := rows.Scan(&attempt.Pubkey, &attempt.LastAttempt, &k0, &k3, &k10002)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		attempt.FetchedKind0 = k0 == 1
@@ -412,9 +477,13 @@ func (s *Storage) GetRecentProfileFetchAttempts(ctx context.Context, limit int)
 		attempt.FetchedKind10002 = k10002 == 1
 
 		attempts = append(attempts, attempt)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return attempts, rows.Err()
+	return attempts, nil
 }
 
 func (s *Storage) BackupTo(destPath string) error {
@@ -430,11 +499,33 @@ func (s *Storage) BackupTo(destPath string) error {
 }
 
 func (s *Storage) GetFollowerCounts(ctx context.Context, minFollowers int) (map[string]int, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
+	if s.followsIndexReady(ctx) {
+		followerCounts := make(map[string]int)
+		err := s.query(ctx, dbConn, "GetFollowerCounts", `
+			SELECT followed, COUNT(*) FROM follows
+			GROUP BY followed
+			HAVING COUNT(*) >= $1
+		`, minFollowers).each(func(rows *sql.Rows) error {
+			var pubkey string
+			var count int
+			if err := rows.Scan(&pubkey, &count); err != nil {
+				return err
+			}
+			followerCounts[pubkey] = count
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return followerCounts, nil
+	}
+
 	// Optimized query: find latest kind 3 per author, extract p tags, count followers
 	var query string
 	if s.isPostgres() {
@@ -483,30 +574,36 @@ func (s *Storage) GetFollowerCounts(ctx context.Context, minFollowers int) (map[
 		HAVING follower_count >= ?`
 	}
 
-	rows, err := dbConn.QueryContext(ctx, query, minFollowers)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	followerCounts := make(map[string]int)
-	for rows.Next() {
+	err := s.query(ctx, dbConn, "GetFollowerCounts", query, minFollowers).each(func(rows *sql.Rows) error {
 		var pubkey string
 		var count int
 		if err := rows.Scan(&pubkey, &count); err != nil {
-			return nil, err
+			return err
 		}
 		followerCounts[pubkey] = count
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return followerCounts, rows.Err()
+	return followerCounts, nil
 }
 
+// pubkeyKindsChunk is how many pubkeys one CheckPubkeyEventKinds query asks
+// for, keeping each query and its plan bounded however many pubkeys come in
+const pubkeyKindsChunk = 500
+
+// PubkeyEventKinds is which of the kinds asked about a pubkey has events of
 type PubkeyEventKinds struct {
-	Pubkey        string
-	HasKind0      bool
-	HasKind3      bool
-	HasKind10002  bool
+	Pubkey string
+	Kinds  map[int]bool
+}
+
+// Has reports whether the pubkey has events of kind
+func (k PubkeyEventKinds) Has(kind int) bool {
+	return k.Kinds[kind]
 }
 
 func (s *Storage) InitTrustedSyncSchema() error {
@@ -533,6 +630,34 @@ func (s *Storage) InitTrustedSyncSchema() error {
 
 	CREATE INDEX IF NOT EXISTS idx_trusted_sync_relay_stats_relay ON trusted_sync_relay_stats(relay_url);
 	CREATE INDEX IF NOT EXISTS idx_trusted_sync_relay_stats_pubkey ON trusted_sync_relay_stats(pubkey);
+
+	CREATE TABLE IF NOT EXISTS trusted_sync_cycles (
+		id INTEGER PRIMARY KEY,
+		total INTEGER NOT NULL DEFAULT 0,
+		processed INTEGER NOT NULL DEFAULT 0,
+		completed_at INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS trusted_sync_cycle_progress (
+		cycle_id INTEGER NOT NULL,
+		pubkey TEXT NOT NULL,
+		PRIMARY KEY (cycle_id, pubkey)
+	);
+
+	CREATE TABLE IF NOT EXISTS relay_capabilities (
+		url TEXT PRIMARY KEY,
+		capability TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		detected_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS relay_auth (
+		url TEXT PRIMARY KEY,
+		successes INTEGER NOT NULL DEFAULT 0,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		last_attempt_at INTEGER NOT NULL
+	);
 	`
 
 	_, err := dbConn.Exec(schema)
@@ -588,22 +713,20 @@ func (s *Storage) GetTrustedSyncQueue(ctx context.Context, pubkeys []string, lim
 		LIMIT ?`
 	}
 
-	rows, err := dbConn.QueryContext(ctx, query, pubkeysJSON, limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	var states []TrustedSyncState
-	for rows.Next() {
+	err := s.query(ctx, dbConn, "GetTrustedSyncQueue", query, pubkeysJSON, limit).each(func(rows *sql.Rows) error {
 		var state TrustedSyncState
 		if err := rows.Scan(&state.Pubkey, &state.LastSyncedAt); err != nil {
-			return nil, err
+			return err
 		}
 		states = append(states, state)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return states, rows.Err()
+	return states, nil
 }
 
 func (s *Storage) UpdateTrustedSyncState(ctx context.Context, pubkey string) error {
@@ -613,11 +736,11 @@ func (s *Storage) UpdateTrustedSyncState(ctx context.Context, pubkey string) err
 	}
 
 	now := time.Now().Unix()
-	_, err := dbConn.ExecContext(ctx, s.rebind(`
+	_, err := s.query(ctx, dbConn, "UpdateTrustedSyncState", `
 		INSERT INTO trusted_sync_state (pubkey, last_synced_at)
 		VALUES (?, ?)
 		ON CONFLICT(pubkey) DO UPDATE SET last_synced_at = excluded.last_synced_at
-	`), pubkey, now)
+	`, pubkey, now).exec()
 
 	return err
 }
@@ -629,13 +752,13 @@ func (s *Storage) RecordTrustedSyncRelayStat(ctx context.Context, relayURL, pubk
 	}
 
 	now := time.Now().Unix()
-	_, err := dbConn.ExecContext(ctx, s.rebind(`
+	_, err := s.query(ctx, dbConn, "RecordTrustedSyncRelayStat", `
 		INSERT INTO trusted_sync_relay_stats (relay_url, pubkey, events_fetched, last_sync_at)
 		VALUES (?, ?, ?, ?)
 		ON CONFLICT(relay_url, pubkey) DO UPDATE SET
 			events_fetched = trusted_sync_relay_stats.events_fetched + excluded.events_fetched,
 			last_sync_at = excluded.last_sync_at
-	`), relayURL, pubkey, eventsFetched, now)
+	`, relayURL, pubkey, eventsFetched, now).exec()
 
 	return err
 }
@@ -648,12 +771,13 @@ type TrustedSyncRelayStat struct {
 }
 
 func (s *Storage) GetTrustedSyncRelayStats(ctx context.Context) ([]TrustedSyncRelayStat, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, `
+	var stats []TrustedSyncRelayStat
+	err := s.query(ctx, dbConn, "GetTrustedSyncRelayStats", `
 		SELECT
 			relay_url,
 			SUM(events_fetched) as total_events,
@@ -662,22 +786,19 @@ func (s *Storage) GetTrustedSyncRelayStats(ctx context.Context) ([]TrustedSyncRe
 		FROM trusted_sync_relay_stats
 		GROUP BY relay_url
 		ORDER BY total_events DESC
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var stats []TrustedSyncRelayStat
-	for rows.Next() {
+	`).each(func(rows *sql.Rows) error {
 		var stat TrustedSyncRelayStat
 		if err := rows.Scan(&stat.RelayURL, &stat.TotalEvents, &stat.UniquePubkeys, &stat.LastSyncAt); err != nil {
-			return nil, err
+			return err
 		}
 		stats = append(stats, stat)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return stats, rows.Err()
+	return stats, nil
 }
 
 type TrustedSyncPubkeyStat struct {
@@ -688,12 +809,13 @@ type TrustedSyncPubkeyStat struct {
 }
 
 func (s *Storage) GetTrustedSyncPubkeyStats(ctx context.Context, limit int) ([]TrustedSyncPubkeyStat, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var stats []TrustedSyncPubkeyStat
+	err := s.query(ctx, dbConn, "GetTrustedSyncPubkeyStats", `
 		SELECT
 			pubkey,
 			SUM(events_fetched) as total_events,
@@ -703,37 +825,34 @@ func (s *Storage) GetTrustedSyncPubkeyStats(ctx context.Context, limit int) ([]T
 		GROUP BY pubkey
 		ORDER BY total_events DESC
 		LIMIT ?
-	`), limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var stats []TrustedSyncPubkeyStat
-	for rows.Next() {
+	`, limit).each(func(rows *sql.Rows) error {
 		var stat TrustedSyncPubkeyStat
 		if err := rows.Scan(&stat.Pubkey, &stat.TotalEvents, &stat.RelayCount, &stat.LastSyncAt); err != nil {
-			return nil, err
+			return err
 		}
 		stats = append(stats, stat)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return stats, rows.Err()
+	return stats, nil
 }
 
 func (s *Storage) GetTrustedSyncTotalStats(ctx context.Context) (totalEvents int64, totalPubkeys int64, totalRelays int64, err error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return 0, 0, 0, nil
 	}
 
-	err = dbConn.QueryRowContext(ctx, `
+	err = s.query(ctx, dbConn, "GetTrustedSyncTotalStats", `
 		SELECT
 			COALESCE(SUM(events_fetched), 0),
 			COUNT(DISTINCT pubkey),
 			COUNT(DISTINCT relay_url)
 		FROM trusted_sync_relay_stats
-	`).Scan(&totalEvents, &totalPubkeys, &totalRelays)
+	`).scan(&totalEvents, &totalPubkeys, &totalRelays)
 
 	return
 }
@@ -773,55 +892,56 @@ func (s *Storage) GetPubkeyRelayList(ctx context.Context, pubkey string) ([]stri
 	return writeRelays, nil
 }
 
-func (s *Storage) CheckPubkeyEventKinds(ctx context.Context, pubkeys []string) (map[string]PubkeyEventKinds, error) {
-	dbConn := s.getDBConn()
+// CheckPubkeyEventKinds reports which of the given kinds each pubkey has events
+// of. Pubkeys are looked up in chunks, so any number can be checked at once
+// without running into SQLite's parameter limit.
+func (s *Storage) CheckPubkeyEventKinds(ctx context.Context, pubkeys []string, kinds []int) (map[string]PubkeyEventKinds, error) {
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	if len(pubkeys) == 0 {
-		return make(map[string]PubkeyEventKinds), nil
-	}
-
-	// Build query with placeholders for all pubkeys
-	placeholders := make([]interface{}, len(pubkeys))
-	for i, pk := range pubkeys {
-		placeholders[i] = pk
+	// Initialize result map with all pubkeys (default: no events)
+	result := make(map[string]PubkeyEventKinds, len(pubkeys))
+	for _, pk := range pubkeys {
+		result[pk] = PubkeyEventKinds{Pubkey: pk, Kinds: make(map[int]bool)}
 	}
-
-	// Build placeholder string
-	query := "SELECT pubkey, MAX(CASE WHEN kind = 0 THEN 1 ELSE 0 END) as has_kind_0, MAX(CASE WHEN kind = 3 THEN 1 ELSE 0 END) as has_kind_3, MAX(CASE WHEN kind = 10002 THEN 1 ELSE 0 END) as has_kind_10002 FROM event WHERE pubkey IN (?"
-	for i := 1; i < len(pubkeys); i++ {
-		query += ",?"
+	if len(pubkeys) == 0 || len(kinds) == 0 {
+		return result, nil
 	}
-	query += ") AND kind IN (0, 3, 10002) GROUP BY pubkey"
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(query), placeholders...)
-	if err != nil {
-		return nil, err
+	// Kinds are numbers, so they go in the query rather than use up parameters
+	kindList := make([]string, len(kinds))
+	for i, kind := range kinds {
+		kindList[i] = strconv.Itoa(kind)
 	}
-	defer rows.Close()
 
-	// Initialize result map with all pubkeys (default: no events)
-	result := make(map[string]PubkeyEventKinds)
-	for _, pk := range pubkeys {
-		result[pk] = PubkeyEventKinds{Pubkey: pk}
-	}
+	for start := 0; start < len(pubkeys); start += pubkeyKindsChunk {
+		chunk := pubkeys[start:min(start+pubkeyKindsChunk, len(pubkeys))]
+		args := make([]interface{}, len(chunk))
+		for i, pk := range chunk {
+			args[i] = pk
+		}
 
-	// Update with actual data
-	for rows.Next() {
-		var pubkey string
-		var hasK0, hasK3, hasK10002 int
-		if err := rows.Scan(&pubkey, &hasK0, &hasK3, &hasK10002); err != nil {
+		// Update with actual data
+		err := s.query(ctx, dbConn, "CheckPubkeyEventKinds", `
+			SELECT DISTINCT pubkey, kind FROM event
+			WHERE pubkey IN (?`+strings.Repeat(",?", len(chunk)-1)+`) AND kind IN (`+strings.Join(kindList, ",")+`)
+		`, args...).each(func(rows *sql.Rows) error {
+			var pubkey string
+			var kind int
+			if err := rows.Scan(&pubkey, &kind); err != nil {
+				return err
+			}
+			if k, ok := result[pubkey]; ok {
+				k.Kinds[kind] = true
+			}
+			return nil
+		})
+		if err != nil {
 			return nil, err
 		}
-		result[pubkey] = PubkeyEventKinds{
-			Pubkey:       pubkey,
-			HasKind0:     hasK0 == 1,
-			HasKind3:     hasK3 == 1,
-			HasKind10002: hasK10002 == 1,
-		}
 	}
 
-	return result, rows.Err()
+	return result, nil
 }
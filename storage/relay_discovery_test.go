@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Checking thousands of pubkeys at once used to put them all in one query,
+// past the database's parameter limit. The test database gets a limit well
+// below the pubkey count, so only a chunked lookup gets through.
+func TestCheckPubkeyEventKindsManyPubkeys(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Every connection to :memory: is its own database
+	db.SetMaxOpenConns(1)
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.Raw(func(dc any) error {
+		dc.(*sqlite3.SQLiteConn).SetLimit(sqlite3.SQLITE_LIMIT_VARIABLE_NUMBER, 999)
+		return nil
+	})
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`CREATE TABLE event (id TEXT PRIMARY KEY, pubkey TEXT NOT NULL, kind INTEGER NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pubkey i has kind k when i is a multiple of k's divisor, so the
+	// pubkeys on either side of each chunk boundary have different kinds
+	const pubkeyCount = 5000
+	divisors := map[int]int{0: 1, 3: 2, 7: 3, 30023: 5, 10002: 7}
+	pubkeys := make([]string, pubkeyCount)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range pubkeys {
+		pubkeys[i] = fmt.Sprintf("%064x", i)
+		for kind, divisor := range divisors {
+			if i%divisor == 0 {
+				if _, err := tx.Exec(`INSERT INTO event (id, pubkey, kind) VALUES (?, ?, ?)`, fmt.Sprintf("%d-%d", i, kind), pubkeys[i], kind); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		// A second event of a kind must not be reported twice
+		if _, err := tx.Exec(`INSERT INTO event (id, pubkey, kind) VALUES (?, ?, 0)`, fmt.Sprintf("%d-dup", i), pubkeys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Storage{analyticsDB: db}
+	// Kind 10002 is stored but not asked about; kind 1 is asked about but never stored
+	kinds := []int{0, 1, 3, 7, 30023}
+	result, err := s.CheckPubkeyEventKinds(ctx, append(pubkeys, "unknown"), kinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != pubkeyCount+1 {
+		t.Fatalf("got %d pubkeys, want %d", len(result), pubkeyCount+1)
+	}
+
+	for i, pk := range pubkeys {
+		got := result[pk]
+		if got.Pubkey != pk {
+			t.Fatalf("pubkey %d: result is for %q", i, got.Pubkey)
+		}
+		for _, kind := range kinds {
+			want := kind != 1 && i%divisors[kind] == 0
+			if got.Has(kind) != want {
+				t.Errorf("pubkey %d: has kind %d = %v, want %v", i, kind, got.Has(kind), want)
+			}
+		}
+		if got.Has(10002) {
+			t.Errorf("pubkey %d: reported kind 10002, which wasn't asked about", i)
+		}
+	}
+	if unknown := result["unknown"]; len(unknown.Kinds) != 0 {
+		t.Errorf("pubkey without events has kinds %v", unknown.Kinds)
+	}
+}
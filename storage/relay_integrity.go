@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/jmoiron/sqlx"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ErrInvalidSignature is returned by SaveUpstreamEvent for events whose signature doesn't verify
+var ErrInvalidSignature = errors.New("invalid: bad signature")
+
+// supersedeWindow is how soon a newer version has to arrive for the relay that
+// delivered the older one to be counted as serving stale data
+const supersedeWindow = 10 * time.Minute
+
+// RelayIntegrity counts what an upstream relay delivered and how much of it was bad
+type RelayIntegrity struct {
+	RelayURL   string
+	Delivered  int64
+	Stale      int64 // replaceable events already outdated, or superseded within minutes
+	InvalidSig int64
+	Duplicates int64
+	// FirstSeen counts current events we had from no other source before, the
+	// new data a relay contributed as opposed to copies of what we already had
+	FirstSeen int64
+}
+
+// Score rates a relay 0-100. Bad signatures weigh heaviest; duplicates barely
+// count, since popular events are expected to arrive from several relays.
+func (r RelayIntegrity) Score() float64 {
+	total := r.Delivered + r.InvalidSig
+	if total == 0 {
+		return 100
+	}
+	bad := float64(r.Stale) + float64(r.InvalidSig)*10 + float64(r.Duplicates)*0.1
+	score := 100 * (1 - bad/float64(total))
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+type upstreamDelivery struct {
+	relays     map[string]bool
+	createdAt  nostr.Timestamp
+	receivedAt time.Time
+}
+
+// relayIntegrityCounters buffers per-relay counts between flushes
+type relayIntegrityCounters struct {
+	mu     sync.Mutex
+	counts map[string]*RelayIntegrity
+	// Latest version delivered per replaceable address, to catch stale deliveries
+	// that are superseded shortly after
+	recent map[string]*upstreamDelivery
+}
+
+func (c *relayIntegrityCounters) get(relayURL string) *RelayIntegrity {
+	if c.counts == nil {
+		c.counts = make(map[string]*RelayIntegrity)
+		c.recent = make(map[string]*upstreamDelivery)
+	}
+	r := c.counts[relayURL]
+	if r == nil {
+		r = &RelayIntegrity{RelayURL: relayURL}
+		c.counts[relayURL] = r
+	}
+	return r
+}
+
+func (s *Storage) InitRelayIntegritySchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS relay_integrity (
+		relay_url TEXT PRIMARY KEY,
+		delivered INTEGER NOT NULL DEFAULT 0,
+		stale INTEGER NOT NULL DEFAULT 0,
+		invalid_sig INTEGER NOT NULL DEFAULT 0,
+		duplicates INTEGER NOT NULL DEFAULT 0,
+		updated_at INTEGER NOT NULL
+	);
+	ALTER TABLE relay_integrity ADD COLUMN IF NOT EXISTS first_seen INTEGER NOT NULL DEFAULT 0;
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// SaveUpstreamEvent saves an event fetched from an upstream relay, checking its
+// signature and counting stale, invalid and duplicate deliveries against the relay
+func (s *Storage) SaveUpstreamEvent(ctx context.Context, relayURL string, evt *nostr.Event) error {
+	if ok, _ := evt.CheckSignature(); !ok {
+		s.integrity.mu.Lock()
+		s.integrity.get(relayURL).InvalidSig++
+		s.integrity.mu.Unlock()
+		return ErrInvalidSignature
+	}
+
+	stale := false
+	if isReplaceableKind(evt.Kind) {
+		existing, err := s.QueryEvents(ctx, nostr.Filter{Kinds: []int{evt.Kind}, Authors: []string{evt.PubKey}, Limit: 1})
+		if err == nil && len(existing) > 0 && existing[0].CreatedAt > evt.CreatedAt {
+			stale = true
+		}
+	}
+
+	s.integrity.mu.Lock()
+	r := s.integrity.get(relayURL)
+	r.Delivered++
+	if stale {
+		r.Stale++
+	}
+	if isReplaceableKind(evt.Kind) && !stale {
+		s.trackDelivery(relayURL, evt)
+	}
+	s.integrity.mu.Unlock()
+
+	err := s.SaveEvent(ctx, evt)
+	if errors.Is(err, eventstore.ErrDupEvent) {
+		s.integrity.mu.Lock()
+		s.integrity.get(relayURL).Duplicates++
+		s.integrity.mu.Unlock()
+	}
+	if err == nil {
+		// Stored events are unique by ID, so a successful save is the first time we
+		// saw this one; stale versions add nothing anyone is served
+		if !stale {
+			s.integrity.mu.Lock()
+			s.integrity.get(relayURL).FirstSeen++
+			s.integrity.mu.Unlock()
+		}
+		if perr := s.RecordProvenance(ctx, evt.ID, ProvenanceUpstream, relayURL); perr != nil {
+			log.Printf("Failed to record provenance of %s: %v", evt.ID, perr)
+		}
+	}
+	return err
+}
+
+// trackDelivery counts relays that recently delivered an older version as stale
+// once a newer one shows up. Called with integrity.mu held.
+func (s *Storage) trackDelivery(relayURL string, evt *nostr.Event) {
+	key := fmt.Sprintf("%s:%d", evt.PubKey, evt.Kind)
+	now := time.Now()
+
+	prev := s.integrity.recent[key]
+	switch {
+	case prev == nil || now.Sub(prev.receivedAt) > supersedeWindow || evt.CreatedAt > prev.createdAt:
+		if prev != nil && now.Sub(prev.receivedAt) <= supersedeWindow {
+			for url := range prev.relays {
+				if url != relayURL {
+					s.integrity.get(url).Stale++
+				}
+			}
+		}
+		s.integrity.recent[key] = &upstreamDelivery{relays: map[string]bool{relayURL: true}, createdAt: evt.CreatedAt, receivedAt: now}
+	case evt.CreatedAt == prev.createdAt:
+		prev.relays[relayURL] = true
+	}
+}
+
+// FlushRelayIntegrity adds the counts gathered since the last flush to the relay_integrity table
+func (s *Storage) FlushRelayIntegrity(ctx context.Context) error {
+	s.integrity.mu.Lock()
+	counts := s.integrity.counts
+	s.integrity.counts = make(map[string]*RelayIntegrity)
+	now := time.Now()
+	for key, d := range s.integrity.recent {
+		if now.Sub(d.receivedAt) > supersedeWindow {
+			delete(s.integrity.recent, key)
+		}
+	}
+	s.integrity.mu.Unlock()
+
+	dbConn := s.getDBConn()
+	if dbConn == nil || len(counts) == 0 {
+		return nil
+	}
+
+	return s.inTx(ctx, dbConn, "FlushRelayIntegrity", func(ctx context.Context, tx *sqlx.Tx) error {
+		for _, r := range counts {
+			_, err := s.query(ctx, tx, "FlushRelayIntegrity", `
+				INSERT INTO relay_integrity (relay_url, delivered, stale, invalid_sig, duplicates, first_seen, updated_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(relay_url) DO UPDATE SET
+					delivered = relay_integrity.delivered + excluded.delivered,
+					stale = relay_integrity.stale + excluded.stale,
+					invalid_sig = relay_integrity.invalid_sig + excluded.invalid_sig,
+					duplicates = relay_integrity.duplicates + excluded.duplicates,
+					first_seen = relay_integrity.first_seen + excluded.first_seen,
+					updated_at = excluded.updated_at
+			`, r.RelayURL, r.Delivered, r.Stale, r.InvalidSig, r.Duplicates, r.FirstSeen, now.Unix()).exec()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetRelayIntegrity returns the stored integrity counts keyed by relay URL
+func (s *Storage) GetRelayIntegrity(ctx context.Context) (map[string]RelayIntegrity, error) {
+	result := make(map[string]RelayIntegrity)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return result, nil
+	}
+
+	err := s.query(ctx, dbConn, "GetRelayIntegrity", `
+		SELECT relay_url, delivered, stale, invalid_sig, duplicates, first_seen FROM relay_integrity
+	`).each(func(rows *sql.Rows) error {
+		var r RelayIntegrity
+		if err := rows.Scan(&r.RelayURL, &r.Delivered, &r.Stale, &r.InvalidSig, &r.Duplicates, &r.FirstSeen); err != nil {
+			return err
+		}
+		result[r.RelayURL] = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
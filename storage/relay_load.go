@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RelayLoad is the relay's interactive load as last sampled, shared through
+// the database so the analytics worker can hold off while the relay is busy
+type RelayLoad struct {
+	Connections  int64
+	QueryLatency time.Duration // mean REQ storage query time since the previous sample
+	SampledAt    time.Time
+}
+
+// RecordRelayLoad replaces the stored load sample
+func (s *Storage) RecordRelayLoad(ctx context.Context, load RelayLoad) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "RecordRelayLoad", `
+		INSERT INTO relay_load (id, connections, query_latency_us, sampled_at) VALUES (1, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			connections = excluded.connections,
+			query_latency_us = excluded.query_latency_us,
+			sampled_at = excluded.sampled_at
+	`, load.Connections, load.QueryLatency.Microseconds(), load.SampledAt.Unix()).exec()
+	return err
+}
+
+// GetRelayLoad returns the last load sample, or nil if the relay never recorded one
+func (s *Storage) GetRelayLoad(ctx context.Context) (*RelayLoad, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var load RelayLoad
+	var latencyUs, sampledAt int64
+	err := s.query(ctx, dbConn, "GetRelayLoad", `
+		SELECT connections, query_latency_us, sampled_at FROM relay_load WHERE id = 1
+	`).scan(&load.Connections, &latencyUs, &sampledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	load.QueryLatency = time.Duration(latencyUs) * time.Microsecond
+	load.SampledAt = unixOrZero(sampledAt)
+	return &load, nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// RelayProbe is what syncing and the census found out about one relay
+type RelayProbe struct {
+	URL           string
+	SyncAttempts  int64
+	SyncSuccesses int64
+	LastSync      time.Time // zero if we never synced from it successfully
+	InfoFetchedAt time.Time // zero if its NIP-11 document was never fetched
+	InfoError     string    // why the last NIP-11 fetch failed, empty on success
+	Capability    string    // RelayAuthRequired or RelayRestricted while flagged
+}
+
+// GetRelayProbes returns the probe data of the given relays, keyed by URL.
+// Relays we never discovered are missing from the result.
+func (s *Storage) GetRelayProbes(ctx context.Context, urls []string) (map[string]RelayProbe, error) {
+	result := make(map[string]RelayProbe)
+
+	dbConn := s.getReadDBConn()
+	if dbConn == nil || len(urls) == 0 {
+		return result, nil
+	}
+
+	args := []interface{}{time.Now().Add(-RelayCapabilityTTL).Unix()}
+	for _, url := range urls {
+		args = append(args, url)
+	}
+
+	err := s.query(ctx, dbConn, "GetRelayProbes", `
+		SELECT d.url, d.sync_attempts, d.sync_successes, d.last_sync,
+		       COALESCE(i.fetched_at, 0), COALESCE(i.error, ''), COALESCE(c.capability, '')
+		FROM discovered_relays d
+		LEFT JOIN relay_info i ON i.url = d.url
+		LEFT JOIN relay_capabilities c ON c.url = d.url AND c.detected_at >= ?
+		WHERE d.url IN (?`+strings.Repeat(",?", len(urls)-1)+`)
+	`, args...).each(func(rows *sql.Rows) error {
+		var p RelayProbe
+		var lastSync, fetchedAt int64
+		if err := rows.Scan(&p.URL, &p.SyncAttempts, &p.SyncSuccesses, &lastSync, &fetchedAt, &p.InfoError, &p.Capability); err != nil {
+			return err
+		}
+		if lastSync > 0 {
+			p.LastSync = time.Unix(lastSync, 0)
+		}
+		if fetchedAt > 0 {
+			p.InfoFetchedAt = time.Unix(fetchedAt, 0)
+		}
+		result[p.URL] = p
+		return nil
+	})
+
+	return result, err
+}
+
+// GetHealthyRelays returns up to limit active discovered relays we synced from
+// successfully within since and that aren't flagged as restricted or requiring
+// auth, best sync success rate first
+func (s *Storage) GetHealthyRelays(ctx context.Context, since time.Time, limit int) ([]string, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var urls []string
+	err := s.query(ctx, dbConn, "GetHealthyRelays", `
+		SELECT d.url
+		FROM discovered_relays d
+		LEFT JOIN relay_capabilities c ON c.url = d.url AND c.detected_at >= ?
+		WHERE d.is_active = 1 AND d.sync_successes > 0 AND d.last_sync >= ? AND c.url IS NULL
+		ORDER BY CAST(d.sync_successes AS REAL) / d.sync_attempts DESC, d.events_contributed DESC
+		LIMIT ?
+	`, time.Now().Add(-RelayCapabilityTTL).Unix(), since.Unix(), limit).each(func(rows *sql.Rows) error {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return err
+		}
+		urls = append(urls, url)
+		return nil
+	})
+	return urls, err
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindReport is a NIP-56 report; its p tags name the reported pubkeys
+const KindReport = 1984
+
+// Report types taken in: the ones that feed spam detection
+const (
+	ReportSpam          = "spam"
+	ReportImpersonation = "impersonation"
+)
+
+// Where a report came from
+const (
+	ReportSourceNostr = "nostr" // a kind 1984 event, the reporter is its author
+	ReportSourceWeb   = "web"   // the /report form, the reporter is the client IP
+)
+
+// Report weights by reporter. Anyone can publish a report or post the form, so
+// only reports from trusted pubkeys count in full.
+const (
+	reportWeightTrusted   = 1.0
+	reportWeightUntrusted = 0.2
+	reportWeightWeb       = 0.1
+)
+
+type AbuseReport struct {
+	Reporter  string
+	Reported  string
+	Type      string
+	Source    string
+	EventID   string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// ReportSummary aggregates the reports against one pubkey
+type ReportSummary struct {
+	Pubkey           string
+	Reports          int
+	TrustedReporters int
+	Score            float64
+	Types            []string
+	LastReportedAt   time.Time
+}
+
+func IsReportType(t string) bool {
+	return t == ReportSpam || t == ReportImpersonation
+}
+
+// ReportsFromEvent extracts the spam and impersonation reports in a kind 1984
+// event. The report type is the third element of the p tag, or of an e tag when
+// an event rather than a profile is reported.
+func ReportsFromEvent(evt *nostr.Event) []AbuseReport {
+	if evt.Kind != KindReport {
+		return nil
+	}
+
+	var eventID, eventType string
+	for _, tag := range evt.Tags {
+		if len(tag) >= 3 && tag[0] == "e" {
+			eventID, eventType = tag[1], tag[2]
+			break
+		}
+	}
+
+	var reports []AbuseReport
+	for _, tag := range evt.Tags {
+		if len(tag) < 2 || tag[0] != "p" || !nostr.IsValid32ByteHex(tag[1]) || tag[1] == evt.PubKey {
+			continue
+		}
+		reportType := eventType
+		if len(tag) >= 3 && tag[2] != "" {
+			reportType = tag[2]
+		}
+		if !IsReportType(reportType) {
+			continue
+		}
+		reports = append(reports, AbuseReport{
+			Reporter:  evt.PubKey,
+			Reported:  tag[1],
+			Type:      reportType,
+			Source:    ReportSourceNostr,
+			EventID:   eventID,
+			Reason:    evt.Content,
+			CreatedAt: evt.CreatedAt.Time(),
+		})
+	}
+	return reports
+}
+
+// SaveAbuseReport records a report, replacing an older one by the same reporter
+// against the same pubkey
+func (s *Storage) SaveAbuseReport(ctx context.Context, r AbuseReport) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	// Web reports are keyed by the reporter's IP
+	if ip, ok := strings.CutPrefix(r.Reporter, "ip:"); ok {
+		hashed, err := s.anonymizeIP(ctx, ip, time.Now())
+		if err != nil {
+			return err
+		}
+		r.Reporter = "ip:" + hashed
+	}
+
+	_, err := s.query(ctx, dbConn, "SaveAbuseReport", `
+		INSERT INTO abuse_reports (reporter, reported, report_type, source, event_id, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(reporter, reported) DO UPDATE SET
+			report_type = excluded.report_type,
+			source = excluded.source,
+			event_id = excluded.event_id,
+			reason = excluded.reason,
+			created_at = excluded.created_at
+		WHERE excluded.created_at >= abuse_reports.created_at
+	`, r.Reporter, r.Reported, r.Type, r.Source, r.EventID, r.Reason, r.CreatedAt.Unix()).exec()
+
+	return err
+}
+
+// GetReportSummaries returns pubkeys whose reports add up to at least minScore,
+// weighting each report by whether its reporter is trusted, highest score first
+func (s *Storage) GetReportSummaries(ctx context.Context, minScore float64, limit int) ([]ReportSummary, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var summaries []ReportSummary
+	err := s.query(ctx, dbConn, "GetReportSummaries", `
+		SELECT r.reported,
+			COUNT(*),
+			COUNT(t.pubkey),
+			SUM(CASE
+				WHEN t.pubkey IS NOT NULL THEN $1::float8
+				WHEN r.source = 'web' THEN $2::float8
+				ELSE $3::float8
+			END) AS score,
+			string_agg(DISTINCT r.report_type, ','),
+			MAX(r.created_at)
+		FROM abuse_reports r
+		LEFT JOIN trusted_pubkeys t ON t.pubkey = r.reporter
+		GROUP BY r.reported
+		HAVING SUM(CASE
+			WHEN t.pubkey IS NOT NULL THEN $1::float8
+			WHEN r.source = 'web' THEN $2::float8
+			ELSE $3::float8
+		END) >= $4::float8
+		ORDER BY score DESC, r.reported
+		LIMIT $5
+	`, reportWeightTrusted, reportWeightWeb, reportWeightUntrusted, minScore, limit).each(func(rows *sql.Rows) error {
+		var r ReportSummary
+		var types string
+		var lastReportedAt int64
+		if err := rows.Scan(&r.Pubkey, &r.Reports, &r.TrustedReporters, &r.Score, &types, &lastReportedAt); err != nil {
+			return err
+		}
+		r.Types = strings.Split(types, ",")
+		r.LastReportedAt = time.Unix(lastReportedAt, 0)
+		summaries = append(summaries, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
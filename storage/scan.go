@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const defaultScanChunkSize = 1000
+
+// ScanStats describes the most recent full scan done through ScanEvents
+type ScanStats struct {
+	Duration   time.Duration
+	Events     int64
+	Chunks     int64
+	FinishedAt time.Time
+}
+
+type scanMetrics struct {
+	mu   sync.Mutex
+	last ScanStats
+}
+
+// SetScanChunkSize sets how many events ScanEvents reads per read transaction
+func (s *Storage) SetScanChunkSize(size int) {
+	s.scanChunkSize = size
+}
+
+func (s *Storage) chunkSize() int {
+	size := s.scanChunkSize
+	if size <= 0 {
+		size = defaultScanChunkSize
+	}
+	// Backends silently cap larger limits, which would look like the end of the scan
+	if max := s.db.MaxQueryLimit(); max > 0 && size > max {
+		size = max
+	}
+	return size
+}
+
+// ScanEvents walks every event matching filter, newest first, in chunks. Each
+// chunk is a separate query and therefore a separate read transaction, so a long
+// scan never pins one LMDB snapshot and blocks page reclamation.
+func (s *Storage) ScanEvents(ctx context.Context, filter nostr.Filter, fn func(evt *nostr.Event)) error {
+	start := time.Now()
+	chunk := s.chunkSize()
+
+	var total, chunks int64
+	until := filter.Until
+	// IDs already delivered at the until boundary, since until is inclusive
+	boundary := make(map[string]bool)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		f := filter
+		f.Limit = chunk
+		f.Until = until
+
+		ch, err := s.db.QueryEvents(ctx, f)
+		if err != nil {
+			return err
+		}
+		chunks++
+
+		received, fresh := 0, 0
+		var oldest nostr.Timestamp
+		oldestIDs := make(map[string]bool)
+		for evt := range ch {
+			received++
+			if received == 1 || evt.CreatedAt < oldest {
+				oldest = evt.CreatedAt
+				oldestIDs = make(map[string]bool)
+			}
+			if evt.CreatedAt == oldest {
+				oldestIDs[evt.ID] = true
+			}
+
+			if boundary[evt.ID] {
+				continue
+			}
+			fresh++
+			total++
+			fn(evt)
+		}
+
+		if received < chunk {
+			break
+		}
+
+		if fresh == 0 {
+			// A full chunk of already seen events sharing one timestamp; step past it
+			next := oldest - 1
+			until = &next
+			boundary = make(map[string]bool)
+			continue
+		}
+
+		if until != nil && *until == oldest {
+			for id := range boundary {
+				oldestIDs[id] = true
+			}
+		}
+		next := oldest
+		until = &next
+		boundary = oldestIDs
+	}
+
+	s.scanStats.mu.Lock()
+	s.scanStats.last = ScanStats{
+		Duration:   time.Since(start),
+		Events:     total,
+		Chunks:     chunks,
+		FinishedAt: time.Now(),
+	}
+	s.scanStats.mu.Unlock()
+
+	return nil
+}
+
+// LastScanStats returns metrics for the most recent completed ScanEvents call
+func (s *Storage) LastScanStats() ScanStats {
+	s.scanStats.mu.Lock()
+	defer s.scanStats.mu.Unlock()
+	return s.scanStats.last
+}
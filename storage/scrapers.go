@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type ScraperCandidate struct {
+	IP            string
+	Reason        string
+	DetectedAt    time.Time
+	LastSeen      time.Time
+	PeakPerMinute int64
+	Detections    int64
+}
+
+func (s *Storage) InitScraperSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS scraper_candidates (
+		ip TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		detected_at INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		peak_per_minute INTEGER NOT NULL DEFAULT 0,
+		detections INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (ip, reason)
+	);
+	CREATE INDEX IF NOT EXISTS idx_scraper_candidates_last_seen ON scraper_candidates(last_seen DESC);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+// SaveScraperCandidate records that an IP crossed a scraping threshold during one detection window
+func (s *Storage) SaveScraperCandidate(ctx context.Context, ip, reason string, perMinute int64) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	now := time.Now()
+	ip, err := s.anonymizeIP(ctx, ip, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.query(ctx, dbConn, "SaveScraperCandidate", `
+		INSERT INTO scraper_candidates (ip, reason, detected_at, last_seen, peak_per_minute, detections)
+		VALUES (?, ?, ?, ?, ?, 1)
+		ON CONFLICT(ip, reason) DO UPDATE SET
+			last_seen = excluded.last_seen,
+			peak_per_minute = GREATEST(scraper_candidates.peak_per_minute, excluded.peak_per_minute),
+			detections = scraper_candidates.detections + 1
+	`, ip, reason, now.Unix(), now.Unix(), perMinute).exec()
+
+	return err
+}
+
+// GetScraperCandidates returns the most recently active scraper candidates
+func (s *Storage) GetScraperCandidates(ctx context.Context, limit int) ([]ScraperCandidate, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var candidates []ScraperCandidate
+	err := s.query(ctx, dbConn, "GetScraperCandidates", `
+		SELECT ip, reason, detected_at, last_seen, peak_per_minute, detections
+		FROM scraper_candidates
+		ORDER BY last_seen DESC
+		LIMIT ?
+	`, limit).each(func(rows *sql.Rows) error {
+		var c ScraperCandidate
+		var detectedAt, lastSeen int64
+		if err := rows.Scan(&c.IP, &c.Reason, &detectedAt, &lastSeen, &c.PeakPerMinute, &c.Detections); err != nil {
+			return err
+		}
+		c.DetectedAt = time.Unix(detectedAt, 0)
+		c.LastSeen = time.Unix(lastSeen, 0)
+		candidates = append(candidates, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
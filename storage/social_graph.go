@@ -2,9 +2,13 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"sort"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type MutedPubkey struct {
@@ -35,86 +39,99 @@ type FollowerTrend struct {
 	Lost        int64
 }
 
-// GetMostMutedPubkeys returns pubkeys that appear most frequently in kind 10000 mute lists
-func (s *Storage) GetMostMutedPubkeys(ctx context.Context, limit int) ([]MutedPubkey, error) {
-	dbConn := s.getDBConn()
-	if dbConn == nil {
-		return nil, nil
-	}
+// mostMutedKept is how many of the most muted pubkeys a refresh keeps
+const mostMutedKept = 1000
 
-	// Get all mute list events (kind 10000)
-	rows, err := dbConn.QueryContext(ctx, `SELECT tags FROM event WHERE kind = 10000`)
-	if err != nil {
-		return nil, err
+// RefreshMostMuted rebuilds the most muted pubkeys from every kind 10000 mute
+// list, with their follower counts, so pages don't scan the lists per view
+func (s *Storage) RefreshMostMuted(ctx context.Context) error {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil
 	}
-	defer rows.Close()
 
-	// Count mutes per pubkey
+	// Count mutes per pubkey across all mute lists (kind 10000)
 	muteCounts := make(map[string]int64)
-	for rows.Next() {
-		var tagsJSON string
-		if err := rows.Scan(&tagsJSON); err != nil {
-			continue
-		}
-		var tags [][]string
-		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
-			continue
-		}
+	err := s.eachTagList(ctx, dbConn, "RefreshMostMuted", `SELECT tags FROM event WHERE kind = 10000`, func(tags [][]string) {
 		for _, tag := range tags {
 			if len(tag) >= 2 && tag[0] == "p" {
 				muteCounts[tag[1]]++
 			}
 		}
+	})
+	if err != nil {
+		return err
 	}
 
-	// Get follower counts for muted pubkeys
-	followerCounts, _ := s.getFollowerCountsForPubkeys(ctx, muteCounts)
-
-	// Convert to slice and sort
 	results := make([]MutedPubkey, 0, len(muteCounts))
 	for pk, count := range muteCounts {
-		results = append(results, MutedPubkey{
-			Pubkey:        pk,
-			MuteCount:     count,
-			FollowerCount: followerCounts[pk],
-		})
+		results = append(results, MutedPubkey{Pubkey: pk, MuteCount: count})
 	}
-
 	sort.Slice(results, func(i, j int) bool {
 		return results[i].MuteCount > results[j].MuteCount
 	})
+	if len(results) > mostMutedKept {
+		results = results[:mostMutedKept]
+	}
 
-	if len(results) > limit {
-		results = results[:limit]
+	// Get follower counts for the muted pubkeys kept
+	kept := make(map[string]int64, len(results))
+	for _, r := range results {
+		kept[r.Pubkey] = r.MuteCount
+	}
+	followerCounts, err := s.getFollowerCountsForPubkeys(ctx, kept)
+	if err != nil {
+		return err
 	}
 
-	return results, nil
+	return s.rebuildDerivedTables(ctx, []derivedTable{mostMutedTable}, func(ctx context.Context, tx *sqlx.Tx) error {
+		for _, r := range results {
+			if _, err := s.query(ctx, tx, "RefreshMostMuted", `
+				INSERT INTO cached_most_muted_next (pubkey, mute_count, follower_count) VALUES (?, ?, ?)
+			`, r.Pubkey, r.MuteCount, followerCounts[r.Pubkey]).exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetMostMutedPubkeys returns the pubkeys that appear most frequently in kind
+// 10000 mute lists, as of the last most muted refresh
+func (s *Storage) GetMostMutedPubkeys(ctx context.Context, limit int) ([]MutedPubkey, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var results []MutedPubkey
+	err := s.query(ctx, dbConn, "GetMostMutedPubkeys", `
+		SELECT pubkey, mute_count, follower_count
+		FROM cached_most_muted
+		ORDER BY mute_count DESC, pubkey
+		LIMIT ?
+	`, limit).each(func(rows *sql.Rows) error {
+		var m MutedPubkey
+		if err := rows.Scan(&m.Pubkey, &m.MuteCount, &m.FollowerCount); err != nil {
+			return err
+		}
+		results = append(results, m)
+		return nil
+	})
+
+	return results, err
 }
 
 // getFollowerCountsForPubkeys counts how many kind 3 events have each pubkey in their "p" tags
 func (s *Storage) getFollowerCountsForPubkeys(ctx context.Context, pubkeys map[string]int64) (map[string]int64, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
 	// Get all contact lists (kind 3)
-	rows, err := dbConn.QueryContext(ctx, `SELECT tags FROM event WHERE kind = 3`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	followerCounts := make(map[string]int64)
-	for rows.Next() {
-		var tagsJSON string
-		if err := rows.Scan(&tagsJSON); err != nil {
-			continue
-		}
-		var tags [][]string
-		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
-			continue
-		}
+	err := s.eachTagList(ctx, dbConn, "getFollowerCountsForPubkeys", `SELECT tags FROM event WHERE kind = 3`, func(tags [][]string) {
 		for _, tag := range tags {
 			if len(tag) >= 2 && tag[0] == "p" {
 				if _, exists := pubkeys[tag[1]]; exists {
@@ -122,6 +139,9 @@ func (s *Storage) getFollowerCountsForPubkeys(ctx context.Context, pubkeys map[s
 				}
 			}
 		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return followerCounts, nil
@@ -129,32 +149,21 @@ func (s *Storage) getFollowerCountsForPubkeys(ctx context.Context, pubkeys map[s
 
 // GetInterestRankings returns the most common interests from kind 10015 events
 func (s *Storage) GetInterestRankings(ctx context.Context, limit int) ([]InterestRank, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, `SELECT tags FROM event WHERE kind = 10015`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	interestCounts := make(map[string]int64)
-	for rows.Next() {
-		var tagsJSON string
-		if err := rows.Scan(&tagsJSON); err != nil {
-			continue
-		}
-		var tags [][]string
-		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
-			continue
-		}
+	err := s.eachTagList(ctx, dbConn, "GetInterestRankings", `SELECT tags FROM event WHERE kind = 10015`, func(tags [][]string) {
 		for _, tag := range tags {
 			if len(tag) >= 2 && tag[0] == "t" {
 				interestCounts[tag[1]]++
 			}
 		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	results := make([]InterestRank, 0, len(interestCounts))
@@ -178,32 +187,21 @@ func (s *Storage) GetInterestRankings(ctx context.Context, limit int) ([]Interes
 
 // GetCommunityRankings returns the most popular communities from kind 10004 events
 func (s *Storage) GetCommunityRankings(ctx context.Context, limit int) ([]CommunityRank, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, `SELECT tags FROM event WHERE kind = 10004`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	communityCounts := make(map[string]int64)
-	for rows.Next() {
-		var tagsJSON string
-		if err := rows.Scan(&tagsJSON); err != nil {
-			continue
-		}
-		var tags [][]string
-		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
-			continue
-		}
+	err := s.eachTagList(ctx, dbConn, "GetCommunityRankings", `SELECT tags FROM event WHERE kind = 10004`, func(tags [][]string) {
 		for _, tag := range tags {
 			if len(tag) >= 2 && tag[0] == "a" {
 				communityCounts[tag[1]]++
 			}
 		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	results := make([]CommunityRank, 0, len(communityCounts))
@@ -227,32 +225,21 @@ func (s *Storage) GetCommunityRankings(ctx context.Context, limit int) ([]Commun
 
 // GetTopFollowed returns pubkeys with the most followers from kind 3 events
 func (s *Storage) GetTopFollowed(ctx context.Context, limit int) ([]FollowerCount, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
-	rows, err := dbConn.QueryContext(ctx, `SELECT tags FROM event WHERE kind = 3`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	followerCounts := make(map[string]int64)
-	for rows.Next() {
-		var tagsJSON string
-		if err := rows.Scan(&tagsJSON); err != nil {
-			continue
-		}
-		var tags [][]string
-		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
-			continue
-		}
+	err := s.eachTagList(ctx, dbConn, "GetTopFollowed", `SELECT tags FROM event WHERE kind = 3`, func(tags [][]string) {
 		for _, tag := range tags {
 			if len(tag) >= 2 && tag[0] == "p" {
 				followerCounts[tag[1]]++
 			}
 		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	results := make([]FollowerCount, 0, len(followerCounts))
@@ -276,34 +263,69 @@ func (s *Storage) GetTopFollowed(ctx context.Context, limit int) ([]FollowerCoun
 
 // GetFollowerTrends calculates who gained/lost the most followers based on event_history
 func (s *Storage) GetFollowerTrends(ctx context.Context, limit int) (rising []FollowerTrend, falling []FollowerTrend, err error) {
-	dbConn := s.getDBConn()
+	changes, err := s.GetFollowerChanges(ctx)
+	if err != nil || changes == nil {
+		return nil, nil, err
+	}
+
+	// Convert to slices
+	allTrends := make([]FollowerTrend, 0, len(changes))
+	for _, trend := range changes {
+		allTrends = append(allTrends, trend)
+	}
+
+	// Sort for rising (highest net gain)
+	sort.Slice(allTrends, func(i, j int) bool {
+		return allTrends[i].NetChange > allTrends[j].NetChange
+	})
+
+	rising = make([]FollowerTrend, 0, limit)
+	for i := 0; i < len(allTrends) && i < limit; i++ {
+		if allTrends[i].NetChange > 0 {
+			rising = append(rising, allTrends[i])
+		}
+	}
+
+	// Sort for falling (lowest net gain / highest loss)
+	sort.Slice(allTrends, func(i, j int) bool {
+		return allTrends[i].NetChange < allTrends[j].NetChange
+	})
+
+	falling = make([]FollowerTrend, 0, limit)
+	for i := 0; i < len(allTrends) && i < limit; i++ {
+		if allTrends[i].NetChange < 0 {
+			falling = append(falling, allTrends[i])
+		}
+	}
+
+	return rising, falling, nil
+}
+
+// GetFollowerChanges returns the followers gained and lost by every followed pubkey,
+// comparing archived contact lists in event_history with the current ones
+func (s *Storage) GetFollowerChanges(ctx context.Context) (map[string]FollowerTrend, error) {
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
-		return nil, nil, nil
+		return nil, nil
 	}
 
 	// Get historical kind 3 events
-	rows, err := dbConn.QueryContext(ctx, `
+	oldFollows := make(map[string]map[string]bool)
+	err := s.query(ctx, dbConn, "GetFollowerChanges: history", `
 		SELECT pubkey, tags
 		FROM event_history
 		WHERE kind = 3
-	`)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer rows.Close()
-
-	// Track old follows per pubkey (aggregated)
-	oldFollows := make(map[string]map[string]bool)
-	for rows.Next() {
+	`).each(func(rows *sql.Rows) error {
 		var pubkey, tagsJSON string
 		if err := rows.Scan(&pubkey, &tagsJSON); err != nil {
-			continue
+			return err
 		}
 		var tags [][]string
 		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
-			continue
+			return nil
 		}
 
+		// Track old follows per pubkey (aggregated)
 		if oldFollows[pubkey] == nil {
 			oldFollows[pubkey] = make(map[string]bool)
 		}
@@ -312,24 +334,22 @@ func (s *Storage) GetFollowerTrends(ctx context.Context, limit int) (rising []Fo
 				oldFollows[pubkey][tag[1]] = true
 			}
 		}
-	}
-
-	// Get current kind 3 events
-	currentRows, err := dbConn.QueryContext(ctx, `SELECT pubkey, tags FROM event WHERE kind = 3`)
+		return nil
+	})
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	defer currentRows.Close()
 
+	// Get current kind 3 events
 	currentFollows := make(map[string]map[string]bool)
-	for currentRows.Next() {
+	err = s.query(ctx, dbConn, "GetFollowerChanges: current", `SELECT pubkey, tags FROM event WHERE kind = 3`).each(func(rows *sql.Rows) error {
 		var pubkey, tagsJSON string
-		if err := currentRows.Scan(&pubkey, &tagsJSON); err != nil {
-			continue
+		if err := rows.Scan(&pubkey, &tagsJSON); err != nil {
+			return err
 		}
 		var tags [][]string
 		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
-			continue
+			return nil
 		}
 
 		currentFollows[pubkey] = make(map[string]bool)
@@ -338,6 +358,10 @@ func (s *Storage) GetFollowerTrends(ctx context.Context, limit int) (rising []Fo
 				currentFollows[pubkey][tag[1]] = true
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Calculate changes per followed pubkey
@@ -371,83 +395,158 @@ func (s *Storage) GetFollowerTrends(ctx context.Context, limit int) (rising []Fo
 		}
 	}
 
-	// Calculate net change
-	for _, trend := range changes {
-		trend.NetChange = int64(trend.Gained) - int64(trend.Lost)
-	}
-
-	// Convert to slices
-	allTrends := make([]FollowerTrend, 0, len(changes))
-	for _, trend := range changes {
-		allTrends = append(allTrends, *trend)
-	}
-
-	// Sort for rising (highest net gain)
-	sort.Slice(allTrends, func(i, j int) bool {
-		return allTrends[i].NetChange > allTrends[j].NetChange
-	})
-
-	rising = make([]FollowerTrend, 0, limit)
-	for i := 0; i < len(allTrends) && i < limit; i++ {
-		if allTrends[i].NetChange > 0 {
-			rising = append(rising, allTrends[i])
+	// Followers gained and lost by a migrated key count toward the key it moved to
+	migrations, _ := s.GetKeyMigrations(ctx)
+	for oldPubkey, newPubkey := range migrations {
+		oldTrend := changes[oldPubkey]
+		if oldTrend == nil {
+			continue
+		}
+		if changes[newPubkey] == nil {
+			changes[newPubkey] = &FollowerTrend{Pubkey: newPubkey}
 		}
+		changes[newPubkey].Gained += oldTrend.Gained
+		changes[newPubkey].Lost += oldTrend.Lost
+		delete(changes, oldPubkey)
 	}
 
-	// Sort for falling (lowest net gain / highest loss)
-	sort.Slice(allTrends, func(i, j int) bool {
-		return allTrends[i].NetChange < allTrends[j].NetChange
-	})
-
-	falling = make([]FollowerTrend, 0, limit)
-	for i := 0; i < len(allTrends) && i < limit; i++ {
-		if allTrends[i].NetChange < 0 {
-			falling = append(falling, allTrends[i])
-		}
+	// Calculate net change
+	result := make(map[string]FollowerTrend, len(changes))
+	for pubkey, trend := range changes {
+		trend.NetChange = int64(trend.Gained) - int64(trend.Lost)
+		result[pubkey] = *trend
 	}
 
-	return rising, falling, nil
+	return result, nil
 }
 
 // GetFollowerCount returns the number of followers for a specific pubkey
 func (s *Storage) GetFollowerCount(ctx context.Context, pubkey string) (int64, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return 0, nil
 	}
 
 	var count int64
 
+	if s.followsIndexReady(ctx) {
+		err := s.query(ctx, dbConn, "GetFollowerCount", `SELECT COUNT(*) FROM follows WHERE followed = ?`, pubkey).scan(&count)
+		return count, err
+	}
+
 	// Use JSONB containment operator for fast counting
-	err := dbConn.QueryRowContext(ctx, `
+	err := s.query(ctx, dbConn, "GetFollowerCount", `
 		SELECT COUNT(*)
 		FROM event
 		WHERE kind = 3
 		AND tags @> $1::jsonb
-	`, fmt.Sprintf(`[["p","%s"]]`, pubkey)).Scan(&count)
+	`, fmt.Sprintf(`[["p","%s"]]`, pubkey)).scan(&count)
 
 	return count, err
 }
 
+// GetVerifiedFollowerCount returns the number of followers that look like real
+// people: their own kind 0 exists locally and they are neither in an active bot
+// cluster nor an unpurged spam candidate
+func (s *Storage) GetVerifiedFollowerCount(ctx context.Context, pubkey string) (int64, error) {
+	counts, err := s.GetVerifiedFollowerCounts(ctx, []string{pubkey})
+	return counts[pubkey], err
+}
+
+// GetVerifiedFollowerCounts is GetVerifiedFollowerCount for several pubkeys,
+// counted in one query over the follows table once it is ready
+func (s *Storage) GetVerifiedFollowerCounts(ctx context.Context, pubkeys []string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(pubkeys))
+	dbConn := s.getReadDBConn()
+	if dbConn == nil || len(pubkeys) == 0 {
+		return counts, nil
+	}
+
+	if s.followsIndexReady(ctx) {
+		err := s.query(ctx, dbConn, "GetVerifiedFollowerCounts", `
+			SELECT f.followed, COUNT(*)
+			FROM follows f
+			WHERE f.followed = ANY($1)
+			AND EXISTS (SELECT 1 FROM event p WHERE p.kind = 0 AND p.pubkey = f.follower)
+			AND NOT EXISTS (SELECT 1 FROM spam_candidates sc WHERE sc.pubkey = f.follower AND sc.purged = 0)
+			AND NOT EXISTS (
+				SELECT 1 FROM bot_cluster_members bcm
+				JOIN bot_clusters bc ON bcm.cluster_id = bc.cluster_id
+				WHERE bcm.pubkey = f.follower AND bc.is_active = 1
+			)
+			GROUP BY f.followed
+		`, pq.Array(pubkeys)).each(func(rows *sql.Rows) error {
+			var pubkey string
+			var count int64
+			if err := rows.Scan(&pubkey, &count); err != nil {
+				return err
+			}
+			counts[pubkey] = count
+			return nil
+		})
+		return counts, err
+	}
+
+	// Until the follows index is built, scan the contact lists of each pubkey
+	for _, pubkey := range pubkeys {
+		var count int64
+		err := s.query(ctx, dbConn, "GetVerifiedFollowerCounts", `
+			SELECT COUNT(DISTINCT c.pubkey)
+			FROM event c
+			WHERE c.kind = 3
+			AND c.tags @> $1::jsonb
+			AND EXISTS (SELECT 1 FROM event p WHERE p.kind = 0 AND p.pubkey = c.pubkey)
+			AND NOT EXISTS (SELECT 1 FROM spam_candidates sc WHERE sc.pubkey = c.pubkey AND sc.purged = 0)
+			AND NOT EXISTS (
+				SELECT 1 FROM bot_cluster_members bcm
+				JOIN bot_clusters bc ON bcm.cluster_id = bc.cluster_id
+				WHERE bcm.pubkey = c.pubkey AND bc.is_active = 1
+			)
+		`, fmt.Sprintf(`[["p","%s"]]`, pubkey)).scan(&count)
+		if err != nil {
+			return counts, err
+		}
+		counts[pubkey] = count
+	}
+	return counts, nil
+}
+
 // GetSocialGraphStats returns summary statistics
 func (s *Storage) GetSocialGraphStats(ctx context.Context) (muteListCount, interestListCount, communityListCount, contactListCount int64, err error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return 0, 0, 0, 0, nil
 	}
 
-	err = dbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM event WHERE kind = 10000`).Scan(&muteListCount)
+	err = s.query(ctx, dbConn, "GetSocialGraphStats: mute lists", `SELECT COUNT(*) FROM event WHERE kind = 10000`).scan(&muteListCount)
 	if err != nil {
 		return
 	}
-	err = dbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM event WHERE kind = 10015`).Scan(&interestListCount)
+	err = s.query(ctx, dbConn, "GetSocialGraphStats: interest lists", `SELECT COUNT(*) FROM event WHERE kind = 10015`).scan(&interestListCount)
 	if err != nil {
 		return
 	}
-	err = dbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM event WHERE kind = 10004`).Scan(&communityListCount)
+	err = s.query(ctx, dbConn, "GetSocialGraphStats: community lists", `SELECT COUNT(*) FROM event WHERE kind = 10004`).scan(&communityListCount)
 	if err != nil {
 		return
 	}
-	err = dbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM event WHERE kind = 3`).Scan(&contactListCount)
+	err = s.query(ctx, dbConn, "GetSocialGraphStats: contact lists", `SELECT COUNT(*) FROM event WHERE kind = 3`).scan(&contactListCount)
 	return
 }
+
+// eachTagList calls fn with the tags of every row of a query selecting only a
+// tags column, skipping rows whose tags don't parse
+func (s *Storage) eachTagList(ctx context.Context, db execer, name, query string, fn func(tags [][]string)) error {
+	return s.query(ctx, db, name, query).each(func(rows *sql.Rows) error {
+		var tagsJSON string
+		if err := rows.Scan(&tagsJSON); err != nil {
+			return err
+		}
+		var tags [][]string
+		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
+			return nil
+		}
+		fn(tags)
+		return nil
+	})
+}
@@ -2,41 +2,44 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/fiatjaf/eventstore"
-	"github.com/fiatjaf/eventstore/lmdb"
-	"github.com/fiatjaf/eventstore/postgresql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/nbd-wtf/go-nostr"
 )
 
 type Storage struct {
-	db             eventstore.Store
+	db             eventBackend
 	archiveEnabled bool
+	archiveGate    func() bool // see SetArchiveGate
 	analyticsDB    *sqlx.DB // Separate PostgreSQL database for analytics
+	readDB         *sqlx.DB // Read-only pool for analytics reads, see ConfigurePools
+	sqlURL         string   // Database behind getDBConn, used to open the read pool
+	scanChunkSize  int
+	queryTimeout   time.Duration
+	scanStats      scanMetrics
+	integrity      relayIntegrityCounters
+	eventLog       *EventLog   // nil unless point-in-time recovery logging is enabled
+	followsReady   atomic.Bool // follows table built, see EnsureFollowsIndex
+	followsRebuild rebuildSet  // authors whose contact lists changed during RebuildFollows
+	searchReady    atomic.Bool // profile search index built, see EnsureProfileSearchIndex
+	writes         writeStats  // rows written per subsystem, see FlushWriteStats
+	ipPrivacy      ipPrivacy   // see EnableIPHashing
+	authorSets     authorSets  // see InternAuthors
+	bundles        bundleCache // see EnableProfileBundles
 }
 
 func New(backend, path string, archiveEnabled bool, analyticsDBURL string) (*Storage, error) {
-	var db eventstore.Store
-
-	switch backend {
-	case "lmdb":
-		db = &lmdb.LMDBBackend{
-			Path:    path,
-			MapSize: 1 << 34, // 16GB
-		}
-	case "postgresql":
-		db = &postgresql.PostgresBackend{
-			DatabaseURL: path,
-			QueryLimit:  1000000,
-		}
-	default:
-		return nil, fmt.Errorf("unsupported storage backend: %s (supported: lmdb, postgresql)", backend)
+	db, err := newEventBackend(backend, path)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := db.Init(); err != nil {
@@ -44,6 +47,9 @@ func New(backend, path string, archiveEnabled bool, analyticsDBURL string) (*Sto
 	}
 
 	storage := &Storage{db: db, archiveEnabled: archiveEnabled}
+	if backend == "postgresql" {
+		storage.sqlURL = path
+	}
 
 	// Connect to separate analytics database if provided (PostgreSQL only)
 	if analyticsDBURL != "" {
@@ -52,6 +58,7 @@ func New(backend, path string, archiveEnabled bool, analyticsDBURL string) (*Sto
 			return nil, fmt.Errorf("failed to connect to analytics database: %w", err)
 		}
 		storage.analyticsDB = analyticsDB
+		storage.sqlURL = analyticsDBURL
 		log.Printf("Connected to separate analytics database (PostgreSQL): %s", analyticsDBURL)
 	}
 
@@ -64,7 +71,7 @@ func New(backend, path string, archiveEnabled bool, analyticsDBURL string) (*Sto
 }
 
 func (s *Storage) SaveEvent(ctx context.Context, evt *nostr.Event) error {
-	if s.archiveEnabled && isReplaceableKind(evt.Kind) {
+	if s.archiveEnabled && isReplaceableKind(evt.Kind) && (s.archiveGate == nil || s.archiveGate()) {
 		s.archiveOldVersion(ctx, evt)
 	}
 	start := time.Now()
@@ -75,7 +82,31 @@ func (s *Storage) SaveEvent(ctx context.Context, evt *nostr.Event) error {
 	if err != nil {
 		return err
 	}
+	s.writes.add(WriteSubsystemEvents, 1)
+	s.bundles.saved(evt)
 
+	if s.eventLog != nil {
+		if err := s.eventLog.Append(evt); err != nil {
+			log.Printf("Failed to append event %s to event log: %v", evt.ID, err)
+		}
+	}
+
+	if evt.Kind == 3 {
+		if err := s.updateFollows(ctx, evt); err != nil {
+			log.Printf("Failed to update follows for %s: %v", evt.PubKey, err)
+		}
+	}
+	if evt.Kind == 0 {
+		if err := s.updateProfileSearch(ctx, evt); err != nil {
+			log.Printf("Failed to index profile of %s for search: %v", evt.PubKey, err)
+		}
+		if err := s.updateLightningAddress(ctx, evt); err != nil {
+			log.Printf("Failed to index lightning address of %s: %v", evt.PubKey, err)
+		}
+		if err := s.recordProfileChange(ctx, evt); err != nil {
+			log.Printf("Failed to record profile change of %s: %v", evt.PubKey, err)
+		}
+	}
 
 	return nil
 }
@@ -123,11 +154,40 @@ func (s *Storage) archiveOldVersion(ctx context.Context, newEvt *nostr.Event) {
 	}
 }
 
+// SetQueryTimeout bounds how long QueryEvents runs; events found by then are returned
+func (s *Storage) SetQueryTimeout(timeout time.Duration) {
+	s.queryTimeout = timeout
+}
+
 func (s *Storage) QueryEvents(ctx context.Context, filter nostr.Filter) ([]*nostr.Event, error) {
-	// Add 5 second timeout to prevent query pile-up
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	return s.QueryEventsWithAuthorSet(ctx, filter, nil)
+}
+
+// QueryEventsWithAuthorSet is QueryEvents for a filter whose authors the caller
+// already interned, so the list isn't hashed again. A nil set is interned here.
+func (s *Storage) QueryEventsWithAuthorSet(ctx context.Context, filter nostr.Filter, authors *AuthorSet) ([]*nostr.Event, error) {
+	if events, ok := s.bundles.answer(filter); ok {
+		return events, nil
+	}
+
+	// Time out to prevent query pile-up
+	timeout := s.queryTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
+	if isIDLookup(filter) {
+		return s.queryByIDs(ctx, filter)
+	}
+	if isAuthorSetQuery(filter) {
+		if authors == nil {
+			authors = s.InternAuthors(filter.Authors)
+		}
+		return s.db.QueryAuthorSet(ctx, authors, filter)
+	}
+
 	// Use eventstore's native query capabilities
 	ch, err := s.db.QueryEvents(ctx, filter)
 	if err != nil {
@@ -143,7 +203,12 @@ func (s *Storage) QueryEvents(ctx context.Context, filter nostr.Filter) ([]*nost
 }
 
 func (s *Storage) DeleteEvent(ctx context.Context, evt *nostr.Event) error {
-	return s.db.DeleteEvent(ctx, evt)
+	if err := s.db.DeleteEvent(ctx, evt); err != nil {
+		return err
+	}
+	s.writes.add(WriteSubsystemEvents, 1)
+	s.bundles.deleted(evt)
+	return nil
 }
 
 func (s *Storage) CountEventsByKind(ctx context.Context, kind int) (int64, error) {
@@ -153,43 +218,61 @@ func (s *Storage) CountEventsByKind(ctx context.Context, kind int) (int64, error
 
 // GetEventCountsByKind returns counts for all kinds stored in the database
 func (s *Storage) GetEventCountsByKind(ctx context.Context) (map[int]int64, error) {
-	// For SQL backends (SQLite/PostgreSQL), query the event table directly
-	dbConn := s.getDBConn()
-	if dbConn != nil {
-		rows, err := dbConn.QueryContext(ctx, `SELECT kind, COUNT(*) FROM event GROUP BY kind`)
-		if err == nil {
-			defer rows.Close()
-			result := make(map[int]int64)
-			for rows.Next() {
-				var kind int
-				var count int64
-				if err := rows.Scan(&kind, &count); err != nil {
-					return nil, err
-				}
-				result[kind] = count
+	// For SQL backends, query the event table directly
+	if dbConn := s.db.SQL(); dbConn != nil {
+		result := make(map[int]int64)
+		err := s.query(ctx, dbConn, "GetEventCountsByKind", `SELECT kind, COUNT(*) FROM event GROUP BY kind`).each(func(rows *sql.Rows) error {
+			var kind int
+			var count int64
+			if err := rows.Scan(&kind, &count); err != nil {
+				return err
 			}
-			return result, rows.Err()
+			result[kind] = count
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
+		return result, nil
 	}
 
 	// For LMDB: iterate through events and count by kind
 	// This is slower but works without SQL tables
 	result := make(map[int]int64)
 
-	// Query all events (no filter) and count by kind
-	ch, err := s.db.QueryEvents(ctx, nostr.Filter{})
+	err := s.ScanEvents(ctx, nostr.Filter{}, func(evt *nostr.Event) {
+		result[evt.Kind]++
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	for evt := range ch {
-		result[evt.Kind]++
+	return result, nil
+}
+
+// CountUniquePubkeys returns how many distinct authors have events stored
+func (s *Storage) CountUniquePubkeys(ctx context.Context) (int64, error) {
+	if dbConn := s.db.SQL(); dbConn != nil {
+		var count int64
+		err := s.query(ctx, dbConn, "CountUniquePubkeys", `SELECT COUNT(DISTINCT pubkey) FROM event`).scan(&count)
+		return count, err
 	}
 
-	return result, nil
+	// For LMDB: iterate through events, as GetEventCountsByKind does
+	authors := make(map[string]struct{})
+	err := s.ScanEvents(ctx, nostr.Filter{}, func(evt *nostr.Event) {
+		authors[evt.PubKey] = struct{}{}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(authors)), nil
 }
 
 func (s *Storage) Close() {
+	if s.readDB != nil {
+		s.readDB.Close()
+	}
 	s.db.Close()
 }
 
@@ -198,16 +281,20 @@ func (s *Storage) EventStore() eventstore.Store {
 	return s.db
 }
 
-// SearchProfiles searches kind:0 events for profiles matching the query
+// SearchProfiles searches kind:0 events for profiles matching the query,
+// through the profile search index once it is built
 func (s *Storage) SearchProfiles(ctx context.Context, query string, limit int) ([]*nostr.Event, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
+	if s.profileSearchReady(ctx) {
+		return s.searchProfileIndex(ctx, query, limit)
+	}
 
 	// Search in content field (which contains JSON with name, display_name, about, nip05)
 	// Also search by pubkey prefix using PostgreSQL ILIKE (case-insensitive)
-	sql := `
+	searchQuery := `
 		SELECT id, pubkey, created_at, kind, tags, content, sig
 		FROM event
 		WHERE kind = 0
@@ -218,18 +305,12 @@ func (s *Storage) SearchProfiles(ctx context.Context, query string, limit int) (
 		ORDER BY created_at DESC
 		LIMIT $3`
 
-	rows, err := dbConn.QueryContext(ctx, sql, query, query, limit*2) // Fetch extra to account for duplicates
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	seen := make(map[string]*nostr.Event)
-	for rows.Next() {
+	err := s.query(ctx, dbConn, "SearchProfiles", searchQuery, query, query, limit*2).each(func(rows *sql.Rows) error { // Fetch extra to account for duplicates
 		var evt nostr.Event
 		var tagsJSON string
 		if err := rows.Scan(&evt.ID, &evt.PubKey, &evt.CreatedAt, &evt.Kind, &tagsJSON, &evt.Content, &evt.Sig); err != nil {
-			continue
+			return err
 		}
 		if err := json.Unmarshal([]byte(tagsJSON), &evt.Tags); err != nil {
 			evt.Tags = nil
@@ -238,6 +319,10 @@ func (s *Storage) SearchProfiles(ctx context.Context, query string, limit int) (
 		if existing, ok := seen[evt.PubKey]; !ok || evt.CreatedAt > existing.CreatedAt {
 			seen[evt.PubKey] = &evt
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	results := make([]*nostr.Event, 0, len(seen))
@@ -327,3 +412,43 @@ func (s *Storage) GetProfileInfo(ctx context.Context, pubkeys []string) (map[str
 
 	return profiles, nil
 }
+
+// ProfileSummary is the part of a kind:0 profile clients show next to a pubkey
+type ProfileSummary struct {
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Picture     string `json:"picture,omitempty"`
+	Nip05       string `json:"nip05,omitempty"`
+}
+
+// GetProfileSummaries returns a map of pubkey -> ProfileSummary from the newest
+// kind:0 event of each pubkey; pubkeys without a readable profile are left out
+func (s *Storage) GetProfileSummaries(ctx context.Context, pubkeys []string) (map[string]ProfileSummary, error) {
+	if len(pubkeys) == 0 {
+		return make(map[string]ProfileSummary), nil
+	}
+
+	events, err := s.QueryEvents(ctx, nostr.Filter{
+		Kinds:   []int{0},
+		Authors: pubkeys,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make(map[string]ProfileSummary)
+	newest := make(map[string]nostr.Timestamp)
+	for _, evt := range events {
+		if at, ok := newest[evt.PubKey]; ok && at >= evt.CreatedAt {
+			continue
+		}
+		var summary ProfileSummary
+		if err := json.Unmarshal([]byte(evt.Content), &summary); err != nil {
+			continue
+		}
+		summaries[evt.PubKey] = summary
+		newest[evt.PubKey] = evt.CreatedAt
+	}
+
+	return summaries, nil
+}
@@ -2,11 +2,10 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
-	"os"
 	"time"
 
-	"github.com/fiatjaf/eventstore/lmdb"
 	"github.com/nbd-wtf/go-nostr"
 )
 
@@ -31,6 +30,14 @@ func (s *Storage) InitStorageStatsSchema() error {
 		event_count INTEGER NOT NULL,
 		recorded_at TIMESTAMP NOT NULL
 	);
+
+	CREATE TABLE IF NOT EXISTS daily_write_stats (
+		date TEXT NOT NULL,
+		subsystem TEXT NOT NULL,
+		statements BIGINT NOT NULL DEFAULT 0,
+		row_count BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (date, subsystem)
+	);
 	`
 
 	_, err := dbConn.Exec(schema)
@@ -39,68 +46,24 @@ func (s *Storage) InitStorageStatsSchema() error {
 
 // GetEventTableSize returns the size of the event table in bytes
 func (s *Storage) GetEventTableSize(ctx context.Context) (int64, error) {
-	// For LMDB, we need to get the file size
-	if lmdbBackend, ok := s.db.(*lmdb.LMDBBackend); ok {
-		stat, err := os.Stat(lmdbBackend.Path)
-		if err != nil {
-			return 0, fmt.Errorf("failed to stat LMDB file: %w", err)
-		}
-		return stat.Size(), nil
-	}
+	return s.db.DiskSize(ctx)
+}
 
-	// For SQL backends, query the database
-	dbConn := s.getDBConn()
+// GetAnalyticsDBSize returns the on-disk size of the SQL database holding the analytics tables
+func (s *Storage) GetAnalyticsDBSize(ctx context.Context) (int64, error) {
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return 0, fmt.Errorf("database connection not available")
 	}
 
 	var size int64
-	var err error
-
-	if s.isPostgres() {
-		// PostgreSQL: use pg_total_relation_size
-		err = dbConn.QueryRowContext(ctx, `SELECT pg_total_relation_size('event')`).Scan(&size)
-	} else {
-		// SQLite3: use dbstat virtual table to get page count for event table
-		// Then multiply by page size
-		var pageSize int64
-		err = dbConn.QueryRowContext(ctx, `PRAGMA page_size`).Scan(&pageSize)
-		if err != nil {
-			return 0, fmt.Errorf("failed to get page size: %w", err)
-		}
-
-		var pageCount int64
-		err = dbConn.QueryRowContext(ctx, `
-			SELECT COUNT(DISTINCT pageno)
-			FROM dbstat
-			WHERE name = 'event'
-		`).Scan(&pageCount)
-		if err == nil {
-			size = pageCount * pageSize
-		}
-	}
-
+	err := s.query(ctx, dbConn, "GetAnalyticsDBSize", `SELECT pg_database_size(current_database())`).scan(&size)
 	return size, err
 }
 
 // GetTotalEventCount returns the total number of events in the event table
 func (s *Storage) GetTotalEventCount(ctx context.Context) (int64, error) {
-	// For LMDB, use the CountEvents method with an empty filter
-	if counter, ok := s.db.(interface {
-		CountEvents(context.Context, nostr.Filter) (int64, error)
-	}); ok {
-		return counter.CountEvents(ctx, nostr.Filter{})
-	}
-
-	// For SQL backends, use direct COUNT query
-	dbConn := s.getDBConn()
-	if dbConn == nil {
-		return 0, fmt.Errorf("database connection not available")
-	}
-
-	var count int64
-	err := dbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM event`).Scan(&count)
-	return count, err
+	return s.db.CountEvents(ctx, nostr.Filter{})
 }
 
 // RecordDailyStorageSnapshot records a daily snapshot of storage stats
@@ -123,14 +86,14 @@ func (s *Storage) RecordDailyStorageSnapshot(ctx context.Context) error {
 	today := time.Now().Format("2006-01-02")
 	now := time.Now()
 
-	_, err = dbConn.ExecContext(ctx, s.rebind(`
+	_, err = s.query(ctx, dbConn, "RecordDailyStorageSnapshot: insert", `
 		INSERT INTO daily_storage_stats (date, event_table_bytes, event_count, recorded_at)
 		VALUES (?, ?, ?, ?)
 		ON CONFLICT(date) DO UPDATE SET
 			event_table_bytes = excluded.event_table_bytes,
 			event_count = excluded.event_count,
 			recorded_at = excluded.recorded_at
-	`), today, size, count, now)
+	`, today, size, count, now).exec()
 
 	if err != nil {
 		return err
@@ -138,47 +101,45 @@ func (s *Storage) RecordDailyStorageSnapshot(ctx context.Context) error {
 
 	// Cleanup old records (older than 30 days)
 	cutoffDate := time.Now().AddDate(0, 0, -30).Format("2006-01-02")
-	_, err = dbConn.ExecContext(ctx, s.rebind(`
+	_, err = s.query(ctx, dbConn, "RecordDailyStorageSnapshot: prune", `
 		DELETE FROM daily_storage_stats WHERE date < ?
-	`), cutoffDate)
+	`, cutoffDate).exec()
 
 	return err
 }
 
 // GetDailyStorageStats returns daily storage stats for the last N days
 func (s *Storage) GetDailyStorageStats(ctx context.Context, days int) ([]DailyStorageStats, error) {
-	dbConn := s.getDBConn()
+	dbConn := s.getReadDBConn()
 	if dbConn == nil {
 		return nil, nil
 	}
 
 	cutoffDate := time.Now().AddDate(0, 0, -days).Format("2006-01-02")
 
-	rows, err := dbConn.QueryContext(ctx, s.rebind(`
+	var results []DailyStorageStats
+	err := s.query(ctx, dbConn, "GetDailyStorageStats", `
 		SELECT date, event_table_bytes, event_count, recorded_at
 		FROM daily_storage_stats
 		WHERE date >= ?
 		ORDER BY date ASC
-	`), cutoffDate)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var results []DailyStorageStats
-	for rows.Next() {
+	`, cutoffDate).each(func(rows *sql.Rows) error {
 		var stat DailyStorageStats
 		if err := rows.Scan(&stat.Date, &stat.EventTableBytes, &stat.EventCount, &stat.RecordedAt); err != nil {
-			return nil, err
+			return err
 		}
 		// Calculate bytes per event
 		if stat.EventCount > 0 {
 			stat.BytesPerEvent = stat.EventTableBytes / stat.EventCount
 		}
 		results = append(results, stat)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return results, rows.Err()
+	return results, nil
 }
 
 // GetCurrentStorageInfo returns the current storage size and event count
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SyncPartner is a relay issued a sync token for mirroring this one. The token
+// is signed over the partner's ID and issue time, so neither it nor a hash of it
+// is stored.
+type SyncPartner struct {
+	ID         string
+	Name       string
+	RelayURL   string
+	IssuedAt   time.Time
+	RevokedAt  time.Time // zero while the token is valid
+	LastSeenAt time.Time // zero until the token was first used
+}
+
+// SyncPartnerUsage counts what a partner did with its token
+type SyncPartnerUsage struct {
+	NegentropySessions int64
+	Requests           int64 // REQ filters
+	Snapshots          int64
+}
+
+func (s *Storage) InitSyncPartnersSchema() error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS sync_partners (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		relay_url TEXT NOT NULL DEFAULT '',
+		issued_at INTEGER NOT NULL,
+		revoked_at INTEGER NOT NULL DEFAULT 0,
+		last_seen_at INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS sync_partner_usage (
+		partner_id TEXT NOT NULL,
+		day INTEGER NOT NULL,
+		negentropy_sessions INTEGER NOT NULL DEFAULT 0,
+		requests INTEGER NOT NULL DEFAULT 0,
+		snapshots INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (partner_id, day)
+	);
+	CREATE INDEX IF NOT EXISTS idx_sync_partner_usage_day ON sync_partner_usage(day);
+	`
+
+	_, err := dbConn.Exec(schema)
+	return err
+}
+
+func (s *Storage) SaveSyncPartner(ctx context.Context, p SyncPartner) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "SaveSyncPartner", `
+		INSERT INTO sync_partners (id, name, relay_url, issued_at)
+		VALUES (?, ?, ?, ?)
+	`, p.ID, p.Name, p.RelayURL, p.IssuedAt.Unix()).exec()
+	return err
+}
+
+// RevokeSyncPartner invalidates the partner's token, reporting whether it was valid
+func (s *Storage) RevokeSyncPartner(ctx context.Context, id string) (bool, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return false, nil
+	}
+
+	result, err := s.query(ctx, dbConn, "RevokeSyncPartner", `
+		UPDATE sync_partners SET revoked_at = ? WHERE id = ? AND revoked_at = 0
+	`, time.Now().Unix(), id).exec()
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetSyncPartners returns issued partners, newest first; with activeOnly, only unrevoked ones
+func (s *Storage) GetSyncPartners(ctx context.Context, activeOnly bool) ([]SyncPartner, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	q := `SELECT id, name, relay_url, issued_at, revoked_at, last_seen_at FROM sync_partners`
+	if activeOnly {
+		q += ` WHERE revoked_at = 0`
+	}
+	q += ` ORDER BY issued_at DESC`
+
+	var partners []SyncPartner
+	err := s.query(ctx, dbConn, "GetSyncPartners", q).each(func(rows *sql.Rows) error {
+		var p SyncPartner
+		var issuedAt, revokedAt, lastSeenAt int64
+		if err := rows.Scan(&p.ID, &p.Name, &p.RelayURL, &issuedAt, &revokedAt, &lastSeenAt); err != nil {
+			return err
+		}
+		p.IssuedAt = time.Unix(issuedAt, 0)
+		if revokedAt > 0 {
+			p.RevokedAt = time.Unix(revokedAt, 0)
+		}
+		if lastSeenAt > 0 {
+			p.LastSeenAt = time.Unix(lastSeenAt, 0)
+		}
+		partners = append(partners, p)
+		return nil
+	})
+
+	return partners, err
+}
+
+// AddSyncPartnerUsage adds usage to the partner's count for the UTC day of at
+// and moves its last seen time forward
+func (s *Storage) AddSyncPartnerUsage(ctx context.Context, id string, usage SyncPartnerUsage, at time.Time) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	day := at.UTC().Truncate(24 * time.Hour).Unix()
+	_, err := s.query(ctx, dbConn, "AddSyncPartnerUsage", `
+		INSERT INTO sync_partner_usage (partner_id, day, negentropy_sessions, requests, snapshots)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(partner_id, day) DO UPDATE SET
+			negentropy_sessions = sync_partner_usage.negentropy_sessions + excluded.negentropy_sessions,
+			requests = sync_partner_usage.requests + excluded.requests,
+			snapshots = sync_partner_usage.snapshots + excluded.snapshots
+	`, id, day, usage.NegentropySessions, usage.Requests, usage.Snapshots).exec()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.query(ctx, dbConn, "AddSyncPartnerUsage: last seen", `
+		UPDATE sync_partners SET last_seen_at = ? WHERE id = ? AND last_seen_at < ?
+	`, at.Unix(), id, at.Unix()).exec()
+	return err
+}
+
+// GetSyncPartnerUsage sums each partner's usage over the last days
+func (s *Storage) GetSyncPartnerUsage(ctx context.Context, days int) (map[string]SyncPartnerUsage, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1)).Unix()
+	usage := make(map[string]SyncPartnerUsage)
+	err := s.query(ctx, dbConn, "GetSyncPartnerUsage", `
+		SELECT partner_id, SUM(negentropy_sessions), SUM(requests), SUM(snapshots)
+		FROM sync_partner_usage
+		WHERE day >= ?
+		GROUP BY partner_id
+	`, since).each(func(rows *sql.Rows) error {
+		var id string
+		var u SyncPartnerUsage
+		if err := rows.Scan(&id, &u.NegentropySessions, &u.Requests, &u.Snapshots); err != nil {
+			return err
+		}
+		usage[id] = u
+		return nil
+	})
+
+	return usage, err
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// TrustDecayAfter is how long trust lasts unless a trust analysis or an
+	// incremental check confirms it again. The analysis runs hourly, so this only
+	// drops pubkeys it stopped confirming, or everyone once it stops running.
+	TrustDecayAfter = 6 * time.Hour
+	// TrustRevocationHold is how long a revoked pubkey can't be trusted again
+	TrustRevocationHold = 7 * 24 * time.Hour
+)
+
+// TrustRevocation is a pubkey whose trust was revoked on compromise signals
+type TrustRevocation struct {
+	Pubkey    string
+	Signals   []string
+	RevokedAt time.Time
+}
+
+// GetTrustedPubkeysConfirmed returns the trusted pubkeys whose trust hasn't
+// decayed, with when it was last confirmed
+func (s *Storage) GetTrustedPubkeysConfirmed(ctx context.Context) (map[string]time.Time, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	confirmed := make(map[string]time.Time)
+	err := s.query(ctx, dbConn, "GetTrustedPubkeysConfirmed", `
+		SELECT pubkey, trusted_at FROM trusted_pubkeys WHERE trusted_at >= ?
+	`, time.Now().Add(-TrustDecayAfter).Unix()).each(func(rows *sql.Rows) error {
+		var pubkey string
+		var at int64
+		if err := rows.Scan(&pubkey, &at); err != nil {
+			return err
+		}
+		confirmed[pubkey] = time.Unix(at, 0)
+		return nil
+	})
+	return confirmed, err
+}
+
+// PruneDecayedTrust deletes trusted pubkeys not confirmed within TrustDecayAfter,
+// so queries joining trusted_pubkeys stop counting them
+func (s *Storage) PruneDecayedTrust(ctx context.Context) (int64, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return 0, nil
+	}
+
+	result, err := s.query(ctx, dbConn, "PruneDecayedTrust", `
+		DELETE FROM trusted_pubkeys WHERE trusted_at < ?
+	`, time.Now().Add(-TrustDecayAfter).Unix()).exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RevokeTrustedPubkey removes pubkey from the trusted set and logs why
+func (s *Storage) RevokeTrustedPubkey(ctx context.Context, pubkey string, signals []string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	return s.inTx(ctx, dbConn, "RevokeTrustedPubkey", func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := s.query(ctx, tx, "RevokeTrustedPubkey: delete", `
+			DELETE FROM trusted_pubkeys WHERE pubkey = ?
+		`, pubkey).exec(); err != nil {
+			return err
+		}
+		_, err := s.query(ctx, tx, "RevokeTrustedPubkey: log", `
+			INSERT INTO trust_revocations (pubkey, signals, revoked_at) VALUES (?, ?, ?)
+		`, pubkey, strings.Join(signals, ","), time.Now().Unix()).exec()
+		return err
+	})
+}
+
+// GetRevokedPubkeys returns the pubkeys whose trust was revoked since the given time
+func (s *Storage) GetRevokedPubkeys(ctx context.Context, since time.Time) (map[string]bool, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	revoked := make(map[string]bool)
+	err := s.query(ctx, dbConn, "GetRevokedPubkeys", `
+		SELECT DISTINCT pubkey FROM trust_revocations WHERE revoked_at >= ?
+	`, since.Unix()).each(func(rows *sql.Rows) error {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return err
+		}
+		revoked[pubkey] = true
+		return nil
+	})
+	return revoked, err
+}
+
+// IsTrustRevoked reports whether pubkey's trust was revoked within TrustRevocationHold
+func (s *Storage) IsTrustRevoked(ctx context.Context, pubkey string) (bool, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return false, nil
+	}
+
+	var count int
+	err := s.query(ctx, dbConn, "IsTrustRevoked", `
+		SELECT COUNT(*) FROM trust_revocations WHERE pubkey = ? AND revoked_at >= ?
+	`, pubkey, time.Now().Add(-TrustRevocationHold).Unix()).scan(&count)
+	return count > 0, err
+}
+
+// GetTrustRevocations returns the latest revocations, newest first
+func (s *Storage) GetTrustRevocations(ctx context.Context, limit int) ([]TrustRevocation, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var revocations []TrustRevocation
+	err := s.query(ctx, dbConn, "GetTrustRevocations", `
+		SELECT pubkey, signals, revoked_at
+		FROM trust_revocations
+		ORDER BY revoked_at DESC
+		LIMIT ?
+	`, limit).each(func(rows *sql.Rows) error {
+		var r TrustRevocation
+		var signals string
+		var at int64
+		if err := rows.Scan(&r.Pubkey, &signals, &at); err != nil {
+			return err
+		}
+		r.Signals = strings.Split(signals, ",")
+		r.RevokedAt = time.Unix(at, 0)
+		revocations = append(revocations, r)
+		return nil
+	})
+	return revocations, err
+}
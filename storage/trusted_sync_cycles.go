@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TrustedSyncCycle is one pass of trusted sync over every trusted pubkey. Its
+// progress is stored as pubkeys are synced, so a restart resumes the pass.
+type TrustedSyncCycle struct {
+	ID          int64 // unix time the cycle started
+	Total       int   // trusted pubkeys when the cycle last ran
+	Processed   int
+	CompletedAt time.Time // zero while the cycle is running
+}
+
+func (c *TrustedSyncCycle) StartedAt() time.Time {
+	return time.Unix(c.ID, 0)
+}
+
+// Percent is how much of the cycle is done
+func (c *TrustedSyncCycle) Percent() float64 {
+	if !c.CompletedAt.IsZero() {
+		return 100
+	}
+	if c.Total == 0 {
+		return 0
+	}
+	return min(float64(c.Processed)/float64(c.Total)*100, 100)
+}
+
+// GetLatestTrustedSyncCycle returns the running cycle, or the last completed
+// one if none is running, or nil before the first cycle
+func (s *Storage) GetLatestTrustedSyncCycle(ctx context.Context) (*TrustedSyncCycle, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	var c TrustedSyncCycle
+	var completedAt int64
+	err := s.query(ctx, dbConn, "GetLatestTrustedSyncCycle", `
+		SELECT id, total, processed, completed_at
+		FROM trusted_sync_cycles
+		ORDER BY id DESC
+		LIMIT 1
+	`).scan(&c.ID, &c.Total, &c.Processed, &completedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if completedAt > 0 {
+		c.CompletedAt = time.Unix(completedAt, 0)
+	}
+	return &c, nil
+}
+
+// StartTrustedSyncCycle opens a cycle over total trusted pubkeys
+func (s *Storage) StartTrustedSyncCycle(ctx context.Context, total int) (*TrustedSyncCycle, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	c := &TrustedSyncCycle{ID: time.Now().Unix(), Total: total}
+	_, err := s.query(ctx, dbConn, "StartTrustedSyncCycle", `
+		INSERT INTO trusted_sync_cycles (id, total) VALUES (?, ?)
+		ON CONFLICT(id) DO NOTHING
+	`, c.ID, c.Total).exec()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetTrustedSyncCycleTotal updates how many pubkeys the cycle covers, as the
+// trusted set changes while it runs
+func (s *Storage) SetTrustedSyncCycleTotal(ctx context.Context, cycleID int64, total int) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	_, err := s.query(ctx, dbConn, "SetTrustedSyncCycleTotal", `
+		UPDATE trusted_sync_cycles SET total = ? WHERE id = ?
+	`, total, cycleID).exec()
+	return err
+}
+
+// GetTrustedSyncCycleProcessed returns the pubkeys the cycle already synced
+func (s *Storage) GetTrustedSyncCycleProcessed(ctx context.Context, cycleID int64) (map[string]bool, error) {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	processed := make(map[string]bool)
+	err := s.query(ctx, dbConn, "GetTrustedSyncCycleProcessed", `
+		SELECT pubkey FROM trusted_sync_cycle_progress WHERE cycle_id = ?
+	`, cycleID).each(func(rows *sql.Rows) error {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return err
+		}
+		processed[pubkey] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return processed, nil
+}
+
+// MarkTrustedSyncCycleProcessed records that the cycle synced pubkey
+func (s *Storage) MarkTrustedSyncCycleProcessed(ctx context.Context, cycleID int64, pubkey string) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	return s.inTx(ctx, dbConn, "MarkTrustedSyncCycleProcessed", func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := s.query(ctx, tx, "MarkTrustedSyncCycleProcessed", `
+			INSERT INTO trusted_sync_cycle_progress (cycle_id, pubkey) VALUES (?, ?)
+			ON CONFLICT(cycle_id, pubkey) DO NOTHING
+		`, cycleID, pubkey).exec()
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		_, err = s.query(ctx, tx, "MarkTrustedSyncCycleProcessed: count", `
+			UPDATE trusted_sync_cycles SET processed = processed + 1 WHERE id = ?
+		`, cycleID).exec()
+		return err
+	})
+}
+
+// CompleteTrustedSyncCycle closes the cycle and drops its per-pubkey progress
+func (s *Storage) CompleteTrustedSyncCycle(ctx context.Context, cycleID int64) error {
+	dbConn := s.getDBConn()
+	if dbConn == nil {
+		return nil
+	}
+
+	return s.inTx(ctx, dbConn, "CompleteTrustedSyncCycle", func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := s.query(ctx, tx, "CompleteTrustedSyncCycle", `
+			UPDATE trusted_sync_cycles SET completed_at = ? WHERE id = ?
+		`, time.Now().Unix(), cycleID).exec()
+		if err != nil {
+			return err
+		}
+		_, err = s.query(ctx, tx, "CompleteTrustedSyncCycle: progress", `
+			DELETE FROM trusted_sync_cycle_progress WHERE cycle_id = ?
+		`, cycleID).exec()
+		return err
+	})
+}
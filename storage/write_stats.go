@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Subsystems rows written by the storage layer are attributed to, by the
+// table they land in
+const (
+	WriteSubsystemEvents       = "events"        // stored events, their history and bookkeeping
+	WriteSubsystemREQAnalytics = "req_analytics" // REQ counts, co-occurrence and interest edges
+	WriteSubsystemDiscovery    = "discovery"     // discovered relays, census and upstream relay stats
+	WriteSubsystemHydration    = "hydration"     // profile fetch attempts and outcomes
+	WriteSubsystemTrustedSync  = "trusted_sync"  // trusted sync state and per-relay stats
+	WriteSubsystemTrust        = "trust"         // trust, spam, bot cluster and report records
+	WriteSubsystemCache        = "cache_refresh" // derived tables and indexes rebuilt from events
+	WriteSubsystemOther        = "other"
+)
+
+// writeSubsystems maps tables to the subsystem writing them. Derived tables
+// rebuilt through "<name>_next" shadows count as cache refreshes whatever
+// their name.
+var writeSubsystems = map[string]string{
+	"event":                   WriteSubsystemEvents,
+	"event_history":           WriteSubsystemEvents,
+	"event_provenance":        WriteSubsystemEvents,
+	"invalid_events":          WriteSubsystemEvents,
+	"daily_kind_counts":       WriteSubsystemEvents,
+	"pubkey_daily_quota":      WriteSubsystemEvents,
+	"rejected_events_by_kind": WriteSubsystemEvents,
+	"origin_rejections":       WriteSubsystemEvents,
+	"oversize_attempts":       WriteSubsystemEvents,
+	"profile_identities":      WriteSubsystemEvents,
+	"profile_changes":         WriteSubsystemEvents,
+
+	"req_analytics":          WriteSubsystemREQAnalytics,
+	"req_analytics_by_kind":  WriteSubsystemREQAnalytics,
+	"req_cooccurrence":       WriteSubsystemREQAnalytics,
+	"req_interest_edges":     WriteSubsystemREQAnalytics,
+	"req_kind_stats":         WriteSubsystemREQAnalytics,
+	"req_kind_stats_daily":   WriteSubsystemREQAnalytics,
+	"daily_requests":         WriteSubsystemREQAnalytics,
+	"hourly_requests":        WriteSubsystemREQAnalytics,
+	"hourly_region_requests": WriteSubsystemREQAnalytics,
+	"privacy_rejected_reqs":  WriteSubsystemREQAnalytics,
+	"rejected_req_kinds":     WriteSubsystemREQAnalytics,
+
+	"discovered_relays":   WriteSubsystemDiscovery,
+	"relay_blocks":        WriteSubsystemDiscovery,
+	"relay_info":          WriteSubsystemDiscovery,
+	"relay_integrity":     WriteSubsystemDiscovery,
+	"relay_capabilities":  WriteSubsystemDiscovery,
+	"relay_auth":          WriteSubsystemDiscovery,
+	"outbox_probe_runs":   WriteSubsystemDiscovery,
+	"outbox_probe_relays": WriteSubsystemDiscovery,
+
+	"profile_fetch_attempts": WriteSubsystemHydration,
+	"hydration_outcomes":     WriteSubsystemHydration,
+
+	"trusted_sync_state":          WriteSubsystemTrustedSync,
+	"trusted_sync_relay_stats":    WriteSubsystemTrustedSync,
+	"trusted_sync_cycles":         WriteSubsystemTrustedSync,
+	"trusted_sync_cycle_progress": WriteSubsystemTrustedSync,
+
+	"trusted_pubkeys":           WriteSubsystemTrust,
+	"trust_revocations":         WriteSubsystemTrust,
+	"spam_candidates":           WriteSubsystemTrust,
+	"bot_clusters":              WriteSubsystemTrust,
+	"bot_cluster_members":       WriteSubsystemTrust,
+	"bot_cluster_exemptions":    WriteSubsystemTrust,
+	"impersonation_candidates":  WriteSubsystemTrust,
+	"duplicate_profile_groups":  WriteSubsystemTrust,
+	"duplicate_profile_members": WriteSubsystemTrust,
+	"scraper_candidates":        WriteSubsystemTrust,
+	"abuse_reports":             WriteSubsystemTrust,
+
+	"derived_table_refreshes": WriteSubsystemCache,
+	"follows":                 WriteSubsystemCache,
+	"follows_state":           WriteSubsystemCache,
+	"follower_counts":         WriteSubsystemCache,
+	"cached_most_muted":       WriteSubsystemCache,
+	"profile_search":          WriteSubsystemCache,
+	"profile_hashtags":        WriteSubsystemCache,
+	"lightning_addresses":     WriteSubsystemCache,
+	"profile_change_velocity": WriteSubsystemCache,
+	"communities":             WriteSubsystemCache,
+	"community_edges":         WriteSubsystemCache,
+	"community_members":       WriteSubsystemCache,
+	"community_stats":         WriteSubsystemCache,
+}
+
+// writeTablePattern finds the table a statement writes to
+var writeTablePattern = regexp.MustCompile(`(?i)\b(?:INSERT\s+INTO|UPDATE|DELETE\s+FROM)\s+([a-z_0-9]+)`)
+
+// WriteStat counts the statements and rows one subsystem wrote on one day
+type WriteStat struct {
+	Date       string
+	Subsystem  string
+	Statements int64
+	Rows       int64
+}
+
+// maxClassifiedStatements caps the statements whose subsystem is remembered;
+// statements built with a varying number of placeholders would grow it forever
+const maxClassifiedStatements = 4096
+
+// writeStats buffers write counts per subsystem between flushes
+type writeStats struct {
+	mu     sync.Mutex
+	counts map[string]*WriteStat
+	// subsystem of each statement seen, "" for statements that don't write
+	subsystems map[string]string
+}
+
+// subsystem returns the subsystem a statement writes for, or "" if it
+// doesn't write or only writes the write stats themselves
+func (w *writeStats) subsystem(query string) string {
+	w.mu.Lock()
+	cached, ok := w.subsystems[query]
+	w.mu.Unlock()
+	if ok {
+		return cached
+	}
+
+	subsystem := ""
+	if m := writeTablePattern.FindStringSubmatch(query); m != nil {
+		table := strings.ToLower(m[1])
+		switch {
+		case table == "daily_write_stats":
+		case strings.HasSuffix(table, shadowSuffix):
+			subsystem = WriteSubsystemCache
+		case writeSubsystems[table] != "":
+			subsystem = writeSubsystems[table]
+		default:
+			subsystem = WriteSubsystemOther
+		}
+	}
+
+	w.mu.Lock()
+	if w.subsystems == nil {
+		w.subsystems = make(map[string]string)
+	}
+	if len(w.subsystems) < maxClassifiedStatements {
+		w.subsystems[query] = subsystem
+	}
+	w.mu.Unlock()
+	return subsystem
+}
+
+// add counts a statement of subsystem writing rows rows
+func (w *writeStats) add(subsystem string, rows int64) {
+	if subsystem == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.counts == nil {
+		w.counts = make(map[string]*WriteStat)
+	}
+	c := w.counts[subsystem]
+	if c == nil {
+		c = &WriteStat{Subsystem: subsystem}
+		w.counts[subsystem] = c
+	}
+	c.Statements++
+	c.Rows += rows
+}
+
+// FlushWriteStats adds the write counts gathered since the last flush to
+// today's row of each subsystem in daily_write_stats
+func (s *Storage) FlushWriteStats(ctx context.Context) error {
+	s.writes.mu.Lock()
+	counts := s.writes.counts
+	s.writes.counts = nil
+	s.writes.mu.Unlock()
+
+	dbConn := s.getDBConn()
+	if dbConn == nil || len(counts) == 0 {
+		return nil
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	return s.inTx(ctx, dbConn, "FlushWriteStats", func(ctx context.Context, tx *sqlx.Tx) error {
+		for _, c := range counts {
+			_, err := s.query(ctx, tx, "FlushWriteStats", `
+				INSERT INTO daily_write_stats (date, subsystem, statements, row_count)
+				VALUES (?, ?, ?, ?)
+				ON CONFLICT(date, subsystem) DO UPDATE SET
+					statements = daily_write_stats.statements + excluded.statements,
+					row_count = daily_write_stats.row_count + excluded.row_count
+			`, today, c.Subsystem, c.Statements, c.Rows).exec()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetDailyWriteStats returns the write counts of the last days days, newest
+// day first and the busiest subsystem first within a day
+func (s *Storage) GetDailyWriteStats(ctx context.Context, days int) ([]WriteStat, error) {
+	dbConn := s.getReadDBConn()
+	if dbConn == nil {
+		return nil, nil
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -days+1).Format("2006-01-02")
+	var stats []WriteStat
+	err := s.query(ctx, dbConn, "GetDailyWriteStats", `
+		SELECT date, subsystem, statements, row_count FROM daily_write_stats
+		WHERE date >= ?
+	`, since).each(func(rows *sql.Rows) error {
+		var stat WriteStat
+		if err := rows.Scan(&stat.Date, &stat.Subsystem, &stat.Statements, &stat.Rows); err != nil {
+			return err
+		}
+		stats = append(stats, stat)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Date != stats[j].Date {
+			return stats[i].Date > stats[j].Date
+		}
+		return stats[i].Rows > stats[j].Rows
+	})
+	return stats, nil
+}
@@ -2,18 +2,38 @@ package sync
 
 import (
 	"context"
+	"errors"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/relay"
 	"github.com/pablof7z/purplepag.es/storage"
 )
 
+// errAuthRequired ends a relay's sync when it closes our subscription for auth we can't give
+var errAuthRequired = errors.New("relay requires NIP-42 auth")
+
 type Syncer struct {
 	storage      *storage.Storage
 	allowedKinds []int
 	relays       []string
+	credentials  *relay.Credentials
+
+	savedEvents atomic.Int64
+	relaysDone  atomic.Int64
+	finished    atomic.Bool
+}
+
+// Progress is how far SyncAll has got
+type Progress struct {
+	Events     int64 `json:"events"`      // new events saved
+	RelaysDone int   `json:"relays_done"` // relays finished, successfully or not
+	Relays     int   `json:"relays"`
+	Finished   bool  `json:"finished"`
 }
 
 func NewSyncer(storage *storage.Storage, allowedKinds []int, relays []string) *Syncer {
@@ -24,13 +44,30 @@ func NewSyncer(storage *storage.Storage, allowedKinds []int, relays []string) *S
 	}
 }
 
+// SetCredentials sets the keys used to authenticate to relays that require NIP-42
+func (s *Syncer) SetCredentials(credentials *relay.Credentials) {
+	s.credentials = credentials
+}
+
+// Progress reports how far SyncAll has got; safe to call while it runs
+func (s *Syncer) Progress() Progress {
+	return Progress{
+		Events:     s.savedEvents.Load(),
+		RelaysDone: int(s.relaysDone.Load()),
+		Relays:     len(s.relays),
+		Finished:   s.finished.Load(),
+	}
+}
+
 func (s *Syncer) SyncAll(ctx context.Context) error {
 	var wg sync.WaitGroup
+	defer s.finished.Store(true)
 
 	for _, relayURL := range s.relays {
 		wg.Add(1)
 		go func(url string) {
 			defer wg.Done()
+			defer s.relaysDone.Add(1)
 			if err := s.syncRelay(ctx, url); err != nil {
 				log.Printf("Failed to sync from %s: %v", url, err)
 				return
@@ -54,6 +91,9 @@ func (s *Syncer) syncRelay(ctx context.Context, relayURL string) error {
 
 	for _, kind := range s.allowedKinds {
 		if err := s.syncKind(ctx, relay, kind); err != nil {
+			if errors.Is(err, errAuthRequired) {
+				return err
+			}
 			log.Printf("Failed to sync kind %d from %s: %v", kind, relayURL, err)
 		}
 	}
@@ -109,7 +149,8 @@ func (s *Syncer) syncKindBatch(ctx context.Context, relay *nostr.Relay, kind int
 	if err != nil {
 		return 0, 0, nil, err
 	}
-	defer sub.Unsub()
+	defer func() { sub.Unsub() }()
+	authed := false
 
 	// Idle timeout - resets each time we receive an event
 	idleTimeout := 30 * time.Second
@@ -142,9 +183,23 @@ func (s *Syncer) syncKindBatch(ctx context.Context, relay *nostr.Relay, kind int
 			}
 			timer.Reset(idleTimeout)
 
-			if err := s.storage.SaveEvent(ctx, evt); err == nil {
+			if err := s.storage.SaveUpstreamEvent(ctx, relay.URL, evt); err == nil {
 				newEvents++
+				s.savedEvents.Add(1)
+			}
+		case reason := <-sub.ClosedReason:
+			if !authed {
+				authed = true
+				if retry := s.credentials.Resubscribe(ctx, s.storage, relay, reason, nostr.Filters{filter}); retry != nil {
+					sub.Unsub()
+					sub = retry
+					continue
+				}
+			}
+			if strings.HasPrefix(reason, "auth-required:") {
+				return eventCount, newEvents, oldestTime, errAuthRequired
 			}
+			return eventCount, newEvents, oldestTime, errors.New(reason)
 		case <-sub.EndOfStoredEvents:
 			return eventCount, newEvents, oldestTime, nil
 		}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	gosync "sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pablof7z/purplepag.es/config"
+	"github.com/pablof7z/purplepag.es/storage"
+)
+
+// syncPlanRow is one relay and kind of `purplepages sync-plan`
+type syncPlanRow struct {
+	Relay  string `json:"relay"`
+	Kind   int    `json:"kind"`
+	Remote int64  `json:"remote"`
+	Local  int64  `json:"local"`
+	// Estimated events a sync would pull: the remote count beyond what we hold.
+	// It's a lower bound, since both sides may hold events the other lacks.
+	Missing int64  `json:"missing"`
+	Error   string `json:"error,omitempty"`
+}
+
+func runSyncPlanCommand(args []string) {
+	planFlags := flag.NewFlagSet("sync-plan", flag.ExitOnError)
+	asJSON := planFlags.Bool("json", false, "Print the plan as JSON")
+	timeout := planFlags.Duration("timeout", 15*time.Second, "Timeout per relay COUNT query")
+	planFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages sync-plan [options] [relay-url...]\n\n")
+		fmt.Fprintf(os.Stderr, "Estimate what a full sync would pull from each relay, per kind, without syncing.\n")
+		fmt.Fprintf(os.Stderr, "Relays default to sync.relays from config.json. Remote counts use NIP-45 COUNT.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		planFlags.PrintDefaults()
+	}
+
+	if err := planFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load("config.json")
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	relays := cfg.Sync.Relays
+	if planFlags.NArg() > 0 {
+		relays = nil
+		for _, url := range planFlags.Args() {
+			if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+				url = "wss://" + url
+			}
+			relays = append(relays, url)
+		}
+	}
+	if len(relays) == 0 {
+		log.Fatalf("No relays to plan for: configure sync.relays or pass relay URLs")
+	}
+
+	kinds := cfg.Sync.Kinds
+	if len(kinds) == 0 {
+		kinds = cfg.SyncKinds
+	}
+
+	store, err := storage.New(cfg.Storage.Backend, cfg.Storage.Path, false, cfg.Storage.AnalyticsDBURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	rows := planSync(ctx, store, relays, kinds, *timeout)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			log.Fatalf("Failed to encode plan: %v", err)
+		}
+		return
+	}
+
+	printSyncPlan(rows)
+}
+
+// planSync counts each kind locally once and on every relay in parallel
+func planSync(ctx context.Context, store *storage.Storage, relays []string, kinds []int, timeout time.Duration) []syncPlanRow {
+	local := make(map[int]int64, len(kinds))
+	for _, kind := range kinds {
+		count, err := store.CountEvents(ctx, nostr.Filter{Kinds: []int{kind}})
+		if err != nil {
+			log.Printf("Failed to count local kind %d: %v", kind, err)
+		}
+		local[kind] = count
+	}
+
+	perRelay := make([][]syncPlanRow, len(relays))
+	var wg gosync.WaitGroup
+	for i, url := range relays {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			perRelay[i] = planRelay(ctx, url, kinds, local, timeout)
+		}(i, url)
+	}
+	wg.Wait()
+
+	var rows []syncPlanRow
+	for _, r := range perRelay {
+		rows = append(rows, r...)
+	}
+	return rows
+}
+
+func planRelay(ctx context.Context, url string, kinds []int, local map[int]int64, timeout time.Duration) []syncPlanRow {
+	rows := make([]syncPlanRow, 0, len(kinds))
+
+	connectCtx, cancel := context.WithTimeout(ctx, timeout)
+	relay, err := nostr.RelayConnect(connectCtx, url)
+	cancel()
+	if err != nil {
+		for _, kind := range kinds {
+			rows = append(rows, syncPlanRow{Relay: url, Kind: kind, Local: local[kind], Error: fmt.Sprintf("connect: %v", err)})
+		}
+		return rows
+	}
+	defer relay.Close()
+
+	for _, kind := range kinds {
+		row := syncPlanRow{Relay: url, Kind: kind, Local: local[kind]}
+
+		countCtx, cancel := context.WithTimeout(ctx, timeout)
+		remote, _, err := relay.Count(countCtx, nostr.Filters{{Kinds: []int{kind}}})
+		cancel()
+		if err != nil {
+			row.Error = fmt.Sprintf("count unsupported or failed: %v", err)
+		} else {
+			row.Remote = remote
+			if remote > row.Local {
+				row.Missing = remote - row.Local
+			}
+		}
+
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func printSyncPlan(rows []syncPlanRow) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Relay\tKind\tRemote\tLocal\tEst. missing\n")
+
+	missing := make(map[string]int64)
+	var relays []string
+	for _, r := range rows {
+		if _, ok := missing[r.Relay]; !ok {
+			relays = append(relays, r.Relay)
+			missing[r.Relay] = 0
+		}
+		if r.Error != "" {
+			fmt.Fprintf(w, "%s\t%d\t-\t%d\t%s\n", r.Relay, r.Kind, r.Local, r.Error)
+			continue
+		}
+		missing[r.Relay] += r.Missing
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", r.Relay, r.Kind, r.Remote, r.Local, r.Missing)
+	}
+
+	fmt.Fprintf(w, "\nRelay\tEst. events to pull\n")
+	for _, relay := range relays {
+		fmt.Fprintf(w, "%s\t%d\n", relay, missing[relay])
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,134 @@
+package templates
+
+import (
+	"embed"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pablof7z/purplepag.es/imgproxy"
+	"github.com/pablof7z/purplepag.es/kinds"
+	"github.com/pablof7z/purplepag.es/static"
+)
+
+// Default templates compiled into the binary, used when no override exists
+//
+//go:embed html/*.html
+var defaults embed.FS
+
+// Funcs available to every template, on top of the ones passed to Get
+var builtins = template.FuncMap{
+	"asset":    static.URL,
+	"avatar":   imgproxy.URL,
+	"kindName": kinds.Name,
+	"notice":   currentNotice,
+}
+
+// notice is shown at the top of every stats page, e.g. when analytics are disabled
+var (
+	noticeMu sync.RWMutex
+	notice   string
+)
+
+// SetNotice sets the banner stats pages render with {{notice}}; empty removes it
+func SetNotice(msg string) {
+	noticeMu.Lock()
+	defer noticeMu.Unlock()
+	notice = msg
+}
+
+func currentNotice() template.HTML {
+	noticeMu.RLock()
+	defer noticeMu.RUnlock()
+	if notice == "" {
+		return ""
+	}
+	return template.HTML(`<div style="background: #2d2000; border: 1px solid #9e6a03; color: #f0f6fc; border-radius: 6px; padding: 0.75rem 1rem; margin-bottom: 1rem; font-size: 0.875rem;">` +
+		template.HTMLEscapeString(notice) + `</div>`)
+}
+
+type cached struct {
+	tmpl    *template.Template
+	path    string // override file the template was parsed from, empty for the default
+	modTime time.Time
+}
+
+var (
+	mu          sync.Mutex
+	overrideDir string
+	cache       = make(map[string]*cached)
+)
+
+// SetOverrideDir makes Get look for <name>.html in dir before falling back to the
+// embedded defaults. Override files are re-read whenever their mtime changes.
+func SetOverrideDir(dir string) {
+	mu.Lock()
+	defer mu.Unlock()
+	overrideDir = dir
+	cache = make(map[string]*cached)
+}
+
+// Get returns the named page template, preferring an override from disk. An
+// override that fails to parse is logged once and the previous version keeps serving.
+func Get(name string, funcs template.FuncMap) (*template.Template, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current := cache[name]
+
+	if overrideDir != "" {
+		path := filepath.Join(overrideDir, name+".html")
+		if info, err := os.Stat(path); err == nil {
+			if current != nil && current.path == path && current.modTime.Equal(info.ModTime()) {
+				return current.tmpl, nil
+			}
+
+			tmpl, err := parseFile(name, path, funcs)
+			if err != nil {
+				log.Printf("Templates: failed to load %s: %v", path, err)
+				if current != nil {
+					tmpl = current.tmpl
+				} else if tmpl, err = parseDefault(name, funcs); err != nil {
+					return nil, err
+				}
+			} else if current != nil {
+				log.Printf("Templates: reloaded %s", path)
+			}
+
+			// Remember the mtime even on failure so a broken file isn't re-parsed every request
+			cache[name] = &cached{tmpl: tmpl, path: path, modTime: info.ModTime()}
+			return tmpl, nil
+		}
+	}
+
+	// No override (or it was removed), serve the default
+	if current != nil && current.path == "" {
+		return current.tmpl, nil
+	}
+
+	tmpl, err := parseDefault(name, funcs)
+	if err != nil {
+		return nil, err
+	}
+	cache[name] = &cached{tmpl: tmpl}
+	return tmpl, nil
+}
+
+func parseFile(name, path string, funcs template.FuncMap) (*template.Template, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(name).Funcs(builtins).Funcs(funcs).Parse(string(content))
+}
+
+func parseDefault(name string, funcs template.FuncMap) (*template.Template, error) {
+	content, err := defaults.ReadFile("html/" + name + ".html")
+	if err != nil {
+		return nil, err
+	}
+	return template.New(name).Funcs(builtins).Funcs(funcs).Parse(string(content))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pablof7z/purplepag.es/analytics"
+)
+
+func runTrustSimulateCommand(args []string) {
+	simulateFlags := flag.NewFlagSet("trust-simulate", flag.ExitOnError)
+	minFollowers := simulateFlags.Int("min-followers", 0, "Trusted followers a pubkey needs to become trusted (required)")
+	limit := simulateFlags.Int("limit", 50, "Number of dropped pubkeys to list, 0 for all")
+	simulateFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: purplepages trust-simulate --min-followers <n> [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Run trust propagation with a different trusted follower threshold and compare the\n")
+		fmt.Fprintf(os.Stderr, "result with the current trusted set, without changing anything.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		simulateFlags.PrintDefaults()
+	}
+
+	if err := simulateFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if *minFollowers <= 0 {
+		simulateFlags.Usage()
+		os.Exit(1)
+	}
+
+	cfg, store := openImportStorage()
+	defer store.Close()
+
+	if !store.AnalyticsEnabled() {
+		log.Fatalf("Analytics disabled: no SQL database configured")
+	}
+
+	ctx := context.Background()
+	trustAnalyzer := analytics.NewTrustAnalyzer(store, analytics.NewClusterDetector(store), cfg.Limits.MinTrustedFollowers)
+	sim, err := trustAnalyzer.SimulateTrust(ctx, analytics.LoadFollowGraph(ctx, store), *minFollowers)
+	if err != nil {
+		log.Fatalf("Simulation failed: %v", err)
+	}
+
+	fmt.Printf("Threshold:        %d trusted followers (configured: %d)\n", sim.MinTrustedFollowers, cfg.Limits.MinTrustedFollowers)
+	fmt.Printf("Trusted now:      %d\n", sim.Current)
+	fmt.Printf("Trusted after:    %d (%+d: %d added, %d dropped)\n", sim.Simulated, sim.Simulated-sim.Current, len(sim.Added), len(sim.Dropped))
+	fmt.Printf("Overlap:          %.1f%% of either set, %.1f%% of current trusted kept\n", sim.Overlap(), sim.KeptShare())
+
+	if len(sim.Dropped) == 0 {
+		return
+	}
+
+	dropped := sim.Dropped
+	if *limit > 0 && len(dropped) > *limit {
+		dropped = dropped[:*limit]
+	}
+	pubkeys := make([]string, len(dropped))
+	for i, d := range dropped {
+		pubkeys[i] = d.Pubkey
+	}
+	names, _ := store.GetProfileNames(ctx, pubkeys)
+
+	fmt.Printf("\nDropped (%d of %d, fewest trusted followers first):\n", len(dropped), len(sim.Dropped))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PUBKEY\tNAME\tTRUSTED FOLLOWERS")
+	for _, d := range dropped {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", d.Pubkey, names[d.Pubkey], d.TrustedFollowers)
+	}
+	w.Flush()
+}